@@ -139,6 +139,12 @@ func InitDatabase(rdb *redis.Client, cfg ClusterConfig) *gorm.DB {
 		}
 	})
 
+	// Scope business owned models to the tenant in the statement context
+	if err := RegisterTenantCallbacks(DBConn); err != nil {
+		fmt.Println(err)
+		panic("failed to register tenant callbacks")
+	}
+
 	return DBConn
 }
 
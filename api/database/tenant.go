@@ -0,0 +1,205 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// ErrCrossTenant is returned when a statement scoped to one business
+// tries to read or write a row owned by another business
+var ErrCrossTenant = errors.New("cross-tenant access denied")
+
+type tenantKey struct{}
+
+// WithTenant returns a context that scopes every query run with it to the given business
+func WithTenant(ctx context.Context, businessId uint) context.Context {
+	return context.WithValue(ctx, tenantKey{}, businessId)
+}
+
+// TenantFromContext returns the business ID a context is scoped to
+func TenantFromContext(ctx context.Context) (uint, bool) {
+	if ctx == nil {
+		return 0, false
+	}
+	id, ok := ctx.Value(tenantKey{}).(uint)
+	return id, ok && id != 0
+}
+
+// ScopeTenant returns a session of db that only sees rows of the given business
+func ScopeTenant(db *gorm.DB, businessId uint) *gorm.DB {
+	return db.WithContext(WithTenant(db.Statement.Context, businessId))
+}
+
+// WithoutTenant returns a session of db that is not scoped to a tenant, for lookups across businesses
+func WithoutTenant(db *gorm.DB) *gorm.DB {
+	return db.WithContext(context.Background())
+}
+
+// RegisterTenantCallbacks adds callbacks that inject a business_id filter into
+// queries, updates and deletes of tenant owned models (any model with a BusinessId
+// field) when the statement context carries a tenant. Creates get the tenant
+// business ID filled in, and writes to rows owned by another business fail with
+// ErrCrossTenant. Raw SQL (db.Raw / db.Exec) is not scoped.
+func RegisterTenantCallbacks(db *gorm.DB) error {
+
+	if err := db.Callback().Query().Before("gorm:query").Register("tenant:query", tenantFilter); err != nil {
+		return err
+	}
+
+	if err := db.Callback().Update().Before("gorm:update").Register("tenant:update", tenantWrite); err != nil {
+		return err
+	}
+
+	if err := db.Callback().Delete().Before("gorm:delete").Register("tenant:delete", tenantWrite); err != nil {
+		return err
+	}
+
+	return db.Callback().Create().Before("gorm:create").Register("tenant:create", tenantCreate)
+}
+
+// tenantField returns the tenant ID and the BusinessId field of the statement model
+func tenantField(db *gorm.DB) (uint, *schema.Field, bool) {
+	tenantId, ok := TenantFromContext(db.Statement.Context)
+	if !ok || db.Statement.Schema == nil {
+		return 0, nil, false
+	}
+
+	field := db.Statement.Schema.LookUpField("BusinessId")
+	if field == nil || field.DBName == "" {
+		return 0, nil, false
+	}
+
+	return tenantId, field, true
+}
+
+func tenantFilter(db *gorm.DB) {
+	if db.Error != nil {
+		return
+	}
+
+	tenantId, field, ok := tenantField(db)
+	if !ok {
+		return
+	}
+
+	db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: tenantId},
+	}})
+}
+
+func tenantWrite(db *gorm.DB) {
+	if db.Error != nil {
+		return
+	}
+
+	tenantId, field, ok := tenantField(db)
+	if !ok {
+		return
+	}
+
+	eachRecord(db, func(rv reflect.Value) {
+		if owner, isZero := businessIdOf(db, field, rv); !isZero && owner != uint64(tenantId) {
+			db.AddError(fmt.Errorf("%w: %s belongs to business %d", ErrCrossTenant, db.Statement.Table, owner))
+			return
+		}
+		checkExistingOwner(db, field, rv, tenantId)
+	})
+
+	tenantFilter(db)
+}
+
+func tenantCreate(db *gorm.DB) {
+	if db.Error != nil {
+		return
+	}
+
+	tenantId, field, ok := tenantField(db)
+	if !ok {
+		return
+	}
+
+	eachRecord(db, func(rv reflect.Value) {
+		owner, isZero := businessIdOf(db, field, rv)
+		if isZero {
+			if err := field.Set(db.Statement.Context, rv, tenantId); err != nil {
+				db.AddError(err)
+			}
+		} else if owner != uint64(tenantId) {
+			db.AddError(fmt.Errorf("%w: cannot create %s for business %d", ErrCrossTenant, db.Statement.Table, owner))
+			return
+		}
+
+		// Save falls back to an upsert, make sure it cannot take over another tenant's row
+		checkExistingOwner(db, field, rv, tenantId)
+	})
+}
+
+// eachRecord calls fn for the struct, or each struct in the slice, being written
+func eachRecord(db *gorm.DB, fn func(reflect.Value)) {
+	rv := reflect.Indirect(db.Statement.ReflectValue)
+
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			fn(reflect.Indirect(rv.Index(i)))
+		}
+	case reflect.Struct:
+		fn(rv)
+	}
+}
+
+func businessIdOf(db *gorm.DB, field *schema.Field, rv reflect.Value) (uint64, bool) {
+	if rv.Kind() != reflect.Struct || rv.Type() != db.Statement.Schema.ModelType {
+		return 0, true
+	}
+
+	value, isZero := field.ValueOf(db.Statement.Context, rv)
+	if isZero {
+		return 0, true
+	}
+
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint(), false
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return uint64(v.Int()), false
+	}
+
+	return 0, true
+}
+
+// checkExistingOwner fails the statement if the record's primary key points at a row
+// that already belongs to another business
+func checkExistingOwner(db *gorm.DB, field *schema.Field, rv reflect.Value, tenantId uint) {
+	pf := db.Statement.Schema.PrioritizedPrimaryField
+	if pf == nil || db.DryRun || rv.Kind() != reflect.Struct || rv.Type() != db.Statement.Schema.ModelType {
+		return
+	}
+
+	id, isZero := pf.ValueOf(db.Statement.Context, rv)
+	if isZero {
+		return
+	}
+
+	var owners []uint64
+	query := fmt.Sprintf("select %s from %s where %s = ?",
+		db.Statement.Quote(field.DBName), db.Statement.Quote(db.Statement.Table), db.Statement.Quote(pf.DBName))
+
+	if err := db.Session(&gorm.Session{NewDB: true, Context: context.Background()}).Raw(query, id).Scan(&owners).Error; err != nil {
+		db.AddError(err)
+		return
+	}
+
+	for _, owner := range owners {
+		if owner != uint64(tenantId) {
+			db.AddError(fmt.Errorf("%w: %s %v belongs to business %d", ErrCrossTenant, db.Statement.Table, id, owner))
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+type tenantPart struct {
+	ID         uint
+	BusinessId uint
+	Name       string
+}
+
+type tenantCity struct {
+	ID   uint
+	Name string
+}
+
+func setupTenantDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:                 true,
+		SkipDefaultTransaction: true,
+		DisableAutomaticPing:   true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to open dry run DB: %v", err)
+	}
+
+	if err := RegisterTenantCallbacks(db); err != nil {
+		t.Fatalf("Failed to register tenant callbacks: %v", err)
+	}
+	return db
+}
+
+func TestTenantIsolation(t *testing.T) {
+	db := setupTenantDB(t)
+	ctx := WithTenant(context.Background(), 7)
+
+	t.Run("Queries are scoped to the tenant", func(t *testing.T) {
+		var parts []tenantPart
+		stmt := db.WithContext(ctx).Where("name = ?", "valve").Find(&parts).Statement
+
+		assert.Contains(t, stmt.SQL.String(), `"tenant_parts"."business_id" = $2`)
+		assert.Equal(t, []interface{}{"valve", uint(7)}, stmt.Vars)
+	})
+
+	t.Run("Queries without a tenant are not scoped", func(t *testing.T) {
+		var parts []tenantPart
+		stmt := db.Find(&parts).Statement
+
+		assert.False(t, strings.Contains(stmt.SQL.String(), "business_id"))
+	})
+
+	t.Run("Models without a business are not scoped", func(t *testing.T) {
+		var cities []tenantCity
+		stmt := db.WithContext(ctx).Find(&cities).Statement
+
+		assert.False(t, strings.Contains(stmt.SQL.String(), "business_id"))
+	})
+
+	t.Run("Creates get the tenant business", func(t *testing.T) {
+		part := tenantPart{Name: "valve"}
+		assert.Nil(t, db.WithContext(ctx).Create(&part).Error)
+		assert.Equal(t, uint(7), part.BusinessId)
+	})
+
+	t.Run("Creates for another business fail", func(t *testing.T) {
+		part := tenantPart{Name: "valve", BusinessId: 8}
+		err := db.WithContext(ctx).Create(&part).Error
+		assert.True(t, errors.Is(err, ErrCrossTenant))
+	})
+
+	t.Run("Updates and deletes are scoped to the tenant", func(t *testing.T) {
+		stmt := db.WithContext(ctx).Model(&tenantPart{}).Where("id = ?", 3).Update("name", "pump").Statement
+		assert.Contains(t, stmt.SQL.String(), `"tenant_parts"."business_id" = `)
+
+		stmt = db.WithContext(ctx).Delete(&tenantPart{ID: 3}).Statement
+		assert.Contains(t, stmt.SQL.String(), `"tenant_parts"."business_id" = `)
+	})
+
+	t.Run("Saving a record of another business fails", func(t *testing.T) {
+		err := db.WithContext(ctx).Save(&tenantPart{ID: 3, BusinessId: 8, Name: "pump"}).Error
+		assert.True(t, errors.Is(err, ErrCrossTenant))
+	})
+}
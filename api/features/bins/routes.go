@@ -13,7 +13,7 @@ import (
 // BinApiRoutes routes prefixed with /api/v1/bins
 func BinApiRoutes(app fiber.Router, db *gorm.DB) {
 	// create a new bin
-	app.Post("/", func(c *fiber.Ctx) error {
+	app.Post("/", services.RequireTenant(db, services.TenantFromBody()), func(c *fiber.Ctx) error {
 		if os.Getenv("USE_DOCKER") == "true" {
 			// no-op - allow all requests
 		} else {
@@ -27,7 +27,7 @@ func BinApiRoutes(app fiber.Router, db *gorm.DB) {
 	})
 
 	// update a bin
-	app.Put("/:id", func(c *fiber.Ctx) error {
+	app.Put("/:id", services.RequireTenant(db, services.TenantFromRecord("bins", "id")), func(c *fiber.Ctx) error {
 		if os.Getenv("USE_DOCKER") == "true" {
 			// no-op - allow all requests
 		} else {
@@ -41,7 +41,7 @@ func BinApiRoutes(app fiber.Router, db *gorm.DB) {
 	})
 
 	// delete a bin
-	app.Delete("/:id", func(c *fiber.Ctx) error {
+	app.Delete("/:id", services.RequireTenant(db, services.TenantFromRecord("bins", "id")), func(c *fiber.Ctx) error {
 		if os.Getenv("USE_DOCKER") == "true" {
 			// no-op - allow all requests
 		} else {
@@ -55,7 +55,7 @@ func BinApiRoutes(app fiber.Router, db *gorm.DB) {
 	})
 
 	// search bins with parts
-	app.Post("/search/parts", func(c *fiber.Ctx) error {
+	app.Post("/search/parts", services.RequireTenant(db, services.TenantFromBody()), func(c *fiber.Ctx) error {
 		if os.Getenv("USE_DOCKER") == "true" {
 			// no-op - allow all requests
 		} else {
@@ -69,7 +69,7 @@ func BinApiRoutes(app fiber.Router, db *gorm.DB) {
 	})
 
 	// search bins with consumables
-	app.Post("/search/consumables", func(c *fiber.Ctx) error {
+	app.Post("/search/consumables", services.RequireTenant(db, services.TenantFromBody()), func(c *fiber.Ctx) error {
 		if os.Getenv("USE_DOCKER") == "true" {
 			// no-op - allow all requests
 		} else {
@@ -188,3 +188,93 @@ func TestBinsManagement(t *testing.T) {
 		assert.Empty(t, bins)
 	})
 }
+
+func TestBinsTenantIsolation(t *testing.T) {
+	app, db, err := test.SetupTestApp()
+	if err != nil {
+		t.Fatalf("Failed to setup test app: %v", err)
+	}
+
+	api := app.Group("/api/v1")
+	BinApiRoutes(api.Group("bins"), db)
+
+	ownId, otherId := test.SetupTenants(db)
+
+	otherBin := models.Bin{BusinessId: otherId, Name: "Other tenant bin"}
+	db.Create(&otherBin)
+
+	t.Run("Create bin for another business", func(t *testing.T) {
+		data := map[string]interface{}{"businessId": otherId, "name": "Hijack bin"}
+		test.SignMap(data)
+		jsonData, _ := json.Marshal(data)
+		req := httptest.NewRequest("POST", "/api/v1/bins", bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, fiber.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("Update bin of another business", func(t *testing.T) {
+		data := map[string]interface{}{"id": otherBin.ID, "businessId": ownId, "name": "Hijacked"}
+		test.SignMap(data)
+		jsonData, _ := json.Marshal(data)
+		req := httptest.NewRequest("PUT", "/api/v1/bins/"+strconv.Itoa(int(otherBin.ID)), bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, fiber.StatusForbidden, resp.StatusCode)
+
+		var bin models.Bin
+		db.First(&bin, otherBin.ID)
+		assert.Equal(t, otherId, bin.BusinessId)
+		assert.Equal(t, otherBin.Name, bin.Name)
+	})
+
+	t.Run("Update a bin that does not exist", func(t *testing.T) {
+		data := map[string]interface{}{"id": 999999999, "businessId": otherId, "name": "Upserted"}
+		test.SignMap(data)
+		jsonData, _ := json.Marshal(data)
+		req := httptest.NewRequest("PUT", "/api/v1/bins/999999999", bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+
+		var count int64
+		db.Model(&models.Bin{}).Where("id = 999999999").Count(&count)
+		assert.Equal(t, int64(0), count)
+	})
+
+	t.Run("Delete bin of another business", func(t *testing.T) {
+		data := map[string]interface{}{}
+		test.SignMap(data)
+		jsonData, _ := json.Marshal(data)
+		req := httptest.NewRequest("DELETE", "/api/v1/bins/"+strconv.Itoa(int(otherBin.ID)), bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, fiber.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("Search bins only returns own business", func(t *testing.T) {
+		data := map[string]interface{}{"businessId": ownId}
+		test.SignMap(data)
+		jsonData, _ := json.Marshal(data)
+		req := httptest.NewRequest("POST", "/api/v1/bins/search/parts", bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, resp.StatusCode)
+
+		var result map[string][]models.Bin
+		json.NewDecoder(resp.Body).Decode(&result)
+		for _, bin := range result["result"] {
+			assert.Equal(t, ownId, bin.BusinessId)
+		}
+	})
+}
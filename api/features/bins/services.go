@@ -4,6 +4,7 @@ import (
 	"strconv"
 
 	"myproject/api/models"
+	"myproject/api/services"
 	"myproject/api/utils"
 
 	"github.com/gofiber/fiber/v2"
@@ -17,6 +18,7 @@ type FiltersBin struct {
 
 // CreateBin Register a bin in the bin table.
 func CreateBin(c *fiber.Ctx, db *gorm.DB) error {
+	db = services.TenantDB(db, c)
 	var bin models.Bin
 	if err := c.BodyParser(&bin); err != nil {
 		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
@@ -31,6 +33,7 @@ func CreateBin(c *fiber.Ctx, db *gorm.DB) error {
 
 // UpdateBin Update a bin in the bin table.
 func UpdateBin(c *fiber.Ctx, db *gorm.DB) error {
+	db = services.TenantDB(db, c)
 	id := c.Params("id")
 	var bin models.Bin
 	if err := c.BodyParser(&bin); err != nil {
@@ -58,6 +61,7 @@ func UpdateBin(c *fiber.Ctx, db *gorm.DB) error {
 
 // DeleteBin Remove a bin of the Bine table; validate its existence beforehand.
 func DeleteBin(c *fiber.Ctx, db *gorm.DB) error {
+	db = services.TenantDB(db, c)
 	id := c.Params("id")
 
 	var bin models.Bin
@@ -69,11 +73,13 @@ func DeleteBin(c *fiber.Ctx, db *gorm.DB) error {
 	if err := db.Delete(&bin).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
 	}
+	services.AuditDeletion(db, "bin", bin.ID, bin.BusinessId, services.TenantUserID(c), "delete")
 	return utils.SendJsonResult(c, bin)
 }
 
 // GetPartsListFromBins list of a bin. This can be filtered by businessId or searchTerm in brand or name part.
 func GetPartsListFromBins(c *fiber.Ctx, db *gorm.DB) error {
+	db = services.TenantDB(db, c)
 	var filter FiltersBin
 	if err := c.BodyParser(&filter); err != nil {
 		c.Status(fiber.StatusInternalServerError).SendString(err.Error())
@@ -83,7 +89,7 @@ func GetPartsListFromBins(c *fiber.Ctx, db *gorm.DB) error {
 	query := db.Preload("Part")
 	if filter.SearchTerm != "" {
 		search := "%" + filter.SearchTerm + "%"
-		query.Where("part_id in (select id from parts where business_id = ? or (brand ilike ? or name ilike ?))", filter.BusinessId, search, search)
+		query.Where("part_id in (select id from parts where business_id = ? and (brand ilike ? or name ilike ?))", filter.BusinessId, search, search)
 	} else {
 		query.Where("part_id in (select id from parts where business_id = ?)", filter.BusinessId)
 	}
@@ -116,6 +122,7 @@ func GetPartsListFromBins(c *fiber.Ctx, db *gorm.DB) error {
 
 // GetConsumablesListFromBins list of a bin. This can be filtered by businessId or searchTerm in brand or name consumable.
 func GetConsumablesListFromBins(c *fiber.Ctx, db *gorm.DB) error {
+	db = services.TenantDB(db, c)
 	var filter FiltersBin
 	if err := c.BodyParser(&filter); err != nil {
 		c.Status(fiber.StatusInternalServerError).SendString(err.Error())
@@ -125,7 +132,7 @@ func GetConsumablesListFromBins(c *fiber.Ctx, db *gorm.DB) error {
 	query := db.Preload("Consumable")
 	if filter.SearchTerm != "" {
 		search := "%" + filter.SearchTerm + "%"
-		query.Where("consumable_id in (select id from consumables where business_id = ? or (brand ilike ? or name ilike ?))", filter.BusinessId, search, search)
+		query.Where("consumable_id in (select id from consumables where business_id = ? and (brand ilike ? or name ilike ?))", filter.BusinessId, search, search)
 	} else {
 		query.Where("consumable_id in (select id from consumables where business_id = ?)", filter.BusinessId)
 	}
@@ -1,10 +1,13 @@
 package business
 
 import (
+	"errors"
 	"fmt"
 	"myproject/api/models"
 	"myproject/api/services"
 	"myproject/api/utils"
+	"strconv"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"gorm.io/gorm"
@@ -15,6 +18,45 @@ func CustomerBusinessRoutes(db *gorm.DB, app fiber.Router) {
 
 }
 
+// providerFilterFromQuery read the marketplace listing filters from the query params
+// ?lat=&lng= for providers covering the point, ?at= (RFC3339 or now) for providers open at the time,
+// ?minRating= for the minimum average rating and ?sort=rating for the best rated first
+func providerFilterFromQuery(c *fiber.Ctx) (ProviderFilter, error) {
+	var filter ProviderFilter
+	var err error
+
+	if c.Query("lat") != "" && c.Query("lng") != "" {
+		if filter.Lat, err = strconv.ParseFloat(c.Query("lat"), 64); err != nil {
+			return filter, errors.New("invalid lat")
+		}
+		if filter.Lng, err = strconv.ParseFloat(c.Query("lng"), 64); err != nil {
+			return filter, errors.New("invalid lng")
+		}
+		filter.HasPos = true
+	}
+
+	if at := c.Query("at"); at == "now" {
+		now := time.Now()
+		filter.OpenAt = &now
+	} else if at != "" {
+		openAt, err := time.Parse(time.RFC3339, at)
+		if err != nil {
+			return filter, errors.New("invalid at, use RFC3339")
+		}
+		filter.OpenAt = &openAt
+	}
+
+	if minRating := c.Query("minRating"); minRating != "" {
+		if filter.MinRating, err = strconv.ParseFloat(minRating, 64); err != nil {
+			return filter, errors.New("invalid minRating")
+		}
+	}
+
+	filter.SortByRating = c.Query("sort") == "rating"
+
+	return filter, nil
+}
+
 // routes prefixed with /api/v1/business
 func BusinessApiRoutes(app fiber.Router, db *gorm.DB) {
 
@@ -40,14 +82,36 @@ func BusinessApiRoutes(app fiber.Router, db *gorm.DB) {
 
 		return utils.SendJsonResult(c, business)
 	})
+	// get the bank details and IDs of a business unmasked, for the owner, admins
+	// and team members with the Sensitive permission
+	app.Get("/:id/sensitive", services.RequireTenant(db, services.TenantFromParam("id")), func(c *fiber.Ctx) error {
+		var user models.User
+		if err := services.UserForId(db, services.TenantUserID(c), &user); err != nil {
+			return c.Status(fiber.StatusUnauthorized).SendString("unauthorized")
+		}
+
+		var business models.Business
+		if err := services.TenantDB(db, c).First(&business, c.Params("id")).Error; err != nil {
+			c.Status(fiber.StatusNotAcceptable)
+			return utils.SendJsonResult(c, fiber.Map{"error": "No Business found with given ID"})
+		}
+
+		if !services.CanRevealSensitive(db, user, business.ID) {
+			c.Status(fiber.StatusForbidden)
+			return utils.SendJsonResult(c, fiber.Map{"error": "You do not have permission to see these details"})
+		}
+
+		return utils.SendJsonResult(c, business.SensitiveFields())
+	})
+
 	// getBusinessTeam - get roles for a business
-	app.Get("/:id/roles", func(c *fiber.Ctx) error {
+	app.Get("/:id/roles", services.RequireTenant(db, services.TenantFromParam("id")), func(c *fiber.Ctx) error {
 		id := c.Params("id")
 
 		var roles []models.BusinessRole
 		var err error
 
-		if roles, err = GetBusinessRoles(id, db); err != nil {
+		if roles, err = GetBusinessRoles(id, services.TenantDB(db, c)); err != nil {
 			fmt.Println(err)
 			c.Status(404).SendString(err.Error())
 			return err
@@ -56,14 +120,14 @@ func BusinessApiRoutes(app fiber.Router, db *gorm.DB) {
 		return utils.SendJsonResult(c, roles)
 	})
 	// getLocationTeam - get roles for a business location
-	app.Get("/:id/roles/location/:locationId", func(c *fiber.Ctx) error {
+	app.Get("/:id/roles/location/:locationId", services.RequireTenant(db, services.TenantFromParam("id")), func(c *fiber.Ctx) error {
 		id := c.Params("id")
 		locationId := c.Params("locationId")
 
 		var roles []models.BusinessRole
 		var err error
 
-		if roles, err = GetBusinessLocationRoles(id, locationId, db); err != nil {
+		if roles, err = GetBusinessLocationRoles(id, locationId, services.TenantDB(db, c)); err != nil {
 			fmt.Println(err)
 			c.Status(404).SendString(err.Error())
 			return err
@@ -72,7 +136,7 @@ func BusinessApiRoutes(app fiber.Router, db *gorm.DB) {
 		return utils.SendJsonResult(c, roles)
 	})
 	// get a service providers customers and their equipment
-	app.Post("/:id/customers", func(c *fiber.Ctx) error {
+	app.Post("/:id/customers", services.RequireTenant(db, services.TenantFromParam("id")), func(c *fiber.Ctx) error {
 		id := c.Params("id")
 
 		return GetBusinessCustomers(c, id, db)
@@ -80,7 +144,7 @@ func BusinessApiRoutes(app fiber.Router, db *gorm.DB) {
 
 	// get a service providers customers and their equipment with a location
 	// within a radius of a point
-	app.Post("/:providerId/customers/within/:lat/:lng/:radius", func(c *fiber.Ctx) error {
+	app.Post("/:providerId/customers/within/:lat/:lng/:radius", services.RequireTenant(db, services.TenantFromParam("providerId")), func(c *fiber.Ctx) error {
 		providerId := c.Params("providerId")
 
 		return GetBusinessCustomersWithinRadius(c, providerId, db)
@@ -88,13 +152,13 @@ func BusinessApiRoutes(app fiber.Router, db *gorm.DB) {
 
 	////////////////  CONFIG	//////////////////////
 	// get the config items for a business
-	app.Get("/:id/config", func(c *fiber.Ctx) error {
+	app.Get("/:id/config", services.RequireTenantRead(db, services.TenantFromParam("id")), func(c *fiber.Ctx) error {
 		id := c.Params("id")
 
 		var configs []models.Config
 		var err error
 
-		if configs, err = GetBusinessConfig(id, db); err != nil {
+		if configs, err = GetBusinessConfig(id, services.TenantDB(db, c)); err != nil {
 			fmt.Println(err)
 			c.Status(404).SendString(err.Error())
 			return err
@@ -103,27 +167,49 @@ func BusinessApiRoutes(app fiber.Router, db *gorm.DB) {
 		return utils.SendJsonResult(c, configs)
 	})
 
+	// get the config of a business resolved against the registry defaults,
+	// with ?locationId= the location values override the business values
+	app.Get("/:id/config/effective", services.RequireTenantRead(db, services.TenantFromParam("id")), func(c *fiber.Ctx) error {
+		id, err := strconv.Atoi(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString("invalid business ID")
+		}
+
+		locationId := utils.QueryInt(c, "locationId", 0)
+
+		tdb := services.TenantDB(db, c)
+		if locationId > 0 {
+			var location models.Location
+			if result := tdb.Select("id").First(&location, "id = ? and business_id = ?", locationId, id); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+				c.Status(fiber.StatusNotAcceptable)
+				return utils.SendJsonResult(c, fiber.Map{"error": "No Location found with given ID"})
+			}
+		}
+
+		return utils.SendJsonResult(c, services.BusinessSettings(tdb, uint(id), uint(locationId)).List())
+	})
+
 	// create a new config item for a business
-	app.Post("/:id/config", func(c *fiber.Ctx) error {
+	app.Post("/:id/config", services.RequireTenant(db, services.TenantFromParam("id")), func(c *fiber.Ctx) error {
 		id := c.Params("id")
 
-		return CreateBusinessConfig(c, id, db)
+		return CreateBusinessConfig(c, id, services.TenantDB(db, c))
 	})
 
 	// update a config item for a business
-	app.Put("/:id/config/:cfgId", func(c *fiber.Ctx) error {
+	app.Put("/:id/config/:cfgId", services.RequireTenant(db, services.TenantFromParam("id")), func(c *fiber.Ctx) error {
 		id := c.Params("id")
 		cfgId := c.Params("cfgId")
 
-		return UpdateBusinessConfig(c, id, cfgId, db)
+		return UpdateBusinessConfig(c, id, cfgId, services.TenantDB(db, c))
 	})
 
 	// delete a config item for a business
-	app.Delete("/:id/config/:cfgId", func(c *fiber.Ctx) error {
+	app.Delete("/:id/config/:cfgId", services.RequireTenant(db, services.TenantFromParam("id")), func(c *fiber.Ctx) error {
 		id := c.Params("id")
 		cfgId := c.Params("cfgId")
 
-		return DeleteBusinessConfig(c, id, cfgId, db)
+		return DeleteBusinessConfig(c, id, cfgId, services.TenantDB(db, c))
 	})
 
 	// get the businesses for a user ID
@@ -187,7 +273,12 @@ func BusinessApiRoutes(app fiber.Router, db *gorm.DB) {
 			return err
 		}
 
-		if businesses, err = ListBusinessesInCity(city, province, country, db); err != nil {
+		filter, err := providerFilterFromQuery(c)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+
+		if businesses, err = ListBusinessesInCity(city, province, country, filter, db); err != nil {
 			return err
 		}
 
@@ -219,7 +310,12 @@ func BusinessApiRoutes(app fiber.Router, db *gorm.DB) {
 			return err
 		}
 
-		if businesses, err = ListServiceProvidersForCategory(category, city, province, country, db); err != nil {
+		filter, err := providerFilterFromQuery(c)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+
+		if businesses, err = ListServiceProvidersForCategory(category, city, province, country, filter, db); err != nil {
 			return err
 		}
 
@@ -260,25 +356,30 @@ func BusinessApiRoutes(app fiber.Router, db *gorm.DB) {
 	})
 
 	// create a new business user role
-	app.Post("/role", func(c *fiber.Ctx) error {
-		return CreateBusinessRole(c, db)
+	app.Post("/role", services.RequireTenant(db, services.TenantFromBody()), func(c *fiber.Ctx) error {
+		return CreateBusinessRole(c, services.TenantDB(db, c))
 	})
-	app.Delete("/:id/role/:roleId", func(c *fiber.Ctx) error {
+	app.Delete("/:id/role/:roleId", services.RequireTenant(db, services.TenantFromParam("id")), func(c *fiber.Ctx) error {
 		id := c.Params("id")
 		roleId := c.Params("roleId")
-		return DeleteBusinessRole(c, db, id, roleId)
+		return DeleteBusinessRole(c, services.TenantDB(db, c), id, roleId)
+	})
+
+	// require the team to log in with a second factor, {"required": true}
+	app.Post("/:id/two_factor", func(c *fiber.Ctx) error {
+		return SetBusinessTwoFactor(c, c.Params("id"), db)
 	})
 
 	app.Post("/businesses_for_qrcode", func(c *fiber.Ctx) error {
 		return GetBusinessesForQRcode(c, db)
 	})
 
-	app.Post("/:id/add_category", func(c *fiber.Ctx) error {
-		return AddBusinessCategory(c, db)
+	app.Post("/:id/add_category", services.RequireTenant(db, services.TenantFromParam("id")), func(c *fiber.Ctx) error {
+		return AddBusinessCategory(c, services.TenantDB(db, c))
 	})
 
-	app.Delete("/:id/remove_category", func(c *fiber.Ctx) error {
-		return RemoveBusinessCategory(c, db)
+	app.Delete("/:id/remove_category", services.RequireTenant(db, services.TenantFromParam("id")), func(c *fiber.Ctx) error {
+		return RemoveBusinessCategory(c, services.TenantDB(db, c))
 	})
 
 }
@@ -3,6 +3,9 @@ package business
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"myproject/api/models"
+	"myproject/api/services"
 	"myproject/test"
 	"net/http/httptest"
 	"testing"
@@ -253,3 +256,216 @@ func TestRestrictedRoutes(t *testing.T) {
 		assert.NotEqual(t, 200, resp.StatusCode)
 	})
 }
+
+func TestBusinessTenantIsolation(t *testing.T) {
+	app, db, err := test.SetupTestApp()
+	if err != nil {
+		t.Fatalf("Failed to setup test app: %v", err)
+	}
+
+	api := app.Group("/api/v1")
+	BusinessApiRoutes(api.Group("business"), db)
+
+	ownId, otherId := test.SetupTenants(db)
+
+	otherConfig := models.Config{BusinessId: otherId, Name: "tenant_key", Value: "other"}
+	db.Create(&otherConfig)
+
+	t.Run("Create config for another business", func(t *testing.T) {
+		data := map[string]interface{}{"key": "test_key", "value": "test_value"}
+		test.SignMap(data)
+		jsonData, _ := json.Marshal(data)
+		req := httptest.NewRequest("POST", fmt.Sprintf("/api/v1/business/%d/config", otherId), bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, fiber.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("Update config of another business through own business", func(t *testing.T) {
+		data := map[string]interface{}{"name": "tenant_key", "value": "hijacked"}
+		test.SignMap(data)
+		jsonData, _ := json.Marshal(data)
+		req := httptest.NewRequest("PUT", fmt.Sprintf("/api/v1/business/%d/config/%d", ownId, otherConfig.ID), bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+
+		var config models.Config
+		db.First(&config, otherConfig.ID)
+		assert.Equal(t, "other", config.Value)
+		assert.Equal(t, otherId, config.BusinessId)
+	})
+
+	t.Run("Get roles of another business", func(t *testing.T) {
+		data := map[string]interface{}{}
+		test.SignMap(data)
+		jsonData, _ := json.Marshal(data)
+		req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/business/%d/roles", otherId), bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, fiber.StatusForbidden, resp.StatusCode)
+	})
+}
+
+func TestBusinessConfigRegistry(t *testing.T) {
+	app, db, err := test.SetupTestApp()
+	if err != nil {
+		t.Fatalf("Failed to setup test app: %v", err)
+	}
+
+	api := app.Group("/api/v1")
+	BusinessApiRoutes(api.Group("business"), db)
+
+	ownId, _ := test.SetupTenants(db)
+
+	location := models.Location{BusinessId: ownId, Name: "Config location"}
+	db.Create(&location)
+
+	postConfig := func(data map[string]interface{}) int {
+		test.SignMap(data)
+		jsonData, _ := json.Marshal(data)
+		req := httptest.NewRequest("POST", fmt.Sprintf("/api/v1/business/%d/config", ownId), bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+		return resp.StatusCode
+	}
+
+	t.Run("Reject unknown and invalid config", func(t *testing.T) {
+		assert.Equal(t, fiber.StatusNotAcceptable, postConfig(map[string]interface{}{"name": "no_such_config", "value": "1"}))
+		assert.Equal(t, fiber.StatusNotAcceptable, postConfig(map[string]interface{}{"name": "invoice_due_days", "value": "soon"}))
+		assert.Equal(t, fiber.StatusNotAcceptable, postConfig(map[string]interface{}{"name": "notify_channel", "value": "pigeon"}))
+		// a business only key cannot be set for a location
+		assert.Equal(t, fiber.StatusNotAcceptable, postConfig(map[string]interface{}{"name": "invoice_due_days", "value": "10", "locationId": location.ID}))
+	})
+
+	t.Run("Resolve the effective config", func(t *testing.T) {
+		assert.Equal(t, fiber.StatusOK, postConfig(map[string]interface{}{"name": "invoice_due_days", "value": " 45 "}))
+		assert.Equal(t, fiber.StatusOK, postConfig(map[string]interface{}{"name": "accept_reviews", "value": "false"}))
+		assert.Equal(t, fiber.StatusOK, postConfig(map[string]interface{}{"name": "accept_reviews", "value": "true", "locationId": location.ID}))
+
+		data := map[string]interface{}{}
+		test.SignMap(data)
+		jsonData, _ := json.Marshal(data)
+		req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/business/%d/config/effective?locationId=%d", ownId, location.ID), bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+		var effective struct {
+			Result []map[string]interface{} `json:"result"`
+		}
+		json.NewDecoder(resp.Body).Decode(&effective)
+
+		sources := map[string]string{}
+		for _, item := range effective.Result {
+			sources[item["name"].(string)] = item["source"].(string)
+		}
+		assert.Equal(t, "location", sources["accept_reviews"])
+		assert.Equal(t, "business", sources["invoice_due_days"])
+		assert.Equal(t, "default", sources["show_prices"])
+		assert.NotContains(t, sources, "theme")
+	})
+
+	t.Run("Typed getters", func(t *testing.T) {
+		business := services.BusinessSettings(db, ownId, 0)
+		assert.Equal(t, 45, business.Int("invoice_due_days"))
+		assert.False(t, business.Bool("accept_reviews"))
+		assert.Equal(t, 10.0, business.Float("service_radius_km"))
+		assert.Equal(t, "email", business.String("notify_channel"))
+
+		assert.True(t, services.BusinessSettings(db, ownId, location.ID).Bool("accept_reviews"))
+	})
+}
+
+func TestBusinessSensitiveFields(t *testing.T) {
+	app, db, err := test.SetupTestApp()
+	if err != nil {
+		t.Fatalf("Failed to setup test app: %v", err)
+	}
+
+	api := app.Group("/api/v1")
+	BusinessApiRoutes(api.Group("business"), db)
+
+	ownId, otherId := test.SetupTenants(db)
+
+	var business models.Business
+	db.First(&business, ownId)
+	business.AccountNumber = "12345678"
+	db.Save(&business)
+	t.Cleanup(func() {
+		db.Exec("update businesses set account_number = '' where id = ?", ownId)
+	})
+
+	t.Run("Masked in JSON", func(t *testing.T) {
+		data, _ := json.Marshal(business)
+		assert.Contains(t, string(data), `"accountNumber":"****5678"`)
+	})
+
+	t.Run("Revealed to the owner", func(t *testing.T) {
+		data := map[string]interface{}{}
+		test.SignMap(data)
+		jsonData, _ := json.Marshal(data)
+		req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/business/%d/sensitive", ownId), bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+		var result struct {
+			Result map[string]string `json:"result"`
+		}
+		json.NewDecoder(resp.Body).Decode(&result)
+		assert.Equal(t, "12345678", result.Result["accountNumber"])
+	})
+
+	t.Run("Not revealed for another business", func(t *testing.T) {
+		data := map[string]interface{}{}
+		test.SignMap(data)
+		jsonData, _ := json.Marshal(data)
+		req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/business/%d/sensitive", otherId), bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, fiber.StatusForbidden, resp.StatusCode)
+	})
+}
+
+func TestListServiceProvidersForCountry(t *testing.T) {
+	_, db, err := test.SetupTestApp()
+	if err != nil {
+		t.Fatalf("Failed to setup test app: %v", err)
+	}
+
+	ownId, _ := test.SetupTenants(db)
+	db.Exec("update businesses set type = 'Services' where id = ?", ownId)
+	db.Create(&models.BusinessCategory{BusinessId: uint64(ownId), Category: "country test"})
+
+	// the address is stored as given, only the country code is derived
+	location := models.Location{BusinessId: ownId, Name: "Country test", City: "cleveland", Province: "OH", Country: "United States"}
+	db.Create(&location)
+	db.First(&location, location.ID)
+	assert.Equal(t, "cleveland", location.City)
+	assert.Equal(t, "United States", location.Country)
+	assert.Equal(t, "US", location.CountryCode)
+
+	for _, country := range []string{"USA", "United States", "US"} {
+		businesses, err := ListServiceProvidersForCategory("country test", "any_city", "OH", country, ProviderFilter{}, db)
+		assert.Nil(t, err)
+		assert.Len(t, businesses, 1, country)
+	}
+
+	businesses, _ := ListServiceProvidersForCategory("country test", "cleveland", "OH", "UK", ProviderFilter{}, db)
+	assert.Len(t, businesses, 0)
+}
@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"log"
 	"myproject/api/models"
 	"myproject/api/services"
@@ -195,6 +194,11 @@ func CreateBusinessConfig(c *fiber.Ctx, id string, db *gorm.DB) error {
 	configItem.BusinessId = business.ID
 	configItem.UserId = business.UserId
 
+	if err := validateBusinessConfig(configItem, business.ID, db); err != nil {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": err.Error()})
+	}
+
 	db.Create(&configItem)
 	return utils.SendJsonResult(c, configItem)
 }
@@ -223,9 +227,27 @@ func UpdateBusinessConfig(c *fiber.Ctx, id, cfgId string, db *gorm.DB) error {
 		return c.JSON(fiber.Map{"error": "No Business found with given ID"})
 	}
 
+	// the name and location of an existing item are kept when the body leaves them out
+	var existing models.Config
+	if result := db.First(&existing, "id = ? and business_id = ?", cfgId, business.ID); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		c.Status(fiber.StatusNotFound)
+		return utils.SendJsonResult(c, fiber.Map{"error": "No Config found with given ID"})
+	}
+	if configItem.Name == "" {
+		configItem.Name = existing.Name
+	}
+	if configItem.LocationId == 0 {
+		configItem.LocationId = existing.LocationId
+	}
+
 	configItem.BusinessId = business.ID
 	configItem.UserId = business.UserId
 
+	if err := validateBusinessConfig(configItem, business.ID, db); err != nil {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": err.Error()})
+	}
+
 	updates := configItem.ToMap()
 	delete(updates, "id")
 	delete(updates, "CreatedAt")
@@ -237,6 +259,19 @@ func UpdateBusinessConfig(c *fiber.Ctx, id, cfgId string, db *gorm.DB) error {
 	return utils.SendJsonResult(c, configItem)
 }
 
+// validateBusinessConfig check a config item of a business or one of its locations against the registry
+func validateBusinessConfig(configItem *models.Config, businessId uint, db *gorm.DB) error {
+
+	if configItem.LocationId > 0 {
+		var location models.Location
+		if result := db.Select("id").First(&location, "id = ? and business_id = ?", configItem.LocationId, businessId); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return errors.New("No Location found with given ID")
+		}
+	}
+
+	return models.ValidateConfig(configItem)
+}
+
 func DeleteBusinessConfig(c *fiber.Ctx, id, cfgId string, db *gorm.DB) error {
 
 	if _, err := services.VerifyFormSignature(db, c); err != nil {
@@ -296,9 +331,7 @@ func CreateCustomerBusiness(c *fiber.Ctx, db *gorm.DB) error {
 		}
 
 		// normalise the owner location data
-		owner.City = utils.NormalizeAddress(owner.City)
-		owner.Province = utils.NormalizeAddress(owner.Province)
-		owner.Country = utils.NormalizeAddress(owner.Country)
+		owner.NormalizeAddress()
 		db.Create(&owner)
 	}
 
@@ -309,10 +342,13 @@ func CreateCustomerBusiness(c *fiber.Ctx, db *gorm.DB) error {
 		providerId = business.ProviderId
 	}
 
-	// normalise the business location data
-	business.City = utils.NormalizeAddress(business.City)
-	business.Province = utils.NormalizeAddress(business.Province)
-	business.Country = utils.NormalizeAddress(business.Country)
+	// the business location data must be valid in its country
+	address, err := utils.ValidateAddress(utils.Address{City: business.City, Province: business.Province, Postcode: business.Zipcode, Country: business.Country})
+	if err != nil {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": err.Error(), "fields": err})
+	}
+	business.City, business.Province, business.Zipcode, business.Country = address.City, address.Province, address.Postcode, address.Country
 
 	// approve business by default
 	business.Enabled = true
@@ -338,10 +374,7 @@ func CreateCustomerBusiness(c *fiber.Ctx, db *gorm.DB) error {
 
 	rand, _ := uuid.NewRandom()
 
-	var tbiz models.Business
-	result = db.First(&tbiz, "uuid = ?", strings.ToLower(tmpUuid))
-
-	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+	if services.SubdomainAvailable(db, strings.ToLower(tmpUuid), 0) == nil {
 		business.Uuid = strings.ToLower(tmpUuid)
 	} else {
 		// a business exists with this uuid so use a random UUID
@@ -445,10 +478,13 @@ func CreateBusiness(c *fiber.Ctx, db *gorm.DB) error {
 		return err
 	}
 
-	// normalise the business location data
-	business.City = utils.NormalizeAddress(business.City)
-	business.Province = utils.NormalizeAddress(business.Province)
-	business.Country = utils.NormalizeAddress(business.Country)
+	// the business location data must be valid in its country
+	address, err := utils.ValidateAddress(utils.Address{City: business.City, Province: business.Province, Postcode: business.Zipcode, Country: business.Country})
+	if err != nil {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": err.Error(), "fields": err})
+	}
+	business.City, business.Province, business.Zipcode, business.Country = address.City, address.Province, address.Postcode, address.Country
 
 	// approve business by default so when they enable a menu they will be visible
 	business.Enabled = true
@@ -475,10 +511,7 @@ func CreateBusiness(c *fiber.Ctx, db *gorm.DB) error {
 
 	rand, _ := uuid.NewRandom()
 
-	var tbiz models.Business
-	result := db.First(&tbiz, "uuid = ?", tmpUuid)
-
-	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+	if services.SubdomainAvailable(db, strings.ToLower(tmpUuid), 0) == nil {
 		business.Uuid = strings.ToLower(tmpUuid)
 	} else {
 		// a business exists with this uuid so use a random UUID
@@ -519,10 +552,13 @@ func CreateBusiness(c *fiber.Ctx, db *gorm.DB) error {
 		loc.BusinessId = business.ID
 		loc.UserId = business.UserId
 
-		// normalise the location data
-		loc.City = utils.NormalizeAddress(loc.City)
-		loc.Province = utils.NormalizeAddress(loc.Province)
-		loc.Country = utils.NormalizeAddress(loc.Country)
+		// the location data must be valid in its country
+		address, err := utils.ValidateAddress(utils.Address{City: loc.City, Province: loc.Province, Postcode: loc.Zipcode, Country: loc.Country})
+		if err != nil {
+			c.Status(fiber.StatusNotAcceptable)
+			return utils.SendJsonResult(c, fiber.Map{"error": err.Error(), "fields": err})
+		}
+		loc.City, loc.Province, loc.Zipcode, loc.Country = address.City, address.Province, address.Postcode, address.Country
 
 		// use the default location photo
 		loc.Photo = "https://myproject.com/img/placeholder-image.png"
@@ -614,37 +650,70 @@ func GetBusinessesForQRcode(c *fiber.Ctx, db *gorm.DB) error {
 	return utils.SendJsonResult(c, businesses)
 }
 
-func ListBusinessesInCity(city string, province string, country string, db *gorm.DB) ([]models.Business, error) {
+func ListBusinessesInCity(city string, province string, country string, filter ProviderFilter, db *gorm.DB) ([]models.Business, error) {
 
 	var businesses []models.Business
 
-	db.
+	filter.apply(db).
 		Preload("Locations.Areas").Preload("Locations.Equipment.QRcodes").Find(&businesses, "id in (select business_id from locations where city = ? and province = ? and country = ?)", city, province, country)
 
 	return businesses, nil
 }
 
-func ListServiceProvidersForCategory(category string, city string, province string, country string, db *gorm.DB) ([]models.Business, error) {
+// ProviderFilter narrows the marketplace listings to businesses covering a point, open at a time
+// or with a minimum rating, and can sort them by rating
+type ProviderFilter struct {
+	Lat    float64
+	Lng    float64
+	HasPos bool       // filter by service areas covering Lat, Lng
+	OpenAt *time.Time // filter by providers open at this time
 
-	var businesses []models.Business
+	MinRating    float64 // filter by the cached average rating
+	SortByRating bool    // best rated first
+}
 
-	cnt2 := country
+// apply add the rating and service area filters to a query of businesses
+func (f ProviderFilter) apply(db *gorm.DB) *gorm.DB {
+	if f.HasPos {
+		db = db.Scopes(services.CoversPoint(f.Lat, f.Lng))
+	}
+
+	if f.MinRating > 0 {
+		db = db.Where("rating_average >= ?", f.MinRating)
+	}
 
-	if country == "United States" {
-		cnt2 = "USA"
-	} else if country == "USA" {
-		cnt2 = "United States"
+	if f.SortByRating {
+		db = db.Order("rating_average desc, rating_count desc")
 	}
 
-	if city == "any_city" {
-		db.Preload("Locations").Find(&businesses,
-			"id in (select business_id from locations where province = ? and (country = ? or country = ?)) and id in (select business_id from business_categories where lower(category) = lower(?)) and type = 'Services'", province, country, cnt2, category)
+	return db
+}
+
+func ListServiceProvidersForCategory(category string, city string, province string, country string, filter ProviderFilter, db *gorm.DB) ([]models.Business, error) {
+
+	var businesses []models.Business
+
+	// the locations in the country by its code, or by any of its names when saved without one
+	countries := []string{country}
+	rules, _ := utils.CountryRulesFor(country)
+	if rules.Code != "" {
+		countries = append(countries, rules.Names()...)
+	}
+	inCountry := db.Where("country_code = ? and country_code <> ''", rules.Code).Or("country in ?", countries)
 
-		return businesses, nil
+	locations := db.Model(&models.Location{}).Select("business_id").Where("province = ?", province).Where(inCountry)
+	if city != "any_city" {
+		locations = locations.Where("city = ?", city)
 	}
 
-	db.Preload("Locations").Find(&businesses,
-		"id in (select business_id from locations where city = ? and province = ? and (country = ? or country = ?)) and id in (select business_id from business_categories where lower(category) = lower(?)) and type = 'Services'", city, province, country, cnt2, category)
+	filter.apply(db).Preload("Locations").
+		Where("id in (?)", locations).
+		Where("id in (select business_id from business_categories where lower(category) = lower(?)) and type = 'Services'", category).
+		Find(&businesses)
+
+	if filter.OpenAt != nil {
+		businesses = services.FilterOpenAt(db, businesses, *filter.OpenAt)
+	}
 
 	return businesses, nil
 }
@@ -667,21 +736,6 @@ type DistinctLocation struct {
 
 func GetCountryProvinceCities(country string, db *gorm.DB) []DistinctLocation {
 
-	var latlngs map[string]map[string]string
-
-	// read the latlngs for the cities in the country
-	jcf := fmt.Sprintf("./data/cities/%s.json", country)
-	if f, err := os.Open(jcf); err == nil {
-
-		defer f.Close()
-
-		byteValue, _ := io.ReadAll(f)
-
-		json.Unmarshal([]byte(byteValue), &latlngs)
-	} else {
-		fmt.Println(err)
-	}
-
 	// query = "select count(id) as count,city,province from locations where country = ? group by city,province order by count desc"
 	var results []DistinctLocation
 
@@ -689,30 +743,17 @@ func GetCountryProvinceCities(country string, db *gorm.DB) []DistinctLocation {
 
 	for i := 0; i < len(results); i++ {
 
-		latlng := ""
-		// if the predefined latlngs have the data use that, otherwise query the db
-		if _, hasKey := latlngs[results[i].Province]; hasKey {
-			//fmt.Println("has province", results[i].Province)
-			if v, hasCity := latlngs[results[i].Province][results[i].City]; hasCity {
-				latlng = v
-				//fmt.Println("has city", results[i].Province, results[i].City)
-			} else {
-				fmt.Printf("missing city (%s) (%s)", results[i].Province, results[i].City)
-				fmt.Println("data", results[i].Province)
-			}
-		} else {
-			fmt.Printf("missing province (%s)", results[i].Province)
-		}
+		// the reference geography has the city by its name or an alternate name, otherwise take it from the locations
+		var latlng string
+		db.Raw(`select concat(latitude, ',', longitude) from cities
+			where (country = ? or country_code = ?) and (lower(state) = lower(?) or code = ?) and ? = any(search_names)
+			order by population desc limit 1`,
+			country, strings.ToUpper(country), results[i].Province, results[i].Province, services.GeographyKey(results[i].City)).Scan(&latlng)
 
 		if latlng == "" {
-			var latlng DistinctLocation
 			db.Raw("select latlng from locations where city = ? and province = ? and latlng is not null limit 1", results[i].City, results[i].Province).Scan(&latlng)
-			results[i].Latlng = fmt.Sprintf("%s", latlng.Latlng)
-		} else {
-			results[i].Latlng = fmt.Sprintf("%s", latlng)
 		}
-
-		//fmt.Println("latlng", results[i].City, results[i].Latlng)
+		results[i].Latlng = latlng
 	}
 
 	return results
@@ -763,21 +804,27 @@ func RemoveBusinessCategory(c *fiber.Ctx, db *gorm.DB) error {
 
 func GetBusinessCustomersWithinRadius(c *fiber.Ctx, id string, db *gorm.DB) error {
 
-	var locations []models.Location
-	query := fmt.Sprintf("ST_DWithin(locations.location, 'SRID=4326;POINT(%s %s)'::geography, %s)", c.Params("lng"), c.Params("lat"), c.Params("radius"))
-	db.Find(&locations, query)
+	query, err := services.ParseRadiusQuery(c.Params("lat"), c.Params("lng"), c.Params("radius"))
+	if err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return utils.SendJsonResult(c, fiber.Map{"error": err.Error()})
+	}
 
-	ids := make([]uint, len(locations))
-	for i, l := range locations {
-		ids[i] = l.ID
+	// the locations of the customers of the provider
+	hits, err := services.SpatialSearch(db, "locations", query, func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("locations.business_id in (select customer_id from business_customers where business_id = ?)", id)
+	})
+	if err != nil {
+		fmt.Println(err)
+		return err
 	}
 
 	var businesses []models.Business
 
-	if len(ids) > 0 {
+	if len(hits) > 0 {
 
 		err := db.
-			Preload("Locations.Areas").Preload("Locations.Equipment.ServiceRecords").Preload("Locations.Equipment.QRcodes").Find(&businesses, "id in (select business_id from locations where id in ?)", ids).Error
+			Preload("Locations.Areas").Preload("Locations.Equipment.ServiceRecords").Preload("Locations.Equipment.QRcodes").Find(&businesses, "id in (select business_id from locations where id in ?)", services.SpatialIds(hits)).Error
 
 		if err != nil {
 			fmt.Println(err)
@@ -788,3 +835,43 @@ func GetBusinessCustomersWithinRadius(c *fiber.Ctx, id string, db *gorm.DB) erro
 	return utils.SendJsonResult(c, businesses)
 
 }
+
+// SetBusinessTwoFactor require the team of the business to log in with a second factor, only the owners can change it
+func SetBusinessTwoFactor(c *fiber.Ctx, id string, db *gorm.DB) error {
+
+	user, err := services.VerifyFormSignature(db, c)
+	if err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return err
+	}
+
+	req := new(struct {
+		Required bool `json:"required" form:"required"`
+	})
+	if err := c.BodyParser(req); err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return err
+	}
+
+	var business models.Business
+	if result := db.First(&business, id); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": "No Business found with given ID"})
+	}
+
+	var ownerRoles int64
+	db.Model(&models.BusinessRole{}).Where("business_id = ? and role_id = ? and type = 'owner'", business.ID, user.ID).Count(&ownerRoles)
+	if !strings.Contains(user.Roles, "admin") && !services.IsBusinessOwner(db, user.ID, business.ID) && ownerRoles == 0 {
+		c.Status(fiber.StatusUnauthorized)
+		return utils.SendJsonResult(c, fiber.Map{"error": "Only the owners can require two factor authentication"})
+	}
+
+	if err := db.Model(&business).Update("require_two_factor", req.Required).Error; err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	return utils.SendJsonResult(c, fiber.Map{"id": business.ID, "requireTwoFactor": req.Required})
+}
@@ -0,0 +1,184 @@
+package contractor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"myproject/api/models"
+	"myproject/test"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubContractors(t *testing.T) {
+	app, db, err := test.SetupTestApp()
+	if err != nil {
+		t.Fatalf("Failed to setup test app: %v", err)
+	}
+
+	api := app.Group("/api/v1")
+	ContractorApiRoutes(api.Group("contractors"), db)
+
+	ownId, otherId := test.SetupTenants(db)
+
+	location := models.Location{BusinessId: ownId, Name: "Contractor site"}
+	db.Create(&location)
+
+	var sub models.SubContractor
+
+	signedRequest := func(method, url string, data map[string]interface{}) (int, map[string]interface{}) {
+		test.SignMap(data)
+		jsonData, _ := json.Marshal(data)
+		req := httptest.NewRequest(method, url, bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+
+		var result map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+
+	t.Run("Add a business as sub contractor", func(t *testing.T) {
+		status, _ := signedRequest("POST", fmt.Sprintf("/api/v1/contractors/%d", ownId), map[string]interface{}{
+			"kind":         "business",
+			"contractorId": otherId,
+		})
+		assert.Equal(t, 200, status)
+	})
+
+	t.Run("Add a user as sub contractor", func(t *testing.T) {
+		status, _ := signedRequest("POST", fmt.Sprintf("/api/v1/contractors/%d", ownId), map[string]interface{}{
+			"kind":   "user",
+			"userId": 3,
+		})
+		assert.Equal(t, 200, status)
+
+		db.Last(&sub, "business_id = ? and technician_id = 3", ownId)
+		assert.NotZero(t, sub.ID)
+
+		// adding the same user again returns the existing sub contractor
+		signedRequest("POST", fmt.Sprintf("/api/v1/contractors/%d", ownId), map[string]interface{}{
+			"kind":   "user",
+			"userId": 3,
+		})
+		var count int64
+		db.Model(&models.SubContractor{}).Where("business_id = ? and technician_id = 3", ownId).Count(&count)
+		assert.Equal(t, int64(1), count)
+	})
+
+	t.Run("Add the business as its own sub contractor", func(t *testing.T) {
+		status, _ := signedRequest("POST", fmt.Sprintf("/api/v1/contractors/%d", ownId), map[string]interface{}{
+			"kind":         "business",
+			"contractorId": ownId,
+		})
+		assert.Equal(t, fiber.StatusNotAcceptable, status)
+	})
+
+	t.Run("Add a sub contractor to another business", func(t *testing.T) {
+		status, _ := signedRequest("POST", fmt.Sprintf("/api/v1/contractors/%d", otherId), map[string]interface{}{
+			"kind":   "user",
+			"userId": 3,
+		})
+		assert.Equal(t, fiber.StatusForbidden, status)
+	})
+
+	t.Run("List sub contractors", func(t *testing.T) {
+		status, result := signedRequest("POST", fmt.Sprintf("/api/v1/contractors/%d/list", ownId), map[string]interface{}{})
+		assert.Equal(t, 200, status)
+
+		subs, ok := result["result"].([]interface{})
+		assert.True(t, ok)
+		assert.Len(t, subs, 2)
+	})
+
+	t.Run("Assign a location", func(t *testing.T) {
+		status, _ := signedRequest("POST", fmt.Sprintf("/api/v1/contractors/%d/%d/assignments", ownId, sub.ID), map[string]interface{}{
+			"locationId":  location.ID,
+			"description": "Annual service",
+			"service":     "hvac",
+		})
+		assert.Equal(t, 200, status)
+
+		status, result := signedRequest("POST", "/api/v1/contractors/assignments", map[string]interface{}{})
+		assert.Equal(t, 200, status)
+
+		assigned, ok := result["result"].(map[string]interface{})
+		assert.True(t, ok)
+		assert.Len(t, assigned["assignments"], 1)
+	})
+
+	t.Run("Rates and quotes", func(t *testing.T) {
+		status, _ := signedRequest("POST", fmt.Sprintf("/api/v1/contractors/%d/%d/rates", ownId, sub.ID), map[string]interface{}{
+			"rate": 40,
+			"unit": "hour",
+		})
+		assert.Equal(t, 200, status)
+
+		status, _ = signedRequest("POST", fmt.Sprintf("/api/v1/contractors/%d/%d/rates", ownId, sub.ID), map[string]interface{}{
+			"service": "hvac",
+			"rate":    55,
+			"unit":    "hour",
+		})
+		assert.Equal(t, 200, status)
+
+		status, result := signedRequest("POST", fmt.Sprintf("/api/v1/contractors/%d/%d/quote", ownId, sub.ID), map[string]interface{}{
+			"service":  "hvac",
+			"quantity": 2,
+		})
+		assert.Equal(t, 200, status)
+		quote := result["result"].(map[string]interface{})
+		assert.Equal(t, float64(110), quote["total"])
+
+		// no rate for plumbing, falls back to the default rate
+		_, result = signedRequest("POST", fmt.Sprintf("/api/v1/contractors/%d/%d/quote", ownId, sub.ID), map[string]interface{}{
+			"service":  "plumbing",
+			"quantity": 2,
+		})
+		quote = result["result"].(map[string]interface{})
+		assert.Equal(t, float64(80), quote["total"])
+	})
+
+	t.Run("Completed work is costed for invoicing", func(t *testing.T) {
+		var assignment models.SubContractorAssignment
+		db.Last(&assignment, "business_id = ? and sub_contractor_id = ?", ownId, sub.ID)
+
+		status, result := signedRequest("PUT", fmt.Sprintf("/api/v1/contractors/%d/%d/assignments/%d", ownId, sub.ID, assignment.ID), map[string]interface{}{
+			"status":   "completed",
+			"quantity": 3,
+		})
+		assert.Equal(t, 200, status)
+		completed := result["result"].(map[string]interface{})
+		assert.Equal(t, float64(55), completed["rate"])
+		assert.Equal(t, float64(165), completed["total"])
+
+		status, result = signedRequest("POST", fmt.Sprintf("/api/v1/contractors/%d/invoice_lines", ownId), map[string]interface{}{
+			"customerId": ownId,
+		})
+		assert.Equal(t, 200, status)
+		invoice := result["result"].(map[string]interface{})
+		assert.Len(t, invoice["lines"], 1)
+		totals := invoice["totals"].(map[string]interface{})
+		total := 0.0
+		for _, value := range totals {
+			total += value.(float64)
+		}
+		assert.Equal(t, float64(165), total)
+
+		status, _ = signedRequest("POST", fmt.Sprintf("/api/v1/contractors/%d/invoice_lines", otherId), map[string]interface{}{})
+		assert.Equal(t, fiber.StatusForbidden, status)
+	})
+
+	t.Run("Remove sub contractor", func(t *testing.T) {
+		status, _ := signedRequest("DELETE", fmt.Sprintf("/api/v1/contractors/%d/%d", ownId, sub.ID), map[string]interface{}{})
+		assert.Equal(t, 200, status)
+
+		var count int64
+		db.Model(&models.SubContractorRate{}).Where("sub_contractor_id = ?", sub.ID).Count(&count)
+		assert.Equal(t, int64(0), count)
+	})
+}
@@ -0,0 +1,92 @@
+package contractor
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"myproject/api/services"
+)
+
+func businessIdParam(c *fiber.Ctx) uint {
+	id, _ := strconv.ParseUint(c.Params("bizid"), 10, 64)
+	return uint(id)
+}
+
+// routes prefixed with /api/v1/contractors
+func ContractorApiRoutes(app fiber.Router, db *gorm.DB) {
+
+	// the work, locations and equipment assigned to the current user as a sub contractor
+	app.Post("/assignments", func(c *fiber.Ctx) error {
+		user, err := services.VerifyFormSignature(db, c)
+		if err != nil {
+			fmt.Println(err)
+			return c.Status(503).SendString(err.Error())
+		}
+		return GetMyAssignments(c, db, user)
+	})
+
+	tenant := services.RequireTenant(db, services.TenantFromParam("bizid"))
+
+	// add a user or a business as a sub contractor
+	app.Post("/:bizid", tenant, func(c *fiber.Ctx) error {
+		return AddSubContractor(c, services.TenantDB(db, c), businessIdParam(c))
+	})
+
+	// list the sub contractors of a business
+	app.Get("/:bizid", tenant, func(c *fiber.Ctx) error {
+		return GetSubContractors(c, services.TenantDB(db, c), businessIdParam(c))
+	})
+	app.Post("/:bizid/list", tenant, func(c *fiber.Ctx) error {
+		return GetSubContractors(c, services.TenantDB(db, c), businessIdParam(c))
+	})
+
+	// remove a sub contractor
+	app.Delete("/:bizid/:id", tenant, func(c *fiber.Ctx) error {
+		return RemoveSubContractor(c, services.TenantDB(db, c), businessIdParam(c))
+	})
+
+	////////////////  ASSIGNMENTS	//////////////////////
+	// assign a location or a piece of equipment to a sub contractor
+	app.Post("/:bizid/:id/assignments", tenant, func(c *fiber.Ctx) error {
+		return CreateAssignment(c, services.TenantDB(db, c), businessIdParam(c))
+	})
+
+	// complete or cancel an assignment
+	app.Put("/:bizid/:id/assignments/:assignmentId", tenant, func(c *fiber.Ctx) error {
+		return UpdateAssignmentStatus(c, services.TenantDB(db, c), businessIdParam(c))
+	})
+
+	app.Delete("/:bizid/:id/assignments/:assignmentId", tenant, func(c *fiber.Ctx) error {
+		return DeleteAssignment(c, services.TenantDB(db, c), businessIdParam(c))
+	})
+
+	////////////////  RATES	//////////////////////
+	app.Get("/:bizid/:id/rates", tenant, func(c *fiber.Ctx) error {
+		return GetRates(c, services.TenantDB(db, c), businessIdParam(c))
+	})
+
+	app.Post("/:bizid/:id/rates", tenant, func(c *fiber.Ctx) error {
+		return CreateRate(c, services.TenantDB(db, c), businessIdParam(c))
+	})
+
+	app.Put("/:bizid/:id/rates/:rateId", tenant, func(c *fiber.Ctx) error {
+		return UpdateRate(c, services.TenantDB(db, c), businessIdParam(c))
+	})
+
+	app.Delete("/:bizid/:id/rates/:rateId", tenant, func(c *fiber.Ctx) error {
+		return DeleteRate(c, services.TenantDB(db, c), businessIdParam(c))
+	})
+
+	// the completed work of the sub contractors, costed with their rates for invoicing
+	app.Post("/:bizid/invoice_lines", tenant, func(c *fiber.Ctx) error {
+		return GetInvoiceLines(c, services.TenantDB(db, c), businessIdParam(c))
+	})
+
+	// cost work done by a sub contractor using its rates
+	app.Post("/:bizid/:id/quote", tenant, func(c *fiber.Ctx) error {
+		return QuoteWork(c, services.TenantDB(db, c), businessIdParam(c))
+	})
+}
@@ -0,0 +1,559 @@
+package contractor
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"myproject/api/database"
+	"myproject/api/models"
+	"myproject/api/services"
+	"myproject/api/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+var rateUnits = []string{"hour", "day", "visit", "item"}
+
+// managerForBusiness verify the request is signed by the owner or an admin of the business
+func managerForBusiness(c *fiber.Ctx, db *gorm.DB, businessId uint) (models.User, error) {
+
+	user, err := services.VerifyFormSignature(db, c)
+	if err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return user, err
+	}
+
+	if !services.CanManageBusiness(db, user, businessId) {
+		c.Status(fiber.StatusForbidden)
+		utils.SendJsonResult(c, fiber.Map{"error": "Only the owner or an admin can manage sub contractors"})
+		return user, fiber.ErrForbidden
+	}
+
+	return user, nil
+}
+
+// subContractorForBusiness load the sub contractor in the :id param
+func subContractorForBusiness(c *fiber.Ctx, db *gorm.DB, businessId uint) (*models.SubContractor, error) {
+
+	var sub models.SubContractor
+	result := db.First(&sub, "business_id = ? and id = ?", businessId, c.Params("id"))
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		c.Status(fiber.StatusNotAcceptable)
+		utils.SendJsonResult(c, fiber.Map{"error": "No SubContractor found with given ID"})
+		return nil, result.Error
+	}
+
+	return &sub, nil
+}
+
+// AddSubContractor add a user or a business as a sub contractor of a business
+func AddSubContractor(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	if _, err := managerForBusiness(c, db, businessId); err != nil {
+		return nil
+	}
+
+	sub := new(models.SubContractor)
+	if err := c.BodyParser(sub); err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return err
+	}
+
+	sub.ID = 0
+	sub.BusinessId = businessId
+
+	switch sub.Kind {
+	case "user":
+		sub.ContractorId = 0
+
+		var user models.User
+		if result := db.First(&user, sub.TechnicianId); errors.Is(result.Error, gorm.ErrRecordNotFound) || sub.TechnicianId == 0 {
+			c.Status(fiber.StatusNotAcceptable)
+			return utils.SendJsonResult(c, fiber.Map{"error": "No User found with given ID"})
+		}
+	case "business":
+		sub.TechnicianId = 0
+
+		var business models.Business
+		if result := db.First(&business, sub.ContractorId); errors.Is(result.Error, gorm.ErrRecordNotFound) || sub.ContractorId == 0 {
+			c.Status(fiber.StatusNotAcceptable)
+			return utils.SendJsonResult(c, fiber.Map{"error": "No Business found with given ID"})
+		}
+		if sub.ContractorId == businessId {
+			c.Status(fiber.StatusNotAcceptable)
+			return utils.SendJsonResult(c, fiber.Map{"error": "A business cannot be its own sub contractor"})
+		}
+	default:
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": "kind must be user or business"})
+	}
+
+	var existing models.SubContractor
+	db.First(&existing, "business_id = ? and technician_id = ? and contractor_id = ?", businessId, sub.TechnicianId, sub.ContractorId)
+	if existing.ID > 0 {
+		return utils.SendJsonResult(c, existing)
+	}
+
+	if err := db.Create(sub).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return utils.SendJsonResult(c, sub)
+}
+
+// GetSubContractors list the sub contractors of a business with their rates
+func GetSubContractors(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	var subs []models.SubContractor
+	db.Preload("User").Preload("Contractor").Preload("Rates").
+		Preload("Assignments", "status = 'active'").
+		Find(&subs, "business_id = ?", businessId)
+
+	return utils.SendJsonResult(c, subs)
+}
+
+// RemoveSubContractor remove a sub contractor with its assignments and rates
+func RemoveSubContractor(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	if _, err := managerForBusiness(c, db, businessId); err != nil {
+		return nil
+	}
+
+	sub, err := subContractorForBusiness(c, db, businessId)
+	if err != nil {
+		return nil
+	}
+
+	if err := db.Delete(sub).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return utils.SendJsonResult(c, sub)
+}
+
+// CreateAssignment assign a location, or a piece of equipment, of the business or of one of its customers
+func CreateAssignment(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	if _, err := managerForBusiness(c, db, businessId); err != nil {
+		return nil
+	}
+
+	sub, err := subContractorForBusiness(c, db, businessId)
+	if err != nil {
+		return nil
+	}
+
+	assignment := new(models.SubContractorAssignment)
+	if err := c.BodyParser(assignment); err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return err
+	}
+
+	// locations and equipment belong to the customers, look them up across businesses
+	lookup := database.WithoutTenant(db)
+
+	if assignment.EquipmentId > 0 {
+		var locationIds []uint
+		lookup.Raw("select location_id from equipment where id = ?", assignment.EquipmentId).Scan(&locationIds)
+		if len(locationIds) == 0 {
+			c.Status(fiber.StatusNotAcceptable)
+			return utils.SendJsonResult(c, fiber.Map{"error": "No Equipment found with given ID"})
+		}
+		assignment.LocationId = locationIds[0]
+	}
+
+	var location models.Location
+	if result := lookup.First(&location, assignment.LocationId); errors.Is(result.Error, gorm.ErrRecordNotFound) || assignment.LocationId == 0 {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": "No Location found with given ID"})
+	}
+
+	if location.BusinessId != businessId {
+		var count int64
+		lookup.Model(&models.BusinessCustomer{}).Where("business_id = ? and customer_id = ?", businessId, location.BusinessId).Count(&count)
+		if count == 0 {
+			c.Status(fiber.StatusForbidden)
+			return utils.SendJsonResult(c, fiber.Map{"error": "The location does not belong to a customer of the business"})
+		}
+	}
+
+	assignment.ID = 0
+	assignment.BusinessId = businessId
+	assignment.SubContractorId = sub.ID
+	assignment.CustomerId = location.BusinessId
+	if assignment.Status == "" {
+		assignment.Status = "active"
+	}
+
+	if err := db.Create(assignment).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return utils.SendJsonResult(c, assignment)
+}
+
+// UpdateAssignmentStatus complete or cancel the work assigned to a sub contractor
+func UpdateAssignmentStatus(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	if _, err := managerForBusiness(c, db, businessId); err != nil {
+		return nil
+	}
+
+	type StatusRequest struct {
+		Status   string  `json:"status"`
+		Quantity float64 `json:"quantity"` // units of work done, 1 by default
+	}
+
+	req := new(StatusRequest)
+	if err := c.BodyParser(req); err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return err
+	}
+
+	if !slices.Contains([]string{"active", "completed", "cancelled"}, req.Status) {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": "status must be active, completed or cancelled"})
+	}
+
+	var assignment models.SubContractorAssignment
+	result := db.First(&assignment, "business_id = ? and sub_contractor_id = ? and id = ?", businessId, c.Params("id"), c.Params("assignmentId"))
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": "No Assignment found with given ID"})
+	}
+
+	assignment.Status = req.Status
+	if req.Status == "completed" {
+		if req.Quantity < 0 {
+			c.Status(fiber.StatusNotAcceptable)
+			return utils.SendJsonResult(c, fiber.Map{"error": "quantity cannot be negative"})
+		}
+		costAssignment(db, &assignment, req.Quantity, time.Now())
+	} else {
+		assignment.CompletedAt = nil
+		assignment.RateId, assignment.Unit, assignment.Rate, assignment.Currency, assignment.Total = 0, "", 0, "", 0
+	}
+	db.Save(&assignment)
+
+	return utils.SendJsonResult(c, assignment)
+}
+
+// costAssignment price completed work with the rate of the sub contractor for its service at the time,
+// the work stays uncosted when no rate applies
+func costAssignment(db *gorm.DB, assignment *models.SubContractorAssignment, quantity float64, completedAt time.Time) {
+	if quantity == 0 {
+		quantity = 1
+	}
+	assignment.CompletedAt = &completedAt
+	assignment.Quantity = quantity
+
+	rate, err := RateFor(db, assignment.SubContractorId, assignment.Service, completedAt)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	assignment.RateId = rate.ID
+	assignment.Unit = rate.Unit
+	assignment.Rate = rate.Rate
+	assignment.Currency = rate.Currency
+	assignment.Total = rate.Rate * quantity
+}
+
+// GetInvoiceLines the completed work of the sub contractors of a business, optionally for one customer
+// and a period, costed with their rates and totalled by currency
+func GetInvoiceLines(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	if _, err := managerForBusiness(c, db, businessId); err != nil {
+		return nil
+	}
+
+	type InvoiceRequest struct {
+		CustomerId      uint       `json:"customerId"`
+		SubContractorId uint       `json:"subContractorId"`
+		From            *time.Time `json:"from"`
+		To              *time.Time `json:"to"`
+	}
+
+	req := new(InvoiceRequest)
+	if err := c.BodyParser(req); err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return err
+	}
+
+	query := db.Where("business_id = ? and status = 'completed'", businessId)
+	if req.CustomerId > 0 {
+		query = query.Where("customer_id = ?", req.CustomerId)
+	}
+	if req.SubContractorId > 0 {
+		query = query.Where("sub_contractor_id = ?", req.SubContractorId)
+	}
+	if req.From != nil {
+		query = query.Where("completed_at >= ?", *req.From)
+	}
+	if req.To != nil {
+		query = query.Where("completed_at < ?", *req.To)
+	}
+
+	var lines []models.SubContractorAssignment
+	query.Order("completed_at").Find(&lines)
+
+	totals := map[string]float64{}
+	uncosted := 0
+	for _, line := range lines {
+		if line.RateId == 0 {
+			uncosted++
+			continue
+		}
+		totals[line.Currency] += line.Total
+	}
+
+	return utils.SendJsonResult(c, fiber.Map{"lines": lines, "totals": totals, "uncosted": uncosted})
+}
+
+// DeleteAssignment remove work assigned to a sub contractor
+func DeleteAssignment(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	if _, err := managerForBusiness(c, db, businessId); err != nil {
+		return nil
+	}
+
+	var assignment models.SubContractorAssignment
+	result := db.First(&assignment, "business_id = ? and sub_contractor_id = ? and id = ?", businessId, c.Params("id"), c.Params("assignmentId"))
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": "No Assignment found with given ID"})
+	}
+
+	if err := db.Delete(&assignment).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return utils.SendJsonResult(c, assignment)
+}
+
+// GetMyAssignments list the active work, locations and equipment assigned to the current user as a sub contractor
+func GetMyAssignments(c *fiber.Ctx, db *gorm.DB, user models.User) error {
+
+	contractorIds := services.ContractorIdsForUser(db, user.ID)
+	if len(contractorIds) == 0 {
+		return utils.SendJsonResult(c, fiber.Map{"assignments": []models.SubContractorAssignment{}, "equipment": []map[string]interface{}{}})
+	}
+
+	var assignments []models.SubContractorAssignment
+	db.Preload("Location.Areas").
+		Where("sub_contractor_id in ? and status = 'active'", contractorIds).
+		Order("starts_at").
+		Find(&assignments)
+
+	equipmentIds := []uint{}
+	locationIds := []uint{}
+	for _, a := range assignments {
+		if a.EquipmentId > 0 {
+			equipmentIds = append(equipmentIds, a.EquipmentId)
+		} else {
+			locationIds = append(locationIds, a.LocationId)
+		}
+	}
+
+	// the contractor only sees the equipment it is assigned to or at the locations it is assigned to
+	equipment := []map[string]interface{}{}
+	if len(equipmentIds) > 0 || len(locationIds) > 0 {
+		db.Raw("select * from equipment where id in ? or location_id in ?", append(equipmentIds, 0), append(locationIds, 0)).Scan(&equipment)
+	}
+
+	return utils.SendJsonResult(c, fiber.Map{"assignments": assignments, "equipment": equipment})
+}
+
+// GetRates list the rates agreed with a sub contractor
+func GetRates(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	var rates []models.SubContractorRate
+	db.Order("service, valid_from").Find(&rates, "business_id = ? and sub_contractor_id = ?", businessId, c.Params("id"))
+
+	return utils.SendJsonResult(c, rates)
+}
+
+func validateRate(c *fiber.Ctx, rate *models.SubContractorRate) bool {
+	if rate.Unit == "" {
+		rate.Unit = "hour"
+	}
+
+	if !slices.Contains(rateUnits, rate.Unit) {
+		c.Status(fiber.StatusNotAcceptable)
+		utils.SendJsonResult(c, fiber.Map{"error": "unit must be hour, day, visit or item"})
+		return false
+	}
+
+	if rate.Rate < 0 {
+		c.Status(fiber.StatusNotAcceptable)
+		utils.SendJsonResult(c, fiber.Map{"error": "rate cannot be negative"})
+		return false
+	}
+
+	if rate.ValidFrom != nil && rate.ValidTo != nil && rate.ValidTo.Before(*rate.ValidFrom) {
+		c.Status(fiber.StatusNotAcceptable)
+		utils.SendJsonResult(c, fiber.Map{"error": "validTo is before validFrom"})
+		return false
+	}
+
+	return true
+}
+
+// CreateRate add a rate for a sub contractor
+func CreateRate(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	if _, err := managerForBusiness(c, db, businessId); err != nil {
+		return nil
+	}
+
+	sub, err := subContractorForBusiness(c, db, businessId)
+	if err != nil {
+		return nil
+	}
+
+	rate := new(models.SubContractorRate)
+	if err := c.BodyParser(rate); err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return err
+	}
+
+	if !validateRate(c, rate) {
+		return nil
+	}
+
+	if rate.Currency == "" {
+		var business models.Business
+		db.First(&business, businessId)
+		rate.Currency = business.Currency
+	}
+
+	rate.ID = 0
+	rate.BusinessId = businessId
+	rate.SubContractorId = sub.ID
+
+	if err := db.Create(rate).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return utils.SendJsonResult(c, rate)
+}
+
+// UpdateRate change a rate of a sub contractor
+func UpdateRate(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	if _, err := managerForBusiness(c, db, businessId); err != nil {
+		return nil
+	}
+
+	var rate models.SubContractorRate
+	result := db.First(&rate, "business_id = ? and sub_contractor_id = ? and id = ?", businessId, c.Params("id"), c.Params("rateId"))
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": "No Rate found with given ID"})
+	}
+
+	if err := c.BodyParser(&rate); err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return err
+	}
+
+	if !validateRate(c, &rate) {
+		return nil
+	}
+
+	if err := db.Model(&models.SubContractorRate{}).Where("id = ?", c.Params("rateId")).
+		Select("service", "unit", "rate", "currency", "valid_from", "valid_to").
+		Updates(&rate).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return utils.SendJsonResult(c, rate)
+}
+
+// DeleteRate remove a rate of a sub contractor
+func DeleteRate(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	if _, err := managerForBusiness(c, db, businessId); err != nil {
+		return nil
+	}
+
+	var rate models.SubContractorRate
+	result := db.First(&rate, "business_id = ? and sub_contractor_id = ? and id = ?", businessId, c.Params("id"), c.Params("rateId"))
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": "No Rate found with given ID"})
+	}
+
+	db.Delete(&rate)
+
+	return utils.SendJsonResult(c, rate)
+}
+
+// RateFor returns the rate of a sub contractor for a service at a given time,
+// falling back to the default rate when there is none for the service
+func RateFor(db *gorm.DB, subContractorId uint, service string, at time.Time) (models.SubContractorRate, error) {
+
+	var rates []models.SubContractorRate
+	db.Order("valid_from desc nulls last").
+		Find(&rates, "sub_contractor_id = ? and (service = ? or service = '')", subContractorId, service)
+
+	for _, match := range []string{service, ""} {
+		for _, rate := range rates {
+			if rate.Service == match && rate.AppliesAt(at) {
+				return rate, nil
+			}
+		}
+	}
+
+	return models.SubContractorRate{}, fmt.Errorf("no rate for sub contractor %d and service %s", subContractorId, service)
+}
+
+// QuoteWork cost work done by a sub contractor, for use on invoices
+func QuoteWork(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	sub, err := subContractorForBusiness(c, db, businessId)
+	if err != nil {
+		return nil
+	}
+
+	type QuoteRequest struct {
+		Service  string     `json:"service"`
+		Quantity float64    `json:"quantity"`
+		At       *time.Time `json:"at"`
+	}
+
+	req := new(QuoteRequest)
+	if err := c.BodyParser(req); err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return err
+	}
+
+	at := time.Now()
+	if req.At != nil {
+		at = *req.At
+	}
+
+	rate, err := RateFor(db, sub.ID, req.Service, at)
+	if err != nil {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": err.Error()})
+	}
+
+	return utils.SendJsonResult(c, fiber.Map{
+		"rate":     rate,
+		"quantity": req.Quantity,
+		"total":    rate.Rate * req.Quantity,
+		"currency": rate.Currency,
+	})
+}
@@ -0,0 +1,142 @@
+package customer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"myproject/api/models"
+	"myproject/test"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCustomers(t *testing.T) {
+	app, db, err := test.SetupTestApp()
+	if err != nil {
+		t.Fatalf("Failed to setup test app: %v", err)
+	}
+
+	api := app.Group("/api/v1")
+	CustomerApiRoutes(api.Group("customers"), db)
+
+	ownId, otherId := test.SetupTenants(db)
+
+	// a customer business the test user also owns, a provider only links businesses it can manage
+	var customerId uint
+	db.Raw("insert into businesses (name, user_id) values (?, 3) returning id", "Customer tenant").Scan(&customerId)
+
+	var tag models.Tag
+
+	signedRequest := func(method, url string, data map[string]interface{}) (int, map[string]interface{}) {
+		test.SignMap(data)
+		jsonData, _ := json.Marshal(data)
+		req := httptest.NewRequest(method, url, bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+
+		var result map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+
+	t.Run("Add customer", func(t *testing.T) {
+		status, _ := signedRequest("POST", fmt.Sprintf("/api/v1/customers/%d", ownId), map[string]interface{}{
+			"customerId": customerId,
+			"status":     "prospect",
+		})
+		assert.Equal(t, 200, status)
+
+		status, _ = signedRequest("POST", fmt.Sprintf("/api/v1/customers/%d", ownId), map[string]interface{}{
+			"customerId": customerId,
+		})
+		assert.Equal(t, fiber.StatusConflict, status)
+	})
+
+	t.Run("Add a business of another owner", func(t *testing.T) {
+		status, _ := signedRequest("POST", fmt.Sprintf("/api/v1/customers/%d", ownId), map[string]interface{}{
+			"customerId": otherId,
+		})
+		assert.Equal(t, fiber.StatusForbidden, status)
+
+		var count int64
+		db.Model(&models.BusinessCustomer{}).Where("business_id = ? and customer_id = ?", ownId, otherId).Count(&count)
+		assert.Equal(t, int64(0), count)
+	})
+
+	t.Run("Add customer with invalid status", func(t *testing.T) {
+		status, _ := signedRequest("POST", fmt.Sprintf("/api/v1/customers/%d", ownId), map[string]interface{}{
+			"customerId": customerId,
+			"status":     "lost",
+		})
+		assert.Equal(t, fiber.StatusNotAcceptable, status)
+	})
+
+	t.Run("Tag customer", func(t *testing.T) {
+		status, result := signedRequest("POST", fmt.Sprintf("/api/v1/customers/%d/tags", ownId), map[string]interface{}{
+			"name": "Key account",
+		})
+		assert.Equal(t, 200, status)
+		tag.ID = uint(result["result"].(map[string]interface{})["id"].(float64))
+
+		status, _ = signedRequest("PUT", fmt.Sprintf("/api/v1/customers/%d/%d/tags/%d", ownId, customerId, tag.ID), map[string]interface{}{})
+		assert.Equal(t, 200, status)
+	})
+
+	t.Run("Add note", func(t *testing.T) {
+		status, _ := signedRequest("POST", fmt.Sprintf("/api/v1/customers/%d/%d/notes", ownId, customerId), map[string]interface{}{
+			"note": "Renewal due in March",
+		})
+		assert.Equal(t, 200, status)
+	})
+
+	t.Run("List customers with filters", func(t *testing.T) {
+		status, result := signedRequest("POST", fmt.Sprintf("/api/v1/customers/%d/list?status=prospect&tag=%d&limit=10", ownId, tag.ID), map[string]interface{}{})
+		assert.Equal(t, 200, status)
+
+		page := result["result"].(map[string]interface{})
+		assert.Equal(t, float64(1), page["total"])
+		assert.Equal(t, float64(10), page["limit"])
+
+		status, result = signedRequest("POST", fmt.Sprintf("/api/v1/customers/%d/list?status=churned", ownId), map[string]interface{}{})
+		assert.Equal(t, 200, status)
+		page = result["result"].(map[string]interface{})
+		assert.Equal(t, float64(0), page["total"])
+	})
+
+	t.Run("Update customer status", func(t *testing.T) {
+		status, _ := signedRequest("PUT", fmt.Sprintf("/api/v1/customers/%d/%d", ownId, customerId), map[string]interface{}{
+			"status":        "active",
+			"contractStart": "2025-01-01T00:00:00Z",
+			"contractEnd":   "2024-01-01T00:00:00Z",
+		})
+		assert.Equal(t, fiber.StatusNotAcceptable, status)
+
+		status, _ = signedRequest("PUT", fmt.Sprintf("/api/v1/customers/%d/%d", ownId, customerId), map[string]interface{}{
+			"status": "active",
+		})
+		assert.Equal(t, 200, status)
+
+		var customer models.BusinessCustomer
+		db.First(&customer, "business_id = ? and customer_id = ?", ownId, customerId)
+		assert.Equal(t, "active", customer.Status)
+	})
+
+	t.Run("List customers of another business", func(t *testing.T) {
+		status, _ := signedRequest("POST", fmt.Sprintf("/api/v1/customers/%d/list", otherId), map[string]interface{}{})
+		assert.Equal(t, fiber.StatusForbidden, status)
+	})
+
+	t.Run("Remove customer", func(t *testing.T) {
+		status, _ := signedRequest("DELETE", fmt.Sprintf("/api/v1/customers/%d/%d", ownId, customerId), map[string]interface{}{})
+		assert.Equal(t, 200, status)
+
+		var count int64
+		db.Model(&models.BusinessTag{}).Where("business_id = ? and customer_id = ?", ownId, customerId).Count(&count)
+		assert.Equal(t, int64(0), count)
+	})
+}
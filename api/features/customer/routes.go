@@ -0,0 +1,91 @@
+package customer
+
+import (
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"myproject/api/services"
+)
+
+func businessIdParam(c *fiber.Ctx) uint {
+	id, _ := strconv.ParseUint(c.Params("bizid"), 10, 64)
+	return uint(id)
+}
+
+// routes prefixed with /api/v1/customers
+func CustomerApiRoutes(app fiber.Router, db *gorm.DB) {
+
+	tenant := services.RequireTenant(db, services.TenantFromParam("bizid"))
+
+	// list the customers of a business
+	// query params: page, limit, status, tag, manager, q
+	app.Get("/:bizid", tenant, func(c *fiber.Ctx) error {
+		return GetCustomers(c, services.TenantDB(db, c), businessIdParam(c))
+	})
+	app.Post("/:bizid/list", tenant, func(c *fiber.Ctx) error {
+		return GetCustomers(c, services.TenantDB(db, c), businessIdParam(c))
+	})
+
+	// link a customer business
+	app.Post("/:bizid", tenant, func(c *fiber.Ctx) error {
+		return AddCustomer(c, services.TenantDB(db, c), businessIdParam(c))
+	})
+
+	////////////////  TAGS	//////////////////////
+	app.Get("/:bizid/tags", tenant, func(c *fiber.Ctx) error {
+		return GetTags(c, services.TenantDB(db, c), businessIdParam(c))
+	})
+
+	app.Post("/:bizid/tags", tenant, func(c *fiber.Ctx) error {
+		return CreateTag(c, services.TenantDB(db, c), businessIdParam(c))
+	})
+
+	////////////////  CUSTOMER	//////////////////////
+	// get a customer with its locations, tags, notes and contacts
+	app.Get("/:bizid/:customerId", tenant, func(c *fiber.Ctx) error {
+		return GetCustomer(c, services.TenantDB(db, c), businessIdParam(c))
+	})
+
+	// update the status, account manager or contract of a customer
+	app.Put("/:bizid/:customerId", tenant, func(c *fiber.Ctx) error {
+		return UpdateCustomer(c, services.TenantDB(db, c), businessIdParam(c))
+	})
+
+	// unlink a customer
+	app.Delete("/:bizid/:customerId", tenant, func(c *fiber.Ctx) error {
+		return RemoveCustomer(c, services.TenantDB(db, c), businessIdParam(c))
+	})
+
+	// tag or untag a customer
+	app.Put("/:bizid/:customerId/tags/:tagId", tenant, func(c *fiber.Ctx) error {
+		return AddCustomerTag(c, services.TenantDB(db, c), businessIdParam(c))
+	})
+
+	app.Delete("/:bizid/:customerId/tags/:tagId", tenant, func(c *fiber.Ctx) error {
+		return RemoveCustomerTag(c, services.TenantDB(db, c), businessIdParam(c))
+	})
+
+	////////////////  NOTES	//////////////////////
+	app.Get("/:bizid/:customerId/notes", tenant, func(c *fiber.Ctx) error {
+		return GetCustomerNotes(c, services.TenantDB(db, c), businessIdParam(c))
+	})
+
+	app.Post("/:bizid/:customerId/notes", tenant, func(c *fiber.Ctx) error {
+		return CreateCustomerNote(c, services.TenantDB(db, c), businessIdParam(c))
+	})
+
+	app.Delete("/:bizid/:customerId/notes/:noteId", tenant, func(c *fiber.Ctx) error {
+		return DeleteCustomerNote(c, services.TenantDB(db, c), businessIdParam(c))
+	})
+
+	////////////////  CONTACTS	//////////////////////
+	app.Get("/:bizid/:customerId/contacts", tenant, func(c *fiber.Ctx) error {
+		return GetCustomerContacts(c, services.TenantDB(db, c), businessIdParam(c))
+	})
+
+	app.Post("/:bizid/:customerId/contacts", tenant, func(c *fiber.Ctx) error {
+		return CreateCustomerContact(c, services.TenantDB(db, c), businessIdParam(c))
+	})
+}
@@ -0,0 +1,570 @@
+package customer
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"myproject/api/database"
+	"myproject/api/models"
+	"myproject/api/services"
+	"myproject/api/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPageSize = 25
+	maxPageSize     = 100
+)
+
+type CustomerRequest struct {
+	CustomerId       uint       `json:"customerId"`
+	Status           string     `json:"status"`
+	AccountManagerId uint       `json:"accountManagerId"`
+	ContractStart    *time.Time `json:"contractStart"`
+	ContractEnd      *time.Time `json:"contractEnd"`
+	Tags             []uint     `json:"tags"`
+}
+
+// customerForBusiness load the business customer link for the :customerId param
+func customerForBusiness(c *fiber.Ctx, db *gorm.DB, businessId uint) (*models.BusinessCustomer, error) {
+
+	var customer models.BusinessCustomer
+	result := db.First(&customer, "business_id = ? and customer_id = ?", businessId, c.Params("customerId"))
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		c.Status(fiber.StatusNotAcceptable)
+		utils.SendJsonResult(c, fiber.Map{"error": "No Customer found with given ID"})
+		return nil, result.Error
+	}
+
+	return &customer, nil
+}
+
+// validateCustomer check the status, account manager and contract dates of a request
+func validateCustomer(c *fiber.Ctx, db *gorm.DB, businessId uint, req *CustomerRequest) bool {
+
+	if req.Status != "" && !slices.Contains(models.CustomerStatuses, req.Status) {
+		c.Status(fiber.StatusNotAcceptable)
+		utils.SendJsonResult(c, fiber.Map{"error": "status must be prospect, active or churned"})
+		return false
+	}
+
+	if req.AccountManagerId > 0 && !services.IsBusinessOwner(db, req.AccountManagerId, businessId) && !services.IsTeamMember(db, req.AccountManagerId, businessId) {
+		c.Status(fiber.StatusNotAcceptable)
+		utils.SendJsonResult(c, fiber.Map{"error": "The account manager must be a member of the team"})
+		return false
+	}
+
+	if req.ContractStart != nil && req.ContractEnd != nil && req.ContractEnd.Before(*req.ContractStart) {
+		c.Status(fiber.StatusNotAcceptable)
+		utils.SendJsonResult(c, fiber.Map{"error": "contractEnd is before contractStart"})
+		return false
+	}
+
+	return true
+}
+
+// attachTags load the tags the business put on each customer
+func attachTags(db *gorm.DB, businessId uint, customers []models.BusinessCustomer) {
+
+	if len(customers) == 0 {
+		return
+	}
+
+	customerIds := make([]uint, len(customers))
+	for i, customer := range customers {
+		customerIds[i] = customer.CustomerId
+	}
+
+	var tags []models.BusinessTag
+	db.Preload("Tag").Find(&tags, "business_id = ? and customer_id in ?", businessId, customerIds)
+
+	for i := range customers {
+		customers[i].Tags = []models.Tag{}
+		for _, tag := range tags {
+			if tag.CustomerId == customers[i].CustomerId && tag.Tag != nil {
+				customers[i].Tags = append(customers[i].Tags, *tag.Tag)
+			}
+		}
+	}
+}
+
+// GetCustomers list the customers of a business one page at a time, filtered by
+// status, tag, account manager or a search of the customer name, email and phone
+func GetCustomers(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	page := utils.QueryInt(c, "page", 1)
+	if page < 1 {
+		page = 1
+	}
+
+	limit := utils.QueryInt(c, "limit", defaultPageSize)
+	if limit < 1 || limit > maxPageSize {
+		limit = defaultPageSize
+	}
+
+	query := db.Model(&models.BusinessCustomer{}).Where("business_id = ?", businessId)
+
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	if manager := utils.QueryInt(c, "manager", 0); manager > 0 {
+		query = query.Where("account_manager_id = ?", manager)
+	}
+
+	if tag := utils.QueryInt(c, "tag", 0); tag > 0 {
+		query = query.Where("customer_id in (select customer_id from business_tags where business_id = ? and tag_id = ?)", businessId, tag)
+	}
+
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		like := "%" + q + "%"
+		query = query.Where("customer_id in (select id from businesses where name ilike ? or email ilike ? or phone ilike ?)", like, like, like)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var customers []models.BusinessCustomer
+	query.Preload("Customer").Preload("AccountManager").
+		Order("id desc").
+		Offset((page - 1) * limit).Limit(limit).
+		Find(&customers)
+
+	attachTags(db, businessId, customers)
+
+	return utils.SendJsonResult(c, fiber.Map{
+		"customers": customers,
+		"total":     total,
+		"page":      page,
+		"limit":     limit,
+	})
+}
+
+// GetCustomer get a customer of the business with its locations, tags, notes and contacts
+func GetCustomer(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	customer, err := customerForBusiness(c, db, businessId)
+	if err != nil {
+		return nil
+	}
+
+	// the customer business and its locations belong to another tenant
+	lookup := database.WithoutTenant(db)
+
+	var business models.Business
+	lookup.Preload("Locations.Areas").First(&business, customer.CustomerId)
+	customer.Customer = &business
+
+	if customer.AccountManagerId > 0 {
+		var manager models.User
+		db.First(&manager, customer.AccountManagerId)
+		customer.AccountManager = &manager
+	}
+
+	customers := []models.BusinessCustomer{*customer}
+	attachTags(db, businessId, customers)
+
+	var notes []models.CustomerNote
+	db.Preload("User").Order("created_at desc").Find(&notes, "business_id = ? and customer_id = ?", businessId, customer.CustomerId)
+
+	var contacts []models.Contact
+	lookup.Order("name").Find(&contacts, "business_id = ?", customer.CustomerId)
+
+	return utils.SendJsonResult(c, fiber.Map{
+		"customer": customers[0],
+		"notes":    notes,
+		"contacts": contacts,
+	})
+}
+
+// AddCustomer link a customer business to the service provider
+func AddCustomer(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	user, err := services.VerifyFormSignature(db, c)
+	if err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	req := new(CustomerRequest)
+	if err := c.BodyParser(req); err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return err
+	}
+
+	if !validateCustomer(c, db, businessId, req) {
+		return nil
+	}
+
+	var business models.Business
+	if result := db.First(&business, req.CustomerId); errors.Is(result.Error, gorm.ErrRecordNotFound) || req.CustomerId == 0 {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": "No Business found with given ID"})
+	}
+
+	if req.CustomerId == businessId {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": "A business cannot be its own customer"})
+	}
+
+	// linking gives the provider read access to the customer, only someone managing it consents
+	if !services.CanManageBusiness(database.WithoutTenant(db), user, req.CustomerId) {
+		c.Status(fiber.StatusForbidden)
+		return utils.SendJsonResult(c, fiber.Map{"error": "Only the owner of the customer business can link it to a provider"})
+	}
+
+	var existing models.BusinessCustomer
+	db.First(&existing, "business_id = ? and customer_id = ?", businessId, req.CustomerId)
+	if existing.ID > 0 {
+		c.Status(fiber.StatusConflict)
+		return utils.SendJsonResult(c, fiber.Map{"error": "The business is already a customer"})
+	}
+
+	customer := models.BusinessCustomer{
+		UserId:           user.ID,
+		BusinessId:       businessId,
+		CustomerId:       req.CustomerId,
+		Status:           req.Status,
+		AccountManagerId: req.AccountManagerId,
+		ContractStart:    req.ContractStart,
+		ContractEnd:      req.ContractEnd,
+	}
+	if customer.Status == "" {
+		customer.Status = "active"
+	}
+
+	if err := db.Create(&customer).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	for _, tagId := range req.Tags {
+		addTag(db, businessId, customer.CustomerId, tagId)
+	}
+
+	return utils.SendJsonResult(c, customer)
+}
+
+// UpdateCustomer change the status, account manager or contract dates of a customer
+func UpdateCustomer(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	if _, err := services.VerifyFormSignature(db, c); err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	customer, err := customerForBusiness(c, db, businessId)
+	if err != nil {
+		return nil
+	}
+
+	req := new(CustomerRequest)
+	if err := c.BodyParser(req); err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return err
+	}
+
+	if !validateCustomer(c, db, businessId, req) {
+		return nil
+	}
+
+	if req.Status != "" {
+		customer.Status = req.Status
+	}
+	customer.AccountManagerId = req.AccountManagerId
+	customer.ContractStart = req.ContractStart
+	customer.ContractEnd = req.ContractEnd
+
+	if err := db.Save(customer).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return utils.SendJsonResult(c, customer)
+}
+
+// RemoveCustomer unlink a customer from the service provider, its tags and notes are removed
+func RemoveCustomer(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	user, err := services.VerifyFormSignature(db, c)
+	if err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	if !services.CanManageBusiness(db, user, businessId) {
+		c.Status(fiber.StatusForbidden)
+		return utils.SendJsonResult(c, fiber.Map{"error": "Only the owner or an admin can remove customers"})
+	}
+
+	customer, err := customerForBusiness(c, db, businessId)
+	if err != nil {
+		return nil
+	}
+
+	if err := db.Delete(customer).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return utils.SendJsonResult(c, customer)
+}
+
+////////////////  NOTES	//////////////////////
+
+func GetCustomerNotes(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	customer, err := customerForBusiness(c, db, businessId)
+	if err != nil {
+		return nil
+	}
+
+	var notes []models.CustomerNote
+	db.Preload("User").Order("created_at desc").Find(&notes, "business_id = ? and customer_id = ?", businessId, customer.CustomerId)
+
+	return utils.SendJsonResult(c, notes)
+}
+
+func CreateCustomerNote(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	user, err := services.VerifyFormSignature(db, c)
+	if err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	customer, err := customerForBusiness(c, db, businessId)
+	if err != nil {
+		return nil
+	}
+
+	note := new(models.CustomerNote)
+	if err := c.BodyParser(note); err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return err
+	}
+
+	note.Note = strings.TrimSpace(note.Note)
+	if note.Note == "" {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": "note is required"})
+	}
+
+	note.ID = 0
+	note.BusinessId = businessId
+	note.CustomerId = customer.CustomerId
+	note.UserId = user.ID
+
+	if err := db.Create(note).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return utils.SendJsonResult(c, note)
+}
+
+// DeleteCustomerNote delete a note, only the author or a manager of the business can
+func DeleteCustomerNote(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	user, err := services.VerifyFormSignature(db, c)
+	if err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	var note models.CustomerNote
+	result := db.First(&note, "business_id = ? and customer_id = ? and id = ?", businessId, c.Params("customerId"), c.Params("noteId"))
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": "No Note found with given ID"})
+	}
+
+	if note.UserId != user.ID && !services.CanManageBusiness(db, user, businessId) {
+		c.Status(fiber.StatusForbidden)
+		return utils.SendJsonResult(c, fiber.Map{"error": "Only the author can delete the note"})
+	}
+
+	db.Delete(&note)
+
+	return utils.SendJsonResult(c, note)
+}
+
+////////////////  TAGS	//////////////////////
+
+// GetTags list the tags a business can use, its own and the pre-determined tags
+func GetTags(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	var tags []models.Tag
+	database.WithoutTenant(db).Order("name").Find(&tags, "business_id = 0 or business_id = ?", businessId)
+
+	return utils.SendJsonResult(c, tags)
+}
+
+func CreateTag(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	if _, err := services.VerifyFormSignature(db, c); err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	tag := new(models.Tag)
+	if err := c.BodyParser(tag); err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return err
+	}
+
+	tag.Name = strings.TrimSpace(tag.Name)
+	if tag.Name == "" {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": "name is required"})
+	}
+
+	var existing models.Tag
+	database.WithoutTenant(db).First(&existing, "(business_id = 0 or business_id = ?) and lower(name) = lower(?)", businessId, tag.Name)
+	if existing.ID > 0 {
+		return utils.SendJsonResult(c, existing)
+	}
+
+	tag.ID = 0
+	tag.BusinessId = businessId
+
+	if err := db.Create(tag).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return utils.SendJsonResult(c, tag)
+}
+
+// addTag tag a customer with one of the business tags or a pre-determined tag
+func addTag(db *gorm.DB, businessId, customerId, tagId uint) error {
+
+	var tag models.Tag
+	result := database.WithoutTenant(db).First(&tag, "id = ? and (business_id = 0 or business_id = ?)", tagId, businessId)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return result.Error
+	}
+
+	var existing models.BusinessTag
+	db.First(&existing, "business_id = ? and customer_id = ? and tag_id = ?", businessId, customerId, tagId)
+	if existing.ID > 0 {
+		return nil
+	}
+
+	return db.Create(&models.BusinessTag{BusinessId: businessId, CustomerId: customerId, TagId: tagId}).Error
+}
+
+func AddCustomerTag(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	if _, err := services.VerifyFormSignature(db, c); err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	customer, err := customerForBusiness(c, db, businessId)
+	if err != nil {
+		return nil
+	}
+
+	tagId, _ := c.ParamsInt("tagId")
+
+	if err := addTag(db, businessId, customer.CustomerId, uint(tagId)); errors.Is(err, gorm.ErrRecordNotFound) {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": "No Tag found with given ID"})
+	} else if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	customers := []models.BusinessCustomer{*customer}
+	attachTags(db, businessId, customers)
+
+	return utils.SendJsonResult(c, customers[0])
+}
+
+func RemoveCustomerTag(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	if _, err := services.VerifyFormSignature(db, c); err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	customer, err := customerForBusiness(c, db, businessId)
+	if err != nil {
+		return nil
+	}
+
+	db.Where("business_id = ? and customer_id = ? and tag_id = ?", businessId, customer.CustomerId, c.Params("tagId")).
+		Delete(&models.BusinessTag{})
+
+	customers := []models.BusinessCustomer{*customer}
+	attachTags(db, businessId, customers)
+
+	return utils.SendJsonResult(c, customers[0])
+}
+
+////////////////  CONTACTS	//////////////////////
+
+// GetCustomerContacts list the contacts of a customer business
+func GetCustomerContacts(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	customer, err := customerForBusiness(c, db, businessId)
+	if err != nil {
+		return nil
+	}
+
+	var contacts []models.Contact
+	database.WithoutTenant(db).Order("name").Find(&contacts, "business_id = ?", customer.CustomerId)
+
+	return utils.SendJsonResult(c, contacts)
+}
+
+// CreateCustomerContact add a contact person to a customer business
+func CreateCustomerContact(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	if _, err := services.VerifyFormSignature(db, c); err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	customer, err := customerForBusiness(c, db, businessId)
+	if err != nil {
+		return nil
+	}
+
+	contact := new(models.Contact)
+	if err := c.BodyParser(contact); err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return err
+	}
+
+	if contact.Name == "" || (contact.Email == "" && contact.Phone == "") {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": "name and an email or phone are required"})
+	}
+
+	lookup := database.WithoutTenant(db)
+
+	if contact.LocationId > 0 {
+		var location models.Location
+		if result := lookup.First(&location, "id = ? and business_id = ?", contact.LocationId, customer.CustomerId); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			c.Status(fiber.StatusNotAcceptable)
+			return utils.SendJsonResult(c, fiber.Map{"error": "No Location found with given ID"})
+		}
+	}
+
+	contact.ID = 0
+	contact.UserId = 0
+	contact.BusinessId = customer.CustomerId
+	contact.Email = strings.ToLower(strings.TrimSpace(contact.Email))
+
+	if err := lookup.Create(contact).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return utils.SendJsonResult(c, contact)
+}
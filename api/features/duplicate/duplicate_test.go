@@ -0,0 +1,109 @@
+package duplicate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"myproject/test"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDuplicates(t *testing.T) {
+	app, db, err := test.SetupTestApp()
+	if err != nil {
+		t.Fatalf("Failed to setup test app: %v", err)
+	}
+
+	api := app.Group("/api/v1")
+	DuplicateApiRoutes(api.Group("duplicates"), db)
+
+	ownId, otherId := test.SetupTenants(db)
+
+	// the signer must be an admin, the same tax ID written two ways makes the tenants duplicates
+	var roles string
+	db.Raw("select roles from users where id = 3").Scan(&roles)
+	db.Exec("update users set roles = 'admin' where id = 3")
+	db.Exec("update businesses set tax_id = '900.123.456-7' where id = ?", ownId)
+	db.Exec("update businesses set tax_id = '9001234567' where id = ?", otherId)
+	t.Cleanup(func() {
+		db.Exec("update users set roles = ? where id = 3", roles)
+		db.Exec("update businesses set tax_id = '' where id in ?", []uint{ownId, otherId})
+	})
+
+	var candidateId, mergeId uint
+
+	signedRequest := func(method, url string, data map[string]interface{}) (int, map[string]interface{}) {
+		test.SignMap(data)
+		jsonData, _ := json.Marshal(data)
+		req := httptest.NewRequest(method, url, bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+
+		var result map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+
+	t.Run("Scan business", func(t *testing.T) {
+		status, result := signedRequest("POST", fmt.Sprintf("/api/v1/duplicates/scan/%d", ownId), map[string]interface{}{})
+		assert.Equal(t, 200, status)
+
+		found := false
+		for _, c := range result["result"].([]interface{}) {
+			candidate := c.(map[string]interface{})
+			if candidate["duplicateId"] == float64(max(ownId, otherId)) {
+				found = true
+				assert.Contains(t, candidate["reasons"], "taxId")
+			}
+		}
+		assert.True(t, found)
+
+		status, result = signedRequest("POST", "/api/v1/duplicates/list", map[string]interface{}{})
+		assert.Equal(t, 200, status)
+		for _, c := range result["result"].(map[string]interface{})["candidates"].([]interface{}) {
+			candidate := c.(map[string]interface{})
+			if candidate["businessId"] == float64(min(ownId, otherId)) && candidate["duplicateId"] == float64(max(ownId, otherId)) {
+				candidateId = uint(candidate["id"].(float64))
+			}
+		}
+		assert.NotZero(t, candidateId)
+	})
+
+	t.Run("Merge into itself", func(t *testing.T) {
+		status, _ := signedRequest("POST", "/api/v1/duplicates/merge", map[string]interface{}{"targetId": ownId, "sourceId": ownId})
+		assert.Equal(t, fiber.StatusNotAcceptable, status)
+	})
+
+	t.Run("Merge and undo", func(t *testing.T) {
+		status, result := signedRequest("POST", "/api/v1/duplicates/merge", map[string]interface{}{"targetId": ownId, "sourceId": otherId})
+		assert.Equal(t, 200, status)
+		mergeId = uint(result["result"].(map[string]interface{})["id"].(float64))
+
+		status, _ = signedRequest("POST", "/api/v1/duplicates/merge", map[string]interface{}{"targetId": ownId, "sourceId": otherId})
+		assert.Equal(t, fiber.StatusConflict, status)
+
+		status, _ = signedRequest("POST", fmt.Sprintf("/api/v1/duplicates/merge/%d/undo", mergeId), map[string]interface{}{})
+		assert.Equal(t, 200, status)
+
+		status, _ = signedRequest("POST", fmt.Sprintf("/api/v1/duplicates/merge/%d/undo", mergeId), map[string]interface{}{})
+		assert.Equal(t, fiber.StatusConflict, status)
+	})
+
+	t.Run("Dismiss candidate", func(t *testing.T) {
+		status, result := signedRequest("PUT", fmt.Sprintf("/api/v1/duplicates/%d/dismiss", candidateId), map[string]interface{}{})
+		assert.Equal(t, 200, status)
+		assert.Equal(t, "dismissed", result["result"].(map[string]interface{})["status"])
+	})
+
+	t.Run("Not an admin", func(t *testing.T) {
+		db.Exec("update users set roles = '' where id = 3")
+		status, _ := signedRequest("POST", "/api/v1/duplicates/list", map[string]interface{}{})
+		assert.Equal(t, fiber.StatusForbidden, status)
+	})
+}
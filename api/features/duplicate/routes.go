@@ -0,0 +1,55 @@
+package duplicate
+
+import (
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+func idParam(c *fiber.Ctx, name string) uint {
+	id, _ := strconv.ParseUint(c.Params(name), 10, 64)
+	return uint(id)
+}
+
+// routes prefixed with /api/v1/duplicates, for admins
+func DuplicateApiRoutes(app fiber.Router, db *gorm.DB) {
+
+	////////////////  CANDIDATES	//////////////////////
+	// list the candidate pairs
+	// query params: status (open, dismissed, merged), page, limit
+	app.Post("/list", func(c *fiber.Ctx) error {
+		return GetCandidates(c, db)
+	})
+
+	// scan all the businesses in the background
+	app.Post("/scan", func(c *fiber.Ctx) error {
+		return ScanAll(c, db)
+	})
+
+	// scan one business
+	app.Post("/scan/:bizid", func(c *fiber.Ctx) error {
+		return ScanBusiness(c, db, idParam(c, "bizid"))
+	})
+
+	// the pair are not duplicates
+	app.Put("/:id/dismiss", func(c *fiber.Ctx) error {
+		return DismissCandidate(c, db)
+	})
+
+	////////////////  MERGES	//////////////////////
+	// merge sourceId into targetId
+	app.Post("/merge", func(c *fiber.Ctx) error {
+		return Merge(c, db)
+	})
+
+	// the merges of a business
+	app.Post("/merges/:bizid", func(c *fiber.Ctx) error {
+		return GetMerges(c, db, idParam(c, "bizid"))
+	})
+
+	// undo a merge
+	app.Post("/merge/:id/undo", func(c *fiber.Ctx) error {
+		return UndoMerge(c, db, idParam(c, "id"))
+	})
+}
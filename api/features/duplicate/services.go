@@ -0,0 +1,216 @@
+package duplicate
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"myproject/api/models"
+	"myproject/api/services"
+	"myproject/api/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+const defaultPageSize = 50
+const maxPageSize = 200
+
+// verifyAdmin check the request signature and that the user is an admin, writes the error response if not
+func verifyAdmin(c *fiber.Ctx, db *gorm.DB) (models.User, bool) {
+
+	user, err := services.VerifyFormSignature(db, c)
+	if err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return user, false
+	}
+
+	if !strings.Contains(user.Roles, "admin") {
+		c.Status(fiber.StatusForbidden)
+		utils.SendJsonResult(c, fiber.Map{"error": "Only admins can manage duplicate businesses"})
+		return user, false
+	}
+
+	return user, true
+}
+
+// GetCandidates list the duplicate candidate pairs, highest score first, filtered by ?status= (default open)
+func GetCandidates(c *fiber.Ctx, db *gorm.DB) error {
+
+	if _, ok := verifyAdmin(c, db); !ok {
+		return nil
+	}
+
+	status := c.Query("status", "open")
+	if !slices.Contains(models.DuplicateStatuses, status) {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": "status must be open, dismissed or merged"})
+	}
+
+	page := utils.QueryInt(c, "page", 1)
+	if page < 1 {
+		page = 1
+	}
+
+	limit := utils.QueryInt(c, "limit", defaultPageSize)
+	if limit < 1 || limit > maxPageSize {
+		limit = defaultPageSize
+	}
+
+	query := db.Model(&models.DuplicateCandidate{}).Where("status = ?", status)
+
+	var total int64
+	query.Count(&total)
+
+	var candidates []models.DuplicateCandidate
+	query.Preload("Business").Preload("Duplicate").
+		Order("score desc, id").Offset((page - 1) * limit).Limit(limit).Find(&candidates)
+
+	return utils.SendJsonResult(c, fiber.Map{
+		"candidates": candidates,
+		"total":      total,
+		"page":       page,
+		"limit":      limit,
+	})
+}
+
+// ScanBusiness find and store the duplicate candidates of one business
+func ScanBusiness(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	if _, ok := verifyAdmin(c, db); !ok {
+		return nil
+	}
+
+	var business models.Business
+	if result := db.First(&business, businessId); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": "No Business found with given ID"})
+	}
+
+	candidates, err := services.SaveDuplicates(db, business)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return utils.SendJsonResult(c, candidates)
+}
+
+// ScanAll find the duplicate candidates of all the businesses in the background, the nightly task does the same
+func ScanAll(c *fiber.Ctx, db *gorm.DB) error {
+
+	if _, ok := verifyAdmin(c, db); !ok {
+		return nil
+	}
+
+	go func() {
+		found, err := services.ScanDuplicates(db)
+		if err != nil {
+			fmt.Println("ScanDuplicates", err)
+		}
+		fmt.Println("ScanDuplicates found", found, "candidates")
+	}()
+
+	c.Status(fiber.StatusAccepted)
+	return utils.SendJsonResult(c, fiber.Map{"status": "scanning"})
+}
+
+// DismissCandidate mark a candidate pair as not duplicates, later scans keep it dismissed
+func DismissCandidate(c *fiber.Ctx, db *gorm.DB) error {
+
+	if _, ok := verifyAdmin(c, db); !ok {
+		return nil
+	}
+
+	var candidate models.DuplicateCandidate
+	if result := db.First(&candidate, c.Params("id")); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": "No Candidate found with given ID"})
+	}
+
+	if candidate.Status == "merged" {
+		c.Status(fiber.StatusConflict)
+		return utils.SendJsonResult(c, fiber.Map{"error": "Candidate was merged, undo the merge first"})
+	}
+
+	candidate.Status = "dismissed"
+	if err := db.Save(&candidate).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return utils.SendJsonResult(c, candidate)
+}
+
+// Merge merge the source business into the target business
+func Merge(c *fiber.Ctx, db *gorm.DB) error {
+
+	user, ok := verifyAdmin(c, db)
+	if !ok {
+		return nil
+	}
+
+	type MergeRequest struct {
+		TargetId uint `json:"targetId"`
+		SourceId uint `json:"sourceId"`
+	}
+
+	req := new(MergeRequest)
+	if err := c.BodyParser(req); err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return err
+	}
+
+	merge, err := services.MergeBusinesses(db, req.TargetId, req.SourceId, user.ID)
+	if err != nil {
+		return mergeError(c, err)
+	}
+
+	return utils.SendJsonResult(c, merge)
+}
+
+// GetMerges list the merges of a business, as target or source, most recent first
+func GetMerges(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	if _, ok := verifyAdmin(c, db); !ok {
+		return nil
+	}
+
+	var merges []models.BusinessMerge
+	db.Order("created_at desc").Find(&merges, "target_id = ? or source_id = ?", businessId, businessId)
+
+	return utils.SendJsonResult(c, merges)
+}
+
+// UndoMerge put back what a merge moved and restore the source business
+func UndoMerge(c *fiber.Ctx, db *gorm.DB, mergeId uint) error {
+
+	user, ok := verifyAdmin(c, db)
+	if !ok {
+		return nil
+	}
+
+	merge, err := services.UndoMerge(db, mergeId, user.ID)
+	if err != nil {
+		return mergeError(c, err)
+	}
+
+	return utils.SendJsonResult(c, merge)
+}
+
+// mergeError send the response for the errors of MergeBusinesses and UndoMerge
+func mergeError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": "No Business or Merge found with given ID"})
+	case errors.Is(err, services.ErrSameBusiness):
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrAlreadyMerged), errors.Is(err, services.ErrMergeUndone):
+		c.Status(fiber.StatusConflict)
+		return utils.SendJsonResult(c, fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+}
@@ -0,0 +1,198 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"myproject/api/models"
+	"myproject/api/services"
+	"myproject/test"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBusinessExport(t *testing.T) {
+	app, db, err := test.SetupTestApp()
+	if err != nil {
+		t.Fatalf("Failed to setup test app: %v", err)
+	}
+
+	api := app.Group("/api/v1")
+	ExportApiRoutes(api.Group("exports"), db)
+
+	ownId, otherId := test.SetupTenants(db)
+
+	var exportId uint
+
+	signedRequest := func(method, url string, data map[string]interface{}) (int, map[string]interface{}) {
+		test.SignMap(data)
+		jsonData, _ := json.Marshal(data)
+		req := httptest.NewRequest(method, url, bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+
+		var result map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+
+	t.Run("Export another business", func(t *testing.T) {
+		status, _ := signedRequest("POST", fmt.Sprintf("/api/v1/exports/%d", otherId), map[string]interface{}{})
+		assert.Equal(t, fiber.StatusForbidden, status)
+	})
+
+	t.Run("Request export", func(t *testing.T) {
+		db.Exec("delete from business_exports where business_id = ?", ownId)
+
+		status, result := signedRequest("POST", fmt.Sprintf("/api/v1/exports/%d", ownId), map[string]interface{}{})
+		assert.Equal(t, fiber.StatusAccepted, status)
+		exportId = uint(result["result"].(map[string]interface{})["id"].(float64))
+
+		status, _ = signedRequest("POST", fmt.Sprintf("/api/v1/exports/%d", ownId), map[string]interface{}{})
+		assert.Equal(t, fiber.StatusConflict, status)
+	})
+
+	t.Run("Run export and download", func(t *testing.T) {
+		db.Exec("delete from tasks where name = ? and data = ?", models.ExportTaskName, fmt.Sprint(exportId))
+		assert.Nil(t, RunExport(db, exportId))
+
+		var export models.BusinessExport
+		db.First(&export, exportId)
+		assert.Equal(t, "ready", export.Status)
+		assert.NotZero(t, export.Size)
+
+		expires := export.ExpiresAt.Unix()
+		url := fmt.Sprintf("/api/v1/exports/%d/download?expires=%d&signature=%s", exportId, expires, services.ExportSignature(exportId, expires))
+
+		resp, err := app.Test(httptest.NewRequest("GET", url, nil), -1)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, resp.StatusCode)
+
+		// the expiry is part of the signature
+		url = fmt.Sprintf("/api/v1/exports/%d/download?expires=%d&signature=%s", exportId, expires+3600, services.ExportSignature(exportId, expires))
+		resp, err = app.Test(httptest.NewRequest("GET", url, nil), -1)
+		assert.Nil(t, err)
+		assert.Equal(t, fiber.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("Import into a new business", func(t *testing.T) {
+		var bundle bytes.Buffer
+		assert.Nil(t, services.BuildExport(db, ownId, &bundle))
+
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("bundle", "export.zip")
+		part.Write(bundle.Bytes())
+
+		data := map[string]interface{}{}
+		test.SignMap(data)
+		for key, value := range data {
+			writer.WriteField(key, fmt.Sprintf("%v", value))
+		}
+		writer.Close()
+
+		req := httptest.NewRequest("POST", "/api/v1/exports/import", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, resp.StatusCode)
+
+		var result map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		business := result["result"].(map[string]interface{})
+		assert.NotEqual(t, float64(ownId), business["id"])
+		assert.Equal(t, float64(3), business["userId"])
+
+		newId := uint(business["id"].(float64))
+		var locations, imported int64
+		db.Model(&models.Location{}).Where("business_id = ?", ownId).Count(&locations)
+		db.Model(&models.Location{}).Where("business_id = ?", newId).Count(&imported)
+		assert.Equal(t, locations, imported)
+
+		db.Exec("delete from locations where business_id = ?", newId)
+		db.Exec("delete from businesses where id = ?", newId)
+	})
+}
+
+// bundle a zip with the files of an export bundle
+func bundle(t *testing.T, files map[string]io.Reader) *zip.Reader {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, r := range files {
+		w, err := zw.Create(name)
+		assert.Nil(t, err)
+		_, err = io.Copy(w, r)
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, zw.Close())
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.Nil(t, err)
+	return zr
+}
+
+// zeros reads n zero bytes, compressed to almost nothing
+type zeros struct{ n int64 }
+
+func (z *zeros) Read(p []byte) (int, error) {
+	if z.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > z.n {
+		p = p[:z.n]
+	}
+	for i := range p {
+		p[i] = 0
+	}
+	z.n -= int64(len(p))
+	return len(p), nil
+}
+
+func TestImportBundleTooLarge(t *testing.T) {
+	zr := bundle(t, map[string]io.Reader{
+		"manifest.json": strings.NewReader(`{"version": 1}`),
+		"business.json": &zeros{n: 300 << 20},
+	})
+
+	// rejected from the zip headers before anything is read or written
+	_, err := services.ImportBundle(nil, zr, 3)
+	assert.ErrorIs(t, err, services.ErrBundleTooLarge)
+}
+
+func TestImportBundleCustomers(t *testing.T) {
+	_, db, err := test.SetupTestApp()
+	if err != nil {
+		t.Fatalf("Failed to setup test app: %v", err)
+	}
+
+	ownId, otherId := test.SetupTenants(db)
+
+	customers, _ := json.Marshal([]models.BusinessCustomer{{CustomerId: otherId}, {CustomerId: ownId}})
+	zr := bundle(t, map[string]io.Reader{
+		"manifest.json":  strings.NewReader(`{"version": 1}`),
+		"business.json":  strings.NewReader(`[{"name": "Imported customers"}]`),
+		"customers.json": bytes.NewReader(customers),
+	})
+
+	business, err := services.ImportBundle(db, zr, 3)
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		db.Exec("delete from business_customers where business_id = ?", business.ID)
+		db.Exec("delete from businesses where id = ?", business.ID)
+	})
+
+	// the business of another owner named in the bundle is not linked
+	var linked []uint
+	db.Model(&models.BusinessCustomer{}).Where("business_id = ?", business.ID).Pluck("customer_id", &linked)
+	assert.Equal(t, []uint{ownId}, linked)
+}
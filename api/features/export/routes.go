@@ -0,0 +1,37 @@
+package export
+
+import (
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+func businessIdParam(c *fiber.Ctx) uint {
+	id, _ := strconv.ParseUint(c.Params("bizid"), 10, 64)
+	return uint(id)
+}
+
+// routes prefixed with /api/v1/exports
+func ExportApiRoutes(app fiber.Router, db *gorm.DB) {
+
+	// download an export with the signed link sent by email
+	app.Get("/:id/download", func(c *fiber.Ctx) error {
+		return DownloadExport(c, db)
+	})
+
+	// restore an export bundle into a new business, multipart form with the ZIP in "bundle"
+	app.Post("/import", func(c *fiber.Ctx) error {
+		return ImportExport(c, db)
+	})
+
+	// request an export of the business data
+	app.Post("/:bizid", func(c *fiber.Ctx) error {
+		return RequestExport(c, db, businessIdParam(c))
+	})
+
+	// the exports of the business
+	app.Post("/:bizid/list", func(c *fiber.Ctx) error {
+		return GetExports(c, db, businessIdParam(c))
+	})
+}
@@ -0,0 +1,270 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"myproject/api/features/message"
+	"myproject/api/models"
+	"myproject/api/services"
+	"myproject/api/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// exportLinkTTL is how long the download link and the file of an export are kept
+const exportLinkTTL = 48 * time.Hour
+
+// exportDir is where the bundles are written, outside ./public
+const exportDir = "user_files/exports"
+
+// maxImportSize is the largest bundle accepted by ImportExport
+const maxImportSize = 100 << 20
+
+// downloadLink the signed link to download an export until it expires
+func downloadLink(export *models.BusinessExport) string {
+	expires := export.ExpiresAt.Unix()
+	return fmt.Sprintf("https://www.myproject.org/api/v1/app/exports/%d/download?expires=%d&signature=%s",
+		export.ID, expires, services.ExportSignature(export.ID, expires))
+}
+
+// RequestExport queue an export of a business, the owner gets an email with the download link when it is ready
+func RequestExport(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	user, err := services.VerifyFormSignature(db, c)
+	if err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	if !strings.Contains(user.Roles, "admin") && !services.IsBusinessOwner(db, user.ID, businessId) {
+		c.Status(fiber.StatusForbidden)
+		return utils.SendJsonResult(c, fiber.Map{"error": "Only the owner can export the business data"})
+	}
+
+	// one export at a time, unless the task of the previous one was lost
+	var running int64
+	db.Model(&models.BusinessExport{}).
+		Where("business_id = ? and status in ('pending', 'running') and created_at > now() - interval '1 hour'", businessId).
+		Count(&running)
+	if running > 0 {
+		c.Status(fiber.StatusConflict)
+		return utils.SendJsonResult(c, fiber.Map{"error": "An export of the business is already in progress"})
+	}
+
+	export := models.BusinessExport{BusinessId: businessId, UserId: user.ID, Status: "pending"}
+	if err := db.Create(&export).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	task := models.Task{
+		UserId:     user.ID,
+		BusinessId: businessId,
+		Name:       models.ExportTaskName,
+		Type:       "export",
+		Frequency:  "once",
+		Enabled:    true,
+		Data:       strconv.FormatUint(uint64(export.ID), 10),
+		RunAt:      time.Now(),
+	}
+	if err := db.Create(&task).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	c.Status(fiber.StatusAccepted)
+	return utils.SendJsonResult(c, export)
+}
+
+// GetExports list the exports of a business, most recent first
+func GetExports(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	user, err := services.VerifyFormSignature(db, c)
+	if err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	if !strings.Contains(user.Roles, "admin") && !services.IsBusinessOwner(db, user.ID, businessId) {
+		c.Status(fiber.StatusForbidden)
+		return utils.SendJsonResult(c, fiber.Map{"error": "Only the owner can export the business data"})
+	}
+
+	var exports []models.BusinessExport
+	db.Order("created_at desc").Limit(20).Find(&exports, "business_id = ?", businessId)
+
+	return utils.SendJsonResult(c, exports)
+}
+
+// DownloadExport send the ZIP of an export, the link is signed and expires so it can be sent by email
+func DownloadExport(c *fiber.Ctx, db *gorm.DB) error {
+
+	id, _ := strconv.ParseUint(c.Params("id"), 10, 64)
+	expires, _ := strconv.ParseInt(c.Query("expires"), 10, 64)
+
+	if !services.ValidExportSignature(uint(id), expires, c.Query("signature")) {
+		return c.Status(fiber.StatusForbidden).SendString("invalid download link")
+	}
+
+	if time.Now().Unix() > expires {
+		return c.Status(fiber.StatusGone).SendString("the download link has expired")
+	}
+
+	var export models.BusinessExport
+	if result := db.First(&export, id); errors.Is(result.Error, gorm.ErrRecordNotFound) || export.Status != "ready" {
+		return c.Status(fiber.StatusNotFound).SendString("export not found")
+	}
+
+	if _, err := os.Stat(export.File); err != nil {
+		return c.Status(fiber.StatusGone).SendString("the export has expired")
+	}
+
+	return c.Download(export.File, fmt.Sprintf("business-%d-export-%d.zip", export.BusinessId, export.ID))
+}
+
+// ImportExport restore an uploaded export bundle into a new business owned by the current user
+func ImportExport(c *fiber.Ctx, db *gorm.DB) error {
+
+	user, err := services.VerifyFormSignature(db, c)
+	if err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	fh, err := c.FormFile("bundle")
+	if err != nil {
+		fmt.Println(err)
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": "No export bundle given"})
+	}
+
+	if fh.Size > maxImportSize {
+		c.Status(fiber.StatusRequestEntityTooLarge)
+		return utils.SendJsonResult(c, fiber.Map{"error": "The export bundle is too large"})
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": services.ErrInvalidBundle.Error()})
+	}
+
+	business, err := services.ImportBundle(db, zr, user.ID)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidBundle) || errors.Is(err, services.ErrBundleTooLarge) {
+			c.Status(fiber.StatusNotAcceptable)
+			return utils.SendJsonResult(c, fiber.Map{"error": err.Error()})
+		}
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return utils.SendJsonResult(c, business)
+}
+
+// RunExport build the bundle of a pending export, email the owner the download link
+// and schedule the removal of the file when the link expires. Called by the task queue.
+func RunExport(db *gorm.DB, exportId uint) error {
+
+	var export models.BusinessExport
+	if err := db.First(&export, exportId).Error; err != nil {
+		return err
+	}
+
+	if export.Status != "pending" {
+		return nil
+	}
+
+	db.Model(&export).Update("status", "running")
+
+	fail := func(err error) error {
+		db.Model(&export).Updates(map[string]interface{}{"status": "failed", "error": err.Error()})
+		return err
+	}
+
+	if err := os.MkdirAll(exportDir, 0700); err != nil {
+		return fail(err)
+	}
+
+	path := fmt.Sprintf("%s/%s.zip", exportDir, utils.GenerateUUID())
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fail(err)
+	}
+
+	err = services.BuildExport(db, export.BusinessId, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return fail(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return fail(err)
+	}
+	expires := time.Now().Add(exportLinkTTL)
+
+	export.Status = "ready"
+	export.File = path
+	export.Size = info.Size()
+	export.ExpiresAt = &expires
+	if err := db.Save(&export).Error; err != nil {
+		return err
+	}
+
+	db.Create(&models.Task{
+		UserId:     export.UserId,
+		BusinessId: export.BusinessId,
+		Name:       models.ExportFileTaskName,
+		Type:       "export",
+		Frequency:  "once",
+		Enabled:    true,
+		Data:       path,
+		RunAt:      expires,
+	})
+
+	var user models.User
+	var business models.Business
+	db.First(&user, export.UserId)
+	db.Select("id", "name").First(&business, export.BusinessId)
+
+	msg := models.EmailMessage{
+		From:     "noreply@myproject.com",
+		FromName: "myproject Team",
+		Template: "templates/export_ready.html",
+		Subject:  fmt.Sprintf("Your %s data export is ready", business.Name),
+		To:       user.Name,
+		Email:    strings.TrimSpace(user.Email),
+	}
+
+	var templateData = map[string]string{
+		"Name":         user.Name,
+		"BusinessName": business.Name,
+		"Link":         downloadLink(&export),
+		"Expires":      expires.Format("2 Jan 2006 15:04 MST"),
+	}
+
+	fmt.Println("RunExport: send template /templates/export_ready.html to", user.Email)
+
+	return message.SendEmailWithMailyak(&msg, templateData)
+}
@@ -5,18 +5,30 @@ import (
 	"myproject/api/database"
 	"myproject/api/features/bins"
 	"myproject/api/features/business"
+	"myproject/api/features/contractor"
+	"myproject/api/features/customer"
+	"myproject/api/features/duplicate"
+	"myproject/api/features/export"
 	"myproject/api/features/feedback"
+	"myproject/api/features/flags"
+	"myproject/api/features/geography"
+	"myproject/api/features/hours"
+	"myproject/api/features/importer"
 	"myproject/api/features/inventory"
 	"myproject/api/features/location"
+	"myproject/api/features/profile"
+	"myproject/api/features/review"
+	"myproject/api/features/routing"
+	"myproject/api/features/site"
+	"myproject/api/features/spatial"
 	"myproject/api/features/team"
+	"myproject/api/features/trash"
 	"myproject/api/features/user"
-	"myproject/api/models"
 	"myproject/api/services"
 	"os"
 
 	"github.com/gofiber/fiber/v2"
 
-	"github.com/golang-jwt/jwt/v4"
 	"gorm.io/gorm"
 )
 
@@ -24,8 +36,13 @@ import (
 // but should all use user api key verification
 func SetupFeatureRoutes(db *gorm.DB, app fiber.Router, cfg database.ClusterConfig) {
 
+	// public business profiles on <uuid>.<PROFILE_DOMAIN>
+	app.Use(profile.ProfileHost(db))
+
 	user.UserPublicRoutes(app, db)
 
+	team.TeamPublicRoutes(app, db)
+
 	v1 := app.Group("/api/v1")
 
 	// duplicate the routes to add the /api/v1/app prefix
@@ -55,14 +72,42 @@ func setupFeatureRoutesInGroup(group fiber.Router, db *gorm.DB, cfg database.Clu
 
 	business.BusinessApiRoutes(group.Group("business"), db)
 
+	contractor.ContractorApiRoutes(group.Group("contractors"), db)
+
+	customer.CustomerApiRoutes(group.Group("customers"), db)
+
+	duplicate.DuplicateApiRoutes(group.Group("duplicates"), db)
+
+	export.ExportApiRoutes(group.Group("exports"), db)
+
 	feedback.FeedbackApiRoutes(group.Group("feedback"), db)
 
+	flags.FlagApiRoutes(group.Group("flags"), db)
+
+	geography.GeographyApiRoutes(group.Group("geography"), db)
+
+	hours.HoursApiRoutes(group.Group("hours"), db)
+
+	importer.ImportApiRoutes(group.Group("imports"), db)
+
 	inventory.InventoryApiRoutes(group.Group("inventory"), db)
 
 	location.LocationApiRoutes(group.Group("location"), db)
 
+	profile.ProfileApiRoutes(group.Group("profile"), db)
+
+	review.ReviewApiRoutes(group.Group("reviews"), db)
+
+	routing.RoutingApiRoutes(group.Group("routing"), db)
+
+	site.SiteApiRoutes(group.Group("sites"), db)
+
+	spatial.SpatialApiRoutes(group.Group("spatial"), db)
+
 	team.TeamApiRoutes(group.Group("team"), db)
 
+	trash.TrashApiRoutes(group.Group("trash"), db)
+
 	user.UserApiRoutes(group.Group("user"), db)
 
 	bins.BinApiRoutes(group.Group("bins"), db)
@@ -92,25 +137,6 @@ func SetupWebUIRoutes(app fiber.Router, db *gorm.DB) {
 
 }
 
-func setUserFromToken(db *gorm.DB, c *fiber.Ctx, token *jwt.Token) error {
-	claims := token.Claims.(jwt.MapClaims)
-	id := claims["user_id"]
-
-	// set the user in the context
-	var result models.User
-	if err := services.UserForId(db, id, &result); err != nil {
-		return err
-	}
-
-	//fmt.Println("setUserFromToken", result.ID, result.Name)
-	c.Locals("currentUser", result)
-
-	// recreate the token and set it in the cookie
-	services.SetupJWTtoken(result, c)
-
-	return c.Next()
-}
-
 /*
 func validJwtToken(c *fiber.Ctx) error {
 	// get the user ID from the JWT token
@@ -148,24 +174,10 @@ func invalidJwtToken(c *fiber.Ctx, e error) error {
 
 func jwtMiddlewareHandler(db *gorm.DB, c *fiber.Ctx) error {
 
-	var err error
-	var token *jwt.Token
-
-	cookie := c.Cookies(database.GetParam("jwt_cookie"))
-
-	//fmt.Println("jwtMiddlewareHandler", cookie)
-
-	if cookie == "" {
-		return invalidJwtToken(c, fmt.Errorf("no JWT cookie"))
-	}
-
-	token, err = jwt.Parse(cookie, func(token *jwt.Token) (interface{}, error) {
-		return []byte(database.GetParam("jwt_secret")), nil
-	})
-
-	if err != nil {
+	// the access token cookie, refreshed with the refresh token cookie when it expired
+	if _, err := services.SessionUser(db, c); err != nil {
 		return invalidJwtToken(c, err)
 	}
 
-	return setUserFromToken(db, c, token)
+	return c.Next()
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"mime/multipart"
+	"myproject/api/models"
 	"myproject/test"
 	"net/http/httptest"
 	"testing"
@@ -23,6 +24,44 @@ func setupFeedbackTestApp(t *testing.T) *fiber.App {
 	return app
 }
 
+func TestFeedbackTenantIsolation(t *testing.T) {
+	app, db, err := test.SetupTestApp()
+	if err != nil {
+		t.Fatalf("Failed to setup test app: %v", err)
+	}
+
+	api := app.Group("/api/v1")
+	FeedbackApiRoutes(api.Group("feedback"), db)
+
+	ownId, otherId := test.SetupTenants(db)
+
+	otherLocation := models.Location{BusinessId: otherId, Name: "Other tenant location"}
+	db.Create(&otherLocation)
+
+	t.Run("Feedback on a location of another business", func(t *testing.T) {
+		feedbackData := map[string]interface{}{
+			"type":        "Issue",
+			"category":    "Technical",
+			"title":       "Cross tenant issue",
+			"description": "Feedback on the location of another business",
+			"businessId":  ownId,
+			"locationId":  otherLocation.ID,
+		}
+
+		jsonData, _ := json.Marshal(feedbackData)
+		req := httptest.NewRequest("POST", "/api/v1/feedback", bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, fiber.StatusNotAcceptable, resp.StatusCode)
+
+		var count int64
+		db.Model(&models.Feedback{}).Where("location_id = ?", otherLocation.ID).Count(&count)
+		assert.Equal(t, int64(0), count)
+	})
+}
+
 func TestCreateFeedback(t *testing.T) {
 	app := setupFeedbackTestApp(t)
 
@@ -22,6 +22,17 @@ func CreateFeedback(c *fiber.Ctx, db *gorm.DB) error {
 		})
 	}
 
+	// the location must be one of the business, feedback is not attached to another tenant
+	if feedback.LocationId != 0 {
+		var others int64
+		db.Model(&models.Location{}).Where("id = ? and business_id <> ?", feedback.LocationId, feedback.BusinessId).Count(&others)
+		if others > 0 {
+			return c.Status(fiber.StatusNotAcceptable).JSON(fiber.Map{
+				"error": "Location does not belong to the business",
+			})
+		}
+	}
+
 	// Create feedback in database
 	result := db.Create(&feedback)
 	if result.Error != nil {
@@ -0,0 +1,124 @@
+package flags
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"myproject/api/database"
+	"myproject/api/services"
+	"myproject/test"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlags(t *testing.T) {
+	app, db, err := test.SetupTestApp()
+	if err != nil {
+		t.Fatalf("Failed to setup test app: %v", err)
+	}
+
+	api := app.Group("/api/v1")
+	FlagApiRoutes(api.Group("flags"), db)
+
+	// a route gated by a flag
+	api.Get("/gated/:bizid", services.RequireTenant(db, services.TenantFromParam("bizid")), services.RequireFeature(db, "test_gate"), func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	ownId, otherId := test.SetupTenants(db)
+
+	// the signer must be an admin
+	var roles string
+	db.Raw("select roles from users where id = 3").Scan(&roles)
+	db.Exec("update users set roles = 'admin' where id = 3")
+	database.SystemParams["flag_test_half"] = "50%"
+	database.SystemParams["flag_test_all"] = "on"
+	t.Cleanup(func() {
+		db.Exec("update users set roles = ? where id = 3", roles)
+		delete(database.SystemParams, "flag_test_half")
+		delete(database.SystemParams, "flag_test_all")
+		services.SetFeatureOverride(db, "business", ownId, "test_gate", "default")
+		services.SetFeatureOverride(db, "business", ownId, "test_all", "default")
+		services.SetFeatureOverride(db, "user", 3, "test_all", "default")
+	})
+
+	signedRequest := func(method, url string, data map[string]interface{}) (int, []byte) {
+		test.SignMap(data)
+		jsonData, _ := json.Marshal(data)
+		req := httptest.NewRequest(method, url, bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+
+		body := new(bytes.Buffer)
+		body.ReadFrom(resp.Body)
+		return resp.StatusCode, body.Bytes()
+	}
+
+	t.Run("Percentage rollout is stable and partial", func(t *testing.T) {
+		on := 0
+		for id := uint(1); id <= 1000; id++ {
+			subject := services.FlagSubject{BusinessId: id}
+			enabled := services.FeatureEnabled(db, "test_half", subject)
+			assert.Equal(t, enabled, services.FeatureEnabled(db, "test_half", subject))
+			if enabled {
+				on++
+			}
+		}
+		assert.InDelta(t, 500, on, 100)
+	})
+
+	t.Run("Gated route is off by default", func(t *testing.T) {
+		status, _ := signedRequest("GET", fmt.Sprintf("/api/v1/gated/%d", ownId), map[string]interface{}{})
+		assert.Equal(t, fiber.StatusNotFound, status)
+	})
+
+	t.Run("Turn a flag on for a business", func(t *testing.T) {
+		status, _ := signedRequest("PUT", fmt.Sprintf("/api/v1/flags/business/%d/test_gate/on", ownId), map[string]interface{}{})
+		assert.Equal(t, 200, status)
+
+		status, _ = signedRequest("GET", fmt.Sprintf("/api/v1/gated/%d", ownId), map[string]interface{}{})
+		assert.Equal(t, 200, status)
+
+		assert.False(t, services.FeatureEnabled(db, "test_gate", services.FlagSubject{BusinessId: otherId}))
+	})
+
+	t.Run("User override beats business override", func(t *testing.T) {
+		status, _ := signedRequest("PUT", fmt.Sprintf("/api/v1/flags/business/%d/test_all/off", ownId), map[string]interface{}{})
+		assert.Equal(t, 200, status)
+		assert.False(t, services.FeatureEnabled(db, "test_all", services.FlagSubject{BusinessId: ownId}))
+
+		status, _ = signedRequest("PUT", "/api/v1/flags/user/3/test_all/on", map[string]interface{}{})
+		assert.Equal(t, 200, status)
+		assert.True(t, services.FeatureEnabled(db, "test_all", services.FlagSubject{BusinessId: ownId, UserId: 3}))
+
+		status, body := signedRequest("POST", fmt.Sprintf("/api/v1/flags/business/%d", ownId), map[string]interface{}{})
+		assert.Equal(t, 200, status)
+
+		var result struct {
+			Result struct {
+				Features  map[string]bool `json:"features"`
+				Overrides []string        `json:"overrides"`
+			} `json:"result"`
+		}
+		json.Unmarshal(body, &result)
+		assert.False(t, result.Result.Features["test_all"])
+		assert.True(t, result.Result.Features["test_gate"])
+		assert.Contains(t, result.Result.Overrides, "-feature:test_all")
+	})
+
+	t.Run("Reject invalid flags", func(t *testing.T) {
+		status, _ := signedRequest("PUT", fmt.Sprintf("/api/v1/flags/business/%d/Bad-Name/on", ownId), map[string]interface{}{})
+		assert.Equal(t, fiber.StatusNotAcceptable, status)
+
+		status, _ = signedRequest("PUT", fmt.Sprintf("/api/v1/flags/part/%d/test_all/on", ownId), map[string]interface{}{})
+		assert.Equal(t, fiber.StatusNotAcceptable, status)
+
+		status, _ = signedRequest("PUT", fmt.Sprintf("/api/v1/flags/business/%d/test_all/maybe", ownId), map[string]interface{}{})
+		assert.Equal(t, fiber.StatusNotAcceptable, status)
+	})
+}
@@ -0,0 +1,34 @@
+package flags
+
+import (
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+func idParam(c *fiber.Ctx, name string) uint {
+	id, _ := strconv.ParseUint(c.Params(name), 10, 64)
+	return uint(id)
+}
+
+// routes prefixed with /api/v1/flags, for admins
+func FlagApiRoutes(app fiber.Router, db *gorm.DB) {
+
+	// the feature flags defined in the system params with their rollout
+	app.Post("/list", func(c *fiber.Ctx) error {
+		return GetGlobalFlags(c, db)
+	})
+
+	// the feature flags of a business, location or user and the overrides in its Flags array
+	// kind: business, location or user
+	app.Post("/:kind/:id", func(c *fiber.Ctx) error {
+		return GetFlags(c, db, c.Params("kind"), idParam(c, "id"))
+	})
+
+	// turn a feature flag on or off for a business, location or user
+	// state: on, off or default to follow the global flag
+	app.Put("/:kind/:id/:name/:state", func(c *fiber.Ctx) error {
+		return SetFlag(c, db, c.Params("kind"), idParam(c, "id"), c.Params("name"), c.Params("state"))
+	})
+}
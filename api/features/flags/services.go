@@ -0,0 +1,109 @@
+package flags
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"myproject/api/models"
+	"myproject/api/services"
+	"myproject/api/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// verifyAdmin check the request signature and that the user is an admin, writes the error response if not
+func verifyAdmin(c *fiber.Ctx, db *gorm.DB) (models.User, bool) {
+
+	user, err := services.VerifyFormSignature(db, c)
+	if err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return user, false
+	}
+
+	if !strings.Contains(user.Roles, "admin") {
+		c.Status(fiber.StatusForbidden)
+		utils.SendJsonResult(c, fiber.Map{"error": "Only admins can manage feature flags"})
+		return user, false
+	}
+
+	return user, true
+}
+
+// GetGlobalFlags list the feature flags defined in the system params, by name
+func GetGlobalFlags(c *fiber.Ctx, db *gorm.DB) error {
+
+	if _, ok := verifyAdmin(c, db); !ok {
+		return nil
+	}
+
+	flags := []services.FeatureFlag{}
+	for _, flag := range services.GlobalFlags() {
+		flags = append(flags, flag)
+	}
+	sort.Slice(flags, func(i, j int) bool { return flags[i].Name < flags[j].Name })
+
+	return utils.SendJsonResult(c, flags)
+}
+
+// flagSubject the subject a business, location or user is evaluated as,
+// a location inherits the overrides of its business
+func flagSubject(db *gorm.DB, kind string, id uint) (services.FlagSubject, error) {
+	switch kind {
+	case "business":
+		return services.FlagSubject{BusinessId: id}, nil
+	case "user":
+		return services.FlagSubject{UserId: id}, nil
+	case "location":
+		var location models.Location
+		if err := db.Select("id", "business_id").First(&location, id).Error; err != nil {
+			return services.FlagSubject{}, err
+		}
+		return services.FlagSubject{BusinessId: location.BusinessId, LocationId: id}, nil
+	}
+	return services.FlagSubject{}, fmt.Errorf("feature flags cannot be set for a %s", kind)
+}
+
+// GetFlags the state of the feature flags for a business, location or user with its overrides
+func GetFlags(c *fiber.Ctx, db *gorm.DB, kind string, id uint) error {
+
+	if _, ok := verifyAdmin(c, db); !ok {
+		return nil
+	}
+
+	subject, err := flagSubject(db, kind, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": fmt.Sprintf("No %s found with given ID", kind)})
+	} else if err != nil {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": err.Error()})
+	}
+
+	return utils.SendJsonResult(c, fiber.Map{
+		"features":  services.Features(db, subject),
+		"overrides": services.FlagsOf(db, kind, id),
+	})
+}
+
+// SetFlag turn a feature flag on or off for a business, location or user
+func SetFlag(c *fiber.Ctx, db *gorm.DB, kind string, id uint, name, state string) error {
+
+	if _, ok := verifyAdmin(c, db); !ok {
+		return nil
+	}
+
+	flags, err := services.SetFeatureOverride(db, kind, id, name, state)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": fmt.Sprintf("No %s found with given ID", kind)})
+	} else if err != nil {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": err.Error()})
+	}
+
+	return utils.SendJsonResult(c, flags)
+}
@@ -0,0 +1,250 @@
+package geography
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"myproject/api/models"
+	"myproject/api/services"
+	"myproject/test"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// the datasets use the user assigned code ZZ so the test does not touch real countries
+const testCountries = "#ISO\tISO3\tISO-Numeric\tfips\tCountry\n" +
+	"ZZ\tZZZ\t999\tZZ\tZedland\tZed City\t1000\t100000\tSA\t.zz\tZZD\tZed\t+999\t#####\t^(\\d{5})$\tes-ZZ,en\t990000000\t\t\n"
+
+const testStates = "ZZ.01\tNorte\tNorte\t990000010\n" +
+	"ZZ.02\tSur\tSur\t990000020\n"
+
+const testCities = "990000101\tBogotá\tBogota\tSanta Fe de Bogotá,Bogotá D.C.,Богота\t4.60971\t-74.08175\tP\tPPLC\tZZ\t\t01\t\t\t\t7674366\t2582\t2620\tAmerica/Bogota\t2025-01-01\n" +
+	"990000102\tMedellín\tMedellin\t\t6.25184\t-75.56359\tP\tPPLA\tZZ\t\t02\t\t\t\t2529403\t1495\t1500\tAmerica/Bogota\t2025-01-01\n" +
+	"990000103\tPueblito\tPueblito\t\t6.1\t-75.5\tP\tPPL\tZZ\t\t02\t\t\t\t50\t1495\t1500\tNot/AZone\t2025-01-01\n" +
+	"990000104\tCerro Alto\tCerro Alto\t\t6.2\t-75.4\tT\tMT\tZZ\t\t02\t\t\t\t0\t3000\t3000\tAmerica/Bogota\t2025-01-01\n" +
+	"not a line\n"
+
+func TestGeographyRecords(t *testing.T) {
+
+	t.Run("Tab separated dump", func(t *testing.T) {
+		var records []map[string]string
+		bad, err := services.EachGeographyRecord(strings.NewReader(testCities), models.GeographyCities, func(record map[string]string) error {
+			records = append(records, record)
+			return nil
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, 0, bad)
+		assert.Len(t, records, 5)
+		assert.Equal(t, "Bogotá", records[0]["name"])
+		assert.Equal(t, "Santa Fe de Bogotá,Bogotá D.C.,Богота", records[0]["alternatenames"])
+		assert.Equal(t, "America/Bogota", records[0]["timezone"])
+		assert.Equal(t, "02", records[1]["admin1_code"])
+	})
+
+	t.Run("Comments are skipped", func(t *testing.T) {
+		var records []map[string]string
+		_, err := services.EachGeographyRecord(strings.NewReader(testCountries), models.GeographyCountries, func(record map[string]string) error {
+			records = append(records, record)
+			return nil
+		})
+		assert.Nil(t, err)
+		assert.Len(t, records, 1)
+		assert.Equal(t, "ZZ", records[0]["iso"])
+		assert.Equal(t, "^(\\d{5})$", records[0]["postal_code_regex"])
+	})
+
+	t.Run("Comma separated with a header", func(t *testing.T) {
+		csv := "Geonameid,Name,Latitude,Longitude,Country Code,Population\n" +
+			"990000201,\"Cali, Valle\",3.43722,-76.5225,ZZ,2227642\n"
+
+		var records []map[string]string
+		_, err := services.EachGeographyRecord(strings.NewReader(csv), models.GeographyCities, func(record map[string]string) error {
+			records = append(records, record)
+			return nil
+		})
+		assert.Nil(t, err)
+		assert.Len(t, records, 1)
+		assert.Equal(t, "Cali, Valle", records[0]["name"])
+		assert.Equal(t, "ZZ", records[0]["country_code"])
+		assert.Equal(t, "2227642", records[0]["population"])
+	})
+
+	t.Run("Unknown kind", func(t *testing.T) {
+		_, err := services.EachGeographyRecord(strings.NewReader(""), "regions", nil)
+		assert.ErrorIs(t, err, services.ErrUnknownGeographyKind)
+	})
+
+	t.Run("Names compare without accents or case", func(t *testing.T) {
+		assert.Equal(t, "bogota d.c.", services.GeographyKey("  Bogotá   D.C. "))
+		assert.Equal(t, "USA", services.LocationCountryName("US", "United States"))
+		assert.Equal(t, "Colombia", services.LocationCountryName("CO", "Colombia"))
+	})
+}
+
+func TestLoadGeography(t *testing.T) {
+	app, db, err := test.SetupTestApp()
+	if err != nil {
+		t.Fatalf("Failed to setup test app: %v", err)
+	}
+
+	GeographyApiRoutes(app.Group("/api/v1/geography"), db)
+
+	cleanup := func() {
+		db.Exec("delete from cities where country_code = 'ZZ'")
+		db.Exec("delete from states where country_code = 'ZZ'")
+		db.Exec("delete from countries where code = 'ZZ'")
+		db.Exec("delete from geography_loads where country = 'ZZ'")
+	}
+	cleanup()
+
+	var roles string
+	db.Raw("select roles from users where id = 3").Scan(&roles)
+	t.Cleanup(func() {
+		db.Exec("update users set roles = ? where id = 3", roles)
+		cleanup()
+	})
+
+	upload := func(kind, dataset string) (int, models.GeographyLoad) {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("file", kind+".txt")
+		part.Write([]byte(dataset))
+
+		data := map[string]interface{}{"kind": kind, "country": "ZZ", "minPopulation": 100}
+		test.SignMap(data)
+		for key, value := range data {
+			writer.WriteField(key, fmt.Sprintf("%v", value))
+		}
+		writer.Close()
+
+		req := httptest.NewRequest("POST", "/api/v1/geography/load", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+
+		var result struct {
+			Result models.GeographyLoad `json:"result"`
+		}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result.Result
+	}
+
+	t.Run("Only admins load", func(t *testing.T) {
+		db.Exec("update users set roles = '' where id = 3")
+		status, _ := upload(models.GeographyCountries, testCountries)
+		assert.Equal(t, fiber.StatusForbidden, status)
+		db.Exec("update users set roles = 'admin' where id = 3")
+	})
+
+	t.Run("Load countries, states and cities", func(t *testing.T) {
+		status, load := upload(models.GeographyCountries, testCountries)
+		assert.Equal(t, 200, status)
+		assert.Equal(t, 1, load.Created)
+
+		status, load = upload(models.GeographyStates, testStates)
+		assert.Equal(t, 200, status)
+		assert.Equal(t, 2, load.Created)
+
+		status, load = upload(models.GeographyCities, testCities)
+		assert.Equal(t, 200, status)
+		assert.Equal(t, 2, load.Created) // the small town and the mountain are skipped
+		assert.Equal(t, 2, load.Skipped)
+		assert.Equal(t, 1, load.Errors)
+
+		var country models.Country
+		db.First(&country, "code = 'ZZ'")
+		assert.Equal(t, "ZZZ", country.Iso3)
+		assert.Equal(t, "999", country.Phone)
+
+		city, found := services.LookupCity(db, "zz", "SANTA FE DE BOGOTA")
+		assert.True(t, found)
+		assert.Equal(t, "Bogotá", city.City)
+		assert.Equal(t, "Bogota", city.NameAscii)
+		assert.Equal(t, "Norte", city.State)
+		assert.Equal(t, "Zedland", city.Country)
+		assert.Equal(t, "America/Bogota", city.Timezone)
+	})
+
+	t.Run("Load again only writes changes", func(t *testing.T) {
+		updated := strings.Replace(testCities, "2529403\t1495\t1500\tAmerica/Bogota\t2025-01-01", "2600000\t1495\t1500\tAmerica/Bogota\t2025-02-01", 1)
+		status, load := upload(models.GeographyCities, updated)
+		assert.Equal(t, 200, status)
+		assert.Equal(t, 0, load.Created)
+		assert.Equal(t, 1, load.Updated)
+
+		city, _ := services.LookupCity(db, "ZZ", "medellin")
+		assert.Equal(t, int64(2600000), city.Population)
+
+		status, load = upload(models.GeographyDeletes, "990000102\tMedellín\tduplicate\n")
+		assert.Equal(t, 200, status)
+		assert.Equal(t, 1, load.Deleted)
+
+		_, found := services.LookupCity(db, "ZZ", "medellin")
+		assert.False(t, found)
+	})
+
+	t.Run("Public lists", func(t *testing.T) {
+		resp, err := app.Test(httptest.NewRequest("GET", "/api/v1/geography/zz/states", nil), -1)
+		assert.Nil(t, err)
+		var states struct {
+			Result []models.State `json:"result"`
+		}
+		json.NewDecoder(resp.Body).Decode(&states)
+		assert.Len(t, states.Result, 2)
+
+		resp, err = app.Test(httptest.NewRequest("GET", "/api/v1/geography/ZZ/cities?name=bogo", nil), -1)
+		assert.Nil(t, err)
+		var cities struct {
+			Result []models.City `json:"result"`
+		}
+		json.NewDecoder(resp.Body).Decode(&cities)
+		assert.Len(t, cities.Result, 1)
+	})
+}
+
+func TestCheckAddress(t *testing.T) {
+	app := fiber.New()
+	GeographyApiRoutes(app.Group("/api/v1/geography"), nil)
+
+	check := func(address map[string]string) (int, map[string]interface{}) {
+		jsonData, _ := json.Marshal(address)
+		req := httptest.NewRequest("POST", "/api/v1/geography/address", bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+
+		var result map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+
+	status, result := check(map[string]string{"city": "cleveland", "province": "oh", "postcode": "44113", "country": "United States"})
+	assert.Equal(t, 200, status)
+	address := result["result"].(map[string]interface{})
+	assert.Equal(t, "USA", address["country"])
+	assert.Equal(t, "US", address["countryCode"])
+	assert.Equal(t, "OH", address["province"])
+
+	status, result = check(map[string]string{"city": "London", "postcode": "NOT A CODE", "country": "UK"})
+	assert.Equal(t, fiber.StatusNotAcceptable, status)
+	fields := result["result"].(map[string]interface{})["fields"].(map[string]interface{})
+	assert.Contains(t, fields, "postcode")
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/api/v1/geography/co/rules", nil), -1)
+	assert.Nil(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	var rules struct {
+		Result struct {
+			Currency  string            `json:"currency"`
+			Provinces map[string]string `json:"provinces"`
+		} `json:"result"`
+	}
+	json.NewDecoder(resp.Body).Decode(&rules)
+	assert.Equal(t, "COP", rules.Result.Currency)
+	assert.Equal(t, "Antioquia", rules.Result.Provinces["ANT"])
+}
@@ -0,0 +1,52 @@
+package geography
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// routes prefixed with /api/v1/geography
+//
+// The reference countries, states and cities loaded from GeoNames dumps or comma separated files,
+// also loaded by the cmd/geography command
+func GeographyApiRoutes(app fiber.Router, db *gorm.DB) {
+
+	// the countries with their ISO codes, currency, phone prefix and postal code format
+	app.Get("/countries", func(c *fiber.Ctx) error {
+		return GetCountries(c, db)
+	})
+
+	// the states of a country by ISO code
+	app.Get("/:country/states", func(c *fiber.Ctx) error {
+		return GetStates(c, db, c.Params("country"))
+	})
+
+	// the cities of a country by ISO code matching a name or an alternate name, accents and case ignored
+	// query: name, state (code) and limit
+	app.Get("/:country/cities", func(c *fiber.Ctx) error {
+		return GetCities(c, db, c.Params("country"))
+	})
+
+	// how the addresses of a country are written: the stored name, currency, locale, postal code example and provinces
+	app.Get("/:country/rules", func(c *fiber.Ctx) error {
+		return GetCountryRules(c, c.Params("country"))
+	})
+
+	// normalise and check an address by the rules of its country
+	// body: city, province, postcode and country
+	app.Post("/address", func(c *fiber.Ctx) error {
+		return CheckAddress(c)
+	})
+
+	// load a dataset, for admins
+	// multipart form with the dataset in "file", kind (countries, states, cities or deletes),
+	// country to only load one country of a dump of all and minPopulation to skip the smaller cities
+	app.Post("/load", func(c *fiber.Ctx) error {
+		return Load(c, db)
+	})
+
+	// the last loads of datasets, for admins
+	app.Post("/loads", func(c *fiber.Ctx) error {
+		return GetLoads(c, db)
+	})
+}
@@ -0,0 +1,180 @@
+package geography
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"myproject/api/models"
+	"myproject/api/services"
+	"myproject/api/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// the largest dataset uploaded, the dumps of all the cities are loaded with the command
+const maxDatasetSize = 200 << 20
+
+func sendError(c *fiber.Ctx, status int, message string) error {
+	c.Status(status)
+	return utils.SendJsonResult(c, fiber.Map{"error": message})
+}
+
+// verifyAdmin check the request signature and that the user is an admin, writes the error response if not
+func verifyAdmin(c *fiber.Ctx, db *gorm.DB) (models.User, bool) {
+
+	user, err := services.VerifyFormSignature(db, c)
+	if err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return user, false
+	}
+
+	if !strings.Contains(user.Roles, "admin") {
+		sendError(c, fiber.StatusForbidden, "Only admins can load reference geography")
+		return user, false
+	}
+
+	return user, true
+}
+
+// GetCountries the countries ordered by name
+func GetCountries(c *fiber.Ctx, db *gorm.DB) error {
+
+	countries := []models.Country{}
+	db.Order("name").Find(&countries)
+
+	return utils.SendJsonResult(c, countries)
+}
+
+// GetStates the states of a country ordered by name
+func GetStates(c *fiber.Ctx, db *gorm.DB, country string) error {
+
+	states := []models.State{}
+	db.Order("name").Find(&states, "country_code = ?", strings.ToUpper(country))
+
+	return utils.SendJsonResult(c, states)
+}
+
+// GetCities the most populated cities of a country whose name or alternate name starts with the name given
+func GetCities(c *fiber.Ctx, db *gorm.DB, country string) error {
+
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	query := db.Where("country_code = ?", strings.ToUpper(country))
+	if state := c.Query("state"); state != "" {
+		query = query.Where("code = ?", state)
+	}
+	if key := services.GeographyKey(c.Query("name")); key != "" {
+		// the names are keys without accents so a prefix matches any of them
+		query = query.Where("exists (select 1 from unnest(search_names) n where n like ?)", key+"%")
+	}
+
+	cities := []models.City{}
+	query.Order("population desc, city").Limit(limit).Find(&cities)
+
+	return utils.SendJsonResult(c, cities)
+}
+
+// GetCountryRules the address rules of a country by ISO code or name
+func GetCountryRules(c *fiber.Ctx, country string) error {
+
+	rules, ok := utils.CountryRulesFor(country)
+	if !ok {
+		return sendError(c, fiber.StatusNotFound, "No rules found for the country")
+	}
+
+	return utils.SendJsonResult(c, fiber.Map{
+		"code":          rules.Code,
+		"iso3":          rules.Iso3,
+		"name":          rules.Name,
+		"currency":      rules.Currency,
+		"locale":        rules.Locale,
+		"postalExample": rules.PostalExample,
+		"provinces":     rules.Provinces,
+	})
+}
+
+// CheckAddress the address written the way its country does, with the invalid fields
+func CheckAddress(c *fiber.Ctx) error {
+
+	var address utils.Address
+	if err := c.BodyParser(&address); err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	address, err := utils.ValidateAddress(address)
+	if err != nil {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": err.Error(), "fields": err, "address": address})
+	}
+
+	return utils.SendJsonResult(c, address)
+}
+
+// Load read an uploaded dataset into the reference geography
+func Load(c *fiber.Ctx, db *gorm.DB) error {
+
+	user, ok := verifyAdmin(c, db)
+	if !ok {
+		return nil
+	}
+
+	kind := c.FormValue("kind")
+	if !slices.Contains([]string{models.GeographyCountries, models.GeographyStates, models.GeographyCities, models.GeographyDeletes}, kind) {
+		return sendError(c, fiber.StatusNotAcceptable, services.ErrUnknownGeographyKind.Error())
+	}
+
+	country := c.FormValue("country")
+	if country != "" && len(country) != 2 {
+		return sendError(c, fiber.StatusNotAcceptable, "country must be an ISO 3166-1 alpha-2 code")
+	}
+	minPopulation, _ := strconv.ParseInt(c.FormValue("minPopulation"), 10, 64)
+
+	fh, err := c.FormFile("file")
+	if err != nil {
+		return sendError(c, fiber.StatusNotAcceptable, "no file given")
+	}
+	if fh.Size > maxDatasetSize {
+		return sendError(c, fiber.StatusNotAcceptable, "the file is too large, load it with the geography command")
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		return sendError(c, fiber.StatusInternalServerError, err.Error())
+	}
+	defer f.Close()
+
+	load, err := services.LoadGeography(db, kind, f, services.GeographyOptions{
+		Country:       country,
+		MinPopulation: minPopulation,
+		Source:        filepath.Base(fh.Filename),
+		UserId:        user.ID,
+	})
+	if err != nil {
+		fmt.Println(err)
+		return sendError(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	return utils.SendJsonResult(c, load)
+}
+
+// GetLoads the last 100 loads of datasets
+func GetLoads(c *fiber.Ctx, db *gorm.DB) error {
+
+	if _, ok := verifyAdmin(c, db); !ok {
+		return nil
+	}
+
+	loads := []models.GeographyLoad{}
+	db.Order("id desc").Limit(100).Find(&loads)
+
+	return utils.SendJsonResult(c, loads)
+}
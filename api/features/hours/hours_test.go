@@ -0,0 +1,132 @@
+package hours
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"myproject/api/models"
+	"myproject/test"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsOpenAt(t *testing.T) {
+	bogota, _ := time.LoadLocation("America/Bogota")
+
+	hours := []models.OpeningHours{
+		{Weekday: 1, Opens: "08:00", Closes: "17:00"}, // Monday
+		{Weekday: 5, Opens: "20:00", Closes: "02:00"}, // Friday night
+	}
+	exceptions := []models.OpeningException{
+		{Date: "2025-12-22", Closed: true, Name: "Holiday"},
+	}
+
+	tests := []struct {
+		name     string
+		at       time.Time
+		expected bool
+	}{
+		{"Monday morning", time.Date(2025, 12, 15, 9, 0, 0, 0, bogota), true},
+		{"Monday evening", time.Date(2025, 12, 15, 18, 0, 0, 0, bogota), false},
+		{"Monday morning in UTC", time.Date(2025, 12, 15, 12, 0, 0, 0, time.UTC), false},
+		{"Friday night", time.Date(2025, 12, 19, 23, 0, 0, 0, bogota), true},
+		{"Saturday after midnight", time.Date(2025, 12, 20, 1, 30, 0, 0, bogota), true},
+		{"Friday after midnight", time.Date(2025, 12, 19, 1, 30, 0, 0, bogota), false},
+		{"Holiday Monday", time.Date(2025, 12, 22, 9, 0, 0, 0, bogota), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, models.IsOpenAt(hours, exceptions, tt.at, bogota))
+		})
+	}
+}
+
+func TestHoursAndServiceAreas(t *testing.T) {
+	app, db, err := test.SetupTestApp()
+	if err != nil {
+		t.Fatalf("Failed to setup test app: %v", err)
+	}
+
+	api := app.Group("/api/v1")
+	HoursApiRoutes(api.Group("hours"), db)
+
+	ownId, otherId := test.SetupTenants(db)
+
+	signedRequest := func(method, url string, data map[string]interface{}) (int, map[string]interface{}) {
+		test.SignMap(data)
+		jsonData, _ := json.Marshal(data)
+		req := httptest.NewRequest(method, url, bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+
+		var result map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+
+	t.Run("Set weekly hours", func(t *testing.T) {
+		status, _ := signedRequest("PUT", fmt.Sprintf("/api/v1/hours/%d/weekly", ownId), map[string]interface{}{
+			"hours": []map[string]interface{}{
+				{"weekday": 1, "opens": "08:00", "closes": "17:00"},
+				{"weekday": 2, "opens": "08:00", "closes": "17:00"},
+			},
+		})
+		assert.Equal(t, 200, status)
+
+		var count int64
+		db.Model(&models.OpeningHours{}).Where("business_id = ? and location_id = 0", ownId).Count(&count)
+		assert.Equal(t, int64(2), count)
+	})
+
+	t.Run("Set invalid weekly hours", func(t *testing.T) {
+		status, _ := signedRequest("PUT", fmt.Sprintf("/api/v1/hours/%d/weekly", ownId), map[string]interface{}{
+			"hours": []map[string]interface{}{
+				{"weekday": 1, "opens": "8am", "closes": "17:00"},
+			},
+		})
+		assert.Equal(t, fiber.StatusNotAcceptable, status)
+	})
+
+	t.Run("Add holiday", func(t *testing.T) {
+		status, _ := signedRequest("POST", fmt.Sprintf("/api/v1/hours/%d/exceptions", ownId), map[string]interface{}{
+			"date":   "2030-12-25",
+			"name":   "Christmas",
+			"closed": true,
+		})
+		assert.Equal(t, 200, status)
+	})
+
+	t.Run("Add service areas", func(t *testing.T) {
+		status, _ := signedRequest("POST", fmt.Sprintf("/api/v1/hours/%d/areas", ownId), map[string]interface{}{
+			"kind":     "radius",
+			"radiusKm": 25,
+		})
+		assert.Equal(t, 200, status)
+
+		status, _ = signedRequest("POST", fmt.Sprintf("/api/v1/hours/%d/areas", ownId), map[string]interface{}{
+			"kind":    "polygon",
+			"geojson": `{"type":"Polygon","coordinates":[[[-75.7,4.8],[-75.6,4.8],[-75.6,4.9],[-75.7,4.9],[-75.7,4.8]]]}`,
+		})
+		assert.Equal(t, 200, status)
+
+		status, _ = signedRequest("POST", fmt.Sprintf("/api/v1/hours/%d/areas", ownId), map[string]interface{}{
+			"kind":    "polygon",
+			"geojson": `{"type":"Point","coordinates":[-75.7,4.8]}`,
+		})
+		assert.Equal(t, fiber.StatusNotAcceptable, status)
+	})
+
+	t.Run("Set hours of another business", func(t *testing.T) {
+		status, _ := signedRequest("PUT", fmt.Sprintf("/api/v1/hours/%d/weekly", otherId), map[string]interface{}{
+			"hours": []map[string]interface{}{},
+		})
+		assert.Equal(t, fiber.StatusForbidden, status)
+	})
+}
@@ -0,0 +1,61 @@
+package hours
+
+import (
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"myproject/api/services"
+)
+
+func businessIdParam(c *fiber.Ctx) uint {
+	id, _ := strconv.ParseUint(c.Params("bizid"), 10, 64)
+	return uint(id)
+}
+
+// routes prefixed with /api/v1/hours
+func HoursApiRoutes(app fiber.Router, db *gorm.DB) {
+
+	tenant := services.RequireTenant(db, services.TenantFromParam("bizid"))
+
+	// get the opening hours, holidays and service areas of a business
+	app.Get("/:bizid", tenant, func(c *fiber.Ctx) error {
+		return GetHours(c, services.TenantDB(db, c), businessIdParam(c))
+	})
+	app.Post("/:bizid/list", tenant, func(c *fiber.Ctx) error {
+		return GetHours(c, services.TenantDB(db, c), businessIdParam(c))
+	})
+
+	// replace the weekly hours of the business or a location
+	app.Put("/:bizid/weekly", tenant, func(c *fiber.Ctx) error {
+		return SetWeeklyHours(c, services.TenantDB(db, c), businessIdParam(c))
+	})
+
+	// check if the business or a location is open
+	app.Post("/:bizid/open", tenant, func(c *fiber.Ctx) error {
+		return IsOpen(c, services.TenantDB(db, c), businessIdParam(c))
+	})
+
+	////////////////  HOLIDAYS	//////////////////////
+	app.Post("/:bizid/exceptions", tenant, func(c *fiber.Ctx) error {
+		return CreateException(c, services.TenantDB(db, c), businessIdParam(c))
+	})
+
+	app.Delete("/:bizid/exceptions/:id", tenant, func(c *fiber.Ctx) error {
+		return DeleteException(c, services.TenantDB(db, c), businessIdParam(c))
+	})
+
+	////////////////  SERVICE AREAS	//////////////////////
+	app.Post("/:bizid/areas", tenant, func(c *fiber.Ctx) error {
+		return CreateServiceArea(c, services.TenantDB(db, c), businessIdParam(c))
+	})
+
+	app.Put("/:bizid/areas/:id", tenant, func(c *fiber.Ctx) error {
+		return UpdateServiceArea(c, services.TenantDB(db, c), businessIdParam(c))
+	})
+
+	app.Delete("/:bizid/areas/:id", tenant, func(c *fiber.Ctx) error {
+		return DeleteServiceArea(c, services.TenantDB(db, c), businessIdParam(c))
+	})
+}
@@ -0,0 +1,346 @@
+package hours
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"myproject/api/models"
+	"myproject/api/services"
+	"myproject/api/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+// managerForBusiness verify the request is signed by the owner or an admin of the business
+func managerForBusiness(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	user, err := services.VerifyFormSignature(db, c)
+	if err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return err
+	}
+
+	if !services.CanManageBusiness(db, user, businessId) {
+		c.Status(fiber.StatusForbidden)
+		utils.SendJsonResult(c, fiber.Map{"error": "Only the owner or an admin can change the opening hours"})
+		return fiber.ErrForbidden
+	}
+
+	return nil
+}
+
+// checkLocation verify the location belongs to the business, 0 is the whole business
+func checkLocation(c *fiber.Ctx, db *gorm.DB, businessId, locationId uint) bool {
+	if locationId == 0 {
+		return true
+	}
+
+	var location models.Location
+	result := db.First(&location, "id = ? and business_id = ?", locationId, businessId)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		c.Status(fiber.StatusNotAcceptable)
+		utils.SendJsonResult(c, fiber.Map{"error": "No Location found with given ID"})
+		return false
+	}
+
+	return true
+}
+
+// GetHours get the weekly hours, exceptions and service areas of a business and its locations
+func GetHours(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	var hours []models.OpeningHours
+	db.Order("location_id, weekday, opens").Find(&hours, "business_id = ?", businessId)
+
+	var exceptions []models.OpeningException
+	db.Order("date").Find(&exceptions, "business_id = ? and date >= current_date", businessId)
+
+	var areas []models.ServiceArea
+	db.Order("id").Find(&areas, "business_id = ?", businessId)
+
+	return utils.SendJsonResult(c, fiber.Map{
+		"hours":      hours,
+		"exceptions": exceptions,
+		"areas":      areas,
+	})
+}
+
+// SetWeeklyHours replace the weekly hours of the business or of one of its locations
+func SetWeeklyHours(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	if err := managerForBusiness(c, db, businessId); err != nil {
+		return nil
+	}
+
+	type WeeklyRequest struct {
+		LocationId uint                  `json:"locationId"`
+		Hours      []models.OpeningHours `json:"hours"`
+	}
+
+	req := new(WeeklyRequest)
+	if err := c.BodyParser(req); err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return err
+	}
+
+	if !checkLocation(c, db, businessId, req.LocationId) {
+		return nil
+	}
+
+	for i, h := range req.Hours {
+		if h.Weekday < 0 || h.Weekday > 6 {
+			c.Status(fiber.StatusNotAcceptable)
+			return utils.SendJsonResult(c, fiber.Map{"error": "weekday must be 0 (Sunday) to 6 (Saturday)"})
+		}
+		if err := models.ValidateClock(h.Opens, h.Closes); err != nil {
+			c.Status(fiber.StatusNotAcceptable)
+			return utils.SendJsonResult(c, fiber.Map{"error": err.Error()})
+		}
+
+		req.Hours[i].ID = 0
+		req.Hours[i].BusinessId = businessId
+		req.Hours[i].LocationId = req.LocationId
+	}
+
+	tx := db.Clauses(dbresolver.Write).Begin()
+
+	if err := tx.Where("business_id = ? and location_id = ?", businessId, req.LocationId).Delete(&models.OpeningHours{}).Error; err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	if len(req.Hours) > 0 {
+		if err := tx.Create(&req.Hours).Error; err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return utils.SendJsonResult(c, req.Hours)
+}
+
+// CreateException add a holiday or a change to the hours on a date
+func CreateException(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	if err := managerForBusiness(c, db, businessId); err != nil {
+		return nil
+	}
+
+	exception := new(models.OpeningException)
+	if err := c.BodyParser(exception); err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return err
+	}
+
+	if _, err := time.Parse("2006-01-02", exception.Date); err != nil {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": "date must be YYYY-MM-DD"})
+	}
+
+	if !exception.Closed {
+		if err := models.ValidateClock(exception.Opens, exception.Closes); err != nil {
+			c.Status(fiber.StatusNotAcceptable)
+			return utils.SendJsonResult(c, fiber.Map{"error": err.Error()})
+		}
+	}
+
+	if !checkLocation(c, db, businessId, exception.LocationId) {
+		return nil
+	}
+
+	if exception.Kind == "" {
+		exception.Kind = "holiday"
+	}
+
+	exception.ID = 0
+	exception.BusinessId = businessId
+
+	if err := db.Create(exception).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return utils.SendJsonResult(c, exception)
+}
+
+func DeleteException(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	if err := managerForBusiness(c, db, businessId); err != nil {
+		return nil
+	}
+
+	var exception models.OpeningException
+	result := db.First(&exception, "business_id = ? and id = ?", businessId, c.Params("id"))
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": "No Exception found with given ID"})
+	}
+
+	db.Delete(&exception)
+
+	return utils.SendJsonResult(c, exception)
+}
+
+// validateArea check the kind, radius and polygon of a service area
+func validateArea(c *fiber.Ctx, area *models.ServiceArea) bool {
+
+	switch area.Kind {
+	case "", "radius":
+		area.Kind = "radius"
+		area.GeoJSON = ""
+		if area.RadiusKm <= 0 {
+			c.Status(fiber.StatusNotAcceptable)
+			utils.SendJsonResult(c, fiber.Map{"error": "radiusKm must be greater than 0"})
+			return false
+		}
+	case "polygon":
+		area.RadiusKm = 0
+
+		var geometry struct {
+			Type        string          `json:"type"`
+			Coordinates json.RawMessage `json:"coordinates"`
+		}
+		if err := json.Unmarshal([]byte(area.GeoJSON), &geometry); err != nil || geometry.Type != "Polygon" {
+			c.Status(fiber.StatusNotAcceptable)
+			utils.SendJsonResult(c, fiber.Map{"error": "geojson must be a GeoJSON Polygon geometry"})
+			return false
+		}
+	default:
+		c.Status(fiber.StatusNotAcceptable)
+		utils.SendJsonResult(c, fiber.Map{"error": "kind must be radius or polygon"})
+		return false
+	}
+
+	return true
+}
+
+// CreateServiceArea add a polygon or a radius covered by the business
+func CreateServiceArea(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	if err := managerForBusiness(c, db, businessId); err != nil {
+		return nil
+	}
+
+	area := new(models.ServiceArea)
+	if err := c.BodyParser(area); err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return err
+	}
+
+	if !validateArea(c, area) || !checkLocation(c, db, businessId, area.LocationId) {
+		return nil
+	}
+
+	area.ID = 0
+	area.BusinessId = businessId
+
+	if err := db.Create(area).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return utils.SendJsonResult(c, area)
+}
+
+func UpdateServiceArea(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	if err := managerForBusiness(c, db, businessId); err != nil {
+		return nil
+	}
+
+	var area models.ServiceArea
+	result := db.First(&area, "business_id = ? and id = ?", businessId, c.Params("id"))
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": "No Service Area found with given ID"})
+	}
+
+	if err := c.BodyParser(&area); err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return err
+	}
+
+	if !validateArea(c, &area) || !checkLocation(c, db, businessId, area.LocationId) {
+		return nil
+	}
+
+	area.BusinessId = businessId
+
+	if err := db.Save(&area).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return utils.SendJsonResult(c, area)
+}
+
+func DeleteServiceArea(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	if err := managerForBusiness(c, db, businessId); err != nil {
+		return nil
+	}
+
+	var area models.ServiceArea
+	result := db.First(&area, "business_id = ? and id = ?", businessId, c.Params("id"))
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": "No Service Area found with given ID"})
+	}
+
+	db.Delete(&area)
+
+	return utils.SendJsonResult(c, area)
+}
+
+// IsOpen check if the business, or one of its locations, is open now or at a given time
+func IsOpen(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	type OpenRequest struct {
+		LocationId uint       `json:"locationId"`
+		At         *time.Time `json:"at"`
+	}
+
+	req := new(OpenRequest)
+	if err := c.BodyParser(req); err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return err
+	}
+
+	at := time.Now()
+	if req.At != nil {
+		at = *req.At
+	}
+
+	if req.LocationId == 0 {
+		var business models.Business
+		db.First(&business, businessId)
+
+		return utils.SendJsonResult(c, fiber.Map{"open": services.IsBusinessOpenAt(db, business, at), "at": at})
+	}
+
+	var location models.Location
+	result := db.First(&location, "id = ? and business_id = ?", req.LocationId, businessId)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": "No Location found with given ID"})
+	}
+
+	tz := services.LocationTimezone(location)
+
+	return utils.SendJsonResult(c, fiber.Map{
+		"open":     services.IsLocationOpenAt(db, location, at),
+		"at":       at.In(tz),
+		"timezone": tz.String(),
+	})
+}
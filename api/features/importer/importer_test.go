@@ -0,0 +1,215 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"myproject/api/models"
+	"myproject/api/services"
+	"myproject/test"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+const locationsCSV = "Store,Name,Street,City,Zip,Email\n" +
+	"S1,North Store,1 Main St,Springfield,12345,north@example.com\n" +
+	"S2,South Store,2 Main St,Springfield,12346,not-an-email\n" +
+	"S1,North Again,9 Side St,Springfield,12399,\n" +
+	",,,,,\n" +
+	"S3,,3 Main St,Springfield,12347,\n"
+
+var locationsMapping = services.ImportMapping{
+	HeaderRow: 1,
+	Columns: map[string][]string{
+		"identity": {"Store"}, "name": {"Name"}, "address": {"Street"}, "city": {"City"}, "postcode": {"zip"}, "email": {"Email"},
+	},
+	Defaults: map[string]string{"country": "USA"},
+}
+
+func TestMapImportRows(t *testing.T) {
+	data, err := services.ReadImportRows(strings.NewReader("\xef\xbb\xbf"+locationsCSV), "stores.csv", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "Store", data[0][0])
+
+	rows, err := services.MapImportRows("location", locationsMapping, data)
+	assert.Nil(t, err)
+
+	// the header and the blank row are left out
+	assert.Len(t, rows, 4)
+	assert.Equal(t, 2, rows[0].Line)
+	assert.Equal(t, "North Store", rows[0].Fields["name"])
+	assert.Equal(t, "USA", rows[0].Fields["country"])
+	assert.Empty(t, rows[0].Errors)
+	assert.Equal(t, []string{"invalid email not-an-email"}, rows[1].Errors)
+	assert.Equal(t, []string{"name is required"}, rows[3].Errors)
+
+	// columns by letter, joined
+	rows, err = services.MapImportRows("location", services.ImportMapping{
+		HeaderRow: 1,
+		Columns:   map[string][]string{"name": {"B"}, "address": {"C", "D"}},
+		Skip:      map[string][]string{"A": {"S2"}},
+	}, data)
+	assert.Nil(t, err)
+	assert.Len(t, rows, 3)
+	assert.Equal(t, "1 Main St, Springfield", rows[0].Fields["address"])
+
+	_, err = services.MapImportRows("location", services.ImportMapping{Columns: map[string][]string{"colour": {"A"}}}, data)
+	assert.NotNil(t, err)
+	_, err = services.MapImportRows("area", services.ImportMapping{Columns: map[string][]string{"name": {"A"}}}, data)
+	assert.NotNil(t, err)
+	_, err = services.MapImportRows("location", services.ImportMapping{HeaderRow: 1, Columns: map[string][]string{"name": {"Missing"}}}, data)
+	assert.NotNil(t, err)
+}
+
+func TestLocationImport(t *testing.T) {
+	app, db, err := test.SetupTestApp()
+	if err != nil {
+		t.Fatalf("Failed to setup test app: %v", err)
+	}
+
+	api := app.Group("/api/v1")
+	ImportApiRoutes(api.Group("imports"), db)
+
+	ownId, otherId := test.SetupTenants(db)
+
+	signedRequest := func(method, url string, data map[string]interface{}) (int, map[string]interface{}) {
+		test.SignMap(data)
+		jsonData, _ := json.Marshal(data)
+		req := httptest.NewRequest(method, url, bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+
+		var result map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+
+	uploadRequest := func(url, file string, fields map[string]interface{}) (int, map[string]interface{}) {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("file", "stores.csv")
+		part.Write([]byte(file))
+
+		test.SignMap(fields)
+		for key, value := range fields {
+			writer.WriteField(key, fmt.Sprintf("%v", value))
+		}
+		writer.Close()
+
+		req := httptest.NewRequest("POST", url, body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+
+		var result map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+
+	var profileId uint
+	var jobId uint
+
+	t.Run("Save profile", func(t *testing.T) {
+		status, _ := signedRequest("POST", fmt.Sprintf("/api/v1/imports/%d/profiles", ownId), map[string]interface{}{
+			"name": "Stores", "target": "location", "mapping": map[string]interface{}{"columns": map[string]interface{}{"colour": []string{"A"}}},
+		})
+		assert.Equal(t, fiber.StatusNotAcceptable, status)
+
+		status, result := signedRequest("POST", fmt.Sprintf("/api/v1/imports/%d/profiles", ownId), map[string]interface{}{
+			"name": "Stores", "target": "location", "mapping": locationsMapping,
+		})
+		assert.Equal(t, 200, status)
+		profileId = uint(result["result"].(map[string]interface{})["id"].(float64))
+
+		// saving again by name replaces the profile
+		status, result = signedRequest("POST", fmt.Sprintf("/api/v1/imports/%d/profiles", ownId), map[string]interface{}{
+			"name": "Stores", "target": "location", "mapping": locationsMapping,
+		})
+		assert.Equal(t, 200, status)
+		assert.Equal(t, float64(profileId), result["result"].(map[string]interface{})["id"])
+
+		status, _ = signedRequest("POST", fmt.Sprintf("/api/v1/imports/%d/profiles/list", otherId), map[string]interface{}{})
+		assert.Equal(t, fiber.StatusForbidden, status)
+	})
+
+	t.Run("Preview", func(t *testing.T) {
+		status, result := uploadRequest(fmt.Sprintf("/api/v1/imports/%d/preview", ownId), locationsCSV, map[string]interface{}{"profileId": profileId})
+		assert.Equal(t, 200, status)
+
+		preview := result["result"].(map[string]interface{})
+		counts := preview["counts"].(map[string]interface{})
+		assert.Equal(t, float64(4), counts["total"])
+		assert.Equal(t, float64(1), counts["created"])
+		assert.Equal(t, float64(3), counts["failed"])
+
+		rows := preview["rows"].([]interface{})
+		assert.Equal(t, []interface{}{"duplicate of line 2"}, rows[2].(map[string]interface{})["errors"])
+
+		var count int64
+		db.Model(&models.Location{}).Where("business_id = ?", ownId).Count(&count)
+		assert.Equal(t, int64(0), count)
+	})
+
+	t.Run("Import and report", func(t *testing.T) {
+		status, result := uploadRequest(fmt.Sprintf("/api/v1/imports/%d", ownId), locationsCSV, map[string]interface{}{"profileId": profileId})
+		assert.Equal(t, 200, status)
+
+		job := result["result"].(map[string]interface{})
+		jobId = uint(job["id"].(float64))
+		assert.Equal(t, "complete", job["status"])
+		assert.Equal(t, float64(1), job["created"])
+		assert.Equal(t, float64(3), job["failed"])
+
+		var location models.Location
+		db.First(&location, "business_id = ? and identity = ?", ownId, "S1")
+		assert.Equal(t, "North Store", location.Name)
+		assert.Contains(t, []string(location.Flags), "imported")
+
+		data := map[string]interface{}{}
+		test.SignMap(data)
+		jsonData, _ := json.Marshal(data)
+		req := httptest.NewRequest("POST", fmt.Sprintf("/api/v1/imports/job/%d/report", jobId), bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, resp.StatusCode)
+		report, _ := io.ReadAll(resp.Body)
+		assert.Contains(t, string(report), "invalid email not-an-email")
+	})
+
+	t.Run("Update existing", func(t *testing.T) {
+		file := "Store,Name,Street,City,Zip,Email\nS1,North Store Renamed,,,,\n"
+
+		status, result := uploadRequest(fmt.Sprintf("/api/v1/imports/%d", ownId), file, map[string]interface{}{"profileId": profileId})
+		assert.Equal(t, 200, status)
+		assert.Equal(t, float64(1), result["result"].(map[string]interface{})["skipped"])
+
+		status, result = uploadRequest(fmt.Sprintf("/api/v1/imports/%d", ownId), file, map[string]interface{}{"profileId": profileId, "mode": "update"})
+		assert.Equal(t, 200, status)
+		assert.Equal(t, float64(1), result["result"].(map[string]interface{})["updated"])
+
+		// the unmapped and empty fields are kept
+		var location models.Location
+		db.First(&location, "business_id = ? and identity = ?", ownId, "S1")
+		assert.Equal(t, "North Store Renamed", location.Name)
+		assert.Equal(t, "1 Main St", location.Address)
+
+		status, result = signedRequest("POST", fmt.Sprintf("/api/v1/imports/%d/jobs", ownId), map[string]interface{}{})
+		assert.Equal(t, 200, status)
+		assert.Len(t, result["result"], 3)
+	})
+
+	db.Exec("delete from locations where business_id = ?", ownId)
+	db.Exec("delete from import_jobs where business_id = ?", ownId)
+	db.Exec("delete from import_profiles where business_id = ?", ownId)
+	db.Exec("delete from tasks where name = ? and business_id = ?", models.ImportFileTaskName, ownId)
+}
@@ -0,0 +1,54 @@
+package importer
+
+import (
+	"strconv"
+
+	"myproject/api/services"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+func idParam(c *fiber.Ctx, name string) uint {
+	id, _ := strconv.ParseUint(c.Params(name), 10, 64)
+	return uint(id)
+}
+
+// routes prefixed with /api/v1/imports
+func ImportApiRoutes(app fiber.Router, db *gorm.DB) {
+
+	// the error report of an import as CSV
+	app.Post("/job/:id/report", services.RequireTenant(db, services.TenantFromRecord("import_jobs", "id")), func(c *fiber.Ctx) error {
+		return DownloadReport(c, db, idParam(c, "id"))
+	})
+
+	// the builtin and saved mapping profiles of the business
+	app.Post("/:bizid/profiles/list", services.RequireTenant(db, services.TenantFromParam("bizid")), func(c *fiber.Ctx) error {
+		return GetProfiles(c, db, idParam(c, "bizid"))
+	})
+
+	// save a mapping profile, replacing the profile with the same name
+	app.Post("/:bizid/profiles", services.RequireTenant(db, services.TenantFromParam("bizid")), func(c *fiber.Ctx) error {
+		return SaveProfile(c, db, idParam(c, "bizid"))
+	})
+
+	app.Delete("/:bizid/profiles/:id", services.RequireTenant(db, services.TenantFromParam("bizid")), func(c *fiber.Ctx) error {
+		return DeleteProfile(c, db, idParam(c, "bizid"), idParam(c, "id"))
+	})
+
+	// dry run of an import: the mapped rows and what would happen to them, nothing is written
+	// multipart form with the XLSX or CSV in "file" and profileId, profile (a builtin) or mapping (JSON)
+	app.Post("/:bizid/preview", services.RequireTenant(db, services.TenantFromParam("bizid")), func(c *fiber.Ctx) error {
+		return PreviewImport(c, db, idParam(c, "bizid"))
+	})
+
+	// the imports of the business, most recent first
+	app.Post("/:bizid/jobs", services.RequireTenant(db, services.TenantFromParam("bizid")), func(c *fiber.Ctx) error {
+		return GetJobs(c, db, idParam(c, "bizid"))
+	})
+
+	// import a file, same form as the preview, large files are imported in the background
+	app.Post("/:bizid", services.RequireTenant(db, services.TenantFromParam("bizid")), func(c *fiber.Ctx) error {
+		return StartImport(c, db, idParam(c, "bizid"))
+	})
+}
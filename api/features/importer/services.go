@@ -0,0 +1,437 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"myproject/api/models"
+	"myproject/api/services"
+	"myproject/api/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// importDir is where the uploaded files and the error reports are kept, outside ./public
+const importDir = "user_files/imports"
+
+// maxImportSize is the largest file accepted
+const maxImportSize = 20 << 20
+
+// inlineImportRows files with more rows are imported by the task queue
+const inlineImportRows = 300
+
+// previewRows how many planned rows a preview returns
+const previewRows = 50
+
+// reportTTL is how long the error report of an import is kept
+const reportTTL = 7 * 24 * time.Hour
+
+// importRequest the mapping of an upload: a saved profile, a builtin profile or a mapping given with the file
+type importRequest struct {
+	ProfileId uint   `json:"profileId" form:"profileId"`
+	Profile   string `json:"profile" form:"profile"`
+	Mapping   string `json:"mapping" form:"mapping"`
+	Target    string `json:"target" form:"target"`
+	Mode      string `json:"mode" form:"mode"` // create or update, create by default
+}
+
+// upload the rows of the uploaded file and the mapping to apply to them
+type upload struct {
+	Target    string
+	Mode      string
+	ProfileId uint
+	Mapping   services.ImportMapping
+	FileName  string
+	Data      []byte
+	Rows      [][]string
+}
+
+func sendError(c *fiber.Ctx, status int, message string) error {
+	c.Status(status)
+	return utils.SendJsonResult(c, fiber.Map{"error": message})
+}
+
+// readUpload read the file and the mapping of an import form
+func readUpload(c *fiber.Ctx, db *gorm.DB, businessId uint) (*upload, error) {
+
+	req := new(importRequest)
+	if err := c.BodyParser(req); err != nil {
+		return nil, err
+	}
+
+	u := &upload{Target: req.Target, Mode: req.Mode, ProfileId: req.ProfileId}
+	if u.Mode == "" {
+		u.Mode = "create"
+	}
+	if !slices.Contains(models.ImportModes, u.Mode) {
+		return nil, errors.New("mode must be create or update")
+	}
+
+	switch {
+	case req.ProfileId > 0:
+		var profile models.ImportProfile
+		if err := services.TenantDB(db, c).First(&profile, "id = ? and business_id = ?", req.ProfileId, businessId).Error; err != nil {
+			return nil, errors.New("no import profile with given ID")
+		}
+		if err := json.Unmarshal(profile.Mapping, &u.Mapping); err != nil {
+			return nil, err
+		}
+		u.Target = profile.Target
+	case req.Profile != "":
+		mapping, ok := services.BuiltinImportProfiles[req.Profile]
+		if !ok {
+			return nil, fmt.Errorf("no builtin import profile %s", req.Profile)
+		}
+		u.Mapping = mapping
+		u.Target = "location"
+	case req.Mapping != "":
+		if err := json.Unmarshal([]byte(req.Mapping), &u.Mapping); err != nil {
+			return nil, errors.New("the mapping is not valid JSON")
+		}
+	default:
+		return nil, errors.New("give a profileId, a profile or a mapping")
+	}
+
+	if u.Target == "" {
+		u.Target = "location"
+	}
+	if err := u.Mapping.Validate(u.Target); err != nil {
+		return nil, err
+	}
+
+	fh, err := c.FormFile("file")
+	if err != nil {
+		return nil, errors.New("no file given")
+	}
+	if fh.Size > maxImportSize {
+		return nil, errors.New("the file is too large")
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if u.Data, err = io.ReadAll(f); err != nil {
+		return nil, err
+	}
+	u.FileName = filepath.Base(fh.Filename)
+
+	if u.Rows, err = services.ReadImportRows(bytes.NewReader(u.Data), u.FileName, u.Mapping.Sheet); err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// GetProfiles the builtin profiles and the profiles saved by the business
+func GetProfiles(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	if _, err := services.VerifyFormSignature(db, c); err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	var profiles []models.ImportProfile
+	services.TenantDB(db, c).Order("name").Find(&profiles, "business_id = ?", businessId)
+
+	return utils.SendJsonResult(c, fiber.Map{"builtin": services.BuiltinImportProfiles, "profiles": profiles, "fields": services.ImportFields})
+}
+
+// SaveProfile create or replace the mapping profile with the given name
+func SaveProfile(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	user, err := services.VerifyFormSignature(db, c)
+	if err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	var body struct {
+		Name    string                 `json:"name"`
+		Target  string                 `json:"target"`
+		Mapping services.ImportMapping `json:"mapping"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	body.Name = strings.TrimSpace(body.Name)
+	if body.Name == "" {
+		return sendError(c, fiber.StatusNotAcceptable, "The profile needs a name")
+	}
+	if body.Target == "" {
+		body.Target = "location"
+	}
+	if err := body.Mapping.Validate(body.Target); err != nil {
+		return sendError(c, fiber.StatusNotAcceptable, err.Error())
+	}
+
+	mapping, _ := json.Marshal(body.Mapping)
+
+	tdb := services.TenantDB(db, c)
+	profile := models.ImportProfile{BusinessId: businessId, Name: body.Name}
+	tdb.Where("business_id = ? and name = ?", businessId, body.Name).Take(&profile)
+
+	profile.Target = body.Target
+	profile.Mapping = datatypes.JSON(mapping)
+	profile.UpdatedBy = user.ID
+	if err := tdb.Save(&profile).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return utils.SendJsonResult(c, profile)
+}
+
+func DeleteProfile(c *fiber.Ctx, db *gorm.DB, businessId, id uint) error {
+
+	if _, err := services.VerifyFormSignature(db, c); err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	result := services.TenantDB(db, c).Delete(&models.ImportProfile{}, "id = ? and business_id = ?", id, businessId)
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(result.Error.Error())
+	}
+	if result.RowsAffected == 0 {
+		return sendError(c, fiber.StatusNotAcceptable, "No import profile found with given ID")
+	}
+
+	return utils.SendJsonResult(c, fiber.Map{"deleted": id})
+}
+
+// PreviewImport map and plan the rows of a file without writing anything
+func PreviewImport(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	if _, err := services.VerifyFormSignature(db, c); err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	u, err := readUpload(c, db, businessId)
+	if err != nil {
+		return sendError(c, fiber.StatusNotAcceptable, err.Error())
+	}
+
+	rows, err := services.MapImportRows(u.Target, u.Mapping, u.Rows)
+	if err != nil {
+		return sendError(c, fiber.StatusNotAcceptable, err.Error())
+	}
+	services.PlanImport(services.TenantDB(db, c), businessId, u.Target, u.Mode, u.Mapping.DedupeOn, rows)
+
+	headers := []string{}
+	if u.Mapping.HeaderRow > 0 && u.Mapping.HeaderRow <= len(u.Rows) {
+		headers = u.Rows[u.Mapping.HeaderRow-1]
+	}
+
+	return utils.SendJsonResult(c, fiber.Map{
+		"target":  u.Target,
+		"mode":    u.Mode,
+		"headers": headers,
+		"counts":  services.PlanCounts(rows),
+		"rows":    rows[:min(len(rows), previewRows)],
+	})
+}
+
+// StartImport save the file and import it, in the background when it is large
+func StartImport(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	user, err := services.VerifyFormSignature(db, c)
+	if err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	u, err := readUpload(c, db, businessId)
+	if err != nil {
+		return sendError(c, fiber.StatusNotAcceptable, err.Error())
+	}
+
+	if err := os.MkdirAll(importDir, 0700); err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+	path := fmt.Sprintf("%s/%s%s", importDir, utils.GenerateUUID(), strings.ToLower(filepath.Ext(u.FileName)))
+	if err := os.WriteFile(path, u.Data, 0600); err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	mapping, _ := json.Marshal(u.Mapping)
+	job := models.ImportJob{
+		BusinessId: businessId,
+		UserId:     user.ID,
+		ProfileId:  u.ProfileId,
+		Target:     u.Target,
+		Mode:       u.Mode,
+		Mapping:    datatypes.JSON(mapping),
+		FileName:   u.FileName,
+		File:       path,
+		Status:     "pending",
+	}
+	if err := db.Create(&job).Error; err != nil {
+		os.Remove(path)
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	if len(u.Rows) <= inlineImportRows {
+		if err := RunImport(db, job.ID); err != nil {
+			fmt.Println("RunImport", job.ID, err)
+		}
+		db.First(&job, job.ID)
+		return utils.SendJsonResult(c, job)
+	}
+
+	task := models.Task{
+		UserId:     user.ID,
+		BusinessId: businessId,
+		Name:       models.ImportTaskName,
+		Type:       "import",
+		Frequency:  "once",
+		Enabled:    true,
+		Data:       strconv.FormatUint(uint64(job.ID), 10),
+		RunAt:      time.Now(),
+	}
+	if err := db.Create(&task).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	c.Status(fiber.StatusAccepted)
+	return utils.SendJsonResult(c, job)
+}
+
+// GetJobs the imports of a business, most recent first
+func GetJobs(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	if _, err := services.VerifyFormSignature(db, c); err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	var jobs []models.ImportJob
+	services.TenantDB(db, c).Order("created_at desc").Limit(20).Find(&jobs, "business_id = ?", businessId)
+
+	return utils.SendJsonResult(c, jobs)
+}
+
+// DownloadReport send the CSV of the rows an import could not write
+func DownloadReport(c *fiber.Ctx, db *gorm.DB, id uint) error {
+
+	if _, err := services.VerifyFormSignature(db, c); err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	var job models.ImportJob
+	if result := services.TenantDB(db, c).First(&job, id); errors.Is(result.Error, gorm.ErrRecordNotFound) || job.Report == "" {
+		return c.Status(fiber.StatusNotFound).SendString("report not found")
+	}
+
+	if _, err := os.Stat(job.Report); err != nil {
+		return c.Status(fiber.StatusGone).SendString("the report has expired")
+	}
+
+	return c.Download(job.Report, fmt.Sprintf("import-%d-errors.csv", job.ID))
+}
+
+// RunImport import the file of a pending job, write the report of the rows that failed
+// and schedule its removal. Called by the task queue, or directly for small files.
+func RunImport(db *gorm.DB, jobId uint) error {
+
+	var job models.ImportJob
+	if err := db.First(&job, jobId).Error; err != nil {
+		return err
+	}
+
+	if job.Status != "pending" {
+		return nil
+	}
+
+	db.Model(&job).Update("status", "running")
+
+	// the file is not needed once the job has run
+	defer func() {
+		os.Remove(job.File)
+		db.Model(&job).Update("file", "")
+	}()
+
+	fail := func(err error) error {
+		db.Model(&job).Updates(map[string]interface{}{"status": "failed", "error": err.Error()})
+		return err
+	}
+
+	var mapping services.ImportMapping
+	if err := json.Unmarshal(job.Mapping, &mapping); err != nil {
+		return fail(err)
+	}
+
+	f, err := os.Open(job.File)
+	if err != nil {
+		return fail(err)
+	}
+	data, err := services.ReadImportRows(f, job.FileName, mapping.Sheet)
+	f.Close()
+	if err != nil {
+		return fail(err)
+	}
+
+	rows, err := services.MapImportRows(job.Target, mapping, data)
+	if err != nil {
+		return fail(err)
+	}
+
+	var business models.Business
+	if err := db.First(&business, job.BusinessId).Error; err != nil {
+		return fail(err)
+	}
+
+	services.PlanImport(db, business.ID, job.Target, job.Mode, mapping.DedupeOn, rows)
+
+	counts, err := services.ApplyImport(db, business, job.Target, rows)
+	if err != nil {
+		return fail(err)
+	}
+
+	if counts.Failed > 0 {
+		path := fmt.Sprintf("%s/%s-report.csv", importDir, utils.GenerateUUID())
+		if report, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600); err == nil {
+			err = services.WriteImportReport(report, rows)
+			report.Close()
+			if err == nil {
+				job.Report = path
+				db.Create(&models.Task{
+					UserId:     job.UserId,
+					BusinessId: job.BusinessId,
+					Name:       models.ImportFileTaskName,
+					Type:       "import",
+					Frequency:  "once",
+					Enabled:    true,
+					Data:       path,
+					RunAt:      time.Now().Add(reportTTL),
+				})
+			}
+		}
+	}
+
+	return db.Model(&job).Updates(map[string]interface{}{
+		"status":  "complete",
+		"report":  job.Report,
+		"total":   counts.Total,
+		"created": counts.Created,
+		"updated": counts.Updated,
+		"skipped": counts.Skipped,
+		"failed":  counts.Failed,
+	}).Error
+}
@@ -31,7 +31,7 @@ func InventoryApiRoutes(app fiber.Router, db *gorm.DB) {
 // It defines endpoints for creating, updating, deleting, and get parts.
 func routerParts(app fiber.Router, db *gorm.DB) {
 	// create a new Part
-	app.Post("/parts", func(c *fiber.Ctx) error {
+	app.Post("/parts", services.RequireTenant(db, services.TenantFromBody()), func(c *fiber.Ctx) error {
 		if os.Getenv("USE_DOCKER") == "true" {
 			// no-op - allow all requests
 		} else {
@@ -45,7 +45,7 @@ func routerParts(app fiber.Router, db *gorm.DB) {
 	})
 
 	// update a part
-	app.Put("/parts/:id", func(c *fiber.Ctx) error {
+	app.Put("/parts/:id", services.RequireTenant(db, services.TenantFromRecord("parts", "id")), func(c *fiber.Ctx) error {
 		if os.Getenv("USE_DOCKER") == "true" {
 			// no-op - allow all requests
 		} else {
@@ -59,7 +59,7 @@ func routerParts(app fiber.Router, db *gorm.DB) {
 	})
 
 	// delete a part
-	app.Delete("/parts/:id", func(c *fiber.Ctx) error {
+	app.Delete("/parts/:id", services.RequireTenant(db, services.TenantFromRecord("parts", "id")), func(c *fiber.Ctx) error {
 		if os.Getenv("USE_DOCKER") == "true" {
 			// no-op - allow all requests
 		} else {
@@ -73,7 +73,7 @@ func routerParts(app fiber.Router, db *gorm.DB) {
 	})
 
 	// get a distinct list of parts for a business
-	app.Post("/:bizid/parts_list", func(c *fiber.Ctx) error {
+	app.Post("/:bizid/parts_list", services.RequireTenant(db, services.TenantFromParam("bizid")), func(c *fiber.Ctx) error {
 		if os.Getenv("USE_DOCKER") == "true" {
 			// no-op - allow all requests
 		} else {
@@ -87,12 +87,12 @@ func routerParts(app fiber.Router, db *gorm.DB) {
 	})
 
 	// get a distinct list of parts for a business
-	app.Get("/:bizid/parts_list", func(c *fiber.Ctx) error {
+	app.Get("/:bizid/parts_list", services.RequireTenantRead(db, services.TenantFromParam("bizid")), func(c *fiber.Ctx) error {
 		return GetPartsList(c, db, "any")
 	})
 
 	// get the list of parts by category
-	app.Post("/:bizid/parts_list/:category", func(c *fiber.Ctx) error {
+	app.Post("/:bizid/parts_list/:category", services.RequireTenant(db, services.TenantFromParam("bizid")), func(c *fiber.Ctx) error {
 		if os.Getenv("USE_DOCKER") == "true" {
 			// no-op - allow all requests
 		} else {
@@ -106,12 +106,12 @@ func routerParts(app fiber.Router, db *gorm.DB) {
 	})
 
 	// get the list of parts by category
-	app.Get("/:bizid/parts_list/:category", func(c *fiber.Ctx) error {
+	app.Get("/:bizid/parts_list/:category", services.RequireTenantRead(db, services.TenantFromParam("bizid")), func(c *fiber.Ctx) error {
 		return GetPartsList(c, db, c.Params("category"))
 	})
 
 	// add part stock to a bin
-	app.Post("/stock/parts", func(c *fiber.Ctx) error {
+	app.Post("/stock/parts", services.RequireTenant(db, services.TenantFromBody()), func(c *fiber.Ctx) error {
 		if os.Getenv("USE_DOCKER") == "true" {
 			// no-op - allow all requests
 		} else {
@@ -125,7 +125,7 @@ func routerParts(app fiber.Router, db *gorm.DB) {
 	})
 
 	// update parts stock in a bin
-	app.Put("/stock/parts", func(c *fiber.Ctx) error {
+	app.Put("/stock/parts", services.RequireTenant(db, services.TenantFromBody()), func(c *fiber.Ctx) error {
 		if os.Getenv("USE_DOCKER") == "true" {
 			// no-op - allow all requests
 		} else {
@@ -143,7 +143,7 @@ func routerParts(app fiber.Router, db *gorm.DB) {
 // It defines endpoints for creating, updating, deleting, and get consumables.
 func routerConsumables(app fiber.Router, db *gorm.DB) {
 	// create a new consumable
-	app.Post("/consumables", func(c *fiber.Ctx) error {
+	app.Post("/consumables", services.RequireTenant(db, services.TenantFromBody()), func(c *fiber.Ctx) error {
 		if os.Getenv("USE_DOCKER") == "true" {
 			// no-op - allow all requests
 		} else {
@@ -157,7 +157,7 @@ func routerConsumables(app fiber.Router, db *gorm.DB) {
 	})
 
 	// update a consumable
-	app.Put("/consumables/:id", func(c *fiber.Ctx) error {
+	app.Put("/consumables/:id", services.RequireTenant(db, services.TenantFromRecord("consumables", "id")), func(c *fiber.Ctx) error {
 		if os.Getenv("USE_DOCKER") == "true" {
 			// no-op - allow all requests
 		} else {
@@ -171,7 +171,7 @@ func routerConsumables(app fiber.Router, db *gorm.DB) {
 	})
 
 	// delete a consumable
-	app.Delete("/consumables/:id", func(c *fiber.Ctx) error {
+	app.Delete("/consumables/:id", services.RequireTenant(db, services.TenantFromRecord("consumables", "id")), func(c *fiber.Ctx) error {
 		if os.Getenv("USE_DOCKER") == "true" {
 			// no-op - allow all requests
 		} else {
@@ -185,7 +185,7 @@ func routerConsumables(app fiber.Router, db *gorm.DB) {
 	})
 
 	// get a distinct list of consumables for a business
-	app.Post("/:bizid/consumables_list", func(c *fiber.Ctx) error {
+	app.Post("/:bizid/consumables_list", services.RequireTenant(db, services.TenantFromParam("bizid")), func(c *fiber.Ctx) error {
 		if os.Getenv("USE_DOCKER") == "true" {
 			// no-op - allow all requests
 		} else {
@@ -199,12 +199,12 @@ func routerConsumables(app fiber.Router, db *gorm.DB) {
 	})
 
 	// get a distinct list of consumables for a business, avoid verification
-	app.Get("/:bizid/consumables_list", func(c *fiber.Ctx) error {
+	app.Get("/:bizid/consumables_list", services.RequireTenantRead(db, services.TenantFromParam("bizid")), func(c *fiber.Ctx) error {
 		return GetConsumablesList(c, db, "any")
 	})
 
 	// get the list of consumables by category
-	app.Post("/:bizid/consumables_list/:category", func(c *fiber.Ctx) error {
+	app.Post("/:bizid/consumables_list/:category", services.RequireTenant(db, services.TenantFromParam("bizid")), func(c *fiber.Ctx) error {
 		if os.Getenv("USE_DOCKER") == "true" {
 			// no-op - allow all requests
 		} else {
@@ -218,12 +218,12 @@ func routerConsumables(app fiber.Router, db *gorm.DB) {
 	})
 
 	// get the list of consumables by category, avoid verification
-	app.Get("/:bizid/consumables_list/:category", func(c *fiber.Ctx) error {
+	app.Get("/:bizid/consumables_list/:category", services.RequireTenantRead(db, services.TenantFromParam("bizid")), func(c *fiber.Ctx) error {
 		return GetConsumablesList(c, db, c.Params("category"))
 	})
 
 	// add consumable stock to a bin
-	app.Post("/stock/consumables", func(c *fiber.Ctx) error {
+	app.Post("/stock/consumables", services.RequireTenant(db, services.TenantFromBody()), func(c *fiber.Ctx) error {
 		if os.Getenv("USE_DOCKER") == "true" {
 			// no-op - allow all requests
 		} else {
@@ -237,7 +237,7 @@ func routerConsumables(app fiber.Router, db *gorm.DB) {
 	})
 
 	// update consumable stock in a bin
-	app.Put("/stock/consumables", func(c *fiber.Ctx) error {
+	app.Put("/stock/consumables", services.RequireTenant(db, services.TenantFromBody()), func(c *fiber.Ctx) error {
 		if os.Getenv("USE_DOCKER") == "true" {
 			// no-op - allow all requests
 		} else {
@@ -255,7 +255,7 @@ func routerConsumables(app fiber.Router, db *gorm.DB) {
 // It defines endpoints for get brands.
 func routerBrands(app fiber.Router, db *gorm.DB) {
 	// get a distinct list of equipment brands for a business
-	app.Post("/:bizid/brands_list", func(c *fiber.Ctx) error {
+	app.Post("/:bizid/brands_list", services.RequireTenant(db, services.TenantFromParam("bizid")), func(c *fiber.Ctx) error {
 		if os.Getenv("USE_DOCKER") == "true" {
 			// no-op - allow all requests
 		} else {
@@ -269,12 +269,12 @@ func routerBrands(app fiber.Router, db *gorm.DB) {
 	})
 
 	// get a distinct list of equipment brands for a business, avoid verification
-	app.Get("/:bizid/brands_list", func(c *fiber.Ctx) error {
+	app.Get("/:bizid/brands_list", services.RequireTenantRead(db, services.TenantFromParam("bizid")), func(c *fiber.Ctx) error {
 		return GetBrandsList(c, db, "any")
 	})
 
 	// get a distinct list of equipment brands for a business by category
-	app.Post("/:bizid/brands_list/:category", func(c *fiber.Ctx) error {
+	app.Post("/:bizid/brands_list/:category", services.RequireTenant(db, services.TenantFromParam("bizid")), func(c *fiber.Ctx) error {
 		if os.Getenv("USE_DOCKER") == "true" {
 			// no-op - allow all requests
 		} else {
@@ -288,7 +288,7 @@ func routerBrands(app fiber.Router, db *gorm.DB) {
 	})
 
 	// get a distinct list of equipment brands for a business by category, avoid verification
-	app.Get("/:bizid/brands_list/:category", func(c *fiber.Ctx) error {
+	app.Get("/:bizid/brands_list/:category", services.RequireTenantRead(db, services.TenantFromParam("bizid")), func(c *fiber.Ctx) error {
 		return GetBrandsList(c, db, c.Params("category"))
 	})
 }
@@ -296,7 +296,7 @@ func routerBrands(app fiber.Router, db *gorm.DB) {
 // routerTransfer sets up routes for transferring stock items between bins, including parts and consumables.
 func routerTransfer(app fiber.Router, db *gorm.DB) {
 	// transfer stock parts between bins
-	app.Post("/transfer/parts/:fromBin/:toBin", func(c *fiber.Ctx) error {
+	app.Post("/transfer/parts/:fromBin/:toBin", services.RequireTenant(db, services.TenantFromRecord("bins", "fromBin")), func(c *fiber.Ctx) error {
 		if os.Getenv("USE_DOCKER") == "true" {
 			// no-op - allow all requests
 		} else {
@@ -310,7 +310,7 @@ func routerTransfer(app fiber.Router, db *gorm.DB) {
 	})
 
 	// transfer stock consumables between bins
-	app.Post("/transfer/consumables/:fromBin/:toBin", func(c *fiber.Ctx) error {
+	app.Post("/transfer/consumables/:fromBin/:toBin", services.RequireTenant(db, services.TenantFromRecord("bins", "fromBin")), func(c *fiber.Ctx) error {
 		if os.Getenv("USE_DOCKER") == "true" {
 			// no-op - allow all requests
 		} else {
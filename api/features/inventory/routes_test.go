@@ -741,3 +741,53 @@ func TestTransferConsumablesStockBin(t *testing.T) {
 		assert.Equal(t, 6, len(updatedToBin.Consumables), "The consumables were not transferred correctly")
 	})
 }
+
+func TestInventoryTenantIsolation(t *testing.T) {
+	app := setupInventoryTestApp(t)
+
+	ownId, otherId := test.SetupTenants(setupDB)
+
+	otherPart := models.Part{BusinessId: otherId, Name: "Other tenant part"}
+	setupDB.Create(&otherPart)
+	otherBin := models.Bin{BusinessId: otherId, Name: "Other tenant bin"}
+	setupDB.Create(&otherBin)
+	ownBin := models.Bin{BusinessId: ownId, Name: "Own tenant bin"}
+	setupDB.Create(&ownBin)
+
+	signedRequest := func(method, url string, data map[string]interface{}) *fiber.Map {
+		test.SignMap(data)
+		jsonData, _ := json.Marshal(data)
+		req := httptest.NewRequest(method, url, bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, fiber.StatusForbidden, resp.StatusCode, url)
+
+		var result fiber.Map
+		json.NewDecoder(resp.Body).Decode(&result)
+		return &result
+	}
+
+	t.Run("List parts of another business", func(t *testing.T) {
+		signedRequest("POST", fmt.Sprintf("/api/v1/inventory/%d/parts_list", otherId), map[string]interface{}{})
+	})
+
+	t.Run("Update part of another business", func(t *testing.T) {
+		signedRequest("PUT", fmt.Sprintf("/api/v1/inventory/parts/%d", otherPart.ID),
+			map[string]interface{}{"id": otherPart.ID, "businessId": ownId, "name": "Hijacked"})
+
+		var part models.Part
+		setupDB.First(&part, otherPart.ID)
+		assert.Equal(t, otherId, part.BusinessId)
+		assert.Equal(t, otherPart.Name, part.Name)
+	})
+
+	t.Run("Delete part of another business", func(t *testing.T) {
+		signedRequest("DELETE", fmt.Sprintf("/api/v1/inventory/parts/%d", otherPart.ID), map[string]interface{}{})
+	})
+
+	t.Run("Transfer stock out of another business bin", func(t *testing.T) {
+		signedRequest("POST", fmt.Sprintf("/api/v1/inventory/transfer/parts/%d/%d", otherBin.ID, ownBin.ID), map[string]interface{}{})
+	})
+}
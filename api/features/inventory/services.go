@@ -4,6 +4,7 @@ import (
 	"strconv"
 
 	"myproject/api/models"
+	"myproject/api/services"
 	"myproject/api/utils"
 
 	"github.com/gofiber/fiber/v2"
@@ -12,6 +13,7 @@ import (
 
 // CreatePart Register a part in the parts table.
 func CreatePart(c *fiber.Ctx, db *gorm.DB) error {
+	db = services.TenantDB(db, c)
 	var part models.Part
 	if err := c.BodyParser(&part); err != nil {
 		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
@@ -26,6 +28,7 @@ func CreatePart(c *fiber.Ctx, db *gorm.DB) error {
 
 // UpdatePart Update a part in the part table.
 func UpdatePart(c *fiber.Ctx, db *gorm.DB) error {
+	db = services.TenantDB(db, c)
 	id := c.Params("id")
 	var part models.Part
 	if err := c.BodyParser(&part); err != nil {
@@ -53,6 +56,7 @@ func UpdatePart(c *fiber.Ctx, db *gorm.DB) error {
 
 // DeletePart Remove a part of the Parte table; validate its existence beforehand.
 func DeletePart(c *fiber.Ctx, db *gorm.DB) error {
+	db = services.TenantDB(db, c)
 	id := c.Params("id")
 
 	var part models.Part
@@ -64,11 +68,13 @@ func DeletePart(c *fiber.Ctx, db *gorm.DB) error {
 	if err := db.Delete(&part).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
 	}
+	services.AuditDeletion(db, "part", part.ID, part.BusinessId, services.TenantUserID(c), "delete")
 	return utils.SendJsonResult(c, part)
 }
 
 // GetPartsList Gets a list of parts; these can be filtered by category.
 func GetPartsList(c *fiber.Ctx, db *gorm.DB, category string) error {
+	db = services.TenantDB(db, c)
 	var parts []models.Part
 
 	if category == "any" || category == "HVAC" {
@@ -82,6 +88,7 @@ func GetPartsList(c *fiber.Ctx, db *gorm.DB, category string) error {
 
 // CreateConsumable Register a consumable in the Consumible table.
 func CreateConsumable(c *fiber.Ctx, db *gorm.DB) error {
+	db = services.TenantDB(db, c)
 	var consumable models.Consumable
 	if err := c.BodyParser(&consumable); err != nil {
 		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
@@ -96,6 +103,7 @@ func CreateConsumable(c *fiber.Ctx, db *gorm.DB) error {
 
 // UpdateConsumable Update the information of a consumable in the Consumible table.
 func UpdateConsumable(c *fiber.Ctx, db *gorm.DB) error {
+	db = services.TenantDB(db, c)
 	id := c.Params("id")
 	var consumable models.Consumable
 	if err := c.BodyParser(&consumable); err != nil {
@@ -123,6 +131,7 @@ func UpdateConsumable(c *fiber.Ctx, db *gorm.DB) error {
 
 // DeleteConsumable Remove a consumable from the Consumible table validate that it exists.
 func DeleteConsumable(c *fiber.Ctx, db *gorm.DB) error {
+	db = services.TenantDB(db, c)
 	id := c.Params("id")
 
 	var consumable models.Consumable
@@ -134,11 +143,13 @@ func DeleteConsumable(c *fiber.Ctx, db *gorm.DB) error {
 	if err := db.Delete(&consumable).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
 	}
+	services.AuditDeletion(db, "consumable", consumable.ID, consumable.BusinessId, services.TenantUserID(c), "delete")
 	return utils.SendJsonResult(c, consumable)
 }
 
 // GetConsumablesList Gets a list of consumables; these can be filtered by category.
 func GetConsumablesList(c *fiber.Ctx, db *gorm.DB, category string) error {
+	db = services.TenantDB(db, c)
 	var consumables []models.Consumable
 
 	if category == "any" || category == "HVAC" {
@@ -152,6 +163,7 @@ func GetConsumablesList(c *fiber.Ctx, db *gorm.DB, category string) error {
 
 // GetBrandsList Gets a list of makes and models from the equipment table.
 func GetBrandsList(c *fiber.Ctx, db *gorm.DB, category string) error {
+	db = services.TenantDB(db, c)
 	type BrandModel struct {
 		Brand string
 		Model string
@@ -174,6 +186,7 @@ func GetBrandsList(c *fiber.Ctx, db *gorm.DB, category string) error {
 // CreatePartStockBin Register the stock of a part, validate that
 // it is registered in the Part table.
 func CreatePartStockBin(c *fiber.Ctx, db *gorm.DB) error {
+	db = services.TenantDB(db, c)
 	var binInfo models.BinInfo
 	if err := c.BodyParser(&binInfo); err != nil {
 		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
@@ -196,6 +209,7 @@ func CreatePartStockBin(c *fiber.Ctx, db *gorm.DB) error {
 // CreateConsumableStockBin Register the stock of a consumable, validate that
 // it is registered in the Consumible table.
 func CreateConsumableStockBin(c *fiber.Ctx, db *gorm.DB) error {
+	db = services.TenantDB(db, c)
 	var binInfo models.BinInfo
 	if err := c.BodyParser(&binInfo); err != nil {
 		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
@@ -217,13 +231,14 @@ func CreateConsumableStockBin(c *fiber.Ctx, db *gorm.DB) error {
 
 // UpdateStockBin Allows updating the stock quantity of a part or consumable.
 func UpdateStockBin(c *fiber.Ctx, db *gorm.DB) error {
+	db = services.TenantDB(db, c)
 	var binInfo models.BinInfo
 	if err := c.BodyParser(&binInfo); err != nil {
 		c.Status(fiber.StatusInternalServerError).SendString(err.Error())
 		return err
 	}
 
-	if err := db.Exec("update bin_infos set quantity = ? where id = ?", binInfo.Quantity, binInfo.ID).Error; err != nil {
+	if err := db.Model(&models.BinInfo{}).Where("id = ?", binInfo.ID).Update("quantity", binInfo.Quantity).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
 	}
 
@@ -232,6 +247,7 @@ func UpdateStockBin(c *fiber.Ctx, db *gorm.DB) error {
 
 // TransferPartsStockBin allows transferring part stock between bins.
 func TransferPartsStockBin(c *fiber.Ctx, db *gorm.DB) error {
+	db = services.TenantDB(db, c)
 	fromBinId := c.Params("fromBin")
 	toBinId := c.Params("toBin")
 
@@ -271,6 +287,7 @@ func TransferPartsStockBin(c *fiber.Ctx, db *gorm.DB) error {
 
 // TransferConsumablesStockBin allows transferring consumable stock between bins.
 func TransferConsumablesStockBin(c *fiber.Ctx, db *gorm.DB) error {
+	db = services.TenantDB(db, c)
 	fromBinId := c.Params("fromBin")
 	toBinId := c.Params("toBin")
 
@@ -310,6 +327,7 @@ func TransferConsumablesStockBin(c *fiber.Ctx, db *gorm.DB) error {
 
 func copyStockInfo(fromStock models.BinInfo, binId uint) models.BinInfo {
 	return models.BinInfo{
+		BusinessId:   fromStock.BusinessId,
 		BinId:        binId,
 		PartId:       fromStock.PartId,
 		ConsumableId: fromStock.ConsumableId,
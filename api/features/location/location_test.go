@@ -2,13 +2,20 @@ package location
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
 
+	"myproject/api/database"
 	"myproject/api/models"
+	"myproject/api/services"
+	"myproject/api/utils"
 	"myproject/test"
 
 	"github.com/gofiber/fiber/v2"
@@ -383,3 +390,203 @@ func TestLocationAreaManagement(t *testing.T) {
 		assert.Equal(t, locationAreaData.ID, newLocationAreas.ID)
 	})
 }
+
+func TestLocationTenantIsolation(t *testing.T) {
+	app, db, err := test.SetupTestApp()
+	if err != nil {
+		t.Fatalf("Failed to setup test app: %v", err)
+	}
+
+	api := app.Group("/api/v1")
+	LocationApiRoutes(api.Group("location"), db)
+
+	// the web routes with the test user logged in
+	web := app.Group("/location", func(c *fiber.Ctx) error {
+		var user models.User
+		services.UserForId(db, 3, &user)
+		c.Locals("currentUser", user)
+		return c.Next()
+	})
+	LocationRestrictedRoutes(web, db)
+
+	ownId, otherId := test.SetupTenants(db)
+
+	otherLocation := models.Location{BusinessId: otherId, Name: "Other tenant location"}
+	db.Create(&otherLocation)
+
+	t.Run("Create location for another business", func(t *testing.T) {
+		data := map[string]interface{}{"businessId": otherId, "name": "Hijack location"}
+		test.SignMap(data)
+		jsonData, _ := json.Marshal(data)
+		req := httptest.NewRequest("POST", "/api/v1/location", bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, fiber.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("Update location of another business", func(t *testing.T) {
+		data := map[string]interface{}{"businessId": ownId, "name": "Hijacked"}
+		test.SignMap(data)
+		jsonData, _ := json.Marshal(data)
+		req := httptest.NewRequest("PUT", "/api/v1/location/"+strconv.Itoa(int(otherLocation.ID)), bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, fiber.StatusForbidden, resp.StatusCode)
+
+		var location models.Location
+		db.First(&location, otherLocation.ID)
+		assert.Equal(t, otherId, location.BusinessId)
+		assert.Equal(t, otherLocation.Name, location.Name)
+	})
+
+	t.Run("Add area to a location of another business", func(t *testing.T) {
+		data := map[string]interface{}{"locationId": otherLocation.ID, "businessId": ownId, "name": "Hijack area"}
+		test.SignMap(data)
+		jsonData, _ := json.Marshal(data)
+		req := httptest.NewRequest("POST", fmt.Sprintf("/api/v1/location/%d/area", otherLocation.ID), bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, fiber.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("Geolocate the locations of another business", func(t *testing.T) {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/location/geolocate/%d", otherId), nil)
+
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, fiber.StatusForbidden, resp.StatusCode)
+	})
+}
+
+// TestGeocodeAddress would require mocking the HTTP request to Google Maps API
+// This is a simplified version that just checks the function doesn't crash
+func TestGeocodeAddress(t *testing.T) {
+	setupLocationTestApp(t)
+
+	t.Run("Skip actual API call", func(t *testing.T) {
+		// Skip this test in normal runs as it requires API key and makes external calls
+		//t.Skip("Skipping test that makes external API calls")
+
+		address := "11109 Starkweather Ave, Cleveland, OH"
+		result, err := services.GoogleGeocoder{Key: database.GetParam("GOOGLE_MAPS_API_KEY")}.Geocode(context.Background(), address)
+
+		assert.Nil(t, err)
+		assert.True(t, strings.Contains(result.Latlng, ","))
+
+		lat, lng := utils.ExtractLatLng(result.Latlng)
+		assert.NotZero(t, lat)
+		assert.NotZero(t, lng)
+		assert.Equal(t, 41.47741, lat)
+		assert.Equal(t, -81.688046, lng)
+	})
+}
+
+func TestNormalizeGeocodeQuery(t *testing.T) {
+	assert.Equal(t, "calle 10 # 5-20, bogota, colombia", services.NormalizeGeocodeQuery(" Calle 10 # 5-20 ,  Bogotá ,Colombia. "))
+	assert.Equal(t, services.NormalizeGeocodeQuery("1 Main St., Springfield"), services.NormalizeGeocodeQuery("1 MAIN ST, springfield,"))
+	assert.Equal(t, "", services.NormalizeGeocodeQuery(" , . "))
+}
+
+func TestGeocodeCache(t *testing.T) {
+	app, db, err := test.SetupTestApp()
+	if err != nil {
+		t.Fatalf("Failed to setup test app: %v", err)
+	}
+
+	api := app.Group("/api/v1")
+	LocationApiRoutes(api.Group("location"), db)
+
+	fixtures := filepath.Join(t.TempDir(), "places.json")
+	os.WriteFile(fixtures, []byte(`[
+		{"address": "11109 Starkweather Ave, Cleveland, OH", "lat": 41.47741, "lng": -81.688046, "country": "us"},
+		{"address": "Carrera 7 # 71-21, Bogota, Colombia", "lat": 4.6560, "lng": -74.0557, "country": "co", "formattedAddress": "Cra. 7 #71-21, Bogotá"}
+	]`), 0600)
+
+	offline, err := services.NewOfflineGeocoder(fixtures)
+	assert.Nil(t, err)
+	services.UseGeocoder(offline)
+	t.Cleanup(func() { services.UseGeocoder(nil) })
+
+	db.Exec("delete from geocode_caches where provider = 'offline'")
+
+	t.Run("Forward and cached", func(t *testing.T) {
+		result, err := services.Geocode(db, "11109 STARKWEATHER AVE, Cleveland, OH.")
+		assert.Nil(t, err)
+		assert.Equal(t, "41.477410,-81.688046", result.Latlng)
+		assert.Equal(t, "US", result.Country)
+		assert.False(t, result.Cached)
+
+		result, err = services.Geocode(db, "11109 Starkweather Ave,  Cleveland, OH")
+		assert.Nil(t, err)
+		assert.True(t, result.Cached)
+		assert.Equal(t, "41.477410,-81.688046", result.Latlng)
+	})
+
+	t.Run("Not found is cached", func(t *testing.T) {
+		_, err := services.Geocode(db, "1 Nowhere Road")
+		assert.ErrorIs(t, err, services.ErrGeocodeNotFound)
+
+		result, err := services.Geocode(db, "1 nowhere road")
+		assert.ErrorIs(t, err, services.ErrGeocodeNotFound)
+		assert.True(t, result.Cached)
+	})
+
+	t.Run("Reverse", func(t *testing.T) {
+		result, err := services.ReverseGeocode(db, 4.6561, -74.0556)
+		assert.Nil(t, err)
+		assert.Equal(t, "Cra. 7 #71-21, Bogotá", result.FormattedAddress)
+		assert.Equal(t, "CO", result.Country)
+
+		_, err = services.ReverseGeocode(db, 10, 10)
+		assert.ErrorIs(t, err, services.ErrGeocodeNotFound)
+
+		_, err = services.ReverseGeocode(db, 91, 0)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("Geocode the locations of a business", func(t *testing.T) {
+		ownId, _ := test.SetupTenants(db)
+		db.Exec("insert into locations (business_id, name, address, city, province, country) values (?, 'Starkweather', '11109 Starkweather Ave', 'Cleveland', 'OH', ''), (?, 'Nowhere', '1 Nowhere Road', '', '', '')", ownId, ownId)
+
+		reports := []services.GeocodeProgress{}
+		progress, err := services.GeocodeLocations(db, ownId, 0, 1, func(p services.GeocodeProgress) { reports = append(reports, p) })
+		assert.Nil(t, err)
+		assert.Equal(t, int64(2), progress.Total)
+		assert.Equal(t, 1, progress.Found)
+		assert.False(t, progress.Finished)
+		assert.Len(t, reports, 1)
+
+		progress, err = services.GeocodeLocations(db, ownId, progress.LastId, 10, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, progress.Failed)
+		assert.True(t, progress.Finished)
+
+		var location models.Location
+		db.First(&location, "business_id = ? and name = 'Starkweather'", ownId)
+		assert.Equal(t, "41.477410,-81.688046", location.Latlng)
+
+		db.Exec("delete from locations where business_id = ?", ownId)
+	})
+
+	t.Run("Geocode address route", func(t *testing.T) {
+		data := map[string]interface{}{"address": "Carrera 7 # 71-21, Bogotá, Colombia"}
+		test.SignMap(data)
+		jsonData, _ := json.Marshal(data)
+		req := httptest.NewRequest("POST", "/api/v1/location/geocode/address", bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, resp.StatusCode)
+
+		var result map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		assert.Equal(t, "4.656000,-74.055700", result["result"])
+	})
+}
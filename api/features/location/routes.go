@@ -3,9 +3,9 @@ package location
 import (
 	"fmt"
 	"os"
-	"slices"
-	"strings"
+	"strconv"
 
+	"myproject/api/database"
 	"myproject/api/models"
 	"myproject/api/services"
 	"myproject/api/utils"
@@ -39,8 +39,7 @@ func LocationRestrictedRoutes(app fiber.Router, db *gorm.DB) {
 
 		user := c.Locals("currentUser").(models.User)
 
-		location, err := GetLocationForID(id, db)
-
+		businessId, err := services.TenantFromRecord("locations", "id")(c, db)
 		if err != nil {
 			return c.Render("home/oops", fiber.Map{
 				"Message": "error getting location",
@@ -48,14 +47,23 @@ func LocationRestrictedRoutes(app fiber.Router, db *gorm.DB) {
 			})
 		}
 
-		// check the user has access to the business
-		if !UserCanAccessBusiness(user, location.Business) {
+		// check the user has access to the business or is a sub contractor assigned to the location
+		locationId, _ := strconv.ParseUint(id, 10, 64)
+		if !services.CanAccessBusiness(db, user, businessId) && !services.IsAssignedContractor(db, user.ID, uint(locationId)) {
 			return c.Render("home/oops", fiber.Map{
 				"Message": "error getting location",
 				"Error":   "You do not have access to this business",
 			})
 		}
 
+		location, err := GetLocationForID(id, database.ScopeTenant(db, businessId))
+		if err != nil {
+			return c.Render("home/oops", fiber.Map{
+				"Message": "error getting location",
+				"Error":   err.Error(),
+			})
+		}
+
 		return c.Render("business/location", fiber.Map{
 			"Business": location.Business,
 			"Location": location,
@@ -64,7 +72,7 @@ func LocationRestrictedRoutes(app fiber.Router, db *gorm.DB) {
 	})
 
 	// geolocate the business locations to prepopulate the latlng
-	app.Get("/geolocate/:businessId", func(c *fiber.Ctx) error {
+	app.Get("/geolocate/:businessId", services.RequireTenant(db, services.TenantFromParam("businessId")), func(c *fiber.Ctx) error {
 		id := c.Params("businessId")
 
 		// the user must be logged in to perform this action
@@ -73,30 +81,18 @@ func LocationRestrictedRoutes(app fiber.Router, db *gorm.DB) {
 		}
 
 		user := c.Locals("currentUser").(models.User)
+		businessId, _ := strconv.ParseUint(id, 10, 64)
 
-		if !slices.Contains([]uint{1, 4, 1072, 1073}, user.ID) {
+		if !services.CanManageBusiness(db, user, uint(businessId)) {
 			err := fmt.Errorf("user %d does not have access to business %s", user.ID, id)
 			fmt.Println(err)
 			c.Status(503).SendString(err.Error())
 			return err
 		}
-		var locations []models.Location
-		// limit to 20 locations at a time to avoid rate limiting
-		db.Limit(20).Find(&locations, "business_id = ? and (latlng is null or latlng = '')", id)
-
-		for _, loc := range locations {
-			address := fmt.Sprintf("%s, %s, %s, %s", loc.Address, loc.City, loc.Province, loc.Country)
-			//fmt.Println("processing", loc.Name, address, loc.Latlng)
-			if loc.Latlng == "" {
-				latlng, err := utils.GeocodeAddress(address)
-				if err != nil {
-					fmt.Println(err)
-					continue
-				}
-				loc.Latlng = latlng
-				//fmt.Println("geolocated", loc.Name, loc.Latlng)
-				db.Save(&loc)
-			}
+
+		// the locations are geocoded by the task queue, the progress is published on the websocket
+		if _, err := services.QueueGeocodeTask(services.TenantDB(db, c), user.ID, uint(businessId)); err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
 		}
 
 		return utils.SendJsonResult(c, "OK")
@@ -157,19 +153,55 @@ func LocationApiRoutes(app fiber.Router, db *gorm.DB) {
 			return c.Status(400).SendString(err.Error())
 		}
 
-		// lower case the address
-		address := strings.ToLower(req.Address)
+		// turn an address into a lat/lng, cached by the normalised address
+		position, err := services.Geocode(db, req.Address)
+		if err != nil {
+			return utils.SendJsonError(c, err)
+		}
 
-		// use google maps API to turn an address into a lat/lng
-		position, err := utils.GeocodeAddress(address)
+		return utils.SendJsonResult(c, position.Latlng)
+	})
+
+	// the address at a lat/lng
+	app.Post("/geocode/reverse", func(c *fiber.Ctx) error {
+		if _, err := services.VerifyFormSignature(db, c); err != nil {
+			fmt.Println(err)
+			c.Status(503).SendString(err.Error())
+			return err
+		}
+
+		type ReverseRequest struct {
+			Lat float64 `json:"lat"`
+			Lng float64 `json:"lng"`
+		}
+
+		req := new(ReverseRequest)
+		if err := c.BodyParser(req); err != nil {
+			return c.Status(400).SendString(err.Error())
+		}
+
+		result, err := services.ReverseGeocode(db, req.Lat, req.Lng)
 		if err != nil {
 			return utils.SendJsonError(c, err)
 		}
 
-		return utils.SendJsonResult(c, position)
+		return utils.SendJsonResult(c, result)
+	})
+
+	// fill the missing latlng of the locations of a business in the background,
+	// the progress is published on the websocket channel geocode-<businessId>
+	app.Post("/geolocate/:businessId", services.RequireTenant(db, services.TenantFromParam("businessId")), func(c *fiber.Ctx) error {
+		user, err := services.VerifyFormSignature(db, c)
+		if err != nil {
+			fmt.Println(err)
+			c.Status(503).SendString(err.Error())
+			return err
+		}
+
+		return GeolocateBusiness(c, db, user)
 	})
 
-	app.Post("/", func(c *fiber.Ctx) error {
+	app.Post("/", services.RequireTenant(db, services.TenantFromBody()), func(c *fiber.Ctx) error {
 		if _, err := services.VerifyFormSignature(db, c); err != nil {
 			fmt.Println(err)
 			c.Status(503).SendString(err.Error())
@@ -178,7 +210,7 @@ func LocationApiRoutes(app fiber.Router, db *gorm.DB) {
 		return CreateLocation(c, db)
 	})
 
-	app.Put("/:id", func(c *fiber.Ctx) error {
+	app.Put("/:id", services.RequireTenant(db, services.TenantFromRecord("locations", "id")), func(c *fiber.Ctx) error {
 		id := c.Params("id")
 		if id == "" {
 			return c.Status(400).SendString("No ID given")
@@ -192,7 +224,7 @@ func LocationApiRoutes(app fiber.Router, db *gorm.DB) {
 	})
 
 	// delete a location
-	app.Delete("/:id", func(c *fiber.Ctx) error {
+	app.Delete("/:id", services.RequireTenant(db, services.TenantFromRecord("locations", "id")), func(c *fiber.Ctx) error {
 		id := c.Params("id")
 		if id == "" {
 			return c.Status(400).SendString("No ID given")
@@ -206,7 +238,7 @@ func LocationApiRoutes(app fiber.Router, db *gorm.DB) {
 	})
 
 	// import locations from an excel file for 216 maintenance
-	app.Post("/import/:providerId/:businessId/:format", func(c *fiber.Ctx) error {
+	app.Post("/import/:providerId/:businessId/:format", services.RequireTenant(db, services.TenantFromParam("businessId")), func(c *fiber.Ctx) error {
 		format := c.Params("format", "")
 		if format == "" {
 			return c.Status(400).SendString("No format given")
@@ -230,7 +262,7 @@ func LocationApiRoutes(app fiber.Router, db *gorm.DB) {
 	})
 
 	// create a new location area
-	app.Post("/:id/area", func(c *fiber.Ctx) error {
+	app.Post("/:id/area", services.RequireTenant(db, services.TenantFromRecord("locations", "id")), func(c *fiber.Ctx) error {
 		if os.Getenv("USE_DOCKER") == "true" {
 			// no-op - allow all requests
 		} else {
@@ -244,7 +276,7 @@ func LocationApiRoutes(app fiber.Router, db *gorm.DB) {
 	})
 
 	// update a location area
-	app.Put("/:location_id/area/:id", func(c *fiber.Ctx) error {
+	app.Put("/:location_id/area/:id", services.RequireTenant(db, services.TenantFromRecord("locations", "location_id")), func(c *fiber.Ctx) error {
 		if os.Getenv("USE_DOCKER") == "true" {
 			// no-op - allow all requests
 		} else {
@@ -258,7 +290,7 @@ func LocationApiRoutes(app fiber.Router, db *gorm.DB) {
 	})
 
 	// delete a location area
-	app.Delete("/:location_id/area/:id", func(c *fiber.Ctx) error {
+	app.Delete("/:location_id/area/:id", services.RequireTenant(db, services.TenantFromRecord("locations", "location_id")), func(c *fiber.Ctx) error {
 		if os.Getenv("USE_DOCKER") == "true" {
 			// no-op - allow all requests
 		} else {
@@ -2,15 +2,14 @@ package location
 
 import (
 	"fmt"
-	"mime/multipart"
 	"strconv"
 	"strings"
 
 	"myproject/api/models"
+	"myproject/api/services"
 	"myproject/api/utils"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/xuri/excelize/v2"
 	"gorm.io/gorm"
 	"gorm.io/plugin/dbresolver"
 )
@@ -27,10 +26,33 @@ func GetLocationForID(id string, db *gorm.DB) (models.Location, error) {
 
 func GetLocationsWithinRadius(c *fiber.Ctx, db *gorm.DB) error {
 
-	var locations []models.Location
+	query, err := services.ParseRadiusQuery(c.Params("latitude"), c.Params("longitude"), c.Params("radius"))
+	if err != nil {
+		c.Status(fiber.StatusBadRequest)
+		return utils.SendJsonResult(c, fiber.Map{"error": err.Error()})
+	}
 
-	db.Preload("Business").Preload("Areas").Where(" ST_DWithin(location, 'SRID=4326;POINT(%s %s)'::geography, %s) ",
-		c.Params("longitude"), c.Params("latitude"), c.Params("radius")).Find(&locations)
+	hits, err := services.SpatialSearch(db, "locations", query, nil)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	var found []models.Location
+	if len(hits) > 0 {
+		db.Preload("Business").Preload("Areas").Find(&found, services.SpatialIds(hits))
+	}
+
+	// nearest first
+	byId := map[uint]models.Location{}
+	for _, location := range found {
+		byId[location.ID] = location
+	}
+	locations := []models.Location{}
+	for _, hit := range hits {
+		if location, ok := byId[hit.ID]; ok {
+			locations = append(locations, location)
+		}
+	}
 
 	return utils.SendJsonResult(c, locations)
 }
@@ -38,7 +60,8 @@ func GetLocationsWithinRadius(c *fiber.Ctx, db *gorm.DB) error {
 func GetCitiesForState(db *gorm.DB, state string, country string) ([]models.City, error) {
 	var cities []models.City
 
-	db.Find(&cities, "code = ? and country = ?", state, country)
+	// the country by its name in the locations or its ISO code
+	db.Order("population desc, city").Find(&cities, "code = ? and (country = ? or country_code = ?)", state, country, strings.ToUpper(country))
 
 	return cities, nil
 }
@@ -53,12 +76,13 @@ func GetStatesForCountry(db *gorm.DB, country string) ([]State, error) {
 	var states []State
 
 	// select distinct state, code from cities where country = 'USA' order by state
-	db.Raw("select distinct state, code from cities where country = ? order by state", country).Scan(&states)
+	db.Raw("select distinct state, code from cities where country = ? or country_code = ? order by state", country, strings.ToUpper(country)).Scan(&states)
 
 	return states, nil
 }
 
 func CreateLocation(c *fiber.Ctx, db *gorm.DB) error {
+	db = services.TenantDB(db, c)
 	location := new(models.Location)
 	if err := c.BodyParser(location); err != nil {
 		fmt.Println(err)
@@ -66,6 +90,13 @@ func CreateLocation(c *fiber.Ctx, db *gorm.DB) error {
 		return err
 	}
 
+	address, err := utils.ValidateAddress(utils.Address{City: location.City, Province: location.Province, Postcode: location.Zipcode, Country: location.Country})
+	if err != nil {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": err.Error(), "fields": err})
+	}
+	location.City, location.Province, location.Zipcode, location.Country = address.City, address.Province, address.Postcode, address.Country
+
 	db.Create(&location)
 
 	if strings.Contains(string(c.Request().Header.ContentType()), "multipart/form-data") {
@@ -87,8 +118,9 @@ func CreateLocation(c *fiber.Ctx, db *gorm.DB) error {
 }
 
 func UpdateLocation(c *fiber.Ctx, db *gorm.DB, id string) error {
+	db = services.TenantDB(db, c)
 	var updates map[string]interface{}
-	if err := c.BodyParser(updates); err != nil {
+	if err := c.BodyParser(&updates); err != nil {
 		fmt.Println(err)
 		c.Status(503).SendString(err.Error())
 		return err
@@ -104,6 +136,26 @@ func UpdateLocation(c *fiber.Ctx, db *gorm.DB, id string) error {
 		return utils.SendJsonResult(c, fiber.Map{"error": "No location found with given ID"})
 	}
 
+	// the address as it will be after the update must be valid in its country
+	address := utils.Address{City: location.City, Province: location.Province, Postcode: location.Zipcode, Country: location.Country}
+	changed := false
+	for key, field := range map[string]*string{"city": &address.City, "province": &address.Province, "postcode": &address.Postcode, "country": &address.Country} {
+		if value, ok := updates[key].(string); ok {
+			*field = value
+			changed = true
+		}
+	}
+	if changed {
+		address, err := utils.ValidateAddress(address)
+		if err != nil {
+			c.Status(fiber.StatusNotAcceptable)
+			return utils.SendJsonResult(c, fiber.Map{"error": err.Error(), "fields": err})
+		}
+		delete(updates, "postcode")
+		updates["city"], updates["province"], updates["zipcode"], updates["country"] = address.City, address.Province, address.Postcode, address.Country
+		updates["country_code"] = address.CountryCode
+	}
+
 	db.Model(&location).Updates(updates)
 
 	if strings.Contains(string(c.Request().Header.ContentType()), "multipart/form-data") {
@@ -124,6 +176,7 @@ func UpdateLocation(c *fiber.Ctx, db *gorm.DB, id string) error {
 }
 
 func DeleteLocation(c *fiber.Ctx, db *gorm.DB, id string) error {
+	db = services.TenantDB(db, c)
 
 	var location models.Location
 	db.First(&location, id)
@@ -134,11 +187,13 @@ func DeleteLocation(c *fiber.Ctx, db *gorm.DB, id string) error {
 	if err := db.Delete(&location).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
 	}
+	services.AuditDeletion(db, "location", location.ID, location.BusinessId, services.TenantUserID(c), "delete")
 	return utils.SendJsonResult(c, location)
 }
 
 // CreateLocationArea Register a location area.
 func CreateLocationArea(c *fiber.Ctx, db *gorm.DB) error {
+	db = services.TenantDB(db, c)
 	var locationArea models.LocationArea
 	if err := c.BodyParser(&locationArea); err != nil {
 		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
@@ -164,6 +219,7 @@ func CreateLocationArea(c *fiber.Ctx, db *gorm.DB) error {
 
 // UpdateLocationArea Update the information of a location area.
 func UpdateLocationArea(c *fiber.Ctx, db *gorm.DB) error {
+	db = services.TenantDB(db, c)
 	var locationArea models.LocationArea
 	if err := c.BodyParser(&locationArea); err != nil {
 		c.Status(fiber.StatusInternalServerError).SendString(err.Error())
@@ -202,6 +258,7 @@ func UpdateLocationArea(c *fiber.Ctx, db *gorm.DB) error {
 
 // DeleteLocationArea Remove a location area. Validate that it exists.
 func DeleteLocationArea(c *fiber.Ctx, db *gorm.DB) error {
+	db = services.TenantDB(db, c)
 	locationId := c.Params("location_id")
 	sidLocation, err := strconv.ParseUint(locationId, 10, 64)
 	if err != nil {
@@ -229,76 +286,17 @@ func DeleteLocationArea(c *fiber.Ctx, db *gorm.DB) error {
 	return utils.SendJsonResult(c, locationArea)
 }
 
-func extractLocationFromRow(row []string, format string, business models.Business) (models.Location, error) {
-
-	if format == "region" {
-		// store number, name, address, city, state, zip, phone, fax, email, website
-		storeNumber := row[0]
-		name := row[1]
-		manager := row[2]
-		address := row[5]
-		city := row[6]
-		state := row[7]
-		zip := row[8]
-		phone := row[9]
-
-		//fmt.Println(storeNumber, name, manager, address, city, state, zip, phone)
-		location := models.Location{
-			Name:        name,
-			Address:     address,
-			City:        city,
-			Province:    state,
-			Country:     "USA",
-			Zipcode:     zip,
-			Phone:       utils.FixupPhone(phone),
-			BusinessId:  business.ID,
-			UserId:      business.UserId,
-			ContactName: manager,
-			Identity:    storeNumber,
-			Flags:       []string{"imported"},
-		}
-		return location, nil
-	} else if format == "external" {
-		// store number, name, address, city, state, zip, phone, fax, email, website
-		storeNumber := row[3]
-		name := row[4]
-		manager := row[0]
-		address := row[6]
-		city := row[8]
-		state := row[9]
-		zip := row[10]
-		phone := row[5]
-
-		if row[7] != "" {
-			address = fmt.Sprintf("%s, %s", address, row[7])
-		}
-
-		//fmt.Println(storeNumber, name, manager, address, city, state, zip, phone)
-		location := models.Location{
-			Name:        name,
-			Address:     address,
-			City:        city,
-			Province:    state,
-			Country:     "USA",
-			Zipcode:     zip,
-			Phone:       utils.FixupPhone(phone),
-			BusinessId:  business.ID,
-			UserId:      business.UserId,
-			ContactName: manager,
-			Identity:    storeNumber,
-			Flags:       []string{"imported"},
-		}
-		return location, nil
-	}
-
-	return models.Location{}, fmt.Errorf("unknown format")
-}
+// ImportLocationsFor216 import the locations of the 216 maintenance spreadsheets with one of the builtin
+// profiles "region" or "external", see the imports feature for other layouts
 func ImportLocationsFor216(c *fiber.Ctx, db *gorm.DB, providerId, businessId, format string) error {
+	db = services.TenantDB(db, c)
 
-	var fh *multipart.FileHeader
+	mapping, ok := services.BuiltinImportProfiles[format]
+	if !ok {
+		return c.Status(400).SendString("Invalid format given")
+	}
 
 	fh, err := c.FormFile("locations")
-
 	if err != nil {
 		fmt.Println(err)
 		c.Status(405).SendString("error No work orders Excel file given")
@@ -306,28 +304,11 @@ func ImportLocationsFor216(c *fiber.Ctx, db *gorm.DB, providerId, businessId, fo
 		return err
 	}
 
-	var (
-		f multipart.File
-	)
-	f, err = fh.Open()
+	f, err := fh.Open()
 	if err != nil {
 		return err
 	}
-
-	defer func() {
-		e := f.Close()
-		if err == nil {
-			err = e
-		}
-	}()
-
-	xcl, err := excelize.OpenReader(f)
-	if err != nil {
-		if closeErr := f.Close(); closeErr != nil {
-			return closeErr
-		}
-		return err
-	}
+	defer f.Close()
 
 	// load the business
 	var business models.Business
@@ -337,55 +318,54 @@ func ImportLocationsFor216(c *fiber.Ctx, db *gorm.DB, providerId, businessId, fo
 		return err
 	}
 
-	addedCount := 0
-	errorCount := 0
-	sheetName := "A38"
-
-	if format == "external" {
-		sheetName = "Sheet1"
+	data, err := services.ReadImportRows(f, "locations.xlsx", mapping.Sheet)
+	if err != nil {
+		fmt.Println(err)
+		return err
 	}
 
-	tx := db.Begin()
-	rows, err := xcl.GetRows(sheetName) // A35 is the Ohio state stores
+	rows, err := services.MapImportRows("location", mapping, data)
 	if err != nil {
 		fmt.Println(err)
 		return err
 	}
 
-	for _, row := range rows {
-
-		if len(row) < 10 {
-			continue
-		}
-
-		if row[2] == "Store Count:" || row[2] == "Manager" {
-			continue
-		}
-
-		location, err := extractLocationFromRow(row, format, business)
-		if err != nil {
-			fmt.Println(err)
-			errorCount++
-		} else {
-			// check if the location already exists
-			var existing models.Location
-			tx.First(&existing, "business_id = ? and identity = ?", business.ID, location.Identity)
-			if existing.ID != 0 {
-				continue
-			}
-			tx.Create(&location)
-			addedCount++
-		}
+	// existing locations are left as they are
+	services.PlanImport(db, business.ID, "location", "create", mapping.DedupeOn, rows)
 
+	counts, err := services.ApplyImport(db, business, "location", rows)
+	if err != nil {
+		fmt.Println(err)
+		return err
 	}
 
-	tx.Commit()
-
 	// read the newly created locations from the source DB
-	tx = db.Clauses(dbresolver.Write).Begin()
+	tx := db.Clauses(dbresolver.Write).Begin()
 	var locations []models.Location
 	tx.Find(&locations, "business_id = ?", businessId)
 	tx.Commit()
 
-	return utils.SendJsonResult(c, fiber.Map{"errorCount": errorCount, "addedCount": addedCount, "locations": locations})
+	return utils.SendJsonResult(c, fiber.Map{"errorCount": counts.Failed, "addedCount": counts.Created, "locations": locations})
+}
+
+// GeolocateBusiness queue the geocoding of the locations of a business without a latlng
+func GeolocateBusiness(c *fiber.Ctx, db *gorm.DB, user models.User) error {
+	businessId, _ := services.TenantID(c)
+	if businessId == 0 {
+		id, _ := strconv.ParseUint(c.Params("businessId"), 10, 64)
+		businessId = uint(id)
+	}
+
+	if !services.CanManageBusiness(db, user, businessId) {
+		c.Status(fiber.StatusForbidden)
+		return utils.SendJsonResult(c, fiber.Map{"error": "Only the managers of the business can geocode its locations"})
+	}
+
+	count, err := services.QueueGeocodeTask(db, user.ID, businessId)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	c.Status(fiber.StatusAccepted)
+	return utils.SendJsonResult(c, fiber.Map{"missing": count, "channel": services.GeocodeChannel(businessId)})
 }
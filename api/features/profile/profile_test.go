@@ -0,0 +1,95 @@
+package profile
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"myproject/api/database"
+	"myproject/test"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubdomainProfiles(t *testing.T) {
+	app, db, err := test.SetupTestApp()
+	if err != nil {
+		t.Fatalf("Failed to setup test app: %v", err)
+	}
+
+	database.SystemParams["profile_domain"] = "myproject.test"
+
+	app.Use(ProfileHost(db))
+	api := app.Group("/api/v1")
+	ProfileApiRoutes(api.Group("profile"), db)
+
+	ownId, otherId := test.SetupTenants(db)
+
+	oldUuid := fmt.Sprintf("profile%d", ownId)
+	newUuid := fmt.Sprintf("renamed%d", ownId)
+	db.Exec("update businesses set uuid = ?, enabled = true where id = ?", oldUuid, ownId)
+	db.Exec("update businesses set uuid = ? where id = ?", fmt.Sprintf("other%d", otherId), otherId)
+
+	signedRequest := func(method, url string, data map[string]interface{}) (int, map[string]interface{}) {
+		test.SignMap(data)
+		jsonData, _ := json.Marshal(data)
+		req := httptest.NewRequest(method, url, bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+
+		var result map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+
+	t.Run("Render profile on subdomain", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://"+oldUuid+".myproject.test/", nil)
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, resp.StatusCode)
+	})
+
+	t.Run("Unknown subdomain", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://nosuchbusiness.myproject.test/", nil)
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("Change to a reserved subdomain", func(t *testing.T) {
+		status, _ := signedRequest("PUT", fmt.Sprintf("/api/v1/profile/%d/subdomain", ownId), map[string]interface{}{"uuid": "www"})
+		assert.Equal(t, fiber.StatusNotAcceptable, status)
+	})
+
+	t.Run("Change to a taken subdomain", func(t *testing.T) {
+		status, _ := signedRequest("PUT", fmt.Sprintf("/api/v1/profile/%d/subdomain", ownId), map[string]interface{}{"uuid": fmt.Sprintf("other%d", otherId)})
+		assert.Equal(t, fiber.StatusNotAcceptable, status)
+	})
+
+	t.Run("Change subdomain of another business", func(t *testing.T) {
+		status, _ := signedRequest("PUT", fmt.Sprintf("/api/v1/profile/%d/subdomain", otherId), map[string]interface{}{"uuid": "hijacked"})
+		assert.Equal(t, fiber.StatusForbidden, status)
+	})
+
+	t.Run("Change subdomain and redirect the old one", func(t *testing.T) {
+		status, result := signedRequest("PUT", fmt.Sprintf("/api/v1/profile/%d/subdomain", ownId), map[string]interface{}{"uuid": newUuid})
+		assert.Equal(t, 200, status)
+		assert.Equal(t, newUuid, result["result"].(map[string]interface{})["uuid"])
+
+		req := httptest.NewRequest("GET", "http://"+oldUuid+".myproject.test/", nil)
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, fiber.StatusMovedPermanently, resp.StatusCode)
+		assert.Equal(t, "https://"+newUuid+".myproject.test/", resp.Header.Get("Location"))
+	})
+
+	t.Run("Old subdomain is not available to other businesses", func(t *testing.T) {
+		status, result := signedRequest("POST", "/api/v1/profile/subdomain/check", map[string]interface{}{"uuid": oldUuid, "businessId": otherId})
+		assert.Equal(t, 200, status)
+		assert.Equal(t, false, result["result"].(map[string]interface{})["available"])
+	})
+}
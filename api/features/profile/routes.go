@@ -0,0 +1,29 @@
+package profile
+
+import (
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"myproject/api/services"
+)
+
+func businessIdParam(c *fiber.Ctx) uint {
+	id, _ := strconv.ParseUint(c.Params("bizid"), 10, 64)
+	return uint(id)
+}
+
+// routes prefixed with /api/v1/profile
+func ProfileApiRoutes(app fiber.Router, db *gorm.DB) {
+
+	// check a subdomain is valid and available
+	app.Post("/subdomain/check", func(c *fiber.Ctx) error {
+		return CheckSubdomain(c, db)
+	})
+
+	// change the subdomain of a business, the old subdomain redirects to the new one
+	app.Put("/:bizid/subdomain", services.RequireTenant(db, services.TenantFromParam("bizid")), func(c *fiber.Ctx) error {
+		return ChangeSubdomain(c, services.TenantDB(db, c), businessIdParam(c))
+	})
+}
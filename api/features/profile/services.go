@@ -0,0 +1,220 @@
+package profile
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"myproject/api/database"
+	"myproject/api/models"
+	"myproject/api/services"
+	"myproject/api/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+// profileUrl is the public profile address of a subdomain
+func profileUrl(subdomain string) string {
+	return fmt.Sprintf("https://%s.%s", subdomain, database.GetParam("PROFILE_DOMAIN"))
+}
+
+// ProfileHost serves the public profile of a business on <uuid>.<PROFILE_DOMAIN>
+// and redirects subdomains a business has moved away from. Requests to other
+// hosts, reserved subdomains, or paths other than / go to the next handler.
+func ProfileHost(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		domain := database.GetParam("PROFILE_DOMAIN")
+		if domain == "" {
+			return c.Next()
+		}
+
+		subdomain := utils.SubdomainOf(c.Hostname(), domain)
+		if subdomain == "" || utils.ValidateSubdomain(subdomain) != nil {
+			return c.Next()
+		}
+
+		if c.Method() != fiber.MethodGet && c.Method() != fiber.MethodHead {
+			return c.Next()
+		}
+
+		business, moved, err := services.BusinessForSubdomain(db, subdomain)
+		if err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				fmt.Println(err)
+			}
+			if c.Path() != "/" {
+				return c.Next()
+			}
+			return c.Status(fiber.StatusNotFound).Render("home/oops", fiber.Map{
+				"Message": "business not found",
+				"Error":   fmt.Sprintf("There is no business at %s.%s", subdomain, domain),
+			})
+		}
+
+		if moved {
+			return c.Redirect(profileUrl(business.Uuid)+c.OriginalURL(), fiber.StatusMovedPermanently)
+		}
+
+		if c.Path() != "/" {
+			return c.Next()
+		}
+
+		return RenderProfile(c, business)
+	}
+}
+
+// RenderProfile render the public profile page of a business
+func RenderProfile(c *fiber.Ctx, business models.Business) error {
+
+	colour := business.Colour
+	if colour == "" {
+		colour = "#000000"
+	}
+
+	// social links shown on the profile, in display order
+	type Link struct {
+		Name string
+		Url  string
+	}
+
+	links := []Link{}
+	for _, l := range []Link{
+		{"Website", business.Website},
+		{"Facebook", business.Facebook},
+		{"Instagram", business.Instagram},
+		{"Twitter", business.Twitter},
+		{"Youtube", business.Youtube},
+	} {
+		if l.Url != "" {
+			links = append(links, l)
+		}
+	}
+
+	if business.WhatsApp != "" {
+		links = append(links, Link{"WhatsApp", "https://wa.me/" + strings.TrimPrefix(business.WhatsApp, "+")})
+	}
+
+	if business.Telegram != "" {
+		links = append(links, Link{"Telegram", "https://t.me/" + strings.TrimPrefix(business.Telegram, "@")})
+	}
+
+	locations := []models.Location{}
+	for _, loc := range business.Locations {
+		if !slices.Contains(loc.Flags, "hidden") {
+			locations = append(locations, loc)
+		}
+	}
+
+	return c.Render("profile/business", fiber.Map{
+		"Title":     business.Name,
+		"Business":  business,
+		"Colour":    colour,
+		"Links":     links,
+		"Locations": locations,
+	}, "layouts/landing_page")
+}
+
+// CheckSubdomain report if a subdomain can be used by a business
+func CheckSubdomain(c *fiber.Ctx, db *gorm.DB) error {
+
+	type CheckRequest struct {
+		Uuid       string `json:"uuid"`
+		BusinessId uint   `json:"businessId"`
+	}
+
+	req := new(CheckRequest)
+	if err := c.BodyParser(req); err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return err
+	}
+
+	subdomain := utils.NormalizeSubdomain(req.Uuid)
+
+	if err := services.SubdomainAvailable(db, subdomain, req.BusinessId); err != nil {
+		return utils.SendJsonResult(c, fiber.Map{"uuid": subdomain, "available": false, "error": err.Error()})
+	}
+
+	return utils.SendJsonResult(c, fiber.Map{"uuid": subdomain, "available": true, "url": profileUrl(subdomain)})
+}
+
+// ChangeSubdomain change the subdomain of a business, the old subdomain redirects to the new one
+func ChangeSubdomain(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	user, err := services.VerifyFormSignature(db, c)
+	if err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	if !strings.Contains(user.Roles, "admin") && !services.IsBusinessOwner(db, user.ID, businessId) {
+		c.Status(fiber.StatusForbidden)
+		return utils.SendJsonResult(c, fiber.Map{"error": "Only the owner can change the subdomain"})
+	}
+
+	type SubdomainRequest struct {
+		Uuid string `json:"uuid"`
+	}
+
+	req := new(SubdomainRequest)
+	if err := c.BodyParser(req); err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return err
+	}
+
+	var business models.Business
+	if result := db.First(&business, businessId); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": "No Business found with given ID"})
+	}
+
+	subdomain := utils.NormalizeSubdomain(req.Uuid)
+	previous := strings.ToLower(business.Uuid)
+
+	if subdomain == previous {
+		return utils.SendJsonResult(c, fiber.Map{"uuid": subdomain, "url": profileUrl(subdomain)})
+	}
+
+	if err := services.SubdomainAvailable(db, subdomain, businessId); err != nil {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": err.Error()})
+	}
+
+	tx := db.Clauses(dbresolver.Write).Begin()
+
+	// the business is taking back one of its old subdomains
+	if err := tx.Where("uuid = ? and business_id = ?", subdomain, businessId).Delete(&models.SubdomainRedirect{}).Error; err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	if previous != "" {
+		var existing models.SubdomainRedirect
+		tx.Limit(1).Find(&existing, "uuid = ?", previous)
+		if existing.ID == 0 {
+			if err := tx.Create(&models.SubdomainRedirect{BusinessId: businessId, Uuid: previous}).Error; err != nil {
+				tx.Rollback()
+				return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+			}
+		}
+	}
+
+	if err := tx.Model(&models.Business{}).Where("id = ?", businessId).Update("uuid", subdomain).Error; err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return utils.SendJsonResult(c, fiber.Map{
+		"uuid":     subdomain,
+		"previous": previous,
+		"url":      profileUrl(subdomain),
+	})
+}
@@ -0,0 +1,114 @@
+package review
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"myproject/test"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReviews(t *testing.T) {
+	app, db, err := test.SetupTestApp()
+	if err != nil {
+		t.Fatalf("Failed to setup test app: %v", err)
+	}
+
+	api := app.Group("/api/v1")
+	ReviewApiRoutes(api.Group("reviews"), db)
+
+	ownId, otherId := test.SetupTenants(db)
+
+	var reviewId uint
+
+	signedRequest := func(method, url string, data map[string]interface{}) (int, map[string]interface{}) {
+		test.SignMap(data)
+		jsonData, _ := json.Marshal(data)
+		req := httptest.NewRequest(method, url, bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+
+		var result map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+
+	t.Run("Review without being a customer", func(t *testing.T) {
+		status, _ := signedRequest("POST", fmt.Sprintf("/api/v1/reviews/business/%d", otherId), map[string]interface{}{
+			"rating": 4,
+		})
+		assert.Equal(t, fiber.StatusForbidden, status)
+	})
+
+	// the business of the signer becomes a customer of the other business
+	db.Exec("delete from business_customers where business_id = ? and customer_id = ?", otherId, ownId)
+	db.Exec("insert into business_customers (business_id, customer_id, status) values (?, ?, 'active')", otherId, ownId)
+
+	t.Run("Review own business", func(t *testing.T) {
+		status, _ := signedRequest("POST", fmt.Sprintf("/api/v1/reviews/business/%d", ownId), map[string]interface{}{
+			"rating": 5,
+		})
+		assert.Equal(t, fiber.StatusForbidden, status)
+	})
+
+	t.Run("Review with invalid rating", func(t *testing.T) {
+		status, _ := signedRequest("POST", fmt.Sprintf("/api/v1/reviews/business/%d", otherId), map[string]interface{}{
+			"rating": 6,
+		})
+		assert.Equal(t, fiber.StatusNotAcceptable, status)
+	})
+
+	t.Run("Review as a verified customer", func(t *testing.T) {
+		status, result := signedRequest("POST", fmt.Sprintf("/api/v1/reviews/business/%d", otherId), map[string]interface{}{
+			"rating": 4,
+			"title":  "Quick repair",
+			"text":   "Came the same day",
+		})
+		assert.Equal(t, 200, status)
+
+		review := result["result"].(map[string]interface{})
+		assert.Equal(t, float64(ownId), review["customerId"])
+		reviewId = uint(review["id"].(float64))
+	})
+
+	t.Run("List reviews and rating", func(t *testing.T) {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/api/v1/reviews/business/%d?sort=highest", otherId), nil)
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, resp.StatusCode)
+
+		req = httptest.NewRequest("GET", fmt.Sprintf("/api/v1/reviews/business/%d/rating", otherId), nil)
+		resp, err = app.Test(req, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, resp.StatusCode)
+
+		var result map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		assert.GreaterOrEqual(t, result["result"].(map[string]interface{})["count"], float64(1))
+	})
+
+	t.Run("Flag review", func(t *testing.T) {
+		status, _ := signedRequest("POST", fmt.Sprintf("/api/v1/reviews/%d/flag", reviewId), map[string]interface{}{
+			"reason": "spam",
+		})
+		assert.Equal(t, 200, status)
+	})
+
+	t.Run("Reply to a review of another business", func(t *testing.T) {
+		status, _ := signedRequest("PUT", fmt.Sprintf("/api/v1/reviews/%d/reply", reviewId), map[string]interface{}{
+			"reply": "Thank you",
+		})
+		assert.Equal(t, fiber.StatusForbidden, status)
+	})
+
+	t.Run("Delete own review", func(t *testing.T) {
+		status, _ := signedRequest("DELETE", fmt.Sprintf("/api/v1/reviews/%d", reviewId), map[string]interface{}{})
+		assert.Equal(t, 200, status)
+	})
+}
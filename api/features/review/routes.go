@@ -0,0 +1,63 @@
+package review
+
+import (
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"myproject/api/services"
+)
+
+func businessIdParam(c *fiber.Ctx) uint {
+	id, _ := strconv.ParseUint(c.Params("bizid"), 10, 64)
+	return uint(id)
+}
+
+// routes prefixed with /api/v1/reviews
+func ReviewApiRoutes(app fiber.Router, db *gorm.DB) {
+
+	////////////////  MODERATION	//////////////////////
+	// reviews waiting for moderation or flagged
+	app.Post("/moderation", func(c *fiber.Ctx) error {
+		return GetModerationQueue(c, db)
+	})
+
+	// publish or hide a review
+	app.Put("/moderation/:id", func(c *fiber.Ctx) error {
+		return ModerateReview(c, db)
+	})
+
+	////////////////  BUSINESS REVIEWS	//////////////////////
+	// list the published reviews of a business
+	// query params: page, limit, rating, locationId, sort (newest, oldest, highest, lowest)
+	app.Get("/business/:bizid", func(c *fiber.Ctx) error {
+		return GetBusinessReviews(c, db, c.Params("bizid"))
+	})
+
+	// the cached rating of a business with the count of reviews for each star
+	app.Get("/business/:bizid/rating", func(c *fiber.Ctx) error {
+		return GetBusinessRating(c, db, c.Params("bizid"))
+	})
+
+	// create or update the review of the current user
+	app.Post("/business/:bizid", func(c *fiber.Ctx) error {
+		return SaveReview(c, db, businessIdParam(c))
+	})
+
+	////////////////  REVIEW	//////////////////////
+	// delete a review by its author
+	app.Delete("/:id", func(c *fiber.Ctx) error {
+		return DeleteReview(c, db)
+	})
+
+	// the business replies to a review
+	app.Put("/:id/reply", services.RequireTenant(db, services.TenantFromRecord("reviews", "id")), func(c *fiber.Ctx) error {
+		return ReplyToReview(c, services.TenantDB(db, c))
+	})
+
+	// report a review
+	app.Post("/:id/flag", func(c *fiber.Ctx) error {
+		return FlagReview(c, db)
+	})
+}
@@ -0,0 +1,393 @@
+package review
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"myproject/api/models"
+	"myproject/api/services"
+	"myproject/api/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/plugin/dbresolver"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// review sort orders for the ?sort= query param
+var reviewOrders = map[string]string{
+	"newest":  "created_at desc",
+	"oldest":  "created_at",
+	"highest": "rating desc, created_at desc",
+	"lowest":  "rating, created_at desc",
+}
+
+func isAdmin(user models.User) bool {
+	return strings.Contains(user.Roles, "admin")
+}
+
+// reviewForId load the review in the :id param
+func reviewForId(c *fiber.Ctx, db *gorm.DB) (*models.Review, error) {
+
+	var review models.Review
+	result := db.First(&review, c.Params("id"))
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		c.Status(fiber.StatusNotAcceptable)
+		utils.SendJsonResult(c, fiber.Map{"error": "No Review found with given ID"})
+		return nil, result.Error
+	}
+
+	return &review, nil
+}
+
+// queryReviews list reviews one page at a time, filtered by ?rating= and ?locationId= and sorted by ?sort=
+func queryReviews(c *fiber.Ctx, query *gorm.DB) error {
+
+	page := utils.QueryInt(c, "page", 1)
+	if page < 1 {
+		page = 1
+	}
+
+	limit := utils.QueryInt(c, "limit", defaultPageSize)
+	if limit < 1 || limit > maxPageSize {
+		limit = defaultPageSize
+	}
+
+	if rating := utils.QueryInt(c, "rating", 0); rating > 0 {
+		query = query.Where("rating = ?", rating)
+	}
+
+	if locationId := utils.QueryInt(c, "locationId", 0); locationId > 0 {
+		query = query.Where("location_id = ?", locationId)
+	}
+
+	order, ok := reviewOrders[c.Query("sort")]
+	if !ok {
+		order = reviewOrders["newest"]
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var reviews []models.Review
+	query.Preload("User", func(db *gorm.DB) *gorm.DB {
+		return db.Select("id", "name", "photo")
+	}).Order(order).Offset((page - 1) * limit).Limit(limit).Find(&reviews)
+
+	return utils.SendJsonResult(c, fiber.Map{
+		"reviews": reviews,
+		"total":   total,
+		"page":    page,
+		"limit":   limit,
+	})
+}
+
+// GetBusinessReviews list the published reviews of a business with its cached rating
+func GetBusinessReviews(c *fiber.Ctx, db *gorm.DB, businessId string) error {
+	return queryReviews(c, db.Model(&models.Review{}).Where("business_id = ? and status = 'published'", businessId))
+}
+
+// GetBusinessRating get the cached rating of a business and the number of reviews for each star
+func GetBusinessRating(c *fiber.Ctx, db *gorm.DB, businessId string) error {
+
+	var business models.Business
+	if result := db.Select("id", "rating_average", "rating_count").First(&business, businessId); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": "No Business found with given ID"})
+	}
+
+	type StarCount struct {
+		Rating int   `json:"rating"`
+		Count  int64 `json:"count"`
+	}
+
+	var stars []StarCount
+	db.Model(&models.Review{}).Select("rating, count(*) as count").
+		Where("business_id = ? and status = 'published'", business.ID).
+		Group("rating").Order("rating desc").Scan(&stars)
+
+	return utils.SendJsonResult(c, fiber.Map{
+		"average": business.RatingAverage,
+		"count":   business.RatingCount,
+		"stars":   stars,
+	})
+}
+
+// SaveReview create or update the review of the current user, who must be a verified customer of the business
+func SaveReview(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	user, err := services.VerifyFormSignature(db, c)
+	if err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	req := new(models.Review)
+	if err := c.BodyParser(req); err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return err
+	}
+
+	if req.Rating < 1 || req.Rating > 5 {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": "rating must be 1 to 5 stars"})
+	}
+
+	if services.IsBusinessOwner(db, user.ID, businessId) || services.IsTeamMember(db, user.ID, businessId) {
+		c.Status(fiber.StatusForbidden)
+		return utils.SendJsonResult(c, fiber.Map{"error": "You cannot review your own business"})
+	}
+
+	customerId := services.VerifiedCustomerOf(db, user.ID, businessId)
+	if customerId == 0 {
+		c.Status(fiber.StatusForbidden)
+		return utils.SendJsonResult(c, fiber.Map{"error": "Only verified customers can review the business"})
+	}
+
+	if req.LocationId > 0 {
+		var location models.Location
+		if result := db.First(&location, "id = ? and business_id = ?", req.LocationId, businessId); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			c.Status(fiber.StatusNotAcceptable)
+			return utils.SendJsonResult(c, fiber.Map{"error": "No Location found with given ID"})
+		}
+	}
+
+	if !services.BusinessSettings(db, businessId, req.LocationId).Bool("accept_reviews") {
+		c.Status(fiber.StatusForbidden)
+		return utils.SendJsonResult(c, fiber.Map{"error": "The business does not accept reviews"})
+	}
+
+	var review models.Review
+	db.Limit(1).Find(&review, "business_id = ? and location_id = ? and user_id = ?", businessId, req.LocationId, user.ID)
+
+	if review.Status == "hidden" {
+		c.Status(fiber.StatusForbidden)
+		return utils.SendJsonResult(c, fiber.Map{"error": "Your review was removed by a moderator"})
+	}
+
+	review.BusinessId = businessId
+	review.LocationId = req.LocationId
+	review.UserId = user.ID
+	review.CustomerId = customerId
+	review.Rating = req.Rating
+	review.Title = strings.TrimSpace(req.Title)
+	review.Text = strings.TrimSpace(req.Text)
+	if review.Status == "" {
+		review.Status = "published"
+	}
+
+	if err := db.Save(&review).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return utils.SendJsonResult(c, review)
+}
+
+// DeleteReview delete a review, by its author or an admin
+func DeleteReview(c *fiber.Ctx, db *gorm.DB) error {
+
+	user, err := services.VerifyFormSignature(db, c)
+	if err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	review, err := reviewForId(c, db)
+	if err != nil {
+		return nil
+	}
+
+	if review.UserId != user.ID && !isAdmin(user) {
+		c.Status(fiber.StatusForbidden)
+		return utils.SendJsonResult(c, fiber.Map{"error": "Only the author can delete the review"})
+	}
+
+	if err := db.Delete(review).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return utils.SendJsonResult(c, review)
+}
+
+// ReplyToReview the reviewed business answers a review, an empty reply removes it
+func ReplyToReview(c *fiber.Ctx, db *gorm.DB) error {
+
+	user, err := services.VerifyFormSignature(db, c)
+	if err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	review, err := reviewForId(c, db)
+	if err != nil {
+		return nil
+	}
+
+	if !isAdmin(user) && !services.IsBusinessOwner(db, user.ID, review.BusinessId) && !services.IsTeamMember(db, user.ID, review.BusinessId) {
+		c.Status(fiber.StatusForbidden)
+		return utils.SendJsonResult(c, fiber.Map{"error": "Only the team of the business can reply"})
+	}
+
+	type ReplyRequest struct {
+		Reply string `json:"reply"`
+	}
+
+	req := new(ReplyRequest)
+	if err := c.BodyParser(req); err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return err
+	}
+
+	review.Reply = strings.TrimSpace(req.Reply)
+	review.ReplyBy = user.ID
+	review.RepliedAt = nil
+	if review.Reply != "" {
+		now := time.Now()
+		review.RepliedAt = &now
+	}
+
+	if err := db.Model(review).Select("reply", "reply_by", "replied_at").Updates(review).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return utils.SendJsonResult(c, review)
+}
+
+// FlagReview report a review, after ReviewFlagLimit flags it is hidden until a moderator checks it
+func FlagReview(c *fiber.Ctx, db *gorm.DB) error {
+
+	user, err := services.VerifyFormSignature(db, c)
+	if err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	review, err := reviewForId(c, db)
+	if err != nil {
+		return nil
+	}
+
+	flag := new(models.ReviewFlag)
+	if err := c.BodyParser(flag); err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return err
+	}
+
+	flag.ID = 0
+	flag.ReviewId = review.ID
+	flag.UserId = user.ID
+
+	tx := db.Clauses(dbresolver.Write).Begin()
+
+	// each user can flag a review once
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(flag)
+	if result.Error != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).SendString(result.Error.Error())
+	}
+
+	if result.RowsAffected > 0 {
+		var count int64
+		tx.Model(&models.ReviewFlag{}).Where("review_id = ?", review.ID).Count(&count)
+
+		review.FlagCount = uint(count)
+		if count >= models.ReviewFlagLimit && review.Status == "published" {
+			review.Status = "pending"
+		}
+
+		if err := tx.Model(review).Select("flag_count", "status").Updates(review).Error; err != nil {
+			tx.Rollback()
+			return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return utils.SendJsonResult(c, fiber.Map{"id": review.ID, "flagged": true})
+}
+
+// GetModerationQueue list the reviews waiting for moderation or flagged, for admins
+func GetModerationQueue(c *fiber.Ctx, db *gorm.DB) error {
+
+	user, err := services.VerifyFormSignature(db, c)
+	if err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	if !isAdmin(user) {
+		c.Status(fiber.StatusForbidden)
+		return utils.SendJsonResult(c, fiber.Map{"error": "Only admins can moderate reviews"})
+	}
+
+	return queryReviews(c, db.Model(&models.Review{}).Where("status = 'pending' or flag_count > 0"))
+}
+
+// ModerateReview publish or hide a review, for admins. Publishing clears the flags
+func ModerateReview(c *fiber.Ctx, db *gorm.DB) error {
+
+	user, err := services.VerifyFormSignature(db, c)
+	if err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	if !isAdmin(user) {
+		c.Status(fiber.StatusForbidden)
+		return utils.SendJsonResult(c, fiber.Map{"error": "Only admins can moderate reviews"})
+	}
+
+	review, err := reviewForId(c, db)
+	if err != nil {
+		return nil
+	}
+
+	type ModerateRequest struct {
+		Status string `json:"status"`
+	}
+
+	req := new(ModerateRequest)
+	if err := c.BodyParser(req); err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return err
+	}
+
+	if !slices.Contains(models.ReviewStatuses, req.Status) {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": "status must be published, pending or hidden"})
+	}
+
+	tx := db.Clauses(dbresolver.Write).Begin()
+
+	review.Status = req.Status
+	if req.Status == "published" {
+		review.FlagCount = 0
+		tx.Where("review_id = ?", review.ID).Delete(&models.ReviewFlag{})
+	}
+
+	// Save so the AfterSave hook refreshes the cached ratings
+	if err := tx.Save(review).Error; err != nil {
+		tx.Rollback()
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return utils.SendJsonResult(c, review)
+}
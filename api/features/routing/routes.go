@@ -0,0 +1,25 @@
+package routing
+
+import (
+	"strconv"
+
+	"myproject/api/services"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+func idParam(c *fiber.Ctx, name string) uint {
+	id, _ := strconv.ParseUint(c.Params(name), 10, 64)
+	return uint(id)
+}
+
+// routes prefixed with /api/v1/routing
+func RoutingApiRoutes(app fiber.Router, db *gorm.DB) {
+
+	// the order to visit locations of the business or of its customers from a start point,
+	// with the arrival and departure times of each visit
+	app.Post("/:bizid/plan", services.RequireTenant(db, services.TenantFromParam("bizid")), func(c *fiber.Ctx) error {
+		return PlanVisits(c, db, idParam(c, "bizid"))
+	})
+}
@@ -0,0 +1,149 @@
+package routing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"myproject/api/services"
+	"myproject/test"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanRoute(t *testing.T) {
+	matrix := services.HaversineMatrix{SpeedKmh: 40, DetourFactor: 1.3}
+	start := services.RoutePoint{Lat: 0, Lng: 0}
+	departure := time.Date(2025, 3, 3, 8, 0, 0, 0, time.UTC)
+
+	order := func(route services.Route) []uint {
+		ids := []uint{}
+		for _, visit := range route.Visits {
+			ids = append(ids, visit.Id)
+		}
+		return ids
+	}
+
+	// along a line, visited from the closest
+	stops := []services.RouteStop{
+		{Id: 1, Point: services.RoutePoint{Lat: 0, Lng: 0.01}, Duration: 30 * time.Minute},
+		{Id: 2, Point: services.RoutePoint{Lat: 0, Lng: 0.03}, Duration: 30 * time.Minute},
+		{Id: 3, Point: services.RoutePoint{Lat: 0, Lng: 0.02}, Duration: 30 * time.Minute},
+	}
+	route, err := services.PlanRoute(context.Background(), matrix, start, stops, departure, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []uint{1, 3, 2}, order(route))
+	assert.Equal(t, "haversine", route.Matrix)
+	assert.InDelta(t, 3*1112*1.3, route.Metres, 20)
+	assert.Nil(t, route.Return)
+
+	first := route.Visits[0]
+	assert.Equal(t, first.Arrival, first.Start)
+	assert.Equal(t, first.Start.Add(30*time.Minute), first.Departure)
+	assert.Equal(t, route.Visits[2].Departure, route.Finish)
+
+	back, err := services.PlanRoute(context.Background(), matrix, start, stops, departure, true)
+	assert.Nil(t, err)
+	assert.NotNil(t, back.Return)
+	assert.InDelta(t, route.Metres*2, back.Metres, 20)
+
+	// the closest opens at 10:00 and the far one closes at 9:00, so the far one goes first
+	opensAt10 := func(at time.Time) bool { return at.Hour() >= 10 }
+	closesAt9 := func(at time.Time) bool { return at.Hour() < 9 }
+	stops = []services.RouteStop{
+		{Id: 1, Point: services.RoutePoint{Lat: 0, Lng: 0.01}, Duration: 30 * time.Minute, OpenAt: opensAt10},
+		{Id: 2, Point: services.RoutePoint{Lat: 0, Lng: 0.05}, Duration: 30 * time.Minute, OpenAt: closesAt9},
+	}
+	route, err = services.PlanRoute(context.Background(), matrix, start, stops, departure, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []uint{2, 1}, order(route))
+	assert.Equal(t, 0, route.Closed)
+	assert.Equal(t, time.Date(2025, 3, 3, 10, 0, 0, 0, time.UTC), route.Visits[1].Start)
+	assert.Greater(t, route.Visits[1].Wait, 0.0)
+
+	// never open long enough for the visit
+	stops[1].Duration = 2 * time.Hour
+	stops[1].OpenAt = func(at time.Time) bool { return at.Hour() == 8 }
+	route, _ = services.PlanRoute(context.Background(), matrix, start, stops, departure, false)
+	assert.Equal(t, 1, route.Closed)
+
+	many := make([]services.RouteStop, 51)
+	_, err = services.PlanRoute(context.Background(), matrix, start, many, departure, false)
+	assert.Equal(t, services.ErrTooManyStops, err)
+}
+
+func TestPlanVisits(t *testing.T) {
+	app, db, err := test.SetupTestApp()
+	if err != nil {
+		t.Fatalf("Failed to setup test app: %v", err)
+	}
+
+	api := app.Group("/api/v1")
+	RoutingApiRoutes(api.Group("routing"), db)
+
+	ownId, otherId := test.SetupTenants(db)
+
+	ids := []uint{}
+	for _, loc := range []struct {
+		name   string
+		latlng string
+	}{{"Centro", "4.6000,-74.0800"}, {"Lejos", "4.6300,-74.0800"}, {"Norte", "4.6135,-74.0800"}, {"Sin ubicacion", ""}} {
+		var id uint
+		db.Raw("insert into locations (business_id, name, latlng) values (?, ?, ?) returning id", ownId, loc.name, loc.latlng).Scan(&id)
+		ids = append(ids, id)
+	}
+	var otherLoc uint
+	db.Raw("insert into locations (business_id, name, latlng) values (?, 'Ajena', '4.6,-74.08') returning id", otherId).Scan(&otherLoc)
+	t.Cleanup(func() { db.Exec("delete from locations where business_id in ?", []uint{ownId, otherId}) })
+
+	plan := func(data map[string]interface{}) (int, map[string]interface{}) {
+		test.SignMap(data)
+		jsonData, _ := json.Marshal(data)
+		req := httptest.NewRequest("POST", fmt.Sprintf("/api/v1/routing/%d/plan", ownId), bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+
+		var result map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+
+	t.Run("Ordered itinerary", func(t *testing.T) {
+		status, result := plan(map[string]interface{}{
+			"locationIds": ids[:3],
+			"startLatlng": "4.5900,-74.0800",
+			"departAt":    "2025-03-03T08:00:00-05:00",
+			"durations":   map[string]int{fmt.Sprint(ids[1]): 45},
+		})
+		assert.Equal(t, 200, status, fmt.Sprint(result))
+
+		itinerary := result["result"].(map[string]interface{})
+		names := []string{}
+		for _, item := range itinerary["visits"].([]interface{}) {
+			visit := item.(map[string]interface{})
+			names = append(names, visit["location"].(map[string]interface{})["name"].(string))
+		}
+		assert.Equal(t, []string{"Centro", "Norte", "Lejos"}, names)
+
+		last := itinerary["visits"].([]interface{})[2].(map[string]interface{})
+		assert.Equal(t, 45.0, last["minutes"])
+		assert.NotEmpty(t, last["arrival"])
+	})
+
+	t.Run("Invalid requests", func(t *testing.T) {
+		status, _ := plan(map[string]interface{}{"locationIds": ids[:1]})
+		assert.Equal(t, 406, status)
+
+		status, _ = plan(map[string]interface{}{"locationIds": ids[3:], "startLatlng": "4.59,-74.08"})
+		assert.Equal(t, 406, status)
+
+		status, _ = plan(map[string]interface{}{"locationIds": []uint{otherLoc}, "startLatlng": "4.59,-74.08"})
+		assert.Equal(t, 406, status)
+	})
+}
@@ -0,0 +1,140 @@
+package routing
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"myproject/api/models"
+	"myproject/api/services"
+	"myproject/api/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// planRequest the visits to plan
+type planRequest struct {
+	LocationIds     []uint               `json:"locationIds"`
+	Start           *services.RoutePoint `json:"start"`       // or StartLatlng
+	StartLatlng     string               `json:"startLatlng"` // e.g. "4.6560,-74.0557"
+	DepartAt        string               `json:"departAt"`    // RFC3339, now when empty
+	Durations       map[string]int       `json:"durations"`   // minutes by location id, visit_minutes of the location otherwise
+	DefaultDuration int                  `json:"defaultDuration"`
+	ReturnToStart   bool                 `json:"returnToStart"`
+}
+
+// PlannedVisit a visit of the itinerary and its location
+type PlannedVisit struct {
+	services.RouteVisit
+	Location models.Location `json:"location"`
+}
+
+// Itinerary the planned route
+type Itinerary struct {
+	services.Route
+	Visits []PlannedVisit `json:"visits"`
+}
+
+// visitDuration the minutes asked for the location, then the default of the request, then the visit_minutes config
+func visitDuration(db *gorm.DB, req *planRequest, location models.Location) time.Duration {
+	minutes, ok := req.Durations[strconv.FormatUint(uint64(location.ID), 10)]
+	if !ok || minutes <= 0 {
+		minutes = req.DefaultDuration
+	}
+	if minutes <= 0 {
+		minutes = services.BusinessSettings(db, location.BusinessId, location.ID).Int("visit_minutes")
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// PlanVisits order the visits to the locations of the request from its start, respecting their opening hours
+func PlanVisits(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	if _, err := services.VerifyFormSignature(db, c); err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	req := new(planRequest)
+	if err := c.BodyParser(req); err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	invalid := func(err error) error {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": err.Error()})
+	}
+
+	start := req.Start
+	if start == nil && req.StartLatlng != "" {
+		if point, ok := services.RoutePointOf(req.StartLatlng); ok {
+			start = &point
+		}
+	}
+	if start == nil {
+		return invalid(errors.New("a start point is required"))
+	}
+
+	departure := time.Now()
+	if req.DepartAt != "" {
+		at, err := time.Parse(time.RFC3339, req.DepartAt)
+		if err != nil {
+			return invalid(errors.New("departAt must be an RFC3339 time"))
+		}
+		departure = at
+	}
+
+	if len(req.LocationIds) == 0 {
+		return invalid(errors.New("locationIds is required"))
+	}
+
+	// the locations of the business and of its customers, the tenant scope would hide the latter
+	var locations []models.Location
+	db.Where("id in ?", req.LocationIds).
+		Where("business_id = ? or business_id in (select customer_id from business_customers where business_id = ?)", businessId, businessId).
+		Find(&locations)
+
+	byId := map[uint]models.Location{}
+	for _, location := range locations {
+		byId[location.ID] = location
+	}
+
+	stops := []services.RouteStop{}
+	planned := map[uint]bool{}
+	for _, id := range req.LocationIds {
+		location, ok := byId[id]
+		if !ok {
+			return invalid(fmt.Errorf("location %d not found", id))
+		}
+		point, ok := services.RoutePointOf(location.Latlng)
+		if !ok {
+			return invalid(fmt.Errorf("location %d has no latlng", id))
+		}
+		if planned[id] {
+			continue
+		}
+		planned[id] = true
+
+		stops = append(stops, services.RouteStop{
+			Id:       location.ID,
+			Point:    point,
+			Duration: visitDuration(db, req, location),
+			OpenAt:   services.LocationOpenAt(db, location),
+		})
+	}
+
+	route, err := services.PlanRoute(c.Context(), services.CurrentDistanceMatrix(db), *start, stops, departure, req.ReturnToStart)
+	if err != nil {
+		return invalid(err)
+	}
+
+	itinerary := Itinerary{Route: route, Visits: []PlannedVisit{}}
+	for _, visit := range route.Visits {
+		itinerary.Visits = append(itinerary.Visits, PlannedVisit{RouteVisit: visit, Location: byId[visit.Id]})
+	}
+
+	return utils.SendJsonResult(c, itinerary)
+}
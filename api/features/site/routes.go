@@ -0,0 +1,69 @@
+package site
+
+import (
+	"strconv"
+
+	"myproject/api/services"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+func idParam(c *fiber.Ctx, name string) uint {
+	id, _ := strconv.ParseUint(c.Params(name), 10, 64)
+	return uint(id)
+}
+
+// routes prefixed with /api/v1/sites
+//
+// The sites, buildings, floors, rooms and areas of a location form a tree of models.LocationNode
+func SiteApiRoutes(app fiber.Router, db *gorm.DB) {
+
+	// the tree of a location, with the totals of each node when rollups is true
+	app.Post("/location/:id/tree", services.RequireTenant(db, services.TenantFromRecord("locations", "id")), func(c *fiber.Ctx) error {
+		return GetTree(c, db, idParam(c, "id"))
+	})
+
+	// add a node to the tree of a location
+	app.Post("/location/:id", services.RequireTenant(db, services.TenantFromRecord("locations", "id")), func(c *fiber.Ctx) error {
+		return CreateNode(c, db, idParam(c, "id"))
+	})
+
+	// rename a node or change its code or notes
+	app.Put("/:id", services.RequireTenant(db, services.TenantFromRecord("location_nodes", "id")), func(c *fiber.Ctx) error {
+		return UpdateNode(c, db, idParam(c, "id"))
+	})
+
+	// delete a node without children, with its images and placements
+	app.Delete("/:id", services.RequireTenant(db, services.TenantFromRecord("location_nodes", "id")), func(c *fiber.Ctx) error {
+		return DeleteNode(c, db, idParam(c, "id"))
+	})
+
+	// move a node and its subtree under another parent, or to another position among its siblings
+	app.Put("/:id/move", services.RequireTenant(db, services.TenantFromRecord("location_nodes", "id")), func(c *fiber.Ctx) error {
+		return MoveNode(c, db, idParam(c, "id"))
+	})
+
+	// the equipment, stock and open work of a node and its subtree
+	app.Post("/:id/rollup", services.RequireTenant(db, services.TenantFromRecord("location_nodes", "id")), func(c *fiber.Ctx) error {
+		return GetRollup(c, db, idParam(c, "id"))
+	})
+
+	// add a photo or a floor plan, multipart form with the image in "file" and kind photo or floor_plan
+	app.Post("/:id/assets", services.RequireTenant(db, services.TenantFromRecord("location_nodes", "id")), func(c *fiber.Ctx) error {
+		return AddNodeImage(c, db, idParam(c, "id"))
+	})
+
+	app.Delete("/:id/assets/:assetId", services.RequireTenant(db, services.TenantFromRecord("location_nodes", "id")), func(c *fiber.Ctx) error {
+		return DeleteNodeImage(c, db, idParam(c, "id"), idParam(c, "assetId"))
+	})
+
+	// place a piece of equipment or a bin in a node, moving it from the node it was in
+	app.Post("/:id/placements", services.RequireTenant(db, services.TenantFromRecord("location_nodes", "id")), func(c *fiber.Ctx) error {
+		return PlaceObject(c, db, idParam(c, "id"))
+	})
+
+	app.Delete("/:id/placements/:placementId", services.RequireTenant(db, services.TenantFromRecord("location_nodes", "id")), func(c *fiber.Ctx) error {
+		return RemovePlacement(c, db, idParam(c, "id"), idParam(c, "placementId"))
+	})
+}
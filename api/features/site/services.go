@@ -0,0 +1,367 @@
+package site
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"myproject/api/models"
+	"myproject/api/services"
+	"myproject/api/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+func sendError(c *fiber.Ctx, status int, message string) error {
+	c.Status(status)
+	return utils.SendJsonResult(c, fiber.Map{"error": message})
+}
+
+// nodeFor the node of the request in the scope of the tenant, sends the error when it is not found
+func nodeFor(c *fiber.Ctx, db *gorm.DB, id uint) (models.User, *models.LocationNode, error) {
+
+	user, err := services.VerifyFormSignature(db, c)
+	if err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return user, nil, err
+	}
+
+	node := new(models.LocationNode)
+	if result := services.TenantDB(db, c).First(node, id); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return user, nil, sendError(c, fiber.StatusNotFound, "No node found with given ID")
+	}
+
+	return user, node, nil
+}
+
+// nodeImages the photos and floor plans of nodes
+func nodeImages(db *gorm.DB) *gorm.DB {
+	return db.Where("asset_type in ?", []string{models.SiteNodePhoto, models.SiteNodeFloorPlan}).Order("sequence")
+}
+
+// GetTree the nodes of a location nested by parent with their images and placements
+func GetTree(c *fiber.Ctx, db *gorm.DB, locationId uint) error {
+
+	if _, err := services.VerifyFormSignature(db, c); err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	var options struct {
+		Rollups bool `json:"rollups"`
+	}
+	c.BodyParser(&options)
+
+	tdb := services.TenantDB(db, c)
+
+	var nodes []models.LocationNode
+	tdb.Preload("Assets", nodeImages).Preload("Placements").Find(&nodes, "location_id = ?", locationId)
+
+	result := fiber.Map{"nodes": services.SiteTree(nodes)}
+	if options.Rollups {
+		rollups, err := services.SiteRollups(tdb, locationId)
+		if err != nil {
+			return sendError(c, fiber.StatusInternalServerError, err.Error())
+		}
+		result["rollups"] = rollups
+	}
+
+	return utils.SendJsonResult(c, result)
+}
+
+// CreateNode add a site, building, floor, room or area to a location
+func CreateNode(c *fiber.Ctx, db *gorm.DB, locationId uint) error {
+
+	user, err := services.VerifyFormSignature(db, c)
+	if err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	tdb := services.TenantDB(db, c)
+
+	var location models.Location
+	if result := tdb.First(&location, locationId); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return sendError(c, fiber.StatusNotFound, "No location found with given ID")
+	}
+
+	node := new(models.LocationNode)
+	if err := c.BodyParser(node); err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+	node.ID = 0
+	node.Assets, node.Placements = nil, nil
+	node.BusinessId = location.BusinessId
+	node.LocationId = location.ID
+	node.UpdatedBy = user.ID
+
+	if err := services.CreateSiteNode(tdb, node); err != nil {
+		if errors.Is(err, services.ErrInvalidSiteNode) {
+			return sendError(c, fiber.StatusNotAcceptable, err.Error())
+		}
+		return sendError(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	return utils.SendJsonResult(c, node)
+}
+
+// UpdateNode change the name, code or notes of a node, its kind when still valid in its parent and for its children
+func UpdateNode(c *fiber.Ctx, db *gorm.DB, id uint) error {
+
+	user, node, err := nodeFor(c, db, id)
+	if node == nil {
+		return err
+	}
+
+	var changes struct {
+		Name  *string `json:"name"`
+		Code  *string `json:"code"`
+		Notes *string `json:"notes"`
+		Kind  *string `json:"kind"`
+	}
+	if err := c.BodyParser(&changes); err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	tdb := services.TenantDB(db, c)
+
+	if changes.Name != nil {
+		if *changes.Name == "" {
+			return sendError(c, fiber.StatusNotAcceptable, "a name is required")
+		}
+		node.Name = *changes.Name
+	}
+	if changes.Code != nil {
+		node.Code = *changes.Code
+	}
+	if changes.Notes != nil {
+		node.Notes = *changes.Notes
+	}
+	if changes.Kind != nil && *changes.Kind != node.Kind {
+		parentKind := ""
+		if node.ParentId > 0 {
+			tdb.Model(&models.LocationNode{}).Where("id = ?", node.ParentId).Pluck("kind", &parentKind)
+		}
+		var childKinds []string
+		tdb.Model(&models.LocationNode{}).Where("parent_id = ?", node.ID).Pluck("kind", &childKinds)
+
+		valid := services.ValidSiteChild(parentKind, *changes.Kind)
+		for _, kind := range childKinds {
+			valid = valid && services.ValidSiteChild(*changes.Kind, kind)
+		}
+		if !valid {
+			return sendError(c, fiber.StatusNotAcceptable, fmt.Sprintf("the node cannot be a %s in its place in the tree", *changes.Kind))
+		}
+		node.Kind = *changes.Kind
+	}
+
+	node.UpdatedBy = user.ID
+	if err := tdb.Save(node).Error; err != nil {
+		return sendError(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	return utils.SendJsonResult(c, node)
+}
+
+// DeleteNode delete a node that has no children, with its images and placements
+func DeleteNode(c *fiber.Ctx, db *gorm.DB, id uint) error {
+
+	_, node, err := nodeFor(c, db, id)
+	if node == nil {
+		return err
+	}
+
+	tdb := services.TenantDB(db, c)
+
+	var children int64
+	tdb.Model(&models.LocationNode{}).Where("parent_id = ?", node.ID).Count(&children)
+	if children > 0 {
+		return sendError(c, fiber.StatusNotAcceptable, "move or delete the children of the node first")
+	}
+
+	err = tdb.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("node_id = ?", node.ID).Delete(&models.LocationPlacement{}).Error; err != nil {
+			return err
+		}
+		if err := nodeImages(tx).Where("object_id = ?", node.ID).Delete(&models.Asset{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(node).Error
+	})
+	if err != nil {
+		return sendError(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	return utils.SendJsonResult(c, node)
+}
+
+// MoveNode move a node under a parent of the same location, 0 for the top, at a position among its children
+func MoveNode(c *fiber.Ctx, db *gorm.DB, id uint) error {
+
+	_, node, err := nodeFor(c, db, id)
+	if node == nil {
+		return err
+	}
+
+	var move struct {
+		ParentId uint `json:"parentId"`
+		Position int  `json:"position"`
+	}
+	if err := c.BodyParser(&move); err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	if err := services.MoveSiteNode(services.TenantDB(db, c), node, move.ParentId, move.Position); err != nil {
+		if errors.Is(err, services.ErrInvalidSiteNode) {
+			return sendError(c, fiber.StatusNotAcceptable, err.Error())
+		}
+		return sendError(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	return utils.SendJsonResult(c, node)
+}
+
+// GetRollup the totals of the subtree of a node
+func GetRollup(c *fiber.Ctx, db *gorm.DB, id uint) error {
+
+	_, node, err := nodeFor(c, db, id)
+	if node == nil {
+		return err
+	}
+
+	rollups, err := services.SiteRollups(services.TenantDB(db, c), node.LocationId)
+	if err != nil {
+		return sendError(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	return utils.SendJsonResult(c, rollups[node.ID])
+}
+
+// AddNodeImage save a photo or a floor plan of a node as an asset
+func AddNodeImage(c *fiber.Ctx, db *gorm.DB, id uint) error {
+
+	user, node, err := nodeFor(c, db, id)
+	if node == nil {
+		return err
+	}
+
+	assetType := models.SiteNodePhoto
+	if c.FormValue("kind") == "floor_plan" {
+		assetType = models.SiteNodeFloorPlan
+	}
+
+	var location models.Location
+	db.First(&location, node.LocationId)
+
+	url, err := utils.SaveImage(c, "file", "", "site", location.Country, location.Province, node.BusinessId)
+	if err != nil {
+		return sendError(c, fiber.StatusNotAcceptable, err.Error())
+	}
+	if url == "" {
+		return sendError(c, fiber.StatusNotAcceptable, "an image is required in file")
+	}
+
+	asset := models.Asset{
+		BusinessId: node.BusinessId,
+		LocationId: node.LocationId,
+		ObjectId:   node.ID,
+		AssetType:  assetType,
+		Name:       c.FormValue("name"),
+		Url:        url,
+		UpdatedBy:  user.ID,
+	}
+	if err := services.TenantDB(db, c).Create(&asset).Error; err != nil {
+		return sendError(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	return utils.SendJsonResult(c, asset)
+}
+
+// DeleteNodeImage remove a photo or a floor plan of a node
+func DeleteNodeImage(c *fiber.Ctx, db *gorm.DB, id, assetId uint) error {
+
+	_, node, err := nodeFor(c, db, id)
+	if node == nil {
+		return err
+	}
+
+	var asset models.Asset
+	if result := nodeImages(services.TenantDB(db, c)).First(&asset, "id = ? and object_id = ?", assetId, node.ID); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return sendError(c, fiber.StatusNotFound, "No image found with given ID")
+	}
+	services.TenantDB(db, c).Delete(&asset)
+
+	return utils.SendJsonResult(c, asset)
+}
+
+// PlaceObject place a piece of equipment or a bin of the location of the node in it, at a point of its floor plan
+func PlaceObject(c *fiber.Ctx, db *gorm.DB, id uint) error {
+
+	user, node, err := nodeFor(c, db, id)
+	if node == nil {
+		return err
+	}
+
+	placement := new(models.LocationPlacement)
+	if err := c.BodyParser(placement); err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	if !slices.Contains([]string{models.PlacementEquipment, models.PlacementBin}, placement.Kind) {
+		return sendError(c, fiber.StatusNotAcceptable, "kind must be equipment or bin")
+	}
+	if placement.X < 0 || placement.X > 1 || placement.Y < 0 || placement.Y > 1 {
+		return sendError(c, fiber.StatusNotAcceptable, "x and y must be between 0 and 1")
+	}
+
+	// the object must be at the location of the node
+	table := "bins"
+	if placement.Kind == models.PlacementEquipment {
+		table = "equipment"
+	}
+	var locationIds []uint
+	db.Table(table).Where("id = ?", placement.ObjectId).Pluck("location_id", &locationIds)
+	if len(locationIds) == 0 || locationIds[0] != node.LocationId {
+		return sendError(c, fiber.StatusNotAcceptable, fmt.Sprintf("No %s found with given ID at the location", placement.Kind))
+	}
+
+	tdb := services.TenantDB(db, c)
+
+	// an object is in one node, placing it again moves it
+	var existing models.LocationPlacement
+	tdb.Where("kind = ? and object_id = ?", placement.Kind, placement.ObjectId).First(&existing)
+
+	placement.ID = existing.ID
+	placement.CreatedAt = existing.CreatedAt
+	placement.BusinessId = node.BusinessId
+	placement.NodeId = node.ID
+	placement.UpdatedBy = user.ID
+	if err := tdb.Save(placement).Error; err != nil {
+		return sendError(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	return utils.SendJsonResult(c, placement)
+}
+
+// RemovePlacement take a piece of equipment or a bin out of a node
+func RemovePlacement(c *fiber.Ctx, db *gorm.DB, id, placementId uint) error {
+
+	_, node, err := nodeFor(c, db, id)
+	if node == nil {
+		return err
+	}
+
+	var placement models.LocationPlacement
+	if result := services.TenantDB(db, c).First(&placement, "id = ? and node_id = ?", placementId, node.ID); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return sendError(c, fiber.StatusNotFound, "No placement found with given ID")
+	}
+	services.TenantDB(db, c).Delete(&placement)
+
+	return utils.SendJsonResult(c, placement)
+}
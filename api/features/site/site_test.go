@@ -0,0 +1,162 @@
+package site
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"myproject/api/models"
+	"myproject/api/services"
+	"myproject/test"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSiteTree(t *testing.T) {
+	assert.True(t, services.ValidSiteChild("", "floor"))
+	assert.True(t, services.ValidSiteChild("site", "building"))
+	assert.True(t, services.ValidSiteChild("building", "room"))
+	assert.True(t, services.ValidSiteChild("room", "area"))
+	assert.True(t, services.ValidSiteChild("area", "area"))
+	assert.False(t, services.ValidSiteChild("floor", "building"))
+	assert.False(t, services.ValidSiteChild("room", "room"))
+	assert.False(t, services.ValidSiteChild("", "wing"))
+
+	nodes := []models.LocationNode{
+		{ID: 4, ParentId: 1, Position: 1, Name: "Floor 2"},
+		{ID: 1, Name: "Main building"},
+		{ID: 3, ParentId: 1, Position: 0, Name: "Floor 1"},
+		{ID: 5, ParentId: 3, Name: "Kitchen"},
+		{ID: 2, Position: 1, Name: "Warehouse"},
+	}
+	tree := services.SiteTree(nodes)
+	assert.Len(t, tree, 2)
+	assert.Equal(t, "Main building", tree[0].Name)
+	assert.Equal(t, "Floor 1", tree[0].Children[0].Name)
+	assert.Equal(t, "Floor 2", tree[0].Children[1].Name)
+	assert.Equal(t, "Kitchen", tree[0].Children[0].Children[0].Name)
+	assert.Equal(t, "Warehouse", tree[1].Name)
+}
+
+func TestSiteNodes(t *testing.T) {
+	app, db, err := test.SetupTestApp()
+	if err != nil {
+		t.Fatalf("Failed to setup test app: %v", err)
+	}
+
+	api := app.Group("/api/v1")
+	SiteApiRoutes(api.Group("sites"), db)
+
+	ownId, otherId := test.SetupTenants(db)
+
+	var locationId, otherLocationId uint
+	db.Raw("insert into locations (business_id, name) values (?, 'Hospital') returning id", ownId).Scan(&locationId)
+	db.Raw("insert into locations (business_id, name) values (?, 'Other') returning id", otherId).Scan(&otherLocationId)
+	t.Cleanup(func() {
+		db.Exec("delete from location_placements where business_id = ?", ownId)
+		db.Exec("delete from location_nodes where location_id = ?", locationId)
+		db.Exec("delete from bin_infos where business_id = ?", ownId)
+		db.Exec("delete from bins where business_id = ?", ownId)
+		db.Exec("delete from locations where id in ?", []uint{locationId, otherLocationId})
+	})
+
+	signedRequest := func(method, url string, data map[string]interface{}) (int, map[string]interface{}) {
+		test.SignMap(data)
+		jsonData, _ := json.Marshal(data)
+		req := httptest.NewRequest(method, url, bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+
+		var result map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+
+	create := func(parentId uint, kind, name string) uint {
+		status, result := signedRequest("POST", fmt.Sprintf("/api/v1/sites/location/%d", locationId),
+			map[string]interface{}{"parentId": parentId, "kind": kind, "name": name, "position": 99})
+		assert.Equal(t, 200, status, fmt.Sprint(result))
+		return uint(result["result"].(map[string]interface{})["id"].(float64))
+	}
+
+	site := create(0, "site", "Campus")
+	north := create(site, "building", "North")
+	south := create(site, "building", "South")
+	floor := create(north, "floor", "Ground floor")
+	room := create(floor, "room", "Theatre 1")
+
+	t.Run("Invalid nodes", func(t *testing.T) {
+		status, _ := signedRequest("POST", fmt.Sprintf("/api/v1/sites/location/%d", locationId), map[string]interface{}{"parentId": room, "kind": "building", "name": "Annex"})
+		assert.Equal(t, 406, status)
+
+		status, _ = signedRequest("POST", fmt.Sprintf("/api/v1/sites/location/%d", otherLocationId), map[string]interface{}{"kind": "site", "name": "Theirs"})
+		assert.Equal(t, 403, status)
+
+		status, _ = signedRequest("PUT", fmt.Sprintf("/api/v1/sites/%d/move", north), map[string]interface{}{"parentId": room})
+		assert.Equal(t, 406, status)
+	})
+
+	t.Run("Move and reorder", func(t *testing.T) {
+		status, _ := signedRequest("PUT", fmt.Sprintf("/api/v1/sites/%d/move", south), map[string]interface{}{"parentId": site, "position": 0})
+		assert.Equal(t, 200, status)
+
+		var nodes []models.LocationNode
+		db.Order("position").Find(&nodes, "parent_id = ?", site)
+		assert.Equal(t, []uint{south, north}, []uint{nodes[0].ID, nodes[1].ID})
+
+		// the floor and its room move to the south building
+		status, _ = signedRequest("PUT", fmt.Sprintf("/api/v1/sites/%d/move", floor), map[string]interface{}{"parentId": south})
+		assert.Equal(t, 200, status)
+
+		var moved models.LocationNode
+		db.First(&moved, room)
+		assert.Equal(t, fmt.Sprintf("/%d/%d/%d/%d/", site, south, floor, room), moved.Path)
+		assert.Equal(t, 3, moved.Depth)
+	})
+
+	t.Run("Placements and rollups", func(t *testing.T) {
+		var binId, subBinId uint
+		db.Raw("insert into bins (business_id, location_id, name) values (?, ?, 'Cart') returning id", ownId, locationId).Scan(&binId)
+		db.Raw("insert into bins (business_id, location_id, bin_id, name) values (?, ?, ?, 'Drawer') returning id", ownId, locationId, binId).Scan(&subBinId)
+		db.Exec("insert into bin_infos (business_id, location_id, bin_id, quantity) values (?, ?, ?, 5), (?, ?, ?, 7)", ownId, locationId, binId, ownId, locationId, subBinId)
+
+		status, _ := signedRequest("POST", fmt.Sprintf("/api/v1/sites/%d/placements", room), map[string]interface{}{"kind": "bin", "objectId": binId, "x": 0.5, "y": 0.25})
+		assert.Equal(t, 200, status)
+
+		status, _ = signedRequest("POST", fmt.Sprintf("/api/v1/sites/%d/placements", room), map[string]interface{}{"kind": "bin", "objectId": binId, "x": 2})
+		assert.Equal(t, 406, status)
+
+		status, result := signedRequest("POST", fmt.Sprintf("/api/v1/sites/%d/rollup", site), map[string]interface{}{})
+		assert.Equal(t, 200, status)
+		rollup := result["result"].(map[string]interface{})
+		assert.Equal(t, 4.0, rollup["nodes"])
+		assert.Equal(t, 1.0, rollup["bins"])
+		assert.Equal(t, 12.0, rollup["stock"])
+
+		status, result = signedRequest("POST", fmt.Sprintf("/api/v1/sites/%d/rollup", north), map[string]interface{}{})
+		assert.Equal(t, 200, status)
+		assert.Equal(t, 0.0, result["result"].(map[string]interface{})["stock"])
+
+		status, result = signedRequest("POST", fmt.Sprintf("/api/v1/sites/location/%d/tree", locationId), map[string]interface{}{"rollups": true})
+		assert.Equal(t, 200, status)
+		tree := result["result"].(map[string]interface{})
+		assert.Len(t, tree["nodes"], 1)
+		assert.Contains(t, tree["rollups"], fmt.Sprint(south))
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		status, _ := signedRequest("DELETE", fmt.Sprintf("/api/v1/sites/%d", floor), map[string]interface{}{})
+		assert.Equal(t, 406, status)
+
+		status, _ = signedRequest("DELETE", fmt.Sprintf("/api/v1/sites/%d", room), map[string]interface{}{})
+		assert.Equal(t, 200, status)
+
+		var placements int64
+		db.Model(&models.LocationPlacement{}).Where("node_id = ?", room).Count(&placements)
+		assert.Equal(t, int64(0), placements)
+	})
+}
@@ -0,0 +1,26 @@
+package spatial
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// routes prefixed with /api/v1/spatial
+//
+// The body of the searches is a services.SpatialQuery with
+//
+//	format: json (default) or geojson for a FeatureCollection
+//	properties: the fields of the GeoJSON features, see LocationProperties and BusinessProperties
+//	businessId: only the locations of this business, including the hidden ones when the user has access to it
+func SpatialApiRoutes(app fiber.Router, db *gorm.DB) {
+
+	// locations within a radius, bounding box or polygon, or the nearest to a point
+	app.Post("/locations", func(c *fiber.Ctx) error {
+		return SearchLocations(c, db)
+	})
+
+	// enabled businesses within a radius, bounding box or polygon, or the nearest to a point
+	app.Post("/businesses", func(c *fiber.Ctx) error {
+		return SearchBusinesses(c, db)
+	})
+}
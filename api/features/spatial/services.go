@@ -0,0 +1,180 @@
+package spatial
+
+import (
+	"errors"
+	"fmt"
+
+	"myproject/api/models"
+	"myproject/api/services"
+	"myproject/api/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// the fields of a location that can be properties of its GeoJSON feature
+var LocationProperties = []string{"name", "businessId", "identity", "address", "city", "province", "country", "phone", "website", "photo", "rank", "ratingAverage", "ratingCount", "timezone"}
+
+// the fields of a business that can be properties of its GeoJSON feature
+var BusinessProperties = []string{"name", "type", "address", "city", "province", "country", "phone", "website", "photo", "summary", "uuid", "ratingAverage", "ratingCount"}
+
+// spatialRequest a spatial query and how to send the results
+type spatialRequest struct {
+	services.SpatialQuery
+	Format     string   `json:"format"` // json or geojson
+	Properties []string `json:"properties"`
+	BusinessId uint     `json:"businessId"`
+}
+
+// LocationHit a location found by a spatial query
+type LocationHit struct {
+	models.Location
+	Distance float64 `json:"distance"` // metres from the origin of the query
+}
+
+// BusinessHit a business found by a spatial query
+type BusinessHit struct {
+	models.Business
+	Distance float64 `json:"distance"`
+}
+
+func parseSpatialRequest(c *fiber.Ctx, db *gorm.DB) (models.User, *spatialRequest, error) {
+	user, err := services.VerifyFormSignature(db, c)
+	if err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return user, nil, err
+	}
+
+	req := new(spatialRequest)
+	if err := c.BodyParser(req); err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return user, nil, err
+	}
+
+	if req.Format != "" && req.Format != "json" && req.Format != "geojson" {
+		c.Status(fiber.StatusBadRequest)
+		err := errors.New("format must be json or geojson")
+		utils.SendJsonResult(c, fiber.Map{"error": err.Error()})
+		return user, nil, err
+	}
+	if err := req.Validate(); err != nil {
+		c.Status(fiber.StatusBadRequest)
+		utils.SendJsonResult(c, fiber.Map{"error": err.Error()})
+		return user, nil, err
+	}
+
+	return user, req, nil
+}
+
+// SearchLocations the locations matching a spatial query, ordered by distance when asked for
+func SearchLocations(c *fiber.Ctx, db *gorm.DB) error {
+
+	user, req, err := parseSpatialRequest(c, db)
+	if err != nil {
+		return nil
+	}
+
+	hits, err := services.SpatialSearch(db, "locations", req.SpatialQuery, func(tx *gorm.DB) *gorm.DB {
+		if req.BusinessId > 0 && services.CanReadBusiness(db, user, req.BusinessId) {
+			return tx.Where("locations.business_id = ?", req.BusinessId)
+		}
+		// the locations of the businesses visible to everyone
+		tx = tx.Where("locations.business_id in (select id from businesses where enabled and deleted_at is null)")
+		if req.BusinessId > 0 {
+			tx = tx.Where("locations.business_id = ?", req.BusinessId)
+		}
+		return tx
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	var locations []models.Location
+	if len(hits) > 0 {
+		db.Find(&locations, services.SpatialIds(hits))
+	}
+	byId := map[uint]models.Location{}
+	for _, location := range locations {
+		byId[location.ID] = location
+	}
+
+	if req.Format == "geojson" {
+		properties := services.GeoJSONProperties(req.Properties, LocationProperties)
+		collection := services.GeoJSONFeatureCollection{Type: "FeatureCollection", Features: []services.GeoJSONFeature{}}
+		for _, hit := range hits {
+			location, ok := byId[hit.ID]
+			if !ok {
+				continue
+			}
+			feature := services.NewGeoJSONFeature(location.ID, location.Latlng, location, properties)
+			if req.HasOrigin() {
+				feature.Properties["distance"] = hit.Distance
+			}
+			collection.Features = append(collection.Features, feature)
+		}
+		c.Set(fiber.HeaderContentType, "application/geo+json")
+		return c.JSON(collection)
+	}
+
+	result := []LocationHit{}
+	for _, hit := range hits {
+		if location, ok := byId[hit.ID]; ok {
+			result = append(result, LocationHit{Location: location, Distance: hit.Distance})
+		}
+	}
+	return utils.SendJsonResult(c, result)
+}
+
+// SearchBusinesses the enabled businesses matching a spatial query, ordered by distance when asked for
+func SearchBusinesses(c *fiber.Ctx, db *gorm.DB) error {
+
+	_, req, err := parseSpatialRequest(c, db)
+	if err != nil {
+		return nil
+	}
+
+	hits, err := services.SpatialSearch(db, "businesses", req.SpatialQuery, func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("businesses.enabled")
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	var businesses []models.Business
+	if len(hits) > 0 {
+		db.Select("id", "name", "type", "address", "city", "province", "country", "phone", "website", "photo", "summary", "uuid",
+			"rating_average", "rating_count", "latlng", "enabled").Find(&businesses, services.SpatialIds(hits))
+	}
+	byId := map[uint]models.Business{}
+	for _, business := range businesses {
+		byId[business.ID] = business
+	}
+
+	if req.Format == "geojson" {
+		properties := services.GeoJSONProperties(req.Properties, BusinessProperties)
+		collection := services.GeoJSONFeatureCollection{Type: "FeatureCollection", Features: []services.GeoJSONFeature{}}
+		for _, hit := range hits {
+			business, ok := byId[hit.ID]
+			if !ok {
+				continue
+			}
+			feature := services.NewGeoJSONFeature(business.ID, business.Latlng, business, properties)
+			if req.HasOrigin() {
+				feature.Properties["distance"] = hit.Distance
+			}
+			collection.Features = append(collection.Features, feature)
+		}
+		c.Set(fiber.HeaderContentType, "application/geo+json")
+		return c.JSON(collection)
+	}
+
+	result := []BusinessHit{}
+	for _, hit := range hits {
+		if business, ok := byId[hit.ID]; ok {
+			result = append(result, BusinessHit{Business: business, Distance: hit.Distance})
+		}
+	}
+	return utils.SendJsonResult(c, result)
+}
@@ -0,0 +1,154 @@
+package spatial
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"myproject/api/services"
+	"myproject/test"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpatialQueryValidate(t *testing.T) {
+	lat, lng := 4.6560, -74.0557
+	bad := 91.0
+
+	valid := []services.SpatialQuery{
+		{Within: "radius", Lat: &lat, Lng: &lng, Radius: 1000},
+		{Within: "nearest", Lat: &lat, Lng: &lng},
+		{Within: "bbox", Bbox: []float64{-75, 4, -74, 5}},
+		{Within: "polygon", Polygon: [][2]float64{{-75, 4}, {-74, 4}, {-74, 5}}, Lat: &lat, Lng: &lng, Sort: "distance"},
+	}
+	for _, q := range valid {
+		assert.Nil(t, q.Validate(), q.Within)
+	}
+
+	invalid := []services.SpatialQuery{
+		{Within: "radius", Lat: &lat, Lng: &lng},
+		{Within: "radius", Lat: &bad, Lng: &lng, Radius: 1000},
+		{Within: "nearest"},
+		{Within: "bbox", Bbox: []float64{-74, 4, -75, 5}},
+		{Within: "polygon", Polygon: [][2]float64{{-75, 4}, {-74, 4}}},
+		{Within: "bbox", Bbox: []float64{-75, 4, -74, 5}, Sort: "distance"},
+		{Within: "circle"},
+	}
+	for _, q := range invalid {
+		assert.ErrorIs(t, q.Validate(), services.ErrInvalidSpatialQuery, q.Within)
+	}
+
+	// the polygon is closed and the limit capped
+	q := services.SpatialQuery{Within: "polygon", Polygon: [][2]float64{{-75, 4}, {-74, 4}, {-74, 5}}, Limit: 5000}
+	assert.Nil(t, q.Validate())
+	assert.Len(t, q.Polygon, 4)
+	assert.Equal(t, 1000, q.Limit)
+
+	_, err := services.ParseRadiusQuery("4.65", "-74.05); drop table locations; --", "1000")
+	assert.ErrorIs(t, err, services.ErrInvalidSpatialQuery)
+
+	feature := services.NewGeoJSONFeature(7, "4.656, -74.0557", map[string]interface{}{"name": "Store", "phone": "123"}, []string{"name"})
+	assert.Equal(t, [2]float64{-74.0557, 4.656}, feature.Geometry.Coordinates)
+	assert.Equal(t, map[string]interface{}{"name": "Store"}, feature.Properties)
+}
+
+func TestSpatialSearch(t *testing.T) {
+	app, db, err := test.SetupTestApp()
+	if err != nil {
+		t.Fatalf("Failed to setup test app: %v", err)
+	}
+
+	api := app.Group("/api/v1")
+	SpatialApiRoutes(api.Group("spatial"), db)
+
+	ownId, _ := test.SetupTenants(db)
+	db.Exec("update businesses set enabled = true where id = ?", ownId)
+
+	// a store in Bogota, one 1.5 km north and one in Medellin
+	for _, loc := range []struct {
+		name     string
+		lat, lng float64
+	}{{"Centro", 4.6, -74.08}, {"Norte", 4.6135, -74.08}, {"Medellin", 6.2442, -75.5812}} {
+		db.Exec("insert into locations (business_id, name, latlng, location) values (?, ?, ?, ST_SetSRID(ST_MakePoint(?, ?), 4326)::geography)",
+			ownId, loc.name, fmt.Sprintf("%f,%f", loc.lat, loc.lng), loc.lng, loc.lat)
+	}
+	t.Cleanup(func() { db.Exec("delete from locations where business_id = ?", ownId) })
+
+	search := func(url string, data map[string]interface{}) (int, map[string]interface{}) {
+		data["businessId"] = ownId
+		test.SignMap(data)
+		jsonData, _ := json.Marshal(data)
+		req := httptest.NewRequest("POST", url, bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+
+		var result map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+
+	names := func(result map[string]interface{}) []string {
+		list := []string{}
+		for _, item := range result["result"].([]interface{}) {
+			list = append(list, item.(map[string]interface{})["name"].(string))
+		}
+		return list
+	}
+
+	t.Run("Radius sorted by distance", func(t *testing.T) {
+		status, result := search("/api/v1/spatial/locations", map[string]interface{}{"within": "radius", "lat": 4.6140, "lng": -74.08, "radius": 5000, "sort": "distance"})
+		assert.Equal(t, 200, status)
+		assert.Equal(t, []string{"Norte", "Centro"}, names(result))
+
+		first := result["result"].([]interface{})[0].(map[string]interface{})
+		assert.InDelta(t, 55, first["distance"], 5)
+	})
+
+	t.Run("Bounding box and polygon", func(t *testing.T) {
+		status, result := search("/api/v1/spatial/locations", map[string]interface{}{"within": "bbox", "bbox": []float64{-76, 6, -75, 7}})
+		assert.Equal(t, 200, status)
+		assert.Equal(t, []string{"Medellin"}, names(result))
+
+		status, result = search("/api/v1/spatial/locations", map[string]interface{}{"within": "polygon", "polygon": [][]float64{{-74.1, 4.59}, {-74.05, 4.59}, {-74.05, 4.605}, {-74.1, 4.605}}})
+		assert.Equal(t, 200, status)
+		assert.Equal(t, []string{"Centro"}, names(result))
+	})
+
+	t.Run("Nearest as GeoJSON", func(t *testing.T) {
+		status, result := search("/api/v1/spatial/locations", map[string]interface{}{"within": "nearest", "lat": 6.0, "lng": -75.0, "limit": 2, "format": "geojson", "properties": []string{"name", "nid"}})
+		assert.Equal(t, 200, status)
+		assert.Equal(t, "FeatureCollection", result["type"])
+
+		features := result["features"].([]interface{})
+		assert.Len(t, features, 2)
+		first := features[0].(map[string]interface{})
+		assert.Equal(t, "Medellin", first["properties"].(map[string]interface{})["name"])
+		assert.NotContains(t, first["properties"], "nid")
+		assert.Equal(t, []interface{}{-75.5812, 6.2442}, first["geometry"].(map[string]interface{})["coordinates"])
+	})
+
+	t.Run("Invalid query", func(t *testing.T) {
+		status, _ := search("/api/v1/spatial/locations", map[string]interface{}{"within": "radius", "lat": 4.6, "lng": -74.08, "radius": -1})
+		assert.Equal(t, 400, status)
+		status, _ = search("/api/v1/spatial/businesses", map[string]interface{}{"within": "bbox", "bbox": "1,2,3,4"})
+		assert.NotEqual(t, 200, status)
+	})
+
+	t.Run("Businesses", func(t *testing.T) {
+		db.Exec("update businesses set latlng = '4.6000,-74.0800', location = ST_SetSRID(ST_MakePoint(-74.08, 4.6), 4326)::geography where id = ?", ownId)
+
+		status, result := search("/api/v1/spatial/businesses", map[string]interface{}{"within": "radius", "lat": 4.6, "lng": -74.08, "radius": 100})
+		assert.Equal(t, 200, status)
+		found := false
+		for _, item := range result["result"].([]interface{}) {
+			if item.(map[string]interface{})["id"] == float64(ownId) {
+				found = true
+			}
+		}
+		assert.True(t, found, fmt.Sprint(result))
+	})
+}
@@ -0,0 +1,487 @@
+package team
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"myproject/api/features/message"
+	"myproject/api/features/user"
+	"myproject/api/models"
+	"myproject/api/services"
+	"myproject/api/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+var roleTypes = []string{"operator", "manager", "owner", "contractor", "technician", "admin"}
+
+func inviteLink(invite *models.TeamInvite) string {
+	return fmt.Sprintf("https://www.myproject.org/team/invite/%s", invite.Code)
+}
+
+// sendTeamInvite sends the invite link on the channel of the invite
+func sendTeamInvite(db *gorm.DB, invite *models.TeamInvite, inviter models.User, business models.Business) error {
+
+	link := inviteLink(invite)
+	text := fmt.Sprintf("%s has invited you to join the %s team on myproject as %s. Accept the invite before %s: %s",
+		inviter.Name, business.Name, invite.Type, invite.ExpiresAt.Format("2 Jan 2006"), link)
+
+	switch invite.Channel {
+	case "whatsapp":
+		go message.WhatsappTextMessage(invite.Phone, text)
+
+	case "telegram":
+		// telegram can only reach existing users that connected the bot
+		var user models.User
+		db.Where("(phone = ? and phone <> '') or (lower(email) = ? and email <> '')", invite.Phone, strings.ToLower(invite.Email)).First(&user)
+		if user.Telegram == "" {
+			return errors.New("the invited person has no telegram account connected")
+		}
+		go message.SendMessageToUserViaTelegram(db, &user, text)
+
+	default:
+		msg := models.EmailMessage{
+			From:     "noreply@myproject.com",
+			FromName: "myproject Team",
+			Template: "templates/team_invite.html",
+			Subject:  fmt.Sprintf("%s has invited you to join the %s team", inviter.Name, business.Name),
+			To:       invite.Email,
+			Email:    strings.TrimSpace(invite.Email),
+		}
+
+		var templateData = map[string]string{
+			"Name":         invite.Name,
+			"InviterName":  inviter.Name,
+			"BusinessName": business.Name,
+			"Type":         invite.Type,
+			"Link":         link,
+			"Expires":      invite.ExpiresAt.Format("2 Jan 2006"),
+		}
+
+		fmt.Println("TeamInvite: send template /templates/team_invite.html to", invite.Email)
+
+		go message.SendEmailWithMailyak(&msg, templateData)
+	}
+
+	return nil
+}
+
+// CreateTeamInvite invite a person by email or phone to a business team
+func CreateTeamInvite(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	inviter, err := services.VerifyFormSignature(db, c)
+	if err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return err
+	}
+
+	if !services.CanManageBusiness(db, inviter, businessId) {
+		c.Status(fiber.StatusForbidden)
+		return utils.SendJsonResult(c, fiber.Map{"error": "Only the owner or an admin can invite team members"})
+	}
+
+	invite := new(models.TeamInvite)
+	if err := c.BodyParser(invite); err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return err
+	}
+
+	invite.Email = strings.ToLower(strings.TrimSpace(invite.Email))
+	if invite.Phone != "" {
+		invite.Phone = utils.FixupPhone(invite.Phone)
+	}
+	if invite.Channel == "" {
+		invite.Channel = "email"
+	}
+
+	if invite.Type == "" {
+		invite.Type = "technician"
+	}
+
+	if !slices.Contains(roleTypes, invite.Type) {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": "Invalid role type"})
+	}
+
+	// an admin of the team cannot hand out more than their own role
+	if (invite.Type == "owner" || invite.Type == "admin") &&
+		!strings.Contains(inviter.Roles, "admin") && !services.IsBusinessOwner(db, inviter.ID, businessId) {
+		c.Status(fiber.StatusForbidden)
+		return utils.SendJsonResult(c, fiber.Map{"error": "Only the owner can invite owners and admins"})
+	}
+
+	switch invite.Channel {
+	case "email":
+		if invite.Email == "" {
+			c.Status(fiber.StatusNotAcceptable)
+			return utils.SendJsonResult(c, fiber.Map{"error": "An email is required to invite by email"})
+		}
+	case "whatsapp", "telegram":
+		if invite.Phone == "" {
+			c.Status(fiber.StatusNotAcceptable)
+			return utils.SendJsonResult(c, fiber.Map{"error": "A phone number is required to invite by " + invite.Channel})
+		}
+	default:
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": "Invalid invite channel"})
+	}
+
+	var business models.Business
+	if result := db.First(&business, businessId); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": "No Business found with given ID"})
+	}
+
+	if invite.LocationId > 0 {
+		var location models.Location
+		if result := db.First(&location, "id = ? and business_id = ?", invite.LocationId, businessId); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			c.Status(fiber.StatusNotAcceptable)
+			return utils.SendJsonResult(c, fiber.Map{"error": "No Location found with given ID"})
+		}
+	}
+
+	// check the person is not already in the team
+	var existing models.BusinessRole
+	db.Where("business_id = ? and location_id = ? and role_id in (select id from users where (lower(email) = ? and email <> '') or (phone = ? and phone <> ''))",
+		businessId, invite.LocationId, invite.Email, invite.Phone).First(&existing)
+	if existing.ID > 0 {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": "The user is already a team member"})
+	}
+
+	code, _ := uuid.NewRandom()
+
+	invite.ID = 0
+	invite.BusinessId = businessId
+	invite.InvitedBy = inviter.ID
+	invite.RoleId = 0
+	invite.Code = code.String()
+	invite.Status = "pending"
+	invite.SentCount = 1
+	invite.SentAt = time.Now()
+	invite.ExpiresAt = invite.SentAt.Add(models.TeamInviteExpiry)
+	invite.AcceptedAt = nil
+
+	// the link is only sent for an invite that was saved
+	if err := db.Create(invite).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	if err := sendTeamInvite(db, invite, inviter, business); err != nil {
+		db.Unscoped().Delete(invite)
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": err.Error()})
+	}
+
+	return utils.SendJsonResult(c, invite)
+}
+
+// GetPendingTeamInvites list the invites of a business that have not been accepted or revoked
+func GetPendingTeamInvites(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	var invites []models.TeamInvite
+	db.Preload("Location").
+		Where("business_id = ? and status = 'pending'", businessId).
+		Order("created_at desc").
+		Find(&invites)
+
+	return utils.SendJsonResult(c, invites)
+}
+
+func teamInviteForBusiness(c *fiber.Ctx, db *gorm.DB, businessId uint) (models.User, *models.TeamInvite, error) {
+
+	user, err := services.VerifyFormSignature(db, c)
+	if err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return user, nil, err
+	}
+
+	if !services.CanManageBusiness(db, user, businessId) {
+		c.Status(fiber.StatusForbidden)
+		return user, nil, utils.SendJsonResult(c, fiber.Map{"error": "Only the owner or an admin can manage team invites"})
+	}
+
+	var invite models.TeamInvite
+	result := db.First(&invite, "business_id = ? and id = ?", businessId, c.Params("id"))
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		c.Status(fiber.StatusNotAcceptable)
+		return user, nil, utils.SendJsonResult(c, fiber.Map{"error": "No TeamInvite found with given ID"})
+	}
+
+	return user, &invite, nil
+}
+
+// RevokeTeamInvite stop a pending invite from being accepted
+func RevokeTeamInvite(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	_, invite, err := teamInviteForBusiness(c, db, businessId)
+	if invite == nil {
+		return err
+	}
+
+	if invite.Status != "pending" {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": "Only pending invites can be revoked"})
+	}
+
+	invite.Status = "revoked"
+	db.Save(invite)
+
+	return utils.SendJsonResult(c, invite)
+}
+
+// ResendTeamInvite send the invite link again and extend its expiry
+func ResendTeamInvite(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	user, invite, err := teamInviteForBusiness(c, db, businessId)
+	if invite == nil {
+		return err
+	}
+
+	if invite.Status != "pending" {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": "Only pending invites can be resent"})
+	}
+
+	var business models.Business
+	db.First(&business, businessId)
+
+	invite.SentCount += 1
+	invite.SentAt = time.Now()
+	invite.ExpiresAt = invite.SentAt.Add(models.TeamInviteExpiry)
+
+	if err := sendTeamInvite(db, invite, user, business); err != nil {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": err.Error()})
+	}
+
+	db.Save(invite)
+
+	return utils.SendJsonResult(c, invite)
+}
+
+// pendingInviteForCode find an invite that can still be accepted
+func pendingInviteForCode(db *gorm.DB, code string) (models.TeamInvite, error) {
+
+	var invite models.TeamInvite
+	result := db.Preload("Business").Preload("Location").First(&invite, "code = ?", code)
+
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return invite, errors.New("invite not found")
+	}
+
+	if invite.Status != "pending" {
+		return invite, fmt.Errorf("invite has been %s", invite.Status)
+	}
+
+	if invite.IsExpired() {
+		return invite, errors.New("invite has expired")
+	}
+
+	return invite, nil
+}
+
+// inviteMatchesUser the invite can only be accepted by the person it was sent to
+func inviteMatchesUser(invite models.TeamInvite, user models.User) bool {
+	if invite.Email != "" && strings.EqualFold(invite.Email, user.Email) {
+		return true
+	}
+	return invite.Phone != "" && utils.FixupPhone(invite.Phone) == utils.FixupPhone(user.Phone)
+}
+
+// acceptTeamInvite create or update the business role of the user and close the invite
+func acceptTeamInvite(db *gorm.DB, invite *models.TeamInvite, user models.User) (models.BusinessRole, error) {
+
+	tx := db.Clauses(dbresolver.Write).Begin()
+
+	var role models.BusinessRole
+	tx.First(&role, "role_id = ? and business_id = ? and location_id = ?", user.ID, invite.BusinessId, invite.LocationId)
+
+	role.RoleId = user.ID
+	role.BusinessId = invite.BusinessId
+	role.LocationId = invite.LocationId
+	role.Type = invite.Type
+	role.Permissions = invite.Permissions
+	role.Services = invite.Services
+	role.UpdatedBy = invite.InvitedBy
+
+	if err := tx.Save(&role).Error; err != nil {
+		tx.Rollback()
+		return role, err
+	}
+
+	now := time.Now()
+	invite.Status = "accepted"
+	invite.RoleId = user.ID
+	invite.AcceptedAt = &now
+
+	if err := tx.Save(invite).Error; err != nil {
+		tx.Rollback()
+		return role, err
+	}
+
+	return role, tx.Commit().Error
+}
+
+// ViewTeamInvite render the page for the invite link
+func ViewTeamInvite(c *fiber.Ctx, db *gorm.DB) error {
+
+	invite, err := pendingInviteForCode(db, c.Params("code"))
+	if err != nil {
+		return c.Render("home/oops", fiber.Map{
+			"Message": "error accepting invite",
+			"Error":   err.Error(),
+		})
+	}
+
+	var user models.User
+	if c.Locals("currentUser") != nil {
+		user = c.Locals("currentUser").(models.User)
+	}
+
+	return c.Render("team/invite", fiber.Map{
+		"Invite":   invite,
+		"Business": invite.Business,
+		"LoggedIn": user.ID > 0,
+		"Code":     invite.Code,
+		"TestEnv":  os.Getenv("TEST_MODE"),
+	}, "layouts/react_htmx")
+}
+
+// AcceptTeamInvite an existing user accepts the invite sent to them
+func AcceptTeamInvite(c *fiber.Ctx, db *gorm.DB) error {
+
+	var user models.User
+	if c.Locals("currentUser") != nil {
+		user = c.Locals("currentUser").(models.User)
+	}
+
+	if user.ID == 0 {
+		var err error
+		if user, err = services.VerifyFormSignature(db, c); err != nil {
+			fmt.Println(err)
+			c.Status(503).SendString(err.Error())
+			return err
+		}
+	}
+
+	invite, err := pendingInviteForCode(db, c.Params("code"))
+	if err != nil {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": err.Error()})
+	}
+
+	if !inviteMatchesUser(invite, user) {
+		c.Status(fiber.StatusForbidden)
+		return utils.SendJsonResult(c, fiber.Map{"error": "The invite was sent to another person"})
+	}
+
+	role, err := acceptTeamInvite(db, &invite, user)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return utils.SendJsonResult(c, role)
+}
+
+// RegisterTeamInvite create the account of an invited person and accept the invite
+func RegisterTeamInvite(c *fiber.Ctx, db *gorm.DB) error {
+
+	invite, err := pendingInviteForCode(db, c.Params("code"))
+	if err != nil {
+		return c.Render("error", fiber.Map{
+			"Error": err.Error(),
+		}, "layouts/htmx_partial")
+	}
+
+	// only the profile fields, the roles and the rest of the user are not the invitee's to set
+	form := struct {
+		Name     string `json:"name" form:"name"`
+		Email    string `json:"email" form:"email"`
+		Phone    string `json:"phone" form:"phone"`
+		Password string `json:"password" form:"password"`
+		City     string `json:"city" form:"city"`
+		Province string `json:"province" form:"province"`
+		Country  string `json:"country" form:"country"`
+	}{}
+	if err := c.BodyParser(&form); err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return err
+	}
+
+	data := &models.User{
+		Name:     form.Name,
+		Email:    form.Email,
+		Phone:    form.Phone,
+		Password: form.Password,
+		City:     form.City,
+		Province: form.Province,
+		Country:  form.Country,
+	}
+
+	if data.Email == "" || data.Phone == "" {
+		return c.Render("error", fiber.Map{
+			"Error": "email and/or phone data is invalid",
+		}, "layouts/htmx_partial")
+	}
+
+	if !inviteMatchesUser(invite, *data) {
+		return c.Render("error", fiber.Map{
+			"Error": "email and/or phone does not match the invite",
+		}, "layouts/htmx_partial")
+	}
+
+	var existing models.User
+	result := db.Where("lower(email) = ? or phone = ?", strings.ToLower(data.Email), utils.FixupPhone(data.Phone)).First(&existing)
+
+	if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return c.Render("error", fiber.Map{
+			"Error": "email and/or phone data already exists, please login to accept the invite",
+		}, "layouts/htmx_partial")
+	}
+
+	// create the api key
+	rand, _ := uuid.NewRandom()
+	data.ApiKey = rand
+	data.UnlockToken = models.EncryptedString(invite.Code)
+
+	// normalise the location data
+	data.NormalizeAddress()
+
+	if err := db.Create(data).Error; err != nil {
+		return c.Render("error", fiber.Map{
+			"Error": err.Error(),
+		}, "layouts/htmx_partial")
+	}
+
+	if _, err := acceptTeamInvite(db, &invite, *data); err != nil {
+		return c.Render("error", fiber.Map{
+			"Error": err.Error(),
+		}, "layouts/htmx_partial")
+	}
+
+	// the business of the invite may require a second factor
+	if session, err := user.LogIn(db, c, *data, false); session == nil || err != nil {
+		return err
+	}
+
+	return c.Render("team/invite_accepted", fiber.Map{
+		"ID":       data.ID,
+		"Name":     data.Name,
+		"Business": invite.Business,
+		"Type":     invite.Type,
+		"Token":    invite.Code,
+		"TestEnv":  os.Getenv("TEST_MODE"),
+	}, "layouts/htmx_partial")
+}
@@ -1,21 +1,94 @@
 package team
 
 import (
+	"strconv"
+
 	"github.com/gofiber/fiber/v2"
 	"gorm.io/gorm"
+
+	"myproject/api/services"
 )
 
+func businessIdParam(c *fiber.Ctx) uint {
+	id, _ := strconv.ParseUint(c.Params("bizid"), 10, 64)
+	return uint(id)
+}
+
 // routes prefixed with /api/v1/team
 func TeamApiRoutes(app fiber.Router, db *gorm.DB) {
 
 	// add service to business_role.services
-	app.Put("/:id/addUserRoleService", func(c *fiber.Ctx) error {
-		return AddUserService(db, c)
+	app.Put("/:id/addUserRoleService", services.RequireTenant(db, services.TenantFromRecord("business_roles", "id")), func(c *fiber.Ctx) error {
+		return AddUserService(services.TenantDB(db, c), c)
 	})
 
 	// remove service from business_role.services
-	app.Put("/:id/removeUserRoleService", func(c *fiber.Ctx) error {
-		return RemoveUserService(db, c)
+	app.Put("/:id/removeUserRoleService", services.RequireTenant(db, services.TenantFromRecord("business_roles", "id")), func(c *fiber.Ctx) error {
+		return RemoveUserService(services.TenantDB(db, c), c)
+	})
+
+	////////////////  INVITES	//////////////////////
+	// invite a person to the team of a business
+	app.Post("/:bizid/invites", services.RequireTenant(db, services.TenantFromParam("bizid")), func(c *fiber.Ctx) error {
+		bizid := businessIdParam(c)
+		return CreateTeamInvite(c, services.TenantDB(db, c), bizid)
+	})
+
+	// list the pending invites of a business
+	app.Get("/:bizid/invites", services.RequireTenant(db, services.TenantFromParam("bizid")), func(c *fiber.Ctx) error {
+		bizid := businessIdParam(c)
+		return GetPendingTeamInvites(c, services.TenantDB(db, c), bizid)
+	})
+	app.Post("/:bizid/invites/pending", services.RequireTenant(db, services.TenantFromParam("bizid")), func(c *fiber.Ctx) error {
+		bizid := businessIdParam(c)
+		return GetPendingTeamInvites(c, services.TenantDB(db, c), bizid)
+	})
+
+	// revoke a pending invite
+	app.Put("/:bizid/invites/:id/revoke", services.RequireTenant(db, services.TenantFromParam("bizid")), func(c *fiber.Ctx) error {
+		bizid := businessIdParam(c)
+		return RevokeTeamInvite(c, services.TenantDB(db, c), bizid)
+	})
+
+	// send a pending invite again
+	app.Put("/:bizid/invites/:id/resend", services.RequireTenant(db, services.TenantFromParam("bizid")), func(c *fiber.Ctx) error {
+		bizid := businessIdParam(c)
+		return ResendTeamInvite(c, services.TenantDB(db, c), bizid)
 	})
 
+	////////////////  TIMESHEETS	//////////////////////
+	// the time the technicians spent at the locations, from the geofence check ins and outs
+	app.Post("/:bizid/timesheets/list", services.RequireTenant(db, services.TenantFromParam("bizid")), func(c *fiber.Ctx) error {
+		return GetTimesheets(c, services.TenantDB(db, c), businessIdParam(c))
+	})
+
+	// correct a timesheet entry
+	app.Put("/:bizid/timesheets/:id", services.RequireTenant(db, services.TenantFromParam("bizid")), func(c *fiber.Ctx) error {
+		id, _ := strconv.ParseUint(c.Params("id"), 10, 64)
+		return UpdateTimesheet(c, services.TenantDB(db, c), businessIdParam(c), uint(id))
+	})
+
+	// the arrivals and departures of the technicians
+	app.Post("/:bizid/geofence_events/list", services.RequireTenant(db, services.TenantFromParam("bizid")), func(c *fiber.Ctx) error {
+		return GetGeofenceEvents(c, services.TenantDB(db, c), businessIdParam(c))
+	})
+
+	// the invited user accepts the invite
+	app.Post("/invite/:code/accept", func(c *fiber.Ctx) error {
+		return AcceptTeamInvite(c, db)
+	})
+}
+
+// routes with no prefix that do not require a JWT token
+func TeamPublicRoutes(app fiber.Router, db *gorm.DB) {
+
+	// show the invite to join a team
+	app.Get("/team/invite/:code", func(c *fiber.Ctx) error {
+		return ViewTeamInvite(c, db)
+	})
+
+	// new user registration from a team invite
+	app.Post("/team/invite/:code/register", func(c *fiber.Ctx) error {
+		return RegisterTeamInvite(c, db)
+	})
 }
@@ -3,9 +3,13 @@ package team
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"myproject/api/models"
+	"myproject/api/services"
 	"myproject/test"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/stretchr/testify/assert"
@@ -286,3 +290,225 @@ func TestTeamMetrics(t *testing.T) {
 		assert.NotNil(t, metrics["customerSatisfaction"])
 	})
 }
+
+func TestTeamTenantIsolation(t *testing.T) {
+	app, db, err := test.SetupTestApp()
+	if err != nil {
+		t.Fatalf("Failed to setup test app: %v", err)
+	}
+
+	api := app.Group("/api/v1")
+	TeamApiRoutes(api.Group("team"), db)
+
+	_, otherId := test.SetupTenants(db)
+
+	otherRole := models.BusinessRole{BusinessId: otherId, Type: "technician"}
+	db.Create(&otherRole)
+
+	t.Run("Add service to a role of another business", func(t *testing.T) {
+		data := map[string]interface{}{"service": "hijack"}
+		test.SignMap(data)
+		jsonData, _ := json.Marshal(data)
+		req := httptest.NewRequest("PUT", fmt.Sprintf("/api/v1/team/%d/addUserRoleService", otherRole.ID), bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, fiber.StatusForbidden, resp.StatusCode)
+
+		var role models.BusinessRole
+		db.First(&role, otherRole.ID)
+		assert.NotContains(t, role.Services, "hijack")
+	})
+}
+
+func TestTeamInvites(t *testing.T) {
+	app, db, err := test.SetupTestApp()
+	if err != nil {
+		t.Fatalf("Failed to setup test app: %v", err)
+	}
+
+	api := app.Group("/api/v1")
+	TeamApiRoutes(api.Group("team"), db)
+
+	ownId, otherId := test.SetupTenants(db)
+
+	var invite models.TeamInvite
+
+	signedRequest := func(method, url string, data map[string]interface{}) (int, map[string]interface{}) {
+		test.SignMap(data)
+		jsonData, _ := json.Marshal(data)
+		req := httptest.NewRequest(method, url, bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+
+		var result map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+
+	t.Run("Create invite", func(t *testing.T) {
+		status, _ := signedRequest("POST", fmt.Sprintf("/api/v1/team/%d/invites", ownId), map[string]interface{}{
+			"name":     "Invited technician",
+			"email":    "Invited.Technician@dummy.com",
+			"channel":  "email",
+			"type":     "technician",
+			"services": []string{"hvac"},
+		})
+		assert.Equal(t, 200, status)
+
+		db.Last(&invite, "business_id = ?", ownId)
+		assert.Equal(t, "invited.technician@dummy.com", invite.Email)
+		assert.Equal(t, "pending", invite.Status)
+		assert.NotEmpty(t, invite.Code)
+		assert.True(t, invite.ExpiresAt.After(time.Now()))
+	})
+
+	t.Run("Create invite with invalid role type", func(t *testing.T) {
+		status, _ := signedRequest("POST", fmt.Sprintf("/api/v1/team/%d/invites", ownId), map[string]interface{}{
+			"email": "invalid@dummy.com",
+			"type":  "superuser",
+		})
+		assert.Equal(t, fiber.StatusNotAcceptable, status)
+	})
+
+	t.Run("Create invite for a location of another business", func(t *testing.T) {
+		location := models.Location{BusinessId: otherId, Name: "Other location"}
+		db.Create(&location)
+
+		status, _ := signedRequest("POST", fmt.Sprintf("/api/v1/team/%d/invites", ownId), map[string]interface{}{
+			"email":      "located@dummy.com",
+			"locationId": location.ID,
+		})
+		assert.Equal(t, fiber.StatusNotAcceptable, status)
+	})
+
+	t.Run("Create invite for another business", func(t *testing.T) {
+		status, _ := signedRequest("POST", fmt.Sprintf("/api/v1/team/%d/invites", otherId), map[string]interface{}{
+			"email": "hijack@dummy.com",
+		})
+		assert.Equal(t, fiber.StatusForbidden, status)
+	})
+
+	t.Run("List pending invites", func(t *testing.T) {
+		status, result := signedRequest("POST", fmt.Sprintf("/api/v1/team/%d/invites/pending", ownId), map[string]interface{}{})
+		assert.Equal(t, 200, status)
+
+		invites, ok := result["result"].([]interface{})
+		assert.True(t, ok)
+		assert.Len(t, invites, 1)
+	})
+
+	t.Run("Resend invite", func(t *testing.T) {
+		status, _ := signedRequest("PUT", fmt.Sprintf("/api/v1/team/%d/invites/%d/resend", ownId, invite.ID), map[string]interface{}{})
+		assert.Equal(t, 200, status)
+
+		var resent models.TeamInvite
+		db.First(&resent, invite.ID)
+		assert.Equal(t, uint(2), resent.SentCount)
+	})
+
+	t.Run("Accept invite as another user", func(t *testing.T) {
+		status, _ := signedRequest("POST", "/api/v1/team/invite/"+invite.Code+"/accept", map[string]interface{}{})
+		assert.Equal(t, fiber.StatusForbidden, status)
+	})
+
+	t.Run("Revoke invite", func(t *testing.T) {
+		status, _ := signedRequest("PUT", fmt.Sprintf("/api/v1/team/%d/invites/%d/revoke", ownId, invite.ID), map[string]interface{}{})
+		assert.Equal(t, 200, status)
+
+		status, _ = signedRequest("POST", "/api/v1/team/invite/"+invite.Code+"/accept", map[string]interface{}{})
+		assert.Equal(t, fiber.StatusNotAcceptable, status)
+	})
+}
+
+func TestTimesheets(t *testing.T) {
+	app, db, err := test.SetupTestApp()
+	if err != nil {
+		t.Fatalf("Failed to setup test app: %v", err)
+	}
+
+	api := app.Group("/api/v1")
+	TeamApiRoutes(api.Group("team"), db)
+
+	ownId, otherId := test.SetupTenants(db)
+
+	// a site of the business of user 3 with a 100 metre geofence
+	var siteId uint
+	db.Raw("insert into locations (business_id, name, latlng, location) values (?, 'Site', '4.6,-74.08', ST_SetSRID(ST_MakePoint(-74.08, 4.6), 4326)::geography) returning id", ownId).Scan(&siteId)
+	t.Cleanup(func() {
+		db.Exec("delete from locations where id = ?", siteId)
+		db.Exec("delete from geofence_events where user_id = 3")
+		db.Exec("delete from timesheet_entries where user_id = 3")
+		db.Exec("delete from user_positions where user_id = 3")
+	})
+
+	start := time.Now().Add(-time.Hour)
+	record := func(minutes int, lat float64) []models.GeofenceEvent {
+		events, err := services.RecordPosition(db, 3, lat, -74.08, 10, start.Add(time.Duration(minutes)*time.Minute))
+		assert.Nil(t, err)
+		return events
+	}
+
+	// 1.1 km away, 55 m away, 111 m away within the exit margin, then 222 m away
+	assert.Len(t, record(0, 4.61), 0)
+	arrival := record(5, 4.6005)
+	assert.Len(t, arrival, 1)
+	assert.Equal(t, models.GeofenceArrival, arrival[0].Kind)
+	assert.Equal(t, ownId, arrival[0].BusinessId)
+	assert.Len(t, record(20, 4.601), 0)
+	departure := record(50, 4.602)
+	assert.Len(t, departure, 1)
+	assert.Equal(t, models.GeofenceDeparture, departure[0].Kind)
+
+	// an inaccurate position is stored without checking in
+	events, _ := services.RecordPosition(db, 3, 4.6, -74.08, 500, time.Now())
+	assert.Len(t, events, 0)
+
+	var entry models.TimesheetEntry
+	db.Last(&entry, "user_id = 3 and location_id = ?", siteId)
+	assert.Equal(t, 45, entry.Minutes)
+
+	signedRequest := func(method, url string, data map[string]interface{}) (int, map[string]interface{}) {
+		test.SignMap(data)
+		jsonData, _ := json.Marshal(data)
+		req := httptest.NewRequest(method, url, bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+
+		var result map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+
+	t.Run("List", func(t *testing.T) {
+		status, result := signedRequest("POST", fmt.Sprintf("/api/v1/team/%d/timesheets/list", ownId), map[string]interface{}{})
+		assert.Equal(t, 200, status)
+		timesheets := result["result"].(map[string]interface{})
+		assert.Len(t, timesheets["entries"], 1)
+		assert.Equal(t, 45.0, timesheets["minutes"].(map[string]interface{})["3"])
+
+		status, result = signedRequest("POST", fmt.Sprintf("/api/v1/team/%d/geofence_events/list", ownId), map[string]interface{}{})
+		assert.Equal(t, 200, status)
+		assert.Len(t, result["result"], 2)
+
+		status, _ = signedRequest("POST", fmt.Sprintf("/api/v1/team/%d/timesheets/list", otherId), map[string]interface{}{})
+		assert.Equal(t, 403, status)
+	})
+
+	t.Run("Correct", func(t *testing.T) {
+		checkOut := entry.CheckIn.Add(time.Hour)
+		status, result := signedRequest("PUT", fmt.Sprintf("/api/v1/team/%d/timesheets/%d", ownId, entry.ID), map[string]interface{}{"checkOut": checkOut, "note": "left late"})
+		assert.Equal(t, 200, status)
+		corrected := result["result"].(map[string]interface{})
+		assert.Equal(t, 60.0, corrected["minutes"])
+		assert.Equal(t, models.TimesheetManual, corrected["source"])
+
+		status, _ = signedRequest("PUT", fmt.Sprintf("/api/v1/team/%d/timesheets/%d", ownId, entry.ID), map[string]interface{}{"checkOut": entry.CheckIn.Add(-time.Hour)})
+		assert.Equal(t, 406, status)
+	})
+}
@@ -0,0 +1,159 @@
+package team
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"myproject/api/models"
+	"myproject/api/services"
+	"myproject/api/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// timesheetFilter the period and the technician of a timesheet query
+type timesheetFilter struct {
+	UserId uint   `json:"userId"`
+	From   string `json:"from"` // 2006-01-02 or RFC3339, the last 7 days when empty
+	To     string `json:"to"`
+}
+
+// parseTimesheetTime a date or an RFC3339 time
+func parseTimesheetTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// timesheetQuery the filter of the request, limited to the own entries of a team member that does not manage the business
+func timesheetQuery(c *fiber.Ctx, db *gorm.DB, businessId uint, column string) (*gorm.DB, error) {
+
+	user, err := services.VerifyFormSignature(db, c)
+	if err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return nil, err
+	}
+
+	filter := new(timesheetFilter)
+	if err := c.BodyParser(filter); err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return nil, err
+	}
+
+	from, to := time.Now().AddDate(0, 0, -7), time.Now()
+	if filter.From != "" {
+		if from, err = parseTimesheetTime(filter.From); err != nil {
+			c.Status(fiber.StatusNotAcceptable)
+			utils.SendJsonResult(c, fiber.Map{"error": "from must be a date"})
+			return nil, err
+		}
+	}
+	if filter.To != "" {
+		if to, err = parseTimesheetTime(filter.To); err != nil {
+			c.Status(fiber.StatusNotAcceptable)
+			utils.SendJsonResult(c, fiber.Map{"error": "to must be a date"})
+			return nil, err
+		}
+		if len(filter.To) == len("2006-01-02") {
+			// the whole day
+			to = to.AddDate(0, 0, 1)
+		}
+	}
+
+	tx := db.Where("business_id = ? and "+column+" >= ? and "+column+" < ?", businessId, from, to)
+
+	if !services.CanManageBusiness(db, user, businessId) {
+		tx = tx.Where("user_id = ?", user.ID)
+	} else if filter.UserId > 0 {
+		tx = tx.Where("user_id = ?", filter.UserId)
+	}
+
+	return tx, nil
+}
+
+// GetTimesheets the timesheet entries of the business checked in during the period and the minutes by technician
+func GetTimesheets(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	tx, err := timesheetQuery(c, db, businessId, "check_in")
+	if err != nil {
+		return nil
+	}
+
+	var entries []models.TimesheetEntry
+	tx.Order("check_in").Find(&entries)
+
+	minutes := map[uint]int{}
+	for _, entry := range entries {
+		minutes[entry.UserId] += entry.Minutes
+	}
+
+	return utils.SendJsonResult(c, fiber.Map{"entries": entries, "minutes": minutes})
+}
+
+// GetGeofenceEvents the arrivals and departures of the technicians of the business during the period
+func GetGeofenceEvents(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	tx, err := timesheetQuery(c, db, businessId, "at")
+	if err != nil {
+		return nil
+	}
+
+	var events []models.GeofenceEvent
+	tx.Order("at").Limit(1000).Find(&events)
+
+	return utils.SendJsonResult(c, events)
+}
+
+// UpdateTimesheet a manager corrects the check in, check out or note of an entry
+func UpdateTimesheet(c *fiber.Ctx, db *gorm.DB, businessId, id uint) error {
+
+	user, err := services.VerifyFormSignature(db, c)
+	if err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	if !services.CanManageBusiness(db, user, businessId) {
+		return c.Status(fiber.StatusForbidden).SendString("only managers can change timesheets")
+	}
+
+	var entry models.TimesheetEntry
+	if result := db.First(&entry, "id = ? and business_id = ?", id, businessId); errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusNotFound).SendString("timesheet entry not found")
+	}
+
+	var changes struct {
+		CheckIn  *time.Time `json:"checkIn"`
+		CheckOut *time.Time `json:"checkOut"`
+		Note     *string    `json:"note"`
+	}
+	if err := c.BodyParser(&changes); err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	if changes.CheckIn != nil {
+		entry.CheckIn = *changes.CheckIn
+	}
+	if changes.CheckOut != nil {
+		entry.CheckOut = changes.CheckOut
+	}
+	if changes.Note != nil {
+		entry.Note = *changes.Note
+	}
+	if entry.CheckOut != nil && entry.CheckOut.Before(entry.CheckIn) {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": "the check out is before the check in"})
+	}
+
+	entry.Source = models.TimesheetManual
+	entry.UpdatedBy = user.ID
+	db.Save(&entry)
+
+	return utils.SendJsonResult(c, entry)
+}
@@ -0,0 +1,44 @@
+package trash
+
+import (
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+func idParam(c *fiber.Ctx, name string) uint {
+	id, _ := strconv.ParseUint(c.Params(name), 10, 64)
+	return uint(id)
+}
+
+// routes prefixed with /api/v1/trash
+func TrashApiRoutes(app fiber.Router, db *gorm.DB) {
+
+	////////////////  BUSINESS	//////////////////////
+	// soft delete a business, restorable for DELETE_RETENTION_DAYS
+	app.Delete("/business/:bizid", func(c *fiber.Ctx) error {
+		return DeleteBusiness(c, db, idParam(c, "bizid"))
+	})
+
+	// restore a deleted business
+	app.Put("/business/:bizid/restore", func(c *fiber.Ctx) error {
+		return RestoreBusiness(c, db, idParam(c, "bizid"))
+	})
+
+	// the deleted data of a business
+	app.Post("/business/:bizid", func(c *fiber.Ctx) error {
+		return GetDeleted(c, db, idParam(c, "bizid"))
+	})
+
+	// who deleted, restored and purged the data of a business
+	app.Post("/business/:bizid/audit", func(c *fiber.Ctx) error {
+		return GetAudit(c, db, idParam(c, "bizid"))
+	})
+
+	////////////////  RECORDS	//////////////////////
+	// restore a deleted location, part, consumable or bin
+	app.Put("/:model/:id/restore", func(c *fiber.Ctx) error {
+		return RestoreRecord(c, db, c.Params("model"), idParam(c, "id"))
+	})
+}
@@ -0,0 +1,192 @@
+package trash
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"myproject/api/database"
+	"myproject/api/models"
+	"myproject/api/services"
+	"myproject/api/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// isOwner check the user owns the business, deleted or not, or is an admin
+func isOwner(db *gorm.DB, user models.User, businessId uint) bool {
+	if strings.Contains(user.Roles, "admin") {
+		return true
+	}
+
+	var business models.Business
+	database.WithoutTenant(db).Unscoped().Select("id", "user_id").Limit(1).Find(&business, businessId)
+
+	return business.ID > 0 && business.UserId == user.ID
+}
+
+// restoreError send the response for the errors of RestoreBusiness and RestoreRecord
+func restoreError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": "Nothing found with given ID"})
+	case errors.Is(err, services.ErrNotDeleted):
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrRetentionExpired):
+		c.Status(fiber.StatusGone)
+		return utils.SendJsonResult(c, fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+}
+
+// DeleteBusiness soft delete a business with its locations, parts, consumables and bins,
+// it can be restored until the retention window passes
+func DeleteBusiness(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	user, err := services.VerifyFormSignature(db, c)
+	if err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	if !isOwner(db, user, businessId) {
+		c.Status(fiber.StatusForbidden)
+		return utils.SendJsonResult(c, fiber.Map{"error": "Only the owner can delete the business"})
+	}
+
+	if err := services.SoftDeleteBusiness(db, businessId, user.ID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.Status(fiber.StatusNotAcceptable)
+			return utils.SendJsonResult(c, fiber.Map{"error": "No Business found with given ID"})
+		}
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return utils.SendJsonResult(c, fiber.Map{
+		"id":      businessId,
+		"purgeAt": time.Now().AddDate(0, 0, services.RetentionDays()),
+	})
+}
+
+// RestoreBusiness restore a deleted business and the data deleted with it
+func RestoreBusiness(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	user, err := services.VerifyFormSignature(db, c)
+	if err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	if !isOwner(db, user, businessId) {
+		c.Status(fiber.StatusForbidden)
+		return utils.SendJsonResult(c, fiber.Map{"error": "Only the owner can restore the business"})
+	}
+
+	if err := services.RestoreBusiness(db, businessId, user.ID); err != nil {
+		return restoreError(c, err)
+	}
+
+	var business models.Business
+	db.First(&business, businessId)
+
+	return utils.SendJsonResult(c, business)
+}
+
+// GetDeleted list the deleted business, locations, parts, consumables and bins of a business with when they will be purged
+func GetDeleted(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	user, err := services.VerifyFormSignature(db, c)
+	if err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	if !isOwner(db, user, businessId) && !services.CanManageBusiness(db, user, businessId) {
+		c.Status(fiber.StatusForbidden)
+		return utils.SendJsonResult(c, fiber.Map{"error": "Only the owner or an admin can see the deleted data"})
+	}
+
+	type Deleted struct {
+		Model     string    `json:"model"`
+		ID        uint      `json:"id"`
+		Name      string    `json:"name"`
+		DeletedAt time.Time `json:"deletedAt"`
+		PurgeAt   time.Time `json:"purgeAt"`
+	}
+
+	lookup := database.WithoutTenant(db)
+	deleted := []Deleted{}
+
+	var rows []Deleted
+	lookup.Raw(`select 'business' as model, id, name, deleted_at from businesses where id = ? and deleted_at is not null
+		union all select 'location', id, name, deleted_at from locations where business_id = ? and deleted_at is not null
+		union all select 'part', id, name, deleted_at from parts where business_id = ? and deleted_at is not null
+		union all select 'consumable', id, name, deleted_at from consumables where business_id = ? and deleted_at is not null
+		union all select 'bin', id, name, deleted_at from bins where business_id = ? and deleted_at is not null
+		order by deleted_at desc`, businessId, businessId, businessId, businessId, businessId).Scan(&rows)
+
+	for _, row := range rows {
+		row.PurgeAt = row.DeletedAt.AddDate(0, 0, services.RetentionDays())
+		deleted = append(deleted, row)
+	}
+
+	return utils.SendJsonResult(c, deleted)
+}
+
+// RestoreRecord restore a deleted location, part, consumable or bin of a business that is not deleted
+func RestoreRecord(c *fiber.Ctx, db *gorm.DB, model string, id uint) error {
+
+	user, err := services.VerifyFormSignature(db, c)
+	if err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	table, ok := services.RetainedTables[model]
+	if !ok {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": "model must be location, part, consumable or bin"})
+	}
+
+	var businessIds []uint
+	database.WithoutTenant(db).Table(table).Where("id = ?", id).Pluck("business_id", &businessIds)
+	if len(businessIds) == 0 {
+		return restoreError(c, gorm.ErrRecordNotFound)
+	}
+
+	// a business that is deleted is restored as a whole
+	if !services.CanManageBusiness(db, user, businessIds[0]) {
+		c.Status(fiber.StatusForbidden)
+		return utils.SendJsonResult(c, fiber.Map{"error": "Only the owner or an admin can restore deleted data"})
+	}
+
+	if _, err := services.RestoreRecord(db, model, id, user.ID); err != nil {
+		return restoreError(c, err)
+	}
+
+	return utils.SendJsonResult(c, fiber.Map{"model": model, "id": id})
+}
+
+// GetAudit list who deleted, restored and purged the data of a business, most recent first
+func GetAudit(c *fiber.Ctx, db *gorm.DB, businessId uint) error {
+
+	user, err := services.VerifyFormSignature(db, c)
+	if err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	if !isOwner(db, user, businessId) && !services.CanManageBusiness(db, user, businessId) {
+		c.Status(fiber.StatusForbidden)
+		return utils.SendJsonResult(c, fiber.Map{"error": "Only the owner or an admin can see the deletion audit"})
+	}
+
+	var audit []models.DeletionAudit
+	database.WithoutTenant(db).Order("created_at desc").Limit(200).Find(&audit, "business_id = ?", businessId)
+
+	return utils.SendJsonResult(c, audit)
+}
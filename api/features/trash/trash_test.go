@@ -0,0 +1,107 @@
+package trash
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"myproject/api/models"
+	"myproject/api/services"
+	"myproject/test"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrash(t *testing.T) {
+	app, db, err := test.SetupTestApp()
+	if err != nil {
+		t.Fatalf("Failed to setup test app: %v", err)
+	}
+
+	api := app.Group("/api/v1")
+	TrashApiRoutes(api.Group("trash"), db)
+
+	_, otherId := test.SetupTenants(db)
+
+	// a business of the signer with a location, to delete
+	business := models.Business{UserId: 3, Name: "Trash test"}
+	db.Create(&business)
+	location := models.Location{BusinessId: business.ID, Name: "Trash test location"}
+	db.Create(&location)
+
+	signedRequest := func(method, url string, data map[string]interface{}) (int, map[string]interface{}) {
+		test.SignMap(data)
+		jsonData, _ := json.Marshal(data)
+		req := httptest.NewRequest(method, url, bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+
+		var result map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+
+	t.Run("Delete another business", func(t *testing.T) {
+		status, _ := signedRequest("DELETE", fmt.Sprintf("/api/v1/trash/business/%d", otherId), map[string]interface{}{})
+		assert.Equal(t, fiber.StatusForbidden, status)
+	})
+
+	t.Run("Delete and restore business", func(t *testing.T) {
+		status, _ := signedRequest("DELETE", fmt.Sprintf("/api/v1/trash/business/%d", business.ID), map[string]interface{}{})
+		assert.Equal(t, 200, status)
+
+		var count int64
+		db.Model(&models.Location{}).Where("id = ?", location.ID).Count(&count)
+		assert.Equal(t, int64(0), count)
+
+		status, result := signedRequest("POST", fmt.Sprintf("/api/v1/trash/business/%d", business.ID), map[string]interface{}{})
+		assert.Equal(t, 200, status)
+		assert.Len(t, result["result"], 2)
+
+		status, _ = signedRequest("PUT", fmt.Sprintf("/api/v1/trash/business/%d/restore", business.ID), map[string]interface{}{})
+		assert.Equal(t, 200, status)
+
+		db.Model(&models.Location{}).Where("id = ?", location.ID).Count(&count)
+		assert.Equal(t, int64(1), count)
+
+		status, _ = signedRequest("PUT", fmt.Sprintf("/api/v1/trash/business/%d/restore", business.ID), map[string]interface{}{})
+		assert.Equal(t, fiber.StatusNotAcceptable, status)
+	})
+
+	t.Run("Restore location", func(t *testing.T) {
+		db.Delete(&models.Location{}, location.ID)
+
+		status, _ := signedRequest("PUT", fmt.Sprintf("/api/v1/trash/location/%d/restore", location.ID), map[string]interface{}{})
+		assert.Equal(t, 200, status)
+
+		status, _ = signedRequest("PUT", fmt.Sprintf("/api/v1/trash/equipment/%d/restore", location.ID), map[string]interface{}{})
+		assert.Equal(t, fiber.StatusNotAcceptable, status)
+	})
+
+	t.Run("Purge after the retention window", func(t *testing.T) {
+		status, _ := signedRequest("DELETE", fmt.Sprintf("/api/v1/trash/business/%d", business.ID), map[string]interface{}{})
+		assert.Equal(t, 200, status)
+
+		db.Exec("update businesses set deleted_at = now() - interval '400 days' where id = ?", business.ID)
+
+		status, _ = signedRequest("PUT", fmt.Sprintf("/api/v1/trash/business/%d/restore", business.ID), map[string]interface{}{})
+		assert.Equal(t, fiber.StatusGone, status)
+
+		_, errs := services.PurgeExpired(db)
+		assert.Empty(t, errs)
+
+		var count int64
+		db.Unscoped().Model(&models.Business{}).Where("id = ?", business.ID).Count(&count)
+		assert.Equal(t, int64(0), count)
+		db.Unscoped().Model(&models.Location{}).Where("id = ?", location.ID).Count(&count)
+		assert.Equal(t, int64(0), count)
+
+		status, result := signedRequest("POST", fmt.Sprintf("/api/v1/trash/business/%d/audit", business.ID), map[string]interface{}{})
+		assert.Equal(t, 200, status)
+		assert.Equal(t, "purge", result["result"].([]interface{})[0].(map[string]interface{})["action"])
+	})
+}
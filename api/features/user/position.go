@@ -0,0 +1,58 @@
+package user
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"myproject/api/features/ws"
+	"myproject/api/models"
+	"myproject/api/services"
+	"myproject/api/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// publishGeofenceEvent send an arrival or departure to the websocket channel of the business
+func publishGeofenceEvent(event models.GeofenceEvent) {
+	channel := services.BusinessChannel(event.BusinessId)
+	payload, _ := json.Marshal(event)
+
+	ws.PublishToChannel(channel, &models.WebsocketMessage{Event: "geofence", Channel: channel, Subject: event.Kind, Payload: string(payload)})
+}
+
+// UpdatePosition store the GPS position of a team member
+func UpdatePosition(db *gorm.DB, c *fiber.Ctx) error {
+
+	userId, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	lat, err1 := strconv.ParseFloat(c.Params("latitude"), 64)
+	lng, err2 := strconv.ParseFloat(c.Params("longitude"), 64)
+	if err != nil || err1 != nil || err2 != nil || lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+		return c.Status(fiber.StatusBadRequest).SendString("invalid position")
+	}
+	accuracy, _ := strconv.ParseFloat(c.Query("accuracy"), 64)
+
+	// update the location geography
+	db.Exec("UPDATE users SET location = ST_SetSRID(ST_MakePoint(?, ?), 4326)::geography WHERE id = ?", lng, lat, userId)
+
+	// only a position signed by the user is trusted for the timesheets
+	if user, err := services.VerifyFormSignature(db, c); err == nil && user.ID == uint(userId) {
+		events, err := services.RecordPosition(db, user.ID, lat, lng, accuracy, time.Now())
+		if err != nil {
+			fmt.Println("UpdatePosition", err)
+		}
+		for _, event := range events {
+			go publishGeofenceEvent(event)
+		}
+	}
+
+	var orig models.User
+	if err := c.BodyParser(&orig); err != nil {
+		c.Status(fiber.StatusBadRequest).SendString("Error parsing driver")
+		return fiber.ErrConflict
+	}
+
+	return utils.SendJsonResult(c, orig)
+}
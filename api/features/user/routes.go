@@ -1,8 +1,6 @@
 package user
 
 import (
-	"crypto/md5"
-	"encoding/hex"
 	"errors"
 	"fmt"
 	"myproject/api/database"
@@ -11,7 +9,7 @@ import (
 	"myproject/api/utils"
 	"net/url"
 	"os"
-	"time"
+	"strconv"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
@@ -45,6 +43,12 @@ func PasswordRoutes(app fiber.Router, db *gorm.DB) {
 
 // routes with no prefix
 func UserPublicRoutes(app fiber.Router, db *gorm.DB) {
+	// the public keys that verify the access tokens, for other services
+	app.Get("/.well-known/jwks.json", func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+		return c.JSON(services.Jwks())
+	})
+
 	app.Get("/connect", func(c *fiber.Ctx) error {
 
 		var jsbundle string
@@ -112,6 +116,11 @@ func UserPublicRoutes(app fiber.Router, db *gorm.DB) {
 		return LoginWithPassword(db, c, false)
 	})
 
+	// the code of the second factor for the challenge of the login
+	app.Post("/login/two_factor", func(c *fiber.Ctx) error {
+		return LoginWithTwoFactor(db, c, false)
+	})
+
 	// new user registration from an invited referral
 	app.Post("/user/register_invite/:code", func(c *fiber.Ctx) error {
 		return RegisterInvitedUser(db, c)
@@ -166,7 +175,7 @@ func UserApiRoutes(app fiber.Router, db *gorm.DB) {
 		id := c.Params("id")
 		token := c.Params("token")
 		var user models.User
-		result := db.First(&user, "id = ? and unlock_token = ?", id, token)
+		result := models.WhereUnlockToken(db, token).First(&user, "id = ?", id)
 
 		if result.Error != nil || errors.Is(result.Error, gorm.ErrRecordNotFound) {
 			fmt.Println(result.Error)
@@ -178,27 +187,11 @@ func UserApiRoutes(app fiber.Router, db *gorm.DB) {
 		user.UnlockToken = ""
 		db.Save(&user)
 
-		token, _, err := services.CreateJWTToken(user, time.Now().Add(time.Hour*(24*90)))
-		if err != nil {
+		// log the user in with a new session
+		if session, err := LogIn(db, c, user, false); session == nil || err != nil {
 			return err
 		}
 
-		domain := database.GetParam("JWT_DOMAIN")
-		if os.Getenv("TEST_MODE") == "true" || database.GetParam("USE_DOCKER") == "true" {
-			domain = "localhost"
-		}
-		// set the token cookie that identifies the user
-		cookie := fiber.Cookie{
-			Name:     database.GetParam("JWT_COOKIE"),
-			Value:    token,
-			Domain:   domain,
-			Expires:  time.Now().Add(time.Hour * 24 * 30), // expires in 30 days
-			HTTPOnly: true,
-			Secure:   true,
-		}
-
-		c.Cookie(&cookie)
-
 		u, err := url.Parse(c.Params("url"))
 		if err != nil {
 			return err
@@ -274,7 +267,7 @@ func UserApiRoutes(app fiber.Router, db *gorm.DB) {
 		rand, _ := uuid.NewRandom()
 		token := rand.String()
 
-		user.UnlockToken = token
+		user.UnlockToken = models.EncryptedString(token)
 
 		db.Save(&user)
 		return c.JSON(fiber.Map{"result": token})
@@ -295,7 +288,7 @@ func UserApiRoutes(app fiber.Router, db *gorm.DB) {
 
 		id := c.Params("id")
 		var user models.User
-		result := db.First(&user, "id = ? and email = ? and unlock_token = ?", id, req.Email, req.Token)
+		result := models.WhereUnlockToken(db, req.Token).First(&user, "id = ? and email = ?", id, req.Email)
 
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 			fmt.Println(result.Error)
@@ -307,7 +300,11 @@ func UserApiRoutes(app fiber.Router, db *gorm.DB) {
 		user.UnlockToken = ""
 		db.Save(&user)
 
-		return services.SetupJWTtoken(user, c)
+		session, err := LogIn(db, c, user, true)
+		if session == nil || err != nil {
+			return err
+		}
+		return c.JSON(session)
 	})
 
 	app.Post("/connect_with_code", func(c *fiber.Ctx) error {
@@ -323,7 +320,7 @@ func UserApiRoutes(app fiber.Router, db *gorm.DB) {
 		}
 
 		var user models.User
-		result := db.First(&user, "phone = ? and email = ? and unlock_token = ?", req.Phone, req.Email, req.Token)
+		result := models.WhereUnlockToken(db, req.Token).First(&user, "phone = ? and email = ?", req.Phone, req.Email)
 
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 			fmt.Println(result.Error)
@@ -335,7 +332,7 @@ func UserApiRoutes(app fiber.Router, db *gorm.DB) {
 		token := rand.String()
 
 		// change the unlock token
-		user.UnlockToken = token
+		user.UnlockToken = models.EncryptedString(token)
 		db.Save(&user)
 
 		/*
@@ -360,7 +357,7 @@ func UserApiRoutes(app fiber.Router, db *gorm.DB) {
 			Email:  user.Email,
 			Phone:  user.Phone,
 			UserId: user.ID,
-			Token:  user.UnlockToken,
+			Token:  string(user.UnlockToken),
 			Locale: user.Locale,
 		}
 
@@ -419,33 +416,35 @@ func UserApiRoutes(app fiber.Router, db *gorm.DB) {
 		// sig.UserId is the verification code number - not the user ID
 		text := fmt.Sprintf("%s-%d-%s-%s", req.Email, sig.UserId, req.Token, sig.Nonce)
 
-		hash := md5.Sum([]byte(text))
-		res := hex.EncodeToString(hash[:])
-		if res == sig.Signature {
-			if err := CreateUser(db, c, true); err != nil {
-				fmt.Println(err, sig)
-				return err
-			}
+		if _, err := services.VerifyToken(c, text, sig.Nonce, sig.Signature); err != nil {
+			fmt.Println(err)
+			return err
+		}
 
-			// get the new or existing user and create the JWT token
-			var user models.User
-			if err := db.First(&user, "email = ? ", req.Email).Error; err != nil {
-				fmt.Println(err, sig)
-				return err
-			}
+		if err := CreateUser(db, c, true); err != nil {
+			fmt.Println(err, sig)
+			return err
+		}
 
-			rand, _ := uuid.NewRandom()
-			token := rand.String()
+		// get the new or existing user and create the JWT token
+		var user models.User
+		if err := db.First(&user, "email = ? ", req.Email).Error; err != nil {
+			fmt.Println(err, sig)
+			return err
+		}
 
-			user.UnlockToken = token
-			db.Save(&user)
+		rand, _ := uuid.NewRandom()
+		token := rand.String()
 
-			services.SetupJWTtoken(user, c)
+		user.UnlockToken = models.EncryptedString(token)
+		db.Save(&user)
 
-			// createUser has already sent the result to the client
-			return nil
+		// the challenge of the second factor replaces the one CreateUser sent
+		session, err := LogIn(db, c, user, true)
+		if session == nil || err != nil {
+			return err
 		}
-		return fmt.Errorf("signature does not match [%d, %s, %s, %s, %s]", sig.UserId, req.Token, req.Email, sig.Signature, res)
+		return c.JSON(session)
 	})
 
 	app.Post("/code_for_credentials", func(c *fiber.Ctx) error {
@@ -476,12 +475,89 @@ func UserApiRoutes(app fiber.Router, db *gorm.DB) {
 		return LoginWithCode(db, c)
 	})
 
+	// the second step of the login, with the challenge and a TOTP or recovery code
+	app.Post("/login/two_factor", func(c *fiber.Ctx) error {
+		return LoginWithTwoFactor(db, c, true)
+	})
+
+	// the secret and QR code for a challenge that requires to enrol a second factor first
+	app.Post("/login/two_factor/enrol", func(c *fiber.Ctx) error {
+		return EnrolLoginChallenge(db, c)
+	})
+
 	app.Post("/logout", func(c *fiber.Ctx) error {
+		services.RevokeRequestSession(db, c)
 		services.ClearCookie(c)
 
 		return utils.SendJsonResult(c, "OK")
 	})
 
+	// new access and refresh tokens for a refresh token
+	app.Post("/refresh", func(c *fiber.Ctx) error {
+		return RefreshToken(db, c)
+	})
+
+	// the sessions of the user on each device
+	app.Post("/:id/sessions", func(c *fiber.Ctx) error {
+		user, err := signedUser(db, c)
+		if err != nil {
+			return err
+		}
+		return GetSessions(db, c, user)
+	})
+
+	// revoke a session with sessionId, or all of them with all
+	app.Post("/:id/sessions/revoke", func(c *fiber.Ctx) error {
+		user, err := signedUser(db, c)
+		if err != nil {
+			return err
+		}
+		return RevokeSignedSessions(db, c, user)
+	})
+
+	// whether the second factor is enabled or required
+	app.Post("/:id/two_factor", func(c *fiber.Ctx) error {
+		user, err := signedUser(db, c)
+		if err != nil {
+			return err
+		}
+		return GetTwoFactor(db, c, user)
+	})
+
+	// a new TOTP secret, enabled once confirmed with a code
+	app.Post("/:id/two_factor/enrol", func(c *fiber.Ctx) error {
+		user, err := signedUser(db, c)
+		if err != nil {
+			return err
+		}
+		return EnrolTwoFactor(db, c, user)
+	})
+
+	app.Post("/:id/two_factor/confirm", func(c *fiber.Ctx) error {
+		user, err := signedUser(db, c)
+		if err != nil {
+			return err
+		}
+		return ConfirmTwoFactor(db, c, user)
+	})
+
+	// replace the recovery codes
+	app.Post("/:id/two_factor/recovery_codes", func(c *fiber.Ctx) error {
+		user, err := signedUser(db, c)
+		if err != nil {
+			return err
+		}
+		return NewRecoveryCodes(db, c, user)
+	})
+
+	app.Post("/:id/two_factor/disable", func(c *fiber.Ctx) error {
+		user, err := signedUser(db, c)
+		if err != nil {
+			return err
+		}
+		return DisableTwoFactor(db, c, user)
+	})
+
 	// user submits their email address to get a reset link
 	app.Post("/forgot", func(c *fiber.Ctx) error {
 		return SendResetLinkToEmail(db, c, true)
@@ -512,8 +588,26 @@ func UserApiRoutes(app fiber.Router, db *gorm.DB) {
 		return utils.SendJsonResult(c, result)
 	})
 
+	// the data items of the signed user with their values, which are masked everywhere else
+	app.Get("/:id/data_items", func(c *fiber.Ctx) error {
+		user, err := signedUser(db, c)
+		if err != nil {
+			return err
+		}
+
+		var items []models.DataItem
+		db.Where("user_id = ? and kind <> ?", user.ID, models.DataItemRecoveryCode).Order("id").Find(&items)
+
+		result := make([]map[string]interface{}, len(items))
+		for i, item := range items {
+			result[i] = item.Unmasked()
+		}
+		return utils.SendJsonResult(c, result)
+	})
+
 	app.Post("/:id/data_items", func(c *fiber.Ctx) error {
-		if _, err := services.VerifyFormSignature(db, c); err != nil {
+		user, err := services.VerifyFormSignature(db, c)
+		if err != nil {
 			fmt.Println(err)
 			c.Status(503).SendString(err.Error())
 			return err
@@ -532,11 +626,16 @@ func UserApiRoutes(app fiber.Router, db *gorm.DB) {
 			return err
 		}
 
+		// the user who saved their own item gets its value back
+		if item.UserId == user.ID {
+			return utils.SendJsonResult(c, item.Unmasked())
+		}
 		return utils.SendJsonResult(c, item)
 	})
 
 	app.Put("/:id/data_items", func(c *fiber.Ctx) error {
-		if _, err := services.VerifyFormSignature(db, c); err != nil {
+		user, err := services.VerifyFormSignature(db, c)
+		if err != nil {
 			fmt.Println(err)
 			c.Status(503).SendString(err.Error())
 			return err
@@ -555,6 +654,10 @@ func UserApiRoutes(app fiber.Router, db *gorm.DB) {
 			return err
 		}
 
+		// the user who saved their own item gets its value back
+		if item.UserId == user.ID {
+			return utils.SendJsonResult(c, item.Unmasked())
+		}
 		return utils.SendJsonResult(c, item)
 	})
 
@@ -591,27 +694,10 @@ func UserApiRoutes(app fiber.Router, db *gorm.DB) {
 		return UpdatePhone(db, c)
 	})
 
-	// update a team member GPS location
+	// update a team member GPS location, optional ?accuracy= in metres
+	// when signed by the user the position also checks them in and out of the geofences of their locations
 	app.Put("/:id/location/:latitude/:longitude", func(c *fiber.Ctx) error {
-		/*
-			if _, err := VerifyFormSignature(db, c); err != nil {
-				fmt.Println(err)
-				c.Status(503).SendString(err.Error())
-				return err
-			}
-		*/
-		point := fmt.Sprintf("SRID=4326;POINT(%s %s)", c.Params("longitude"), c.Params("latitude"))
-
-		// update the location geography
-		db.Exec("UPDATE users SET location = ? WHERE id = ?", point, c.Params("id"))
-
-		var orig models.User
-		if err := c.BodyParser(&orig); err != nil {
-			c.Status(fiber.StatusBadRequest).SendString("Error parsing driver")
-			return fiber.ErrConflict
-		}
-
-		return utils.SendJsonResult(c, orig)
+		return UpdatePosition(db, c)
 	})
 
 	app.Delete("/delete_for_token/:token", func(c *fiber.Ctx) error {
@@ -630,12 +716,51 @@ func UserRestrictedRoutes(app fiber.Router, db *gorm.DB) {
 	})
 
 	app.Get("/logout", func(c *fiber.Ctx) error {
-		// delete the cookie and redirect to login
+		// end the session, delete the cookies and redirect to login
+		services.RevokeRequestSession(db, c)
 		services.ClearCookie(c)
 
 		return c.Redirect("/")
 	})
 
+	app.Get("/sessions", func(c *fiber.Ctx) error {
+		return GetSessions(db, c, c.Locals("currentUser").(models.User))
+	})
+
+	// log out of all the sessions, keepCurrent=true to stay logged in on this device
+	app.Delete("/sessions", func(c *fiber.Ctx) error {
+		return RevokeSessions(db, c, c.Locals("currentUser").(models.User), c.Query("keepCurrent") == "true")
+	})
+
+	app.Delete("/sessions/:sid", func(c *fiber.Ctx) error {
+		sid, err := strconv.ParseUint(c.Params("sid"), 10, 64)
+		if err != nil {
+			c.Status(fiber.StatusNotAcceptable)
+			return utils.SendJsonResult(c, fiber.Map{"error": "invalid session id"})
+		}
+		return RevokeSession(db, c, c.Locals("currentUser").(models.User), uint(sid))
+	})
+
+	app.Get("/two_factor", func(c *fiber.Ctx) error {
+		return GetTwoFactor(db, c, c.Locals("currentUser").(models.User))
+	})
+
+	app.Post("/two_factor/enrol", func(c *fiber.Ctx) error {
+		return EnrolTwoFactor(db, c, c.Locals("currentUser").(models.User))
+	})
+
+	app.Post("/two_factor/confirm", func(c *fiber.Ctx) error {
+		return ConfirmTwoFactor(db, c, c.Locals("currentUser").(models.User))
+	})
+
+	app.Post("/two_factor/recovery_codes", func(c *fiber.Ctx) error {
+		return NewRecoveryCodes(db, c, c.Locals("currentUser").(models.User))
+	})
+
+	app.Delete("/two_factor", func(c *fiber.Ctx) error {
+		return DisableTwoFactor(db, c, c.Locals("currentUser").(models.User))
+	})
+
 	// change the unlock_token for a user
 	app.Get("/unlock_token/:id/:token", func(c *fiber.Ctx) error {
 		id := c.Params("id")
@@ -653,7 +778,7 @@ func UserRestrictedRoutes(app fiber.Router, db *gorm.DB) {
 				"You are not authorized to change the unlock token"))
 		}
 
-		db.Exec("update users set unlock_token = ? where id = ?", token, id)
+		db.Exec("update users set unlock_token = ?, unlock_token_index = ? where id = ?", models.EncryptedString(token), models.BlindIndexString(utils.BlindIndex(token)), id)
 
 		return c.JSON(fiber.Map{"result": "OK"})
 	})
@@ -699,7 +824,7 @@ func UserTestRoutes(app fiber.Router, db *gorm.DB) {
 		}
 
 		// reset the unlock token
-		user.UnlockToken = token
+		user.UnlockToken = models.EncryptedString(token)
 
 		// save the user - beforeSave updates hashedpassword
 		db.Save(&user)
@@ -129,7 +129,7 @@ func ConfirmEmail(db *gorm.DB, c *fiber.Ctx) error {
 	code := c.Params("code")
 
 	var userInstance models.User
-	result := db.First(&userInstance, "unlock_token = ?", code)
+	result := models.WhereUnlockToken(db, code).First(&userInstance)
 
 	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
@@ -138,8 +138,7 @@ func ConfirmEmail(db *gorm.DB, c *fiber.Ctx) error {
 	}
 
 	// extend the JWT token
-	_, err := services.SetTokenInClient(c, userInstance)
-	if err != nil {
+	if session, err := LogIn(db, c, userInstance, false); session == nil || err != nil {
 		return err
 	}
 
@@ -193,12 +192,10 @@ func RegisterInvitedUser(db *gorm.DB, c *fiber.Ctx) error {
 		// create the api key
 		rand, _ := uuid.NewRandom()
 		data.ApiKey = rand
-		data.UnlockToken = referral.Code
+		data.UnlockToken = models.EncryptedString(referral.Code)
 
 		// normalise the location data
-		data.City = utils.NormalizeAddress(data.City)
-		data.Province = utils.NormalizeAddress(data.Province)
-		data.Country = utils.NormalizeAddress(data.Country)
+		data.NormalizeAddress()
 
 		db.Create(&data)
 
@@ -212,7 +209,9 @@ func RegisterInvitedUser(db *gorm.DB, c *fiber.Ctx) error {
 
 	}
 
-	services.SetupJWTtoken(*data, c)
+	if session, err := LogIn(db, c, *data, false); session == nil || err != nil {
+		return err
+	}
 
 	// render a template to create a new user
 	return c.Render("user/registered_invite", fiber.Map{
@@ -254,17 +253,17 @@ func CreateUser(db *gorm.DB, c *fiber.Ctx, unlock bool) error {
 		data.ApiKey = rand
 
 		// normalise the location data
-		data.City = utils.NormalizeAddress(data.City)
-		data.Province = utils.NormalizeAddress(data.Province)
-		data.Country = utils.NormalizeAddress(data.Country)
+		data.NormalizeAddress()
 
 		if unlock {
-			data.UnlockToken = rand.String()
+			data.UnlockToken = models.EncryptedString(rand.String())
 		}
 
 		db.Create(&data)
 
-		services.SetupJWTtoken(*data, c)
+		if session, err := LogIn(db, c, *data, true); session == nil || err != nil {
+			return err
+		}
 
 		return utils.SendJsonResult(c, data)
 	}
@@ -280,9 +279,7 @@ func createNewUser(db *gorm.DB, data *models.User) (*models.User, error) {
 	data.UnlockToken = ""
 
 	// normalise the location data
-	data.City = utils.NormalizeAddress(data.City)
-	data.Province = utils.NormalizeAddress(data.Province)
-	data.Country = utils.NormalizeAddress(data.Country)
+	data.NormalizeAddress()
 
 	db.Create(&data)
 
@@ -448,7 +445,7 @@ func UpdatePassword(db *gorm.DB, c *fiber.Ctx) error {
 
 	// find the user for the token
 	var user models.User
-	result := db.First(&user, "unlock_token = ?", data.Token)
+	result := models.WhereUnlockToken(db, data.Token).First(&user)
 	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 		return c.Render("error", fiber.Map{
 			"Error": "The password reset token does not exist",
@@ -463,7 +460,9 @@ func UpdatePassword(db *gorm.DB, c *fiber.Ctx) error {
 	db.Save(&user)
 
 	// setup the JWT token
-	services.SetupJWTtoken(user, c)
+	if session, err := LogIn(db, c, user, false); session == nil || err != nil {
+		return err
+	}
 
 	return c.Render("user/password_updated", fiber.Map{}, "layouts/htmx_partial")
 }
@@ -493,7 +492,7 @@ func SendResetLinkToEmail(db *gorm.DB, c *fiber.Ctx, isApi bool) error {
 	}
 
 	// generate a random UUID
-	user.UnlockToken = utils.GenerateUUID()
+	user.UnlockToken = models.EncryptedString(utils.GenerateUUID())
 
 	db.Save(&user)
 
@@ -586,7 +585,7 @@ func EmailCodeForCredentials(db *gorm.DB, c *fiber.Ctx) error {
 		response = "register"
 	} else {
 
-		user.UnlockToken = fmt.Sprintf("%d", code)
+		user.UnlockToken = models.EncryptedString(fmt.Sprintf("%d", code))
 
 		db.Save(&user)
 	}
@@ -626,14 +625,14 @@ func RegisterWithCode(db *gorm.DB, c *fiber.Ctx) error {
 	// find the user either in the users table or in the contacts table
 	var user models.User
 
-	result := db.Where("phone = ? and unlock_token = ?", data.Phone, data.UnlockToken).First(&user)
+	result := models.WhereUnlockToken(db, string(data.UnlockToken)).Where("phone = ?", data.Phone).First(&user)
 
 	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 		// user does not exist
 		// get the contact from the contacts table for the unlock_token
 		var contact models.Contact
 
-		result = db.Where("code = ? and phone = ?", data.UnlockToken, data.Phone).First(&contact)
+		result = db.Where("code = ? and phone = ?", string(data.UnlockToken), data.Phone).First(&contact)
 
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 			c.Status(503).SendString("unlock token does not match")
@@ -666,7 +665,9 @@ func RegisterWithCode(db *gorm.DB, c *fiber.Ctx) error {
 
 		db.Save(&contact)
 
-		services.SetupJWTtoken(*newUser, c)
+		if session, err := LogIn(db, c, *newUser, true); session == nil || err != nil {
+			return err
+		}
 
 		return utils.SendJsonResult(c, newUser)
 	}
@@ -714,9 +715,7 @@ func UpdateUser(db *gorm.DB, c *fiber.Ctx) error {
 	}
 
 	// normalise the location data
-	user.City = utils.NormalizeAddress(user.City)
-	user.Province = utils.NormalizeAddress(user.Province)
-	user.Country = utils.NormalizeAddress(user.Country)
+	user.NormalizeAddress()
 
 	db.Save(&user)
 
@@ -831,9 +830,8 @@ func CreateSession(db *gorm.DB, c *fiber.Ctx, user *models.User, data *models.Us
 	if user.City != data.City {
 
 		// normalise the location data
-		user.City = utils.NormalizeAddress(data.City)
-		user.Province = utils.NormalizeAddress(data.Province)
-		user.Country = utils.NormalizeAddress(data.Country)
+		user.City, user.Province, user.Country = data.City, data.Province, data.Country
+		user.NormalizeAddress()
 
 		db.Save(user)
 	}
@@ -846,7 +844,9 @@ func CreateSession(db *gorm.DB, c *fiber.Ctx, user *models.User, data *models.Us
 		fmt.Println(err, sig)
 	}
 
-	services.SetupJWTtoken(*user, c)
+	if session, err := LogIn(db, c, *user, true); session == nil || err != nil {
+		return err
+	}
 
 	return utils.SendJsonResult(c, user)
 }
@@ -896,12 +896,20 @@ func LoginWithPassword(db *gorm.DB, c *fiber.Ctx, isApi bool) error {
 		return c.Redirect("/login?message=Password+does+not+match")
 	}
 
-	services.SetupJWTtoken(user, c)
+	// the session only starts after the second factor
+	if session, err := LogIn(db, c, user, isApi); session == nil || err != nil {
+		return err
+	}
 
 	if isApi {
 		return utils.SendJsonResult(c, user.ToMap())
 	}
 
+	return redirectAfterLogin(c, user)
+}
+
+// redirectAfterLogin send the user to the home of their role
+func redirectAfterLogin(c *fiber.Ctx, user models.User) error {
 	if strings.Contains(user.Roles, "admin") {
 		return redirectToAdmin(c)
 	} else if strings.Contains(user.Roles, "provider") {
@@ -922,7 +930,7 @@ func LoginWithCode(db *gorm.DB, c *fiber.Ctx) error {
 
 	var user models.User
 
-	result := db.Preload("BusinessRoles").Where("email = ? and phone = ? and unlock_token = ?", data.Email, data.Phone, data.UnlockToken).First(&user)
+	result := models.WhereUnlockToken(db, string(data.UnlockToken)).Preload("BusinessRoles").Where("email = ? and phone = ?", data.Email, data.Phone).First(&user)
 
 	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 		fmt.Println(result.Error)
@@ -937,7 +945,10 @@ func LoginWithCode(db *gorm.DB, c *fiber.Ctx) error {
 		db.Save(&user)
 	}
 
-	services.SetupJWTtoken(user, c)
+	// the session only starts after the second factor
+	if session, err := LogIn(db, c, user, true); session == nil || err != nil {
+		return err
+	}
 
 	return utils.SendJsonResult(c, user)
 }
@@ -954,7 +965,7 @@ func LoginForQRcode(db *gorm.DB, c *fiber.Ctx) error {
 
 	var user models.User
 
-	result := db.Preload("BusinessRoles").Where("id = ? and email = ? and unlock_token = ? and unlock_token != ''", id, data.Email, data.UnlockToken).First(&user)
+	result := models.WhereUnlockToken(db, string(data.UnlockToken)).Preload("BusinessRoles").Where("id = ? and email = ?", id, data.Email).First(&user)
 
 	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 		fmt.Println(result.Error)
@@ -967,13 +978,14 @@ func LoginForQRcode(db *gorm.DB, c *fiber.Ctx) error {
 
 	// update the user location
 	// normalise the location data
-	user.City = utils.NormalizeAddress(data.City)
-	user.Province = utils.NormalizeAddress(data.Province)
-	user.Country = utils.NormalizeAddress(data.Country)
+	user.City, user.Province, user.Country = data.City, data.Province, data.Country
+	user.NormalizeAddress()
 
 	db.Save(&user)
 
-	services.SetupJWTtoken(user, c)
+	if session, err := LogIn(db, c, user, true); session == nil || err != nil {
+		return err
+	}
 
 	return utils.SendJsonResult(c, user)
 }
@@ -991,7 +1003,7 @@ func LoginForToken(db *gorm.DB, c *fiber.Ctx) error {
 	text := fmt.Sprintf("%s-%s-%s-%s", sig.Nonce, sig.AppName, sig.PackageName, token)
 
 	// verify the request came from the app
-	_, err := services.VerifyToken(text, sig.Signature)
+	_, err := services.VerifyToken(c, text, sig.Nonce, sig.Signature)
 
 	if err != nil {
 		fmt.Println(err)
@@ -1013,7 +1025,7 @@ func LoginForToken(db *gorm.DB, c *fiber.Ctx) error {
 
 	var user models.User
 
-	result := db.Preload("BusinessRoles").Where("id = ? and unlock_token = ?", referral.ReferredID, token).First(&user)
+	result := models.WhereUnlockToken(db, token).Preload("BusinessRoles").Where("id = ?", referral.ReferredID).First(&user)
 
 	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 		fmt.Println(result.Error)
@@ -1029,15 +1041,16 @@ func LoginForToken(db *gorm.DB, c *fiber.Ctx) error {
 	}
 
 	// normalise the location data
-	user.City = utils.NormalizeAddress(data.City)
-	user.Province = utils.NormalizeAddress(data.Province)
-	user.Country = utils.NormalizeAddress(data.Country)
+	user.City, user.Province, user.Country = data.City, data.Province, data.Country
+	user.NormalizeAddress()
 
 	// reset the unlock token
 	user.UnlockToken = ""
 	db.Save(&user)
 
-	services.SetupJWTtoken(user, c)
+	if session, err := LogIn(db, c, user, true); session == nil || err != nil {
+		return err
+	}
 
 	res := fiber.Map{"result": user}
 
@@ -1056,7 +1069,7 @@ func LoginWithTokenLink(db *gorm.DB, c *fiber.Ctx) error {
 
 	var user models.User
 
-	result := db.Where("id = ? and unlock_token = ?", id, token).First(&user)
+	result := models.WhereUnlockToken(db, token).Where("id = ?", id).First(&user)
 
 	fmt.Println("LoginWithTokenLink", id, token, user)
 
@@ -1071,18 +1084,11 @@ func LoginWithTokenLink(db *gorm.DB, c *fiber.Ctx) error {
 	user.UnlockToken = ""
 	db.Save(&user)
 
-	_, err := services.SetTokenInClient(c, user)
-	if err != nil {
+	if session, err := LogIn(db, c, user, false); session == nil || err != nil {
 		return err
 	}
 
-	if strings.Contains(user.Roles, "admin") {
-		return redirectToAdmin(c)
-	} else if strings.Contains(user.Roles, "provider") {
-		return redirectToProvider(c)
-	}
-
-	return c.Redirect("https://myproject.com/")
+	return redirectAfterLogin(c, user)
 }
 
 func DeleteUserAccount(db *gorm.DB, c *fiber.Ctx) error {
@@ -1105,7 +1111,7 @@ func DeleteUserAccount(db *gorm.DB, c *fiber.Ctx) error {
 
 	code := r1.Intn(79000) + 10000
 
-	user.UnlockToken = fmt.Sprintf("%d", code)
+	user.UnlockToken = models.EncryptedString(fmt.Sprintf("%d", code))
 
 	db.Save(&user)
 
@@ -1135,7 +1141,7 @@ func DeleteUserAccountForToken(db *gorm.DB, c *fiber.Ctx) error {
 	}
 
 	var user models.User
-	result := db.First(&user, "email = ? and unlock_token = ?", data.Email, token)
+	result := models.WhereUnlockToken(db, token).First(&user, "email = ?", data.Email)
 
 	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 		fmt.Println(result.Error)
@@ -1186,8 +1192,15 @@ func SetUserConfigMap(db *gorm.DB, c *fiber.Ctx, id string) (models.Config, erro
 	return *configItem, nil
 }
 
+// errRecoveryCodeItem the recovery codes of the second factor are only written by the two factor endpoints
+var errRecoveryCodeItem = fiber.NewError(fiber.StatusNotAcceptable, "recovery codes can not be changed as data items")
+
 func AddDataItem(db *gorm.DB, userId string, dataItem *models.DataItem) (*models.DataItem, error) {
 
+	if dataItem.Kind == models.DataItemRecoveryCode {
+		return nil, errRecoveryCodeItem
+	}
+
 	err := db.Create(&dataItem).Error
 	if err != nil {
 		return nil, err
@@ -1198,6 +1211,16 @@ func AddDataItem(db *gorm.DB, userId string, dataItem *models.DataItem) (*models
 
 func UpdateDataItem(db *gorm.DB, userId string, dataItem *models.DataItem) (*models.DataItem, error) {
 
+	if dataItem.Kind == models.DataItemRecoveryCode {
+		return nil, errRecoveryCodeItem
+	}
+	if dataItem.ID != 0 {
+		var existing models.DataItem
+		if err := db.Select("kind").First(&existing, dataItem.ID).Error; err == nil && existing.Kind == models.DataItemRecoveryCode {
+			return nil, errRecoveryCodeItem
+		}
+	}
+
 	err := db.Save(&dataItem).Error
 	if err != nil {
 		return nil, err
@@ -0,0 +1,115 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"myproject/api/models"
+	"myproject/api/services"
+	"myproject/api/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+type revokeSessionsRequest struct {
+	SessionId   uint `json:"sessionId" form:"sessionId"`
+	All         bool `json:"all" form:"all"`
+	KeepCurrent bool `json:"keepCurrent" form:"keepCurrent"` // with all, keep the session of the request
+}
+
+// GetSessions the devices the user is logged in on
+func GetSessions(db *gorm.DB, c *fiber.Ctx, user models.User) error {
+	return utils.SendJsonResult(c, services.UserSessions(db, c, user.ID))
+}
+
+// RevokeSession log the user out of one of their sessions
+func RevokeSession(db *gorm.DB, c *fiber.Ctx, user models.User, sessionId uint) error {
+	var session models.UserSession
+	if err := db.First(&session, "id = ? and user_id = ?", sessionId, user.ID).Error; err != nil {
+		c.Status(fiber.StatusNotFound)
+		return utils.SendJsonResult(c, fiber.Map{"error": "session not found"})
+	}
+
+	if err := services.RevokeSession(db, &session, models.SessionRevoked); err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	if current, ok := c.Locals("sessionId").(uint); ok && current == session.ID {
+		services.ClearCookie(c)
+	}
+	return utils.SendJsonResult(c, fiber.Map{"revoked": 1})
+}
+
+// RevokeSessions log the user out of all their sessions, or all but the session of the request
+func RevokeSessions(db *gorm.DB, c *fiber.Ctx, user models.User, keepCurrent bool) error {
+	var except uint
+	if keepCurrent {
+		except, _ = c.Locals("sessionId").(uint)
+	}
+
+	revoked, err := services.RevokeUserSessions(db, user.ID, except, models.SessionRevoked)
+	if err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	if except == 0 {
+		services.ClearCookie(c)
+	}
+	return utils.SendJsonResult(c, fiber.Map{"revoked": revoked})
+}
+
+// RevokeSignedSessions revoke one session, or all of them, of the user of a signed request
+func RevokeSignedSessions(db *gorm.DB, c *fiber.Ctx, user models.User) error {
+	req := new(revokeSessionsRequest)
+	if err := c.BodyParser(req); err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	if req.All {
+		return RevokeSessions(db, c, user, req.KeepCurrent)
+	}
+	if req.SessionId == 0 {
+		c.Status(fiber.StatusNotAcceptable)
+		return utils.SendJsonResult(c, fiber.Map{"error": "sessionId or all is required"})
+	}
+	return RevokeSession(db, c, user, req.SessionId)
+}
+
+// RefreshToken a new access token and refresh token for the refresh token in the body or the cookie
+func RefreshToken(db *gorm.DB, c *fiber.Ctx) error {
+	req := struct {
+		RefreshToken string `json:"refreshToken" form:"refreshToken"`
+	}{}
+	c.BodyParser(&req)
+	if req.RefreshToken == "" {
+		req.RefreshToken = c.Cookies(services.RefreshCookieName())
+	}
+
+	data, err := services.RefreshSession(db, c, req.RefreshToken)
+	if err != nil {
+		services.ClearCookie(c)
+		return c.Status(fiber.StatusUnauthorized).SendString(err.Error())
+	}
+	return utils.SendJsonResult(c, data)
+}
+
+// signedUser the user of a signed request that must match the :id param
+func signedUser(db *gorm.DB, c *fiber.Ctx) (models.User, error) {
+	user, err := services.VerifyFormSignature(db, c)
+	if err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return user, err
+	}
+
+	if c.Params("id") != strconv.FormatUint(uint64(user.ID), 10) {
+		c.Status(503).SendString("user id does not match")
+		return user, errors.New("user id does not match")
+	}
+	return user, nil
+}
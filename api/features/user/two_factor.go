@@ -0,0 +1,198 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/url"
+
+	"myproject/api/models"
+	"myproject/api/services"
+	"myproject/api/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+type twoFactorRequest struct {
+	Challenge string `json:"challenge" form:"challenge"`
+	Code      string `json:"code" form:"code"` // TOTP code or recovery code
+}
+
+// twoFactorError send the response for the errors of the second factor
+func twoFactorError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, services.ErrInvalidTwoFactorCode), errors.Is(err, services.ErrInvalidChallenge):
+		c.Status(fiber.StatusUnauthorized)
+	case errors.Is(err, services.ErrTwoFactorEnabled), errors.Is(err, services.ErrTwoFactorNotEnrolled), errors.Is(err, services.ErrTwoFactorRequired):
+		c.Status(fiber.StatusNotAcceptable)
+	case errors.Is(err, services.ErrTwoFactorLocked):
+		c.Status(fiber.StatusTooManyRequests)
+	default:
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+	return utils.SendJsonResult(c, fiber.Map{"error": err.Error()})
+}
+
+func parseTwoFactorRequest(c *fiber.Ctx) (*twoFactorRequest, error) {
+	req := new(twoFactorRequest)
+	if err := c.BodyParser(req); err != nil {
+		fmt.Println(err)
+		c.Status(503).SendString(err.Error())
+		return nil, err
+	}
+	return req, nil
+}
+
+// GetTwoFactor whether the user has a second factor, and whether a business requires one
+func GetTwoFactor(db *gorm.DB, c *fiber.Ctx, user models.User) error {
+	var remaining int64
+	db.Model(&models.DataItem{}).Where("user_id = ? and kind = ?", user.ID, models.DataItemRecoveryCode).Count(&remaining)
+
+	return utils.SendJsonResult(c, fiber.Map{
+		"enabled":       services.TwoFactorEnabled(db, user.ID),
+		"required":      services.TwoFactorRequired(db, user.ID),
+		"recoveryCodes": remaining,
+	})
+}
+
+// EnrolTwoFactor the pending TOTP secret, or a new one, with its otpauth URI and QR code, enabled by ConfirmTwoFactor
+func EnrolTwoFactor(db *gorm.DB, c *fiber.Ctx, user models.User) error {
+	enrolment, err := services.EnrolTwoFactor(db, user)
+	if err != nil {
+		return twoFactorError(c, err)
+	}
+	return utils.SendJsonResult(c, enrolment)
+}
+
+// ConfirmTwoFactor enable the second factor with a first code, returns the recovery codes
+func ConfirmTwoFactor(db *gorm.DB, c *fiber.Ctx, user models.User) error {
+	req, err := parseTwoFactorRequest(c)
+	if err != nil {
+		return err
+	}
+
+	codes, err := services.ConfirmTwoFactor(db, user.ID, req.Code)
+	if err != nil {
+		return twoFactorError(c, err)
+	}
+	return utils.SendJsonResult(c, fiber.Map{"enabled": true, "recoveryCodes": codes})
+}
+
+// NewRecoveryCodes replace the recovery codes after checking a code
+func NewRecoveryCodes(db *gorm.DB, c *fiber.Ctx, user models.User) error {
+	req, err := parseTwoFactorRequest(c)
+	if err != nil {
+		return err
+	}
+
+	if err := services.CheckTwoFactorCode(db, user.ID, req.Code); err != nil {
+		return twoFactorError(c, err)
+	}
+	codes, err := services.NewRecoveryCodes(db, user.ID)
+	if err != nil {
+		return twoFactorError(c, err)
+	}
+	return utils.SendJsonResult(c, fiber.Map{"recoveryCodes": codes})
+}
+
+// DisableTwoFactor remove the second factor after checking a code
+func DisableTwoFactor(db *gorm.DB, c *fiber.Ctx, user models.User) error {
+	req, err := parseTwoFactorRequest(c)
+	if err != nil {
+		return err
+	}
+
+	if err := services.DisableTwoFactor(db, user.ID, req.Code); err != nil {
+		return twoFactorError(c, err)
+	}
+	return utils.SendJsonResult(c, fiber.Map{"enabled": false})
+}
+
+// LogIn start the session of a user who gave a first factor, returning the session data. Users who need
+// a second factor get its challenge as the response instead and no session, handlers then send nothing more.
+// Every handler that logs a user in goes through here so none of them skips the second factor.
+func LogIn(db *gorm.DB, c *fiber.Ctx, user models.User, isApi bool) (fiber.Map, error) {
+	if services.NeedsTwoFactor(db, user.ID) {
+		return nil, sendTwoFactorChallenge(db, c, user, isApi)
+	}
+	return services.SetTokenInClient(db, c, user)
+}
+
+// sendTwoFactorChallenge answer a login with a first factor with the challenge of the second factor
+func sendTwoFactorChallenge(db *gorm.DB, c *fiber.Ctx, user models.User, isApi bool) error {
+	challenge, err := services.StartTwoFactorChallenge(db, user)
+	if err != nil {
+		if isApi {
+			return twoFactorError(c, err)
+		}
+		return c.Redirect("/login?message=" + url.QueryEscape(err.Error()))
+	}
+
+	if isApi {
+		return utils.SendJsonResult(c, challenge)
+	}
+	return renderTwoFactor(db, c, challenge["challenge"].(string), "")
+}
+
+// renderTwoFactor the page that asks for the code of a challenge, with the QR code to enrol when needed
+func renderTwoFactor(db *gorm.DB, c *fiber.Ctx, challenge, message string) error {
+	data := fiber.Map{
+		"Challenge": challenge,
+		"Message":   message,
+		"jsbundle":  "/dist/user.bundle.js",
+		"cssbundle": "/dist/main.bundle.css",
+	}
+
+	if enrolment, err := services.EnrolChallenge(db, challenge); err == nil {
+		// a data URI, trusted so html/template keeps it in the src of the image
+		data["Qr"] = template.URL(enrolment["qr"].(string))
+		data["Secret"] = enrolment["secret"]
+	}
+	return c.Render("user/two_factor", data)
+}
+
+// LoginWithTwoFactor the second step of the login, the code of the challenge gets the session
+func LoginWithTwoFactor(db *gorm.DB, c *fiber.Ctx, isApi bool) error {
+	req, err := parseTwoFactorRequest(c)
+	if err != nil {
+		return err
+	}
+
+	user, codes, err := services.CompleteTwoFactorChallenge(db, req.Challenge, req.Code)
+	if err != nil {
+		if isApi {
+			return twoFactorError(c, err)
+		}
+		return c.Redirect("/login?message=" + url.QueryEscape(err.Error()))
+	}
+
+	if _, err := services.SetTokenInClient(db, c, user); err != nil {
+		fmt.Println(err)
+		return c.Status(503).SendString(err.Error())
+	}
+
+	if isApi {
+		result := user.ToMap()
+		if codes != nil {
+			result["recoveryCodes"] = codes
+		}
+		return utils.SendJsonResult(c, result)
+	}
+	return redirectAfterLogin(c, user)
+}
+
+// EnrolLoginChallenge the QR code of the challenge of a user who must enrol before logging in
+func EnrolLoginChallenge(db *gorm.DB, c *fiber.Ctx) error {
+	req, err := parseTwoFactorRequest(c)
+	if err != nil {
+		return err
+	}
+
+	enrolment, err := services.EnrolChallenge(db, req.Challenge)
+	if err != nil {
+		return twoFactorError(c, err)
+	}
+	return utils.SendJsonResult(c, enrolment)
+}
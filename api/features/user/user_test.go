@@ -1,14 +1,23 @@
 package user
 
 import (
+	"bytes"
 	"encoding/json"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"myproject/api/database"
+	"myproject/api/models"
+	"myproject/api/services"
+	"myproject/api/utils"
 	"myproject/test"
 
+	"github.com/golang-jwt/jwt/v4"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
 )
 
 func setupUserTestApp(t *testing.T) *fiber.App {
@@ -39,3 +48,314 @@ func TestGetUser(t *testing.T) {
 	assert.True(t, ok)
 	assert.NotNil(t, userData["id"])
 }
+
+func TestAccessTokens(t *testing.T) {
+	database.SystemParams["jwt_secret"] = "access-token-test-secret"
+	user := models.User{ID: 3}
+
+	t.Run("Claims", func(t *testing.T) {
+		token, jti, err := services.CreateJWTToken(user, 7, time.Now().Add(time.Minute))
+		assert.Nil(t, err)
+		assert.NotEmpty(t, jti)
+
+		claims, err := services.ParseAccessToken(token)
+		assert.Nil(t, err)
+		assert.Equal(t, uint(3), claims.UserId)
+		assert.Equal(t, uint(7), claims.SessionId)
+		assert.Equal(t, jti, claims.ID)
+	})
+
+	t.Run("Unique IDs", func(t *testing.T) {
+		_, first, _ := services.CreateJWTToken(user, 7, time.Now().Add(time.Minute))
+		_, second, _ := services.CreateJWTToken(user, 7, time.Now().Add(time.Minute))
+		assert.NotEqual(t, first, second)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		token, _, _ := services.CreateJWTToken(user, 7, time.Now().Add(-time.Minute))
+		_, err := services.ParseAccessToken(token)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("Other secret", func(t *testing.T) {
+		token, _, _ := services.CreateJWTToken(user, 7, time.Now().Add(time.Minute))
+		database.SystemParams["jwt_secret"] = "another-secret"
+		defer func() { database.SystemParams["jwt_secret"] = "access-token-test-secret" }()
+
+		_, err := services.ParseAccessToken(token)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("Unsigned", func(t *testing.T) {
+		token, _ := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{"user_id": 3, "sid": 7}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+		_, err := services.ParseAccessToken(token)
+		assert.NotNil(t, err)
+	})
+}
+
+func TestSessions(t *testing.T) {
+	app, db, err := test.SetupTestApp()
+	if err != nil {
+		t.Fatalf("Failed to setup test app: %v", err)
+	}
+
+	// logs the test user in with a new session
+	app.Post("/test/login", func(c *fiber.Ctx) error {
+		var user models.User
+		services.UserForId(db, 3, &user)
+		data, err := services.SetTokenInClient(db, c, user)
+		if err != nil {
+			return err
+		}
+		return utils.SendJsonResult(c, data)
+	})
+	api := app.Group("/api/v1")
+	UserApiRoutes(api.Group("user"), db)
+	t.Cleanup(func() { db.Exec("delete from user_sessions where user_id = 3") })
+
+	post := func(url string, data map[string]interface{}) (int, map[string]interface{}) {
+		jsonData, _ := json.Marshal(data)
+		req := httptest.NewRequest("POST", url, bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+
+		var result map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+
+	login := func() map[string]interface{} {
+		status, result := post("/test/login", map[string]interface{}{"packageName": "com.example.app"})
+		assert.Equal(t, 200, status)
+		return result["result"].(map[string]interface{})
+	}
+
+	t.Run("Refresh rotates the token", func(t *testing.T) {
+		session := login()
+		refresh := session["refreshToken"].(string)
+
+		status, result := post("/api/v1/user/refresh", map[string]interface{}{"refreshToken": refresh})
+		assert.Equal(t, 200, status)
+		rotated := result["result"].(map[string]interface{})
+		assert.NotEqual(t, refresh, rotated["refreshToken"])
+		assert.Equal(t, session["sessionId"], rotated["sessionId"])
+
+		claims, err := services.ParseAccessToken(rotated["token"].(string))
+		assert.Nil(t, err)
+		assert.Equal(t, uint(3), claims.UserId)
+	})
+
+	t.Run("Reused refresh token revokes the session", func(t *testing.T) {
+		session := login()
+		refresh := session["refreshToken"].(string)
+
+		status, result := post("/api/v1/user/refresh", map[string]interface{}{"refreshToken": refresh})
+		assert.Equal(t, 200, status)
+		rotated := result["result"].(map[string]interface{})
+
+		// replaced long ago, not a parallel request
+		db.Exec("update user_sessions set rotated_at = ? where id = ?", time.Now().Add(-time.Hour), session["sessionId"])
+
+		status, _ = post("/api/v1/user/refresh", map[string]interface{}{"refreshToken": refresh})
+		assert.Equal(t, 401, status)
+
+		var revoked models.UserSession
+		db.First(&revoked, session["sessionId"])
+		assert.NotNil(t, revoked.RevokedAt)
+		assert.Equal(t, models.SessionReused, revoked.RevokedWhy)
+
+		// the thief and the user are both logged out
+		status, _ = post("/api/v1/user/refresh", map[string]interface{}{"refreshToken": rotated["refreshToken"]})
+		assert.Equal(t, 401, status)
+	})
+
+	t.Run("A parallel refresh of an expired session is rejected", func(t *testing.T) {
+		session := login()
+		refresh := session["refreshToken"].(string)
+
+		status, _ := post("/api/v1/user/refresh", map[string]interface{}{"refreshToken": refresh})
+		assert.Equal(t, 200, status)
+
+		// still within the grace of the rotation
+		db.Exec("update user_sessions set expires_at = ? where id = ?", time.Now().Add(-time.Minute), session["sessionId"])
+
+		status, _ = post("/api/v1/user/refresh", map[string]interface{}{"refreshToken": refresh})
+		assert.Equal(t, 401, status)
+	})
+
+	t.Run("List and revoke", func(t *testing.T) {
+		session := login()
+
+		data := map[string]interface{}{}
+		test.SignMap(data)
+		status, result := post("/api/v1/user/3/sessions", data)
+		assert.Equal(t, 200, status)
+
+		found := false
+		for _, item := range result["result"].([]interface{}) {
+			listed := item.(map[string]interface{})
+			if listed["id"] == session["sessionId"] {
+				found = true
+				assert.Equal(t, "com.example.app", listed["package"])
+			}
+		}
+		assert.True(t, found)
+
+		data = map[string]interface{}{"sessionId": session["sessionId"]}
+		test.SignMap(data)
+		status, _ = post("/api/v1/user/3/sessions/revoke", data)
+		assert.Equal(t, 200, status)
+
+		status, _ = post("/api/v1/user/refresh", map[string]interface{}{"refreshToken": session["refreshToken"]})
+		assert.Equal(t, 401, status)
+
+		login()
+		data = map[string]interface{}{"all": true}
+		test.SignMap(data)
+		status, result = post("/api/v1/user/3/sessions/revoke", data)
+		assert.Equal(t, 200, status)
+		assert.NotZero(t, result["result"].(map[string]interface{})["revoked"])
+
+		var active int64
+		db.Model(&models.UserSession{}).Where("user_id = 3 and revoked_at is null").Count(&active)
+		assert.Zero(t, active)
+	})
+}
+
+func TestLogInTwoFactor(t *testing.T) {
+	app, db, err := test.SetupTestApp()
+	if err != nil {
+		t.Fatalf("Failed to setup test app: %v", err)
+	}
+	api := app.Group("/api/v1")
+	UserApiRoutes(api.Group("user"), db)
+
+	var user models.User
+	assert.Nil(t, services.UserForId(db, 3, &user))
+	t.Cleanup(func() {
+		db.Exec("update users set unlock_token = ?, unlock_token_index = ? where id = 3", user.UnlockToken, user.UnlockTokenIndex)
+		db.Where("user_id = 3").Delete(&models.UserTwoFactor{})
+		db.Where("user_id = 3 and kind = ?", models.DataItemRecoveryCode).Delete(&models.DataItem{})
+		db.Exec("delete from user_sessions where user_id = 3")
+	})
+
+	enrolment, err := services.EnrolTwoFactor(db, user)
+	assert.Nil(t, err)
+	code, _ := services.TotpCode(enrolment["secret"].(string), time.Now().Unix()/30)
+	_, err = services.ConfirmTwoFactor(db, user.ID, code)
+	assert.Nil(t, err)
+
+	// an unlock token is a first factor like the password
+	token := "two-factor-test-token"
+	db.Exec("update users set unlock_token = ?, unlock_token_index = ? where id = 3", models.EncryptedString(token), models.BlindIndexString(utils.BlindIndex(token)))
+
+	jsonData, _ := json.Marshal(map[string]interface{}{"email": user.Email, "token": token})
+	req := httptest.NewRequest("POST", "/api/v1/user/connect/3", bytes.NewReader(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req, -1)
+	assert.Nil(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	var result map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&result)
+	challenge, ok := result["result"].(map[string]interface{})
+	assert.True(t, ok)
+	assert.Equal(t, true, challenge["twoFactorRequired"])
+	assert.NotEmpty(t, challenge["challenge"])
+
+	for _, cookie := range resp.Cookies() {
+		assert.NotEqual(t, database.GetParam("JWT_COOKIE"), cookie.Name, "no session before the second factor")
+	}
+}
+
+func TestWhereUnlockToken(t *testing.T) {
+	_, db, err := test.SetupTestApp()
+	if err != nil {
+		t.Fatalf("Failed to setup test app: %v", err)
+	}
+
+	// no blind index without its key
+	delete(database.SystemParams, "encryption_index_key")
+
+	var user models.User
+	assert.Nil(t, services.UserForId(db, 3, &user))
+	t.Cleanup(func() {
+		db.Exec("update users set unlock_token = ?, unlock_token_index = ? where id = 3", user.UnlockToken, user.UnlockTokenIndex)
+	})
+
+	token := "unlock-token-test"
+	saved := user
+	saved.UnlockToken = models.EncryptedString(token)
+	assert.Nil(t, db.Save(&saved).Error)
+
+	var found models.User
+	assert.Nil(t, models.WhereUnlockToken(db, token).First(&found, "id = 3").Error)
+	assert.ErrorIs(t, models.WhereUnlockToken(db, "wrong").First(&found, "id = 3").Error, gorm.ErrRecordNotFound)
+
+	// a user without a token
+	saved.UnlockToken = ""
+	assert.Nil(t, db.Save(&saved).Error)
+	assert.ErrorIs(t, models.WhereUnlockToken(db, "wrong").First(&found, "id = 3").Error, gorm.ErrRecordNotFound)
+	assert.ErrorIs(t, models.WhereUnlockToken(db, "").First(&found, "id = 3").Error, gorm.ErrRecordNotFound)
+}
+
+func TestDataItems(t *testing.T) {
+	app, db, err := test.SetupTestApp()
+	if err != nil {
+		t.Fatalf("Failed to setup test app: %v", err)
+	}
+	UserApiRoutes(app.Group("/api/v1").Group("user"), db)
+	t.Cleanup(func() {
+		db.Where("user_id = 3 and kind = ?", "place of birth").Delete(&models.DataItem{})
+	})
+
+	signedRequest := func(method, url string, data map[string]interface{}) (int, map[string]interface{}) {
+		test.SignMap(data)
+		jsonData, _ := json.Marshal(data)
+		req := httptest.NewRequest(method, url, bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req, -1)
+		assert.Nil(t, err)
+
+		var result map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+
+	t.Run("The owner gets the value of a saved item", func(t *testing.T) {
+		status, result := signedRequest("POST", "/api/v1/user/3/data_items", map[string]interface{}{
+			"userId": 3,
+			"kind":   "place of birth",
+			"value":  "Lisbon",
+		})
+		assert.Equal(t, 200, status)
+		item, _ := result["result"].(map[string]interface{})
+		assert.Equal(t, "Lisbon", item["value"])
+	})
+
+	t.Run("The owner lists their items unmasked", func(t *testing.T) {
+		status, result := signedRequest("GET", "/api/v1/user/3/data_items", map[string]interface{}{})
+		assert.Equal(t, 200, status)
+
+		items, _ := result["result"].([]interface{})
+		values := []interface{}{}
+		for _, item := range items {
+			values = append(values, item.(map[string]interface{})["value"])
+		}
+		assert.Contains(t, values, "Lisbon")
+	})
+
+	t.Run("The items of another user are not listed", func(t *testing.T) {
+		status, _ := signedRequest("GET", "/api/v1/user/4/data_items", map[string]interface{}{})
+		assert.Equal(t, 503, status)
+	})
+
+	// elsewhere the value stays masked
+	var item models.DataItem
+	db.Last(&item, "user_id = 3 and kind = ?", "place of birth")
+	masked, _ := json.Marshal(item)
+	assert.NotContains(t, string(masked), "Lisbon")
+}
@@ -3,6 +3,7 @@ package models
 import (
 	"fmt"
 	"myproject/api/utils"
+	"strconv"
 	"strings"
 	"time"
 
@@ -23,24 +24,28 @@ type Business struct {
 	UserId     uint      `gorm:"type:BIGINT" json:"userId" form:"userId"` // user id that created the business
 	ProviderId uint      `gorm:"-:migration;->" json:"providerId"`
 
-	Locale     string `gorm:"type:VARCHAR" json:"locale"`   // language of the business  e.g. es_CO
-	Currency   string `gorm:"type:VARCHAR" json:"currency"` // currency of the business  e.g. COP
-	Name       string `gorm:"type:VARCHAR" json:"name"`
-	Address    string `gorm:"type:VARCHAR" json:"address"`
-	City       string `gorm:"type:VARCHAR" json:"city"`
-	Province   string `gorm:"type:VARCHAR" json:"province"`
-	Zipcode    string `gorm:"type:VARCHAR" json:"zipcode"` // zipcode/postcode of the business - not used in Colombia
-	Country    string `gorm:"type:VARCHAR" json:"country"`
-	Phone      string `gorm:"type:VARCHAR" json:"phone"`      // phone number of the business with ISO prefix e.g. +57123456789
-	Website    string `gorm:"type:VARCHAR" json:"website"`    // URL of the website of the business e.g. https://www.example.com
-	Latlng     string `gorm:"type:VARCHAR" json:"latlng"`     // latitude longitude separated by comma e.g. 4.8057849, -75.6830817
-	Email      string `gorm:"type:VARCHAR" json:"email"`      // email of the business e.g. mybiz@gmail.com
-	Type       string `gorm:"type:VARCHAR" json:"type"`       // type of the business e.g. restaurant, bar, cafe, etc.
-	Photo      string `gorm:"type:VARCHAR" json:"photo"`      // url of the business logo photo (set when uploading a photo)
-	Background string `gorm:"type:VARCHAR" json:"background"` // url of the business background photo (set when uploading a background)
-	Banner     string `gorm:"type:VARCHAR" json:"banner"`     // url of the business mktplace banner photo (set when uploading a background)
-	Nid        string `gorm:"type:VARCHAR" json:"nid"`        // national ID, NIT, registration number etc.
-	TaxId      string `gorm:"type:VARCHAR" json:"taxId"`      // tax ID, TAX, registration number etc.
+	Locale     string           `gorm:"type:VARCHAR" json:"locale"`   // language of the business  e.g. es_CO
+	Currency   string           `gorm:"type:VARCHAR" json:"currency"` // currency of the business  e.g. COP
+	Name       string           `gorm:"type:VARCHAR" json:"name"`
+	Address    string           `gorm:"type:VARCHAR" json:"address"`
+	City       string           `gorm:"type:VARCHAR" json:"city"`
+	Province   string           `gorm:"type:VARCHAR" json:"province"`
+	Zipcode    string           `gorm:"type:VARCHAR" json:"zipcode"` // zipcode/postcode of the business - not used in Colombia
+	Country    string           `gorm:"type:VARCHAR" json:"country"`
+	Phone      string           `gorm:"type:VARCHAR" json:"phone"`      // phone number of the business with ISO prefix e.g. +57123456789
+	Website    string           `gorm:"type:VARCHAR" json:"website"`    // URL of the website of the business e.g. https://www.example.com
+	Latlng     string           `gorm:"type:VARCHAR" json:"latlng"`     // latitude longitude separated by comma e.g. 4.8057849, -75.6830817
+	Email      string           `gorm:"type:VARCHAR" json:"email"`      // email of the business e.g. mybiz@gmail.com
+	Type       string           `gorm:"type:VARCHAR" json:"type"`       // type of the business e.g. restaurant, bar, cafe, etc.
+	Photo      string           `gorm:"type:VARCHAR" json:"photo"`      // url of the business logo photo (set when uploading a photo)
+	Background string           `gorm:"type:VARCHAR" json:"background"` // url of the business background photo (set when uploading a background)
+	Banner     string           `gorm:"type:VARCHAR" json:"banner"`     // url of the business mktplace banner photo (set when uploading a background)
+	Nid        EncryptedString  `gorm:"type:VARCHAR" json:"nid"`        // national ID, NIT, registration number etc.
+	TaxId      EncryptedString  `gorm:"type:VARCHAR" json:"taxId"`      // tax ID, TAX, registration number etc.
+	NidIndex   BlindIndexString `gorm:"type:VARCHAR;index" json:"-"`    // blind index of the normalised national ID, to find duplicates
+	TaxIdIndex BlindIndexString `gorm:"type:VARCHAR;index" json:"-"`    // blind index of the normalised tax ID
+
+	CountryCode string `gorm:"type:CHAR(2)" json:"countryCode"` // ISO 3166-1 alpha-2 of the country, set on save
 
 	Facebook  string `gorm:"type:VARCHAR" json:"facebook"`  // facebook page of the business e.g. https://www.facebook.com/mybiz
 	Instagram string `gorm:"type:VARCHAR" json:"instagram"` // instagram page of the business e.g. https://www.instagram.com/mybiz
@@ -53,10 +58,13 @@ type Business struct {
 	Signal   string `gorm:"type:VARCHAR" json:"signal"  form:"signal"`
 	Session  string `gorm:"type:VARCHAR" json:"session"  form:"session"`
 
-	Rating      string `gorm:"type:VARCHAR" json:"rating"`      // rating of the business - not used
+	Rating      string `gorm:"type:VARCHAR" json:"rating"`      // rating of the business - not used, see RatingAverage
 	Summary     string `gorm:"type:VARCHAR" json:"summary"`     // short summary of the business (max 50 chars)
 	Description string `gorm:"type:VARCHAR" json:"description"` // longer description of the business
 
+	RatingAverage float64 `gorm:"->;type:NUMERIC(3,2);default:0" json:"ratingAverage"` // average stars of the published reviews, cached by UpdateRatings
+	RatingCount   uint    `gorm:"->;default:0" json:"ratingCount"`                     // number of published reviews
+
 	// use uuid for dynamic sub domains
 	Uuid    string `gorm:"type:VARCHAR" json:"uuid"` // chosen by the user as a unique subdomain on mydomain.com / defaults to a generated UUID4
 	Enabled bool   `json:"enabled"`                  //  only enabled businesses are visible
@@ -68,15 +76,17 @@ type Business struct {
 
 	UpdatedBy uint `gorm:"type:BIGINT" ` // user id that last updated the object
 
-	Bank          string `gorm:"type:VARCHAR" json:"bank"`          // bank name
-	SortCode      string `gorm:"type:VARCHAR" json:"sortCode"`      // bank sort code
-	AccountName   string `gorm:"type:VARCHAR" json:"accountName"`   // bank account name
-	AccountNumber string `gorm:"type:VARCHAR" json:"accountNumber"` // bank account number
-	PaymentTerms  string `gorm:"type:VARCHAR" json:"paymentTerms"`  // payment terms for the business
+	Bank          string          `gorm:"type:VARCHAR" json:"bank"`          // bank name
+	SortCode      EncryptedString `gorm:"type:VARCHAR" json:"sortCode"`      // bank sort code
+	AccountName   string          `gorm:"type:VARCHAR" json:"accountName"`   // bank account name
+	AccountNumber EncryptedString `gorm:"type:VARCHAR" json:"accountNumber"` // bank account number
+	PaymentTerms  string          `gorm:"type:VARCHAR" json:"paymentTerms"`  // payment terms for the business
 
 	//Functions string         `gorm:"type:VARCHAR;default:'[\"Equipment\",\"Contacts\"]'" json:"functions" form:"functions"` //  what features are enabled for the business
 	Flags pq.StringArray `gorm:"type:varchar[]" json:"flags"` // flags to control the business, e.g. open, closed, hidden, featured
 
+	RequireTwoFactor bool `json:"requireTwoFactor"` // the team members log in with a TOTP code, set by the owners
+
 	Roles []BusinessRole //`gorm:"many2many:business_roles;"`
 
 	Configs    []Config
@@ -91,6 +101,7 @@ type Business struct {
 
 	CreatedAt time.Time
 	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"deletedAt"` // soft deleted, purged after the retention window
 }
 
 /*
@@ -128,7 +139,10 @@ type BusinessRole struct {
 type BusinessTag struct {
 	ID         uint `gorm:"primaryKey;type:BIGSERIAL" json:"id"`
 	BusinessId uint `gorm:"type:BIGINT" json:"businessId" form:"businessId"`
-	TagId      uint `gorm:"type:BIGINT" json:"tagId" form:"tagId"` // tags.id
+	CustomerId uint `gorm:"type:BIGINT" json:"customerId" form:"customerId"` // customer business ID tagged by the business or 0
+	TagId      uint `gorm:"type:BIGINT" json:"tagId" form:"tagId"`           // tags.id
+
+	Tag *Tag `gorm:"foreignKey:TagId;references:ID" json:",omitempty"`
 }
 
 type BusinessCustomer struct {
@@ -137,7 +151,17 @@ type BusinessCustomer struct {
 	BusinessId uint `gorm:"type:BIGINT" json:"businessId" form:"businessId"` // provider business ID
 	CustomerId uint `gorm:"type:BIGINT" json:"customerId" form:"customerId"` // customer business ID
 
-	Customer *Business `gorm:"foreignKey:CustomerId;references:ID" json:",omitempty"`
+	Status           string     `gorm:"type:VARCHAR;default:'active'" json:"status" form:"status"`   // prospect, active, churned
+	AccountManagerId uint       `gorm:"type:BIGINT" json:"accountManagerId" form:"accountManagerId"` // users.id of the team member managing the account
+	ContractStart    *time.Time `json:"contractStart"`
+	ContractEnd      *time.Time `json:"contractEnd"`
+
+	Customer       *Business `gorm:"foreignKey:CustomerId;references:ID" json:",omitempty"`
+	AccountManager *User     `gorm:"foreignKey:AccountManagerId;references:ID" json:",omitempty"`
+	Tags           []Tag     `gorm:"-" json:"tags,omitempty"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
 }
 
 type SubContractor struct {
@@ -152,6 +176,9 @@ type SubContractor struct {
 
 	Contractor *Business `gorm:"foreignKey:ContractorId;references:ID" json:",omitempty"`
 	User       *User     `gorm:"foreignKey:TechnicianId;references:ID" json:",omitempty"`
+
+	Assignments []SubContractorAssignment `json:"assignments,omitempty"`
+	Rates       []SubContractorRate       `json:"rates,omitempty"`
 }
 
 // businesses can have 1 or more categories matching equipment categories or trades
@@ -195,6 +222,10 @@ func MigrateBusiness(db *gorm.DB) error {
 		return err
 	}
 
+	// a missing blind index is NULL so it never matches
+	db.Exec("update businesses set nid_index = null where nid_index = ''")
+	db.Exec("update businesses set tax_id_index = null where tax_id_index = ''")
+
 	if err := db.AutoMigrate(&BusinessRole{}); err != nil {
 		return err
 	}
@@ -240,21 +271,75 @@ func MigrateBusiness(db *gorm.DB) error {
 	return nil
 }
 
-func (d *Business) BeforeDelete(tx *gorm.DB) error {
+// the data of a business hard deleted by PurgeBusinessData, children before their parents.
+// Where defaults to business_id = ?
+var businessDataTables = []struct {
+	Table string
+	Where string
+}{
+	{"service_requests", ""},
+	{"service_records", ""},
+	{"qrcodes", ""},
+	{"equipment", ""},
+	{"subscriptions", ""},
+	{"business_roles", ""},
+	{"configs", ""},
+	{"business_categories", ""},
+	{"business_tags", "business_id = ? or customer_id = ?"},
+	{"customer_notes", "business_id = ? or customer_id = ?"},
+	{"business_customers", "business_id = ? or customer_id = ?"},
+	{"business_links", ""},
+	{"sub_contractor_rates", ""},
+	{"sub_contractor_assignments", ""},
+	{"sub_contractors", "business_id = ? or contractor_id = ?"},
+	{"review_flags", "review_id in (select id from reviews where business_id = ?)"},
+	{"reviews", ""},
+	{"opening_hours", ""},
+	{"opening_exceptions", ""},
+	{"service_areas", ""},
+	{"team_invites", ""},
+	{"subdomain_redirects", ""},
+	{"contacts", ""},
+	{"assets", ""},
+	{"bin_infos", ""},
+	{"bins", ""},
+	{"parts", ""},
+	{"consumables", ""},
+	{"location_areas", ""},
+	{"locations", ""},
+	{"balances", ""},
+	{"data_caches", ""},
+}
+
+// PurgeBusinessData hard delete a business and all its data, tables that do not exist are skipped.
+// Run it in a transaction, the first error aborts the purge.
+func PurgeBusinessData(tx *gorm.DB, businessId uint) error {
+
+	for _, t := range businessDataTables {
+		var exists bool
+		if err := tx.Raw("select to_regclass(cast(? as text)) is not null", t.Table).Scan(&exists).Error; err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
 
-	tx.Exec("delete from service_requests where business_id = ?", d.ID)
-	tx.Exec("delete from service_records where business_id = ?", d.ID)
-	tx.Exec("delete from qrcodes where business_id = ?", d.ID)
-	tx.Exec("delete from equipment where business_id = ?", d.ID)
-	tx.Exec("delete from subscriptions where business_id = ?", d.ID)
-	tx.Exec("delete from business_roles where business_id = ?", d.ID)
-	tx.Exec("delete from configs where business_id = ?", d.ID)
-	tx.Exec("delete from business_categories where business_id = ?", d.ID)
-	tx.Exec("delete from business_customers where business_id = ?", d.ID)
-	tx.Exec("delete from business_links where business_id = ?", d.ID)
-	tx.Exec("delete from locations where business_id = ?", d.ID)
+		where := t.Where
+		if where == "" {
+			where = "business_id = ?"
+		}
 
-	return nil
+		args := []interface{}{}
+		for i := 0; i < strings.Count(where, "?"); i++ {
+			args = append(args, businessId)
+		}
+
+		if err := tx.Exec(fmt.Sprintf("delete from %s where %s", t.Table, where), args...).Error; err != nil {
+			return fmt.Errorf("%s: %w", t.Table, err)
+		}
+	}
+
+	return tx.Unscoped().Delete(&Business{}, businessId).Error
 }
 
 // runs before create and save
@@ -262,61 +347,70 @@ func (b *Business) BeforeSave(tx *gorm.DB) error {
 	// capitalize the name
 	b.Name = strings.TrimSpace(strings.Title(b.Name))
 
-	// remove accents from city/province
-	// normalise the business location data
-	b.City = utils.NormalizeAddress(b.City)
-	b.Province = utils.NormalizeAddress(b.Province)
-	//b.Country = utils.NormalizeAddress(b.Country)  // do not do this as it changes USA to Usa
+	// the handlers validate the address, only its country code is derived here
+	b.CountryCode = utils.CountryCode(b.Country)
 
-	if b.Country == "United States" || b.Country == "Usa" {
-		b.Country = "USA"
+	// the currency and locale of the country unless the business chose its own
+	if rules, ok := utils.CountryRulesFor(b.CountryCode); ok {
+		if b.Currency == "" {
+			b.Currency = rules.Currency
+		}
+		if b.Locale == "" {
+			b.Locale = rules.Locale
+		}
 	}
 
-	if b.Country == "United Kingdom" {
-		b.Country = "UK"
-	}
+	b.Phone = utils.FixupPhone(b.Phone)
 
-	if b.Country == "USA" {
-		b.Currency = "USD"
-		b.Locale = "en_US"
-	}
+	b.NidIndex = BlindIndexString(utils.BlindIndex(utils.NormalizeTaxId(string(b.Nid))))
+	b.TaxIdIndex = BlindIndexString(utils.BlindIndex(utils.NormalizeTaxId(string(b.TaxId))))
+	return nil
+}
 
-	if b.Country == "UK" {
-		b.Currency = "GBP"
-		b.Locale = "en_GB"
+// SensitiveFields the plaintext of the encrypted fields by their JSON name, for callers allowed to see them
+func (business Business) SensitiveFields() map[string]string {
+	return map[string]string{
+		"nid":           string(business.Nid),
+		"taxId":         string(business.TaxId),
+		"sortCode":      string(business.SortCode),
+		"accountNumber": string(business.AccountNumber),
 	}
-
-	b.Phone = utils.FixupPhone(b.Phone)
-	return nil
 }
 
+// ConfigString the value of a config item of the business, resolved against the registry
 func (business Business) ConfigString(name string, defaultValue string) string {
-	if business.Configs == nil || len(business.Configs) == 0 {
-		return defaultValue
-	}
-	for _, cfg := range business.Configs {
-		if cfg.Name == name {
-			return cfg.Value
-		}
-	}
-	return defaultValue
+	return ConfigValue(business.businessConfigs(), name, defaultValue)
 }
 
+// ConfigBool the value of a bool config item of the business, resolved against the registry
 func (business Business) ConfigBool(name string, defaultValue bool) bool {
-	if business.Configs == nil || len(business.Configs) == 0 {
+	value, err := strconv.ParseBool(business.ConfigString(name, strconv.FormatBool(defaultValue)))
+	if err != nil {
 		return defaultValue
 	}
+	return value
+}
+
+// businessConfigs the configs set for the whole business, not one of its locations
+func (business Business) businessConfigs() []Config {
+	var rows []Config
 	for _, cfg := range business.Configs {
-		if cfg.Name == name {
-			return cfg.Value == "true"
+		if cfg.LocationId == 0 {
+			rows = append(rows, cfg)
 		}
 	}
-	return defaultValue
+	return rows
 }
 
 func (b *Business) AfterSave(tx *gorm.DB) error {
 	// capitalize the name
 	tx.Exec("update businesses set name = initcap(name) where id = ?", b.ID)
+
+	// keep the position used by the spatial queries in step with the latlng
+	if b.Latlng != "" {
+		plat, plng := utils.ExtractLatLng(b.Latlng)
+		tx.Exec("update businesses set location = ST_SetSRID(ST_MakePoint(?, ?), 4326)::geography where id = ?", plng, plat, b.ID)
+	}
 	return nil
 }
 
@@ -392,6 +486,8 @@ func (item *BusinessCustomer) AfterUpdate(tx *gorm.DB) error {
 
 func (item *BusinessCustomer) AfterDelete(tx *gorm.DB) error {
 	tx.Exec("delete from data_caches where business_id = ? and (kind = 'customers' or kind = 'ui-customers')", item.BusinessId)
+	tx.Exec("delete from business_tags where business_id = ? and customer_id = ?", item.BusinessId, item.CustomerId)
+	tx.Exec("delete from customer_notes where business_id = ? and customer_id = ?", item.BusinessId, item.CustomerId)
 	return nil
 }
 
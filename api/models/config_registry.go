@@ -0,0 +1,160 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// the scopes a config item can be set at, from the most specific
+const (
+	ConfigScopeUser     = "user"
+	ConfigScopeLocation = "location"
+	ConfigScopeBusiness = "business"
+	ConfigScopeSite     = "site"
+)
+
+// the kinds of config value
+var ConfigKinds = []string{"string", "int", "float", "bool", "json"}
+
+// ConfigKey describes a known config item
+type ConfigKey struct {
+	Name        string   `json:"name"`
+	Kind        string   `json:"kind"`    // string, int, float, bool or json
+	Default     string   `json:"default"` // string representation of the default value
+	Allowed     []string `json:"allowed"` // the allowed values, any value if empty
+	Scopes      []string `json:"scopes"`  // where the item can be set
+	Description string   `json:"description"`
+}
+
+// ConfigKeys the registry of known config items by name
+var ConfigKeys = map[string]ConfigKey{}
+
+func init() {
+	RegisterConfigKey(ConfigKey{Name: "accept_reviews", Kind: "bool", Default: "true",
+		Scopes: []string{ConfigScopeBusiness, ConfigScopeLocation}, Description: "Customers can review the business or location"})
+	RegisterConfigKey(ConfigKey{Name: "show_prices", Kind: "bool", Default: "true",
+		Scopes: []string{ConfigScopeBusiness, ConfigScopeLocation}, Description: "Show prices on the public profile"})
+	RegisterConfigKey(ConfigKey{Name: "invoice_due_days", Kind: "int", Default: "30",
+		Scopes: []string{ConfigScopeBusiness}, Description: "Days before an invoice is due"})
+	RegisterConfigKey(ConfigKey{Name: "service_radius_km", Kind: "float", Default: "10",
+		Scopes: []string{ConfigScopeBusiness, ConfigScopeLocation}, Description: "Distance served around a location"})
+	RegisterConfigKey(ConfigKey{Name: "notify_channel", Kind: "string", Default: "email", Allowed: []string{"email", "sms", "whatsapp", "telegram"},
+		Scopes: []string{ConfigScopeUser, ConfigScopeBusiness}, Description: "How notifications are sent"})
+	RegisterConfigKey(ConfigKey{Name: "theme", Kind: "string", Default: "auto", Allowed: []string{"auto", "light", "dark"},
+		Scopes: []string{ConfigScopeUser, ConfigScopeSite}, Description: "Colour theme of the UI"})
+	RegisterConfigKey(ConfigKey{Name: "booking_lead_minutes", Kind: "int", Default: "60",
+		Scopes: []string{ConfigScopeBusiness, ConfigScopeLocation}, Description: "Minimum notice for a booking"})
+	RegisterConfigKey(ConfigKey{Name: "visit_minutes", Kind: "int", Default: "30",
+		Scopes: []string{ConfigScopeBusiness, ConfigScopeLocation}, Description: "Time a technician spends on a visit"})
+	RegisterConfigKey(ConfigKey{Name: "geofence_metres", Kind: "int", Default: "100",
+		Scopes: []string{ConfigScopeBusiness, ConfigScopeLocation}, Description: "Radius around a location where technicians are checked in"})
+}
+
+// RegisterConfigKey add a config item to the registry, panics on an invalid definition
+func RegisterConfigKey(key ConfigKey) {
+	if !slices.Contains(ConfigKinds, key.Kind) {
+		panic(fmt.Sprintf("config %s: unknown kind %s", key.Name, key.Kind))
+	}
+	if _, err := key.Parse(key.Default); err != nil {
+		panic(fmt.Sprintf("config %s: invalid default: %s", key.Name, err))
+	}
+	ConfigKeys[key.Name] = key
+}
+
+// Parse convert the string representation of a value to its kind,
+// checking it against the allowed values
+func (key ConfigKey) Parse(value string) (interface{}, error) {
+	var parsed interface{}
+	var err error
+
+	switch key.Kind {
+	case "int":
+		parsed, err = strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	case "float":
+		parsed, err = strconv.ParseFloat(strings.TrimSpace(value), 64)
+	case "bool":
+		parsed, err = strconv.ParseBool(strings.TrimSpace(value))
+	case "json":
+		var v interface{}
+		err = json.Unmarshal([]byte(value), &v)
+		parsed = v
+	default:
+		parsed = value
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a %s", key.Name, key.Kind)
+	}
+
+	if len(key.Allowed) > 0 && !slices.Contains(key.Allowed, value) {
+		return nil, fmt.Errorf("%s must be one of %s", key.Name, strings.Join(key.Allowed, ", "))
+	}
+
+	return parsed, nil
+}
+
+// Scope the scope a config row is set at
+func (d *Config) Scope() string {
+	switch {
+	case d.LocationId > 0:
+		return ConfigScopeLocation
+	case d.BusinessId > 0:
+		return ConfigScopeBusiness
+	case d.SiteId > 0:
+		return ConfigScopeSite
+	}
+	return ConfigScopeUser
+}
+
+// ConfigValue resolve a config item over rows ordered from the least to the most specific:
+// the last row that parses wins, else the registry default, else defaultValue for unregistered items
+func ConfigValue(rows []Config, name string, defaultValue string) string {
+	key, registered := ConfigKeys[name]
+	value := defaultValue
+	if registered {
+		value = key.Default
+	}
+
+	for _, row := range rows {
+		if row.Name != name {
+			continue
+		}
+		// a value written before the key was registered may not parse, it is ignored
+		if registered {
+			if _, err := key.Parse(row.Value); err != nil {
+				continue
+			}
+		}
+		value = row.Value
+	}
+
+	return value
+}
+
+// ValidateConfig check a config item against the registry before it is written,
+// sets the kind and the canonical value
+func ValidateConfig(cfg *Config) error {
+	key, ok := ConfigKeys[cfg.Name]
+	if !ok {
+		return fmt.Errorf("unknown config %q", cfg.Name)
+	}
+
+	scope := cfg.Scope()
+	if !slices.Contains(key.Scopes, scope) {
+		return fmt.Errorf("%s cannot be set for a %s", key.Name, scope)
+	}
+
+	parsed, err := key.Parse(cfg.Value)
+	if err != nil {
+		return err
+	}
+
+	cfg.Kind = key.Kind
+	if key.Kind != "string" && key.Kind != "json" {
+		cfg.Value = fmt.Sprint(parsed)
+	}
+
+	return nil
+}
@@ -46,16 +46,6 @@ func MigrateContact(db *gorm.DB) error {
 // runs before create and save
 func (b *Contact) BeforeSave(tx *gorm.DB) error {
 
-	// remove accents from city/province
-	// normalise the business location data
-	b.City = utils.NormalizeAddress(b.City)
-	b.Province = utils.NormalizeAddress(b.Province)
-	//b.Country = utils.NormalizeAddress(b.Country)  // do not do this as it changes USA to Usa
-
-	if b.Country == "United States" {
-		b.Country = "USA"
-	}
-
 	b.Phone = utils.FixupPhone(b.Phone)
 	return nil
 }
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// work at a customer location or on a piece of equipment assigned to a sub contractor
+type SubContractorAssignment struct {
+	ID              uint `gorm:"primaryKey;type:BIGSERIAL" json:"id"`
+	BusinessId      uint `gorm:"type:BIGINT;index:sub_contractor_assignment_data" json:"businessId" form:"businessId"`           // service provider business ID
+	SubContractorId uint `gorm:"type:BIGINT;index:sub_contractor_assignment_data" json:"subContractorId" form:"subContractorId"` // sub_contractors.id
+	CustomerId      uint `gorm:"type:BIGINT" json:"customerId" form:"customerId"`                                                // customer business ID owning the location
+	LocationId      uint `gorm:"type:BIGINT" json:"locationId" form:"locationId"`                                                // locations.id the contractor can access
+	EquipmentId     uint `gorm:"type:BIGINT" json:"equipmentId" form:"equipmentId"`                                              // equipment.id or 0 for the whole location
+
+	Description string     `gorm:"type:VARCHAR" json:"description" form:"description"`        // the work to be done
+	Status      string     `gorm:"type:VARCHAR;default:'active'" json:"status" form:"status"` // active, completed, cancelled
+	Service     string     `gorm:"type:VARCHAR" json:"service" form:"service"`                // service performed, selects the contractor rate
+	StartsAt    *time.Time `json:"startsAt"`
+	EndsAt      *time.Time `json:"endsAt"`
+
+	// the work is costed with the rate of the contractor when it is completed, these are the invoice lines
+	CompletedAt *time.Time `json:"completedAt"`
+	Quantity    float64    `gorm:"type:DECIMAL(10,2)" json:"quantity" form:"quantity"` // units of the rate, e.g. hours
+	RateId      uint       `gorm:"type:BIGINT" json:"rateId"`                          // sub_contractor_rates.id that applied or 0 when there was none
+	Unit        string     `gorm:"type:VARCHAR" json:"unit"`
+	Rate        float64    `gorm:"type:DECIMAL(10,2)" json:"rate"`
+	Currency    string     `gorm:"type:VARCHAR" json:"currency"`
+	Total       float64    `gorm:"type:DECIMAL(10,2)" json:"total"`
+
+	Location *Location `gorm:"foreignKey:LocationId;references:ID" json:",omitempty"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// rate agreed with a sub contractor, used to cost their work on invoices
+type SubContractorRate struct {
+	ID              uint `gorm:"primaryKey;type:BIGSERIAL" json:"id"`
+	BusinessId      uint `gorm:"type:BIGINT;index:sub_contractor_rate_data" json:"businessId" form:"businessId"`           // service provider business ID
+	SubContractorId uint `gorm:"type:BIGINT;index:sub_contractor_rate_data" json:"subContractorId" form:"subContractorId"` // sub_contractors.id
+
+	Service  string  `gorm:"type:VARCHAR" json:"service" form:"service"`          // service the rate applies to or empty for the default rate
+	Unit     string  `gorm:"type:VARCHAR;default:'hour'" json:"unit" form:"unit"` // hour, day, visit or item
+	Rate     float64 `gorm:"type:DECIMAL(10,2)" json:"rate" form:"rate"`          // price per unit
+	Currency string  `gorm:"type:VARCHAR" json:"currency" form:"currency"`        // defaults to the currency of the business
+
+	ValidFrom *time.Time `json:"validFrom"` // rate applies from this date, nil for always
+	ValidTo   *time.Time `json:"validTo"`   // rate applies until this date, nil for always
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// AppliesAt is true if the rate is valid at the given time
+func (r *SubContractorRate) AppliesAt(at time.Time) bool {
+	if r.ValidFrom != nil && at.Before(*r.ValidFrom) {
+		return false
+	}
+	if r.ValidTo != nil && at.After(*r.ValidTo) {
+		return false
+	}
+	return true
+}
+
+func (d *SubContractor) BeforeDelete(tx *gorm.DB) error {
+
+	if err := tx.Exec("delete from sub_contractor_assignments where sub_contractor_id = ?", d.ID).Error; err != nil {
+		return err
+	}
+
+	return tx.Exec("delete from sub_contractor_rates where sub_contractor_id = ?", d.ID).Error
+}
+
+func MigrateSubContractor(db *gorm.DB) error {
+
+	if err := db.AutoMigrate(&SubContractorAssignment{}); err != nil {
+		return err
+	}
+
+	if err := db.AutoMigrate(&SubContractorRate{}); err != nil {
+		return err
+	}
+
+	db.Exec("CREATE UNIQUE INDEX CONCURRENTLY IF NOT EXISTS sub_contractor_data on sub_contractors (business_id, technician_id, contractor_id)")
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var CustomerStatuses = []string{"prospect", "active", "churned"}
+
+// tags a business can put on its customers, business ID 0 for the pre-determined tags
+type Tag struct {
+	ID         uint   `gorm:"primaryKey;type:BIGSERIAL" json:"id"`
+	BusinessId uint   `gorm:"type:BIGINT" json:"businessId" form:"businessId"`
+	Name       string `gorm:"type:VARCHAR" json:"name" form:"name"`
+	Color      string `gorm:"type:VARCHAR" json:"color" form:"color"` // hex colour e.g. #ff0000
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// note written by a team member about a customer of the business
+type CustomerNote struct {
+	ID         uint   `gorm:"primaryKey;type:BIGSERIAL" json:"id"`
+	BusinessId uint   `gorm:"type:BIGINT;index:customer_note_data" json:"businessId" form:"businessId"` // provider business ID
+	CustomerId uint   `gorm:"type:BIGINT;index:customer_note_data" json:"customerId" form:"customerId"` // customer business ID
+	UserId     uint   `gorm:"type:BIGINT" json:"userId"`                                                // users.id of the author
+	Note       string `gorm:"type:VARCHAR" json:"note" form:"note"`
+
+	User *User `gorm:"foreignKey:UserId;references:ID" json:",omitempty"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func MigrateCustomer(db *gorm.DB) error {
+
+	if err := db.AutoMigrate(&Tag{}); err != nil {
+		return err
+	}
+
+	if err := db.AutoMigrate(&BusinessTag{}); err != nil {
+		return err
+	}
+
+	if err := db.AutoMigrate(&CustomerNote{}); err != nil {
+		return err
+	}
+
+	db.Exec("CREATE UNIQUE INDEX CONCURRENTLY IF NOT EXISTS tag_name on tags (business_id, name)")
+	db.Exec("CREATE UNIQUE INDEX CONCURRENTLY IF NOT EXISTS business_tag_data on business_tags (business_id, customer_id, tag_id)")
+
+	return nil
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// who deleted, restored or purged what
+type DeletionAudit struct {
+	ID         uint   `gorm:"primaryKey;type:BIGSERIAL" json:"id"`
+	Model      string `gorm:"type:VARCHAR" json:"model"` // business, location, part, consumable, bin
+	ObjectId   uint   `gorm:"type:BIGINT" json:"objectId"`
+	BusinessId uint   `gorm:"type:BIGINT;index:deletion_audit_business" json:"businessId"`
+	UserId     uint   `gorm:"type:BIGINT" json:"userId"`  // users.id or 0 for the purge task
+	Action     string `gorm:"type:VARCHAR" json:"action"` // delete, restore, purge
+	Error      string `gorm:"type:VARCHAR" json:"error"`  // why a purge failed
+	Count      int64  `json:"count"`                      // rows affected by a bulk purge
+
+	CreatedAt time.Time
+}
+
+func MigrateDeletionAudit(db *gorm.DB) error {
+
+	return db.AutoMigrate(&DeletionAudit{})
+}
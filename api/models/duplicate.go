@@ -0,0 +1,60 @@
+package models
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+var DuplicateStatuses = []string{"open", "dismissed", "merged"}
+
+// a pair of businesses that may be the same real business, BusinessId is always the lower ID
+type DuplicateCandidate struct {
+	ID          uint           `gorm:"primaryKey;type:BIGSERIAL" json:"id"`
+	BusinessId  uint           `gorm:"type:BIGINT" json:"businessId"`
+	DuplicateId uint           `gorm:"type:BIGINT" json:"duplicateId"`
+	Score       int            `json:"score"`                                     // sum of the weights of the matching fields
+	Reasons     pq.StringArray `gorm:"type:varchar[]" json:"reasons"`             // matching fields e.g. taxId, phone, name, nearby
+	Distance    float64        `json:"distance"`                                  // metres between the businesses or -1 if unknown
+	Status      string         `gorm:"type:VARCHAR;default:'open'" json:"status"` // open, dismissed, merged
+
+	Business  *Business `gorm:"foreignKey:BusinessId;references:ID" json:",omitempty"`
+	Duplicate *Business `gorm:"foreignKey:DuplicateId;references:ID" json:",omitempty"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// undo record of a business merged into another
+type BusinessMerge struct {
+	ID       uint `gorm:"primaryKey;type:BIGSERIAL" json:"id"`
+	TargetId uint `gorm:"type:BIGINT;index:business_merge_data" json:"targetId"` // business kept
+	SourceId uint `gorm:"type:BIGINT;index:business_merge_data" json:"sourceId"` // business merged into the target
+	MergedBy uint `gorm:"type:BIGINT" json:"mergedBy"`                           // users.id of the admin
+
+	Moved         datatypes.JSON `gorm:"type:jsonb" json:"moved"` // IDs re-pointed to the target keyed by table.column
+	SourceEnabled bool           `json:"sourceEnabled"`           // state of the source before the merge
+	SourceFlags   pq.StringArray `gorm:"type:varchar[]" json:"sourceFlags"`
+
+	UndoneAt *time.Time `json:"undoneAt"`
+	UndoneBy uint       `gorm:"type:BIGINT" json:"undoneBy"`
+
+	CreatedAt time.Time
+}
+
+func MigrateDuplicate(db *gorm.DB) error {
+
+	if err := db.AutoMigrate(&DuplicateCandidate{}); err != nil {
+		return err
+	}
+
+	if err := db.AutoMigrate(&BusinessMerge{}); err != nil {
+		return err
+	}
+
+	db.Exec("CREATE UNIQUE INDEX CONCURRENTLY IF NOT EXISTS duplicate_candidate_pair on duplicate_candidates (business_id, duplicate_id)")
+
+	return nil
+}
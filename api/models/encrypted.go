@@ -0,0 +1,76 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+
+	"myproject/api/utils"
+)
+
+// EncryptedString a sensitive string column, encrypted at rest with utils.EncryptField
+// and masked in JSON. Use string(value) where the plaintext is needed, e.g. for callers allowed to see it.
+type EncryptedString string
+
+// Value encrypt the value when it is written
+func (s EncryptedString) Value() (driver.Value, error) {
+	return utils.EncryptField(string(s))
+}
+
+// Scan decrypt the value when it is read
+func (s *EncryptedString) Scan(value interface{}) error {
+	var stored string
+	switch v := value.(type) {
+	case nil:
+	case string:
+		stored = v
+	case []byte:
+		stored = string(v)
+	default:
+		return fmt.Errorf("cannot scan %T into an EncryptedString", value)
+	}
+
+	plain, err := utils.DecryptField(stored)
+	if err != nil {
+		return err
+	}
+	*s = EncryptedString(plain)
+	return nil
+}
+
+// MarshalJSON mask all but the last characters
+func (s EncryptedString) MarshalJSON() ([]byte, error) {
+	return json.Marshal(utils.MaskValue(string(s)))
+}
+
+// String the plaintext, for templates
+func (s EncryptedString) String() string {
+	return string(s)
+}
+
+// BlindIndexString the blind index of an encrypted column, NULL when there is none
+// so a missing index never matches another missing one
+type BlindIndexString string
+
+// Value an empty index is written as NULL
+func (s BlindIndexString) Value() (driver.Value, error) {
+	if s == "" {
+		return nil, nil
+	}
+	return string(s), nil
+}
+
+// Scan read NULL as an empty index
+func (s *BlindIndexString) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*s = ""
+	case string:
+		*s = BlindIndexString(v)
+	case []byte:
+		*s = BlindIndexString(v)
+	default:
+		return fmt.Errorf("cannot scan %T into a BlindIndexString", value)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ExportStatuses = []string{"pending", "running", "ready", "failed"}
+
+// ExportTaskName is the name of the task that builds a business export
+const ExportTaskName = "Export business data"
+
+// ExportFileTaskName is the name of the task that deletes an expired export file
+const ExportFileTaskName = "Delete business export file"
+
+// a ZIP bundle of the data of a business requested by its owner
+type BusinessExport struct {
+	ID         uint   `gorm:"primaryKey;type:BIGSERIAL" json:"id"`
+	BusinessId uint   `gorm:"type:BIGINT;index:business_export_business" json:"businessId"`
+	UserId     uint   `gorm:"type:BIGINT" json:"userId"`                    // users.id that requested the export
+	Status     string `gorm:"type:VARCHAR;default:'pending'" json:"status"` // pending, running, ready, failed
+	File       string `gorm:"type:VARCHAR" json:"-"`                        // path of the ZIP file on disk
+	Size       int64  `json:"size"`                                         // bytes
+	Error      string `gorm:"type:VARCHAR" json:"error"`
+
+	ExpiresAt *time.Time `json:"expiresAt"` // the download link and the file expire
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func MigrateExport(db *gorm.DB) error {
+
+	return db.AutoMigrate(&BusinessExport{})
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// GeocodeTaskName is the name of the task that fills the missing latlng of the locations of a business
+const GeocodeTaskName = "Geocode locations"
+
+// a geocoder answer cached by its normalised query so an address is only looked up once
+type GeocodeCache struct {
+	ID               uint    `gorm:"primaryKey;type:BIGSERIAL" json:"id"`
+	Kind             string  `gorm:"type:VARCHAR" json:"kind"`  // forward for an address, reverse for a latlng
+	Query            string  `gorm:"type:VARCHAR" json:"query"` // normalised address or lat,lng rounded to 5 decimals
+	Provider         string  `gorm:"type:VARCHAR" json:"provider"`
+	Found            bool    `json:"found"` // false when the provider had no result, retried after a while
+	Lat              float64 `json:"lat"`
+	Lng              float64 `json:"lng"`
+	FormattedAddress string  `gorm:"type:VARCHAR" json:"formattedAddress"`
+	Country          string  `gorm:"type:VARCHAR" json:"country"` // ISO 3166 alpha-2 code
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func MigrateGeocode(db *gorm.DB) error {
+
+	if err := db.AutoMigrate(&GeocodeCache{}); err != nil {
+		return err
+	}
+
+	db.Exec("CREATE UNIQUE INDEX CONCURRENTLY IF NOT EXISTS geocode_cache_query on geocode_caches (kind, query)")
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// the kinds of geofence events
+const (
+	GeofenceArrival   = "arrival"
+	GeofenceDeparture = "departure"
+)
+
+// the sources of timesheet entries
+const (
+	TimesheetGeofence = "geofence" // opened and closed by the geofence events
+	TimesheetManual   = "manual"   // corrected by a manager
+)
+
+// a position posted by the mobile app of a user
+type UserPosition struct {
+	ID       uint    `gorm:"primaryKey;type:BIGSERIAL" json:"id"`
+	UserId   uint    `gorm:"type:BIGINT;index:user_position_time" json:"userId"`
+	Lat      float64 `json:"lat"`
+	Lng      float64 `json:"lng"`
+	Accuracy float64 `json:"accuracy"` // metres, 0 when unknown
+
+	CreatedAt time.Time `gorm:"index:user_position_time"`
+}
+
+// a technician entering or leaving the geofence of a location
+type GeofenceEvent struct {
+	ID         uint      `gorm:"primaryKey;type:BIGSERIAL" json:"id"`
+	BusinessId uint      `gorm:"type:BIGINT;index:geofence_event_data" json:"businessId"` // the business the technician works for
+	UserId     uint      `gorm:"type:BIGINT;index:geofence_event_data" json:"userId"`
+	LocationId uint      `gorm:"type:BIGINT" json:"locationId"`
+	Kind       string    `gorm:"type:VARCHAR" json:"kind"` // arrival or departure
+	Lat        float64   `json:"lat"`
+	Lng        float64   `json:"lng"`
+	Distance   float64   `json:"distance"` // metres from the location
+	At         time.Time `json:"at"`
+
+	CreatedAt time.Time
+}
+
+// the time a technician spent at a location
+type TimesheetEntry struct {
+	ID         uint       `gorm:"primaryKey;type:BIGSERIAL" json:"id"`
+	BusinessId uint       `gorm:"type:BIGINT;index:timesheet_entry_data" json:"businessId" form:"businessId"`
+	UserId     uint       `gorm:"type:BIGINT;index:timesheet_entry_data" json:"userId" form:"userId"`
+	LocationId uint       `gorm:"type:BIGINT" json:"locationId" form:"locationId"`
+	CheckIn    time.Time  `json:"checkIn" form:"checkIn"`
+	CheckOut   *time.Time `json:"checkOut" form:"checkOut"` // nil while the technician is at the location
+	Minutes    int        `json:"minutes"`
+	Source     string     `gorm:"type:VARCHAR;default:'geofence'" json:"source"` // geofence or manual
+	Note       string     `gorm:"type:VARCHAR" json:"note" form:"note"`
+	UpdatedBy  uint       `gorm:"type:BIGINT" json:"updatedBy"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// BeforeSave keep the minutes in line with the check in and out
+func (t *TimesheetEntry) BeforeSave(tx *gorm.DB) error {
+	t.Minutes = 0
+	if t.CheckOut != nil && t.CheckOut.After(t.CheckIn) {
+		t.Minutes = int(t.CheckOut.Sub(t.CheckIn).Minutes())
+	}
+	return nil
+}
+
+func MigrateGeofence(db *gorm.DB) error {
+
+	if err := db.AutoMigrate(&UserPosition{}, &GeofenceEvent{}, &TimesheetEntry{}); err != nil {
+		return err
+	}
+
+	// at most one open entry per technician and location
+	db.Exec("CREATE UNIQUE INDEX CONCURRENTLY IF NOT EXISTS timesheet_entry_open on timesheet_entries (user_id, location_id) where check_out is null")
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package models
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+// the kinds of reference geography datasets
+const (
+	GeographyCountries = "countries" // GeoNames countryInfo.txt
+	GeographyStates    = "states"    // GeoNames admin1CodesASCII.txt
+	GeographyCities    = "cities"    // GeoNames <country>.txt, citiesNNN.txt or modifications-<date>.txt
+	GeographyDeletes   = "deletes"   // GeoNames deletes-<date>.txt
+)
+
+// a country of the reference geography
+type Country struct {
+	Code         string         `gorm:"type:CHAR(2);primaryKey" json:"code"` // ISO 3166-1 alpha-2
+	Iso3         string         `gorm:"type:CHAR(3)" json:"iso3"`
+	Numeric      string         `gorm:"type:CHAR(3)" json:"numeric"`
+	Name         string         `gorm:"type:VARCHAR" json:"name"`
+	NameAscii    string         `gorm:"type:VARCHAR" json:"nameAscii"`
+	Capital      string         `gorm:"type:VARCHAR" json:"capital"`
+	Continent    string         `gorm:"type:CHAR(2)" json:"continent"`
+	Currency     string         `gorm:"type:CHAR(3)" json:"currency"`
+	Phone        string         `gorm:"type:VARCHAR" json:"phone"`        // calling code without the +
+	PostalFormat string         `gorm:"type:VARCHAR" json:"postalFormat"` // e.g. #####-####
+	PostalRegex  string         `gorm:"type:VARCHAR" json:"postalRegex"`
+	Languages    pq.StringArray `gorm:"type:varchar[]" json:"languages"`
+	GeonameId    uint           `gorm:"type:BIGINT" json:"geonameId"`
+
+	UpdatedAt time.Time
+}
+
+// a state, province or department of a country
+type State struct {
+	ID          uint   `gorm:"primaryKey;type:BIGSERIAL" json:"id"`
+	CountryCode string `gorm:"type:CHAR(2)" json:"countryCode"`
+	Code        string `gorm:"type:VARCHAR(20)" json:"code"` // admin1 code within the country e.g. OH or 02
+	Name        string `gorm:"type:VARCHAR" json:"name"`
+	NameAscii   string `gorm:"type:VARCHAR" json:"nameAscii"`
+	GeonameId   uint   `gorm:"type:BIGINT" json:"geonameId"`
+
+	UpdatedAt time.Time
+}
+
+// a load of a reference geography dataset
+type GeographyLoad struct {
+	ID      uint   `gorm:"primaryKey;type:BIGSERIAL" json:"id"`
+	UserId  uint   `gorm:"type:BIGINT" json:"userId"` // 0 when loaded by the command
+	Kind    string `gorm:"type:VARCHAR" json:"kind"`
+	Source  string `gorm:"type:VARCHAR" json:"source"` // file name or URL
+	Country string `gorm:"type:CHAR(2)" json:"country"`
+	Created int    `json:"created"`
+	Updated int    `json:"updated"`
+	Skipped int    `json:"skipped"` // unchanged since the last load, or filtered out
+	Deleted int    `json:"deleted"`
+	Errors  int    `json:"errors"` // lines that could not be read
+	Error   string `gorm:"type:VARCHAR" json:"error"`
+
+	CreatedAt time.Time
+}
+
+func MigrateGeography(db *gorm.DB) error {
+
+	if err := db.AutoMigrate(&Country{}, &State{}, &GeographyLoad{}); err != nil {
+		return err
+	}
+
+	db.Exec("CREATE UNIQUE INDEX CONCURRENTLY IF NOT EXISTS state_code on states (country_code, code)")
+	db.Exec("CREATE UNIQUE INDEX CONCURRENTLY IF NOT EXISTS city_geoname on cities (geoname_id) where geoname_id > 0")
+	db.Exec("CREATE INDEX CONCURRENTLY IF NOT EXISTS city_search_names on cities using gin (search_names)")
+
+	return nil
+}
@@ -0,0 +1,155 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// weekly opening hours of a business, or of one of its locations
+// a location with no hours of its own uses the hours of the business
+type OpeningHours struct {
+	ID         uint   `gorm:"primaryKey;type:BIGSERIAL" json:"id"`
+	BusinessId uint   `gorm:"type:BIGINT;index:opening_hours_data" json:"businessId" form:"businessId"`
+	LocationId uint   `gorm:"type:BIGINT;index:opening_hours_data" json:"locationId" form:"locationId"` // locations.id or 0 for the whole business
+	Weekday    int    `json:"weekday" form:"weekday"`                                                   // 0 Sunday to 6 Saturday
+	Opens      string `gorm:"type:VARCHAR(5)" json:"opens" form:"opens"`                                // local time e.g. 08:00
+	Closes     string `gorm:"type:VARCHAR(5)" json:"closes" form:"closes"`                              // local time e.g. 17:30, before opens when open past midnight
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// holiday or change to the weekly opening hours on a date
+type OpeningException struct {
+	ID         uint   `gorm:"primaryKey;type:BIGSERIAL" json:"id"`
+	BusinessId uint   `gorm:"type:BIGINT;index:opening_exception_data" json:"businessId" form:"businessId"`
+	LocationId uint   `gorm:"type:BIGINT;index:opening_exception_data" json:"locationId" form:"locationId"` // locations.id or 0 for the whole business
+	Date       string `gorm:"type:DATE" json:"date" form:"date"`                                            // local date e.g. 2025-12-25
+	Name       string `gorm:"type:VARCHAR" json:"name" form:"name"`                                         // e.g. Christmas
+	Kind       string `gorm:"type:VARCHAR;default:'holiday'" json:"kind" form:"kind"`                       // holiday or exception
+	Closed     bool   `json:"closed" form:"closed"`                                                         // closed all day
+	Opens      string `gorm:"type:VARCHAR(5)" json:"opens" form:"opens"`                                    // hours for the day when not closed
+	Closes     string `gorm:"type:VARCHAR(5)" json:"closes" form:"closes"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// geographic area a service business covers, either a polygon or a radius around the location
+// the polygon is stored in the PostGIS area column, set from GeoJSON
+type ServiceArea struct {
+	ID         uint    `gorm:"primaryKey;type:BIGSERIAL" json:"id"`
+	BusinessId uint    `gorm:"type:BIGINT;index:service_area_business" json:"businessId" form:"businessId"`
+	LocationId uint    `gorm:"type:BIGINT" json:"locationId" form:"locationId"` // locations.id the radius is measured from, or 0 for the business location
+	Name       string  `gorm:"type:VARCHAR" json:"name" form:"name"`
+	Kind       string  `gorm:"type:VARCHAR;default:'radius'" json:"kind" form:"kind"` // radius or polygon
+	RadiusKm   float64 `json:"radiusKm" form:"radiusKm"`
+	GeoJSON    string  `gorm:"column:geojson;type:TEXT" json:"geojson" form:"geojson"` // polygon geometry as GeoJSON
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// parseClock returns the minutes since midnight of a HH:MM time
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, use HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// ValidateClock checks opens and closes are HH:MM times
+func ValidateClock(opens, closes string) error {
+	if _, err := parseClock(opens); err != nil {
+		return err
+	}
+	_, err := parseClock(closes)
+	return err
+}
+
+// openBetween is true if the minute of the day is within opens and closes,
+// spanning midnight when closes is before opens
+func openBetween(opens, closes string, minute int) bool {
+	o, err := parseClock(opens)
+	if err != nil {
+		return false
+	}
+	c, err := parseClock(closes)
+	if err != nil {
+		return false
+	}
+
+	if c <= o {
+		return minute >= o || minute < c
+	}
+	return minute >= o && minute < c
+}
+
+// IsOpenAt reports if the weekly hours and exceptions are open at the given time,
+// evaluated in the timezone of the location
+func IsOpenAt(hours []OpeningHours, exceptions []OpeningException, at time.Time, tz *time.Location) bool {
+	local := at.In(tz)
+	date := local.Format("2006-01-02")
+	minute := local.Hour()*60 + local.Minute()
+
+	for _, e := range exceptions {
+		if len(e.Date) >= 10 && e.Date[:10] == date {
+			if e.Closed {
+				return false
+			}
+			return openBetween(e.Opens, e.Closes, minute)
+		}
+	}
+
+	weekday := int(local.Weekday())
+	yesterday := (weekday + 6) % 7
+
+	for _, h := range hours {
+		o, _ := parseClock(h.Opens)
+		c, _ := parseClock(h.Closes)
+		overnight := c <= o
+
+		if h.Weekday == weekday && openBetween(h.Opens, h.Closes, minute) && (!overnight || minute >= o) {
+			return true
+		}
+
+		// still open from the evening before
+		if h.Weekday == yesterday && overnight && minute < c {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AfterSave store the GeoJSON polygon in the PostGIS area column
+func (a *ServiceArea) AfterSave(tx *gorm.DB) error {
+	if a.Kind == "polygon" && a.GeoJSON != "" {
+		return tx.Exec("update service_areas set area = ST_SetSRID(ST_GeomFromGeoJSON(?), 4326)::geography where id = ?", a.GeoJSON, a.ID).Error
+	}
+
+	return tx.Exec("update service_areas set area = null where id = ?", a.ID).Error
+}
+
+func MigrateOpeningHours(db *gorm.DB) error {
+
+	if err := db.AutoMigrate(&OpeningHours{}); err != nil {
+		return err
+	}
+
+	if err := db.AutoMigrate(&OpeningException{}); err != nil {
+		return err
+	}
+
+	if err := db.AutoMigrate(&ServiceArea{}); err != nil {
+		return err
+	}
+
+	db.Exec("alter table service_areas add column if not exists area GEOGRAPHY(POLYGON,4326)")
+	db.Exec("CREATE INDEX CONCURRENTLY IF NOT EXISTS service_area_geo on service_areas using gist (area)")
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+var ImportTargets = []string{"location", "area", "contact"}
+
+var ImportModes = []string{"create", "update"}
+
+var ImportStatuses = []string{"pending", "running", "complete", "failed"}
+
+// ImportTaskName is the name of the task that runs a large import in the background
+const ImportTaskName = "Import locations"
+
+// ImportFileTaskName is the name of the task that deletes the error report of an import
+const ImportFileTaskName = "Delete import report file"
+
+// a named column mapping a business reuses for its spreadsheets, see services.ImportMapping
+type ImportProfile struct {
+	ID         uint           `gorm:"primaryKey;type:BIGSERIAL" json:"id"`
+	BusinessId uint           `gorm:"type:BIGINT" json:"businessId"`
+	Name       string         `gorm:"type:VARCHAR" json:"name"`
+	Target     string         `gorm:"type:VARCHAR;default:'location'" json:"target"` // location, area or contact
+	Mapping    datatypes.JSON `gorm:"type:jsonb" json:"mapping"`
+	UpdatedBy  uint           `gorm:"type:BIGINT" json:"updatedBy"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// an import of a spreadsheet into the locations, areas or contacts of a business
+type ImportJob struct {
+	ID         uint           `gorm:"primaryKey;type:BIGSERIAL" json:"id"`
+	BusinessId uint           `gorm:"type:BIGINT;index:import_job_business" json:"businessId"`
+	UserId     uint           `gorm:"type:BIGINT" json:"userId"` // users.id that uploaded the file
+	ProfileId  uint           `gorm:"type:BIGINT" json:"profileId"`
+	Target     string         `gorm:"type:VARCHAR" json:"target"`
+	Mode       string         `gorm:"type:VARCHAR" json:"mode"`     // create skips existing records, update overwrites them
+	Mapping    datatypes.JSON `gorm:"type:jsonb" json:"mapping"`    // the mapping used, profiles can change afterwards
+	FileName   string         `gorm:"type:VARCHAR" json:"fileName"` // name of the uploaded file
+	File       string         `gorm:"type:VARCHAR" json:"-"`        // path of the uploaded file until it is imported
+	Report     string         `gorm:"type:VARCHAR" json:"-"`        // path of the CSV error report
+	Status     string         `gorm:"type:VARCHAR;default:'pending'" json:"status"`
+	Error      string         `gorm:"type:VARCHAR" json:"error"`
+
+	Total   int `json:"total"`
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+	Skipped int `json:"skipped"`
+	Failed  int `json:"failed"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func MigrateImport(db *gorm.DB) error {
+
+	if err := db.AutoMigrate(&ImportProfile{}, &ImportJob{}); err != nil {
+		return err
+	}
+
+	db.Exec("CREATE UNIQUE INDEX CONCURRENTLY IF NOT EXISTS import_profile_name on import_profiles (business_id, name)")
+
+	return nil
+}
@@ -28,6 +28,7 @@ type Part struct {
 
 	CreatedAt time.Time
 	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"deletedAt"` // soft deleted, purged after the retention window
 }
 
 type Consumable struct {
@@ -49,6 +50,7 @@ type Consumable struct {
 
 	CreatedAt time.Time
 	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"deletedAt"` // soft deleted, purged after the retention window
 }
 
 // connects parts or consumables to bins, locations, and quantities
@@ -91,6 +93,7 @@ type Bin struct {
 
 	CreatedAt time.Time
 	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"deletedAt"` // soft deleted, purged after the retention window
 }
 
 func MigrateInventory(db *gorm.DB) error {
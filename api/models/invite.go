@@ -0,0 +1,64 @@
+package models
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+// TeamInviteExpiry is how long a team invite link stays valid after it is sent
+const TeamInviteExpiry = 7 * 24 * time.Hour
+
+// invites a person to join the team of a business, accepting creates the BusinessRole
+type TeamInvite struct {
+	ID         uint `gorm:"primaryKey;type:BIGSERIAL" json:"id"`
+	BusinessId uint `gorm:"type:BIGINT;index:team_invite_business" json:"businessId" form:"businessId"`
+	LocationId uint `gorm:"type:BIGINT" json:"locationId" form:"locationId"` // locations.id of the team or 0 for the whole business
+	InvitedBy  uint `gorm:"type:BIGINT" json:"invitedBy"`                    // users.id of the team member that sent the invite
+	RoleId     uint `gorm:"type:BIGINT" json:"roleId"`                       // users.id of the user that accepted the invite
+
+	Name    string `gorm:"type:VARCHAR" json:"name" form:"name"`
+	Email   string `gorm:"type:VARCHAR" json:"email" form:"email"`
+	Phone   string `gorm:"type:VARCHAR" json:"phone" form:"phone"`
+	Channel string `gorm:"type:VARCHAR;default:'email'" json:"channel" form:"channel"` // email, whatsapp or telegram
+
+	// the BusinessRole to create
+	Type        string         `gorm:"type:VARCHAR" json:"type" form:"type"` // operator, manager, owner, contractor, technician
+	Permissions string         `gorm:"type:VARCHAR" json:"permissions" form:"permissions"`
+	Services    pq.StringArray `gorm:"type:varchar[]" json:"services"`
+
+	Code   string `gorm:"type:VARCHAR" json:"-"`                                      // secret used in the invite link
+	Status string `gorm:"type:VARCHAR;default:'pending'" json:"status" form:"status"` // pending, accepted, revoked
+
+	SentCount  uint       `json:"sentCount"`
+	SentAt     time.Time  `json:"sentAt"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	AcceptedAt *time.Time `json:"acceptedAt"`
+
+	Business *Business `json:",omitempty"`
+	Location *Location `json:",omitempty"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (i *TeamInvite) IsExpired() bool {
+	return time.Now().After(i.ExpiresAt)
+}
+
+// IsPending is true if the invite can still be accepted
+func (i *TeamInvite) IsPending() bool {
+	return i.Status == "pending" && !i.IsExpired()
+}
+
+func MigrateTeamInvite(db *gorm.DB) error {
+
+	if err := db.AutoMigrate(&TeamInvite{}); err != nil {
+		return err
+	}
+
+	db.Exec("CREATE UNIQUE INDEX CONCURRENTLY IF NOT EXISTS team_invite_code on team_invites (code)")
+
+	return nil
+}
@@ -23,6 +23,7 @@ type Location struct {
 	Province    string    `gorm:"type:VARCHAR" json:"province" form:"province"`
 	Zipcode     string    `gorm:"type:VARCHAR" json:"postcode" form:"postcode"` // zipcode/postcode of the business - not used in Colombia
 	Country     string    `gorm:"type:VARCHAR" json:"country" form:"country"`
+	CountryCode string    `gorm:"type:CHAR(2)" json:"countryCode" form:"-"`     // ISO 3166-1 alpha-2 of the country, set on save
 	Phone       string    `gorm:"type:VARCHAR" json:"phone" form:"phone"`       // phone number of the contact/location with ISO prefix e.g. +57123456789
 	Website     string    `gorm:"type:VARCHAR" json:"website" form:"website"`   // URL of the website of the location  e.g. https://www.example.com
 	Latlng      string    `gorm:"type:VARCHAR" json:"latlng" form:"latlng"`     // latitude longitude separated by comma e.g. 4.8057849, -75.6830817
@@ -44,6 +45,11 @@ type Location struct {
 
 	Rank uint `gorm:"default:1000" json:"rank"` // rank the location to order within the mktplace home page
 
+	RatingAverage float64 `gorm:"->;type:NUMERIC(3,2);default:0" json:"ratingAverage"` // average stars of the published reviews of the location
+	RatingCount   uint    `gorm:"->;default:0" json:"ratingCount"`
+
+	Timezone string `gorm:"type:VARCHAR" json:"timezone" form:"timezone"` // IANA timezone e.g. America/Bogota, derived from the country and latlng when empty
+
 	Configs  []Config
 	Business *Business
 	User     *User
@@ -51,17 +57,26 @@ type Location struct {
 
 	CreatedAt time.Time
 	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"deletedAt"` // soft deleted, purged after the retention window
 }
 
 type City struct {
 	ID        uint   `gorm:"primary_key" json:"id"`
-	Code      string `gorm:"type:CHAR(2)" json:"code"`  // state code
-	State     string `gorm:"type:VARCHAR" json:"state"` // state name
+	Code      string `gorm:"type:VARCHAR(20)" json:"code"` // state code, the ISO 3166-2 subdivision or GeoNames admin1 code
+	State     string `gorm:"type:VARCHAR" json:"state"`    // state name
 	City      string `gorm:"type:VARCHAR" json:"city"`
 	County    string `gorm:"type:VARCHAR" json:"county"`
 	Latitude  string `gorm:"type:NUMERIC(10,6)" json:"latitude"`
 	Longitude string `gorm:"type:NUMERIC(10,6)" json:"longitude"`
-	Country   string `gorm:"type:VARCHAR;default:'USA'" json:"country"`
+	Country   string `gorm:"type:VARCHAR;default:'USA'" json:"country"` // country name as stored in the locations
+
+	CountryCode string         `gorm:"type:CHAR(2);index" json:"countryCode"` // ISO 3166-1 alpha-2
+	NameAscii   string         `gorm:"type:VARCHAR" json:"nameAscii"`         // the name without accents
+	SearchNames pq.StringArray `gorm:"type:varchar[]" json:"-"`               // lower case names and alternate names without accents
+	Timezone    string         `gorm:"type:VARCHAR" json:"timezone"`          // IANA timezone
+	Population  int64          `json:"population"`
+	GeonameId   uint           `gorm:"type:BIGINT;default:0" json:"geonameId"` // 0 for cities not loaded from GeoNames
+	ModifiedOn  string         `gorm:"type:VARCHAR(10)" json:"modifiedOn"`     // date of the last change in the dataset
 }
 
 // LocationArea instance the area of a location
@@ -114,24 +129,14 @@ func MigrateLocation(db *gorm.DB) error {
 func (l *Location) BeforeSave(tx *gorm.DB) error {
 	l.Name = strings.TrimSpace(strings.Title(l.Name))
 
-	// remove accents from city/province
-	// normalise the business location data
-	l.City = utils.NormalizeAddress(l.City)
-
-	//l.Country = utils.NormalizeAddress(l.Country)
+	// the handlers validate the address, only its country code is derived here
+	l.CountryCode = utils.CountryCode(l.Country)
 
-	if l.Country == "United States" || l.Country == "Usa" {
-		l.Country = "USA"
-	}
+	l.Phone = utils.FixupPhone(l.Phone)
 
-	if l.Country == "USA" {
-		// do not capitalize state in USA
-		l.Province = utils.RemoveAccents(l.Province)
-	} else {
-		l.Province = utils.NormalizeAddress(l.Province)
+	if l.Timezone == "" {
+		l.Timezone = utils.TimezoneFor(l.Country, l.Latlng)
 	}
-
-	l.Phone = utils.FixupPhone(l.Phone)
 	return nil
 }
 
@@ -141,22 +146,20 @@ func (l *Location) AfterSave(tx *gorm.DB) error {
 
 	if l.Latlng != "" {
 		plat, plng := utils.ExtractLatLng(l.Latlng)
-		tx.Exec(fmt.Sprintf("update locations set location = ST_GeographyFromText('SRID=4326;POINT(%f %f)') where id = ?", plng, plat), l.ID)
+		tx.Exec("update locations set location = ST_SetSRID(ST_MakePoint(?, ?), 4326)::geography where id = ?", plng, plat, l.ID)
 	}
 
 	return nil
 }
 
+// ConfigString the value of a config item of the location, which overrides the value of its business
+// when Business.Configs is loaded, resolved against the registry
 func (loc Location) ConfigString(name string, defaultValue string) string {
-	if loc.Configs == nil || len(loc.Configs) == 0 {
-		return defaultValue
-	}
-	for _, cfg := range loc.Configs {
-		if cfg.Name == name {
-			return cfg.Value
-		}
+	var rows []Config
+	if loc.Business != nil {
+		rows = loc.Business.businessConfigs()
 	}
-	return defaultValue
+	return ConfigValue(append(rows, loc.Configs...), name, defaultValue)
 }
 
 func (item *Location) AfterCreate(tx *gorm.DB) error {
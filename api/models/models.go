@@ -26,6 +26,42 @@ func MigrateTables(db *gorm.DB) error {
 		return err
 	}
 
+	if err := MigrateSubContractor(db); err != nil {
+		return err
+	}
+
+	if err := MigrateCustomer(db); err != nil {
+		return err
+	}
+
+	if err := MigrateSubdomainRedirect(db); err != nil {
+		return err
+	}
+
+	if err := MigrateDuplicate(db); err != nil {
+		return err
+	}
+
+	if err := MigrateExport(db); err != nil {
+		return err
+	}
+
+	if err := MigrateDeletionAudit(db); err != nil {
+		return err
+	}
+
+	if err := MigrateImport(db); err != nil {
+		return err
+	}
+
+	if err := MigrateGeocode(db); err != nil {
+		return err
+	}
+
+	if err := MigrateGeofence(db); err != nil {
+		return err
+	}
+
 	if err := MigrateDataCache(db); err != nil {
 		return err
 	}
@@ -44,9 +80,41 @@ func MigrateTables(db *gorm.DB) error {
 		return err
 	}
 
+	if err := MigrateGeography(db); err != nil {
+		return err
+	}
+
+	if err := MigrateOpeningHours(db); err != nil {
+		return err
+	}
+
+	if err := MigrateReview(db); err != nil {
+		return err
+	}
+
+	if err := MigrateSession(db); err != nil {
+		return err
+	}
+
+	if err := MigrateSigningKey(db); err != nil {
+		return err
+	}
+
+	if err := MigrateSite(db); err != nil {
+		return err
+	}
+
 	if err := MigrateTask(db); err != nil {
 		return err
 	}
+
+	if err := MigrateTeamInvite(db); err != nil {
+		return err
+	}
+	if err := MigrateTwoFactor(db); err != nil {
+		return err
+	}
+
 	if err := MigrateUser(db); err != nil {
 		return err
 	}
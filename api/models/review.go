@@ -0,0 +1,92 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ReviewFlagLimit is the number of flags that sends a review back to moderation
+const ReviewFlagLimit = 3
+
+var ReviewStatuses = []string{"published", "pending", "hidden"}
+
+// star rating and review of a business, or one of its locations, by a verified customer
+type Review struct {
+	ID         uint `gorm:"primaryKey;type:BIGSERIAL" json:"id"`
+	BusinessId uint `gorm:"type:BIGINT;index:review_business" json:"businessId" form:"businessId"` // reviewed business ID
+	LocationId uint `gorm:"type:BIGINT" json:"locationId" form:"locationId"`                       // reviewed locations.id or 0 for the business
+	UserId     uint `gorm:"type:BIGINT" json:"userId"`                                             // users.id of the author
+	CustomerId uint `gorm:"type:BIGINT" json:"customerId"`                                         // business ID the author is a verified customer through
+
+	Rating int    `json:"rating" form:"rating"` // 1 to 5 stars
+	Title  string `gorm:"type:VARCHAR" json:"title" form:"title"`
+	Text   string `gorm:"type:TEXT" json:"text" form:"text"`
+
+	Status    string `gorm:"type:VARCHAR;default:'published'" json:"status"` // published, pending (awaiting moderation), hidden
+	FlagCount uint   `json:"flagCount"`
+
+	Reply     string     `gorm:"type:TEXT" json:"reply"` // the business reply
+	ReplyBy   uint       `gorm:"type:BIGINT" json:"replyBy"`
+	RepliedAt *time.Time `json:"repliedAt"`
+
+	User *User `gorm:"foreignKey:UserId;references:ID" json:",omitempty"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// a user reporting a review as inappropriate
+type ReviewFlag struct {
+	ID       uint   `gorm:"primaryKey;type:BIGSERIAL" json:"id"`
+	ReviewId uint   `gorm:"type:BIGINT" json:"reviewId"`
+	UserId   uint   `gorm:"type:BIGINT" json:"userId"`
+	Reason   string `gorm:"type:VARCHAR" json:"reason" form:"reason"`
+
+	CreatedAt time.Time
+}
+
+// UpdateRatings recompute the cached rating of a business and its location from the published reviews
+func UpdateRatings(tx *gorm.DB, businessId, locationId uint) error {
+
+	if err := tx.Exec(`update businesses set
+		rating_average = coalesce((select round(avg(rating), 2) from reviews where business_id = ? and status = 'published'), 0),
+		rating_count = (select count(*) from reviews where business_id = ? and status = 'published')
+		where id = ?`, businessId, businessId, businessId).Error; err != nil {
+		return err
+	}
+
+	if locationId == 0 {
+		return nil
+	}
+
+	return tx.Exec(`update locations set
+		rating_average = coalesce((select round(avg(rating), 2) from reviews where location_id = ? and status = 'published'), 0),
+		rating_count = (select count(*) from reviews where location_id = ? and status = 'published')
+		where id = ?`, locationId, locationId, locationId).Error
+}
+
+func (r *Review) AfterSave(tx *gorm.DB) error {
+	return UpdateRatings(tx, r.BusinessId, r.LocationId)
+}
+
+func (r *Review) AfterDelete(tx *gorm.DB) error {
+	tx.Exec("delete from review_flags where review_id = ?", r.ID)
+	return UpdateRatings(tx, r.BusinessId, r.LocationId)
+}
+
+func MigrateReview(db *gorm.DB) error {
+
+	if err := db.AutoMigrate(&Review{}); err != nil {
+		return err
+	}
+
+	if err := db.AutoMigrate(&ReviewFlag{}); err != nil {
+		return err
+	}
+
+	db.Exec("CREATE UNIQUE INDEX CONCURRENTLY IF NOT EXISTS review_author on reviews (business_id, location_id, user_id)")
+	db.Exec("CREATE UNIQUE INDEX CONCURRENTLY IF NOT EXISTS review_flag_data on review_flags (review_id, user_id)")
+
+	return nil
+}
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// a login of a user on a device, the refresh token of the session gets new access tokens
+// and is replaced each time it is used
+type UserSession struct {
+	ID           uint       `gorm:"primaryKey;type:BIGSERIAL" json:"id"`
+	UserId       uint       `gorm:"type:BIGINT;index" json:"userId"`
+	RefreshHash  string     `gorm:"type:VARCHAR" json:"-"` // SHA-256 of the current refresh token
+	PreviousHash string     `gorm:"type:VARCHAR" json:"-"` // of the refresh token it replaced, using it again revokes the session
+	AccessJti    string     `gorm:"type:VARCHAR" json:"-"` // ID of the last access token issued
+	Package      string     `gorm:"type:VARCHAR" json:"package"`
+	UserAgent    string     `gorm:"type:VARCHAR" json:"userAgent"`
+	ClientIP     string     `gorm:"type:VARCHAR" json:"clientIp"`
+	RotatedAt    time.Time  `json:"rotatedAt"`
+	LastUsedAt   time.Time  `json:"lastUsedAt"`
+	ExpiresAt    time.Time  `json:"expiresAt"`
+	RevokedAt    *time.Time `json:"revokedAt"`
+	RevokedWhy   string     `gorm:"type:VARCHAR" json:"revokedWhy"` // logout, revoked, reused
+
+	App     *UserApp `gorm:"-" json:"app,omitempty"` // the app of the session on the device
+	Current bool     `gorm:"-" json:"current"`       // the session of the request
+
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// the reasons sessions end
+const (
+	SessionLogout  = "logout"
+	SessionRevoked = "revoked"
+	SessionReused  = "reused" // a replaced refresh token was used again, it was stolen or replayed
+)
+
+func MigrateSession(db *gorm.DB) error {
+
+	if err := db.AutoMigrate(&UserSession{}); err != nil {
+		return err
+	}
+
+	db.Exec("CREATE UNIQUE INDEX CONCURRENTLY IF NOT EXISTS user_session_refresh_idx on user_sessions (refresh_hash)")
+	db.Exec("CREATE INDEX CONCURRENTLY IF NOT EXISTS user_session_previous_idx on user_sessions (previous_hash) where previous_hash <> ''")
+
+	return nil
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// a key that signs the access tokens, the public keys are published at /.well-known/jwks.json
+// so other services can verify the tokens
+type SigningKey struct {
+	ID          uint            `gorm:"primaryKey" json:"id"`
+	Kid         string          `gorm:"type:VARCHAR" json:"kid"`
+	Algorithm   string          `gorm:"type:VARCHAR" json:"algorithm"` // RS256 or EdDSA
+	PrivateKey  EncryptedString `gorm:"type:TEXT" json:"-"`            // PKCS #8 PEM
+	PublicKey   string          `gorm:"type:TEXT" json:"publicKey"`    // PKIX PEM
+	Status      string          `gorm:"type:VARCHAR;index" json:"status"`
+	VerifyUntil *time.Time      `json:"verifyUntil"` // when a replaced key stops verifying the tokens it signed
+	RetiredAt   *time.Time      `json:"retiredAt"`
+
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// the status of the signing keys
+const (
+	SigningKeyActive  = "active"  // signs the new tokens
+	SigningKeyVerify  = "verify"  // replaced, only verifies the tokens it signed until VerifyUntil
+	SigningKeyRetired = "retired" // no longer trusted
+)
+
+func MigrateSigningKey(db *gorm.DB) error {
+
+	if err := db.AutoMigrate(&SigningKey{}); err != nil {
+		return err
+	}
+
+	db.Exec("CREATE UNIQUE INDEX CONCURRENTLY IF NOT EXISTS signing_key_kid_idx on signing_keys (kid)")
+
+	return nil
+}
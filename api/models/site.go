@@ -0,0 +1,88 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// the kinds of the nodes of the tree of a location, from the top
+var SiteNodeKinds = []string{"site", "building", "floor", "room", "area"}
+
+// the asset types of the images of a node, Asset.ObjectId is the node ID
+const (
+	SiteNodePhoto     = "site_photo"
+	SiteNodeFloorPlan = "floor_plan"
+)
+
+// the kinds of objects placed in a node
+const (
+	PlacementEquipment = "equipment" // equipment.id
+	PlacementBin       = "bin"       // bins.id, with its stock and sub bins
+)
+
+// a site, building, floor, room or area of a location, nested by ParentId
+type LocationNode struct {
+	ID         uint   `gorm:"primaryKey;type:BIGSERIAL" json:"id"`
+	BusinessId uint   `gorm:"type:BIGINT;index:location_node_data" json:"businessId" form:"businessId"`
+	LocationId uint   `gorm:"type:BIGINT;index:location_node_data" json:"locationId" form:"locationId"`
+	ParentId   uint   `gorm:"type:BIGINT;default:0" json:"parentId" form:"parentId"` // location_nodes.id or 0 at the top of the location
+	Path       string `gorm:"type:VARCHAR;index" json:"path"`                        // IDs from the top down to this node e.g. /3/8/21/
+	Depth      int    `json:"depth"`
+	Position   int    `json:"position" form:"position"` // order among the children of the parent
+	Kind       string `gorm:"type:VARCHAR" json:"kind" form:"kind"`
+	Name       string `gorm:"type:VARCHAR" json:"name" form:"name"`
+	Code       string `gorm:"type:VARCHAR" json:"code" form:"code"` // e.g. room number, barcode or QRcode at the door
+	Notes      string `gorm:"type:VARCHAR" json:"notes" form:"notes"`
+	AreaId     uint   `gorm:"type:BIGINT;default:0" json:"areaId"` // location_areas.id the node was created from
+	UpdatedBy  uint   `gorm:"type:BIGINT" json:"updatedBy"`
+
+	Assets     []Asset             `gorm:"foreignKey:ObjectId" json:"assets,omitempty"`
+	Placements []LocationPlacement `gorm:"foreignKey:NodeId" json:"placements,omitempty"`
+	Children   []*LocationNode     `gorm:"-" json:"children,omitempty"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// ChildPath the path of a child of the node, or of a node at the top when n is nil
+func (n *LocationNode) ChildPath(id uint) string {
+	if n == nil {
+		return fmt.Sprintf("/%d/", id)
+	}
+	return fmt.Sprintf("%s%d/", n.Path, id)
+}
+
+// a piece of equipment or a bin in a node, at a point of its floor plan
+type LocationPlacement struct {
+	ID         uint    `gorm:"primaryKey;type:BIGSERIAL" json:"id"`
+	BusinessId uint    `gorm:"type:BIGINT" json:"businessId"`
+	NodeId     uint    `gorm:"type:BIGINT;index" json:"nodeId"`
+	Kind       string  `gorm:"type:VARCHAR" json:"kind" form:"kind"` // equipment or bin
+	ObjectId   uint    `gorm:"type:BIGINT" json:"objectId" form:"objectId"`
+	X          float64 `json:"x" form:"x"` // 0 to 1 from the left of the floor plan
+	Y          float64 `json:"y" form:"y"` // 0 to 1 from the top
+	UpdatedBy  uint    `gorm:"type:BIGINT" json:"updatedBy"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func MigrateSite(db *gorm.DB) error {
+
+	if err := db.AutoMigrate(&LocationNode{}, &LocationPlacement{}); err != nil {
+		return err
+	}
+
+	// an object is placed in one node
+	db.Exec("CREATE UNIQUE INDEX CONCURRENTLY IF NOT EXISTS location_placement_object on location_placements (kind, object_id)")
+
+	// the flat areas of the locations become areas at the top of their location
+	db.Exec(`insert into location_nodes (business_id, location_id, parent_id, kind, name, area_id, path, depth, position, created_at, updated_at)
+		select a.business_id, a.location_id, 0, 'area', a.name, a.id, '', 0, 0, now(), now() from location_areas a
+		where not exists (select 1 from location_nodes n where n.area_id = a.id)`)
+	db.Exec("update location_nodes set path = '/' || id || '/' where path = ''")
+
+	return nil
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// old subdomain of a business, redirected to its current Uuid
+type SubdomainRedirect struct {
+	ID         uint   `gorm:"primaryKey;type:BIGSERIAL" json:"id"`
+	BusinessId uint   `gorm:"type:BIGINT" json:"businessId"`
+	Uuid       string `gorm:"type:VARCHAR" json:"uuid"` // the previous subdomain
+
+	CreatedAt time.Time
+}
+
+func MigrateSubdomainRedirect(db *gorm.DB) error {
+
+	if err := db.AutoMigrate(&SubdomainRedirect{}); err != nil {
+		return err
+	}
+
+	db.Exec("CREATE UNIQUE INDEX CONCURRENTLY IF NOT EXISTS subdomain_redirect_uuid on subdomain_redirects (uuid)")
+	db.Exec("CREATE UNIQUE INDEX CONCURRENTLY IF NOT EXISTS business_uuid_lower on businesses (lower(uuid))")
+
+	return nil
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// the TOTP second factor of a user, required at login once confirmed with a code
+type UserTwoFactor struct {
+	ID          uint            `gorm:"primaryKey" json:"id"`
+	UserId      uint            `gorm:"type:BIGINT" json:"userId"`
+	Secret      EncryptedString `gorm:"type:VARCHAR" json:"-"` // base32 TOTP secret
+	ConfirmedAt *time.Time      `json:"confirmedAt"`           // nil until the user enters a first code
+	LastStep    int64           `json:"-"`                     // time step of the last code used, each code is accepted once
+
+	ChallengeHash      string     `gorm:"type:VARCHAR;index" json:"-"` // SHA-256 of the pending login challenge
+	ChallengeExpiresAt *time.Time `json:"-"`
+	ChallengeAttempts  int        `json:"-"`
+	FailedAttempts     int        `json:"-"` // invalid codes since the last valid one, across the challenges
+	LockedUntil        *time.Time `json:"-"` // no challenge is answered until then after too many invalid codes
+
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// DataItemRecoveryCode the kind of the data items that hold the hashed recovery codes of the second factor
+const DataItemRecoveryCode = "recovery_code"
+
+func MigrateTwoFactor(db *gorm.DB) error {
+
+	if err := db.AutoMigrate(&UserTwoFactor{}); err != nil {
+		return err
+	}
+
+	db.Exec("CREATE UNIQUE INDEX CONCURRENTLY IF NOT EXISTS user_two_factor_user_idx on user_two_factors (user_id)")
+
+	return nil
+}
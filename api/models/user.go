@@ -44,8 +44,9 @@ type User struct {
 
 	Locale string `gorm:"type:VARCHAR" json:"locale" form:"locale"`
 
-	UnlockToken string         `gorm:"type:VARCHAR" json:"unlockToken" form:"unlockToken"`
-	Flags       pq.StringArray `gorm:"type:varchar[]" json:"flags"` // flags to control the user
+	UnlockToken      EncryptedString  `gorm:"type:VARCHAR" json:"unlockToken" form:"unlockToken"` // encrypted, find users by it with WhereUnlockToken
+	UnlockTokenIndex BlindIndexString `gorm:"type:VARCHAR;index" json:"-"`                        // blind index of the unlock token
+	Flags            pq.StringArray   `gorm:"type:varchar[]" json:"flags"`                        // flags to control the user
 
 	DataItems []DataItem
 
@@ -136,10 +137,20 @@ type LoginRequest struct {
 //	DocumentNumber string `gorm:"type:VARCHAR" json:"documentNumber" form:"documentNumber"`
 //	DocumentType   string `gorm:"type:VARCHAR" json:"documentType" form:"documentType"`
 type DataItem struct {
-	ID     uint   `gorm:"primary_key" json:"id"`
-	UserId uint   `gorm:"type:BIGINT"  json:"userId" form:"userId"`
-	Kind   string `gorm:"type:VARCHAR" json:"kind" form:"kind"` // fingerprint, face, picture, public key, place name (place of birth), voice print, document type, etc.
-	Value  string `gorm:"type:VARCHAR" json:"value" form:"value"`
+	ID     uint            `gorm:"primary_key" json:"id"`
+	UserId uint            `gorm:"type:BIGINT"  json:"userId" form:"userId"`
+	Kind   string          `gorm:"type:VARCHAR" json:"kind" form:"kind"` // fingerprint, face, picture, public key, place name (place of birth), voice print, document type, etc.
+	Value  EncryptedString `gorm:"type:VARCHAR" json:"value" form:"value"`
+}
+
+// Unmasked the data item with the plaintext value, for the user it belongs to
+func (item DataItem) Unmasked() map[string]interface{} {
+	return map[string]interface{}{
+		"id":     item.ID,
+		"userId": item.UserId,
+		"kind":   item.Kind,
+		"value":  string(item.Value),
+	}
 }
 
 func MigrateUser(db *gorm.DB) error {
@@ -173,6 +184,9 @@ func MigrateUser(db *gorm.DB) error {
 	// create a unique index on users email and phone
 	db.Exec("CREATE UNIQUE INDEX CONCURRENTLY IF NOT EXISTS user_data_idx on users (email, phone)")
 
+	// a missing blind index is NULL so it never matches
+	db.Exec("update users set unlock_token_index = null where unlock_token_index = ''")
+
 	// users, user_apps and data items should be in the same data shard
 	// constrain user_apps to reference users
 	//db.Exec("alter table user_apps add constraint user_apps_id_fkey foreign key (user_id) references users(id) on delete cascade")
@@ -218,21 +232,34 @@ func (u *User) BeforeCreate(tx *gorm.DB) (err error) {
 	return nil
 }
 
-// runs before create and save
-func (u *User) BeforeSave(tx *gorm.DB) error {
+// WhereUnlockToken find the users with an unlock token by its blind index, when there is an index key,
+// or its plaintext for users saved before the token was encrypted. An empty token matches no one,
+// nor do the users without a token.
+func WhereUnlockToken(db *gorm.DB, token string) *gorm.DB {
+	if token == "" {
+		return db.Where("false")
+	}
+	db = db.Where("coalesce(unlock_token, '') <> ''")
+	if index := utils.BlindIndex(token); index != "" {
+		return db.Where("(unlock_token_index = ? or unlock_token = ?)", index, token)
+	}
+	return db.Where("unlock_token = ?", token)
+}
 
-	// remove accents from city/province
-	// normalise the business location data
-	u.City = utils.NormalizeAddress(u.City)
-	u.Province = utils.NormalizeAddress(u.Province)
-	//b.Country = utils.NormalizeAddress(b.Country)  // do not do this as it changes USA to Usa
+// NormalizeAddress write the location of the user by the rules of their country,
+// for the handlers that save it
+func (u *User) NormalizeAddress() {
+	address := utils.NormalizeAddressFor(utils.Address{City: u.City, Province: u.Province, Country: u.Country})
+	u.City, u.Province, u.Country = address.City, address.Province, address.Country
+}
 
-	if u.Country == "United States" || u.Country == "Usa" {
-		u.Country = "USA"
-	}
+// runs before create and save
+func (u *User) BeforeSave(tx *gorm.DB) error {
 
 	u.Phone = utils.FixupPhone(u.Phone)
 
+	u.UnlockTokenIndex = BlindIndexString(utils.BlindIndex(string(u.UnlockToken)))
+
 	if u.Password != "" {
 		// hash the password to HashedPassword
 		u.HashedPassword, _ = HashPassword(u.Password)
@@ -3,6 +3,7 @@ package services
 import (
 	"errors"
 	"myproject/api/models"
+	"strings"
 
 	"gorm.io/gorm"
 )
@@ -30,3 +31,103 @@ func IsBusinessOwner(db *gorm.DB, userId, businessId uint) bool {
 
 	return !errors.Is(result.Error, gorm.ErrRecordNotFound)
 }
+
+func IsBusinessProvider(db *gorm.DB, userId, businessId uint) bool {
+	// check if the user is a team member of a provider servicing the business
+	var count int64
+	db.Model(&models.BusinessCustomer{}).
+		Where("customer_id = ? and business_id in (select business_id from business_roles where role_id = ? union select id from businesses where user_id = ?)", businessId, userId, userId).
+		Count(&count)
+
+	return count > 0
+}
+
+// CanManageBusiness reports if the user is the owner or an admin of the business
+func CanManageBusiness(db *gorm.DB, user models.User, businessId uint) bool {
+	return strings.Contains(user.Roles, "admin") ||
+		IsBusinessOwner(db, user.ID, businessId) ||
+		IsBusinessAdmin(db, user.ID, businessId)
+}
+
+// SensitivePermission the team permission to see the bank details and IDs of the business unmasked
+const SensitivePermission = "Sensitive"
+
+// CanRevealSensitive reports if the user may see the encrypted fields of the business:
+// the owner, a platform admin or a team member with the Sensitive permission
+func CanRevealSensitive(db *gorm.DB, user models.User, businessId uint) bool {
+	if strings.Contains(user.Roles, "admin") || IsBusinessOwner(db, user.ID, businessId) {
+		return true
+	}
+
+	var permissions []string
+	db.Model(&models.BusinessRole{}).Where("business_id = ? and role_id = ?", businessId, user.ID).Pluck("permissions", &permissions)
+	for _, p := range permissions {
+		for _, permission := range strings.Split(p, ",") {
+			if strings.TrimSpace(permission) == SensitivePermission {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// CanAccessBusiness reports if the user may read and write the data of the business.
+// The providers of a customer business only read it, see CanReadBusiness.
+func CanAccessBusiness(db *gorm.DB, user models.User, businessId uint) bool {
+	if strings.Contains(user.Roles, "admin") {
+		return true
+	}
+
+	return IsBusinessOwner(db, user.ID, businessId) ||
+		IsTeamMember(db, user.ID, businessId)
+}
+
+// CanReadBusiness reports if the user may read the data of the business, as one of its team
+// or as the team of a provider servicing it
+func CanReadBusiness(db *gorm.DB, user models.User, businessId uint) bool {
+	return CanAccessBusiness(db, user, businessId) || IsBusinessProvider(db, user.ID, businessId)
+}
+
+// contractorOf selects the sub_contractors rows the user acts for, directly or as a team member of a contractor business
+const contractorOf = "(technician_id = ? or contractor_id in (select business_id from business_roles where role_id = ? union select id from businesses where user_id = ?))"
+
+// ContractorIdsForUser returns the sub_contractors IDs the user can work as
+func ContractorIdsForUser(db *gorm.DB, userId uint) []uint {
+	var ids []uint
+	db.Model(&models.SubContractor{}).Where(contractorOf, userId, userId, userId).Pluck("id", &ids)
+	return ids
+}
+
+// IsAssignedContractor reports if the user works for a sub contractor with active work at the location
+func IsAssignedContractor(db *gorm.DB, userId, locationId uint) bool {
+	var count int64
+	db.Model(&models.SubContractorAssignment{}).
+		Where("location_id = ? and status = 'active' and sub_contractor_id in (select id from sub_contractors where "+contractorOf+")", locationId, userId, userId, userId).
+		Count(&count)
+
+	return count > 0
+}
+
+// userBusinesses selects the businesses the user owns or is a team member of
+const userBusinesses = "(select id from businesses where user_id = ? union select business_id from business_roles where role_id = ?)"
+
+// VerifiedCustomerOf returns the business the user is a verified customer of businessId through:
+// a business of the user that is a non prospect customer of businessId, or that had work
+// completed by businessId as a sub contractor. Returns 0 if the user is not a verified customer.
+func VerifiedCustomerOf(db *gorm.DB, userId, businessId uint) uint {
+	var customerIds []uint
+
+	db.Raw(`select customer_id from business_customers
+		where business_id = ? and status <> 'prospect' and customer_id in `+userBusinesses+`
+		union
+		select s.business_id from sub_contractors s
+		join sub_contractor_assignments a on a.sub_contractor_id = s.id and a.status = 'completed'
+		where s.contractor_id = ? and s.business_id in `+userBusinesses,
+		businessId, userId, userId, businessId, userId, userId).Scan(&customerIds)
+
+	if len(customerIds) == 0 {
+		return 0
+	}
+	return customerIds[0]
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+
+	"myproject/api/models"
+
+	"gorm.io/gorm"
+)
+
+// EffectiveConfig the resolved value of a registered config item and where it came from
+type EffectiveConfig struct {
+	Name        string      `json:"name"`
+	Kind        string      `json:"kind"`
+	Value       interface{} `json:"value"`
+	Default     interface{} `json:"default"`
+	Source      string      `json:"source"` // default, business, location, user or site
+	ConfigId    uint        `json:"configId"`
+	Allowed     []string    `json:"allowed"`
+	Description string      `json:"description"`
+}
+
+// Settings the config values of a business, location or user resolved against the registry
+type Settings map[string]EffectiveConfig
+
+// resolveSettings apply the config rows over the registry defaults of the keys that can be set at one of the scopes,
+// rows must be ordered from the least to the most specific
+func resolveSettings(scopes []string, rows []models.Config) Settings {
+	settings := Settings{}
+
+	for name, key := range models.ConfigKeys {
+		if !slices.ContainsFunc(key.Scopes, func(scope string) bool { return slices.Contains(scopes, scope) }) {
+			continue
+		}
+		def, _ := key.Parse(key.Default)
+		settings[name] = EffectiveConfig{Name: name, Kind: key.Kind, Value: def, Default: def, Source: "default", Allowed: key.Allowed, Description: key.Description}
+	}
+
+	for _, row := range rows {
+		effective, ok := settings[row.Name]
+		if !ok {
+			continue
+		}
+		// a value written before the key was registered may not parse, it is ignored
+		value, err := models.ConfigKeys[row.Name].Parse(row.Value)
+		if err != nil {
+			continue
+		}
+		effective.Value = value
+		effective.Source = row.Scope()
+		effective.ConfigId = row.ID
+		settings[row.Name] = effective
+	}
+
+	return settings
+}
+
+// BusinessSettings resolve the config of a business, or of one of its locations when locationId is set:
+// the location value overrides the business value which overrides the registry default
+func BusinessSettings(db *gorm.DB, businessId, locationId uint) Settings {
+	var rows []models.Config
+	db.Where("business_id = ? and location_id in ? and name in ?", businessId, []uint{0, locationId}, configNames()).
+		Order("location_id").Find(&rows)
+
+	return resolveSettings([]string{models.ConfigScopeBusiness, models.ConfigScopeLocation}, rows)
+}
+
+// SiteSettings resolve the config of a website over the registry defaults
+func SiteSettings(db *gorm.DB, siteId uint) Settings {
+	return UserSettings(db, 0, siteId)
+}
+
+// UserSettings resolve the config a user set for themselves on a website: the user value
+// overrides the value of the site, when siteId is set, which overrides the registry default
+func UserSettings(db *gorm.DB, userId, siteId uint) Settings {
+	var rows []models.Config
+	if siteId > 0 {
+		db.Where("site_id = ? and business_id = 0 and location_id = 0 and name in ?", siteId, configNames()).Find(&rows)
+	}
+
+	var userRows []models.Config
+	if userId > 0 {
+		db.Where("user_id = ? and business_id = 0 and location_id = 0 and site_id = 0 and name in ?", userId, configNames()).Find(&userRows)
+	}
+
+	return resolveSettings([]string{models.ConfigScopeUser, models.ConfigScopeSite}, append(rows, userRows...))
+}
+
+func configNames() []string {
+	names := make([]string, 0, len(models.ConfigKeys))
+	for name := range models.ConfigKeys {
+		names = append(names, name)
+	}
+	return names
+}
+
+// List the settings sorted by name
+func (s Settings) List() []EffectiveConfig {
+	list := make([]EffectiveConfig, 0, len(s))
+	for _, effective := range s {
+		list = append(list, effective)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// value the resolved value of a config item, nil if the item is not registered
+func (s Settings) value(name string) interface{} {
+	if effective, ok := s[name]; ok {
+		return effective.Value
+	}
+	if key, ok := models.ConfigKeys[name]; ok {
+		def, _ := key.Parse(key.Default)
+		return def
+	}
+	return nil
+}
+
+// String the value of a string config item
+func (s Settings) String(name string) string {
+	switch v := s.value(name).(type) {
+	case string:
+		return v
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Bool the value of a bool config item, false if unknown
+func (s Settings) Bool(name string) bool {
+	v, _ := s.value(name).(bool)
+	return v
+}
+
+// Int the value of an int config item, 0 if unknown
+func (s Settings) Int(name string) int {
+	v, _ := s.value(name).(int64)
+	return int(v)
+}
+
+// Float the value of a float or int config item, 0 if unknown
+func (s Settings) Float(name string) float64 {
+	switch v := s.value(name).(type) {
+	case float64:
+		return v
+	case int64:
+		return float64(v)
+	}
+	return 0
+}
@@ -0,0 +1,324 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"myproject/api/models"
+	"myproject/api/utils"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/plugin/dbresolver"
+)
+
+// weights of the fields matched by FindDuplicates
+var duplicateWeights = map[string]int{
+	"taxId":  50,
+	"nid":    50,
+	"phone":  30,
+	"email":  30,
+	"name":   25,
+	"nearby": 20,
+}
+
+// DuplicateThreshold is the minimum score of a candidate pair,
+// a matching tax ID on its own or two weaker matches e.g. name and nearby
+const DuplicateThreshold = 45
+
+// duplicateRadius is the distance in metres under which two businesses are nearby
+const duplicateRadius = 150
+
+// the business references re-pointed by MergeBusinesses, keyed by table.column,
+// with the condition that skips rows the target already has
+var mergeReferences = []struct {
+	Key       string
+	Condition string
+}{
+	{"locations.business_id", ""},
+	{"location_areas.business_id", ""},
+	{"location_nodes.business_id", ""},
+	{"location_placements.business_id", ""},
+	{"business_roles.business_id", "(role_id, location_id) not in (select role_id, location_id from business_roles where business_id = @target)"},
+	{"business_customers.business_id", "customer_id <> @target and customer_id not in (select customer_id from business_customers where business_id = @target)"},
+	{"business_customers.customer_id", "business_id <> @target and business_id not in (select business_id from business_customers where customer_id = @target)"},
+	{"configs.business_id", ""},
+	{"contacts.business_id", ""},
+	{"assets.business_id", ""},
+}
+
+var ErrSameBusiness = errors.New("cannot merge a business into itself")
+var ErrAlreadyMerged = errors.New("business was already merged")
+var ErrMergeUndone = errors.New("merge was already undone")
+
+// the fields of a business compared by FindDuplicates
+type duplicateMatch struct {
+	ID       uint
+	Name     string
+	Phone    string
+	Email    string
+	Nid      models.EncryptedString
+	TaxId    models.EncryptedString
+	Distance float64
+}
+
+// scoreDuplicate compare two businesses, distance in metres or -1 if unknown
+func scoreDuplicate(business models.Business, match duplicateMatch) (int, []string) {
+	reasons := []string{}
+
+	if id := utils.NormalizeTaxId(string(business.TaxId)); id != "" && id == utils.NormalizeTaxId(string(match.TaxId)) {
+		reasons = append(reasons, "taxId")
+	}
+	if id := utils.NormalizeTaxId(string(business.Nid)); id != "" && id == utils.NormalizeTaxId(string(match.Nid)) {
+		reasons = append(reasons, "nid")
+	}
+	if phone := utils.FixupPhone(business.Phone); len(phone) >= 7 && phone == utils.FixupPhone(match.Phone) {
+		reasons = append(reasons, "phone")
+	}
+	if email := strings.ToLower(strings.TrimSpace(business.Email)); email != "" && email == strings.ToLower(strings.TrimSpace(match.Email)) {
+		reasons = append(reasons, "email")
+	}
+	if name := utils.NormalizeBusinessName(business.Name); name != "" && name == utils.NormalizeBusinessName(match.Name) {
+		reasons = append(reasons, "name")
+	}
+	if match.Distance >= 0 && match.Distance <= duplicateRadius {
+		reasons = append(reasons, "nearby")
+	}
+
+	score := 0
+	for _, r := range reasons {
+		score += duplicateWeights[r]
+	}
+
+	return score, reasons
+}
+
+// FindDuplicates returns the businesses that may be the same real business as the given one,
+// matching the tax ID, national ID, phone, email or location then scoring the normalised fields
+func FindDuplicates(db *gorm.DB, business models.Business) []models.DuplicateCandidate {
+
+	taxId := utils.NormalizeTaxId(string(business.TaxId))
+	nid := utils.NormalizeTaxId(string(business.Nid))
+
+	// encrypted IDs are matched by their blind index, the ones saved before encryption by value
+	var matches []duplicateMatch
+	db.Raw(`select b.id, b.name, b.phone, b.email, b.nid, b.tax_id,
+			coalesce(ST_Distance(b.location, s.location), -1) as distance
+		from businesses b, (select location from businesses where id = @id) s
+		where b.id <> @id and not ('merged' = any(coalesce(b.flags, '{}')))
+		and ((@taxId <> '' and ((@taxIdIndex <> '' and b.tax_id_index = @taxIdIndex) or upper(regexp_replace(b.tax_id, '[^A-Za-z0-9]', '', 'g')) = @taxId))
+			or (@nid <> '' and ((@nidIndex <> '' and b.nid_index = @nidIndex) or upper(regexp_replace(b.nid, '[^A-Za-z0-9]', '', 'g')) = @nid))
+			or (length(@phone) >= 7 and b.phone = @phone)
+			or (@email <> '' and lower(trim(b.email)) = @email)
+			or ST_DWithin(b.location, s.location, @radius))
+		limit 50`,
+		map[string]interface{}{
+			"id":         business.ID,
+			"taxId":      taxId,
+			"taxIdIndex": utils.BlindIndex(taxId),
+			"nid":        nid,
+			"nidIndex":   utils.BlindIndex(nid),
+			"phone":      utils.FixupPhone(business.Phone),
+			"email":      strings.ToLower(strings.TrimSpace(business.Email)),
+			"radius":     duplicateRadius,
+		}).Scan(&matches)
+
+	candidates := []models.DuplicateCandidate{}
+	for _, match := range matches {
+		score, reasons := scoreDuplicate(business, match)
+		if score < DuplicateThreshold {
+			continue
+		}
+
+		// store each pair once with the lower ID first
+		candidate := models.DuplicateCandidate{BusinessId: business.ID, DuplicateId: match.ID, Score: score, Reasons: reasons, Distance: match.Distance}
+		if match.ID < business.ID {
+			candidate.BusinessId, candidate.DuplicateId = match.ID, business.ID
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	return candidates
+}
+
+// SaveDuplicates find and store the duplicate candidates of a business,
+// pairs already dismissed or merged keep their status
+func SaveDuplicates(db *gorm.DB, business models.Business) ([]models.DuplicateCandidate, error) {
+
+	candidates := FindDuplicates(db, business)
+	if len(candidates) == 0 {
+		return candidates, nil
+	}
+
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "business_id"}, {Name: "duplicate_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"score", "reasons", "distance", "updated_at"}),
+	}).Create(&candidates).Error
+
+	return candidates, err
+}
+
+// ScanDuplicates find the duplicate candidates of all the businesses, returns the number of pairs found
+func ScanDuplicates(db *gorm.DB) (int, error) {
+
+	found := 0
+	var businesses []models.Business
+
+	result := db.Select("id", "name", "phone", "email", "nid", "tax_id").
+		Where("not ('merged' = any(coalesce(flags, '{}')))").
+		FindInBatches(&businesses, 200, func(tx *gorm.DB, batch int) error {
+			for _, business := range businesses {
+				candidates, err := SaveDuplicates(db, business)
+				if err != nil {
+					return err
+				}
+				found += len(candidates)
+			}
+			return nil
+		})
+
+	return found, result.Error
+}
+
+// MergeBusinesses move the locations, team, customers, configs, contacts and assets of the source
+// business to the target in one transaction and disable the source. The returned record lists
+// everything that was moved so UndoMerge can put it back.
+func MergeBusinesses(db *gorm.DB, targetId, sourceId, userId uint) (*models.BusinessMerge, error) {
+
+	if targetId == sourceId {
+		return nil, ErrSameBusiness
+	}
+
+	tx := db.Clauses(dbresolver.Write).Begin()
+
+	var source, target models.Business
+	if err := tx.Select("id", "enabled", "flags").First(&source, sourceId).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Select("id", "flags").First(&target, targetId).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if slices.Contains(source.Flags, "merged") || slices.Contains(target.Flags, "merged") {
+		tx.Rollback()
+		return nil, ErrAlreadyMerged
+	}
+
+	moved := map[string][]uint{}
+	for _, ref := range mergeReferences {
+		table, column, _ := strings.Cut(ref.Key, ".")
+
+		sql := fmt.Sprintf("update %s set %s = @target where %s = @source", table, column, column)
+		if ref.Condition != "" {
+			sql += " and " + ref.Condition
+		}
+
+		var ids []uint
+		if err := tx.Raw(sql+" returning id", map[string]interface{}{"target": targetId, "source": sourceId}).Scan(&ids).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		if len(ids) > 0 {
+			moved[ref.Key] = ids
+		}
+	}
+
+	movedJson, _ := json.Marshal(moved)
+
+	merge := models.BusinessMerge{
+		TargetId:      targetId,
+		SourceId:      sourceId,
+		MergedBy:      userId,
+		Moved:         movedJson,
+		SourceEnabled: source.Enabled,
+		SourceFlags:   source.Flags,
+	}
+	if err := tx.Create(&merge).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Exec("update businesses set enabled = false, flags = array_append(coalesce(flags, '{}'), 'merged') where id = ?", sourceId).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	tx.Model(&models.DuplicateCandidate{}).
+		Where("(business_id = ? and duplicate_id = ?) or (business_id = ? and duplicate_id = ?)", targetId, sourceId, sourceId, targetId).
+		Update("status", "merged")
+
+	tx.Exec("delete from data_caches where business_id in ?", []uint{targetId, sourceId})
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	return &merge, nil
+}
+
+// UndoMerge move back everything a merge moved to the target that still belongs to it and restore the source business
+func UndoMerge(db *gorm.DB, mergeId, userId uint) (*models.BusinessMerge, error) {
+
+	tx := db.Clauses(dbresolver.Write).Begin()
+
+	var merge models.BusinessMerge
+	if err := tx.First(&merge, mergeId).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if merge.UndoneAt != nil {
+		tx.Rollback()
+		return nil, ErrMergeUndone
+	}
+
+	moved := map[string][]uint{}
+	if err := json.Unmarshal(merge.Moved, &moved); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	for _, ref := range mergeReferences {
+		ids := moved[ref.Key]
+		if len(ids) == 0 {
+			continue
+		}
+
+		table, column, _ := strings.Cut(ref.Key, ".")
+		sql := fmt.Sprintf("update %s set %s = ? where %s = ? and id in ?", table, column, column)
+		if err := tx.Exec(sql, merge.SourceId, merge.TargetId, ids).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if err := tx.Model(&models.Business{}).Where("id = ?", merge.SourceId).
+		Updates(map[string]interface{}{"enabled": merge.SourceEnabled, "flags": merge.SourceFlags}).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	tx.Model(&models.DuplicateCandidate{}).
+		Where("(business_id = ? and duplicate_id = ?) or (business_id = ? and duplicate_id = ?)", merge.TargetId, merge.SourceId, merge.SourceId, merge.TargetId).
+		Update("status", "open")
+
+	tx.Exec("delete from data_caches where business_id in ?", []uint{merge.TargetId, merge.SourceId})
+
+	now := time.Now()
+	merge.UndoneAt = &now
+	merge.UndoneBy = userId
+	if err := tx.Save(&merge).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	return &merge, nil
+}
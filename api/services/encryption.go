@@ -0,0 +1,120 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"myproject/api/database"
+	"myproject/api/models"
+	"myproject/api/utils"
+
+	"gorm.io/gorm"
+)
+
+// the columns of models.EncryptedString fields, by table
+var EncryptedColumns = map[string][]string{
+	"businesses": {"nid", "tax_id", "sort_code", "account_number"},
+	"users":      {"unlock_token"},
+	"data_items": {"value"},
+}
+
+// the blind indexes of encrypted columns, by table.column, written with the column
+var encryptedIndexes = map[string]struct {
+	Column    string
+	Normalize func(string) string
+}{
+	"businesses.nid":     {"nid_index", utils.NormalizeTaxId},
+	"businesses.tax_id":  {"tax_id_index", utils.NormalizeTaxId},
+	"users.unlock_token": {"unlock_token_index", strings.TrimSpace},
+}
+
+// reencryptBatch how many rows are rewritten per query
+const reencryptBatch = 200
+
+// ReencryptAll rewrite the encrypted columns that are in plaintext or under a master key other than the active one,
+// and fill in missing blind indexes. Run after adding a key and making it active, the old key can be removed
+// once it reports nothing left to do. Rows that cannot be decrypted are reported and skipped.
+func ReencryptAll(db *gorm.DB) (int, []error) {
+
+	active := utils.ActiveEncryptionKey()
+	if active == "" {
+		return 0, nil
+	}
+
+	db = database.WithoutTenant(db)
+	current := utils.EncryptedPrefix + active + ":%"
+	count := 0
+	errs := []error{}
+
+	for table, columns := range EncryptedColumns {
+
+		conditions := []string{}
+		for _, column := range columns {
+			conditions = append(conditions, fmt.Sprintf("(coalesce(%s, '') <> '' and %s not like @current)", column, column))
+			if index, ok := encryptedIndexes[table+"."+column]; ok {
+				conditions = append(conditions, fmt.Sprintf("(coalesce(%s, '') <> '' and coalesce(%s, '') = '')", column, index.Column))
+			}
+		}
+		where := "id > @after and (" + strings.Join(conditions, " or ") + ")"
+
+		var after uint
+		for {
+			rows, err := db.Table(table).Select(append([]string{"id"}, columns...)).
+				Where(where, map[string]interface{}{"after": after, "current": current}).
+				Order("id").Limit(reencryptBatch).Rows()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", table, err))
+				break
+			}
+
+			type pending struct {
+				id     uint
+				values []models.EncryptedString
+			}
+			batch := []pending{}
+			read := 0
+			for rows.Next() {
+				read++
+				var id uint
+				values := make([]models.EncryptedString, len(columns))
+				dest := []interface{}{&id}
+				for i := range values {
+					dest = append(dest, &values[i])
+				}
+				if err := rows.Scan(dest...); err != nil {
+					// the id is scanned first so the row can be skipped
+					errs = append(errs, fmt.Errorf("%s %d: %w", table, id, err))
+					after = max(after, id)
+					continue
+				}
+				batch = append(batch, pending{id, values})
+			}
+			rows.Close()
+
+			// a batch of rows that all failed to decrypt is skipped past, not the end of the table
+			if read == 0 {
+				break
+			}
+
+			for _, row := range batch {
+				after = max(after, row.id)
+
+				updates := map[string]interface{}{}
+				for i, column := range columns {
+					updates[column] = row.values[i]
+					if index, ok := encryptedIndexes[table+"."+column]; ok {
+						updates[index.Column] = models.BlindIndexString(utils.BlindIndex(index.Normalize(string(row.values[i]))))
+					}
+				}
+
+				if err := db.Table(table).Where("id = ?", row.id).Updates(updates).Error; err != nil {
+					errs = append(errs, fmt.Errorf("%s %d: %w", table, row.id, err))
+					continue
+				}
+				count++
+			}
+		}
+	}
+
+	return count, errs
+}
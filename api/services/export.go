@@ -0,0 +1,457 @@
+package services
+
+import (
+	"archive/zip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"myproject/api/database"
+	"myproject/api/models"
+	"myproject/api/utils"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+// ExportVersion is the version of the bundle layout written in manifest.json
+const ExportVersion = 1
+
+// the datasets of a business export, in the order they are restored by ImportBundle
+var exportDatasets = []struct {
+	Name  string
+	New   func() interface{}
+	Where string
+}{
+	{"locations", func() interface{} { return &[]models.Location{} }, "business_id = ?"},
+	{"areas", func() interface{} { return &[]models.LocationArea{} }, "business_id = ?"},
+	{"roles", func() interface{} { return &[]models.BusinessRole{} }, "business_id = ?"},
+	{"configs", func() interface{} { return &[]models.Config{} }, "business_id = ?"},
+	{"contacts", func() interface{} { return &[]models.Contact{} }, "business_id = ?"},
+	{"customers", func() interface{} { return &[]models.BusinessCustomer{} }, "business_id = ?"},
+	{"parts", func() interface{} { return &[]models.Part{} }, "business_id = ?"},
+	{"consumables", func() interface{} { return &[]models.Consumable{} }, "business_id = ?"},
+	{"bins", func() interface{} { return &[]models.Bin{} }, "business_id = ?"},
+	{"stock", func() interface{} { return &[]models.BinInfo{} }, "business_id = ?"},
+	{"feedback", func() interface{} { return &[]models.Feedback{} }, "business_id = ?"},
+	{"assets", func() interface{} { return &[]models.Asset{} }, "business_id = ?"},
+}
+
+// associations of the business left out of business.json, they are exported as their own datasets
+var businessAssociations = []string{"Roles", "Configs", "Contacts", "Locations", "Categories", "Customers", "Contractors", "User"}
+
+var (
+	ErrInvalidBundle  = errors.New("not a business export bundle")
+	ErrBundleTooLarge = errors.New("the export bundle is too large once uncompressed")
+)
+
+// the largest uncompressed file and total of a bundle, the upload limit only bounds the compressed size
+const (
+	maxDatasetSize = 256 << 20
+	maxBundleSize  = 1 << 30
+)
+
+// ExportSignature sign the download link of an export
+func ExportSignature(exportId uint, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(database.GetParam("JWT_SECRET")))
+	fmt.Fprintf(mac, "export:%d:%d", exportId, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ValidExportSignature check the signature of a download link in constant time
+func ValidExportSignature(exportId uint, expires int64, signature string) bool {
+	return hmac.Equal([]byte(ExportSignature(exportId, expires)), []byte(signature))
+}
+
+// exportRows convert records to JSON objects without the empty associations
+func exportRows(records interface{}, omit []string) ([]map[string]interface{}, error) {
+	data, err := json.Marshal(records)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		for key, value := range row {
+			if value == nil || slices.Contains(omit, key) {
+				delete(row, key)
+			}
+		}
+	}
+
+	return rows, nil
+}
+
+// writeDataset add <name>.json and <name>.csv to the bundle
+func writeDataset(zw *zip.Writer, name string, rows []map[string]interface{}) error {
+
+	w, err := zw.Create(name + ".json")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(rows); err != nil {
+		return err
+	}
+
+	// CSV columns are the union of the keys, sorted
+	columns := []string{}
+	for _, row := range rows {
+		for key := range row {
+			if !slices.Contains(columns, key) {
+				columns = append(columns, key)
+			}
+		}
+	}
+	sort.Strings(columns)
+
+	w, err = zw.Create(name + ".csv")
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	cw.Write(columns)
+
+	for _, row := range rows {
+		record := make([]string, len(columns))
+		for i, column := range columns {
+			switch value := row[column].(type) {
+			case nil:
+			case string:
+				record[i] = value
+			case float64, bool:
+				record[i] = fmt.Sprint(value)
+			default:
+				// nested values e.g. flags or json fields
+				data, _ := json.Marshal(value)
+				record[i] = string(data)
+			}
+		}
+		cw.Write(record)
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// BuildExport write the ZIP bundle of a business: manifest.json and a JSON and a CSV file
+// for the business and each of its datasets
+func BuildExport(db *gorm.DB, businessId uint, out io.Writer) error {
+
+	var business models.Business
+	if err := db.First(&business, businessId).Error; err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(out)
+
+	rows, err := exportRows([]models.Business{business}, businessAssociations)
+	if err != nil {
+		return err
+	}
+	// the bundle is for the owner, it has the sensitive fields the JSON responses mask
+	for key, value := range business.SensitiveFields() {
+		rows[0][key] = value
+	}
+	if err := writeDataset(zw, "business", rows); err != nil {
+		return err
+	}
+
+	counts := map[string]int{"business": 1}
+	for _, dataset := range exportDatasets {
+		records := dataset.New()
+		if err := db.Where(dataset.Where, businessId).Order("id").Find(records).Error; err != nil {
+			return err
+		}
+
+		rows, err := exportRows(records, nil)
+		if err != nil {
+			return err
+		}
+		if err := writeDataset(zw, dataset.Name, rows); err != nil {
+			return err
+		}
+		counts[dataset.Name] = len(rows)
+	}
+
+	w, err := zw.Create("manifest.json")
+	if err != nil {
+		return err
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"version":    ExportVersion,
+		"businessId": businessId,
+		"name":       business.Name,
+		"exportedAt": time.Now().UTC(),
+		"counts":     counts,
+	})
+
+	return zw.Close()
+}
+
+// readDataset decode <name>.json of a bundle into records, a missing file is an empty dataset
+func readDataset(zr *zip.Reader, name string, records interface{}) error {
+	f, err := zr.Open(name + ".json")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	if info, err := f.Stat(); err != nil || info.Size() > maxDatasetSize {
+		return ErrBundleTooLarge
+	}
+	return json.NewDecoder(io.LimitReader(f, maxDatasetSize)).Decode(records)
+}
+
+// mapId translate an exported ID to the ID of the restored record
+func mapId(ids map[uint]uint, id uint) uint {
+	if id == 0 {
+		return 0
+	}
+	return ids[id]
+}
+
+// ImportBundle restore an export bundle into a new business owned by ownerId, all the records get new IDs
+// and references between them are translated. Users and customer businesses are referenced, not copied.
+func ImportBundle(db *gorm.DB, zr *zip.Reader, ownerId uint) (*models.Business, error) {
+
+	var total uint64
+	for _, file := range zr.File {
+		total += file.UncompressedSize64
+		if file.UncompressedSize64 > maxDatasetSize || total > maxBundleSize {
+			return nil, ErrBundleTooLarge
+		}
+	}
+
+	var manifest struct {
+		Version int `json:"version"`
+	}
+	f, err := zr.Open("manifest.json")
+	if err != nil {
+		return nil, ErrInvalidBundle
+	}
+	err = json.NewDecoder(io.LimitReader(f, maxDatasetSize)).Decode(&manifest)
+	f.Close()
+	if err != nil || manifest.Version < 1 || manifest.Version > ExportVersion {
+		return nil, ErrInvalidBundle
+	}
+
+	var businesses []models.Business
+	if err := readDataset(zr, "business", &businesses); err != nil || len(businesses) != 1 {
+		return nil, ErrInvalidBundle
+	}
+
+	var locations []models.Location
+	var areas []models.LocationArea
+	var roles []models.BusinessRole
+	var configs []models.Config
+	var contacts []models.Contact
+	var customers []models.BusinessCustomer
+	var parts []models.Part
+	var consumables []models.Consumable
+	var bins []models.Bin
+	var stock []models.BinInfo
+	var feedback []models.Feedback
+	var assets []models.Asset
+
+	for name, records := range map[string]interface{}{
+		"locations": &locations, "areas": &areas, "roles": &roles, "configs": &configs,
+		"contacts": &contacts, "customers": &customers, "parts": &parts, "consumables": &consumables,
+		"bins": &bins, "stock": &stock, "feedback": &feedback, "assets": &assets,
+	} {
+		if err := readDataset(zr, name, records); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	tx := db.Clauses(dbresolver.Write).Begin()
+
+	business := businesses[0]
+	business.ID = 0
+	business.Uid = uuid.Nil
+	business.UserId = ownerId
+	business.UpdatedBy = ownerId
+	business.Flags = slices.DeleteFunc(business.Flags, func(flag string) bool { return flag == "merged" })
+
+	// keep the subdomain when it is free
+	business.Uuid = strings.ToLower(business.Uuid)
+	if SubdomainAvailable(tx, business.Uuid, 0) != nil {
+		business.Uuid = fmt.Sprintf("%s-%s", utils.NormalizeSubdomain(business.Name), utils.GenerateUUID()[0:6])
+	}
+
+	if err := tx.Omit(businessAssociations...).Create(&business).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	create := func(record interface{}) error {
+		return tx.Omit("Business", "User", "Location", "Part", "Consumable", "Bin", "Customer", "AccountManager", "Configs", "Areas", "Parts", "Consumables").Create(record).Error
+	}
+
+	locationIds := map[uint]uint{}
+	for _, loc := range locations {
+		oldId := loc.ID
+		loc.ID = 0
+		loc.Uid = uuid.Nil
+		loc.BusinessId = business.ID
+		if err := create(&loc); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		locationIds[oldId] = loc.ID
+	}
+
+	for _, area := range areas {
+		area.ID = 0
+		area.BusinessId = business.ID
+		area.LocationId = mapId(locationIds, area.LocationId)
+		if err := create(&area); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	for _, role := range roles {
+		role.ID = 0
+		role.BusinessId = business.ID
+		role.LocationId = mapId(locationIds, role.LocationId)
+		if err := create(&role); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	for _, cfg := range configs {
+		cfg.ID = 0
+		cfg.BusinessId = business.ID
+		cfg.LocationId = mapId(locationIds, cfg.LocationId)
+		if err := create(&cfg); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	for _, contact := range contacts {
+		contact.ID = 0
+		contact.Uuid = uuid.Nil
+		contact.BusinessId = business.ID
+		contact.LocationId = mapId(locationIds, contact.LocationId)
+		if err := create(&contact); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	for _, customer := range customers {
+		// a bundle can name any business, only the customers the importer owns are linked again
+		if !IsBusinessOwner(database.WithoutTenant(tx), ownerId, customer.CustomerId) {
+			continue
+		}
+		customer.ID = 0
+		customer.BusinessId = business.ID
+		if err := create(&customer); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	partIds := map[uint]uint{}
+	for _, part := range parts {
+		oldId := part.ID
+		part.ID = 0
+		part.BusinessId = business.ID
+		if err := create(&part); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		partIds[oldId] = part.ID
+	}
+
+	consumableIds := map[uint]uint{}
+	for _, consumable := range consumables {
+		oldId := consumable.ID
+		consumable.ID = 0
+		consumable.BusinessId = business.ID
+		if err := create(&consumable); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		consumableIds[oldId] = consumable.ID
+	}
+
+	// bins are nested, create them all then point them at their new parents
+	binIds := map[uint]uint{}
+	for i := range bins {
+		oldId := bins[i].ID
+		bins[i].ID = 0
+		bins[i].BusinessId = business.ID
+		bins[i].LocationId = mapId(locationIds, bins[i].LocationId)
+		parentId := bins[i].BinId
+		bins[i].BinId = 0
+		if err := create(&bins[i]); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		bins[i].BinId = parentId
+		binIds[oldId] = bins[i].ID
+	}
+	for _, bin := range bins {
+		if bin.BinId > 0 {
+			tx.Model(&models.Bin{}).Where("id = ?", bin.ID).Update("bin_id", mapId(binIds, bin.BinId))
+		}
+	}
+
+	for _, info := range stock {
+		info.ID = 0
+		info.BusinessId = business.ID
+		info.LocationId = mapId(locationIds, info.LocationId)
+		info.BinId = mapId(binIds, info.BinId)
+		info.PartId = mapId(partIds, info.PartId)
+		info.ConsumableId = mapId(consumableIds, info.ConsumableId)
+		if err := create(&info); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	for _, item := range feedback {
+		item.ID = 0
+		item.BusinessId = business.ID
+		item.LocationId = mapId(locationIds, item.LocationId)
+		if err := create(&item); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	for _, asset := range assets {
+		asset.ID = 0
+		asset.BusinessId = business.ID
+		asset.LocationId = mapId(locationIds, asset.LocationId)
+		if err := create(&asset); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	return &business, nil
+}
@@ -0,0 +1,277 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"myproject/api/database"
+	"myproject/api/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// the system params defining the feature flags, flag_<name>: on, off or a rollout percentage e.g. 25%
+const featureParamPrefix = "flag_"
+
+// the entries of a Flags array overriding a feature flag, "feature:<name>" turns it on, "-feature:<name>" off
+const featureOnPrefix = "feature:"
+const featureOffPrefix = "-feature:"
+
+// flagCacheTTL how long the Flags array of a business, location or user is cached
+const flagCacheTTL = 5 * time.Minute
+
+// FlagTables the tables whose Flags arrays can override a feature flag, by kind
+var FlagTables = map[string]string{
+	"business": "businesses",
+	"location": "locations",
+	"user":     "users",
+}
+
+var featureNameRegex = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+var ErrInvalidFeature = errors.New("feature names are lower case letters, digits and underscores")
+
+// flagCache caches the Flags arrays, nil to read them from the database every time
+var flagCache *redis.Client
+
+// UseFlagCache cache the Flags arrays read by the feature flags in redis
+func UseFlagCache(rdb *redis.Client) {
+	flagCache = rdb
+}
+
+// FeatureFlag a feature flag defined in the system params
+type FeatureFlag struct {
+	Name    string `json:"name"`
+	Rollout int    `json:"rollout"` // percentage of businesses, or users when there is no business, that have the flag
+}
+
+// FlagSubject who a feature flag is evaluated for, the user overrides the location which overrides the business
+type FlagSubject struct {
+	BusinessId uint
+	LocationId uint
+	UserId     uint
+}
+
+// parseRollout read the value of a flag_ param as a percentage
+func parseRollout(value string) int {
+	value = strings.TrimSpace(strings.ToLower(value))
+
+	switch value {
+	case "on", "true", "yes":
+		return 100
+	case "off", "false", "no", "":
+		return 0
+	}
+
+	percent, err := strconv.Atoi(strings.TrimSuffix(value, "%"))
+	if err != nil {
+		return 0
+	}
+	return max(0, min(100, percent))
+}
+
+// GlobalFlags the feature flags defined by the flag_<name> system params
+func GlobalFlags() map[string]FeatureFlag {
+	flags := map[string]FeatureFlag{}
+	for key, value := range database.SystemParams {
+		if name, ok := strings.CutPrefix(strings.ToLower(key), featureParamPrefix); ok && name != "" {
+			flags[name] = FeatureFlag{Name: name, Rollout: parseRollout(value)}
+		}
+	}
+	return flags
+}
+
+// rolloutBucket place an ID in one of 100 buckets, stable for a flag and different across flags
+func rolloutBucket(name string, id uint) int {
+	h := fnv.New32a()
+	h.Write([]byte(fmt.Sprintf("%s:%d", name, id)))
+	return int(h.Sum32() % 100)
+}
+
+// flagOverride the state a Flags array gives a feature, ok is false when it leaves it alone
+func flagOverride(flags []string, name string) (enabled bool, ok bool) {
+	for _, flag := range flags {
+		switch flag {
+		case featureOnPrefix + name:
+			return true, true
+		case featureOffPrefix + name:
+			return false, true
+		}
+	}
+	return false, false
+}
+
+func flagCacheKey(kind string, id uint) string {
+	return fmt.Sprintf("flags:%s:%d", kind, id)
+}
+
+// FlagsOf the Flags array of a business, location or user, from the cache when possible
+func FlagsOf(db *gorm.DB, kind string, id uint) []string {
+	if id == 0 {
+		return nil
+	}
+
+	ctx := context.Background()
+	key := flagCacheKey(kind, id)
+
+	if flagCache != nil {
+		if cached, err := flagCache.Get(ctx, key).Result(); err == nil {
+			var flags []string
+			if json.Unmarshal([]byte(cached), &flags) == nil {
+				return flags
+			}
+		} else if !errors.Is(err, redis.Nil) {
+			fmt.Println("flag cache", err)
+		}
+	}
+
+	var row struct {
+		Flags pq.StringArray
+	}
+	database.WithoutTenant(db).Table(FlagTables[kind]).Select("flags").Where("id = ?", id).Take(&row)
+
+	if flagCache != nil {
+		data, _ := json.Marshal([]string(row.Flags))
+		flagCache.Set(ctx, key, data, flagCacheTTL)
+	}
+
+	return row.Flags
+}
+
+// evaluateFlag the state of a flag for the subject given the Flags arrays of its user, location and business
+func evaluateFlag(name string, rollout int, subject FlagSubject, userFlags, locationFlags, businessFlags []string) bool {
+	for _, flags := range [][]string{userFlags, locationFlags, businessFlags} {
+		if enabled, ok := flagOverride(flags, name); ok {
+			return enabled
+		}
+	}
+
+	if rollout >= 100 {
+		return true
+	}
+	if rollout <= 0 {
+		return false
+	}
+
+	id := subject.BusinessId
+	if id == 0 {
+		id = subject.UserId
+	}
+	if id == 0 {
+		return false
+	}
+	return rolloutBucket(name, id) < rollout
+}
+
+// FeatureEnabled whether a feature flag is on for the subject,
+// a flag with no flag_ param is off unless a Flags array turns it on
+func FeatureEnabled(db *gorm.DB, name string, subject FlagSubject) bool {
+	return evaluateFlag(name, GlobalFlags()[name].Rollout, subject,
+		FlagsOf(db, "user", subject.UserId),
+		FlagsOf(db, "location", subject.LocationId),
+		FlagsOf(db, "business", subject.BusinessId))
+}
+
+// Features the state of every feature flag for the subject,
+// the flags defined in the system params and the ones turned on by an override
+func Features(db *gorm.DB, subject FlagSubject) map[string]bool {
+	userFlags := FlagsOf(db, "user", subject.UserId)
+	locationFlags := FlagsOf(db, "location", subject.LocationId)
+	businessFlags := FlagsOf(db, "business", subject.BusinessId)
+
+	global := GlobalFlags()
+	for _, flags := range [][]string{userFlags, locationFlags, businessFlags} {
+		for _, flag := range flags {
+			if name, ok := strings.CutPrefix(flag, featureOnPrefix); ok {
+				if _, defined := global[name]; !defined {
+					global[name] = FeatureFlag{Name: name}
+				}
+			}
+		}
+	}
+
+	features := map[string]bool{}
+	for name, flag := range global {
+		features[name] = evaluateFlag(name, flag.Rollout, subject, userFlags, locationFlags, businessFlags)
+	}
+	return features
+}
+
+// requestFlagSubject the business the request was scoped to by RequireTenant and its user
+func requestFlagSubject(c *fiber.Ctx) FlagSubject {
+	subject := FlagSubject{UserId: TenantUserID(c)}
+	if user, ok := c.Locals("currentUser").(models.User); ok && subject.UserId == 0 {
+		subject.UserId = user.ID
+	}
+	subject.BusinessId, _ = TenantID(c)
+	return subject
+}
+
+// RequestFeatures the feature flags of the request, pass them to c.Render to show template sections
+// e.g. {{if .Features.new_checkout}}
+func RequestFeatures(db *gorm.DB, c *fiber.Ctx) map[string]bool {
+	return Features(db, requestFlagSubject(c))
+}
+
+// RequireFeature gate a route behind a feature flag, responds not found when it is off.
+// Put it after RequireTenant so the flag is evaluated for the business of the request.
+func RequireFeature(db *gorm.DB, name string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !FeatureEnabled(db, name, requestFlagSubject(c)) {
+			return c.Status(fiber.StatusNotFound).SendString("Cannot " + c.Method() + " " + c.Path())
+		}
+		return c.Next()
+	}
+}
+
+// SetFeatureOverride turn a feature flag on or off for a business, location or user,
+// or back to the global default with state "default"
+func SetFeatureOverride(db *gorm.DB, kind string, id uint, name, state string) ([]string, error) {
+	table, ok := FlagTables[kind]
+	if !ok {
+		return nil, fmt.Errorf("feature flags cannot be set for a %s", kind)
+	}
+	if !featureNameRegex.MatchString(name) {
+		return nil, ErrInvalidFeature
+	}
+
+	// drop any override of the flag then add the new one
+	flags := "array_remove(array_remove(coalesce(flags, '{}'), @on), @off)"
+	switch state {
+	case "on":
+		flags = "array_append(" + flags + ", @on)"
+	case "off":
+		flags = "array_append(" + flags + ", @off)"
+	case "default":
+	default:
+		return nil, errors.New("state must be on, off or default")
+	}
+
+	var rows []struct {
+		Flags pq.StringArray
+	}
+	result := database.WithoutTenant(db).Raw(fmt.Sprintf("update %s set flags = %s where id = @id returning flags", table, flags),
+		map[string]interface{}{"on": featureOnPrefix + name, "off": featureOffPrefix + name, "id": id}).Scan(&rows)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if len(rows) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	if flagCache != nil {
+		flagCache.Del(context.Background(), flagCacheKey(kind, id))
+	}
+
+	return rows[0].Flags, nil
+}
@@ -0,0 +1,565 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"myproject/api/database"
+	"myproject/api/models"
+	"myproject/api/utils"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GeocodeResult a position and the address a geocoder gave for it
+type GeocodeResult struct {
+	Lat              float64 `json:"lat"`
+	Lng              float64 `json:"lng"`
+	Latlng           string  `json:"latlng"` // as stored in the Latlng of a location
+	FormattedAddress string  `json:"formattedAddress"`
+	Country          string  `json:"country"` // ISO 3166 alpha-2 code
+	Provider         string  `json:"provider"`
+	Cached           bool    `json:"cached"`
+}
+
+// Geocoder turns addresses into positions and back
+type Geocoder interface {
+	Name() string
+	Geocode(ctx context.Context, address string) (GeocodeResult, error)
+	Reverse(ctx context.Context, lat, lng float64) (GeocodeResult, error)
+}
+
+var ErrGeocodeNotFound = errors.New("no results found")
+
+// geocodeNotFoundTTL how long a query without a result is cached before the provider is asked again
+const geocodeNotFoundTTL = 30 * 24 * time.Hour
+
+var geocodeClient = &http.Client{Timeout: 10 * time.Second}
+
+func newGeocodeResult(provider string, lat, lng float64, address, country string) GeocodeResult {
+	return GeocodeResult{
+		Lat:              lat,
+		Lng:              lng,
+		Latlng:           fmt.Sprintf("%f,%f", lat, lng),
+		FormattedAddress: address,
+		Country:          strings.ToUpper(country),
+		Provider:         provider,
+	}
+}
+
+func getGeocodeJSON(ctx context.Context, uri string, userAgent string, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+	if err != nil {
+		return err
+	}
+	if userAgent != "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+
+	resp, err := geocodeClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("geocoder responded %s", resp.Status)
+	}
+
+	return json.Unmarshal(body, result)
+}
+
+// GoogleGeocoder the Google Maps geocoding API
+type GoogleGeocoder struct {
+	Key     string
+	BaseURL string // https://maps.googleapis.com/maps/api/geocode/json by default
+}
+
+func (g GoogleGeocoder) Name() string {
+	return "google"
+}
+
+type googleGeocodeResponse struct {
+	Status       string `json:"status"`
+	ErrorMessage string `json:"error_message"`
+	Results      []struct {
+		FormattedAddress  string `json:"formatted_address"`
+		AddressComponents []struct {
+			ShortName string   `json:"short_name"`
+			Types     []string `json:"types"`
+		} `json:"address_components"`
+		Geometry struct {
+			Location struct {
+				Lat float64 `json:"lat"`
+				Lng float64 `json:"lng"`
+			} `json:"location"`
+		} `json:"geometry"`
+	} `json:"results"`
+}
+
+func (g GoogleGeocoder) query(ctx context.Context, params url.Values) (GeocodeResult, error) {
+	if g.Key == "" {
+		return GeocodeResult{}, errors.New("no google maps api key found in database")
+	}
+
+	base := g.BaseURL
+	if base == "" {
+		base = "https://maps.googleapis.com/maps/api/geocode/json"
+	}
+	params.Set("key", g.Key)
+
+	var resp googleGeocodeResponse
+	if err := getGeocodeJSON(ctx, base+"?"+params.Encode(), "", &resp); err != nil {
+		return GeocodeResult{}, err
+	}
+
+	switch resp.Status {
+	case "OK":
+	case "ZERO_RESULTS":
+		return GeocodeResult{}, ErrGeocodeNotFound
+	default:
+		return GeocodeResult{}, fmt.Errorf("google geocoder %s %s", resp.Status, resp.ErrorMessage)
+	}
+	if len(resp.Results) == 0 {
+		return GeocodeResult{}, ErrGeocodeNotFound
+	}
+
+	first := resp.Results[0]
+	country := ""
+	for _, component := range first.AddressComponents {
+		for _, t := range component.Types {
+			if t == "country" {
+				country = component.ShortName
+			}
+		}
+	}
+
+	return newGeocodeResult(g.Name(), first.Geometry.Location.Lat, first.Geometry.Location.Lng, first.FormattedAddress, country), nil
+}
+
+func (g GoogleGeocoder) Geocode(ctx context.Context, address string) (GeocodeResult, error) {
+	return g.query(ctx, url.Values{"address": {address}})
+}
+
+func (g GoogleGeocoder) Reverse(ctx context.Context, lat, lng float64) (GeocodeResult, error) {
+	return g.query(ctx, url.Values{"latlng": {fmt.Sprintf("%f,%f", lat, lng)}})
+}
+
+// NominatimGeocoder the OpenStreetMap Nominatim API, the public server allows one request per second
+// and requires a user agent identifying the application
+type NominatimGeocoder struct {
+	BaseURL   string // https://nominatim.openstreetmap.org by default
+	UserAgent string
+}
+
+func (g NominatimGeocoder) Name() string {
+	return "nominatim"
+}
+
+type nominatimPlace struct {
+	Lat         string `json:"lat"`
+	Lon         string `json:"lon"`
+	DisplayName string `json:"display_name"`
+	Error       string `json:"error"`
+	Address     struct {
+		CountryCode string `json:"country_code"`
+	} `json:"address"`
+}
+
+func (g NominatimGeocoder) result(place nominatimPlace) (GeocodeResult, error) {
+	if place.Error != "" || place.Lat == "" {
+		return GeocodeResult{}, ErrGeocodeNotFound
+	}
+	lat, err := strconv.ParseFloat(place.Lat, 64)
+	if err != nil {
+		return GeocodeResult{}, err
+	}
+	lng, err := strconv.ParseFloat(place.Lon, 64)
+	if err != nil {
+		return GeocodeResult{}, err
+	}
+	return newGeocodeResult(g.Name(), lat, lng, place.DisplayName, place.Address.CountryCode), nil
+}
+
+func (g NominatimGeocoder) baseURL() string {
+	if g.BaseURL == "" {
+		return "https://nominatim.openstreetmap.org"
+	}
+	return strings.TrimSuffix(g.BaseURL, "/")
+}
+
+func (g NominatimGeocoder) Geocode(ctx context.Context, address string) (GeocodeResult, error) {
+	params := url.Values{"q": {address}, "format": {"jsonv2"}, "addressdetails": {"1"}, "limit": {"1"}}
+
+	var places []nominatimPlace
+	if err := getGeocodeJSON(ctx, g.baseURL()+"/search?"+params.Encode(), g.UserAgent, &places); err != nil {
+		return GeocodeResult{}, err
+	}
+	if len(places) == 0 {
+		return GeocodeResult{}, ErrGeocodeNotFound
+	}
+	return g.result(places[0])
+}
+
+func (g NominatimGeocoder) Reverse(ctx context.Context, lat, lng float64) (GeocodeResult, error) {
+	params := url.Values{"lat": {fmt.Sprintf("%f", lat)}, "lon": {fmt.Sprintf("%f", lng)}, "format": {"jsonv2"}}
+
+	var place nominatimPlace
+	if err := getGeocodeJSON(ctx, g.baseURL()+"/reverse?"+params.Encode(), g.UserAgent, &place); err != nil {
+		return GeocodeResult{}, err
+	}
+	return g.result(place)
+}
+
+// OfflineGeocoder answers from a fixed list of places, for tests and development without network access
+type OfflineGeocoder struct {
+	Places []OfflinePlace
+}
+
+// OfflinePlace an entry of the fixture file of the offline geocoder
+type OfflinePlace struct {
+	Address          string  `json:"address"`
+	Lat              float64 `json:"lat"`
+	Lng              float64 `json:"lng"`
+	FormattedAddress string  `json:"formattedAddress"`
+	Country          string  `json:"country"`
+}
+
+// offlineReverseRadius how far from a place a reverse lookup still finds it, in metres
+const offlineReverseRadius = 1000
+
+// NewOfflineGeocoder load the places of a JSON fixture file, an array of OfflinePlace
+func NewOfflineGeocoder(path string) (*OfflineGeocoder, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &OfflineGeocoder{}
+	if err := json.Unmarshal(data, &g.Places); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func (g *OfflineGeocoder) Name() string {
+	return "offline"
+}
+
+func (g *OfflineGeocoder) result(place OfflinePlace) GeocodeResult {
+	address := place.FormattedAddress
+	if address == "" {
+		address = place.Address
+	}
+	return newGeocodeResult(g.Name(), place.Lat, place.Lng, address, place.Country)
+}
+
+func (g *OfflineGeocoder) Geocode(ctx context.Context, address string) (GeocodeResult, error) {
+	query := NormalizeGeocodeQuery(address)
+	for _, place := range g.Places {
+		if NormalizeGeocodeQuery(place.Address) == query {
+			return g.result(place), nil
+		}
+	}
+	return GeocodeResult{}, ErrGeocodeNotFound
+}
+
+func (g *OfflineGeocoder) Reverse(ctx context.Context, lat, lng float64) (GeocodeResult, error) {
+	nearest := -1
+	distance := math.MaxFloat64
+	for i, place := range g.Places {
+		if d := utils.HaversineDistance(lat, lng, place.Lat, place.Lng); d < distance {
+			nearest, distance = i, d
+		}
+	}
+	if nearest < 0 || distance > offlineReverseRadius {
+		return GeocodeResult{}, ErrGeocodeNotFound
+	}
+	return g.result(g.Places[nearest]), nil
+}
+
+var geocoderMutex sync.Mutex
+var geocoder Geocoder
+
+// UseGeocoder replace the geocoder chosen from the params, nil to go back to the params
+func UseGeocoder(g Geocoder) {
+	geocoderMutex.Lock()
+	defer geocoderMutex.Unlock()
+	geocoder = g
+}
+
+// CurrentGeocoder the geocoder set by UseGeocoder, otherwise the GEOCODER param: google, nominatim or offline
+// with the fixtures in GEOCODER_FIXTURES. Google is used when there is a GOOGLE_MAPS_API_KEY, Nominatim otherwise.
+func CurrentGeocoder() (Geocoder, error) {
+	geocoderMutex.Lock()
+	defer geocoderMutex.Unlock()
+
+	if geocoder != nil {
+		return geocoder, nil
+	}
+
+	key := database.GetParam("GOOGLE_MAPS_API_KEY")
+	name := database.GetParam("GEOCODER")
+	if name == "" {
+		name = "nominatim"
+		if key != "" {
+			name = "google"
+		}
+	}
+
+	switch name {
+	case "google":
+		return GoogleGeocoder{Key: key}, nil
+	case "nominatim":
+		agent := database.GetParam("NOMINATIM_USER_AGENT")
+		if agent == "" {
+			agent = "myproject geocoder"
+		}
+		return NominatimGeocoder{BaseURL: database.GetParam("NOMINATIM_URL"), UserAgent: agent}, nil
+	case "offline":
+		g, err := NewOfflineGeocoder(database.GetParam("GEOCODER_FIXTURES"))
+		if err != nil {
+			return nil, err
+		}
+		// the fixtures are only read once
+		geocoder = g
+		return g, nil
+	}
+
+	return nil, fmt.Errorf("unknown geocoder %s", name)
+}
+
+// GeocodeInterval the time to wait between two requests to the provider in a batch,
+// the GEOCODE_RATE param in requests per second or the limit of the provider
+func GeocodeInterval(g Geocoder) time.Duration {
+	rate, _ := strconv.ParseFloat(database.GetParam("GEOCODE_RATE"), 64)
+	if rate <= 0 {
+		switch g.Name() {
+		case "offline":
+			return 0
+		case "google":
+			rate = 10
+		default:
+			rate = 1
+		}
+	}
+	return time.Duration(float64(time.Second) / rate)
+}
+
+var geocodePunctuation = regexp.MustCompile(`[^\p{L}\p{N},#-]+`)
+
+// NormalizeGeocodeQuery the cache key of an address: lower case, without accents, punctuation or repeated spaces
+func NormalizeGeocodeQuery(address string) string {
+	address = strings.ToLower(utils.RemoveAccents(address))
+	address = geocodePunctuation.ReplaceAllString(address, " ")
+
+	parts := []string{}
+	for _, part := range strings.Split(address, ",") {
+		if part = strings.Join(strings.Fields(part), " "); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func reverseGeocodeQuery(lat, lng float64) string {
+	return fmt.Sprintf("%.5f,%.5f", lat, lng)
+}
+
+// cachedGeocode answer from the cache or ask the geocoder and cache its answer,
+// answers without a result are cached too and asked again after geocodeNotFoundTTL
+func cachedGeocode(db *gorm.DB, kind, query string, lookup func(g Geocoder) (GeocodeResult, error)) (GeocodeResult, error) {
+
+	var cached models.GeocodeCache
+	db.Where("kind = ? and query = ?", kind, query).Take(&cached)
+	if cached.ID > 0 && (cached.Found || time.Since(cached.UpdatedAt) < geocodeNotFoundTTL) {
+		if !cached.Found {
+			return GeocodeResult{Provider: cached.Provider, Cached: true}, ErrGeocodeNotFound
+		}
+		result := newGeocodeResult(cached.Provider, cached.Lat, cached.Lng, cached.FormattedAddress, cached.Country)
+		result.Cached = true
+		return result, nil
+	}
+
+	g, err := CurrentGeocoder()
+	if err != nil {
+		return GeocodeResult{}, err
+	}
+
+	result, err := lookup(g)
+	if err != nil && !errors.Is(err, ErrGeocodeNotFound) {
+		// provider errors are not cached
+		return GeocodeResult{Provider: g.Name()}, err
+	}
+
+	entry := models.GeocodeCache{
+		Kind:             kind,
+		Query:            query,
+		Provider:         g.Name(),
+		Found:            err == nil,
+		Lat:              result.Lat,
+		Lng:              result.Lng,
+		FormattedAddress: result.FormattedAddress,
+		Country:          result.Country,
+	}
+	db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "kind"}, {Name: "query"}},
+		DoUpdates: clause.AssignmentColumns([]string{"provider", "found", "lat", "lng", "formatted_address", "country", "updated_at"}),
+	}).Create(&entry)
+
+	result.Provider = g.Name()
+	return result, err
+}
+
+// Geocode the position of an address, from the cache when it was looked up before
+func Geocode(db *gorm.DB, address string) (GeocodeResult, error) {
+	query := NormalizeGeocodeQuery(address)
+	if query == "" {
+		return GeocodeResult{}, ErrGeocodeNotFound
+	}
+
+	return cachedGeocode(db, "forward", query, func(g Geocoder) (GeocodeResult, error) {
+		return g.Geocode(context.Background(), address)
+	})
+}
+
+// ReverseGeocode the address at a position, from the cache when a position within about a metre was looked up before
+func ReverseGeocode(db *gorm.DB, lat, lng float64) (GeocodeResult, error) {
+	if lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+		return GeocodeResult{}, errors.New("invalid latitude or longitude")
+	}
+
+	return cachedGeocode(db, "reverse", reverseGeocodeQuery(lat, lng), func(g Geocoder) (GeocodeResult, error) {
+		return g.Reverse(context.Background(), lat, lng)
+	})
+}
+
+// GeocodeProgress how far the geocoding of the locations of a business has got
+type GeocodeProgress struct {
+	BusinessId uint  `json:"businessId"`
+	Total      int64 `json:"total"` // locations without a latlng when the batch started
+	Done       int   `json:"done"`
+	Found      int   `json:"found"`
+	Failed     int   `json:"failed"`
+	LastId     uint  `json:"lastId"` // the next batch starts after this location
+	Finished   bool  `json:"finished"`
+}
+
+// GeocodeChannel the websocket channel the progress of the geocoding of a business is published on
+func GeocodeChannel(businessId uint) string {
+	return fmt.Sprintf("geocode-%d", businessId)
+}
+
+// LocationAddress the address of a location as given to a geocoder
+func LocationAddress(loc models.Location) string {
+	parts := []string{}
+	for _, part := range []string{loc.Address, loc.City, loc.Province, loc.Zipcode, loc.Country} {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// missingLatlng the locations of a business without a position
+func missingLatlng(db *gorm.DB, businessId, afterId uint) *gorm.DB {
+	return db.Model(&models.Location{}).Where("business_id = ? and (latlng is null or latlng = '') and id > ?", businessId, afterId)
+}
+
+// QueueGeocodeTask schedule the geocoding of the locations of a business without a latlng,
+// returns how many there are
+func QueueGeocodeTask(db *gorm.DB, userId, businessId uint) (int64, error) {
+	var count int64
+	missingLatlng(db, businessId, 0).Count(&count)
+	if count == 0 {
+		return 0, nil
+	}
+
+	// a batch already queued for the business picks up the new locations
+	var queued int64
+	db.Model(&models.Task{}).Where("name = ? and business_id = ? and enabled != false", models.GeocodeTaskName, businessId).Count(&queued)
+	if queued > 0 {
+		return count, nil
+	}
+
+	return count, db.Create(&models.Task{
+		UserId:     userId,
+		BusinessId: businessId,
+		Name:       models.GeocodeTaskName,
+		Type:       "geocode",
+		Frequency:  "once",
+		Enabled:    true,
+		Data:       "0",
+		RunAt:      time.Now(),
+	}).Error
+}
+
+// GeocodeLocations fill the latlng of up to limit locations of a business after the location afterId,
+// waiting between the requests to the provider so its rate limit is respected.
+// report is called as the batch progresses.
+func GeocodeLocations(db *gorm.DB, businessId, afterId uint, limit int, report func(GeocodeProgress)) (GeocodeProgress, error) {
+
+	progress := GeocodeProgress{BusinessId: businessId, LastId: afterId}
+
+	g, err := CurrentGeocoder()
+	if err != nil {
+		return progress, err
+	}
+	interval := GeocodeInterval(g)
+
+	missingLatlng(db, businessId, afterId).Count(&progress.Total)
+
+	var locations []models.Location
+	missingLatlng(db, businessId, afterId).Order("id").Limit(limit).Find(&locations)
+	progress.Finished = len(locations) < limit
+
+	for i, loc := range locations {
+		result, err := Geocode(db, LocationAddress(loc))
+		if err == nil {
+			loc.Latlng = result.Latlng
+			err = db.Save(&loc).Error
+		}
+
+		if err != nil {
+			if !errors.Is(err, ErrGeocodeNotFound) {
+				fmt.Println("GeocodeLocations", loc.ID, err)
+			}
+			progress.Failed++
+		} else {
+			progress.Found++
+		}
+		progress.Done++
+		progress.LastId = loc.ID
+
+		if report != nil && (progress.Done%10 == 0 || i == len(locations)-1) {
+			report(progress)
+		}
+
+		if !result.Cached && i < len(locations)-1 {
+			time.Sleep(interval)
+		}
+	}
+
+	if report != nil && len(locations) == 0 {
+		report(progress)
+	}
+
+	return progress, nil
+}
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"myproject/api/models"
+
+	"gorm.io/gorm"
+)
+
+// maxGeofenceRadius the largest geofence in metres, locations further away are not looked at
+const maxGeofenceRadius = 1000
+
+// a technician leaves a location when further than its radius times this, so a position
+// jittering on the edge of the geofence does not check them in and out
+const geofenceExitMargin = 1.25
+
+// positions less accurate than this, in metres, are stored but do not check in or out
+const maxPositionAccuracy = 200
+
+// BusinessChannel the websocket channel of the events of a business
+func BusinessChannel(businessId uint) string {
+	return fmt.Sprintf("business-%d", businessId)
+}
+
+// geofenceSite a location near a position the user works at
+type geofenceSite struct {
+	LocationId         uint
+	BusinessId         uint // the business the user works for
+	LocationBusinessId uint // the business of the location, the same or a customer
+	Distance           float64
+}
+
+// geofenceSites the locations within maxGeofenceRadius of the position of the businesses
+// the user owns or is in the team of, and of their customers
+func geofenceSites(db *gorm.DB, userId uint, lat, lng float64) ([]geofenceSite, error) {
+	var sites []geofenceSite
+
+	err := db.Raw(`with employers as (
+			select id as business_id from businesses where user_id = @user and deleted_at is null
+			union select business_id from business_roles where role_id = @user
+		), sites as (
+			select l.id, e.business_id as employer from locations l join employers e on e.business_id = l.business_id
+			union
+			select l.id, e.business_id from locations l
+			join business_customers bc on bc.customer_id = l.business_id
+			join employers e on e.business_id = bc.business_id
+		)
+		select distinct on (l.id) l.id as location_id, sites.employer as business_id, l.business_id as location_business_id,
+			ST_Distance(l.location, ST_SetSRID(ST_MakePoint(@lng, @lat), 4326)::geography) as distance
+		from sites join locations l on l.id = sites.id
+		where l.deleted_at is null and l.location is not null
+			and ST_DWithin(l.location, ST_SetSRID(ST_MakePoint(@lng, @lat), 4326)::geography, @radius)
+		order by l.id, sites.employer = l.business_id desc, sites.employer`,
+		map[string]interface{}{"user": userId, "lat": lat, "lng": lng, "radius": maxGeofenceRadius}).Scan(&sites).Error
+
+	return sites, err
+}
+
+// geofenceRadius the radius of the geofence of a location, geofence_metres of the location or its business
+func geofenceRadius(db *gorm.DB, site geofenceSite) float64 {
+	radius := BusinessSettings(db, site.LocationBusinessId, site.LocationId).Int("geofence_metres")
+	return float64(min(max(radius, 10), maxGeofenceRadius))
+}
+
+// RecordPosition store a position of a user and check them in to the locations they entered
+// and out of the locations they left. Returns the arrival and departure events.
+func RecordPosition(db *gorm.DB, userId uint, lat, lng, accuracy float64, at time.Time) ([]models.GeofenceEvent, error) {
+
+	if !validLatLng(lat, lng) {
+		return nil, fmt.Errorf("invalid position %f,%f", lat, lng)
+	}
+
+	if err := db.Create(&models.UserPosition{UserId: userId, Lat: lat, Lng: lng, Accuracy: accuracy, CreatedAt: at}).Error; err != nil {
+		return nil, err
+	}
+	if accuracy > maxPositionAccuracy {
+		return nil, nil
+	}
+
+	sites, err := geofenceSites(db, userId, lat, lng)
+	if err != nil {
+		return nil, err
+	}
+
+	events := []models.GeofenceEvent{}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var open []models.TimesheetEntry
+		tx.Find(&open, "user_id = ? and check_out is null and source = ?", userId, models.TimesheetGeofence)
+
+		openAt := map[uint]models.TimesheetEntry{}
+		for _, entry := range open {
+			openAt[entry.LocationId] = entry
+		}
+
+		event := func(kind string, businessId, locationId uint, distance float64) error {
+			e := models.GeofenceEvent{BusinessId: businessId, UserId: userId, LocationId: locationId,
+				Kind: kind, Lat: lat, Lng: lng, Distance: distance, At: at}
+			if err := tx.Create(&e).Error; err != nil {
+				return err
+			}
+			events = append(events, e)
+			return nil
+		}
+
+		near := map[uint]bool{}
+		for _, site := range sites {
+			radius := geofenceRadius(tx, site)
+
+			if _, ok := openAt[site.LocationId]; ok {
+				near[site.LocationId] = site.Distance <= radius*geofenceExitMargin
+				continue
+			}
+			if site.Distance > radius {
+				continue
+			}
+
+			entry := models.TimesheetEntry{BusinessId: site.BusinessId, UserId: userId, LocationId: site.LocationId,
+				CheckIn: at, Source: models.TimesheetGeofence}
+			if err := tx.Create(&entry).Error; err != nil {
+				return err
+			}
+			if err := event(models.GeofenceArrival, site.BusinessId, site.LocationId, site.Distance); err != nil {
+				return err
+			}
+		}
+
+		for _, entry := range open {
+			if near[entry.LocationId] {
+				continue
+			}
+			if entry.CheckIn.After(at) {
+				// an older position posted late
+				continue
+			}
+
+			checkOut := at
+			entry.CheckOut = &checkOut
+			if err := tx.Save(&entry).Error; err != nil {
+				return err
+			}
+
+			var distance float64
+			tx.Raw("select ST_Distance(location, ST_SetSRID(ST_MakePoint(?, ?), 4326)::geography) from locations where id = ?",
+				lng, lat, entry.LocationId).Scan(&distance)
+			if err := event(models.GeofenceDeparture, entry.BusinessId, entry.LocationId, distance); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return events, err
+}
+
+// PurgeOldPositions delete the positions stored before a time, the events and timesheets are kept
+func PurgeOldPositions(db *gorm.DB, before time.Time) (int64, error) {
+	result := db.Where("created_at < ?", before).Delete(&models.UserPosition{})
+	return result.RowsAffected, result.Error
+}
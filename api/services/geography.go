@@ -0,0 +1,562 @@
+package services
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"myproject/api/models"
+	"myproject/api/utils"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// the columns of the GeoNames dumps, in order, used as the keys of the records of tab separated files.
+// Comma separated files name their columns in a header line with the same names.
+var geographyColumns = map[string][]string{
+	models.GeographyCountries: {"iso", "iso3", "iso_numeric", "fips", "country", "capital", "area", "population", "continent", "tld",
+		"currency_code", "currency_name", "phone", "postal_code_format", "postal_code_regex", "languages", "geonameid", "neighbours", "equivalent_fips_code"},
+	models.GeographyStates: {"code", "name", "asciiname", "geonameid"},
+	models.GeographyCities: {"geonameid", "name", "asciiname", "alternatenames", "latitude", "longitude", "feature_class", "feature_code",
+		"country_code", "cc2", "admin1_code", "admin2_code", "admin3_code", "admin4_code", "population", "elevation", "dem", "timezone", "modification_date"},
+	models.GeographyDeletes: {"geonameid", "name", "comment"},
+}
+
+// rows written to the database at once
+const geographyBatchSize = 500
+
+// the most alternate names kept to look a city up
+const maxSearchNames = 20
+
+var ErrUnknownGeographyKind = errors.New("kind must be countries, states, cities or deletes")
+
+// GeographyOptions what to load from a dataset
+type GeographyOptions struct {
+	Country       string // ISO code of the only country to load, all when empty
+	MinPopulation int64  // cities with fewer people are skipped
+	Source        string // file name or URL, for the record of the load
+	UserId        uint
+}
+
+// GeographyKey a name in lower case without accents or repeated spaces, to compare names
+func GeographyKey(name string) string {
+	return strings.Join(strings.Fields(strings.ToLower(utils.RemoveAccents(name))), " ")
+}
+
+// LocationCountryName the name of a country as the locations store it, USA for the United States
+func LocationCountryName(code, name string) string {
+	if rules, ok := utils.CountryRulesFor(code); ok {
+		return rules.Name
+	}
+	if name == "" {
+		return code
+	}
+	return name
+}
+
+// EachGeographyRecord call fn with each record of a GeoNames tab separated dump or of a comma separated file
+// with a header, keyed by the column names. Comment lines start with #. Returns the lines that could not be read.
+func EachGeographyRecord(r io.Reader, kind string, fn func(record map[string]string) error) (int, error) {
+
+	columns, ok := geographyColumns[kind]
+	if !ok {
+		return 0, ErrUnknownGeographyKind
+	}
+
+	br := bufio.NewReaderSize(r, 64*1024)
+
+	// skip the comments to find whether the data is tab or comma separated
+	for {
+		peek, err := br.Peek(1)
+		if err != nil || peek[0] != '#' {
+			break
+		}
+		if _, err := br.ReadString('\n'); err != nil {
+			break
+		}
+	}
+	first, _ := br.Peek(4096)
+	line, _, _ := strings.Cut(string(first), "\n")
+
+	record := func(fields []string) map[string]string {
+		values := map[string]string{}
+		for i, name := range columns {
+			if i < len(fields) {
+				values[name] = strings.TrimSpace(fields[i])
+			}
+		}
+		return values
+	}
+
+	bad := 0
+	if !strings.Contains(line, "\t") && strings.Contains(line, ",") {
+		reader := csv.NewReader(br)
+		reader.LazyQuotes = true
+		reader.FieldsPerRecord = -1
+		reader.Comment = '#'
+
+		// the header names the columns
+		header, err := reader.Read()
+		if err != nil {
+			return 0, err
+		}
+		columns = []string{}
+		for _, name := range header {
+			columns = append(columns, strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), " ", "_"))
+		}
+
+		for {
+			fields, err := reader.Read()
+			if err == io.EOF {
+				return bad, nil
+			}
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				bad++
+				continue
+			} else if err != nil {
+				return bad, err
+			}
+			if err := fn(record(fields)); err != nil {
+				return bad, err
+			}
+		}
+	}
+
+	// the GeoNames dumps have no quoting, a tab always separates the fields
+	scanner := bufio.NewScanner(br)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := fn(record(strings.Split(line, "\t"))); err != nil {
+			return bad, err
+		}
+	}
+	return bad, scanner.Err()
+}
+
+// searchNames the distinct keys of the names of a city in latin script
+func searchNames(names ...string) []string {
+	keys := []string{}
+	for _, name := range names {
+		key := GeographyKey(name)
+		if key == "" || slices.Contains(keys, key) {
+			continue
+		}
+		latin := true
+		for _, r := range key {
+			if !(r >= 'a' && r <= 'z') && r != ' ' && r != '-' && r != '\'' && r != '.' {
+				latin = false
+				break
+			}
+		}
+		if latin {
+			keys = append(keys, key)
+		}
+		if len(keys) == maxSearchNames {
+			break
+		}
+	}
+	return keys
+}
+
+// validTimezone whether the IANA timezone can be loaded, remembered in checked
+func validTimezone(checked map[string]bool, name string) bool {
+	if valid, ok := checked[name]; ok {
+		return valid
+	}
+	_, err := time.LoadLocation(name)
+	checked[name] = name != "" && err == nil
+	return checked[name]
+}
+
+// asciiName the ASCII name of a dataset or the name without accents
+func asciiName(record map[string]string, name string) string {
+	if ascii := record["asciiname"]; ascii != "" {
+		return ascii
+	}
+	return utils.RemoveAccents(name)
+}
+
+// LoadGeography read a reference geography dataset into the countries, states or cities tables.
+// Loading a newer version of a dataset only writes the rows that changed, deletes remove cities.
+func LoadGeography(db *gorm.DB, kind string, r io.Reader, opts GeographyOptions) (models.GeographyLoad, error) {
+
+	opts.Country = strings.ToUpper(opts.Country)
+	load := models.GeographyLoad{UserId: opts.UserId, Kind: kind, Source: opts.Source, Country: opts.Country}
+
+	var err error
+	switch kind {
+	case models.GeographyCountries:
+		err = loadCountries(db, r, opts, &load)
+	case models.GeographyStates:
+		err = loadStates(db, r, opts, &load)
+	case models.GeographyCities, models.GeographyDeletes:
+		err = loadCities(db, kind, r, opts, &load)
+	default:
+		return load, ErrUnknownGeographyKind
+	}
+	if err != nil {
+		load.Error = err.Error()
+	} else if kind == models.GeographyCountries {
+		RegisterGeographyRules(db)
+	}
+
+	db.Create(&load)
+	return load, err
+}
+
+func loadCountries(db *gorm.DB, r io.Reader, opts GeographyOptions, load *models.GeographyLoad) error {
+
+	existing := map[string]models.Country{}
+	var countries []models.Country
+	db.Find(&countries)
+	for _, country := range countries {
+		existing[country.Code] = country
+	}
+
+	batch := []models.Country{}
+	bad, err := EachGeographyRecord(r, models.GeographyCountries, func(record map[string]string) error {
+		code := strings.ToUpper(record["iso"])
+		if len(code) != 2 {
+			load.Errors++
+			return nil
+		}
+		if opts.Country != "" && code != opts.Country {
+			load.Skipped++
+			return nil
+		}
+
+		geonameId, _ := strconv.ParseUint(record["geonameid"], 10, 64)
+		languages := []string{}
+		for _, language := range strings.Split(record["languages"], ",") {
+			if language != "" {
+				languages = append(languages, language)
+			}
+		}
+		country := models.Country{
+			Code:         code,
+			Iso3:         strings.ToUpper(record["iso3"]),
+			Numeric:      record["iso_numeric"],
+			Name:         record["country"],
+			NameAscii:    utils.RemoveAccents(record["country"]),
+			Capital:      record["capital"],
+			Continent:    record["continent"],
+			Currency:     record["currency_code"],
+			Phone:        strings.TrimPrefix(record["phone"], "+"),
+			PostalFormat: record["postal_code_format"],
+			PostalRegex:  record["postal_code_regex"],
+			Languages:    languages,
+			GeonameId:    uint(geonameId),
+		}
+
+		if old, ok := existing[code]; !ok {
+			load.Created++
+		} else {
+			country.UpdatedAt = old.UpdatedAt
+			if fmt.Sprint(old) == fmt.Sprint(country) {
+				load.Skipped++
+				return nil
+			}
+			load.Updated++
+		}
+		batch = append(batch, country)
+		return nil
+	})
+	load.Errors += bad
+	if err != nil {
+		return err
+	}
+
+	if len(batch) > 0 {
+		return db.Clauses(clause.OnConflict{UpdateAll: true}).CreateInBatches(batch, geographyBatchSize).Error
+	}
+	return nil
+}
+
+func loadStates(db *gorm.DB, r io.Reader, opts GeographyOptions, load *models.GeographyLoad) error {
+
+	existing := map[string]models.State{}
+	var states []models.State
+	db.Find(&states)
+	for _, state := range states {
+		existing[state.CountryCode+"."+state.Code] = state
+	}
+
+	batch := []models.State{}
+	bad, err := EachGeographyRecord(r, models.GeographyStates, func(record map[string]string) error {
+		// GeoNames codes are CC.ADMIN1, comma separated files can have a country_code column
+		countryCode, code, found := strings.Cut(record["code"], ".")
+		if !found {
+			countryCode, code = record["country_code"], record["code"]
+		}
+		countryCode = strings.ToUpper(countryCode)
+		if len(countryCode) != 2 || code == "" || record["name"] == "" {
+			load.Errors++
+			return nil
+		}
+		if opts.Country != "" && countryCode != opts.Country {
+			load.Skipped++
+			return nil
+		}
+
+		geonameId, _ := strconv.ParseUint(record["geonameid"], 10, 64)
+		state := models.State{CountryCode: countryCode, Code: code, Name: record["name"], NameAscii: asciiName(record, record["name"]), GeonameId: uint(geonameId)}
+
+		if old, ok := existing[countryCode+"."+code]; !ok {
+			load.Created++
+		} else if old.Name == state.Name && old.NameAscii == state.NameAscii && old.GeonameId == state.GeonameId {
+			load.Skipped++
+			return nil
+		} else {
+			load.Updated++
+		}
+		batch = append(batch, state)
+		return nil
+	})
+	load.Errors += bad
+	if err != nil {
+		return err
+	}
+
+	if len(batch) == 0 {
+		return nil
+	}
+	err = db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "country_code"}, {Name: "code"}},
+		DoUpdates: clause.AssignmentColumns([]string{"name", "name_ascii", "geoname_id", "updated_at"}),
+	}).CreateInBatches(batch, geographyBatchSize).Error
+	if err != nil {
+		return err
+	}
+
+	// the cities loaded before their states
+	return db.Exec(`update cities set state = s.name from states s
+		where s.country_code = cities.country_code and s.code = cities.code and cities.state is distinct from s.name`).Error
+}
+
+func loadCities(db *gorm.DB, kind string, r io.Reader, opts GeographyOptions, load *models.GeographyLoad) error {
+
+	countryNames := map[string]string{}
+	var countries []models.Country
+	db.Select("code, name").Find(&countries)
+	for _, country := range countries {
+		countryNames[country.Code] = country.Name
+	}
+
+	stateNames := map[string]string{}
+	var states []models.State
+	db.Select("country_code, code, name").Find(&states)
+	for _, state := range states {
+		stateNames[state.CountryCode+"."+state.Code] = state.Name
+	}
+
+	batch := []models.City{}
+	deletes := []uint{}
+	timezones := map[string]bool{}
+
+	flush := func() error {
+		if len(deletes) > 0 {
+			result := db.Where("geoname_id in ?", deletes).Delete(&models.City{})
+			if result.Error != nil {
+				return result.Error
+			}
+			load.Deleted += int(result.RowsAffected)
+			load.Skipped += len(deletes) - int(result.RowsAffected)
+			deletes = deletes[:0]
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+
+		ids := []uint{}
+		for _, city := range batch {
+			ids = append(ids, city.GeonameId)
+		}
+		var existing []models.City
+		db.Select("id, geoname_id, modified_on").Where("geoname_id in ?", ids).Find(&existing)
+		modified := map[uint]string{}
+		for _, city := range existing {
+			modified[city.GeonameId] = city.ModifiedOn
+		}
+
+		changed := []models.City{}
+		for _, city := range batch {
+			on, ok := modified[city.GeonameId]
+			switch {
+			case !ok:
+				load.Created++
+			case on != "" && on >= city.ModifiedOn:
+				load.Skipped++
+				continue
+			default:
+				load.Updated++
+			}
+			changed = append(changed, city)
+		}
+		batch = batch[:0]
+
+		if len(changed) == 0 {
+			return nil
+		}
+		return db.Clauses(clause.OnConflict{
+			Columns:     []clause.Column{{Name: "geoname_id"}},
+			TargetWhere: clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "geoname_id > 0"}}},
+			DoUpdates: clause.AssignmentColumns([]string{"code", "state", "city", "county", "latitude", "longitude", "country",
+				"country_code", "name_ascii", "search_names", "timezone", "population", "modified_on"}),
+		}).Create(&changed).Error
+	}
+
+	bad, err := EachGeographyRecord(r, kind, func(record map[string]string) error {
+		geonameId, err := strconv.ParseUint(record["geonameid"], 10, 64)
+		if err != nil || geonameId == 0 {
+			load.Errors++
+			return nil
+		}
+
+		if kind == models.GeographyDeletes {
+			deletes = append(deletes, uint(geonameId))
+		} else {
+			countryCode := strings.ToUpper(record["country_code"])
+			population, _ := strconv.ParseInt(record["population"], 10, 64)
+			lat, err1 := strconv.ParseFloat(record["latitude"], 64)
+			lng, err2 := strconv.ParseFloat(record["longitude"], 64)
+
+			switch {
+			case err1 != nil || err2 != nil || !validLatLng(lat, lng) || len(countryCode) != 2 || record["name"] == "":
+				load.Errors++
+				return nil
+			case opts.Country != "" && countryCode != opts.Country,
+				record["feature_class"] != "" && record["feature_class"] != "P", // only populated places
+				population < opts.MinPopulation:
+				load.Skipped++
+				return nil
+			}
+
+			name := record["name"]
+			timezone := record["timezone"]
+			if !validTimezone(timezones, timezone) {
+				timezone = ""
+			}
+			names := append([]string{name, record["asciiname"]}, strings.Split(record["alternatenames"], ",")...)
+
+			batch = append(batch, models.City{
+				Code:        record["admin1_code"],
+				State:       stateNames[countryCode+"."+record["admin1_code"]],
+				City:        name,
+				Latitude:    strconv.FormatFloat(lat, 'f', 6, 64),
+				Longitude:   strconv.FormatFloat(lng, 'f', 6, 64),
+				Country:     LocationCountryName(countryCode, countryNames[countryCode]),
+				CountryCode: countryCode,
+				NameAscii:   asciiName(record, name),
+				SearchNames: searchNames(names...),
+				Timezone:    timezone,
+				Population:  population,
+				GeonameId:   uint(geonameId),
+				ModifiedOn:  record["modification_date"],
+			})
+		}
+
+		if len(batch) >= geographyBatchSize || len(deletes) >= geographyBatchSize {
+			return flush()
+		}
+		return nil
+	})
+	load.Errors += bad
+	if err != nil {
+		return err
+	}
+
+	return flush()
+}
+
+// RegisterGeographyRules add the address rules of the countries of the reference geography, the postal
+// codes, currency and locale of the dataset fill what the builtin rules of a country leave empty.
+// The timezones of the cities replace the builtin timezones of the countries.
+func RegisterGeographyRules(db *gorm.DB) error {
+	var countries []models.Country
+	if err := db.Find(&countries).Error; err != nil {
+		return err
+	}
+
+	for _, country := range countries {
+		rules, ok := utils.CountryRulesFor(country.Code)
+		if !ok {
+			rules = utils.CountryRules{Code: country.Code, Name: LocationCountryName(country.Code, country.Name)}
+		}
+		if rules.Iso3 == "" {
+			rules.Iso3 = country.Iso3
+		}
+		if country.NameAscii != "" && country.NameAscii != rules.Name && !slices.Contains(rules.Aliases, country.NameAscii) {
+			rules.Aliases = append(rules.Aliases, country.NameAscii)
+		}
+		if rules.Currency == "" {
+			rules.Currency = country.Currency
+		}
+		if rules.Locale == "" && len(country.Languages) > 0 {
+			// GeoNames languages are es-CO or es
+			language, region, _ := strings.Cut(country.Languages[0], "-")
+			if region == "" {
+				region = country.Code
+			}
+			rules.Locale = language + "_" + strings.ToUpper(region)
+		}
+		if rules.PostalRegex == "" && country.PostalRegex != "" {
+			rules.PostalRegex, rules.PostalExample = country.PostalRegex, country.PostalFormat
+		}
+
+		if err := utils.RegisterCountryRules(rules); err != nil {
+			// a postal code regex Go cannot compile, keep the country without it
+			rules.PostalRegex, rules.PostalExample = "", ""
+			utils.RegisterCountryRules(rules)
+		}
+	}
+	return registerGeographyTimezones(db)
+}
+
+// registerGeographyTimezones set the timezones of each country from its cities, with the centre
+// of the cities of each timezone weighted by population
+func registerGeographyTimezones(db *gorm.DB) error {
+	var rows []struct {
+		CountryCode string
+		Timezone    string
+		Latitude    float64
+		Longitude   float64
+	}
+	err := db.Raw(`select country_code, timezone,
+			sum(latitude * population) / sum(population) as latitude,
+			sum(longitude * population) / sum(population) as longitude
+		from cities where timezone <> '' and country_code <> '' and population > 0
+		group by country_code, timezone
+		order by country_code, sum(population) desc`).Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+
+	zones := map[string][]utils.CountryTimezone{}
+	for _, row := range rows {
+		zones[row.CountryCode] = append(zones[row.CountryCode], utils.CountryTimezone{Zone: row.Timezone, Latitude: row.Latitude, Longitude: row.Longitude})
+	}
+	for code, countryZones := range zones {
+		utils.RegisterCountryTimezones(code, countryZones)
+	}
+	return nil
+}
+
+// LookupCity the most populated city of a country with a name or an alternate name, accents and case ignored
+func LookupCity(db *gorm.DB, countryCode, name string) (models.City, bool) {
+	var city models.City
+	result := db.Where("country_code = ? and ? = any(search_names)", strings.ToUpper(countryCode), GeographyKey(name)).
+		Order("population desc").Limit(1).Find(&city)
+	return city, result.RowsAffected > 0
+}
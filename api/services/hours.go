@@ -0,0 +1,133 @@
+package services
+
+import (
+	"time"
+
+	"myproject/api/models"
+	"myproject/api/utils"
+
+	"gorm.io/gorm"
+)
+
+// HoursForLocation returns the weekly hours and the exceptions of a location.
+// A location with no weekly hours of its own uses the hours of the business,
+// and the business holidays apply to all its locations.
+func HoursForLocation(db *gorm.DB, businessId, locationId uint) ([]models.OpeningHours, []models.OpeningException) {
+
+	var hours []models.OpeningHours
+	if locationId > 0 {
+		db.Order("weekday, opens").Find(&hours, "business_id = ? and location_id = ?", businessId, locationId)
+	}
+	if len(hours) == 0 {
+		db.Order("weekday, opens").Find(&hours, "business_id = ? and location_id = 0", businessId)
+	}
+
+	// location exceptions first so they take precedence over the business holidays
+	var exceptions []models.OpeningException
+	db.Order("location_id desc, date").
+		Find(&exceptions, "business_id = ? and location_id in ? and date >= current_date - 1", businessId, []uint{0, locationId})
+
+	return hours, exceptions
+}
+
+// LocationTimezone returns the timezone of a location, derived from its country and latlng when not set
+func LocationTimezone(location models.Location) *time.Location {
+	if location.Timezone != "" {
+		return utils.LoadTimezone(location.Timezone)
+	}
+	return utils.LoadTimezone(utils.TimezoneFor(location.Country, location.Latlng))
+}
+
+// IsLocationOpenAt reports if a location is open at the given time in its own timezone
+func IsLocationOpenAt(db *gorm.DB, location models.Location, at time.Time) bool {
+	hours, exceptions := HoursForLocation(db, location.BusinessId, location.ID)
+	return models.IsOpenAt(hours, exceptions, at, LocationTimezone(location))
+}
+
+// IsBusinessOpenAt reports if any location of the business is open at the given time
+func IsBusinessOpenAt(db *gorm.DB, business models.Business, at time.Time) bool {
+
+	if business.Locations == nil {
+		db.Find(&business.Locations, "business_id = ?", business.ID)
+	}
+
+	return len(FilterOpenAt(db, []models.Business{business}, at)) > 0
+}
+
+// FilterOpenAt returns the businesses with a location open at the given time. The hours of all the
+// businesses are read at once, the locations must be preloaded.
+func FilterOpenAt(db *gorm.DB, businesses []models.Business, at time.Time) []models.Business {
+
+	if len(businesses) == 0 {
+		return businesses
+	}
+
+	ids := make([]uint, 0, len(businesses))
+	for _, business := range businesses {
+		ids = append(ids, business.ID)
+	}
+
+	type owner struct{ businessId, locationId uint }
+
+	var hours []models.OpeningHours
+	db.Order("weekday, opens").Find(&hours, "business_id in ?", ids)
+	weekly := map[owner][]models.OpeningHours{}
+	for _, h := range hours {
+		key := owner{h.BusinessId, h.LocationId}
+		weekly[key] = append(weekly[key], h)
+	}
+
+	var exceptions []models.OpeningException
+	db.Order("date").Find(&exceptions, "business_id in ? and date >= current_date - 1", ids)
+	closures := map[owner][]models.OpeningException{}
+	for _, e := range exceptions {
+		key := owner{e.BusinessId, e.LocationId}
+		closures[key] = append(closures[key], e)
+	}
+
+	// the same rules as HoursForLocation: the business hours when the location has none,
+	// and the location exceptions before the business holidays
+	openAt := func(businessId, locationId uint, tz *time.Location) bool {
+		locationHours := weekly[owner{businessId, locationId}]
+		if len(locationHours) == 0 {
+			locationHours = weekly[owner{businessId, 0}]
+		}
+		var locationExceptions []models.OpeningException
+		if locationId > 0 {
+			locationExceptions = append(locationExceptions, closures[owner{businessId, locationId}]...)
+		}
+		locationExceptions = append(locationExceptions, closures[owner{businessId, 0}]...)
+		return models.IsOpenAt(locationHours, locationExceptions, at, tz)
+	}
+
+	open := []models.Business{}
+	for _, business := range businesses {
+		if len(business.Locations) == 0 {
+			if openAt(business.ID, 0, utils.LoadTimezone(utils.TimezoneFor(business.Country, business.Latlng))) {
+				open = append(open, business)
+			}
+			continue
+		}
+		for _, location := range business.Locations {
+			if openAt(business.ID, location.ID, LocationTimezone(location)) {
+				open = append(open, business)
+				break
+			}
+		}
+	}
+
+	return open
+}
+
+// CoversPoint scopes a query of businesses to those with a service area covering the point,
+// either inside a polygon or within the radius of the location it is measured from
+func CoversPoint(lat, lng float64) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where(`businesses.id in (select s.business_id from service_areas s
+			left join locations l on l.id = s.location_id
+			join businesses b on b.id = s.business_id
+			where (s.kind = 'polygon' and ST_Covers(s.area, ST_SetSRID(ST_MakePoint(?, ?), 4326)::geography))
+			or (s.kind = 'radius' and ST_DWithin(coalesce(l.location, b.location), ST_SetSRID(ST_MakePoint(?, ?), 4326)::geography, s.radius_km * 1000)))`,
+			lng, lat, lng, lat)
+	}
+}
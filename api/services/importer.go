@@ -0,0 +1,559 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"myproject/api/models"
+	"myproject/api/utils"
+
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+// ImportMapping how the rows of a spreadsheet become records, stored in models.ImportProfile
+type ImportMapping struct {
+	Sheet      string              `json:"sheet"`      // XLSX sheet, the first one when empty
+	HeaderRow  int                 `json:"headerRow"`  // row with the column names, counted from 1, 0 when there is none
+	MinColumns int                 `json:"minColumns"` // shorter rows are skipped e.g. titles and totals
+	Columns    map[string][]string `json:"columns"`    // field -> column names or letters, several columns are joined with ", "
+	Defaults   map[string]string   `json:"defaults"`   // field -> value when the columns are empty
+	Skip       map[string][]string `json:"skip"`       // column -> values, rows with one of them are skipped
+	DedupeOn   string              `json:"dedupeOn"`   // identity, address, or empty for identity then address
+}
+
+// the fields of each import target
+var ImportFields = map[string][]string{
+	"location": {"name", "identity", "address", "city", "province", "postcode", "country", "phone", "email", "website", "latlng", "contactName", "timezone"},
+	"area":     {"location", "name"},
+	"contact":  {"name", "email", "phone", "city", "province", "country", "location"},
+}
+
+var importRequired = map[string][]string{
+	"location": {"name"},
+	"area":     {"location", "name"},
+	"contact":  {"name"},
+}
+
+// the spreadsheet layouts of the original 216 maintenance imports
+var BuiltinImportProfiles = map[string]ImportMapping{
+	"region": {
+		Sheet:      "A38",
+		MinColumns: 10,
+		Columns: map[string][]string{
+			"identity": {"A"}, "name": {"B"}, "contactName": {"C"}, "address": {"F"},
+			"city": {"G"}, "province": {"H"}, "postcode": {"I"}, "phone": {"J"},
+		},
+		Defaults: map[string]string{"country": "USA"},
+		Skip:     map[string][]string{"C": {"Store Count:", "Manager"}},
+		DedupeOn: "identity",
+	},
+	"external": {
+		Sheet:      "Sheet1",
+		MinColumns: 10,
+		Columns: map[string][]string{
+			"contactName": {"A"}, "identity": {"D"}, "name": {"E"}, "phone": {"F"}, "address": {"G", "H"},
+			"city": {"I"}, "province": {"J"}, "postcode": {"K"},
+		},
+		Defaults: map[string]string{"country": "USA"},
+		Skip:     map[string][]string{"C": {"Store Count:", "Manager"}},
+		DedupeOn: "identity",
+	},
+}
+
+var ErrUnsupportedImportFile = errors.New("the file must be XLSX or CSV")
+
+// ImportRow a mapped row and what the import does with it
+type ImportRow struct {
+	Line    int               `json:"line"` // line in the file, counted from 1
+	Fields  map[string]string `json:"fields"`
+	Action  string            `json:"action"` // create, update, skip or error
+	MatchId uint              `json:"matchId,omitempty"`
+	Errors  []string          `json:"errors,omitempty"`
+}
+
+// ImportCounts the outcome of an import
+type ImportCounts struct {
+	Total   int `json:"total"`
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+	Skipped int `json:"skipped"`
+	Failed  int `json:"failed"`
+}
+
+// Validate check the mapping can be used for the target
+func (m ImportMapping) Validate(target string) error {
+	fields, ok := ImportFields[target]
+	if !ok {
+		return fmt.Errorf("cannot import %s", target)
+	}
+	if len(m.Columns) == 0 {
+		return errors.New("map at least one column")
+	}
+	for field, columns := range m.Columns {
+		if !slices.Contains(fields, field) {
+			return fmt.Errorf("unknown %s field %s", target, field)
+		}
+		if len(columns) == 0 {
+			return fmt.Errorf("no column for %s", field)
+		}
+	}
+	for field := range m.Defaults {
+		if !slices.Contains(fields, field) {
+			return fmt.Errorf("unknown %s field %s", target, field)
+		}
+	}
+	for _, field := range importRequired[target] {
+		if _, ok := m.Columns[field]; !ok && m.Defaults[field] == "" {
+			return fmt.Errorf("%s must be mapped", field)
+		}
+	}
+	if m.DedupeOn != "" && m.DedupeOn != "identity" && m.DedupeOn != "address" {
+		return errors.New("dedupeOn must be identity or address")
+	}
+	if m.HeaderRow < 0 || m.MinColumns < 0 {
+		return errors.New("headerRow and minColumns cannot be negative")
+	}
+	return nil
+}
+
+// ReadImportRows read the rows of an XLSX or CSV file, by the extension of its name
+func ReadImportRows(r io.Reader, fileName, sheet string) ([][]string, error) {
+
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".csv":
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		cr := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+		cr.FieldsPerRecord = -1
+		cr.LazyQuotes = true
+		return cr.ReadAll()
+
+	case ".xlsx", ".xlsm":
+		xcl, err := excelize.OpenReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer xcl.Close()
+
+		if sheet == "" {
+			sheets := xcl.GetSheetList()
+			if len(sheets) == 0 {
+				return nil, errors.New("the file has no sheets")
+			}
+			sheet = sheets[0]
+		}
+		return xcl.GetRows(sheet)
+	}
+
+	return nil, ErrUnsupportedImportFile
+}
+
+// importColumn the index of a column given by its header name or its letter
+func importColumn(header []string, ref string) (int, error) {
+	for i, name := range header {
+		if strings.EqualFold(strings.TrimSpace(name), strings.TrimSpace(ref)) {
+			return i, nil
+		}
+	}
+	if n, err := excelize.ColumnNameToNumber(strings.TrimSpace(ref)); err == nil {
+		return n - 1, nil
+	}
+	return 0, fmt.Errorf("no column %q", ref)
+}
+
+// MapImportRows apply a mapping to the rows of a file and validate the fields,
+// the header row and the rows the mapping skips are left out
+func MapImportRows(target string, m ImportMapping, rows [][]string) ([]ImportRow, error) {
+
+	if err := m.Validate(target); err != nil {
+		return nil, err
+	}
+
+	var header []string
+	if m.HeaderRow > 0 && m.HeaderRow <= len(rows) {
+		header = rows[m.HeaderRow-1]
+	}
+
+	columns := map[string][]int{}
+	for field, refs := range m.Columns {
+		for _, ref := range refs {
+			index, err := importColumn(header, ref)
+			if err != nil {
+				return nil, err
+			}
+			columns[field] = append(columns[field], index)
+		}
+	}
+
+	skip := map[int][]string{}
+	for ref, values := range m.Skip {
+		index, err := importColumn(header, ref)
+		if err != nil {
+			return nil, err
+		}
+		skip[index] = values
+	}
+
+	cell := func(row []string, index int) string {
+		if index < len(row) {
+			return strings.TrimSpace(row[index])
+		}
+		return ""
+	}
+
+	result := []ImportRow{}
+	for i, row := range rows {
+		if i < m.HeaderRow || len(row) < m.MinColumns || strings.TrimSpace(strings.Join(row, "")) == "" {
+			continue
+		}
+
+		skipped := false
+		for index, values := range skip {
+			if slices.Contains(values, cell(row, index)) {
+				skipped = true
+			}
+		}
+		if skipped {
+			continue
+		}
+
+		fields := map[string]string{}
+		for field, indexes := range columns {
+			values := []string{}
+			for _, index := range indexes {
+				if value := cell(row, index); value != "" {
+					values = append(values, value)
+				}
+			}
+			fields[field] = strings.Join(values, ", ")
+		}
+		for field, value := range m.Defaults {
+			if fields[field] == "" {
+				fields[field] = value
+			}
+		}
+
+		result = append(result, ImportRow{Line: i + 1, Fields: fields, Errors: validateImportFields(target, fields)})
+	}
+
+	return result, nil
+}
+
+func validateImportFields(target string, fields map[string]string) []string {
+	errs := []string{}
+
+	for _, field := range importRequired[target] {
+		if fields[field] == "" {
+			errs = append(errs, field+" is required")
+		}
+	}
+	if email := fields["email"]; email != "" {
+		if _, err := mail.ParseAddress(email); err != nil {
+			errs = append(errs, "invalid email "+email)
+		}
+	}
+	if latlng := fields["latlng"]; latlng != "" {
+		lat, lng := utils.ExtractLatLng(latlng)
+		if (lat == 0 && lng == 0) || lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+			errs = append(errs, "invalid latlng "+latlng)
+		}
+	}
+	if target == "contact" && fields["email"] == "" && fields["phone"] == "" {
+		errs = append(errs, "email or phone is required")
+	}
+
+	return errs
+}
+
+// importAddressKey compare addresses ignoring case, accents and spacing
+func importAddressKey(address, city, postcode string) string {
+	if strings.TrimSpace(address) == "" {
+		return ""
+	}
+	key := strings.Join([]string{address, city, postcode}, "|")
+	return strings.ToLower(utils.RemoveAccents(strings.Join(strings.Fields(key), " ")))
+}
+
+// importKeys the keys a row is deduplicated on, in order of preference
+func importKeys(target, dedupeOn string, fields map[string]string, locationId uint) []string {
+	keys := []string{}
+	switch target {
+	case "location":
+		if dedupeOn != "address" && fields["identity"] != "" {
+			keys = append(keys, "identity:"+strings.ToLower(fields["identity"]))
+		}
+		if dedupeOn != "identity" {
+			if key := importAddressKey(fields["address"], fields["city"], fields["postcode"]); key != "" {
+				keys = append(keys, "address:"+key)
+			}
+		}
+	case "area":
+		keys = append(keys, fmt.Sprintf("area:%d:%s", locationId, strings.ToLower(fields["name"])))
+	case "contact":
+		if fields["email"] != "" {
+			keys = append(keys, "email:"+strings.ToLower(fields["email"]))
+		}
+		if fields["phone"] != "" {
+			keys = append(keys, "phone:"+utils.FixupPhone(fields["phone"]))
+		}
+	}
+	return keys
+}
+
+// PlanImport decide what happens to each valid row: create it, update the record it duplicates in update mode,
+// or skip it. Rows repeating an earlier row of the file are errors.
+func PlanImport(db *gorm.DB, businessId uint, target, mode, dedupeOn string, rows []ImportRow) {
+
+	// the existing records by key
+	existing := map[string]uint{}
+	locationIds := map[string]uint{}
+
+	var locations []models.Location
+	db.Select("id", "identity", "address", "city", "zipcode").Find(&locations, "business_id = ?", businessId)
+	for _, loc := range locations {
+		if loc.Identity != "" {
+			locationIds[strings.ToLower(loc.Identity)] = loc.ID
+		}
+	}
+
+	switch target {
+	case "location":
+		for _, loc := range locations {
+			for _, key := range importKeys(target, dedupeOn, map[string]string{"identity": loc.Identity, "address": loc.Address, "city": loc.City, "postcode": loc.Zipcode}, 0) {
+				existing[key] = loc.ID
+			}
+		}
+	case "area":
+		var areas []models.LocationArea
+		db.Find(&areas, "business_id = ?", businessId)
+		for _, area := range areas {
+			existing[importKeys(target, dedupeOn, map[string]string{"name": area.Name}, area.LocationId)[0]] = area.ID
+		}
+	case "contact":
+		var contacts []models.Contact
+		db.Select("id", "email", "phone").Find(&contacts, "business_id = ?", businessId)
+		for _, contact := range contacts {
+			for _, key := range importKeys(target, dedupeOn, map[string]string{"email": contact.Email, "phone": contact.Phone}, 0) {
+				existing[key] = contact.ID
+			}
+		}
+	}
+
+	seen := map[string]int{}
+	for i := range rows {
+		row := &rows[i]
+
+		var locationId uint
+		if ref := row.Fields["location"]; ref != "" {
+			locationId = locationIds[strings.ToLower(ref)]
+			if locationId == 0 {
+				row.Errors = append(row.Errors, "no location with identity "+ref)
+			}
+		}
+
+		if len(row.Errors) > 0 {
+			row.Action = "error"
+			continue
+		}
+
+		keys := importKeys(target, dedupeOn, row.Fields, locationId)
+
+		for _, key := range keys {
+			if line, ok := seen[key]; ok {
+				row.Errors = append(row.Errors, fmt.Sprintf("duplicate of line %d", line))
+				break
+			}
+		}
+		if len(row.Errors) > 0 {
+			row.Action = "error"
+			continue
+		}
+		for _, key := range keys {
+			seen[key] = row.Line
+		}
+
+		row.Action = "create"
+		for _, key := range keys {
+			if id, ok := existing[key]; ok {
+				row.MatchId = id
+				row.Action = "skip"
+				if mode == "update" {
+					row.Action = "update"
+				}
+				break
+			}
+		}
+
+		if target != "location" {
+			row.Fields["locationId"] = strconv.FormatUint(uint64(locationId), 10)
+		}
+	}
+}
+
+// setImportedLocation copy the imported fields to a location, empty fields leave the location as it is
+func setImportedLocation(loc *models.Location, fields map[string]string) {
+	set := func(field string, dest *string) {
+		if value := fields[field]; value != "" {
+			*dest = value
+		}
+	}
+	set("name", &loc.Name)
+	set("identity", &loc.Identity)
+	set("address", &loc.Address)
+	set("city", &loc.City)
+	set("province", &loc.Province)
+	set("postcode", &loc.Zipcode)
+	set("country", &loc.Country)
+	set("phone", &loc.Phone)
+	set("email", &loc.Email)
+	set("website", &loc.Website)
+	set("latlng", &loc.Latlng)
+	set("contactName", &loc.ContactName)
+	set("timezone", &loc.Timezone)
+}
+
+func setImportedContact(contact *models.Contact, fields map[string]string) {
+	set := func(field string, dest *string) {
+		if value := fields[field]; value != "" {
+			*dest = value
+		}
+	}
+	set("name", &contact.Name)
+	set("email", &contact.Email)
+	set("phone", &contact.Phone)
+	set("city", &contact.City)
+	set("province", &contact.Province)
+	set("country", &contact.Country)
+	if id, _ := strconv.ParseUint(fields["locationId"], 10, 64); id > 0 {
+		contact.LocationId = uint(id)
+	}
+}
+
+// ApplyImport create and update the records of the planned rows in one transaction,
+// a row that fails to save is marked as an error without stopping the others
+func ApplyImport(db *gorm.DB, business models.Business, target string, rows []ImportRow) (ImportCounts, error) {
+
+	counts := ImportCounts{Total: len(rows)}
+
+	tx := db.Clauses(dbresolver.Write).Begin()
+
+	for i := range rows {
+		row := &rows[i]
+
+		var err error
+		switch row.Action {
+		case "skip":
+			counts.Skipped++
+			continue
+		case "error":
+			counts.Failed++
+			continue
+		}
+
+		// a savepoint per row so a failed row does not abort the transaction
+		tx.SavePoint("row")
+
+		switch target {
+		case "location":
+			loc := models.Location{BusinessId: business.ID, UserId: business.UserId, Flags: []string{"imported"}}
+			if row.Action == "update" {
+				err = tx.First(&loc, row.MatchId).Error
+			}
+			if err == nil {
+				setImportedLocation(&loc, row.Fields)
+				err = tx.Save(&loc).Error
+			}
+		case "area":
+			locationId, _ := strconv.ParseUint(row.Fields["locationId"], 10, 64)
+			area := models.LocationArea{ID: row.MatchId, BusinessId: business.ID, LocationId: uint(locationId), Name: row.Fields["name"]}
+			err = tx.Save(&area).Error
+		case "contact":
+			contact := models.Contact{BusinessId: business.ID, Flags: []string{"imported"}}
+			if row.Action == "update" {
+				err = tx.First(&contact, row.MatchId).Error
+			}
+			if err == nil {
+				setImportedContact(&contact, row.Fields)
+				err = tx.Save(&contact).Error
+			}
+		}
+
+		if err != nil {
+			tx.RollbackTo("row")
+			row.Errors = append(row.Errors, err.Error())
+			row.Action = "error"
+			counts.Failed++
+			continue
+		}
+
+		if row.Action == "update" {
+			counts.Updated++
+		} else {
+			counts.Created++
+		}
+	}
+
+	return counts, tx.Commit().Error
+}
+
+// WriteImportReport write the rows with errors as CSV: line, errors, then the mapped fields
+func WriteImportReport(w io.Writer, rows []ImportRow) error {
+
+	fields := []string{}
+	for _, row := range rows {
+		for field := range row.Fields {
+			if field != "locationId" && !slices.Contains(fields, field) {
+				fields = append(fields, field)
+			}
+		}
+	}
+	sort.Strings(fields)
+
+	cw := csv.NewWriter(w)
+	cw.Write(append([]string{"line", "errors"}, fields...))
+
+	for _, row := range rows {
+		if row.Action != "error" {
+			continue
+		}
+		record := []string{strconv.Itoa(row.Line), strings.Join(row.Errors, "; ")}
+		for _, field := range fields {
+			record = append(record, row.Fields[field])
+		}
+		cw.Write(record)
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// PlanCounts what an import of the planned rows would do, for a preview
+func PlanCounts(rows []ImportRow) ImportCounts {
+	counts := ImportCounts{Total: len(rows)}
+	for _, row := range rows {
+		switch row.Action {
+		case "create":
+			counts.Created++
+		case "update":
+			counts.Updated++
+		case "skip":
+			counts.Skipped++
+		default:
+			counts.Failed++
+		}
+	}
+	return counts
+}
@@ -0,0 +1,230 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"myproject/api/database"
+	"myproject/api/models"
+
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+// defaultRetentionDays is how long soft deleted data can be restored when DELETE_RETENTION_DAYS is not set
+const defaultRetentionDays = 30
+
+// the soft deleted tables of a business, by the model name used in the audit and the restore route
+var RetainedTables = map[string]string{
+	"location":   "locations",
+	"part":       "parts",
+	"consumable": "consumables",
+	"bin":        "bins",
+}
+
+// the rows that reference a soft deleted row and go with it when it is purged
+var retainedChildren = map[string][]string{
+	"locations": {
+		"delete from location_areas where location_id in (select id from locations where deleted_at < ?)",
+		"delete from location_placements where node_id in (select n.id from location_nodes n join locations l on l.id = n.location_id where l.deleted_at < ?)",
+		"delete from location_nodes where location_id in (select id from locations where deleted_at < ?)",
+	},
+	"parts":       {"delete from bin_infos where part_id in (select id from parts where deleted_at < ?)"},
+	"consumables": {"delete from bin_infos where consumable_id in (select id from consumables where deleted_at < ?)"},
+	"bins": {
+		"delete from bin_infos where bin_id in (select id from bins where deleted_at < ?)",
+		"delete from location_placements where kind = 'bin' and object_id in (select id from bins where deleted_at < ?)",
+	},
+}
+
+var ErrNotDeleted = errors.New("not deleted")
+var ErrRetentionExpired = errors.New("the retention window has passed, the data can no longer be restored")
+
+// RetentionDays is how long soft deleted data is kept before the purge
+func RetentionDays() int {
+	if days, err := strconv.Atoi(database.GetParam("DELETE_RETENTION_DAYS")); err == nil && days > 0 {
+		return days
+	}
+	return defaultRetentionDays
+}
+
+// RetentionCutoff data deleted before this time is purged
+func RetentionCutoff() time.Time {
+	return time.Now().AddDate(0, 0, -RetentionDays())
+}
+
+// AuditDeletion record a delete, restore or purge
+func AuditDeletion(db *gorm.DB, model string, objectId, businessId, userId uint, action string) {
+	db.Create(&models.DeletionAudit{Model: model, ObjectId: objectId, BusinessId: businessId, UserId: userId, Action: action})
+}
+
+// SoftDeleteBusiness soft delete a business with its locations, parts, consumables and bins.
+// They share the deleted_at of the business so RestoreBusiness restores only what was deleted with it.
+func SoftDeleteBusiness(db *gorm.DB, businessId, userId uint) error {
+
+	tx := database.WithoutTenant(db).Clauses(dbresolver.Write).Begin()
+
+	now := time.Now()
+
+	result := tx.Exec("update businesses set deleted_at = ? where id = ? and deleted_at is null", now, businessId)
+	if result.Error != nil {
+		tx.Rollback()
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		return gorm.ErrRecordNotFound
+	}
+
+	for _, table := range RetainedTables {
+		if err := tx.Exec(fmt.Sprintf("update %s set deleted_at = ? where business_id = ? and deleted_at is null", table), now, businessId).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	tx.Exec("delete from data_caches where business_id = ?", businessId)
+
+	AuditDeletion(tx, "business", businessId, businessId, userId, "delete")
+
+	return tx.Commit().Error
+}
+
+// RestoreBusiness restore a soft deleted business and the data deleted with it, within the retention window
+func RestoreBusiness(db *gorm.DB, businessId, userId uint) error {
+
+	tx := database.WithoutTenant(db).Clauses(dbresolver.Write).Begin()
+
+	var business models.Business
+	if err := tx.Unscoped().Select("id", "deleted_at").First(&business, businessId).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if !business.DeletedAt.Valid {
+		tx.Rollback()
+		return ErrNotDeleted
+	}
+	if business.DeletedAt.Time.Before(RetentionCutoff()) {
+		tx.Rollback()
+		return ErrRetentionExpired
+	}
+
+	for _, table := range RetainedTables {
+		if err := tx.Exec(fmt.Sprintf("update %s set deleted_at = null where business_id = ? and deleted_at = ?", table), businessId, business.DeletedAt.Time).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err := tx.Exec("update businesses set deleted_at = null where id = ?", businessId).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	AuditDeletion(tx, "business", businessId, businessId, userId, "restore")
+
+	return tx.Commit().Error
+}
+
+// RestoreRecord restore a soft deleted location, part, consumable or bin within the retention window,
+// returns the business it belongs to
+func RestoreRecord(db *gorm.DB, model string, id, userId uint) (uint, error) {
+
+	table, ok := RetainedTables[model]
+	if !ok {
+		return 0, fmt.Errorf("cannot restore a %s", model)
+	}
+
+	var row struct {
+		BusinessId uint
+		DeletedAt  *time.Time
+	}
+	db = database.WithoutTenant(db)
+	if err := db.Table(table).Select("business_id", "deleted_at").Where("id = ?", id).Take(&row).Error; err != nil {
+		return 0, err
+	}
+	if row.DeletedAt == nil {
+		return row.BusinessId, ErrNotDeleted
+	}
+	if row.DeletedAt.Before(RetentionCutoff()) {
+		return row.BusinessId, ErrRetentionExpired
+	}
+
+	if err := db.Exec(fmt.Sprintf("update %s set deleted_at = null where id = ?", table), id).Error; err != nil {
+		return row.BusinessId, err
+	}
+
+	AuditDeletion(db, model, id, row.BusinessId, userId, "restore")
+
+	return row.BusinessId, nil
+}
+
+// PurgeExpired hard delete the businesses, and the locations, parts, consumables and bins,
+// soft deleted before the retention window. Each business is purged in its own transaction,
+// a failure is audited and reported without stopping the others.
+func PurgeExpired(db *gorm.DB) (int, []error) {
+
+	db = database.WithoutTenant(db)
+	cutoff := RetentionCutoff()
+	purged := 0
+	errs := []error{}
+
+	var businessIds []uint
+	db.Unscoped().Model(&models.Business{}).Where("deleted_at < ?", cutoff).Pluck("id", &businessIds)
+
+	for _, businessId := range businessIds {
+		tx := db.Clauses(dbresolver.Write).Begin()
+		if err := models.PurgeBusinessData(tx, businessId); err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("purge business %d: %w", businessId, err)
+			errs = append(errs, err)
+			db.Create(&models.DeletionAudit{Model: "business", ObjectId: businessId, BusinessId: businessId, Action: "purge", Error: err.Error()})
+			continue
+		}
+		AuditDeletion(tx, "business", businessId, businessId, 0, "purge")
+		if err := tx.Commit().Error; err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		purged++
+	}
+
+	// records deleted on their own
+	for model, table := range RetainedTables {
+		tx := db.Clauses(dbresolver.Write).Begin()
+
+		var failed error
+		for _, sql := range retainedChildren[table] {
+			if err := tx.Exec(sql, cutoff).Error; err != nil {
+				failed = err
+				break
+			}
+		}
+
+		var count int64
+		if failed == nil {
+			result := tx.Exec(fmt.Sprintf("delete from %s where deleted_at < ?", table), cutoff)
+			failed = result.Error
+			count = result.RowsAffected
+		}
+
+		if failed != nil {
+			tx.Rollback()
+			failed = fmt.Errorf("purge %s: %w", table, failed)
+			errs = append(errs, failed)
+			db.Create(&models.DeletionAudit{Model: model, Action: "purge", Error: failed.Error()})
+			continue
+		}
+
+		if count > 0 {
+			tx.Create(&models.DeletionAudit{Model: model, Action: "purge", Count: count})
+		}
+		if err := tx.Commit().Error; err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return purged, errs
+}
@@ -0,0 +1,386 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"myproject/api/database"
+	"myproject/api/models"
+	"myproject/api/utils"
+
+	"github.com/lib/pq"
+	"gorm.io/gorm"
+)
+
+// RoutePoint a place on a route
+type RoutePoint struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+// RouteLeg the distance and the travel time between two points
+type RouteLeg struct {
+	Metres  float64 `json:"metres"`
+	Seconds float64 `json:"seconds"`
+}
+
+// DistanceMatrix gives the legs between every pair of points, matrix[i][j] from point i to point j.
+// Road distances from a routing service can replace the straight line estimates.
+type DistanceMatrix interface {
+	Name() string
+	Matrix(ctx context.Context, points []RoutePoint) ([][]RouteLeg, error)
+}
+
+// the straight line distance is multiplied by this to estimate the road distance
+const defaultDetourFactor = 1.3
+
+// average speed of a technician in town when not configured
+const defaultRouteSpeedKmh = 40
+
+// travelSeconds the time to drive metres at kmh
+func travelSeconds(metres, kmh float64) float64 {
+	return metres / (kmh * 1000 / 3600)
+}
+
+// HaversineMatrix straight line distances on the sphere, lengthened by a detour factor
+type HaversineMatrix struct {
+	SpeedKmh     float64
+	DetourFactor float64
+}
+
+func (m HaversineMatrix) Name() string {
+	return "haversine"
+}
+
+func (m HaversineMatrix) Matrix(ctx context.Context, points []RoutePoint) ([][]RouteLeg, error) {
+	matrix := make([][]RouteLeg, len(points))
+	for i, a := range points {
+		matrix[i] = make([]RouteLeg, len(points))
+		for j, b := range points {
+			if i == j {
+				continue
+			}
+			metres := utils.HaversineDistance(a.Lat, a.Lng, b.Lat, b.Lng) * m.DetourFactor
+			matrix[i][j] = RouteLeg{Metres: metres, Seconds: travelSeconds(metres, m.SpeedKmh)}
+		}
+	}
+	return matrix, nil
+}
+
+// PostGISMatrix distances on the spheroid computed by PostGIS, lengthened by a detour factor
+type PostGISMatrix struct {
+	DB           *gorm.DB
+	SpeedKmh     float64
+	DetourFactor float64
+}
+
+func (m PostGISMatrix) Name() string {
+	return "postgis"
+}
+
+func (m PostGISMatrix) Matrix(ctx context.Context, points []RoutePoint) ([][]RouteLeg, error) {
+	lats := make([]float64, len(points))
+	lngs := make([]float64, len(points))
+	for i, p := range points {
+		lats[i], lngs[i] = p.Lat, p.Lng
+	}
+
+	var rows []struct {
+		I      int
+		J      int
+		Metres float64
+	}
+	err := m.DB.WithContext(ctx).Raw(`select a.i - 1 as i, b.i - 1 as j,
+		ST_Distance(ST_SetSRID(ST_MakePoint(a.lng, a.lat), 4326)::geography, ST_SetSRID(ST_MakePoint(b.lng, b.lat), 4326)::geography) as metres
+		from unnest(?::float8[], ?::float8[]) with ordinality as a(lat, lng, i)
+		cross join unnest(?::float8[], ?::float8[]) with ordinality as b(lat, lng, i)`,
+		pq.Array(lats), pq.Array(lngs), pq.Array(lats), pq.Array(lngs)).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	matrix := make([][]RouteLeg, len(points))
+	for i := range matrix {
+		matrix[i] = make([]RouteLeg, len(points))
+	}
+	for _, row := range rows {
+		metres := row.Metres * m.DetourFactor
+		matrix[row.I][row.J] = RouteLeg{Metres: metres, Seconds: travelSeconds(metres, m.SpeedKmh)}
+	}
+	return matrix, nil
+}
+
+var distanceMatrixMutex sync.Mutex
+var distanceMatrix DistanceMatrix
+
+// UseDistanceMatrix replace the distance matrix chosen from the params, nil to go back to the params
+func UseDistanceMatrix(m DistanceMatrix) {
+	distanceMatrixMutex.Lock()
+	defer distanceMatrixMutex.Unlock()
+	distanceMatrix = m
+}
+
+// CurrentDistanceMatrix the matrix set by UseDistanceMatrix, otherwise the ROUTE_DISTANCE param: haversine (default) or postgis,
+// at ROUTE_SPEED_KMH with the ROUTE_DETOUR_FACTOR
+func CurrentDistanceMatrix(db *gorm.DB) DistanceMatrix {
+	distanceMatrixMutex.Lock()
+	defer distanceMatrixMutex.Unlock()
+
+	if distanceMatrix != nil {
+		return distanceMatrix
+	}
+
+	speed, _ := strconv.ParseFloat(database.GetParam("ROUTE_SPEED_KMH"), 64)
+	if speed <= 0 {
+		speed = defaultRouteSpeedKmh
+	}
+	detour, _ := strconv.ParseFloat(database.GetParam("ROUTE_DETOUR_FACTOR"), 64)
+	if detour < 1 {
+		detour = defaultDetourFactor
+	}
+
+	if database.GetParam("ROUTE_DISTANCE") == "postgis" {
+		return PostGISMatrix{DB: db, SpeedKmh: speed, DetourFactor: detour}
+	}
+	return HaversineMatrix{SpeedKmh: speed, DetourFactor: detour}
+}
+
+// RouteStop a visit to plan
+type RouteStop struct {
+	Id       uint
+	Point    RoutePoint
+	Duration time.Duration
+	OpenAt   func(time.Time) bool // nil when the place is always open
+}
+
+// RouteVisit a planned visit
+type RouteVisit struct {
+	Index     int       `json:"-"` // of the stop in the stops given to PlanRoute
+	Id        uint      `json:"id"`
+	Travel    RouteLeg  `json:"travel"` // from the previous stop or the start
+	Arrival   time.Time `json:"arrival"`
+	Start     time.Time `json:"start"` // after waiting for the place to open
+	Departure time.Time `json:"departure"`
+	Wait      float64   `json:"waitSeconds"`
+	Closed    bool      `json:"closed"` // the place is not open for the whole visit within routeOpenHorizon of the arrival
+	Minutes   float64   `json:"minutes"`
+}
+
+// Route a planned itinerary
+type Route struct {
+	Visits    []RouteVisit `json:"visits"`
+	Return    *RouteLeg    `json:"return,omitempty"` // back to the start when asked for
+	Metres    float64      `json:"metres"`
+	Seconds   float64      `json:"seconds"` // travel time
+	Departure time.Time    `json:"departure"`
+	Finish    time.Time    `json:"finish"`
+	Closed    int          `json:"closed"` // visits that could not be fitted in the opening hours
+	Matrix    string       `json:"matrix"`
+}
+
+// how far past the arrival a visit can wait for the place to open
+const routeOpenHorizon = 24 * time.Hour
+
+// the steps in which the opening of a place is searched for
+const routeOpenStep = 15 * time.Minute
+
+// maxRouteStops the most visits planned at once
+const maxRouteStops = 50
+
+var ErrTooManyStops = fmt.Errorf("a route can have at most %d stops", maxRouteStops)
+
+// openFor the earliest start at or after arrival when the stop is open for its whole visit
+func openFor(stop RouteStop, arrival time.Time) (time.Time, bool) {
+	if stop.OpenAt == nil {
+		return arrival, true
+	}
+
+	last := max(stop.Duration-time.Minute, 0)
+	open := func(start time.Time) bool {
+		return stop.OpenAt(start) && stop.OpenAt(start.Add(last))
+	}
+
+	if open(arrival) {
+		return arrival, true
+	}
+	// otherwise wait until a quarter of the hour when it is open
+	for start := arrival.Truncate(routeOpenStep).Add(routeOpenStep); start.Sub(arrival) <= routeOpenHorizon; start = start.Add(routeOpenStep) {
+		if open(start) {
+			return start, true
+		}
+	}
+	return arrival, false
+}
+
+// schedule the visits of the stops in order, the first point of the matrix is the start
+func schedule(stops []RouteStop, order []int, matrix [][]RouteLeg, departure time.Time) Route {
+	route := Route{Departure: departure, Visits: make([]RouteVisit, 0, len(order))}
+
+	at := departure
+	from := 0
+	for _, index := range order {
+		stop := stops[index]
+		leg := matrix[from][index+1]
+
+		arrival := at.Add(time.Duration(leg.Seconds * float64(time.Second)))
+		start, open := openFor(stop, arrival)
+
+		visit := RouteVisit{
+			Index:     index,
+			Id:        stop.Id,
+			Travel:    leg,
+			Arrival:   arrival,
+			Start:     start,
+			Departure: start.Add(stop.Duration),
+			Wait:      start.Sub(arrival).Seconds(),
+			Closed:    !open,
+			Minutes:   stop.Duration.Minutes(),
+		}
+		route.Visits = append(route.Visits, visit)
+
+		route.Metres += leg.Metres
+		route.Seconds += leg.Seconds
+		if !open {
+			route.Closed++
+		}
+
+		at = visit.Departure
+		from = index + 1
+	}
+
+	route.Finish = at
+	return route
+}
+
+// routeCost compare schedules: fewer closed visits first, then the earliest finish, then the shortest distance
+func routeCost(route Route) float64 {
+	return float64(route.Closed)*1e12 + route.Finish.Sub(route.Departure).Seconds()*1e3 + route.Metres/1000
+}
+
+// nearestNeighbour visit the closest stop not visited yet, starting from the start
+func nearestNeighbour(n int, matrix [][]RouteLeg) []int {
+	order := make([]int, 0, n)
+	visited := make([]bool, n)
+
+	from := 0
+	for len(order) < n {
+		next := -1
+		for i := 0; i < n; i++ {
+			if !visited[i] && (next < 0 || matrix[from][i+1].Seconds < matrix[from][next+1].Seconds) {
+				next = i
+			}
+		}
+		visited[next] = true
+		order = append(order, next)
+		from = next + 1
+	}
+	return order
+}
+
+// twoOpt reverse segments of the order while that lowers the cost of the schedule
+func twoOpt(order []int, cost func([]int) float64) []int {
+	best := cost(order)
+
+	for improved := true; improved; {
+		improved = false
+		for i := 0; i < len(order)-1; i++ {
+			for j := i + 1; j < len(order); j++ {
+				candidate := make([]int, len(order))
+				copy(candidate, order)
+				for a, b := i, j; a < b; a, b = a+1, b-1 {
+					candidate[a], candidate[b] = candidate[b], candidate[a]
+				}
+				if c := cost(candidate); c < best {
+					order, best, improved = candidate, c, true
+				}
+			}
+		}
+	}
+	return order
+}
+
+// PlanRoute order the stops from the start with nearest neighbour then 2-opt, taking the opening hours
+// and the visit durations into account, and schedule the visits from the departure time
+func PlanRoute(ctx context.Context, matrix DistanceMatrix, start RoutePoint, stops []RouteStop, departure time.Time, returnToStart bool) (Route, error) {
+	if len(stops) == 0 {
+		return Route{Departure: departure, Finish: departure, Visits: []RouteVisit{}, Matrix: matrix.Name()}, nil
+	}
+	if len(stops) > maxRouteStops {
+		return Route{}, ErrTooManyStops
+	}
+
+	points := []RoutePoint{start}
+	for _, stop := range stops {
+		points = append(points, stop.Point)
+	}
+
+	legs, err := matrix.Matrix(ctx, points)
+	if err != nil {
+		return Route{}, err
+	}
+	if len(legs) != len(points) {
+		return Route{}, errors.New("the distance matrix does not match the stops")
+	}
+
+	cost := func(order []int) float64 {
+		route := schedule(stops, order, legs, departure)
+		c := routeCost(route)
+		if returnToStart {
+			c += legs[order[len(order)-1]+1][0].Seconds * 1e3
+		}
+		return c
+	}
+
+	order := twoOpt(nearestNeighbour(len(stops), legs), cost)
+
+	route := schedule(stops, order, legs, departure)
+	route.Matrix = matrix.Name()
+	if returnToStart {
+		back := legs[order[len(order)-1]+1][0]
+		route.Return = &back
+		route.Metres += back.Metres
+		route.Seconds += back.Seconds
+		route.Finish = route.Finish.Add(time.Duration(back.Seconds * float64(time.Second)))
+	}
+
+	return route, nil
+}
+
+// LocationOpenAt whether a location is open at a time, nil when it has no opening hours.
+// Without weekly hours the location is open except on the days it is closed for a holiday.
+func LocationOpenAt(db *gorm.DB, location models.Location) func(time.Time) bool {
+	hours, exceptions := HoursForLocation(db, location.BusinessId, location.ID)
+	tz := LocationTimezone(location)
+
+	if len(hours) > 0 {
+		return func(at time.Time) bool {
+			return models.IsOpenAt(hours, exceptions, at, tz)
+		}
+	}
+
+	closed := map[string]bool{}
+	for _, e := range exceptions {
+		if e.Closed && len(e.Date) >= 10 {
+			closed[e.Date[:10]] = true
+		}
+	}
+	if len(closed) == 0 {
+		return nil
+	}
+	return func(at time.Time) bool {
+		return !closed[at.In(tz).Format("2006-01-02")]
+	}
+}
+
+// RoutePointOf the position of a latlng, false when it is not a valid position
+func RoutePointOf(latlng string) (RoutePoint, bool) {
+	lat, lng := utils.ExtractLatLng(latlng)
+	if (lat == 0 && lng == 0) || !validLatLng(lat, lng) {
+		return RoutePoint{}, false
+	}
+	return RoutePoint{Lat: lat, Lng: lng}, true
+}
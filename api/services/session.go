@@ -0,0 +1,439 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"myproject/api/database"
+	"myproject/api/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// the lifetimes of the tokens unless ACCESS_TOKEN_MINUTES and REFRESH_TOKEN_DAYS are set
+const (
+	defaultAccessTokenMinutes = 15
+	defaultRefreshTokenDays   = 30
+)
+
+// a refresh token replaced less than this ago is still accepted once, for the requests
+// the browser sent at the same time, but gets no new refresh token
+const refreshReuseGrace = 30 * time.Second
+
+var (
+	ErrNoSession           = errors.New("no session")
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token already used, the session is revoked")
+	ErrSessionRevoked      = errors.New("the session is revoked")
+	ErrSessionExpired      = errors.New("the session expired")
+)
+
+// sessionCache holds the revoked access tokens and sessions, nil to check the sessions in the database
+var sessionCache *redis.Client
+
+// UseSessionCache keep the revoked access tokens in redis until they expire
+func UseSessionCache(rdb *redis.Client) {
+	sessionCache = rdb
+}
+
+// AccessTokenClaims the claims of an access token
+type AccessTokenClaims struct {
+	UserId    uint `json:"user_id"`
+	SessionId uint `json:"sid,omitempty"` // 0 in the tokens issued before the sessions
+	jwt.RegisteredClaims
+}
+
+func accessTokenLifetime() time.Duration {
+	minutes, _ := strconv.Atoi(database.GetParam("ACCESS_TOKEN_MINUTES"))
+	if minutes <= 0 {
+		minutes = defaultAccessTokenMinutes
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+func refreshTokenLifetime() time.Duration {
+	days, _ := strconv.Atoi(database.GetParam("REFRESH_TOKEN_DAYS"))
+	if days <= 0 {
+		days = defaultRefreshTokenDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// RefreshCookieName the cookie of the refresh token, next to the JWT_COOKIE of the access token
+func RefreshCookieName() string {
+	return database.GetParam("JWT_COOKIE") + "_refresh"
+}
+
+func cookieDomain() string {
+	if os.Getenv("TEST_MODE") == "true" || os.Getenv("USE_DOCKER") == "true" {
+		return "localhost"
+	}
+	return database.GetParam("JWT_DOMAIN")
+}
+
+func setCookie(c *fiber.Ctx, name, value string, expires time.Time) {
+	c.Cookie(&fiber.Cookie{
+		Name:     name,
+		Domain:   cookieDomain(),
+		Value:    value,
+		Expires:  expires,
+		HTTPOnly: true,
+		Secure:   true,
+	})
+}
+
+// hashRefreshToken the refresh tokens are only stored hashed
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newRefreshToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ParseAccessToken the claims of a valid access token, expired tokens and tokens of retired keys are not valid
+func ParseAccessToken(token string) (*AccessTokenClaims, error) {
+	claims := new(AccessTokenClaims)
+	_, err := jwt.ParseWithClaims(token, claims, tokenKey)
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// issueAccessToken sign a new access token of the session and remember its ID
+func issueAccessToken(db *gorm.DB, user models.User, session *models.UserSession) (string, time.Time, error) {
+	expires := time.Now().Add(accessTokenLifetime())
+	token, jti, err := CreateJWTToken(user, session.ID, expires)
+	if err != nil {
+		return "", expires, err
+	}
+	session.AccessJti = jti
+	db.Model(session).Update("access_jti", jti)
+	return token, expires, nil
+}
+
+// sendTokens set the access and refresh cookies, refresh is empty to keep the refresh cookie of the client
+func sendTokens(c *fiber.Ctx, user models.User, session *models.UserSession, access string, accessExpires time.Time, refresh string) fiber.Map {
+	setCookie(c, database.GetParam("JWT_COOKIE"), access, accessExpires)
+
+	data := fiber.Map{"token": access, "exp": accessExpires.Unix(), "sessionId": session.ID, "user": user}
+	if refresh != "" {
+		setCookie(c, RefreshCookieName(), refresh, session.ExpiresAt)
+		data["refreshToken"] = refresh
+		data["refreshExp"] = session.ExpiresAt.Unix()
+	}
+
+	c.Locals("currentUser", user)
+	c.Locals("sessionId", session.ID)
+	return data
+}
+
+// startSession log the user in on the device of the request with a new session, or give a new access token
+// to the session of the request when it is the session of the user
+func startSession(db *gorm.DB, c *fiber.Ctx, user models.User, refreshLifetime time.Duration) (fiber.Map, error) {
+
+	if sessionId, ok := c.Locals("sessionId").(uint); ok {
+		var session models.UserSession
+		if db.First(&session, "id = ? and user_id = ? and revoked_at is null", sessionId, user.ID).Error == nil {
+			access, expires, err := issueAccessToken(db, user, &session)
+			if err != nil {
+				return fiber.Map{}, err
+			}
+			return sendTokens(c, user, &session, access, expires, ""), nil
+		}
+	}
+
+	// the app of a signed request
+	sig := new(models.Signature)
+	c.BodyParser(sig)
+
+	clientIP, _ := c.Locals("clientIP").(string)
+	refresh := newRefreshToken()
+	now := time.Now()
+	session := models.UserSession{
+		UserId:      user.ID,
+		RefreshHash: hashRefreshToken(refresh),
+		Package:     sig.PackageName,
+		UserAgent:   string(c.Request().Header.UserAgent()),
+		ClientIP:    clientIP,
+		RotatedAt:   now,
+		LastUsedAt:  now,
+		ExpiresAt:   now.Add(refreshLifetime),
+	}
+	if err := db.Create(&session).Error; err != nil {
+		return fiber.Map{}, err
+	}
+
+	access, expires, err := issueAccessToken(db, user, &session)
+	if err != nil {
+		return fiber.Map{}, err
+	}
+	return sendTokens(c, user, &session, access, expires, refresh), nil
+}
+
+// RefreshSession replace a refresh token with a new one and a new access token. Using a replaced
+// refresh token again revokes the session, unless it was replaced a moment ago by a parallel request.
+func RefreshSession(db *gorm.DB, c *fiber.Ctx, refresh string) (fiber.Map, error) {
+
+	hash := hashRefreshToken(refresh)
+
+	var session models.UserSession
+	if err := db.First(&session, "refresh_hash = ?", hash).Error; err != nil {
+		if err := db.First(&session, "previous_hash = ?", hash).Error; err != nil {
+			return fiber.Map{}, ErrInvalidRefreshToken
+		}
+		if session.RevokedAt != nil || time.Since(session.RotatedAt) > refreshReuseGrace {
+			RevokeSession(db, &session, models.SessionReused)
+			return fiber.Map{}, ErrRefreshTokenReused
+		}
+		if time.Now().After(session.ExpiresAt) {
+			return fiber.Map{}, ErrSessionExpired
+		}
+		return refreshAccess(db, c, &session, "")
+	}
+
+	if session.RevokedAt != nil {
+		return fiber.Map{}, ErrSessionRevoked
+	}
+	if time.Now().After(session.ExpiresAt) {
+		return fiber.Map{}, ErrSessionExpired
+	}
+
+	next := newRefreshToken()
+	clientIP, _ := c.Locals("clientIP").(string)
+	now := time.Now()
+	result := db.Model(&models.UserSession{}).Where("id = ? and refresh_hash = ?", session.ID, hash).Updates(map[string]interface{}{
+		"refresh_hash":  hashRefreshToken(next),
+		"previous_hash": hash,
+		"rotated_at":    now,
+		"last_used_at":  now,
+		"expires_at":    now.Add(refreshTokenLifetime()),
+		"client_ip":     clientIP,
+	})
+	if result.Error != nil {
+		return fiber.Map{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		// a parallel request replaced it first
+		return refreshAccess(db, c, &session, "")
+	}
+	session.ExpiresAt = now.Add(refreshTokenLifetime())
+
+	return refreshAccess(db, c, &session, next)
+}
+
+// refreshAccess send a new access token of the session, with the new refresh token when not empty
+func refreshAccess(db *gorm.DB, c *fiber.Ctx, session *models.UserSession, refresh string) (fiber.Map, error) {
+	var user models.User
+	if err := UserForId(db, session.UserId, &user); err != nil {
+		return fiber.Map{}, err
+	}
+
+	access, expires, err := issueAccessToken(db, user, session)
+	if err != nil {
+		return fiber.Map{}, err
+	}
+	return sendTokens(c, user, session, access, expires, refresh), nil
+}
+
+// accessTokenRevoked whether the access token or its session was revoked
+func accessTokenRevoked(db *gorm.DB, claims *AccessTokenClaims) bool {
+	if sessionCache != nil {
+		n, err := sessionCache.Exists(context.Background(), revokedJtiKey(claims.ID), revokedSessionKey(claims.SessionId)).Result()
+		if err == nil {
+			return n > 0
+		}
+		fmt.Println(err)
+	}
+
+	var revoked int64
+	db.Model(&models.UserSession{}).Where("id = ? and revoked_at is not null", claims.SessionId).Count(&revoked)
+	return revoked > 0
+}
+
+func revokedJtiKey(jti string) string {
+	return "revoked-jti:" + jti
+}
+
+func revokedSessionKey(id uint) string {
+	return fmt.Sprintf("revoked-session:%d", id)
+}
+
+// defaultLegacyTokensUntil the last expiry of the tokens issued before the sessions, 180 days after they were introduced
+var defaultLegacyTokensUntil = time.Date(2027, time.April, 17, 0, 0, 0, 0, time.UTC)
+
+// legacyTokensExpire the last expiry of the tokens issued before the sessions, JWT_LEGACY_UNTIL as
+// an RFC 3339 time or a date when set
+func legacyTokensExpire() time.Time {
+	value := database.GetParam("JWT_LEGACY_UNTIL")
+	if until, err := time.Parse(time.RFC3339, value); err == nil {
+		return until
+	}
+	if until, err := time.Parse(time.DateOnly, value); err == nil {
+		return until
+	}
+	return defaultLegacyTokensUntil
+}
+
+// legacyTokenAccepted reports if a token without a session may be moved to one: they are only accepted
+// when JWT_LEGACY_TOKENS is true and look like the ones issued before the sessions, without iat and
+// expiring before legacyTokensExpire, so a token signed later with the shared secret cannot open a session
+func legacyTokenAccepted(claims *AccessTokenClaims) bool {
+	if database.GetParam("JWT_LEGACY_TOKENS") != "true" {
+		return false
+	}
+	return claims.IssuedAt == nil && claims.ExpiresAt != nil && claims.ExpiresAt.Time.Before(legacyTokensExpire())
+}
+
+// SessionUser the user of the access token cookie of a request, or of the refresh token cookie when the
+// access token expired. Sets the currentUser and sessionId locals. Tokens issued before the sessions
+// are rejected, unless JWT_LEGACY_TOKENS is true and they are moved to a new session.
+func SessionUser(db *gorm.DB, c *fiber.Ctx) (models.User, error) {
+
+	if user, ok := c.Locals("currentUser").(models.User); ok && c.Locals("sessionId") != nil {
+		return user, nil
+	}
+
+	if cookie := c.Cookies(database.GetParam("JWT_COOKIE")); cookie != "" {
+		if claims, err := ParseAccessToken(cookie); err == nil {
+			var user models.User
+			if err := UserForId(db, claims.UserId, &user); err != nil {
+				return user, err
+			}
+
+			if claims.SessionId == 0 {
+				if !legacyTokenAccepted(claims) {
+					return models.User{}, ErrNoSession
+				}
+				_, err := startSession(db, c, user, refreshTokenLifetime())
+				return user, err
+			}
+
+			if accessTokenRevoked(db, claims) {
+				ClearCookie(c)
+				return models.User{}, ErrSessionRevoked
+			}
+
+			c.Locals("currentUser", user)
+			c.Locals("sessionId", claims.SessionId)
+			return user, nil
+		}
+	}
+
+	refresh := c.Cookies(RefreshCookieName())
+	if refresh == "" {
+		return models.User{}, ErrNoSession
+	}
+
+	data, err := RefreshSession(db, c, refresh)
+	if err != nil {
+		ClearCookie(c)
+		return models.User{}, err
+	}
+	return data["user"].(models.User), nil
+}
+
+// RevokeSession end a session, its refresh token can no longer be used and its access tokens are rejected
+func RevokeSession(db *gorm.DB, session *models.UserSession, why string) error {
+	now := time.Now()
+	if session.RevokedAt == nil {
+		session.RevokedAt, session.RevokedWhy = &now, why
+		if err := db.Model(session).Updates(map[string]interface{}{"revoked_at": now, "revoked_why": why}).Error; err != nil {
+			return err
+		}
+	}
+
+	if sessionCache != nil {
+		ctx := context.Background()
+		ttl := accessTokenLifetime() + time.Minute
+		sessionCache.Set(ctx, revokedSessionKey(session.ID), why, ttl)
+		if session.AccessJti != "" {
+			sessionCache.Set(ctx, revokedJtiKey(session.AccessJti), why, ttl)
+		}
+	}
+	return nil
+}
+
+// RevokeUserSessions end the sessions of a user except one, 0 for all. Returns the number of sessions ended.
+func RevokeUserSessions(db *gorm.DB, userId, exceptId uint, why string) (int, error) {
+	var sessions []models.UserSession
+	db.Find(&sessions, "user_id = ? and id <> ? and revoked_at is null", userId, exceptId)
+
+	for i := range sessions {
+		if err := RevokeSession(db, &sessions[i], why); err != nil {
+			return i, err
+		}
+	}
+	return len(sessions), nil
+}
+
+// RevokeRequestSession end the session of the request, on logout
+func RevokeRequestSession(db *gorm.DB, c *fiber.Ctx) error {
+	var session models.UserSession
+
+	if sessionId, ok := c.Locals("sessionId").(uint); ok {
+		if err := db.First(&session, sessionId).Error; err == nil {
+			return RevokeSession(db, &session, models.SessionLogout)
+		}
+	}
+
+	if claims, err := ParseAccessToken(c.Cookies(database.GetParam("JWT_COOKIE"))); err == nil && claims.SessionId > 0 {
+		if err := db.First(&session, claims.SessionId).Error; err == nil {
+			return RevokeSession(db, &session, models.SessionLogout)
+		}
+	}
+
+	if refresh := c.Cookies(RefreshCookieName()); refresh != "" {
+		if err := db.First(&session, "refresh_hash = ?", hashRefreshToken(refresh)).Error; err == nil {
+			return RevokeSession(db, &session, models.SessionLogout)
+		}
+	}
+	return nil
+}
+
+// UserSessions the active sessions of a user, the most recently used first, with the app of each device
+func UserSessions(db *gorm.DB, c *fiber.Ctx, userId uint) []models.UserSession {
+	sessions := []models.UserSession{}
+	db.Order("last_used_at desc").Find(&sessions, "user_id = ? and revoked_at is null and expires_at > ?", userId, time.Now())
+
+	var apps []models.UserApp
+	db.Find(&apps, "user_id = ?", userId)
+
+	current, _ := c.Locals("sessionId").(uint)
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == current
+		for j := range apps {
+			if sessions[i].Package != "" && apps[j].Package == sessions[i].Package {
+				sessions[i].App = &apps[j]
+			}
+		}
+	}
+	return sessions
+}
+
+// PurgeOldSessions delete the sessions that expired or were revoked before a time
+func PurgeOldSessions(db *gorm.DB, before time.Time) (int64, error) {
+	result := db.Where("expires_at < ? or revoked_at < ?", before, before).Delete(&models.UserSession{})
+	return result.RowsAffected, result.Error
+}
+
+// newTokenId the unique ID of an access token
+func newTokenId() string {
+	return uuid.NewString()
+}
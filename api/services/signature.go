@@ -0,0 +1,222 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"myproject/api/database"
+	"myproject/api/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// the headers of a signed request. Clients send X-Signature-Version: 2 to sign with HMAC-SHA256,
+// requests without it use the deprecated MD5 signature in the body
+const (
+	SignatureVersionHeader   = "X-Signature-Version"
+	SignatureHeader          = "X-Signature"
+	SignatureNonceHeader     = "X-Signature-Nonce"
+	SignatureTimestampHeader = "X-Signature-Timestamp"
+)
+
+// the version of the signatures
+const (
+	SignatureLegacy = "1"
+	SignatureHmac   = "2"
+)
+
+// how far the timestamp of a signed request can be from the server clock unless SIGNATURE_SKEW_SECONDS is set
+const defaultSignatureSkew = 5 * time.Minute
+
+const maxNonceLength = 128
+
+var (
+	ErrSignatureMismatch       = errors.New("signature does not match")
+	ErrSignatureExpired        = errors.New("signature timestamp is outside the allowed window")
+	ErrSignatureMissing        = errors.New("missing signature, nonce or timestamp")
+	ErrNonceReused             = errors.New("nonce already used")
+	ErrLegacySignature         = errors.New("MD5 signatures are no longer accepted, sign with version 2")
+	ErrUnknownSignatureVersion = errors.New("unknown signature version")
+	ErrNoAppSigningKey         = errors.New("version 2 app signatures need the APP_SIGNING_KEY param")
+)
+
+// nonceCache remembers the nonces of the signed requests, nil to remember them in this process
+var nonceCache *redis.Client
+
+var (
+	recentNonces     = map[string]time.Time{}
+	recentNoncesLock sync.Mutex
+)
+
+// UseNonceCache remember the nonces of the signed requests in redis, shared by all the servers
+func UseNonceCache(rdb *redis.Client) {
+	nonceCache = rdb
+}
+
+func signatureSkew() time.Duration {
+	seconds, _ := strconv.Atoi(database.GetParam("SIGNATURE_SKEW_SECONDS"))
+	if seconds <= 0 {
+		return defaultSignatureSkew
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// legacySignatures whether the deprecated MD5 signatures are still accepted, until LEGACY_SIGNATURES is false
+func legacySignatures() bool {
+	return database.GetParam("LEGACY_SIGNATURES") != "false"
+}
+
+// SignRequest the version 2 signature of a request: the hex HMAC-SHA256 with the API key of the user of
+//
+//	2\n<METHOD>\n<path and query>\n<SHA-256 of the body>\n<timestamp>\n<nonce>\n<user ID>
+func SignRequest(key string, userId uint, method, path string, body []byte, timestamp, nonce string) string {
+	bodyHash := sha256.Sum256(body)
+	text := fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n%s\n%d", SignatureHmac, method, path, hex.EncodeToString(bodyHash[:]), timestamp, nonce, userId)
+
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(text))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature check a version 2 signature and its timestamp, the nonce is checked by ClaimNonce
+func VerifySignature(key string, userId uint, method, path string, body []byte, timestamp, nonce, signature string, now time.Time) error {
+	if signature == "" || nonce == "" || timestamp == "" || len(nonce) > maxNonceLength {
+		return ErrSignatureMissing
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrSignatureMissing
+	}
+	skew := now.Sub(time.Unix(seconds, 0))
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > signatureSkew() {
+		return ErrSignatureExpired
+	}
+
+	expected := SignRequest(key, userId, method, path, body, timestamp, nonce)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrSignatureMismatch
+	}
+	return nil
+}
+
+// ClaimNonce fails when the nonce of the user was already used within the skew window
+func ClaimNonce(userId uint, nonce string) error {
+	key := fmt.Sprintf("sig-nonce:%d:%s", userId, nonce)
+	ttl := 2 * signatureSkew()
+
+	if nonceCache != nil {
+		ok, err := nonceCache.SetNX(context.Background(), key, 1, ttl).Result()
+		if err == nil {
+			if !ok {
+				return ErrNonceReused
+			}
+			return nil
+		}
+		fmt.Println(err)
+	}
+
+	recentNoncesLock.Lock()
+	defer recentNoncesLock.Unlock()
+
+	now := time.Now()
+	if expires, ok := recentNonces[key]; ok && now.Before(expires) {
+		return ErrNonceReused
+	}
+	if len(recentNonces) > 10000 {
+		for k, expires := range recentNonces {
+			if now.After(expires) {
+				delete(recentNonces, k)
+			}
+		}
+	}
+	recentNonces[key] = now.Add(ttl)
+	return nil
+}
+
+// checkRequestSignature verify the signature of a request by the user in the version the client asked for
+func checkRequestSignature(c *fiber.Ctx, user models.User, nonce string, signature string) error {
+
+	switch c.Get(SignatureVersionHeader, SignatureLegacy) {
+	case SignatureHmac:
+		nonce = c.Get(SignatureNonceHeader)
+		if err := VerifySignature(user.ApiKey.String(), user.ID, c.Method(), c.OriginalURL(), c.Body(), c.Get(SignatureTimestampHeader), nonce, c.Get(SignatureHeader), time.Now()); err != nil {
+			return err
+		}
+		return ClaimNonce(user.ID, nonce)
+
+	case SignatureLegacy:
+		if !legacySignatures() {
+			return ErrLegacySignature
+		}
+		c.Set("Deprecation", "true")
+
+		if nonce == "" || len(nonce) > maxNonceLength {
+			return ErrSignatureMissing
+		}
+
+		// SETUP YOUR SIGNATURE FORMAT HERE E.G
+		text := fmt.Sprintf("%s-%d-%s-%s-%s-%s", user.ApiKey, user.ID, nonce, user.Email, user.Name, user.PubKey)
+		// and ensure to use the same format in clients
+
+		if !md5Matches(text, signature) {
+			return ErrSignatureMismatch
+		}
+		return ClaimNonce(user.ID, nonce)
+	}
+
+	return ErrUnknownSignatureVersion
+}
+
+// checkAppSignature verify the signature of a request by an app before the user is known. Version 2 signs
+// the request like a user with APP_SIGNING_KEY as the key and 0 as the user ID, the legacy MD5 signs text
+// which includes the nonce
+func checkAppSignature(c *fiber.Ctx, text string, nonce string, signature string) error {
+
+	switch c.Get(SignatureVersionHeader, SignatureLegacy) {
+	case SignatureHmac:
+		key := database.GetParam("APP_SIGNING_KEY")
+		if key == "" {
+			return ErrNoAppSigningKey
+		}
+		nonce = c.Get(SignatureNonceHeader)
+		if err := VerifySignature(key, 0, c.Method(), c.OriginalURL(), c.Body(), c.Get(SignatureTimestampHeader), nonce, c.Get(SignatureHeader), time.Now()); err != nil {
+			return err
+		}
+		return ClaimNonce(0, nonce)
+
+	case SignatureLegacy:
+		if !legacySignatures() {
+			return ErrLegacySignature
+		}
+		c.Set("Deprecation", "true")
+
+		if nonce == "" || len(nonce) > maxNonceLength {
+			return ErrSignatureMissing
+		}
+		if !md5Matches(text, signature) {
+			return ErrSignatureMismatch
+		}
+		return ClaimNonce(0, nonce)
+	}
+
+	return ErrUnknownSignatureVersion
+}
+
+func md5Matches(text string, signature string) bool {
+	hash := md5.Sum([]byte(text))
+	return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(hash[:])), []byte(signature)) == 1
+}
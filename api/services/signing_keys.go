@@ -0,0 +1,368 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"sync"
+	"time"
+
+	"myproject/api/database"
+	"myproject/api/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"gorm.io/gorm"
+)
+
+// the algorithms of the signing keys
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// how long a replaced key keeps verifying the tokens it signed unless JWT_KEY_OVERLAP_HOURS is set
+const defaultKeyOverlap = 24 * time.Hour
+
+// the keys are read again after this, so every server and prefork child picks up a rotation
+const keyringReload = time.Minute
+
+// an unknown kid reads the keys again at most this often
+const keyringMissReload = 10 * time.Second
+
+var (
+	ErrNoSigningKey      = errors.New("no active signing key, run cmd/jwtkeys -rotate")
+	ErrUnknownSigningKey = errors.New("unknown signing key")
+	ErrUnknownAlgorithm  = errors.New("unknown signing key algorithm, use RS256 or EdDSA")
+	ErrRetireActiveKey   = errors.New("the active key signs the new tokens, rotate it first")
+)
+
+type signingKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	private crypto.Signer // nil for the keys that only verify
+	public  crypto.PublicKey
+}
+
+type keyring struct {
+	active *signingKey
+	keys   map[string]*signingKey
+	order  []string // the kids, the active key first
+	loaded time.Time
+}
+
+var (
+	ring       = &keyring{keys: map[string]*signingKey{}}
+	ringLock   sync.RWMutex
+	ringDB     *gorm.DB
+	ringMissed time.Time
+)
+
+// UseSigningKeys sign the access tokens with the active key in the signing_keys table, and verify
+// them with any key not retired. Without keys the tokens are signed with JWT_SECRET.
+func UseSigningKeys(db *gorm.DB) error {
+	ringDB = db
+	return reloadSigningKeys()
+}
+
+// SetSigningKeys replace the keys used to sign and verify the access tokens
+func SetSigningKeys(records []models.SigningKey) error {
+	next := &keyring{keys: map[string]*signingKey{}, loaded: time.Now()}
+
+	for _, record := range records {
+		if record.Status == models.SigningKeyRetired || (record.Status == models.SigningKeyVerify && record.VerifyUntil != nil && time.Now().After(*record.VerifyUntil)) {
+			continue
+		}
+
+		key, err := parseSigningKey(record)
+		if err != nil {
+			return fmt.Errorf("signing key %s: %w", record.Kid, err)
+		}
+		if record.Status == models.SigningKeyActive {
+			next.active = key
+			next.order = append([]string{key.kid}, next.order...)
+		} else {
+			next.order = append(next.order, key.kid)
+		}
+		next.keys[key.kid] = key
+	}
+
+	ringLock.Lock()
+	ring = next
+	ringLock.Unlock()
+	return nil
+}
+
+func reloadSigningKeys() error {
+	var records []models.SigningKey
+	if err := ringDB.Order("created_at desc").Find(&records, "status <> ?", models.SigningKeyRetired).Error; err != nil {
+		return err
+	}
+	return SetSigningKeys(records)
+}
+
+// currentKeyring the keys, read again from the database once a minute
+func currentKeyring() *keyring {
+	ringLock.RLock()
+	current := ring
+	ringLock.RUnlock()
+
+	if ringDB != nil && time.Since(current.loaded) > keyringReload {
+		if err := reloadSigningKeys(); err != nil {
+			// keep the keys read last, and try again in a minute
+			fmt.Println("reloadSigningKeys", err)
+			ringLock.Lock()
+			ring.loaded = time.Now()
+			ringLock.Unlock()
+		}
+		ringLock.RLock()
+		current = ring
+		ringLock.RUnlock()
+	}
+	return current
+}
+
+// verificationKey the key of a kid, reading the keys again when another server rotated them
+func verificationKey(kid string) (*signingKey, error) {
+	if key, ok := currentKeyring().keys[kid]; ok {
+		return key, nil
+	}
+
+	ringLock.Lock()
+	reload := ringDB != nil && time.Since(ringMissed) > keyringMissReload
+	if reload {
+		ringMissed = time.Now()
+	}
+	ringLock.Unlock()
+
+	if reload {
+		if err := reloadSigningKeys(); err != nil {
+			fmt.Println("reloadSigningKeys", err)
+		}
+		if key, ok := currentKeyring().keys[kid]; ok {
+			return key, nil
+		}
+	}
+	return nil, ErrUnknownSigningKey
+}
+
+// sharedSecretTokens whether tokens are still signed and verified with JWT_SECRET: only until there is an
+// active key, and not at all when JWT_HS256 is false
+func sharedSecretTokens() bool {
+	return currentKeyring().active == nil && database.GetParam("JWT_HS256") != "false"
+}
+
+// signToken sign the claims with the active key, or JWT_SECRET when there is none
+func signToken(claims jwt.Claims) (string, error) {
+	if active := currentKeyring().active; active != nil {
+		token := jwt.NewWithClaims(active.method, claims)
+		token.Header["kid"] = active.kid
+		return token.SignedString(active.private)
+	}
+
+	if !sharedSecretTokens() {
+		return "", ErrNoSigningKey
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(database.GetParam("JWT_SECRET")))
+}
+
+// tokenKey the key that verifies a token, by its kid header
+func tokenKey(token *jwt.Token) (interface{}, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok || !sharedSecretTokens() {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return []byte(database.GetParam("JWT_SECRET")), nil
+	}
+
+	key, err := verificationKey(kid)
+	if err != nil {
+		return nil, err
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+	}
+	return key.public, nil
+}
+
+func parseSigningKey(record models.SigningKey) (*signingKey, error) {
+	key := &signingKey{kid: record.Kid}
+	switch record.Algorithm {
+	case AlgorithmRS256:
+		key.method = jwt.SigningMethodRS256
+	case AlgorithmEdDSA:
+		key.method = jwt.SigningMethodEdDSA
+	default:
+		return nil, ErrUnknownAlgorithm
+	}
+
+	block, _ := pem.Decode([]byte(record.PublicKey))
+	if block == nil {
+		return nil, errors.New("invalid public key PEM")
+	}
+	public, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key.public = public
+
+	if record.Status == models.SigningKeyActive {
+		block, _ := pem.Decode([]byte(record.PrivateKey))
+		if block == nil {
+			return nil, errors.New("invalid private key PEM")
+		}
+		private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := private.(crypto.Signer)
+		if !ok {
+			return nil, ErrUnknownAlgorithm
+		}
+		key.private = signer
+	}
+
+	switch key.public.(type) {
+	case *rsa.PublicKey:
+		if record.Algorithm != AlgorithmRS256 {
+			return nil, ErrUnknownAlgorithm
+		}
+	case ed25519.PublicKey:
+		if record.Algorithm != AlgorithmEdDSA {
+			return nil, ErrUnknownAlgorithm
+		}
+	default:
+		return nil, ErrUnknownAlgorithm
+	}
+	return key, nil
+}
+
+// NewSigningKey generate an active key pair, RS256 or EdDSA
+func NewSigningKey(algorithm string) (models.SigningKey, error) {
+	var private crypto.Signer
+	var err error
+	switch algorithm {
+	case AlgorithmRS256:
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case AlgorithmEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return models.SigningKey{}, ErrUnknownAlgorithm
+	}
+	if err != nil {
+		return models.SigningKey{}, err
+	}
+
+	privateDer, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return models.SigningKey{}, err
+	}
+	publicDer, err := x509.MarshalPKIXPublicKey(private.Public())
+	if err != nil {
+		return models.SigningKey{}, err
+	}
+
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+
+	return models.SigningKey{
+		Kid:        time.Now().UTC().Format("20060102") + "-" + hex.EncodeToString(suffix),
+		Algorithm:  algorithm,
+		PrivateKey: models.EncryptedString(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDer})),
+		PublicKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDer})),
+		Status:     models.SigningKeyActive,
+	}, nil
+}
+
+func keyOverlap() time.Duration {
+	hours, _ := strconv.Atoi(database.GetParam("JWT_KEY_OVERLAP_HOURS"))
+	if hours <= 0 {
+		return defaultKeyOverlap
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// RotateSigningKey make a new key the active key. The replaced key verifies the tokens it signed for
+// JWT_KEY_OVERLAP_HOURS, and the keys replaced before that are retired.
+func RotateSigningKey(db *gorm.DB, algorithm string) (models.SigningKey, error) {
+	key, err := NewSigningKey(algorithm)
+	if err != nil {
+		return key, err
+	}
+
+	now := time.Now()
+	verifyUntil := now.Add(keyOverlap())
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.SigningKey{}).Where("status = ? and verify_until < ?", models.SigningKeyVerify, now).
+			Updates(map[string]interface{}{"status": models.SigningKeyRetired, "retired_at": now}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.SigningKey{}).Where("status = ?", models.SigningKeyActive).
+			Updates(map[string]interface{}{"status": models.SigningKeyVerify, "verify_until": verifyUntil}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&key).Error
+	})
+	if err != nil {
+		return key, err
+	}
+
+	if ringDB == db {
+		reloadSigningKeys()
+	}
+	return key, nil
+}
+
+// RetireSigningKey stop trusting a replaced key, the tokens it signed are no longer valid
+func RetireSigningKey(db *gorm.DB, kid string) error {
+	var key models.SigningKey
+	if err := db.First(&key, "kid = ?", kid).Error; err != nil {
+		return err
+	}
+	if key.Status == models.SigningKeyActive {
+		return ErrRetireActiveKey
+	}
+
+	now := time.Now()
+	if err := db.Model(&key).Updates(map[string]interface{}{"status": models.SigningKeyRetired, "retired_at": now}).Error; err != nil {
+		return err
+	}
+
+	if ringDB == db {
+		reloadSigningKeys()
+	}
+	return nil
+}
+
+// Jwks the public keys that verify the access tokens, as a JSON Web Key Set
+func Jwks() fiber.Map {
+	current := currentKeyring()
+
+	keys := []fiber.Map{}
+	for _, kid := range current.order {
+		key := current.keys[kid]
+		jwk := fiber.Map{"kid": key.kid, "use": "sig", "alg": key.method.Alg()}
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk["kty"] = "RSA"
+			jwk["n"] = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk["e"] = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk["kty"] = "OKP"
+			jwk["crv"] = "Ed25519"
+			jwk["x"] = base64.RawURLEncoding.EncodeToString(public)
+		}
+		keys = append(keys, jwk)
+	}
+	return fiber.Map{"keys": keys}
+}
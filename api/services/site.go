@@ -0,0 +1,278 @@
+package services
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+
+	"myproject/api/models"
+
+	"gorm.io/gorm"
+)
+
+var ErrInvalidSiteNode = errors.New("invalid node")
+
+// siteKindRank the level of a kind of node, rooms and areas are at the same level
+func siteKindRank(kind string) int {
+	if kind == "area" {
+		return slices.Index(models.SiteNodeKinds, "room")
+	}
+	return slices.Index(models.SiteNodeKinds, kind)
+}
+
+// ValidSiteChild whether a node of kind can be in a node of parentKind, empty at the top of the location.
+// A node is below the level of its parent, or an area within an area or a room.
+func ValidSiteChild(parentKind, kind string) bool {
+	rank := siteKindRank(kind)
+	if rank < 0 {
+		return false
+	}
+	if parentKind == "" {
+		return true
+	}
+	parentRank := siteKindRank(parentKind)
+	return rank > parentRank || (kind == "area" && parentRank == rank)
+}
+
+// SiteTree the nodes of a location nested under their parents and ordered by position
+func SiteTree(nodes []models.LocationNode) []*models.LocationNode {
+	byId := map[uint]*models.LocationNode{}
+	for i := range nodes {
+		nodes[i].Children = nil
+		byId[nodes[i].ID] = &nodes[i]
+	}
+
+	roots := []*models.LocationNode{}
+	for i := range nodes {
+		node := &nodes[i]
+		if parent, ok := byId[node.ParentId]; ok {
+			parent.Children = append(parent.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+
+	var sortNodes func(list []*models.LocationNode)
+	sortNodes = func(list []*models.LocationNode) {
+		slices.SortStableFunc(list, func(a, b *models.LocationNode) int {
+			if a.Position != b.Position {
+				return a.Position - b.Position
+			}
+			return int(a.ID) - int(b.ID)
+		})
+		for _, node := range list {
+			sortNodes(node.Children)
+		}
+	}
+	sortNodes(roots)
+
+	return roots
+}
+
+// renumberSiblings place the node at position among the other children of its parent and number them from 0
+func renumberSiblings(tx *gorm.DB, node *models.LocationNode, position int) error {
+	var siblings []models.LocationNode
+	tx.Where("location_id = ? and parent_id = ? and id <> ?", node.LocationId, node.ParentId, node.ID).
+		Order("position, id").Find(&siblings)
+
+	position = min(max(position, 0), len(siblings))
+	ids := []uint{}
+	for _, sibling := range siblings {
+		ids = append(ids, sibling.ID)
+	}
+	ids = slices.Insert(ids, position, node.ID)
+
+	for i, id := range ids {
+		if err := tx.Model(&models.LocationNode{}).Where("id = ?", id).Update("position", i).Error; err != nil {
+			return err
+		}
+	}
+	node.Position = position
+	return nil
+}
+
+// CreateSiteNode add a node to the tree of its location at its position among the children of its parent
+func CreateSiteNode(db *gorm.DB, node *models.LocationNode) error {
+
+	node.Name = strings.TrimSpace(node.Name)
+	if node.Name == "" {
+		return errors.Join(ErrInvalidSiteNode, errors.New("a name is required"))
+	}
+
+	var parent *models.LocationNode
+	if node.ParentId > 0 {
+		parent = new(models.LocationNode)
+		if err := db.First(parent, "id = ? and location_id = ?", node.ParentId, node.LocationId).Error; err != nil {
+			return errors.Join(ErrInvalidSiteNode, errors.New("the parent is not in the location"))
+		}
+	}
+	parentKind := ""
+	if parent != nil {
+		parentKind = parent.Kind
+	}
+	if !ValidSiteChild(parentKind, node.Kind) {
+		return errors.Join(ErrInvalidSiteNode, errors.New("a "+node.Kind+" cannot be in a "+parentKind))
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		position := node.Position
+		if err := tx.Create(node).Error; err != nil {
+			return err
+		}
+
+		node.Path = parent.ChildPath(node.ID)
+		node.Depth = strings.Count(node.Path, "/") - 2
+		if err := tx.Model(node).Updates(map[string]interface{}{"path": node.Path, "depth": node.Depth}).Error; err != nil {
+			return err
+		}
+
+		return renumberSiblings(tx, node, position)
+	})
+}
+
+// MoveSiteNode move a node with its subtree under another parent of the same location, 0 for the top,
+// at a position among the children of the parent
+func MoveSiteNode(db *gorm.DB, node *models.LocationNode, parentId uint, position int) error {
+
+	var parent *models.LocationNode
+	if parentId > 0 {
+		parent = new(models.LocationNode)
+		if err := db.First(parent, "id = ? and location_id = ?", parentId, node.LocationId).Error; err != nil {
+			return errors.Join(ErrInvalidSiteNode, errors.New("the parent is not in the location"))
+		}
+		if strings.HasPrefix(parent.Path, node.Path) {
+			return errors.Join(ErrInvalidSiteNode, errors.New("a node cannot be moved into itself"))
+		}
+	}
+	parentKind := ""
+	if parent != nil {
+		parentKind = parent.Kind
+	}
+	if !ValidSiteChild(parentKind, node.Kind) {
+		return errors.Join(ErrInvalidSiteNode, errors.New("a "+node.Kind+" cannot be in a "+parentKind))
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		oldParent, oldPath := node.ParentId, node.Path
+		newPath := parent.ChildPath(node.ID)
+		depthChange := strings.Count(newPath, "/") - strings.Count(oldPath, "/")
+
+		if oldPath != newPath {
+			if err := tx.Exec(`update location_nodes set path = ? || substr(path, ?), depth = depth + ?
+				where location_id = ? and path like ?`,
+				newPath, len(oldPath)+1, depthChange, node.LocationId, oldPath+"%").Error; err != nil {
+				return err
+			}
+		}
+
+		node.ParentId, node.Path, node.Depth = parentId, newPath, node.Depth+depthChange
+		if err := tx.Model(node).Update("parent_id", parentId).Error; err != nil {
+			return err
+		}
+		if err := renumberSiblings(tx, node, position); err != nil {
+			return err
+		}
+
+		if oldParent != parentId {
+			// close the gap left among the old siblings
+			var siblings []models.LocationNode
+			tx.Where("location_id = ? and parent_id = ?", node.LocationId, oldParent).Order("position, id").Find(&siblings)
+			for i, sibling := range siblings {
+				if err := tx.Model(&sibling).Update("position", i).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// SiteRollup the totals of a node and its subtree
+type SiteRollup struct {
+	Nodes     int   `json:"nodes"`     // below the node
+	Equipment int   `json:"equipment"` // placed in the subtree
+	Bins      int   `json:"bins"`
+	Stock     int64 `json:"stock"`    // quantity of the parts and consumables in the bins and their sub bins
+	OpenWork  int   `json:"openWork"` // active sub contractor assignments on the equipment
+}
+
+// SiteRollups the totals of every node of a location by node ID
+func SiteRollups(db *gorm.DB, locationId uint) (map[uint]*SiteRollup, error) {
+
+	var nodes []models.LocationNode
+	if err := db.Select("id, path").Find(&nodes, "location_id = ?", locationId).Error; err != nil {
+		return nil, err
+	}
+
+	// the totals placed directly in each node
+	var own []struct {
+		NodeId    uint
+		Equipment int
+		Bins      int
+		Stock     int64
+		OpenWork  int
+	}
+	err := db.Raw(`with recursive placed as (
+			select p.node_id, p.kind, p.object_id from location_placements p
+			join location_nodes n on n.id = p.node_id where n.location_id = @location
+		), bin_tree as (
+			select node_id, object_id as bin_id from placed where kind = 'bin'
+			union
+			select t.node_id, b.id from bins b join bin_tree t on b.bin_id = t.bin_id where b.deleted_at is null
+		)
+		select n.id as node_id,
+			(select count(*) from placed where placed.node_id = n.id and kind = 'equipment') as equipment,
+			(select count(*) from placed where placed.node_id = n.id and kind = 'bin') as bins,
+			(select coalesce(sum(i.quantity), 0) from bin_tree t join bin_infos i on i.bin_id = t.bin_id where t.node_id = n.id) as stock,
+			(select count(*) from sub_contractor_assignments a join placed on placed.kind = 'equipment' and placed.object_id = a.equipment_id
+				where placed.node_id = n.id and a.status = 'active') as open_work
+		from location_nodes n where n.location_id = @location`,
+		map[string]interface{}{"location": locationId}).Scan(&own).Error
+	if err != nil {
+		return nil, err
+	}
+
+	rollups := map[uint]*SiteRollup{}
+	for _, node := range nodes {
+		rollups[node.ID] = &SiteRollup{}
+	}
+
+	pathOf := map[uint]string{}
+	for _, node := range nodes {
+		pathOf[node.ID] = node.Path
+	}
+
+	// add the totals of each node to it and its ancestors
+	for _, totals := range own {
+		for _, id := range pathIds(pathOf[totals.NodeId]) {
+			if rollup, ok := rollups[id]; ok {
+				rollup.Equipment += totals.Equipment
+				rollup.Bins += totals.Bins
+				rollup.Stock += totals.Stock
+				rollup.OpenWork += totals.OpenWork
+			}
+		}
+	}
+	for _, node := range nodes {
+		ids := pathIds(node.Path)
+		for _, id := range ids[:max(len(ids)-1, 0)] {
+			if rollup, ok := rollups[id]; ok {
+				rollup.Nodes++
+			}
+		}
+	}
+
+	return rollups, nil
+}
+
+// pathIds the IDs of a path from the top
+func pathIds(path string) []uint {
+	ids := []uint{}
+	for _, part := range strings.Split(strings.Trim(path, "/"), "/") {
+		if id, err := strconv.ParseUint(part, 10, 64); err == nil {
+			ids = append(ids, uint(id))
+		}
+	}
+	return ids
+}
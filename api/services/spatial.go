@@ -0,0 +1,253 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"myproject/api/utils"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// the kinds of spatial query
+const (
+	SpatialRadius  = "radius"  // within Radius metres of Lat,Lng
+	SpatialBox     = "bbox"    // within the bounding box [minLng, minLat, maxLng, maxLat]
+	SpatialPolygon = "polygon" // within a polygon of [lng, lat] points, GeoJSON order
+	SpatialNearest = "nearest" // the Limit closest to Lat,Lng
+)
+
+// maxSpatialResults the most results a spatial query returns
+const maxSpatialResults = 1000
+
+// maxSpatialRadius the largest radius of a query, in metres
+const maxSpatialRadius = 500000
+
+var ErrInvalidSpatialQuery = errors.New("invalid spatial query")
+
+// SpatialQuery a search of the rows of a table with a location geography column.
+// Only the values are sent to the database, as query parameters.
+type SpatialQuery struct {
+	Within  string       `json:"within"` // radius, bbox, polygon or nearest
+	Lat     *float64     `json:"lat"`    // the centre of a radius or nearest query, the origin of the distances of the others
+	Lng     *float64     `json:"lng"`
+	Radius  float64      `json:"radius"` // metres
+	Bbox    []float64    `json:"bbox"`
+	Polygon [][2]float64 `json:"polygon"`
+	Limit   int          `json:"limit"`
+	Sort    string       `json:"sort"` // distance, or the order of the IDs when empty
+}
+
+// SpatialHit a row found by a spatial query and its distance in metres from the origin of the query, 0 without an origin
+type SpatialHit struct {
+	ID       uint    `json:"id"`
+	Distance float64 `json:"distance"`
+}
+
+func validLatLng(lat, lng float64) bool {
+	return lat >= -90 && lat <= 90 && lng >= -180 && lng <= 180
+}
+
+// HasOrigin whether the distances of the results can be measured
+func (q *SpatialQuery) HasOrigin() bool {
+	return q.Lat != nil && q.Lng != nil
+}
+
+// Validate check the values of the query and set the defaults
+func (q *SpatialQuery) Validate() error {
+	invalid := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%w: %s", ErrInvalidSpatialQuery, fmt.Sprintf(format, args...))
+	}
+
+	if q.HasOrigin() && !validLatLng(*q.Lat, *q.Lng) {
+		return invalid("lat must be between -90 and 90 and lng between -180 and 180")
+	}
+
+	switch q.Within {
+	case SpatialRadius:
+		if !q.HasOrigin() {
+			return invalid("lat and lng are required")
+		}
+		if q.Radius <= 0 || q.Radius > maxSpatialRadius {
+			return invalid("radius must be more than 0 and at most %d metres", maxSpatialRadius)
+		}
+	case SpatialNearest:
+		if !q.HasOrigin() {
+			return invalid("lat and lng are required")
+		}
+		if q.Limit == 0 {
+			q.Limit = 10
+		}
+		q.Sort = "distance"
+	case SpatialBox:
+		if len(q.Bbox) != 4 || !validLatLng(q.Bbox[1], q.Bbox[0]) || !validLatLng(q.Bbox[3], q.Bbox[2]) ||
+			q.Bbox[0] >= q.Bbox[2] || q.Bbox[1] >= q.Bbox[3] {
+			return invalid("bbox must be [minLng, minLat, maxLng, maxLat]")
+		}
+	case SpatialPolygon:
+		points := len(q.Polygon)
+		if points > 0 && q.Polygon[0] != q.Polygon[points-1] {
+			// close the ring
+			q.Polygon = append(q.Polygon, q.Polygon[0])
+			points++
+		}
+		if points < 4 {
+			return invalid("a polygon needs at least 3 points")
+		}
+		if points > 1000 {
+			return invalid("a polygon can have at most 1000 points")
+		}
+		for _, point := range q.Polygon {
+			if !validLatLng(point[1], point[0]) {
+				return invalid("polygon points are [lng, lat]")
+			}
+		}
+	default:
+		return invalid("within must be radius, bbox, polygon or nearest")
+	}
+
+	if q.Sort != "" && q.Sort != "distance" {
+		return invalid("sort must be distance")
+	}
+	if q.Sort == "distance" && !q.HasOrigin() {
+		return invalid("lat and lng are required to sort by distance")
+	}
+
+	if q.Limit <= 0 {
+		q.Limit = 100
+	}
+	q.Limit = min(q.Limit, maxSpatialResults)
+
+	return nil
+}
+
+// ParseRadiusQuery a radius query from the lat, lng and radius of a URL
+func ParseRadiusQuery(lat, lng, radius string) (SpatialQuery, error) {
+	plat, err1 := strconv.ParseFloat(lat, 64)
+	plng, err2 := strconv.ParseFloat(lng, 64)
+	pradius, err3 := strconv.ParseFloat(radius, 64)
+	if err := errors.Join(err1, err2, err3); err != nil {
+		return SpatialQuery{}, fmt.Errorf("%w: lat, lng and radius must be numbers", ErrInvalidSpatialQuery)
+	}
+
+	q := SpatialQuery{Within: SpatialRadius, Lat: &plat, Lng: &plng, Radius: pradius, Sort: "distance", Limit: maxSpatialResults}
+	return q, q.Validate()
+}
+
+// distanceOrder order by the distance from the origin of the query, using the spatial index
+func (q *SpatialQuery) distanceOrder(column string) clause.Expr {
+	return gorm.Expr(column+" <-> ST_SetSRID(ST_MakePoint(?, ?), 4326)::geography", *q.Lng, *q.Lat)
+}
+
+// Apply add the spatial condition, the order and the limit of the query to db, for a table with a location column
+func (q *SpatialQuery) Apply(db *gorm.DB, table string) *gorm.DB {
+	column := table + ".location"
+	db = db.Where(column + " is not null")
+
+	switch q.Within {
+	case SpatialRadius:
+		db = db.Where("ST_DWithin("+column+", ST_SetSRID(ST_MakePoint(?, ?), 4326)::geography, ?)", *q.Lng, *q.Lat, q.Radius)
+	case SpatialBox:
+		db = db.Where("ST_Intersects("+column+"::geometry, ST_MakeEnvelope(?, ?, ?, ?, 4326))", q.Bbox[0], q.Bbox[1], q.Bbox[2], q.Bbox[3])
+	case SpatialPolygon:
+		polygon, _ := json.Marshal(map[string]interface{}{"type": "Polygon", "coordinates": [][][2]float64{q.Polygon}})
+		db = db.Where("ST_Intersects("+column+"::geometry, ST_SetSRID(ST_GeomFromGeoJSON(?), 4326))", string(polygon))
+	}
+
+	if q.Sort == "distance" {
+		db = db.Order(clause.OrderBy{Expression: q.distanceOrder(column)})
+	} else {
+		db = db.Order(table + ".id")
+	}
+
+	return db.Limit(q.Limit)
+}
+
+// SpatialSearch the IDs of the rows of the table matching the query and their distance,
+// filter adds conditions on the table e.g. the business of the locations
+func SpatialSearch(db *gorm.DB, table string, q SpatialQuery, filter func(*gorm.DB) *gorm.DB) ([]SpatialHit, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+
+	tx := db.Table(table).Where(table + ".deleted_at is null")
+	if q.HasOrigin() {
+		tx = tx.Select(table+".id, ST_Distance("+table+".location, ST_SetSRID(ST_MakePoint(?, ?), 4326)::geography) as distance", *q.Lng, *q.Lat)
+	} else {
+		tx = tx.Select(table + ".id, 0 as distance")
+	}
+	if filter != nil {
+		tx = filter(tx)
+	}
+
+	hits := []SpatialHit{}
+	err := q.Apply(tx, table).Scan(&hits).Error
+	return hits, err
+}
+
+// SpatialIds the IDs of the hits in order
+func SpatialIds(hits []SpatialHit) []uint {
+	ids := make([]uint, len(hits))
+	for i, hit := range hits {
+		ids[i] = hit.ID
+	}
+	return ids
+}
+
+// GeoJSONGeometry a GeoJSON point
+type GeoJSONGeometry struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"` // [lng, lat]
+}
+
+type GeoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Id         uint                   `json:"id"`
+	Geometry   *GeoJSONGeometry       `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type GeoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []GeoJSONFeature `json:"features"`
+}
+
+// NewGeoJSONFeature a point feature at latlng with the selected JSON fields of v as properties,
+// the geometry is null when latlng is empty
+func NewGeoJSONFeature(id uint, latlng string, v interface{}, properties []string) GeoJSONFeature {
+	feature := GeoJSONFeature{Type: "Feature", Id: id, Properties: map[string]interface{}{}}
+
+	if latlng != "" {
+		lat, lng := utils.ExtractLatLng(latlng)
+		feature.Geometry = &GeoJSONGeometry{Type: "Point", Coordinates: [2]float64{lng, lat}}
+	}
+
+	data, _ := json.Marshal(v)
+	var fields map[string]interface{}
+	json.Unmarshal(data, &fields)
+	for _, name := range properties {
+		if value, ok := fields[name]; ok {
+			feature.Properties[name] = value
+		}
+	}
+
+	return feature
+}
+
+// GeoJSONProperties the properties asked for that are allowed, all the allowed ones when none are asked for
+func GeoJSONProperties(asked []string, allowed []string) []string {
+	if len(asked) == 0 {
+		return allowed
+	}
+	properties := []string{}
+	for _, name := range asked {
+		if slices.Contains(allowed, name) {
+			properties = append(properties, name)
+		}
+	}
+	return properties
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"errors"
+
+	"myproject/api/database"
+	"myproject/api/models"
+	"myproject/api/utils"
+
+	"gorm.io/gorm"
+)
+
+var ErrSubdomainTaken = errors.New("the subdomain is already taken")
+
+// SubdomainAvailable checks the subdomain is valid and not used, or previously used,
+// by a business other than businessId
+func SubdomainAvailable(db *gorm.DB, subdomain string, businessId uint) error {
+
+	if err := utils.ValidateSubdomain(subdomain); err != nil {
+		return err
+	}
+
+	lookup := database.WithoutTenant(db)
+
+	var count int64
+	// deleted businesses keep their subdomain until they are purged
+	lookup.Unscoped().Model(&models.Business{}).Where("lower(uuid) = ? and id <> ?", subdomain, businessId).Count(&count)
+	if count > 0 {
+		return ErrSubdomainTaken
+	}
+
+	lookup.Model(&models.SubdomainRedirect{}).Where("uuid = ? and business_id <> ?", subdomain, businessId).Count(&count)
+	if count > 0 {
+		return ErrSubdomainTaken
+	}
+
+	return nil
+}
+
+// BusinessForSubdomain finds the enabled business served on a subdomain. When the subdomain
+// was changed it returns the business with moved set, the caller should redirect to business.Uuid
+func BusinessForSubdomain(db *gorm.DB, subdomain string) (business models.Business, moved bool, err error) {
+
+	result := db.Preload("Locations").Preload("Categories").
+		First(&business, "lower(uuid) = ? and enabled = true", subdomain)
+	if result.Error == nil {
+		return business, false, nil
+	}
+	if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return business, false, result.Error
+	}
+
+	var redirect models.SubdomainRedirect
+	if result := db.First(&redirect, "uuid = ?", subdomain); result.Error != nil {
+		return business, false, result.Error
+	}
+
+	if result := db.First(&business, "id = ? and enabled = true", redirect.BusinessId); result.Error != nil {
+		return business, false, result.Error
+	}
+
+	return business, true, nil
+}
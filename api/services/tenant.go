@@ -0,0 +1,149 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"myproject/api/database"
+	"myproject/api/models"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// TenantSource resolves the business a request acts on
+type TenantSource func(c *fiber.Ctx, db *gorm.DB) (uint, error)
+
+// TenantFromParam reads the business ID from a route param
+func TenantFromParam(name string) TenantSource {
+	return func(c *fiber.Ctx, db *gorm.DB) (uint, error) {
+		id, err := strconv.ParseUint(c.Params(name), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid business ID param %s", name)
+		}
+		return uint(id), nil
+	}
+}
+
+// TenantFromBody reads the businessId field of the request body
+func TenantFromBody() TenantSource {
+	return func(c *fiber.Ctx, db *gorm.DB) (uint, error) {
+		var body struct {
+			BusinessId uint `json:"businessId" form:"businessId"`
+		}
+		if err := c.BodyParser(&body); err != nil {
+			return 0, err
+		}
+		if body.BusinessId == 0 {
+			return 0, errors.New("missing businessId")
+		}
+		return body.BusinessId, nil
+	}
+}
+
+// TenantFromRecord reads the business ID of the row of table whose id is in the route param
+func TenantFromRecord(table string, param string) TenantSource {
+	return func(c *fiber.Ctx, db *gorm.DB) (uint, error) {
+		var businessIds []uint
+		if err := db.Table(table).Where("id = ?", c.Params(param)).Pluck("business_id", &businessIds).Error; err != nil {
+			return 0, err
+		}
+		if len(businessIds) == 0 {
+			return 0, gorm.ErrRecordNotFound
+		}
+		return businessIds[0], nil
+	}
+}
+
+// tenantUser returns the authenticated user from the session or the request signature
+func tenantUser(c *fiber.Ctx, db *gorm.DB) (models.User, error) {
+	if user, ok := c.Locals("currentUser").(models.User); ok && user.ID > 0 {
+		return user, nil
+	}
+
+	sig := new(models.Signature)
+	if err := c.BodyParser(sig); err != nil {
+		return models.User{}, err
+	}
+
+	userId := sig.SignerId
+	if userId == 0 {
+		userId = sig.UserId
+	}
+	if userId == 0 {
+		return models.User{}, errors.New("no user for request")
+	}
+
+	return checkUserSignature(c, db, userId, sig.Nonce, sig.Signature)
+}
+
+// RequireTenant checks the current user can access the business resolved by source
+// and scopes the request context to it. Use TenantDB in handlers to run queries in that scope.
+func RequireTenant(db *gorm.DB, source TenantSource) fiber.Handler {
+	return requireTenant(db, source, CanAccessBusiness)
+}
+
+// RequireTenantRead is RequireTenant for the routes that only read, which the providers
+// servicing the business may also use
+func RequireTenantRead(db *gorm.DB, source TenantSource) fiber.Handler {
+	return requireTenant(db, source, CanReadBusiness)
+}
+
+func requireTenant(db *gorm.DB, source TenantSource, allowed func(*gorm.DB, models.User, uint) bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, err := tenantUser(c, db)
+		if err != nil {
+			if os.Getenv("USE_DOCKER") == "true" {
+				// no-op - allow anonymous requests
+				return c.Next()
+			}
+			fmt.Println(err)
+			return c.Status(fiber.StatusUnauthorized).SendString("unauthorized")
+		}
+
+		businessId, err := source(c, db)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// without a scope the handler would save a new row for any business
+			c.Status(fiber.StatusNotFound)
+			return SendJsonResult(c, fiber.Map{"error": "record not found"})
+		} else if err != nil {
+			fmt.Println(err)
+			c.Status(fiber.StatusBadRequest)
+			return SendJsonResult(c, fiber.Map{"error": err.Error()})
+		}
+
+		if !allowed(db, user, businessId) {
+			fmt.Println(database.ErrCrossTenant, user.ID, businessId)
+			c.Status(fiber.StatusForbidden)
+			return SendJsonResult(c, fiber.Map{"error": database.ErrCrossTenant.Error()})
+		}
+
+		c.SetUserContext(database.WithTenant(c.UserContext(), businessId))
+		c.Locals(tenantUserKey, user.ID)
+
+		return c.Next()
+	}
+}
+
+// TenantID returns the business ID the request was scoped to by RequireTenant
+func TenantID(c *fiber.Ctx) (uint, bool) {
+	return database.TenantFromContext(c.UserContext())
+}
+
+// tenantUserKey is the Locals key of the ID of the user checked by RequireTenant
+const tenantUserKey = "tenantUserId"
+
+// TenantUserID returns the ID of the user checked by RequireTenant, 0 if none
+func TenantUserID(c *fiber.Ctx) uint {
+	if id, ok := c.Locals(tenantUserKey).(uint); ok {
+		return id
+	}
+	return 0
+}
+
+// TenantDB returns db scoped to the tenant of the request, if any
+func TenantDB(db *gorm.DB, c *fiber.Ctx) *gorm.DB {
+	return db.WithContext(c.UserContext())
+}
@@ -0,0 +1,406 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"myproject/api/database"
+	"myproject/api/models"
+	"myproject/api/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// RFC 6238 codes of 6 digits every 30 seconds, a code of the step before or after is accepted for clock drift
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	totpDrift  = 1
+)
+
+const recoveryCodeCount = 10
+
+// a login challenge must be answered within this, with at most maxChallengeAttempts codes.
+// After maxFailedAttempts invalid codes, in any number of challenges, the user is locked out for twoFactorLockout
+const (
+	challengeLifetime    = 5 * time.Minute
+	maxChallengeAttempts = 5
+	maxFailedAttempts    = 10
+	twoFactorLockout     = 15 * time.Minute
+)
+
+var (
+	ErrInvalidTwoFactorCode = errors.New("invalid authentication code")
+	ErrTwoFactorEnabled     = errors.New("two factor authentication is already enabled")
+	ErrTwoFactorNotEnrolled = errors.New("two factor authentication is not enabled")
+	ErrTwoFactorRequired    = errors.New("a business of the user requires two factor authentication")
+	ErrInvalidChallenge     = errors.New("invalid or expired login challenge")
+	ErrTwoFactorLocked      = errors.New("too many invalid authentication codes, try again later")
+)
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTotpSecret a random base32 secret of 160 bits
+func NewTotpSecret() string {
+	b := make([]byte, 20)
+	rand.Read(b)
+	return base32NoPadding.EncodeToString(b)
+}
+
+// TotpCode the code of a base32 secret for a time step
+func TotpCode(secret string, step int64) (string, error) {
+	key, err := base32NoPadding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", err
+	}
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000), nil
+}
+
+// VerifyTotp the time step of the code when it is valid at the time and newer than the last step used
+func VerifyTotp(secret, code string, now time.Time, lastStep int64) (int64, bool) {
+	code = strings.ReplaceAll(code, " ", "")
+	current := now.Unix() / totpPeriod
+	for step := current - totpDrift; step <= current+totpDrift; step++ {
+		if step <= lastStep {
+			continue
+		}
+		expected, err := TotpCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// TotpURI the otpauth URI that authenticator apps read from a QR code
+func TotpURI(account, secret string) string {
+	issuer := database.GetParam("TOTP_ISSUER")
+	if issuer == "" {
+		issuer = "myproject"
+	}
+
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+account) + "?" + query.Encode()
+}
+
+func hashSecret(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// TwoFactorEnabled whether the user confirmed a TOTP second factor
+func TwoFactorEnabled(db *gorm.DB, userId uint) bool {
+	var count int64
+	db.Model(&models.UserTwoFactor{}).Where("user_id = ? and confirmed_at is not null", userId).Count(&count)
+	return count > 0
+}
+
+// TwoFactorRequired whether a business the user owns or is in the team of requires a second factor
+func TwoFactorRequired(db *gorm.DB, userId uint) bool {
+	var count int64
+	db.Model(&models.Business{}).
+		Where("require_two_factor and (user_id = ? or id in (select business_id from business_roles where role_id = ?))", userId, userId).
+		Count(&count)
+	return count > 0
+}
+
+// NeedsTwoFactor whether the user must give a TOTP code after the first factor to log in
+func NeedsTwoFactor(db *gorm.DB, userId uint) bool {
+	return TwoFactorEnabled(db, userId) || TwoFactorRequired(db, userId)
+}
+
+// EnrolTwoFactor the secret for the user to add to an authenticator app, with its otpauth URI and QR code.
+// A pending secret is shown again until it is confirmed with a code, so the app the user
+// already scanned it into keeps working. It is not required at login until confirmed.
+func EnrolTwoFactor(db *gorm.DB, user models.User) (fiber.Map, error) {
+	var twoFactor models.UserTwoFactor
+	db.Limit(1).Find(&twoFactor, "user_id = ?", user.ID)
+	if twoFactor.ConfirmedAt != nil {
+		return fiber.Map{}, ErrTwoFactorEnabled
+	}
+
+	secret := string(twoFactor.Secret)
+	pending := secret != ""
+	if !pending {
+		secret = NewTotpSecret()
+	}
+
+	// the QR code first, a URI too long for one leaves no enrolment the user cannot scan
+	uri := TotpURI(user.Email, secret)
+	qr, err := utils.QRCodePNG(uri, 6)
+	if err != nil {
+		return fiber.Map{}, err
+	}
+
+	if !pending {
+		twoFactor.UserId = user.ID
+		twoFactor.Secret = models.EncryptedString(secret)
+		twoFactor.LastStep = 0
+		if err := db.Save(&twoFactor).Error; err != nil {
+			return fiber.Map{}, err
+		}
+	}
+
+	return fiber.Map{
+		"secret": secret,
+		"uri":    uri,
+		"qr":     "data:image/png;base64," + base64.StdEncoding.EncodeToString(qr),
+	}, nil
+}
+
+// ConfirmTwoFactor enable the second factor of the user with a code of the enrolled secret,
+// returns the recovery codes that are only shown once
+func ConfirmTwoFactor(db *gorm.DB, userId uint, code string) ([]string, error) {
+	var twoFactor models.UserTwoFactor
+	if err := db.First(&twoFactor, "user_id = ? and secret <> ''", userId).Error; err != nil {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+	if twoFactor.ConfirmedAt != nil {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	step, ok := VerifyTotp(string(twoFactor.Secret), code, time.Now(), twoFactor.LastStep)
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	now := time.Now()
+	if err := db.Model(&twoFactor).Updates(map[string]interface{}{"confirmed_at": now, "last_step": step}).Error; err != nil {
+		return nil, err
+	}
+	return NewRecoveryCodes(db, userId)
+}
+
+// NewRecoveryCodes replace the recovery codes of the user, each logs in once instead of a TOTP code.
+// They are stored hashed as data items.
+func NewRecoveryCodes(db *gorm.DB, userId uint) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? and kind = ?", userId, models.DataItemRecoveryCode).Delete(&models.DataItem{}).Error; err != nil {
+			return err
+		}
+
+		for i := range codes {
+			b := make([]byte, 5)
+			rand.Read(b)
+			code := strings.ToLower(base32NoPadding.EncodeToString(b))
+			codes[i] = code[:4] + "-" + code[4:]
+
+			item := models.DataItem{UserId: userId, Kind: models.DataItemRecoveryCode, Value: models.EncryptedString(hashSecret(codes[i]))}
+			if err := tx.Create(&item).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// useRecoveryCode delete the recovery code of the user when it matches
+func useRecoveryCode(db *gorm.DB, userId uint, code string) bool {
+	var items []models.DataItem
+	db.Find(&items, "user_id = ? and kind = ?", userId, models.DataItemRecoveryCode)
+
+	hash := hashSecret(strings.ToLower(strings.TrimSpace(code)))
+	for _, item := range items {
+		if subtle.ConstantTimeCompare([]byte(item.Value), []byte(hash)) == 1 {
+			return db.Delete(&item).RowsAffected == 1
+		}
+	}
+	return false
+}
+
+// CheckTwoFactorCode accept a TOTP code not used before, or a recovery code
+func CheckTwoFactorCode(db *gorm.DB, userId uint, code string) error {
+	var twoFactor models.UserTwoFactor
+	if err := db.First(&twoFactor, "user_id = ? and confirmed_at is not null", userId).Error; err != nil {
+		return ErrTwoFactorNotEnrolled
+	}
+
+	if strings.Contains(code, "-") {
+		if useRecoveryCode(db, userId, code) {
+			return nil
+		}
+		return ErrInvalidTwoFactorCode
+	}
+
+	step, ok := VerifyTotp(string(twoFactor.Secret), code, time.Now(), twoFactor.LastStep)
+	if !ok {
+		return ErrInvalidTwoFactorCode
+	}
+
+	// a parallel request with the same code loses
+	result := db.Model(&models.UserTwoFactor{}).Where("id = ? and last_step < ?", twoFactor.ID, step).Update("last_step", step)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// DisableTwoFactor remove the second factor of the user after checking a code, unless a business requires it
+func DisableTwoFactor(db *gorm.DB, userId uint, code string) error {
+	if TwoFactorRequired(db, userId) {
+		return ErrTwoFactorRequired
+	}
+	if err := CheckTwoFactorCode(db, userId, code); err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? and kind = ?", userId, models.DataItemRecoveryCode).Delete(&models.DataItem{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userId).Delete(&models.UserTwoFactor{}).Error
+	})
+}
+
+// StartTwoFactorChallenge the challenge a user who gave their first factor answers with a TOTP code
+// to get a session. Users a business requires it of and who have not enrolled enrol with the challenge.
+func StartTwoFactorChallenge(db *gorm.DB, user models.User) (fiber.Map, error) {
+	b := make([]byte, 32)
+	rand.Read(b)
+	challenge := base64.RawURLEncoding.EncodeToString(b)
+
+	var twoFactor models.UserTwoFactor
+	db.Limit(1).Find(&twoFactor, "user_id = ?", user.ID)
+	if twoFactorLocked(twoFactor) {
+		return fiber.Map{}, ErrTwoFactorLocked
+	}
+
+	expires := time.Now().Add(challengeLifetime)
+	twoFactor.UserId = user.ID
+	twoFactor.ChallengeHash = hashSecret(challenge)
+	twoFactor.ChallengeExpiresAt = &expires
+	twoFactor.ChallengeAttempts = 0
+	if err := db.Save(&twoFactor).Error; err != nil {
+		return fiber.Map{}, err
+	}
+
+	return fiber.Map{
+		"twoFactorRequired": true,
+		"challenge":         challenge,
+		"enrol":             twoFactor.ConfirmedAt == nil,
+		"expiresAt":         expires.Unix(),
+	}, nil
+}
+
+// challengeTwoFactor the second factor of a pending challenge
+func challengeTwoFactor(db *gorm.DB, challenge string) (models.UserTwoFactor, error) {
+	var twoFactor models.UserTwoFactor
+	if challenge == "" || db.First(&twoFactor, "challenge_hash = ?", hashSecret(challenge)).Error != nil {
+		return twoFactor, ErrInvalidChallenge
+	}
+	if twoFactor.ChallengeExpiresAt == nil || time.Now().After(*twoFactor.ChallengeExpiresAt) || twoFactor.ChallengeAttempts >= maxChallengeAttempts {
+		return twoFactor, ErrInvalidChallenge
+	}
+	if twoFactorLocked(twoFactor) {
+		return twoFactor, ErrTwoFactorLocked
+	}
+	return twoFactor, nil
+}
+
+// twoFactorLocked whether the user gave too many invalid codes recently
+func twoFactorLocked(twoFactor models.UserTwoFactor) bool {
+	return twoFactor.LockedUntil != nil && time.Now().Before(*twoFactor.LockedUntil)
+}
+
+// twoFactorFailed count an invalid code of the user, locking them out when there are too many.
+// Starting a new challenge does not reset the count, only a valid code does.
+func twoFactorFailed(db *gorm.DB, twoFactor models.UserTwoFactor) {
+	// the count only goes up while there are codes left, a parallel request cannot skip the lockout
+	result := db.Model(&models.UserTwoFactor{}).
+		Where("id = ? and failed_attempts < ?", twoFactor.ID, maxFailedAttempts-1).
+		Update("failed_attempts", gorm.Expr("failed_attempts + 1"))
+	if result.Error == nil && result.RowsAffected == 1 {
+		return
+	}
+
+	until := time.Now().Add(twoFactorLockout)
+	db.Model(&twoFactor).Updates(map[string]interface{}{"failed_attempts": 0, "locked_until": until, "challenge_hash": "", "challenge_expires_at": nil})
+}
+
+// EnrolChallenge enrol the user of a challenge who must use a second factor and has none yet
+func EnrolChallenge(db *gorm.DB, challenge string) (fiber.Map, error) {
+	twoFactor, err := challengeTwoFactor(db, challenge)
+	if err != nil {
+		return fiber.Map{}, err
+	}
+
+	var user models.User
+	if err := UserForId(db, twoFactor.UserId, &user); err != nil {
+		return fiber.Map{}, err
+	}
+	return EnrolTwoFactor(db, user)
+}
+
+// CompleteTwoFactorChallenge the user of a challenge answered with a valid code, and the recovery codes
+// when the code confirmed the enrolment
+func CompleteTwoFactorChallenge(db *gorm.DB, challenge, code string) (models.User, []string, error) {
+	twoFactor, err := challengeTwoFactor(db, challenge)
+	if err != nil {
+		return models.User{}, nil, err
+	}
+
+	// claim an attempt first, the parallel requests past the limit are turned away
+	result := db.Model(&models.UserTwoFactor{}).
+		Where("id = ? and challenge_hash = ? and challenge_attempts < ?", twoFactor.ID, twoFactor.ChallengeHash, maxChallengeAttempts).
+		Update("challenge_attempts", gorm.Expr("challenge_attempts + 1"))
+	if result.Error != nil || result.RowsAffected == 0 {
+		return models.User{}, nil, ErrInvalidChallenge
+	}
+
+	var recoveryCodes []string
+	if twoFactor.ConfirmedAt == nil {
+		recoveryCodes, err = ConfirmTwoFactor(db, twoFactor.UserId, code)
+	} else {
+		err = CheckTwoFactorCode(db, twoFactor.UserId, code)
+	}
+	if err != nil {
+		if errors.Is(err, ErrInvalidTwoFactorCode) {
+			twoFactorFailed(db, twoFactor)
+		}
+		return models.User{}, nil, err
+	}
+
+	db.Model(&twoFactor).Updates(map[string]interface{}{"challenge_hash": "", "challenge_expires_at": nil, "failed_attempts": 0})
+
+	var user models.User
+	if err := UserForId(db, twoFactor.UserId, &user); err != nil {
+		return user, nil, err
+	}
+	return user, recoveryCodes, nil
+}
@@ -1,8 +1,6 @@
 package services
 
 import (
-	"crypto/md5"
-	"encoding/hex"
 	"errors"
 	"fmt"
 	"myproject/api/database"
@@ -26,18 +24,25 @@ func GetCurrentUserID(c *fiber.Ctx) (uint64, error) {
 	return userId, nil
 }
 
-func CreateJWTToken(user models.User, expires time.Time) (string, int64, error) {
-
-	token := jwt.New(jwt.SigningMethodHS256)
-	claims := token.Claims.(jwt.MapClaims)
-	claims["user_id"] = user.ID
-	claims["exp"] = expires.Unix()
-	t, err := token.SignedString([]byte(database.GetParam("JWT_SECRET")))
+// CreateJWTToken sign a short lived access token of a session with the active signing key, returns the token and its ID
+func CreateJWTToken(user models.User, sessionId uint, expires time.Time) (string, string, error) {
+
+	now := time.Now()
+	claims := AccessTokenClaims{
+		UserId:    user.ID,
+		SessionId: sessionId,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        newTokenId(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expires),
+		},
+	}
+	t, err := signToken(claims)
 	if err != nil {
-		return "", 0, err
+		return "", "", err
 	}
 
-	return t, expires.Unix(), nil
+	return t, claims.ID, nil
 }
 
 func UserForId(db *gorm.DB, id interface{}, user *models.User) error {
@@ -50,42 +55,18 @@ func UserForId(db *gorm.DB, id interface{}, user *models.User) error {
 	return nil
 }
 
-func setTokenInClient(c *fiber.Ctx, user models.User, expires time.Time) (fiber.Map, error) {
-	token, exp, err := CreateJWTToken(user, expires)
-	if err != nil {
-		return fiber.Map{}, err
-	}
-
-	domain := database.GetParam("JWT_DOMAIN")
-	if os.Getenv("TEST_MODE") == "true" || os.Getenv("USE_DOCKER") == "true" {
-		domain = "localhost"
-	}
-	// set the token cookie that identifies the user
-	cookie := fiber.Cookie{
-		Name:     database.GetParam("JWT_COOKIE"),
-		Domain:   domain,
-		Value:    token,
-		Expires:  expires,
-		HTTPOnly: true,
-		Secure:   true,
-	}
-
-	//fmt.Println("SetupJWTtoken", user.ID, user.Name, cookie.Value, exp)
-	c.Cookie(&cookie)
-
-	return fiber.Map{"token": token, "exp": exp, "user": user}, nil
-}
-
-func SetShortLivedTokenInClient(c *fiber.Ctx, user models.User) (fiber.Map, error) {
-	return setTokenInClient(c, user, time.Now().Add(time.Hour*(24*10))) // expires in 10 days)
+// SetShortLivedTokenInClient log the user in with a session that can be refreshed for 10 days
+func SetShortLivedTokenInClient(db *gorm.DB, c *fiber.Ctx, user models.User) (fiber.Map, error) {
+	return startSession(db, c, user, time.Hour*(24*10)) // expires in 10 days
 }
 
-func SetTokenInClient(c *fiber.Ctx, user models.User) (fiber.Map, error) {
-	return setTokenInClient(c, user, time.Now().Add(time.Hour*(24*180))) // expires in 180 days)
+// SetTokenInClient log the user in with a session, sets the access and refresh token cookies
+func SetTokenInClient(db *gorm.DB, c *fiber.Ctx, user models.User) (fiber.Map, error) {
+	return startSession(db, c, user, refreshTokenLifetime())
 }
 
-func SetupJWTtoken(user models.User, c *fiber.Ctx) error {
-	data, err := SetTokenInClient(c, user)
+func SetupJWTtoken(db *gorm.DB, user models.User, c *fiber.Ctx) error {
+	data, err := SetTokenInClient(db, c, user)
 	if err != nil {
 		return err
 	}
@@ -93,16 +74,19 @@ func SetupJWTtoken(user models.User, c *fiber.Ctx) error {
 	return c.JSON(data)
 }
 
+// ClearCookie remove the access and refresh token cookies
 func ClearCookie(c *fiber.Ctx) {
-	cookie := fiber.Cookie{
-		Name:     database.GetParam("JWT_COOKIE"),
-		Domain:   database.GetParam("JWT_DOMAIN"),
-		Value:    "deleted",
-		Expires:  time.Now().Add(-3 * time.Second),
-		HTTPOnly: true,
-		Secure:   true,
+	for _, name := range []string{database.GetParam("JWT_COOKIE"), RefreshCookieName()} {
+		cookie := fiber.Cookie{
+			Name:     name,
+			Domain:   cookieDomain(),
+			Value:    "deleted",
+			Expires:  time.Now().Add(-3 * time.Second),
+			HTTPOnly: true,
+			Secure:   true,
+		}
+		c.Cookie(&cookie)
 	}
-	c.Cookie(&cookie)
 }
 
 func UpdateUserApp(db *gorm.DB, sig *models.Signature, c *fiber.Ctx) {
@@ -148,17 +132,36 @@ func UserTokenForApp(db *gorm.DB, id uint, appPkg string) (string, error) {
 	return app.Token, nil
 }
 
-func VerifyToken(text string, token string) (bool, error) {
-	hash := md5.Sum([]byte(text))
-	res := hex.EncodeToString(hash[:])
-	if res == token {
-		return true, nil
+// VerifyToken check the signature of a text by an app, before the user is known.
+// The nonce is part of the text, each is accepted once.
+func VerifyToken(c *fiber.Ctx, text string, nonce string, token string) (bool, error) {
+	if err := checkAppSignature(c, text, nonce, token); err != nil {
+		return false, err
 	}
-	return false, fmt.Errorf("token does not match [%s, %s, %s]", text, token, res)
+	return true, nil
 }
 
 func VerifyUserSignature(c *fiber.Ctx, db *gorm.DB, userId uint, nonce string, signature string) (models.User, error) {
 
+	user, err := checkUserSignature(c, db, userId, nonce, signature)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	return user, nil
+}
+
+// signedUserKey is the Locals key of the user whose signature of the request was verified
+const signedUserKey = "signedUser"
+
+// checkUserSignature loads the user and verifies the signature without touching the response.
+// A request is verified once: its nonce is claimed, so RequireTenant and the handler share the result.
+func checkUserSignature(c *fiber.Ctx, db *gorm.DB, userId uint, nonce string, signature string) (models.User, error) {
+
+	if signed, ok := c.Locals(signedUserKey).(models.User); ok && signed.ID == userId {
+		return signed, nil
+	}
+
 	var user models.User
 	result := db.First(&user, userId)
 
@@ -168,25 +171,14 @@ func VerifyUserSignature(c *fiber.Ctx, db *gorm.DB, userId uint, nonce string, s
 	}
 
 	if os.Getenv("TEST_MODE") == "true" || os.Getenv("USE_DOCKER") == "true" {
-		// setup the JWT token for the user
-		SetupJWTtoken(user, c)
-
 		return user, nil
 	}
 
-	// SETUP YOUR SIGNATURE FORMAT HERE E.G
-	text := fmt.Sprintf("%s-%d-%s-%s-%s-%s", user.ApiKey, userId, nonce, user.Email, user.Name, user.PubKey)
-	// and ensure to use the same format in clients
-
-	hash := md5.Sum([]byte(text))
-	res := hex.EncodeToString(hash[:])
-	if res == signature {
-		// setup the JWT token for the user
-		SetupJWTtoken(user, c)
-
-		return user, nil
+	if err := checkRequestSignature(c, user, nonce, signature); err != nil {
+		return models.User{}, fmt.Errorf("user %d: %w", userId, err)
 	}
-	return models.User{}, fmt.Errorf("signature does not match [%d, %s, %s, %s, %s, %s]", userId, user.ApiKey, user.Email, nonce, signature, res)
+	c.Locals(signedUserKey, user)
+	return user, nil
 }
 
 func VerifyMatchingUser(uid uint, c *fiber.Ctx) error {
@@ -3,6 +3,7 @@ package tasks
 import (
 	"fmt"
 	"myproject/api/database"
+	"myproject/api/services"
 	"time"
 
 	"github.com/bsm/redislock"
@@ -21,6 +22,14 @@ func DoEveryDay(rdb *redis.Client, cfg database.ClusterConfig) {
 
 	clearTempFiles(rdb, cfg)
 
+	findDuplicateBusinesses(rdb, cfg)
+
+	purgeDeletedData(rdb, cfg)
+
+	purgeOldPositions(rdb, cfg)
+
+	purgeOldSessions(rdb, cfg)
+
 }
 
 func clearAppEvents(rdb *redis.Client, cfg database.ClusterConfig) {
@@ -91,3 +100,154 @@ func clearTempFiles(rdb *redis.Client, cfg database.ClusterConfig) {
 	// if the modified time is older than 1 day, delete the file
 
 }
+
+// findDuplicateBusinesses refresh the duplicate business candidates for the admins to review
+func findDuplicateBusinesses(rdb *redis.Client, cfg database.ClusterConfig) {
+
+	locker := redislock.New(rdb)
+
+	ctx := context.Background()
+
+	// hold the lock while scanning so only one server runs it
+	lock, err := locker.Obtain(ctx, "findDuplicateBusinesses", 30*time.Minute, nil)
+	if err == redislock.ErrNotObtained {
+		return
+	} else if err != nil {
+		return
+	}
+
+	defer lock.Release(ctx)
+
+	db, err := gorm.Open(postgres.Open(cfg.Primary.GetDSN()), &gorm.Config{
+		Logger:                                   GormLogger.Default.LogMode(GormLogger.Silent),
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	sqlDB, err := db.DB()
+	defer sqlDB.Close()
+
+	found, err := services.ScanDuplicates(db)
+	if err != nil {
+		fmt.Println("findDuplicateBusinesses", err)
+	}
+	fmt.Println("findDuplicateBusinesses found", found, "candidates")
+}
+
+// purgeDeletedData hard delete the data soft deleted before the retention window
+func purgeDeletedData(rdb *redis.Client, cfg database.ClusterConfig) {
+
+	locker := redislock.New(rdb)
+
+	ctx := context.Background()
+
+	lock, err := locker.Obtain(ctx, "purgeDeletedData", 30*time.Minute, nil)
+	if err == redislock.ErrNotObtained {
+		return
+	} else if err != nil {
+		return
+	}
+
+	defer lock.Release(ctx)
+
+	db, err := gorm.Open(postgres.Open(cfg.Primary.GetDSN()), &gorm.Config{
+		Logger:                                   GormLogger.Default.LogMode(GormLogger.Silent),
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	sqlDB, err := db.DB()
+	defer sqlDB.Close()
+
+	purged, errs := services.PurgeExpired(db)
+	for _, err := range errs {
+		fmt.Println("purgeDeletedData", err)
+	}
+	fmt.Println("purgeDeletedData purged", purged, "businesses with", len(errs), "errors")
+}
+
+// positionRetention how long the GPS positions of the users are kept
+const positionRetention = 90 * 24 * time.Hour
+
+// purgeOldPositions delete the GPS positions older than the retention
+func purgeOldPositions(rdb *redis.Client, cfg database.ClusterConfig) {
+
+	locker := redislock.New(rdb)
+
+	ctx := context.Background()
+
+	lock, err := locker.Obtain(ctx, "purgeOldPositions", 30*time.Minute, nil)
+	if err == redislock.ErrNotObtained {
+		return
+	} else if err != nil {
+		return
+	}
+
+	defer lock.Release(ctx)
+
+	db, err := gorm.Open(postgres.Open(cfg.Primary.GetDSN()), &gorm.Config{
+		Logger:                                   GormLogger.Default.LogMode(GormLogger.Silent),
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	sqlDB, err := db.DB()
+	defer sqlDB.Close()
+
+	purged, err := services.PurgeOldPositions(db, time.Now().Add(-positionRetention))
+	if err != nil {
+		fmt.Println("purgeOldPositions", err)
+	}
+	fmt.Println("purgeOldPositions purged", purged, "positions")
+}
+
+// sessionRetention how long the expired and revoked sessions are kept
+const sessionRetention = 30 * 24 * time.Hour
+
+// purgeOldSessions delete the sessions that expired or were revoked before the retention
+func purgeOldSessions(rdb *redis.Client, cfg database.ClusterConfig) {
+
+	locker := redislock.New(rdb)
+
+	ctx := context.Background()
+
+	lock, err := locker.Obtain(ctx, "purgeOldSessions", 30*time.Minute, nil)
+	if err == redislock.ErrNotObtained {
+		return
+	} else if err != nil {
+		return
+	}
+
+	defer lock.Release(ctx)
+
+	db, err := gorm.Open(postgres.Open(cfg.Primary.GetDSN()), &gorm.Config{
+		Logger:                                   GormLogger.Default.LogMode(GormLogger.Silent),
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	sqlDB, err := db.DB()
+	defer sqlDB.Close()
+
+	purged, err := services.PurgeOldSessions(db, time.Now().Add(-sessionRetention))
+	if err != nil {
+		fmt.Println("purgeOldSessions", err)
+	}
+	fmt.Println("purgeOldSessions purged", purged, "sessions")
+}
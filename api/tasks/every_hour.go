@@ -1,15 +1,61 @@
 package tasks
 
 import (
+	"context"
 	"fmt"
 	"myproject/api/database"
+	"myproject/api/services"
 	"time"
 
+	"github.com/bsm/redislock"
 	"github.com/redis/go-redis/v9"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	GormLogger "gorm.io/gorm/logger"
 )
 
 func DoEveryHour(rdb *redis.Client, cfg database.ClusterConfig) {
 
 	fmt.Println("running every hour", time.Now())
 
+	reencryptSensitiveData(rdb, cfg)
+
+}
+
+// reencryptSensitiveData move the encrypted fields to the active master key after a rotation
+func reencryptSensitiveData(rdb *redis.Client, cfg database.ClusterConfig) {
+
+	locker := redislock.New(rdb)
+
+	ctx := context.Background()
+
+	lock, err := locker.Obtain(ctx, "reencryptSensitiveData", 50*time.Minute, nil)
+	if err == redislock.ErrNotObtained {
+		return
+	} else if err != nil {
+		return
+	}
+
+	defer lock.Release(ctx)
+
+	db, err := gorm.Open(postgres.Open(cfg.Primary.GetDSN()), &gorm.Config{
+		Logger:                                   GormLogger.Default.LogMode(GormLogger.Silent),
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	sqlDB, err := db.DB()
+	defer sqlDB.Close()
+
+	count, errs := services.ReencryptAll(db)
+	for _, err := range errs {
+		fmt.Println("reencryptSensitiveData", err)
+	}
+	if count > 0 || len(errs) > 0 {
+		fmt.Println("reencryptSensitiveData rewrote", count, "rows with", len(errs), "errors")
+	}
 }
@@ -1,10 +1,16 @@
 package tasks
 
 import (
+	"encoding/json"
 	"fmt"
 	"myproject/api/database"
+	"myproject/api/features/export"
+	"myproject/api/features/importer"
+	"myproject/api/features/ws"
 	"myproject/api/models"
+	"myproject/api/services"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/bsm/redislock"
@@ -50,6 +56,12 @@ func DoEveryMinute(rdb *redis.Client, cfg database.ClusterConfig) {
 
 	DeleteTempFiles(rdb, cfg)
 
+	RunExportTasks(rdb, cfg)
+
+	RunImportTasks(rdb, cfg)
+
+	RunGeocodeTasks(rdb, cfg)
+
 }
 
 func DeleteTempFiles(rdb *redis.Client, cfg database.ClusterConfig) {
@@ -93,7 +105,8 @@ func DeleteTempFiles(rdb *redis.Client, cfg database.ClusterConfig) {
 	var tasks []models.Task
 
 	db.Find(&tasks,
-		"run_at < now() and enabled != false and (name = 'Delete temporary invoice file')   ")
+		"run_at < now() and enabled != false and (name = 'Delete temporary invoice file' or name in ?)",
+		[]string{models.ExportFileTaskName, models.ImportFileTaskName})
 
 	for _, task := range tasks {
 		// delete the file
@@ -102,3 +115,174 @@ func DeleteTempFiles(rdb *redis.Client, cfg database.ClusterConfig) {
 		db.Delete(&task)
 	}
 }
+
+// RunExportTasks build the business exports requested since the last run
+func RunExportTasks(rdb *redis.Client, cfg database.ClusterConfig) {
+
+	locker := redislock.New(rdb)
+
+	ctx := context.Background()
+
+	// hold the lock while exporting so a task is not run twice
+	lock, err := locker.Obtain(ctx, "RunExportTasks", 10*time.Minute, nil)
+	if err == redislock.ErrNotObtained {
+		return
+	} else if err != nil {
+		return
+	}
+
+	defer lock.Release(ctx)
+
+	db, err := gorm.Open(postgres.Open(cfg.Primary.GetDSN()), &gorm.Config{
+		Logger:                                   GormLogger.Default.LogMode(GormLogger.Silent),
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if database.GetParam("LOG_TASK_SQL") == "true" {
+		db.Config.Logger.LogMode(GormLogger.Info)
+	}
+
+	sqlDB, err := db.DB()
+	defer sqlDB.Close()
+
+	var tasks []models.Task
+
+	db.Find(&tasks, "run_at < now() and enabled != false and name = ?", models.ExportTaskName)
+
+	for _, task := range tasks {
+		// the task runs once
+		db.Delete(&task)
+
+		exportId, _ := strconv.ParseUint(task.Data, 10, 64)
+		if err := export.RunExport(db, uint(exportId)); err != nil {
+			fmt.Println("RunExport", exportId, err)
+		}
+	}
+}
+
+// RunImportTasks import the large files uploaded since the last run
+func RunImportTasks(rdb *redis.Client, cfg database.ClusterConfig) {
+
+	locker := redislock.New(rdb)
+
+	ctx := context.Background()
+
+	// hold the lock while importing so a task is not run twice
+	lock, err := locker.Obtain(ctx, "RunImportTasks", 10*time.Minute, nil)
+	if err == redislock.ErrNotObtained {
+		return
+	} else if err != nil {
+		return
+	}
+
+	defer lock.Release(ctx)
+
+	db, err := gorm.Open(postgres.Open(cfg.Primary.GetDSN()), &gorm.Config{
+		Logger:                                   GormLogger.Default.LogMode(GormLogger.Silent),
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if database.GetParam("LOG_TASK_SQL") == "true" {
+		db.Config.Logger.LogMode(GormLogger.Info)
+	}
+
+	sqlDB, err := db.DB()
+	defer sqlDB.Close()
+
+	var tasks []models.Task
+
+	db.Find(&tasks, "run_at < now() and enabled != false and name = ?", models.ImportTaskName)
+
+	for _, task := range tasks {
+		// the task runs once
+		db.Delete(&task)
+
+		jobId, _ := strconv.ParseUint(task.Data, 10, 64)
+		if err := importer.RunImport(db, uint(jobId)); err != nil {
+			fmt.Println("RunImport", jobId, err)
+		}
+	}
+}
+
+// geocodeBatchSize how many locations a geocode task looks up before handing over to the next run
+const geocodeBatchSize = 200
+
+// RunGeocodeTasks fill the missing latlng of the locations of the businesses that asked for it,
+// publishing the progress on the websocket channel of each business
+func RunGeocodeTasks(rdb *redis.Client, cfg database.ClusterConfig) {
+
+	locker := redislock.New(rdb)
+
+	ctx := context.Background()
+
+	// a batch can take a few minutes at the rate limit of the provider
+	lock, err := locker.Obtain(ctx, "RunGeocodeTasks", 30*time.Minute, nil)
+	if err == redislock.ErrNotObtained {
+		return
+	} else if err != nil {
+		return
+	}
+
+	defer lock.Release(ctx)
+
+	db, err := gorm.Open(postgres.Open(cfg.Primary.GetDSN()), &gorm.Config{
+		Logger:                                   GormLogger.Default.LogMode(GormLogger.Silent),
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if database.GetParam("LOG_TASK_SQL") == "true" {
+		db.Config.Logger.LogMode(GormLogger.Info)
+	}
+
+	sqlDB, err := db.DB()
+	defer sqlDB.Close()
+
+	var tasks []models.Task
+
+	db.Find(&tasks, "run_at < now() and enabled != false and name = ?", models.GeocodeTaskName)
+
+	for _, task := range tasks {
+		db.Delete(&task)
+
+		channel := services.GeocodeChannel(task.BusinessId)
+		publish := func(progress services.GeocodeProgress) {
+			payload, _ := json.Marshal(progress)
+			subject := "progress"
+			if progress.Finished {
+				subject = "complete"
+			}
+			msg, _ := json.Marshal(models.WebsocketMessage{Event: "geocode", Channel: channel, Subject: subject, Payload: string(payload)})
+			ws.Publish(rdb, channel, string(msg))
+		}
+
+		// the task data is the ID of the last location looked up
+		afterId, _ := strconv.ParseUint(task.Data, 10, 64)
+		progress, err := services.GeocodeLocations(db, task.BusinessId, uint(afterId), geocodeBatchSize, publish)
+		if err != nil {
+			fmt.Println("RunGeocodeTasks", task.BusinessId, err)
+			continue
+		}
+
+		if !progress.Finished {
+			// carry on with the next batch on the next run
+			task.ID = 0
+			task.Data = strconv.FormatUint(uint64(progress.LastId), 10)
+			db.Create(&task)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Address the parts of an address that depend on its country
+type Address struct {
+	City        string `json:"city"`
+	Province    string `json:"province"`
+	Postcode    string `json:"postcode"`
+	Country     string `json:"country"`
+	CountryCode string `json:"countryCode"` // ISO 3166-1 alpha-2, set by NormalizeAddressFor
+}
+
+// CountryRules how the addresses of a country are written and checked
+type CountryRules struct {
+	Code     string   // ISO 3166-1 alpha-2 e.g. CO
+	Iso3     string   // ISO 3166-1 alpha-3 e.g. COL
+	Name     string   // the name stored in the businesses and locations e.g. USA, UK, Colombia
+	Aliases  []string // other names of the country, accents and case are ignored
+	Currency string   // ISO 4217 default currency of the businesses
+	Locale   string   // default locale of the businesses e.g. es_CO
+
+	PostalRegex    string              // postal codes must match when given, upper case without repeated spaces
+	PostalExample  string              // shown when a postal code does not match
+	FormatPostcode func(string) string // optional, to write a valid postal code the usual way
+
+	Provinces       map[string]string // ISO 3166-2 subdivision codes to names, provinces are checked when set
+	ProvinceAliases map[string]string // other names of the provinces to their codes
+
+	postal *regexp.Regexp
+}
+
+// AddressError the invalid fields of an address by their JSON name
+type AddressError map[string]string
+
+func (e AddressError) Error() string {
+	fields := []string{}
+	for field := range e {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	messages := []string{}
+	for _, field := range fields {
+		messages = append(messages, e[field])
+	}
+	return strings.Join(messages, ", ")
+}
+
+var (
+	countryRulesMutex sync.RWMutex
+	countryRules      = map[string]*CountryRules{} // by ISO code
+	countryNames      = map[string]string{}        // the keys of the codes, names and aliases to ISO codes
+)
+
+func countryKey(name string) string {
+	return strings.Join(strings.Fields(strings.ToLower(RemoveAccents(strings.Trim(name, " .")))), " ")
+}
+
+// RegisterCountryRules add or replace the rules of a country
+func RegisterCountryRules(rules CountryRules) error {
+	rules.Code = strings.ToUpper(rules.Code)
+	if len(rules.Code) != 2 {
+		return fmt.Errorf("%q is not an ISO 3166-1 alpha-2 code", rules.Code)
+	}
+	if rules.PostalRegex != "" {
+		postal, err := regexp.Compile(rules.PostalRegex)
+		if err != nil {
+			return err
+		}
+		rules.postal = postal
+	}
+	if rules.Name == "" {
+		rules.Name = rules.Code
+	}
+
+	countryRulesMutex.Lock()
+	defer countryRulesMutex.Unlock()
+
+	countryRules[rules.Code] = &rules
+	for _, name := range append([]string{rules.Code, rules.Iso3, rules.Name}, rules.Aliases...) {
+		if key := countryKey(name); key != "" {
+			countryNames[key] = rules.Code
+		}
+	}
+	return nil
+}
+
+// CountryRulesFor the rules of a country by ISO code, stored name or alias
+func CountryRulesFor(country string) (CountryRules, bool) {
+	countryRulesMutex.RLock()
+	defer countryRulesMutex.RUnlock()
+
+	if rules, ok := countryRules[countryNames[countryKey(country)]]; ok {
+		return *rules, true
+	}
+	return CountryRules{}, false
+}
+
+// CountryCode the ISO 3166-1 alpha-2 code of a country, empty when it is not known
+func CountryCode(country string) string {
+	rules, _ := CountryRulesFor(country)
+	return rules.Code
+}
+
+// Names the stored name, the codes and the aliases of the country
+func (rules CountryRules) Names() []string {
+	return append([]string{rules.Name, rules.Code, rules.Iso3}, rules.Aliases...)
+}
+
+// province the canonical code or name of a province, and whether it is one of the provinces of the country
+func (rules CountryRules) province(province string) (string, bool) {
+	key := countryKey(province)
+	if key == "" || len(rules.Provinces) == 0 {
+		return NormalizeAddress(province), true
+	}
+	if _, ok := rules.Provinces[strings.ToUpper(key)]; ok {
+		return strings.ToUpper(key), true
+	}
+	for _, name := range rules.Provinces {
+		if countryKey(name) == key {
+			return name, true
+		}
+	}
+	if code, ok := rules.ProvinceAliases[key]; ok {
+		return rules.Provinces[code], true
+	}
+	return NormalizeAddress(province), false
+}
+
+// NormalizeAddressFor write an address the way its country does: the stored name of the country,
+// the code or the name of the province as given, the usual case of the postal code.
+// Nothing is changed that could not be changed back, use ValidateAddress to find what is wrong.
+func NormalizeAddressFor(a Address) Address {
+	a.City = NormalizeAddress(a.City)
+	a.Postcode = strings.ToUpper(strings.Join(strings.Fields(a.Postcode), " "))
+
+	rules, ok := CountryRulesFor(a.Country)
+	if !ok {
+		a.Country = strings.TrimSpace(a.Country)
+		a.Province = NormalizeAddress(a.Province)
+		a.CountryCode = ""
+		return a
+	}
+
+	a.Country = rules.Name
+	a.CountryCode = rules.Code
+	a.Province, _ = rules.province(a.Province)
+	if rules.FormatPostcode != nil && rules.postal != nil && a.Postcode != "" {
+		if formatted := rules.FormatPostcode(a.Postcode); rules.postal.MatchString(formatted) {
+			a.Postcode = formatted
+		}
+	}
+	return a
+}
+
+// ValidateAddress the normalised address, with an AddressError when the province or the postal code
+// are not valid in the country. Addresses in countries without rules are not checked.
+func ValidateAddress(a Address) (Address, error) {
+	a = NormalizeAddressFor(a)
+
+	rules, ok := CountryRulesFor(a.Country)
+	if !ok {
+		return a, nil
+	}
+
+	problems := AddressError{}
+	if _, known := rules.province(a.Province); !known {
+		problems["province"] = fmt.Sprintf("%s is not a province of %s", a.Province, rules.Name)
+	}
+	if a.Postcode != "" && rules.postal != nil && !rules.postal.MatchString(a.Postcode) {
+		message := fmt.Sprintf("%s is not a postal code of %s", a.Postcode, rules.Name)
+		if rules.PostalExample != "" {
+			message += ", e.g. " + rules.PostalExample
+		}
+		problems["postcode"] = message
+	}
+
+	if len(problems) > 0 {
+		return a, problems
+	}
+	return a, nil
+}
+
+// spaceBeforeLast put a space before the last n characters of a postal code written without it
+func spaceBeforeLast(n int) func(string) string {
+	return func(postcode string) string {
+		postcode = strings.ReplaceAll(postcode, " ", "")
+		if len(postcode) <= n {
+			return postcode
+		}
+		return postcode[:len(postcode)-n] + " " + postcode[len(postcode)-n:]
+	}
+}
+
+func init() {
+	for _, rules := range builtinCountryRules {
+		if err := RegisterCountryRules(rules); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// the countries the platform is used in, other countries can be registered from the reference geography
+var builtinCountryRules = []CountryRules{
+	{
+		Code: "US", Iso3: "USA", Name: "USA", Aliases: []string{"United States", "United States of America", "U.S.A", "U.S"},
+		Currency: "USD", Locale: "en_US",
+		PostalRegex: `^\d{5}(-\d{4})?$`, PostalExample: "44113 or 44113-1234",
+		Provinces: map[string]string{
+			"AL": "Alabama", "AK": "Alaska", "AZ": "Arizona", "AR": "Arkansas", "CA": "California", "CO": "Colorado",
+			"CT": "Connecticut", "DE": "Delaware", "DC": "District of Columbia", "FL": "Florida", "GA": "Georgia",
+			"HI": "Hawaii", "ID": "Idaho", "IL": "Illinois", "IN": "Indiana", "IA": "Iowa", "KS": "Kansas",
+			"KY": "Kentucky", "LA": "Louisiana", "ME": "Maine", "MD": "Maryland", "MA": "Massachusetts",
+			"MI": "Michigan", "MN": "Minnesota", "MS": "Mississippi", "MO": "Missouri", "MT": "Montana",
+			"NE": "Nebraska", "NV": "Nevada", "NH": "New Hampshire", "NJ": "New Jersey", "NM": "New Mexico",
+			"NY": "New York", "NC": "North Carolina", "ND": "North Dakota", "OH": "Ohio", "OK": "Oklahoma",
+			"OR": "Oregon", "PA": "Pennsylvania", "PR": "Puerto Rico", "RI": "Rhode Island", "SC": "South Carolina",
+			"SD": "South Dakota", "TN": "Tennessee", "TX": "Texas", "UT": "Utah", "VT": "Vermont", "VA": "Virginia",
+			"WA": "Washington", "WV": "West Virginia", "WI": "Wisconsin", "WY": "Wyoming",
+		},
+		ProvinceAliases: map[string]string{"washington dc": "DC", "washington d.c": "DC", "d.c": "DC"},
+	},
+	{
+		Code: "GB", Iso3: "GBR", Name: "UK", Aliases: []string{"United Kingdom", "Great Britain", "England", "Scotland", "Wales", "Northern Ireland"},
+		Currency: "GBP", Locale: "en_GB",
+		PostalRegex: `^([A-Z]{1,2}\d[A-Z\d]? \d[A-Z]{2}|GIR 0AA)$`, PostalExample: "SW1A 1AA",
+		FormatPostcode: spaceBeforeLast(3),
+	},
+	{
+		Code: "CA", Iso3: "CAN", Name: "Canada",
+		Currency: "CAD", Locale: "en_CA",
+		PostalRegex: `^[ABCEGHJ-NPRSTVXY]\d[ABCEGHJ-NPRSTV-Z] \d[ABCEGHJ-NPRSTV-Z]\d$`, PostalExample: "K1A 0B1",
+		FormatPostcode: spaceBeforeLast(3),
+		Provinces: map[string]string{
+			"AB": "Alberta", "BC": "British Columbia", "MB": "Manitoba", "NB": "New Brunswick", "NL": "Newfoundland and Labrador",
+			"NS": "Nova Scotia", "NT": "Northwest Territories", "NU": "Nunavut", "ON": "Ontario", "PE": "Prince Edward Island",
+			"QC": "Quebec", "SK": "Saskatchewan", "YT": "Yukon",
+		},
+	},
+	{
+		Code: "CO", Iso3: "COL", Name: "Colombia",
+		Currency: "COP", Locale: "es_CO",
+		PostalRegex: `^\d{6}$`, PostalExample: "110111",
+		// the names as NormalizeAddress writes them, without accents
+		Provinces: map[string]string{
+			"AMA": "Amazonas", "ANT": "Antioquia", "ARA": "Arauca", "ATL": "Atlantico", "BOL": "Bolivar", "BOY": "Boyaca",
+			"CAL": "Caldas", "CAQ": "Caqueta", "CAS": "Casanare", "CAU": "Cauca", "CES": "Cesar", "CHO": "Choco",
+			"COR": "Cordoba", "CUN": "Cundinamarca", "DC": "Bogota", "GUA": "Guainia", "GUV": "Guaviare", "HUI": "Huila",
+			"LAG": "La Guajira", "MAG": "Magdalena", "MET": "Meta", "NAR": "Narino", "NSA": "Norte De Santander",
+			"PUT": "Putumayo", "QUI": "Quindio", "RIS": "Risaralda", "SAN": "Santander", "SAP": "San Andres",
+			"SUC": "Sucre", "TOL": "Tolima", "VAC": "Valle Del Cauca", "VAU": "Vaupes", "VID": "Vichada",
+		},
+		ProvinceAliases: map[string]string{
+			"bogota d.c": "DC", "bogota dc": "DC", "distrito capital": "DC", "bogota distrito capital": "DC",
+			"valle": "VAC", "guajira": "LAG", "san andres y providencia": "SAP",
+			"archipielago de san andres, providencia y santa catalina": "SAP",
+		},
+	},
+	{Code: "MX", Iso3: "MEX", Name: "Mexico", Currency: "MXN", Locale: "es_MX", PostalRegex: `^\d{5}$`, PostalExample: "06000"},
+	{Code: "EC", Iso3: "ECU", Name: "Ecuador", Currency: "USD", Locale: "es_EC", PostalRegex: `^\d{6}$`, PostalExample: "170150"},
+	{Code: "PE", Iso3: "PER", Name: "Peru", Currency: "PEN", Locale: "es_PE", PostalRegex: `^\d{5}$`, PostalExample: "15001"},
+	{Code: "PA", Iso3: "PAN", Name: "Panama", Currency: "PAB", Locale: "es_PA"},
+	{Code: "VE", Iso3: "VEN", Name: "Venezuela", Currency: "VES", Locale: "es_VE", PostalRegex: `^\d{4}$`, PostalExample: "1010"},
+	{Code: "CL", Iso3: "CHL", Name: "Chile", Currency: "CLP", Locale: "es_CL", PostalRegex: `^\d{7}$`, PostalExample: "8320000"},
+	{Code: "AR", Iso3: "ARG", Name: "Argentina", Currency: "ARS", Locale: "es_AR", PostalRegex: `^([A-Z]\d{4}[A-Z]{3}|\d{4})$`, PostalExample: "C1002AAA"},
+	{Code: "CR", Iso3: "CRI", Name: "Costa Rica", Currency: "CRC", Locale: "es_CR", PostalRegex: `^\d{5}$`, PostalExample: "10101"},
+	{Code: "GT", Iso3: "GTM", Name: "Guatemala", Currency: "GTQ", Locale: "es_GT", PostalRegex: `^\d{5}$`, PostalExample: "01001"},
+	{Code: "DO", Iso3: "DOM", Name: "Dominican Republic", Aliases: []string{"Republica Dominicana"}, Currency: "DOP", Locale: "es_DO", PostalRegex: `^\d{5}$`, PostalExample: "10101"},
+	{Code: "IE", Iso3: "IRL", Name: "Ireland", Currency: "EUR", Locale: "en_IE", PostalRegex: `^([AC-FHKNPRTV-Y]\d{2}|D6W) [0-9AC-FHKNPRTV-Y]{4}$`, PostalExample: "D02 X285",
+		FormatPostcode: spaceBeforeLast(4)},
+	{Code: "ES", Iso3: "ESP", Name: "Spain", Aliases: []string{"Espana"}, Currency: "EUR", Locale: "es_ES", PostalRegex: `^\d{5}$`, PostalExample: "28001"},
+	{Code: "FR", Iso3: "FRA", Name: "France", Currency: "EUR", Locale: "fr_FR", PostalRegex: `^\d{5}$`, PostalExample: "75001"},
+	{Code: "DE", Iso3: "DEU", Name: "Germany", Aliases: []string{"Deutschland"}, Currency: "EUR", Locale: "de_DE", PostalRegex: `^\d{5}$`, PostalExample: "10115"},
+	{Code: "IT", Iso3: "ITA", Name: "Italy", Aliases: []string{"Italia"}, Currency: "EUR", Locale: "it_IT", PostalRegex: `^\d{5}$`, PostalExample: "00118"},
+	{Code: "PT", Iso3: "PRT", Name: "Portugal", Currency: "EUR", Locale: "pt_PT", PostalRegex: `^\d{4}-\d{3}$`, PostalExample: "1000-001"},
+	{Code: "NL", Iso3: "NLD", Name: "Netherlands", Aliases: []string{"Holland", "The Netherlands"}, Currency: "EUR", Locale: "nl_NL",
+		PostalRegex: `^\d{4} [A-Z]{2}$`, PostalExample: "1011 AB", FormatPostcode: spaceBeforeLast(2)},
+}
@@ -0,0 +1,221 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"myproject/api/database"
+)
+
+// Sensitive fields are encrypted with envelope keys: each value gets its own random data key
+// which is encrypted by a master key from the config. Stored values look like
+//
+//	enc:v1:<master key id>:<encrypted data key>:<encrypted value>
+//
+// The master keys are in the ENCRYPTION_KEYS param as id:base64 pairs separated by commas,
+// ENCRYPTION_KEY_ID names the one used for new values (the first by default).
+// ENCRYPTION_INDEX_KEY keys the blind indexes and is required with them.
+// Rotate by adding a new key, making it the active one and letting the re-encryption job
+// rewrite the values before the old key is removed.
+const EncryptedPrefix = "enc:v1:"
+
+var ErrUnknownEncryptionKey = errors.New("value was encrypted with a master key that is not configured")
+var ErrInvalidEncryptedValue = errors.New("invalid encrypted value")
+
+type encryptionKeyRing struct {
+	params string
+	keys   map[string][]byte
+	active string
+	err    error
+}
+
+var keyRingMutex sync.Mutex
+var keyRing encryptionKeyRing
+
+// encryptionKeys the master keys from the params, parsed again when the params change
+func encryptionKeys() encryptionKeyRing {
+	params := database.GetParam("ENCRYPTION_KEYS") + "|" + database.GetParam("ENCRYPTION_KEY_ID") + "|" + database.GetParam("ENCRYPTION_INDEX_KEY")
+
+	keyRingMutex.Lock()
+	defer keyRingMutex.Unlock()
+
+	if keyRing.keys != nil && keyRing.params == params {
+		return keyRing
+	}
+
+	ring := encryptionKeyRing{params: params, keys: map[string][]byte{}}
+	for _, pair := range strings.Split(database.GetParam("ENCRYPTION_KEYS"), ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(pair, ":")
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if !ok || id == "" || err != nil || len(key) != 32 {
+			ring.err = fmt.Errorf("encryption key %q must be id:base64 of 32 bytes", id)
+			break
+		}
+		ring.keys[id] = key
+		if ring.active == "" {
+			ring.active = id
+		}
+	}
+
+	if active := database.GetParam("ENCRYPTION_KEY_ID"); active != "" && ring.err == nil {
+		if _, ok := ring.keys[active]; !ok {
+			ring.err = fmt.Errorf("encryption key %s is not in ENCRYPTION_KEYS", active)
+		}
+		ring.active = active
+	}
+
+	// encrypted values without a blind index could no longer be searched
+	if ring.active != "" && ring.err == nil && database.GetParam("ENCRYPTION_INDEX_KEY") == "" {
+		ring.err = errors.New("ENCRYPTION_INDEX_KEY must be set with ENCRYPTION_KEYS")
+	}
+
+	keyRing = ring
+	return ring
+}
+
+// ActiveEncryptionKey the ID of the master key used for new values, empty when encryption is not configured
+func ActiveEncryptionKey() string {
+	return encryptionKeys().active
+}
+
+func seal(key, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(key, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, ErrInvalidEncryptedValue
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+}
+
+// EncryptField encrypt a sensitive value with a new data key under the active master key.
+// Empty values stay empty and values are stored as they are when no key is configured.
+func EncryptField(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	ring := encryptionKeys()
+	if ring.err != nil {
+		return "", ring.err
+	}
+	if ring.active == "" {
+		return value, nil
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+
+	wrapped, err := seal(ring.keys[ring.active], dataKey)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := seal(dataKey, []byte(value))
+	if err != nil {
+		return "", err
+	}
+
+	return EncryptedPrefix + ring.active + ":" + base64.RawStdEncoding.EncodeToString(wrapped) + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptField decrypt a value written by EncryptField, values stored before encryption are returned as they are
+func DecryptField(stored string) (string, error) {
+	rest, ok := strings.CutPrefix(stored, EncryptedPrefix)
+	if !ok {
+		return stored, nil
+	}
+
+	parts := strings.Split(rest, ":")
+	if len(parts) != 3 {
+		return "", ErrInvalidEncryptedValue
+	}
+
+	ring := encryptionKeys()
+	key, ok := ring.keys[parts[0]]
+	if !ok {
+		return "", ErrUnknownEncryptionKey
+	}
+
+	wrapped, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", ErrInvalidEncryptedValue
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", ErrInvalidEncryptedValue
+	}
+
+	dataKey, err := open(key, wrapped)
+	if err != nil {
+		return "", ErrInvalidEncryptedValue
+	}
+	plaintext, err := open(dataKey, sealed)
+	if err != nil {
+		return "", ErrInvalidEncryptedValue
+	}
+
+	return string(plaintext), nil
+}
+
+// BlindIndex a keyed hash of a value so an encrypted column can still be searched for an exact match,
+// the key is ENCRYPTION_INDEX_KEY and must not change once data is indexed. Without it there is no index,
+// the values are then stored in plaintext and searched by value
+func BlindIndex(value string) string {
+	key := database.GetParam("ENCRYPTION_INDEX_KEY")
+	if value == "" || key == "" {
+		return ""
+	}
+
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// MaskValue hide all but the last 4 characters of a sensitive value, short values are hidden completely
+func MaskValue(value string) string {
+	if value == "" {
+		return ""
+	}
+
+	runes := []rune(value)
+	if len(runes) <= 6 {
+		return strings.Repeat("*", len(runes))
+	}
+	return strings.Repeat("*", len(runes)-4) + string(runes[len(runes)-4:])
+}
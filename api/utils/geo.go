@@ -0,0 +1,17 @@
+package utils
+
+import "math"
+
+// EarthRadius mean radius of the earth in metres
+const EarthRadius = 6371000.0
+
+// HaversineDistance the great circle distance in metres between two positions in degrees
+func HaversineDistance(lat1, lng1, lat2, lng2 float64) float64 {
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+
+	dLat := toRad(lat2 - lat1)
+	dLng := toRad(lng2 - lng1)
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * EarthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
@@ -0,0 +1,466 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// a QR code in byte mode with error correction level M, versions 1 to 10 hold up to 213 bytes,
+// enough for otpauth URIs and links
+
+var ErrQRCodeTooLong = errors.New("text too long for a QR code")
+
+// the error correction blocks of each version at level M
+type qrVersion struct {
+	ecPerBlock int
+	blocks     []int // data codewords of each block
+	alignment  []int // centers of the alignment patterns
+}
+
+var qrVersions = []qrVersion{
+	1:  {10, []int{16}, nil},
+	2:  {16, []int{28}, []int{6, 18}},
+	3:  {26, []int{44}, []int{6, 22}},
+	4:  {18, []int{32, 32}, []int{6, 26}},
+	5:  {24, []int{43, 43}, []int{6, 30}},
+	6:  {16, []int{27, 27, 27, 27}, []int{6, 34}},
+	7:  {18, []int{31, 31, 31, 31}, []int{6, 22, 38}},
+	8:  {22, []int{38, 38, 39, 39}, []int{6, 24, 42}},
+	9:  {22, []int{36, 36, 36, 37, 37}, []int{6, 26, 46}},
+	10: {26, []int{43, 43, 43, 43, 44}, []int{6, 28, 50}},
+}
+
+func (v qrVersion) dataCodewords() int {
+	total := 0
+	for _, n := range v.blocks {
+		total += n
+	}
+	return total
+}
+
+type qrCode struct {
+	size     int
+	modules  [][]bool
+	function [][]bool // finder, timing, alignment, format and version modules
+}
+
+// QRCode the dark modules of the QR code of a text, rows of columns
+func QRCode(text string) ([][]bool, error) {
+	data := []byte(text)
+
+	version := 0
+	for v := 1; v < len(qrVersions); v++ {
+		countBits := 8
+		if v >= 10 {
+			countBits = 16
+		}
+		if 4+countBits+8*len(data) <= 8*qrVersions[v].dataCodewords() {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, ErrQRCodeTooLong
+	}
+
+	codewords := qrCodewords(qrVersions[version], version, data)
+
+	qr := newQRCode(version)
+	qr.drawCodewords(codewords)
+
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		qr.applyMask(mask)
+		qr.drawFormat(mask)
+		if penalty := qr.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		qr.applyMask(mask) // undo
+	}
+	qr.applyMask(best)
+	qr.drawFormat(best)
+
+	return qr.modules, nil
+}
+
+// QRCodePNG the QR code of a text as a PNG with modules of scale pixels and a quiet zone
+func QRCodePNG(text string, scale int) ([]byte, error) {
+	modules, err := QRCode(text)
+	if err != nil {
+		return nil, err
+	}
+
+	const quiet = 4
+	size := (len(modules) + 2*quiet) * scale
+	img := image.NewGray(image.Rect(0, 0, size, size))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	for y, row := range modules {
+		for x, dark := range row {
+			if !dark {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					img.SetGray((x+quiet)*scale+dx, (y+quiet)*scale+dy, color.Gray{})
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// qrCodewords the data in byte mode with padding, split in blocks with their error correction and interleaved
+func qrCodewords(v qrVersion, version int, data []byte) []byte {
+	var bits []bool
+	put := func(value, length int) {
+		for i := length - 1; i >= 0; i-- {
+			bits = append(bits, (value>>i)&1 == 1)
+		}
+	}
+
+	capacity := 8 * v.dataCodewords()
+	put(0x4, 4)
+	if version >= 10 {
+		put(len(data), 16)
+	} else {
+		put(len(data), 8)
+	}
+	for _, b := range data {
+		put(int(b), 8)
+	}
+	for i := 0; i < 4 && len(bits) < capacity; i++ {
+		bits = append(bits, false)
+	}
+	for len(bits)%8 != 0 {
+		bits = append(bits, false)
+	}
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		put(pad, 8)
+	}
+
+	all := make([]byte, len(bits)/8)
+	for i, bit := range bits {
+		if bit {
+			all[i/8] |= 1 << (7 - i%8)
+		}
+	}
+
+	divisor := reedSolomonDivisor(v.ecPerBlock)
+	var blocks, ecBlocks [][]byte
+	offset := 0
+	for _, n := range v.blocks {
+		block := all[offset : offset+n]
+		offset += n
+		blocks = append(blocks, block)
+		ecBlocks = append(ecBlocks, reedSolomonRemainder(block, divisor))
+	}
+
+	var result []byte
+	longest := v.blocks[len(v.blocks)-1]
+	for i := 0; i < longest; i++ {
+		for _, block := range blocks {
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+	for i := 0; i < v.ecPerBlock; i++ {
+		for _, ec := range ecBlocks {
+			result = append(result, ec[i])
+		}
+	}
+	return result
+}
+
+// gfMultiply multiply in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1
+func gfMultiply(x, y byte) byte {
+	var z byte
+	for i := 7; i >= 0; i-- {
+		carry := z >> 7
+		z <<= 1
+		if carry == 1 {
+			z ^= 0x1D
+		}
+		if (y>>i)&1 == 1 {
+			z ^= x
+		}
+	}
+	return z
+}
+
+// reedSolomonDivisor the generator polynomial of a degree, without its leading 1
+func reedSolomonDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	var root byte = 1
+	for i := 0; i < degree; i++ {
+		for j := 0; j < degree; j++ {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < degree {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+// reedSolomonRemainder the error correction codewords of the data
+func reedSolomonRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i := range result {
+			result[i] ^= gfMultiply(divisor[i], factor)
+		}
+	}
+	return result
+}
+
+func newQRCode(version int) *qrCode {
+	size := 17 + 4*version
+	qr := &qrCode{size: size, modules: make([][]bool, size), function: make([][]bool, size)}
+	for i := range qr.modules {
+		qr.modules[i] = make([]bool, size)
+		qr.function[i] = make([]bool, size)
+	}
+
+	for i := 0; i < size; i++ {
+		qr.set(6, i, i%2 == 0)
+		qr.set(i, 6, i%2 == 0)
+	}
+
+	qr.drawFinder(3, 3)
+	qr.drawFinder(size-4, 3)
+	qr.drawFinder(3, size-4)
+
+	centers := qrVersions[version].alignment
+	for i, x := range centers {
+		for j, y := range centers {
+			if (i == 0 && j == 0) || (i == 0 && j == len(centers)-1) || (i == len(centers)-1 && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					qr.set(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	// reserve the format modules, drawn with the mask
+	qr.drawFormat(0)
+
+	if version >= 7 {
+		bits := qrVersionBits(version)
+		for i := 0; i < 18; i++ {
+			a, b := size-11+i%3, i/3
+			qr.set(a, b, (bits>>i)&1 == 1)
+			qr.set(b, a, (bits>>i)&1 == 1)
+		}
+	}
+	return qr
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// set a function module at column x and row y
+func (qr *qrCode) set(x, y int, dark bool) {
+	qr.modules[y][x] = dark
+	qr.function[y][x] = true
+}
+
+func (qr *qrCode) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx >= 0 && xx < qr.size && yy >= 0 && yy < qr.size {
+				distance := max(abs(dx), abs(dy))
+				qr.set(xx, yy, distance != 2 && distance != 4)
+			}
+		}
+	}
+}
+
+// qrFormatBits the error correction level M and the mask with their BCH code
+func qrFormatBits(mask int) int {
+	data := mask // level M is 00
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+// qrVersionBits the version with its BCH code, from version 7
+func qrVersionBits(version int) int {
+	rem := version
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+	return version<<12 | rem
+}
+
+// drawFormat the format bits, twice
+func (qr *qrCode) drawFormat(mask int) {
+	bits := qrFormatBits(mask)
+	bit := func(i int) bool { return (bits>>i)&1 == 1 }
+
+	for i := 0; i <= 5; i++ {
+		qr.set(8, i, bit(i))
+	}
+	qr.set(8, 7, bit(6))
+	qr.set(8, 8, bit(7))
+	qr.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		qr.set(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		qr.set(qr.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		qr.set(8, qr.size-15+i, bit(i))
+	}
+	qr.set(8, qr.size-8, true) // the dark module
+}
+
+// drawCodewords place the codewords in the zigzag of column pairs from the bottom right
+func (qr *qrCode) drawCodewords(data []byte) {
+	i := 0
+	for right := qr.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < qr.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = qr.size - 1 - vert
+				}
+				if !qr.function[y][x] && i < len(data)*8 {
+					qr.modules[y][x] = (data[i>>3]>>(7-i&7))&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+// applyMask flip the data modules of the mask pattern, applying it twice undoes it
+func (qr *qrCode) applyMask(mask int) {
+	for y := 0; y < qr.size; y++ {
+		for x := 0; x < qr.size; x++ {
+			var flip bool
+			switch mask {
+			case 0:
+				flip = (x+y)%2 == 0
+			case 1:
+				flip = y%2 == 0
+			case 2:
+				flip = x%3 == 0
+			case 3:
+				flip = (x+y)%3 == 0
+			case 4:
+				flip = (x/3+y/2)%2 == 0
+			case 5:
+				flip = x*y%2+x*y%3 == 0
+			case 6:
+				flip = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				flip = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if flip && !qr.function[y][x] {
+				qr.modules[y][x] = !qr.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty how hard the code is to read, to choose the mask
+func (qr *qrCode) penalty() int {
+	penalty := 0
+	at := func(x, y int, transpose bool) bool {
+		if transpose {
+			return qr.modules[x][y]
+		}
+		return qr.modules[y][x]
+	}
+
+	finder := []bool{true, false, true, true, true, false, true}
+	for _, transpose := range []bool{false, true} {
+		for y := 0; y < qr.size; y++ {
+			run := 1
+			for x := 1; x <= qr.size; x++ {
+				if x < qr.size && at(x, y, transpose) == at(x-1, y, transpose) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					penalty += 3 + run - 5
+				}
+				run = 1
+			}
+
+			// dark light dark dark dark light dark with 4 light modules on a side
+			for x := 0; x+7 <= qr.size; x++ {
+				match := true
+				for k := 0; k < 7 && match; k++ {
+					match = at(x+k, y, transpose) == finder[k]
+				}
+				if !match {
+					continue
+				}
+				light := func(from, to int) bool {
+					for k := from; k < to; k++ {
+						if k >= 0 && k < qr.size && at(k, y, transpose) {
+							return false
+						}
+					}
+					return true
+				}
+				if light(x-4, x) || light(x+7, x+11) {
+					penalty += 40
+				}
+			}
+		}
+	}
+
+	dark := 0
+	for y := 0; y < qr.size; y++ {
+		for x := 0; x < qr.size; x++ {
+			if qr.modules[y][x] {
+				dark++
+			}
+			if x+1 < qr.size && y+1 < qr.size {
+				c := qr.modules[y][x]
+				if c == qr.modules[y][x+1] && c == qr.modules[y+1][x] && c == qr.modules[y+1][x+1] {
+					penalty += 3
+				}
+			}
+		}
+	}
+	total := qr.size * qr.size
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+	penalty += k * 10
+
+	return penalty
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"encoding/json"
+	"strconv"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -29,3 +30,12 @@ func SendJsonError(c *fiber.Ctx, err error) error {
 
 	return nil
 }
+
+// QueryInt read an integer query param or the default when missing or invalid
+func QueryInt(c *fiber.Ctx, key string, defaultValue int) int {
+	value, err := strconv.Atoi(c.Query(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
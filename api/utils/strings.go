@@ -30,8 +30,62 @@ func UnescapeAccents(s string) (string, error) {
 	return RemoveAccents(decodedValue), nil
 }
 
+// NormalizeAddress a city or a province without accents or repeated spaces, with the words
+// capitalised. Words already written in mixed case like McDonald are kept, and so are the short
+// codes like OH or DC unless the whole address is in capitals.
 func NormalizeAddress(s string) string {
-	return strings.Title(strings.ToLower(RemoveAccents(strings.Trim(s, " "))))
+	words := strings.Fields(RemoveAccents(s))
+	allCaps := strings.ToUpper(strings.Join(words, " ")) == strings.Join(words, " ")
+
+	for i, word := range words {
+		lower, upper := strings.ToLower(word), strings.ToUpper(word)
+		switch {
+		case word == upper && (len(words) == 1 || !allCaps) && len(word) <= 3:
+			// a code
+		case word != lower && word != upper && word != strings.Title(lower):
+			// mixed case
+		default:
+			words[i] = strings.Title(lower)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// legal entity suffixes ignored when comparing business names
+var legalSuffixes = map[string]bool{
+	"sas": true, "sa": true, "ltda": true, "eu": true, "inc": true,
+	"llc": true, "ltd": true, "co": true, "corp": true, "plc": true,
+}
+
+// NormalizeBusinessName reduce a business name to lower case words without accents,
+// punctuation or legal suffixes so "Taller Pérez S.A.S." and "taller perez" compare equal
+func NormalizeBusinessName(s string) string {
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		if r == '.' {
+			return -1
+		}
+		return ' '
+	}, strings.ToLower(NormalizeAddress(s)))
+
+	words := strings.Fields(name)
+	for len(words) > 1 && legalSuffixes[words[len(words)-1]] {
+		words = words[:len(words)-1]
+	}
+
+	return strings.Join(words, " ")
+}
+
+// NormalizeTaxId keep only the upper case letters and digits of a tax or national ID
+func NormalizeTaxId(s string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return -1
+	}, strings.ToUpper(s))
 }
 
 func GenerateUUID() string {
@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"errors"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// subdomains used by the platform that a business cannot take
+var reservedSubdomains = []string{
+	"admin", "api", "app", "assets", "auth", "blog", "cdn", "dashboard", "dev",
+	"docs", "ftp", "help", "img", "imap", "login", "mail", "marketplace", "mktplace",
+	"my", "myproject", "pop", "root", "smtp", "staging", "static", "status",
+	"store", "support", "team", "test", "user", "webmail", "ws", "www",
+}
+
+var subdomainPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// NormalizeSubdomain lower cases and trims a subdomain
+func NormalizeSubdomain(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+// ValidateSubdomain checks a normalised subdomain is a valid DNS label that is not reserved
+func ValidateSubdomain(s string) error {
+	if len(s) < 3 || len(s) > 63 {
+		return errors.New("the subdomain must be 3 to 63 characters")
+	}
+
+	if !subdomainPattern.MatchString(s) {
+		return errors.New("the subdomain can only contain letters, numbers and hyphens and cannot start or end with a hyphen")
+	}
+
+	if slices.Contains(reservedSubdomains, s) {
+		return errors.New("the subdomain is reserved")
+	}
+
+	return nil
+}
+
+// SubdomainOf returns the subdomain of host under domain, e.g. "mybiz" for
+// host "mybiz.myproject.com:443" and domain "myproject.com", or "" if the host
+// is not a single level subdomain of domain
+func SubdomainOf(host, domain string) string {
+	host = strings.ToLower(host)
+	if i := strings.LastIndex(host, ":"); i >= 0 && !strings.Contains(host[i:], "]") {
+		host = host[:i]
+	}
+
+	domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+	if domain == "" || !strings.HasSuffix(host, "."+domain) {
+		return ""
+	}
+
+	sub := strings.TrimSuffix(host, "."+domain)
+	if strings.Contains(sub, ".") {
+		return ""
+	}
+
+	return sub
+}
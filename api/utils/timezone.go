@@ -0,0 +1,162 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CountryTimezone a timezone of a country with the centre of its cities, weighted by population
+type CountryTimezone struct {
+	Zone      string
+	Latitude  float64
+	Longitude float64
+}
+
+var (
+	countryZones      = map[string][]CountryTimezone{} // by ISO 3166-1 alpha-2 code, the most populated zone first
+	countryZonesMutex sync.RWMutex
+)
+
+// RegisterCountryTimezones set the timezones of a country from the reference geography, the most populated first
+func RegisterCountryTimezones(code string, zones []CountryTimezone) {
+	countryZonesMutex.Lock()
+	defer countryZonesMutex.Unlock()
+
+	if len(zones) == 0 {
+		delete(countryZones, strings.ToUpper(code))
+		return
+	}
+	countryZones[strings.ToUpper(code)] = zones
+}
+
+// referenceTimezone the timezone of the reference geography with the centre nearest to the latlng,
+// the most populated one without a latlng
+func referenceTimezone(country string, latlng string) (string, bool) {
+	countryZonesMutex.RLock()
+	zones := countryZones[CountryCode(country)]
+	countryZonesMutex.RUnlock()
+
+	if len(zones) == 0 {
+		return "", false
+	}
+	if len(zones) == 1 || strings.TrimSpace(latlng) == "" {
+		return zones[0].Zone, true
+	}
+
+	lat, lng := ExtractLatLng(latlng)
+	nearest, distance := zones[0].Zone, math.Inf(1)
+	for _, zone := range zones {
+		if d := HaversineDistance(lat, lng, zone.Latitude, zone.Longitude); d < distance {
+			nearest, distance = zone.Zone, d
+		}
+	}
+	return nearest, true
+}
+
+// IANA timezone of countries with a single timezone, or the most used one, until the reference geography is loaded
+var countryTimezones = map[string]string{
+	"colombia":           "America/Bogota",
+	"co":                 "America/Bogota",
+	"ecuador":            "America/Guayaquil",
+	"peru":               "America/Lima",
+	"panama":             "America/Panama",
+	"venezuela":          "America/Caracas",
+	"chile":              "America/Santiago",
+	"argentina":          "America/Argentina/Buenos_Aires",
+	"costa rica":         "America/Costa_Rica",
+	"guatemala":          "America/Guatemala",
+	"dominican republic": "America/Santo_Domingo",
+	"uk":                 "Europe/London",
+	"gb":                 "Europe/London",
+	"united kingdom":     "Europe/London",
+	"ireland":            "Europe/Dublin",
+	"spain":              "Europe/Madrid",
+	"es":                 "Europe/Madrid",
+	"france":             "Europe/Paris",
+	"germany":            "Europe/Berlin",
+	"italy":              "Europe/Rome",
+	"portugal":           "Europe/Lisbon",
+	"netherlands":        "Europe/Amsterdam",
+}
+
+// US timezones from east to west by the longitude they start at
+var usaTimezones = []struct {
+	west float64
+	zone string
+}{
+	{-87.5, "America/New_York"},
+	{-101.5, "America/Chicago"},
+	{-115, "America/Denver"},
+	{-141, "America/Los_Angeles"},
+	{-180, "America/Anchorage"},
+}
+
+// TimezoneFor returns the IANA timezone for a country and a latlng, e.g. "4.8057849, -75.6830817".
+// The timezones of the reference geography are used when loaded. Without them countries spanning
+// several timezones use the longitude, and unknown countries fall back to the fixed Etc/GMT offset
+// of the longitude, or UTC without a latlng.
+func TimezoneFor(country string, latlng string) string {
+	if tz, ok := referenceTimezone(country, latlng); ok {
+		return tz
+	}
+
+	country = strings.ToLower(strings.TrimSpace(RemoveAccents(country)))
+
+	_, lng := ExtractLatLng(latlng)
+	hasLatlng := strings.TrimSpace(latlng) != ""
+
+	if country == "usa" || country == "united states" || country == "us" {
+		if !hasLatlng {
+			return "America/New_York"
+		}
+		if lng < -150 && lng > -161 {
+			return "Pacific/Honolulu"
+		}
+		for _, tz := range usaTimezones {
+			if lng >= tz.west {
+				return tz.zone
+			}
+		}
+		return "America/Anchorage"
+	}
+
+	if country == "mexico" {
+		if hasLatlng && lng < -106 {
+			return "America/Tijuana"
+		}
+		return "America/Mexico_City"
+	}
+
+	if tz, ok := countryTimezones[country]; ok {
+		return tz
+	}
+
+	if !hasLatlng {
+		return "UTC"
+	}
+
+	// Etc/GMT zones have the sign reversed, Etc/GMT+5 is UTC-5
+	offset := int(math.Round(lng / 15))
+	if offset == 0 {
+		return "UTC"
+	}
+	return fmt.Sprintf("Etc/GMT%+d", -offset)
+}
+
+// LoadTimezone loads the named timezone, falling back to UTC if it is unknown
+func LoadTimezone(name string) *time.Location {
+	if name == "" {
+		return time.UTC
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		fmt.Println(err)
+		return time.UTC
+	}
+
+	return loc
+}
@@ -1,13 +1,8 @@
 package utils
 
 import (
-	"encoding/json"
 	"fmt"
-	"io"
-	"log"
 	"math/rand"
-	"myproject/api/database"
-	"net/http"
 	"reflect"
 	"strconv"
 	"strings"
@@ -58,69 +53,6 @@ func ExtractLatLng(latlng string) (float64, float64) {
 	return lat, lng
 }
 
-func GeocodeAddress(address string) (string, error) {
-	// use the google maps api to get the lat/lng for the address
-	// https://maps.googleapis.com/maps/api/geocode/json?address=1600+Amphitheatre+Parkway,+Mountain+View,+CA&key=YOUR_API_KEY
-
-	// uriencode the address
-	address = strings.ReplaceAll(address, " ", "+")
-	key := database.GetParam("GOOGLE_MAPS_API_KEY")
-
-	if key == "" {
-		return "", fmt.Errorf("no google maps api key found in database")
-	}
-
-	uri := fmt.Sprintf("https://maps.googleapis.com/maps/api/geocode/json?address=%s&key=%s", address, key)
-
-	resp, err := http.Get(uri)
-	if err != nil {
-		log.Print(err)
-		return "", err
-	}
-
-	defer resp.Body.Close()
-
-	barr, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Print(err)
-		return "", err
-	}
-
-	body := string(barr[:])
-
-	// fmt.Println("response Status:", resp.Status)
-	// fmt.Println("response Headers:", resp.Header)
-	// fmt.Println("response Body:", body)
-
-	var result map[string]interface{}
-	json.Unmarshal([]byte(body), &result)
-
-	// extract the lat/lng
-	results, ok := result["results"].([]interface{})
-	if !ok || len(results) == 0 {
-		return "", fmt.Errorf("no results found")
-	}
-
-	geometry, ok := results[0].(map[string]interface{})["geometry"].(map[string]interface{})
-	if !ok {
-		return "", fmt.Errorf("no geometry found")
-	}
-
-	// extract the lat/lng
-	latlng, ok := geometry["location"].(map[string]interface{})
-	if !ok {
-		return "", fmt.Errorf("no location found")
-	}
-
-	lat := latlng["lat"].(float64)
-	lng := latlng["lng"].(float64)
-
-	// fmt.Println("lat/lng", lat, lng)
-
-	return fmt.Sprintf("%f,%f", lat, lng), nil
-
-}
-
 // Helper function to convert numbers to words
 func NumberToWords(num uint) string {
 	if num == 0 {
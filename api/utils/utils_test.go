@@ -1,6 +1,12 @@
 package utils
 
 import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image/png"
+	"myproject/api/database"
 	"myproject/test"
 	"strings"
 	"testing"
@@ -73,6 +79,17 @@ func TestExtractLatLng(t *testing.T) {
 	}
 }
 
+func TestHaversineDistance(t *testing.T) {
+	assert.Equal(t, 0.0, HaversineDistance(4.8057849, -75.6830817, 4.8057849, -75.6830817))
+
+	// Bogota to Medellin is about 240 km
+	d := HaversineDistance(4.7110, -74.0721, 6.2442, -75.5812)
+	assert.InDelta(t, 240000, d, 5000)
+
+	// one degree of latitude is about 111 km
+	assert.InDelta(t, 111195, HaversineDistance(0, 0, 1, 0), 10)
+}
+
 func TestNumberToWords(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -104,27 +121,523 @@ func TestNumberToWords(t *testing.T) {
 	}
 }
 
-// TestGeocodeAddress would require mocking the HTTP request to Google Maps API
-// This is a simplified version that just checks the function doesn't crash
-func TestGeocodeAddress(t *testing.T) {
-	setupUtilsTestApp(t)
-	//t.Logf("app: %v", app)
-	//t.Logf("db: %v", setupDB)
+func TestTimezoneFor(t *testing.T) {
+	tests := []struct {
+		name     string
+		country  string
+		latlng   string
+		expected string
+	}{
+		{"Colombia", "Colombia", "4.8057849, -75.6830817", "America/Bogota"},
+		{"Cleveland", "USA", "41.47741, -81.688046", "America/New_York"},
+		{"Denver", "United States", "39.7392, -104.9903", "America/Denver"},
+		{"Los Angeles", "USA", "34.0522, -118.2437", "America/Los_Angeles"},
+		{"Unknown country", "Atlantis", "0, -45", "Etc/GMT+3"},
+		{"No latlng", "Atlantis", "", "UTC"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, TimezoneFor(tt.country, tt.latlng))
+		})
+	}
+
+	t.Run("Reference geography", func(t *testing.T) {
+		RegisterCountryTimezones("CL", []CountryTimezone{
+			{Zone: "America/Santiago", Latitude: -33.45, Longitude: -70.66},
+			{Zone: "Pacific/Easter", Latitude: -27.15, Longitude: -109.43},
+		})
+		t.Cleanup(func() { RegisterCountryTimezones("CL", nil) })
+
+		assert.Equal(t, "America/Santiago", TimezoneFor("Chile", ""))
+		assert.Equal(t, "America/Santiago", TimezoneFor("Chile", "-36.82, -73.05"))
+		assert.Equal(t, "Pacific/Easter", TimezoneFor("Chile", "-27.11, -109.35"))
+	})
+}
+
+func TestValidateSubdomain(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		isValid bool
+	}{
+		{"Valid name", "mybiz", true},
+		{"Valid with hyphen", "my-biz-2", true},
+		{"Generated UUID", "0b4e7a0e-5b7a-4c3a-9a9b-6c1f2e3d4c5b", true},
+		{"Too short", "ab", false},
+		{"Leading hyphen", "-mybiz", false},
+		{"Dots", "my.biz", false},
+		{"Reserved", "www", false},
+		{"Reserved api", "api", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateSubdomain(tt.input)
+			assert.Equal(t, tt.isValid, err == nil)
+		})
+	}
+}
+
+func TestSubdomainOf(t *testing.T) {
+	assert.Equal(t, "mybiz", SubdomainOf("MyBiz.myproject.com", "myproject.com"))
+	assert.Equal(t, "mybiz", SubdomainOf("mybiz.myproject.com:3000", "myproject.com"))
+	assert.Equal(t, "", SubdomainOf("myproject.com", "myproject.com"))
+	assert.Equal(t, "", SubdomainOf("a.mybiz.myproject.com", "myproject.com"))
+	assert.Equal(t, "", SubdomainOf("mybiz.other.com", "myproject.com"))
+}
+
+func TestNormalizeBusinessName(t *testing.T) {
+	assert.Equal(t, "taller perez", NormalizeBusinessName("Taller Pérez S.A.S."))
+	assert.Equal(t, "taller perez", NormalizeBusinessName("  TALLER  PEREZ sas"))
+	assert.Equal(t, "acme", NormalizeBusinessName("Acme, Inc."))
+	assert.Equal(t, "sas", NormalizeBusinessName("SAS"))
+}
+
+func TestNormalizeTaxId(t *testing.T) {
+	assert.Equal(t, "9001234567", NormalizeTaxId("900.123.456-7"))
+	assert.Equal(t, "AB123", NormalizeTaxId(" ab-123 "))
+}
+
+func TestNormalizeAddress(t *testing.T) {
+	assert.Equal(t, "Bogota", NormalizeAddress("  bogotá "))
+	assert.Equal(t, "Valle Del Cauca", NormalizeAddress("VALLE DEL  CAUCA"))
+	assert.Equal(t, "McDonald", NormalizeAddress("McDonald"))
+	assert.Equal(t, "OH", NormalizeAddress("OH"))
+	assert.Equal(t, "Washington DC", NormalizeAddress("washington DC"))
+}
+
+func TestValidateAddress(t *testing.T) {
+	t.Run("Country names and ISO codes", func(t *testing.T) {
+		for _, name := range []string{"USA", "Usa", "us", "United States", "U.S.A."} {
+			assert.Equal(t, "US", CountryCode(name), name)
+		}
+		assert.Equal(t, "GB", CountryCode("United Kingdom"))
+		assert.Equal(t, "CO", CountryCode("COL"))
+		assert.Equal(t, "", CountryCode("Atlantis"))
+
+		address := NormalizeAddressFor(Address{City: "london", Country: "United Kingdom"})
+		assert.Equal(t, "UK", address.Country)
+		assert.Equal(t, "GB", address.CountryCode)
+	})
+
+	t.Run("Provinces keep their code or name", func(t *testing.T) {
+		address, err := ValidateAddress(Address{City: "cleveland", Province: "oh", Postcode: "44113", Country: "USA"})
+		assert.Nil(t, err)
+		assert.Equal(t, "Cleveland", address.City)
+		assert.Equal(t, "OH", address.Province)
+
+		address, err = ValidateAddress(Address{Province: "OHIO", Country: "USA"})
+		assert.Nil(t, err)
+		assert.Equal(t, "Ohio", address.Province)
+
+		address, err = ValidateAddress(Address{City: "Bogotá", Province: "Bogotá D.C.", Country: "Colombia"})
+		assert.Nil(t, err)
+		assert.Equal(t, "Bogota", address.Province)
+
+		_, err = ValidateAddress(Address{Province: "Ohoi", Country: "USA"})
+		var problems AddressError
+		assert.ErrorAs(t, err, &problems)
+		assert.Contains(t, problems, "province")
+	})
 
-	t.Run("Skip actual API call", func(t *testing.T) {
-		// Skip this test in normal runs as it requires API key and makes external calls
-		//t.Skip("Skipping test that makes external API calls")
+	t.Run("Postal codes", func(t *testing.T) {
+		address, err := ValidateAddress(Address{Postcode: "sw1a1aa", Country: "UK"})
+		assert.Nil(t, err)
+		assert.Equal(t, "SW1A 1AA", address.Postcode)
+
+		_, err = ValidateAddress(Address{Province: "Ohio", Postcode: "4411", Country: "USA"})
+		var problems AddressError
+		assert.ErrorAs(t, err, &problems)
+		assert.Equal(t, []string{"postcode"}, keys(problems))
+		assert.Contains(t, err.Error(), "44113")
 
-		address := "11109 Starkweather Ave, Cleveland, OH"
-		latlng, err := GeocodeAddress(address)
+		// not rewritten when invalid
+		address, _ = ValidateAddress(Address{Postcode: "12-34", Country: "Colombia"})
+		assert.Equal(t, "12-34", address.Postcode)
+	})
 
+	t.Run("Countries without rules are not checked", func(t *testing.T) {
+		address, err := ValidateAddress(Address{City: "atlantis city", Province: "Deep", Postcode: "??", Country: "Atlantis"})
 		assert.Nil(t, err)
-		assert.True(t, strings.Contains(latlng, ","))
+		assert.Equal(t, "Atlantis City", address.City)
+		assert.Equal(t, "", address.CountryCode)
+	})
 
-		lat, lng := ExtractLatLng(latlng)
-		assert.NotZero(t, lat)
-		assert.NotZero(t, lng)
-		assert.Equal(t, 41.47741, lat)
-		assert.Equal(t, -81.688046, lng)
+	t.Run("Registered rules", func(t *testing.T) {
+		assert.Nil(t, RegisterCountryRules(CountryRules{Code: "zz", Name: "Zedland", Currency: "ZZD", PostalRegex: `^\d{3}$`}))
+		rules, ok := CountryRulesFor("zedland")
+		assert.True(t, ok)
+		assert.Equal(t, "ZZD", rules.Currency)
+
+		_, err := ValidateAddress(Address{Postcode: "1234", Country: "ZZ"})
+		assert.NotNil(t, err)
+
+		assert.NotNil(t, RegisterCountryRules(CountryRules{Code: "ZZZ"}))
 	})
 }
+
+func keys(m map[string]string) []string {
+	list := []string{}
+	for key := range m {
+		list = append(list, key)
+	}
+	return list
+}
+
+func TestEncryptField(t *testing.T) {
+	key1 := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	key2 := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32))
+
+	params := database.SystemParams
+	database.SystemParams = map[string]string{}
+	t.Cleanup(func() { database.SystemParams = params })
+
+	// stored as is without keys
+	stored, err := EncryptField("12345678")
+	assert.Nil(t, err)
+	assert.Equal(t, "12345678", stored)
+
+	database.SystemParams["encryption_keys"] = "k1:" + key1
+	_, err = EncryptField("12345678")
+	assert.NotNil(t, err, "the blind index key is required with the master keys")
+
+	database.SystemParams["encryption_index_key"] = "index"
+	k1Value, err := EncryptField("12345678")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(k1Value, EncryptedPrefix+"k1:"))
+	assert.NotContains(t, k1Value, "12345678")
+
+	again, _ := EncryptField("12345678")
+	assert.NotEqual(t, k1Value, again, "each value has its own data key and nonce")
+
+	empty, _ := EncryptField("")
+	assert.Equal(t, "", empty)
+
+	// rotate to k2, values under k1 still decrypt
+	database.SystemParams["encryption_keys"] = "k1:" + key1 + ",k2:" + key2
+	database.SystemParams["encryption_key_id"] = "k2"
+	assert.Equal(t, "k2", ActiveEncryptionKey())
+
+	plain, err := DecryptField(k1Value)
+	assert.Nil(t, err)
+	assert.Equal(t, "12345678", plain)
+
+	k2Value, _ := EncryptField("12345678")
+	assert.True(t, strings.HasPrefix(k2Value, EncryptedPrefix+"k2:"))
+
+	// k1 removed
+	database.SystemParams["encryption_keys"] = "k2:" + key2
+	_, err = DecryptField(k1Value)
+	assert.Equal(t, ErrUnknownEncryptionKey, err)
+
+	plain, err = DecryptField("stored before encryption")
+	assert.Nil(t, err)
+	assert.Equal(t, "stored before encryption", plain)
+
+	_, err = DecryptField(k2Value[:len(k2Value)-4] + "AAAA")
+	assert.Equal(t, ErrInvalidEncryptedValue, err)
+
+	database.SystemParams["encryption_keys"] = "k2:short"
+	_, err = EncryptField("12345678")
+	assert.NotNil(t, err)
+}
+
+func TestMaskValue(t *testing.T) {
+	assert.Equal(t, "", MaskValue(""))
+	assert.Equal(t, "******", MaskValue("123456"))
+	assert.Equal(t, "****5678", MaskValue("12345678"))
+
+	params := database.SystemParams
+	database.SystemParams = map[string]string{"jwt_secret": "secret"}
+	t.Cleanup(func() { database.SystemParams = params })
+
+	assert.Equal(t, "", BlindIndex("900123"), "no index without a dedicated key")
+
+	database.SystemParams["encryption_index_key"] = "index"
+	assert.NotEqual(t, "", BlindIndex("900123"))
+	assert.Equal(t, BlindIndex("900123"), BlindIndex("900123"))
+	assert.NotEqual(t, BlindIndex("900123"), BlindIndex("900124"))
+	assert.Equal(t, "", BlindIndex(""))
+}
+
+func TestQRCode(t *testing.T) {
+	// the error correction of HELLO WORLD in version 1-M
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	assert.Equal(t, []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}, reedSolomonRemainder(data, reedSolomonDivisor(10)))
+
+	for mask, bits := range []int{0x5412, 0x5125, 0x5E7C, 0x5B4B, 0x45F9, 0x40CE, 0x4F97, 0x4AA0} {
+		assert.Equal(t, bits, qrFormatBits(mask), "mask %d", mask)
+	}
+	assert.Equal(t, 0x07C94, qrVersionBits(7))
+
+	modules, err := QRCode("hello")
+	assert.Nil(t, err)
+	assert.Equal(t, 21, len(modules))
+	// the finder pattern of the top left corner
+	for i := 0; i < 7; i++ {
+		assert.True(t, modules[0][i])
+		assert.True(t, modules[6][i])
+		assert.True(t, modules[i][0])
+	}
+	assert.False(t, modules[1][1])
+	assert.True(t, modules[3][3])
+	assert.False(t, modules[7][7])
+
+	modules, err = QRCode(strings.Repeat("a", 100))
+	assert.Nil(t, err)
+	assert.Greater(t, len(modules), 21)
+
+	image, err := QRCodePNG("hello", 4)
+	assert.Nil(t, err)
+	decoded, err := png.Decode(bytes.NewReader(image))
+	assert.Nil(t, err)
+	assert.Equal(t, (21+8)*4, decoded.Bounds().Dx())
+
+	_, err = QRCode(strings.Repeat("a", 300))
+	assert.ErrorIs(t, err, ErrQRCodeTooLong)
+
+	t.Run("Decode", func(t *testing.T) {
+		// the texts that fill each version from 1 to 10
+		for i, n := range []int{1, 14, 26, 42, 62, 84, 106, 122, 152, 180, 213} {
+			text := strings.Repeat("otpauth://totp/myproject:x?secret=ABC&", 6)[:n]
+			modules, err := QRCode(text)
+			assert.Nil(t, err)
+			assert.Equal(t, 17+4*max(i, 1), len(modules), "%d bytes", n)
+
+			decoded, err := decodeQRCode(modules)
+			assert.Nil(t, err, "%d bytes", n)
+			assert.Equal(t, text, decoded)
+		}
+
+		// with each mask, whichever has the lowest penalty
+		codewords := qrCodewords(qrVersions[7], 7, []byte("otpauth://totp/myproject"))
+		for mask := 0; mask < 8; mask++ {
+			qr := newQRCode(7)
+			qr.drawCodewords(codewords)
+			qr.applyMask(mask)
+			qr.drawFormat(mask)
+
+			decoded, err := decodeQRCode(qr.modules)
+			assert.Nil(t, err, "mask %d", mask)
+			assert.Equal(t, "otpauth://totp/myproject", decoded, "mask %d", mask)
+		}
+
+		modules, _ := QRCode("hello")
+		modules[10][10] = !modules[10][10]
+		_, err := decodeQRCode(modules)
+		assert.NotNil(t, err, "a wrong module fails the error correction")
+	})
+}
+
+// the level M error correction of the versions 1 to 10 as in the standard: the codewords of each block
+// and the groups of blocks with their count and data codewords
+var qrStandardBlocks = map[int]struct {
+	ec     int
+	groups [][2]int
+}{
+	1: {10, [][2]int{{1, 16}}}, 2: {16, [][2]int{{1, 28}}}, 3: {26, [][2]int{{1, 44}}},
+	4: {18, [][2]int{{2, 32}}}, 5: {24, [][2]int{{2, 43}}}, 6: {16, [][2]int{{4, 27}}},
+	7: {18, [][2]int{{4, 31}}}, 8: {22, [][2]int{{2, 38}, {2, 39}}}, 9: {22, [][2]int{{3, 36}, {2, 37}}},
+	10: {26, [][2]int{{4, 43}, {1, 44}}},
+}
+
+var qrStandardAlignment = map[int][]int{
+	2: {6, 18}, 3: {6, 22}, 4: {6, 26}, 5: {6, 30}, 6: {6, 34},
+	7: {6, 22, 38}, 8: {6, 24, 42}, 9: {6, 26, 46}, 10: {6, 28, 50},
+}
+
+// decodeQRCode read back a byte mode QR code of level M like a scanner would, written from the standard
+// and not with the encoder: format and version information, unmasking, the zigzag, the blocks and the data.
+// Only the error correction codewords are computed with the encoder, TestQRCode checks them against the standard.
+func decodeQRCode(modules [][]bool) (string, error) {
+	size := len(modules)
+	version := (size - 17) / 4
+	blocks, ok := qrStandardBlocks[version]
+	if !ok || size != 17+4*version {
+		return "", fmt.Errorf("no version of size %d", size)
+	}
+	dark := func(x, y int) bool { return modules[y][x] }
+
+	// the format around the top left finder, and its copy split between the two others
+	var format, formatCopy int
+	for i, at := range [][2]int{{8, 0}, {8, 1}, {8, 2}, {8, 3}, {8, 4}, {8, 5}, {8, 7}, {8, 8}, {7, 8}, {5, 8}, {4, 8}, {3, 8}, {2, 8}, {1, 8}, {0, 8}} {
+		if dark(at[0], at[1]) {
+			format |= 1 << i
+		}
+	}
+	for i := 0; i < 15; i++ {
+		x, y := size-1-i, 8
+		if i >= 8 {
+			x, y = 8, size-15+i
+		}
+		if dark(x, y) {
+			formatCopy |= 1 << i
+		}
+	}
+	if format != formatCopy {
+		return "", errors.New("the format copies differ")
+	}
+	format ^= 0x5412
+	if bchRemainder(format, 0x537, 10) != 0 || format>>13 != 0 {
+		return "", fmt.Errorf("invalid format %x", format)
+	}
+	mask := format >> 10 & 7
+
+	if version >= 7 {
+		var info, infoCopy int
+		for i := 0; i < 18; i++ {
+			if dark(i/3, size-11+i%3) {
+				info |= 1 << i
+			}
+			if dark(size-11+i%3, i/3) {
+				infoCopy |= 1 << i
+			}
+		}
+		if info != infoCopy || bchRemainder(info, 0x1F25, 12) != 0 || info>>12 != version {
+			return "", fmt.Errorf("invalid version information %x", info)
+		}
+	}
+
+	last := size - 7
+	function := func(x, y int) bool {
+		switch {
+		case x < 9 && y < 9, x >= size-8 && y < 9, x < 9 && y >= size-8:
+			return true // finders with their separators, the format and the dark module
+		case x == 6, y == 6:
+			return true // timing
+		case version >= 7 && (x < 6 && y >= size-11 && y < size-8 || y < 6 && x >= size-11 && x < size-8):
+			return true // version information
+		}
+		for _, cx := range qrStandardAlignment[version] {
+			for _, cy := range qrStandardAlignment[version] {
+				if cx == 6 && cy == 6 || cx == 6 && cy == last || cx == last && cy == 6 {
+					continue
+				}
+				if x >= cx-2 && x <= cx+2 && y >= cy-2 && y <= cy+2 {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	// the mask conditions of the standard, on the row i and the column j
+	masked := func(i, j int) bool {
+		switch mask {
+		case 0:
+			return (i+j)%2 == 0
+		case 1:
+			return i%2 == 0
+		case 2:
+			return j%3 == 0
+		case 3:
+			return (i+j)%3 == 0
+		case 4:
+			return (i/2+j/3)%2 == 0
+		case 5:
+			return (i*j)%2+(i*j)%3 == 0
+		case 6:
+			return ((i*j)%2+(i*j)%3)%2 == 0
+		}
+		return ((i+j)%2+(i*j)%3)%2 == 0
+	}
+
+	// two columns at a time from the bottom right, up then down, skipping the vertical timing
+	var bits []bool
+	upward := true
+	for right := size - 1; right > 0; right -= 2 {
+		if right == 6 {
+			right--
+		}
+		for k := 0; k < size; k++ {
+			y := k
+			if upward {
+				y = size - 1 - k
+			}
+			for _, x := range []int{right, right - 1} {
+				if !function(x, y) {
+					bits = append(bits, dark(x, y) != masked(y, x))
+				}
+			}
+		}
+		upward = !upward
+	}
+	codewords := make([]byte, len(bits)/8)
+	for i := range codewords {
+		for _, bit := range bits[8*i : 8*i+8] {
+			codewords[i] <<= 1
+			if bit {
+				codewords[i] |= 1
+			}
+		}
+	}
+
+	// the data codewords of the blocks are interleaved, then their error correction
+	var dataBlocks, ecBlocks [][]byte
+	for _, group := range blocks.groups {
+		for n := 0; n < group[0]; n++ {
+			dataBlocks = append(dataBlocks, make([]byte, group[1]))
+			ecBlocks = append(ecBlocks, make([]byte, blocks.ec))
+		}
+	}
+	next := 0
+	longest := blocks.groups[len(blocks.groups)-1][1]
+	for i := 0; i < longest+blocks.ec; i++ {
+		for b := range dataBlocks {
+			switch {
+			case i < longest && i < len(dataBlocks[b]):
+				dataBlocks[b][i] = codewords[next]
+			case i >= longest:
+				ecBlocks[b][i-longest] = codewords[next]
+			default:
+				continue
+			}
+			next++
+		}
+	}
+
+	var data []byte
+	for b, block := range dataBlocks {
+		if !bytes.Equal(ecBlocks[b], reedSolomonRemainder(block, reedSolomonDivisor(blocks.ec))) {
+			return "", fmt.Errorf("block %d fails the error correction", b)
+		}
+		data = append(data, block...)
+	}
+
+	position := 0
+	read := func(n int) int {
+		value := 0
+		for ; n > 0; n-- {
+			value = value<<1 | int(data[position/8]>>(7-position%8)&1)
+			position++
+		}
+		return value
+	}
+	if mode := read(4); mode != 0x4 {
+		return "", fmt.Errorf("mode %x is not byte mode", mode)
+	}
+	countBits := 8
+	if version >= 10 {
+		countBits = 16
+	}
+	count := read(countBits)
+	if 4+countBits+8*count > 8*len(data) {
+		return "", fmt.Errorf("%d bytes do not fit in version %d", count, version)
+	}
+	text := make([]byte, count)
+	for i := range text {
+		text[i] = byte(read(8))
+	}
+	return string(text), nil
+}
+
+// bchRemainder the remainder of the bits divided by the generator of a degree
+func bchRemainder(value, generator, degree int) int {
+	for i := 30; i >= degree; i-- {
+		if value>>i&1 == 1 {
+			value ^= generator << (i - degree)
+		}
+	}
+	return value
+}
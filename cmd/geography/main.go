@@ -0,0 +1,129 @@
+// Command geography loads reference geography into the countries, states and cities tables
+// from GeoNames dumps (https://download.geonames.org/export/dump/) or comma separated files with a header.
+//
+//	go run ./cmd/geography -kind countries -url https://download.geonames.org/export/dump/countryInfo.txt
+//	go run ./cmd/geography -kind states -url https://download.geonames.org/export/dump/admin1CodesASCII.txt
+//	go run ./cmd/geography -kind cities -country CO -url https://download.geonames.org/export/dump/CO.zip
+//	go run ./cmd/geography -kind cities -file modifications-2025-03-01.txt
+//	go run ./cmd/geography -kind deletes -file deletes-2025-03-01.txt
+//
+// Load the countries and the states before the cities so the cities get their names.
+// Loading a dataset again only writes the rows that changed.
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"myproject/api/database"
+	"myproject/api/services"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	GormLogger "gorm.io/gorm/logger"
+)
+
+// open the file or download the URL, the first .txt or .csv of a zip archive
+func open(file, url string) (io.ReadCloser, string, error) {
+
+	source := file
+	if url != "" {
+		source = url
+		resp, err := http.Get(url)
+		if err != nil {
+			return nil, source, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, source, fmt.Errorf("%s: %s", url, resp.Status)
+		}
+
+		tmp, err := os.CreateTemp("", "geography-*"+path.Ext(url))
+		if err != nil {
+			return nil, source, err
+		}
+		defer os.Remove(tmp.Name())
+		if _, err := io.Copy(tmp, resp.Body); err != nil {
+			return nil, source, err
+		}
+		tmp.Close()
+		file = tmp.Name()
+	}
+
+	if !strings.HasSuffix(strings.ToLower(file), ".zip") {
+		f, err := os.Open(file)
+		return f, source, err
+	}
+
+	zr, err := zip.OpenReader(file)
+	if err != nil {
+		return nil, source, err
+	}
+	for _, entry := range zr.File {
+		name := strings.ToLower(entry.Name)
+		if (strings.HasSuffix(name, ".txt") || strings.HasSuffix(name, ".csv")) && !strings.Contains(name, "readme") {
+			r, err := entry.Open()
+			if err != nil {
+				zr.Close()
+				return nil, source, err
+			}
+			return struct {
+				io.Reader
+				io.Closer
+			}{r, zr}, source + "/" + entry.Name, nil
+		}
+	}
+	zr.Close()
+	return nil, source, errors.New("no .txt or .csv in " + source)
+}
+
+func main() {
+
+	kind := flag.String("kind", "", "countries, states, cities or deletes")
+	country := flag.String("country", "", "ISO code of the only country to load")
+	minPopulation := flag.Int64("min-population", 0, "skip the cities with fewer people")
+	file := flag.String("file", "", "dataset file, .txt, .csv or .zip")
+	url := flag.String("url", "", "dataset URL, instead of a file")
+	flag.Parse()
+
+	if *kind == "" || (*file == "") == (*url == "") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := database.LoadConfig()
+	if err != nil {
+		panic(err)
+	}
+	database.SystemParams = cfg.Params
+
+	db, err := gorm.Open(postgres.Open(cfg.Database.Primary.GetDSN()), &gorm.Config{
+		Logger:                                   GormLogger.Default.LogMode(GormLogger.Warn),
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	r, source, err := open(*file, *url)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer r.Close()
+
+	load, err := services.LoadGeography(db, *kind, r, services.GeographyOptions{Country: *country, MinPopulation: *minPopulation, Source: source})
+	fmt.Printf("%s from %s: %d created, %d updated, %d deleted, %d skipped, %d errors\n",
+		load.Kind, source, load.Created, load.Updated, load.Deleted, load.Skipped, load.Errors)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
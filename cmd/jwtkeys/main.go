@@ -0,0 +1,85 @@
+// Command jwtkeys generates and rotates the keys that sign the access tokens. The servers read
+// the keys again within a minute, and publish the public keys at /.well-known/jwks.json.
+//
+//	go run ./cmd/jwtkeys -list
+//	go run ./cmd/jwtkeys -rotate -alg EdDSA
+//	go run ./cmd/jwtkeys -retire 20250301-1a2b3c4d
+//
+// A rotated key keeps verifying the tokens it signed for JWT_KEY_OVERLAP_HOURS (24 by default).
+// Retiring a key rejects the access tokens it signed, the clients get new ones with their refresh token.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"myproject/api/database"
+	"myproject/api/models"
+	"myproject/api/services"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	GormLogger "gorm.io/gorm/logger"
+)
+
+func main() {
+
+	list := flag.Bool("list", false, "list the keys")
+	rotate := flag.Bool("rotate", false, "generate a new active key, the first key when there is none")
+	alg := flag.String("alg", services.AlgorithmRS256, "algorithm of the new key, RS256 or EdDSA")
+	retire := flag.String("retire", "", "kid of a replaced key to stop trusting")
+	flag.Parse()
+
+	if !*list && !*rotate && *retire == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := database.LoadConfig()
+	if err != nil {
+		panic(err)
+	}
+	database.SystemParams = cfg.Params
+
+	db, err := gorm.Open(postgres.Open(cfg.Database.Primary.GetDSN()), &gorm.Config{
+		Logger:                                   GormLogger.Default.LogMode(GormLogger.Warn),
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	if err := models.MigrateSigningKey(db); err != nil {
+		panic(err)
+	}
+
+	if *retire != "" {
+		if err := services.RetireSigningKey(db, *retire); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Println("retired", *retire)
+	}
+
+	if *rotate {
+		key, err := services.RotateSigningKey(db, *alg)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Println("active", key.Kid, key.Algorithm)
+	}
+
+	if *list {
+		var keys []models.SigningKey
+		db.Order("created_at desc").Find(&keys)
+		for _, key := range keys {
+			until := ""
+			if key.VerifyUntil != nil && key.Status == models.SigningKeyVerify {
+				until = "until " + key.VerifyUntil.Format("2006-01-02 15:04")
+			}
+			fmt.Printf("%-18s %-6s %-8s %s %s\n", key.Kid, key.Algorithm, key.Status, key.CreatedAt.Format("2006-01-02 15:04"), until)
+		}
+	}
+}
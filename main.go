@@ -35,8 +35,6 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/recover"
 	"github.com/gofiber/websocket/v2"
 	"github.com/redis/go-redis/v9"
-
-	"github.com/golang-jwt/jwt/v4"
 )
 
 var rdb *redis.Client
@@ -95,6 +93,15 @@ func main() {
 
 	rdb, _ := database.ConnectRedis(cfg.Redis)
 
+	// cache the Flags arrays read by the feature flags
+	services.UseFlagCache(rdb)
+
+	// remember the revoked access tokens until they expire
+	services.UseSessionCache(rdb)
+
+	// reject the nonces of signed requests already seen by any server
+	services.UseNonceCache(rdb)
+
 	if !fiber.IsChild() {
 		//fmt.Println("I'm a parent process")
 
@@ -105,13 +112,23 @@ func main() {
 
 	db := database.InitDatabase(rdb, cfg.Database)
 
+	// the keys that sign the access tokens, read again by every prefork child when rotated
+	if err := services.UseSigningKeys(db); err != nil {
+		fmt.Println(err)
+	}
+
+	// the address rules of the countries loaded into the reference geography
+	if err := services.RegisterGeographyRules(db); err != nil {
+		fmt.Println(err)
+	}
+
 	app.Use(recover.New())
 
 	if database.GetParam("DEV_MODE") == "true" {
 		app.Use(cors.New(cors.Config{
 			AllowCredentials: true,
 			AllowOrigins:     "*",
-			AllowHeaders:     "Origin, Content-Type, Accept",
+			AllowHeaders:     "Origin, Content-Type, Accept, X-Signature-Version, X-Signature, X-Signature-Nonce, X-Signature-Timestamp",
 		}))
 	}
 
@@ -333,36 +350,9 @@ func setupPublic(app *fiber.App, db *gorm.DB) {
 
 func userFromCookie(db *gorm.DB, c *fiber.Ctx) error {
 
-	cookie := c.Cookies(database.GetParam("jwt_cookie"))
-
-	//fmt.Println("userFromCookie", cookie)
-
-	if cookie != "" {
-
-		token, err := jwt.Parse(cookie, func(token *jwt.Token) (interface{}, error) {
-			return []byte(database.GetParam("jwt_secret")), nil
-		})
-
-		if err != nil {
-			return c.Next()
-		}
-
-		//fmt.Println("userFromCookie", token.Claims)
-		claims := token.Claims.(jwt.MapClaims)
-		id := claims["user_id"]
-
-		// set the user in the context
-		var result models.User
-		if err := services.UserForId(db, id, &result); err != nil {
-			return c.Next()
-		}
-
-		//fmt.Println("setUserFromToken", result.ID, result.Name)
-		c.Locals("currentUser", result)
-
-		// recreate the token and set it in the cookie
-		services.SetupJWTtoken(result, c)
-	}
+	// sets the user in the context when the access token is valid and not revoked,
+	// or after refreshing it with the refresh token cookie
+	services.SessionUser(db, c)
 
 	return c.Next()
 }
@@ -0,0 +1,52 @@
+package services
+
+import (
+	"myproject/api/models"
+	"testing"
+)
+
+func TestConfigValue(t *testing.T) {
+	business := models.Business{Configs: []models.Config{
+		{BusinessId: 1, Name: "show_prices", Value: "false"},
+		{BusinessId: 1, Name: "visit_minutes", Value: "45"},
+		{BusinessId: 1, Name: "booking_lead_minutes", Value: "soon"},
+		{BusinessId: 1, LocationId: 2, Name: "visit_minutes", Value: "90"},
+		{BusinessId: 1, Name: "legacy", Value: "on"},
+	}}
+
+	t.Run("Business", func(t *testing.T) {
+		if business.ConfigBool("show_prices", true) {
+			t.Errorf("show_prices: expected the business value false")
+		}
+		if v := business.ConfigString("visit_minutes", "0"); v != "45" {
+			t.Errorf("visit_minutes: expected the business value 45 not the location value, got %s", v)
+		}
+		if v := business.ConfigString("booking_lead_minutes", "0"); v != "60" {
+			t.Errorf("booking_lead_minutes: expected the registry default 60 for a value that does not parse, got %s", v)
+		}
+		if !business.ConfigBool("accept_reviews", false) {
+			t.Errorf("accept_reviews: expected the registry default true")
+		}
+		if v := business.ConfigString("legacy", "off"); v != "on" {
+			t.Errorf("legacy: expected the value of an unregistered item, got %s", v)
+		}
+		if v := business.ConfigString("missing", "fallback"); v != "fallback" {
+			t.Errorf("missing: expected the default of an unregistered item, got %s", v)
+		}
+	})
+
+	t.Run("Location", func(t *testing.T) {
+		loc := models.Location{ID: 2, Business: &business, Configs: []models.Config{
+			{BusinessId: 1, LocationId: 2, Name: "visit_minutes", Value: "90"},
+		}}
+		if v := loc.ConfigString("visit_minutes", "0"); v != "90" {
+			t.Errorf("visit_minutes: expected the location value 90, got %s", v)
+		}
+		if v := loc.ConfigString("show_prices", "true"); v != "false" {
+			t.Errorf("show_prices: expected the business value false, got %s", v)
+		}
+		if v := (models.Location{}).ConfigString("geofence_metres", "0"); v != "100" {
+			t.Errorf("geofence_metres: expected the registry default 100, got %s", v)
+		}
+	})
+}
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"myproject/api/database"
+	"myproject/api/services"
+	"myproject/test"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignature(t *testing.T) {
+	key := "7d7cbe48-358c-4750-b6fd-301164fe971c"
+	body := []byte(`{"signerId":3,"name":"Ajena"}`)
+	now := time.Now()
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	nonce := uuid.NewString()
+
+	signature := services.SignRequest(key, 3, "POST", "/api/v1/business/7?x=1", body, timestamp, nonce)
+
+	verify := func(key string, userId uint, method, path string, body []byte, timestamp, signature string, now time.Time) error {
+		return services.VerifySignature(key, userId, method, path, body, timestamp, nonce, signature, now)
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		assert.Nil(t, verify(key, 3, "POST", "/api/v1/business/7?x=1", body, timestamp, signature, now))
+		assert.Nil(t, verify(key, 3, "POST", "/api/v1/business/7?x=1", body, timestamp, signature, now.Add(4*time.Minute)))
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		assert.Equal(t, services.ErrSignatureMismatch, verify("another-key", 3, "POST", "/api/v1/business/7?x=1", body, timestamp, signature, now))
+		assert.Equal(t, services.ErrSignatureMismatch, verify(key, 4, "POST", "/api/v1/business/7?x=1", body, timestamp, signature, now))
+		assert.Equal(t, services.ErrSignatureMismatch, verify(key, 3, "PUT", "/api/v1/business/7?x=1", body, timestamp, signature, now))
+		assert.Equal(t, services.ErrSignatureMismatch, verify(key, 3, "POST", "/api/v1/business/8?x=1", body, timestamp, signature, now))
+		assert.Equal(t, services.ErrSignatureMismatch, verify(key, 3, "POST", "/api/v1/business/7?x=1", []byte(`{"signerId":3,"name":"Other"}`), timestamp, signature, now))
+
+		later := strconv.FormatInt(now.Unix()+1, 10)
+		assert.Equal(t, services.ErrSignatureMismatch, verify(key, 3, "POST", "/api/v1/business/7?x=1", body, later, signature, now))
+	})
+
+	t.Run("Clock skew", func(t *testing.T) {
+		assert.Equal(t, services.ErrSignatureExpired, verify(key, 3, "POST", "/api/v1/business/7?x=1", body, timestamp, signature, now.Add(6*time.Minute)))
+		assert.Equal(t, services.ErrSignatureExpired, verify(key, 3, "POST", "/api/v1/business/7?x=1", body, timestamp, signature, now.Add(-6*time.Minute)))
+
+		database.SystemParams["signature_skew_seconds"] = "60"
+		defer delete(database.SystemParams, "signature_skew_seconds")
+		assert.Equal(t, services.ErrSignatureExpired, verify(key, 3, "POST", "/api/v1/business/7?x=1", body, timestamp, signature, now.Add(2*time.Minute)))
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		assert.Equal(t, services.ErrSignatureMissing, verify(key, 3, "POST", "/api/v1/business/7?x=1", body, timestamp, "", now))
+		assert.Equal(t, services.ErrSignatureMissing, verify(key, 3, "POST", "/api/v1/business/7?x=1", body, "yesterday", signature, now))
+	})
+
+	t.Run("Nonce replay", func(t *testing.T) {
+		assert.Nil(t, services.ClaimNonce(3, nonce))
+		assert.Equal(t, services.ErrNonceReused, services.ClaimNonce(3, nonce))
+
+		// nonces are per user
+		assert.Nil(t, services.ClaimNonce(4, nonce))
+	})
+	t.Run("App", func(t *testing.T) {
+		database.SystemParams["app_signing_key"] = "app-key"
+		defer delete(database.SystemParams, "app_signing_key")
+
+		app := fiber.New()
+		app.Post("/api/v1/user/new_user", func(c *fiber.Ctx) error {
+			if _, err := services.VerifyToken(c, "text-"+c.Query("nonce"), c.Query("nonce"), c.Query("signature")); err != nil {
+				return c.Status(503).SendString(err.Error())
+			}
+			return c.SendString("ok")
+		})
+
+		send := func(path string, body []byte, headers map[string]string) int {
+			req := httptest.NewRequest("POST", path, bytes.NewReader(body))
+			for name, value := range headers {
+				req.Header.Set(name, value)
+			}
+			resp, err := app.Test(req)
+			assert.Nil(t, err)
+			return resp.StatusCode
+		}
+		signed := func(path string, body []byte) map[string]string {
+			timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+			nonce := uuid.NewString()
+			return map[string]string{
+				services.SignatureVersionHeader:   services.SignatureHmac,
+				services.SignatureTimestampHeader: timestamp,
+				services.SignatureNonceHeader:     nonce,
+				services.SignatureHeader:          services.SignRequest("app-key", 0, "POST", path, body, timestamp, nonce),
+			}
+		}
+
+		headers := signed("/api/v1/user/new_user", body)
+		assert.Equal(t, 200, send("/api/v1/user/new_user", body, headers))
+		assert.Equal(t, 503, send("/api/v1/user/new_user", body, headers), "a replayed request is rejected")
+
+		headers = signed("/api/v1/user/new_user", body)
+		assert.Equal(t, 503, send("/api/v1/user/new_user", []byte(`{"signerId":3,"name":"Other"}`), headers), "the body is signed")
+
+		// the legacy MD5 of the text with its nonce, accepted once until LEGACY_SIGNATURES is false
+		legacyNonce := uuid.NewString()
+		hash := md5.Sum([]byte("text-" + legacyNonce))
+		legacy := "/api/v1/user/new_user?nonce=" + legacyNonce + "&signature=" + hex.EncodeToString(hash[:])
+		assert.Equal(t, 200, send(legacy, body, nil))
+		assert.Equal(t, 503, send(legacy, body, nil), "a replayed legacy request is rejected")
+
+		legacyNonce = uuid.NewString()
+		hash = md5.Sum([]byte("text-" + legacyNonce))
+		legacy = "/api/v1/user/new_user?nonce=" + legacyNonce + "&signature=" + hex.EncodeToString(hash[:])
+		database.SystemParams["legacy_signatures"] = "false"
+		defer delete(database.SystemParams, "legacy_signatures")
+		assert.Equal(t, 503, send(legacy, body, nil))
+	})
+}
+
+func TestSignatureTenantRoute(t *testing.T) {
+	_, db, err := test.SetupTestApp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_MODE", "")
+	own, other := test.SetupTenants(db)
+
+	// the handler verifies the signature RequireTenant already verified, without claiming its nonce again
+	app := fiber.New()
+	app.Post("/api/v1/app/business/:id", services.RequireTenant(db, services.TenantFromParam("id")), func(c *fiber.Ctx) error {
+		user, err := services.VerifyFormSignature(db, c)
+		if err != nil {
+			return c.Status(503).SendString(err.Error())
+		}
+		return c.SendString(strconv.Itoa(int(user.ID)))
+	})
+
+	key := "7d7cbe48-358c-4750-b6fd-301164fe971c"
+	body := []byte(`{"signerId":3}`)
+	send := func(path string, nonce string) int {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req := httptest.NewRequest("POST", path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(services.SignatureVersionHeader, services.SignatureHmac)
+		req.Header.Set(services.SignatureTimestampHeader, timestamp)
+		req.Header.Set(services.SignatureNonceHeader, nonce)
+		req.Header.Set(services.SignatureHeader, services.SignRequest(key, 3, "POST", path, body, timestamp, nonce))
+		resp, err := app.Test(req)
+		assert.Nil(t, err)
+		return resp.StatusCode
+	}
+
+	path := "/api/v1/app/business/" + strconv.Itoa(int(own))
+	nonce := uuid.NewString()
+	assert.Equal(t, 200, send(path, nonce))
+	assert.Equal(t, 401, send(path, nonce), "a replayed request is rejected")
+
+	assert.Equal(t, 403, send("/api/v1/app/business/"+strconv.Itoa(int(other)), uuid.NewString()))
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"myproject/api/database"
+	"myproject/api/models"
+	"myproject/api/services"
+	"myproject/test"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSigningKeys(t *testing.T) {
+	database.SystemParams["jwt_secret"] = "signing-keys-test-secret"
+	t.Cleanup(func() {
+		services.SetSigningKeys(nil)
+		delete(database.SystemParams, "jwt_hs256")
+	})
+	user := models.User{ID: 3}
+
+	kidOf := func(token string) string {
+		parsed, _, err := new(jwt.Parser).ParseUnverified(token, jwt.MapClaims{})
+		assert.Nil(t, err)
+		kid, _ := parsed.Header["kid"].(string)
+		return kid
+	}
+
+	sign := func() string {
+		token, _, err := services.CreateJWTToken(user, 7, time.Now().Add(time.Minute))
+		assert.Nil(t, err)
+		return token
+	}
+
+	rsaKey, err := services.NewSigningKey(services.AlgorithmRS256)
+	assert.Nil(t, err)
+	edKey, err := services.NewSigningKey(services.AlgorithmEdDSA)
+	assert.Nil(t, err)
+
+	_, err = services.NewSigningKey("HS256")
+	assert.Equal(t, services.ErrUnknownAlgorithm, err)
+
+	hs256 := sign()
+	assert.Equal(t, "", kidOf(hs256))
+
+	t.Run("RS256", func(t *testing.T) {
+		assert.Nil(t, services.SetSigningKeys([]models.SigningKey{rsaKey}))
+
+		token := sign()
+		assert.Equal(t, rsaKey.Kid, kidOf(token))
+		assert.True(t, strings.HasPrefix(rsaKey.PublicKey, "-----BEGIN PUBLIC KEY-----"))
+
+		claims, err := services.ParseAccessToken(token)
+		assert.Nil(t, err)
+		assert.Equal(t, uint(3), claims.UserId)
+	})
+
+	t.Run("Rotation", func(t *testing.T) {
+		old := sign()
+
+		replaced := rsaKey
+		replaced.Status = models.SigningKeyVerify
+		until := time.Now().Add(time.Hour)
+		replaced.VerifyUntil = &until
+		assert.Nil(t, services.SetSigningKeys([]models.SigningKey{edKey, replaced}))
+
+		token := sign()
+		assert.Equal(t, edKey.Kid, kidOf(token))
+		_, err := services.ParseAccessToken(token)
+		assert.Nil(t, err)
+
+		// the tokens of the replaced key are still valid during the overlap
+		_, err = services.ParseAccessToken(old)
+		assert.Nil(t, err)
+
+		jwks := services.Jwks()["keys"].([]fiber.Map)
+		assert.Len(t, jwks, 2)
+		assert.Equal(t, edKey.Kid, jwks[0]["kid"])
+		assert.Equal(t, "OKP", jwks[0]["kty"])
+		assert.Equal(t, "Ed25519", jwks[0]["crv"])
+		assert.Equal(t, "EdDSA", jwks[0]["alg"])
+		assert.Equal(t, rsaKey.Kid, jwks[1]["kid"])
+		assert.Equal(t, "RSA", jwks[1]["kty"])
+		assert.Equal(t, "AQAB", jwks[1]["e"])
+		assert.NotEmpty(t, jwks[1]["n"])
+
+		// after the overlap
+		expired := time.Now().Add(-time.Minute)
+		replaced.VerifyUntil = &expired
+		assert.Nil(t, services.SetSigningKeys([]models.SigningKey{edKey, replaced}))
+		_, err = services.ParseAccessToken(old)
+		assert.NotNil(t, err)
+		assert.Len(t, services.Jwks()["keys"], 1)
+	})
+
+	t.Run("Algorithm confusion", func(t *testing.T) {
+		// an HS256 token with the kid of a public key
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"user_id": 3, "sid": 7})
+		token.Header["kid"] = edKey.Kid
+		forged, _ := token.SignedString([]byte(edKey.PublicKey))
+		_, err := services.ParseAccessToken(forged)
+		assert.NotNil(t, err)
+
+		token.Header["kid"] = "unknown"
+		forged, _ = token.SignedString([]byte("signing-keys-test-secret"))
+		_, err = services.ParseAccessToken(forged)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("Shared secret", func(t *testing.T) {
+		// not accepted once there is an active key
+		_, err := services.ParseAccessToken(hs256)
+		assert.NotNil(t, err)
+
+		services.SetSigningKeys(nil)
+		_, err = services.ParseAccessToken(hs256)
+		assert.Nil(t, err)
+
+		database.SystemParams["jwt_hs256"] = "false"
+		_, err = services.ParseAccessToken(hs256)
+		assert.NotNil(t, err)
+
+		_, _, err = services.CreateJWTToken(user, 7, time.Now().Add(time.Minute))
+		assert.Equal(t, services.ErrNoSigningKey, err)
+	})
+}
+
+func TestRotateSigningKey(t *testing.T) {
+	_, db, err := test.SetupTestApp()
+	if err != nil {
+		t.Fatalf("Failed to setup test app: %v", err)
+	}
+	var existing []uint
+	db.Model(&models.SigningKey{}).Pluck("id", &existing)
+	t.Cleanup(func() {
+		db.Where("id not in ?", append(existing, 0)).Delete(&models.SigningKey{})
+		services.SetSigningKeys(nil)
+	})
+
+	assert.Nil(t, services.UseSigningKeys(db))
+
+	first, err := services.RotateSigningKey(db, services.AlgorithmEdDSA)
+	assert.Nil(t, err)
+	token, _, err := services.CreateJWTToken(models.User{ID: 3}, 7, time.Now().Add(time.Minute))
+	assert.Nil(t, err)
+	second, err := services.RotateSigningKey(db, services.AlgorithmRS256)
+	assert.Nil(t, err)
+
+	// the replaced key verifies its tokens until it is retired
+	_, err = services.ParseAccessToken(token)
+	assert.Nil(t, err)
+
+	var replaced models.SigningKey
+	db.First(&replaced, "kid = ?", first.Kid)
+	assert.Equal(t, models.SigningKeyVerify, replaced.Status)
+	assert.NotNil(t, replaced.VerifyUntil)
+
+	assert.Equal(t, services.ErrRetireActiveKey, services.RetireSigningKey(db, second.Kid))
+	assert.Nil(t, services.RetireSigningKey(db, first.Kid))
+
+	db.First(&replaced, "kid = ?", first.Kid)
+	assert.Equal(t, models.SigningKeyRetired, replaced.Status)
+
+	_, err = services.ParseAccessToken(token)
+	assert.NotNil(t, err, "the keys are read again when one is retired")
+}
@@ -0,0 +1,159 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"myproject/api/models"
+	"myproject/api/services"
+	"myproject/test"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTotp(t *testing.T) {
+	// the SHA1 secret of RFC 6238, 12345678901234567890 in base32
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+	for seconds, expected := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	} {
+		code, err := services.TotpCode(secret, seconds/30)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, code, "time %d", seconds)
+	}
+
+	now := time.Unix(1234567890, 0)
+	step := now.Unix() / 30
+
+	t.Run("Codes of the next and previous steps are accepted", func(t *testing.T) {
+		for _, s := range []int64{step - 1, step, step + 1} {
+			code, _ := services.TotpCode(secret, s)
+			used, ok := services.VerifyTotp(secret, code, now, 0)
+			assert.True(t, ok)
+			assert.Equal(t, s, used)
+		}
+
+		code, _ := services.TotpCode(secret, step+2)
+		_, ok := services.VerifyTotp(secret, code, now, 0)
+		assert.False(t, ok)
+	})
+
+	t.Run("A code is not accepted twice", func(t *testing.T) {
+		code, _ := services.TotpCode(secret, step)
+		used, ok := services.VerifyTotp(secret, code, now, 0)
+		assert.True(t, ok)
+
+		_, ok = services.VerifyTotp(secret, code, now, used)
+		assert.False(t, ok)
+	})
+
+	t.Run("The URI names the issuer and the account", func(t *testing.T) {
+		uri := services.TotpURI("jane@example.com", secret)
+		assert.True(t, strings.HasPrefix(uri, "otpauth://totp/myproject:jane@example.com?"))
+		assert.Contains(t, uri, "secret="+secret)
+		assert.Contains(t, uri, "issuer=myproject")
+	})
+
+	assert.Len(t, services.NewTotpSecret(), 32)
+}
+
+func TestTwoFactorChallenge(t *testing.T) {
+	_, db, err := test.SetupTestApp()
+	if err != nil {
+		t.Fatalf("Failed to setup test app: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Where("user_id = 3").Delete(&models.UserTwoFactor{})
+		db.Where("user_id = 3 and kind = ?", models.DataItemRecoveryCode).Delete(&models.DataItem{})
+	})
+
+	var user models.User
+	assert.Nil(t, services.UserForId(db, 3, &user))
+
+	enrolment, err := services.EnrolTwoFactor(db, user)
+	assert.Nil(t, err)
+	secret := enrolment["secret"].(string)
+	assert.True(t, strings.HasPrefix(enrolment["qr"].(string), "data:image/png;base64,"))
+	assert.False(t, services.TwoFactorEnabled(db, user.ID))
+
+	// the pending secret is shown again until it is confirmed
+	again, err := services.EnrolTwoFactor(db, user)
+	assert.Nil(t, err)
+	assert.Equal(t, secret, again["secret"])
+
+	step := time.Now().Unix() / 30
+	code, _ := services.TotpCode(secret, step-1)
+	codes, err := services.ConfirmTwoFactor(db, user.ID, code)
+	assert.Nil(t, err)
+	assert.Len(t, codes, 10)
+	assert.True(t, services.NeedsTwoFactor(db, user.ID))
+
+	_, err = services.EnrolTwoFactor(db, user)
+	assert.Equal(t, services.ErrTwoFactorEnabled, err)
+
+	t.Run("The challenge is answered with a TOTP code", func(t *testing.T) {
+		challenge, err := services.StartTwoFactorChallenge(db, user)
+		assert.Nil(t, err)
+		assert.Equal(t, false, challenge["enrol"])
+
+		// the code of the confirmation is used
+		_, _, err = services.CompleteTwoFactorChallenge(db, challenge["challenge"].(string), code)
+		assert.Equal(t, services.ErrInvalidTwoFactorCode, err)
+
+		next, _ := services.TotpCode(secret, step)
+		loggedIn, _, err := services.CompleteTwoFactorChallenge(db, challenge["challenge"].(string), next)
+		assert.Nil(t, err)
+		assert.Equal(t, user.ID, loggedIn.ID)
+
+		// a challenge is answered once
+		_, _, err = services.CompleteTwoFactorChallenge(db, challenge["challenge"].(string), next)
+		assert.Equal(t, services.ErrInvalidChallenge, err)
+	})
+
+	t.Run("A recovery code is used once", func(t *testing.T) {
+		challenge, _ := services.StartTwoFactorChallenge(db, user)
+		_, _, err := services.CompleteTwoFactorChallenge(db, challenge["challenge"].(string), codes[0])
+		assert.Nil(t, err)
+
+		challenge, _ = services.StartTwoFactorChallenge(db, user)
+		_, _, err = services.CompleteTwoFactorChallenge(db, challenge["challenge"].(string), codes[0])
+		assert.Equal(t, services.ErrInvalidTwoFactorCode, err)
+	})
+
+	t.Run("Invalid codes lock the user out across challenges", func(t *testing.T) {
+		// with the invalid recovery code above
+		for i := 0; i < 9; i++ {
+			// a new challenge does not reset the count
+			challenge, err := services.StartTwoFactorChallenge(db, user)
+			assert.Nil(t, err)
+			_, _, err = services.CompleteTwoFactorChallenge(db, challenge["challenge"].(string), "abcdef")
+			assert.Equal(t, services.ErrInvalidTwoFactorCode, err)
+		}
+
+		_, err := services.StartTwoFactorChallenge(db, user)
+		assert.Equal(t, services.ErrTwoFactorLocked, err)
+
+		db.Model(&models.UserTwoFactor{}).Where("user_id = ?", user.ID).Update("locked_until", nil)
+		challenge, err := services.StartTwoFactorChallenge(db, user)
+		assert.Nil(t, err)
+
+		// the attempts of a challenge are limited
+		for i := 0; i < 5; i++ {
+			_, _, err = services.CompleteTwoFactorChallenge(db, challenge["challenge"].(string), "abcdef")
+			assert.Equal(t, services.ErrInvalidTwoFactorCode, err)
+		}
+		_, _, err = services.CompleteTwoFactorChallenge(db, challenge["challenge"].(string), "abcdef")
+		assert.Equal(t, services.ErrInvalidChallenge, err)
+		db.Model(&models.UserTwoFactor{}).Where("user_id = ?", user.ID).Update("failed_attempts", 0)
+	})
+
+	t.Run("Disable with a recovery code", func(t *testing.T) {
+		assert.Nil(t, services.DisableTwoFactor(db, user.ID, codes[1]))
+		assert.False(t, services.NeedsTwoFactor(db, user.ID))
+	})
+}
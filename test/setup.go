@@ -80,7 +80,7 @@ func SetupTestApp() (*fiber.App, *gorm.DB, error) {
 		app.Use(cors.New(cors.Config{
 			AllowCredentials: true,
 			AllowOrigins:     "http://localhost:8080, http://localhost:4000, http://localhost:3000, http://localhost:5173",
-			AllowHeaders:     "Origin, Content-Type, Accept",
+			AllowHeaders:     "Origin, Content-Type, Accept, X-Signature-Version, X-Signature, X-Signature-Nonce, X-Signature-Timestamp",
 		}))
 	}
 
@@ -166,6 +166,20 @@ func SignMap(mapData map[string]interface{}) {
 	mapData["nonce"] = nonce
 }
 
+// SetupTenants creates a business owned by the test user ID:3 and a business
+// owned by another user, used to check data does not leak between businesses.
+// It returns the IDs of both businesses
+func SetupTenants(db *gorm.DB) (uint, uint) {
+	rand, _ := uuid.NewRandom()
+
+	var own, otherUser, other uint
+	db.Raw("insert into businesses (name, user_id) values (?, 3) returning id", "Tenant "+rand.String()).Scan(&own)
+	db.Raw("insert into users (name, email) values (?, ?) returning id", "Tenant "+rand.String(), rand.String()+"@dummy.com").Scan(&otherUser)
+	db.Raw("insert into businesses (name, user_id) values (?, ?) returning id", "Other tenant "+rand.String(), otherUser).Scan(&other)
+
+	return own, other
+}
+
 func ConvertHTTPRequestToFastHTTPRequest(httpReq *http.Request) (*fasthttp.Request, error) {
 	fastReq := fasthttp.AcquireRequest()
 	fastReq.Header.SetMethod(httpReq.Method)